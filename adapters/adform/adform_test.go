@@ -203,7 +203,7 @@ func preparePrebidRequest(serverUrl string, t *testing.T) *pbs.PBSRequest {
 	r, err := pbs.ParsePBSRequest(prebidHttpRequest, &config.AuctionTimeouts{
 		Default: 2000,
 		Max:     2000,
-	}, cacheClient, &config.HostCookie{})
+	}, cacheClient, &config.HostCookie{}, nil)
 	if err != nil {
 		t.Fatalf("ParsePBSRequest failed: %v", err)
 	}
@@ -372,7 +372,7 @@ func TestAppNexusBasicResponse(t *testing.T) {
 	pbReq, err := pbs.ParsePBSRequest(req, &config.AuctionTimeouts{
 		Default: 2000,
 		Max:     2000,
-	}, cacheClient, &hcc)
+	}, cacheClient, &hcc, nil)
 	if err != nil {
 		t.Fatalf("ParsePBSRequest failed: %v", err)
 	}
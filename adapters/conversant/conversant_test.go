@@ -618,7 +618,7 @@ func ParseRequest(req *pbs.PBSRequest) (*pbs.PBSRequest, error) {
 	parsedReq, err := pbs.ParsePBSRequest(httpReq, &config.AuctionTimeouts{
 		Default: 2000,
 		Max:     2000,
-	}, cache, &hcc)
+	}, cache, &hcc, nil)
 
 	return parsedReq, err
 }
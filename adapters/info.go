@@ -6,6 +6,7 @@ import (
 
 	"github.com/golang/glog"
 	"github.com/mxmCherry/openrtb"
+	"github.com/prebid/prebid-server/errortypes"
 	"github.com/prebid/prebid-server/openrtb_ext"
 	yaml "gopkg.in/yaml.v2"
 )
@@ -20,6 +21,8 @@ import (
 //      to nil before the request is forwarded to the delegate.
 //   3. Any Imps which have no MediaTypes left will be removed.
 //   4. If there are no valid Imps left, the delegate won't be called at all.
+//
+// Each Imp removed in step 3 is reported with an UnsupportedImpError.
 func EnforceBidderInfo(bidder Bidder, info BidderInfo) Bidder {
 	return &InfoAwareBidder{
 		Bidder: bidder,
@@ -32,6 +35,13 @@ type InfoAwareBidder struct {
 	info parsedBidderInfo
 }
 
+// UnsupportedImpError is returned by an InfoAwareBidder for each Imp which it removed from the request,
+// because none of the Imp's MediaTypes are supported by the Bidder. It's a BadInput error otherwise.
+type UnsupportedImpError struct {
+	errortypes.BadInput
+	ImpID string
+}
+
 func (i *InfoAwareBidder) MakeRequests(request *openrtb.BidRequest) ([]*RequestData, []error) {
 	var allowedMediaTypes parsedSupports
 	if request.Site != nil {
@@ -118,7 +128,10 @@ func (i *InfoAwareBidder) filterImps(imps []openrtb.Imp, numToFilter int) ([]ope
 		if hasAnyTypes(&imps[i]) {
 			newImps = append(newImps, imps[i])
 		} else {
-			errs = append(errs, BadInput(fmt.Sprintf("request.imp[%d] has no supported MediaTypes. It will be ignored", i)))
+			errs = append(errs, &UnsupportedImpError{
+				BadInput: errortypes.BadInput{Message: fmt.Sprintf("request.imp[%d] has no supported MediaTypes. It will be ignored", i)},
+				ImpID:    imps[i].ID,
+			})
 		}
 	}
 	return newImps, errs
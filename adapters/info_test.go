@@ -74,6 +74,7 @@ func TestImpFiltering(t *testing.T) {
 				Video: &openrtb.Video{},
 			},
 			{
+				ID:     "native-imp",
 				Native: &openrtb.Native{},
 			},
 			{
@@ -82,6 +83,7 @@ func TestImpFiltering(t *testing.T) {
 				Native: &openrtb.Native{},
 			},
 			{
+				ID:     "banner-imp",
 				Banner: &openrtb.Banner{},
 			},
 		},
@@ -99,8 +101,15 @@ func TestImpFiltering(t *testing.T) {
 	assert.IsType(t, &errortypes.BadInput{}, errs[0])
 	assert.IsType(t, &errortypes.BadInput{}, errs[1])
 	assert.IsType(t, &errortypes.BadInput{}, errs[2])
-	assert.IsType(t, &errortypes.BadInput{}, errs[3])
-	assert.IsType(t, &errortypes.BadInput{}, errs[4])
+	assert.Equal(t, &adapters.UnsupportedImpError{
+		BadInput: errortypes.BadInput{Message: "request.imp[1] has no supported MediaTypes. It will be ignored"},
+		ImpID:    "native-imp",
+	}, errs[3])
+	assert.Equal(t, &adapters.UnsupportedImpError{
+		BadInput: errortypes.BadInput{Message: "request.imp[3] has no supported MediaTypes. It will be ignored"},
+		ImpID:    "banner-imp",
+	}, errs[4])
+	assert.Equal(t, errortypes.BadInputCode, errortypes.DecodeError(errs[3]))
 
 	req := bidder.gotRequest
 	if !assert.Len(t, req.Imp, 2) {
@@ -235,7 +235,7 @@ func TestLifestreetBasicResponse(t *testing.T) {
 	pbReq, err := pbs.ParsePBSRequest(req, &config.AuctionTimeouts{
 		Default: 2000,
 		Max:     2000,
-	}, cacheClient, &hcc)
+	}, cacheClient, &hcc, nil)
 	if err != nil {
 		t.Fatalf("ParsePBSRequest failed: %v", err)
 	}
@@ -638,7 +638,7 @@ func TestPubmaticSampleRequest(t *testing.T) {
 	_, err = pbs.ParsePBSRequest(httpReq, &config.AuctionTimeouts{
 		Default: 2000,
 		Max:     2000,
-	}, cacheClient, &hcc)
+	}, cacheClient, &hcc, nil)
 	if err != nil {
 		t.Fatalf("Error when parsing request: %v", err)
 	}
@@ -0,0 +1,193 @@
+package config
+
+import (
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prebid/prebid-server/analytics"
+	"github.com/prebid/prebid-server/config"
+	"github.com/prebid/prebid-server/pbsmetrics"
+)
+
+// NewAsyncPBSAnalytics is like NewPBSAnalytics, but the endpoints never wait for the modules.
+//
+// Each module gets a bounded queue of events, and a pool of workers which log them. If a module's queue is full,
+// its new events are dropped and recorded in the metricsEngine. Events are sampled using the rates in cfg.Sampling
+// before they're queued. Since the workers run concurrently, modules must be safe for concurrent use, and must
+// not modify the objects they're given.
+//
+// The shutdown function waits for the modules to log their queued events, and then closes any which implement io.Closer.
+func NewAsyncPBSAnalytics(cfg *config.Analytics, metricsEngine pbsmetrics.MetricsEngine) (module analytics.PBSAnalyticsModule, shutdown func()) {
+	return newAsyncAnalytics(newModules(cfg, metricsEngine), cfg.Async, cfg.Sampling, metricsEngine)
+}
+
+func newAsyncAnalytics(modules []namedModule, cfg config.AnalyticsAsync, sampling config.AnalyticsSampling, metricsEngine pbsmetrics.MetricsEngine) (*asyncAnalytics, func()) {
+	a := &asyncAnalytics{
+		modules:  make([]*asyncModule, len(modules)),
+		sampling: sampling,
+		sample:   defaultSample,
+	}
+	for i, module := range modules {
+		a.modules[i] = newAsyncModule(module, cfg.Workers, cfg.QueueSize, metricsEngine)
+	}
+	return a, func() {
+		a.shutdown(cfg.ShutdownTimeoutDuration())
+	}
+}
+
+// asyncAnalytics implements the PBSAnalyticsModule interface by queueing the events for each module.
+type asyncAnalytics struct {
+	modules  []*asyncModule
+	sampling config.AnalyticsSampling
+	// sample returns true if an event should be logged, given the sample rate for its type.
+	sample func(rate float64) bool
+}
+
+func defaultSample(rate float64) bool {
+	return rate >= 1 || rand.Float64() < rate
+}
+
+func (a *asyncAnalytics) LogAuctionObject(ao *analytics.AuctionObject) {
+	if !a.sample(a.sampling.Auction) {
+		return
+	}
+	for _, m := range a.modules {
+		module := m.module
+		m.enqueue(pbsmetrics.AnalyticsEventAuction, func() { module.LogAuctionObject(ao) })
+	}
+}
+
+func (a *asyncAnalytics) LogAmpObject(ao *analytics.AmpObject) {
+	if !a.sample(a.sampling.Amp) {
+		return
+	}
+	for _, m := range a.modules {
+		module := m.module
+		m.enqueue(pbsmetrics.AnalyticsEventAmp, func() { module.LogAmpObject(ao) })
+	}
+}
+
+func (a *asyncAnalytics) LogCookieSyncObject(cso *analytics.CookieSyncObject) {
+	if !a.sample(a.sampling.CookieSync) {
+		return
+	}
+	for _, m := range a.modules {
+		module := m.module
+		m.enqueue(pbsmetrics.AnalyticsEventCookieSync, func() { module.LogCookieSyncObject(cso) })
+	}
+}
+
+func (a *asyncAnalytics) LogSetUIDObject(so *analytics.SetUIDObject) {
+	if !a.sample(a.sampling.SetUID) {
+		return
+	}
+	for _, m := range a.modules {
+		module := m.module
+		m.enqueue(pbsmetrics.AnalyticsEventSetUID, func() { module.LogSetUIDObject(so) })
+	}
+}
+
+func (a *asyncAnalytics) LogEventObject(eo *analytics.EventObject) {
+	if !a.sample(a.sampling.Event) {
+		return
+	}
+	for _, m := range a.modules {
+		module := m.module
+		m.enqueue(pbsmetrics.AnalyticsEventNotify, func() { module.LogEventObject(eo) })
+	}
+}
+
+// shutdown stops the modules concurrently, so that a slow one doesn't use up the others' time.
+func (a *asyncAnalytics) shutdown(timeout time.Duration) {
+	var wg sync.WaitGroup
+	wg.Add(len(a.modules))
+	for _, m := range a.modules {
+		go func(m *asyncModule) {
+			m.shutdown(timeout)
+			wg.Done()
+		}(m)
+	}
+	wg.Wait()
+}
+
+func newAsyncModule(module namedModule, workers int, queueSize int, metricsEngine pbsmetrics.MetricsEngine) *asyncModule {
+	if workers < 1 {
+		workers = 1
+	}
+	m := &asyncModule{
+		namedModule:   module,
+		metricsEngine: metricsEngine,
+		queue:         make(chan func(), queueSize),
+		done:          make(chan struct{}),
+	}
+	m.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go m.work()
+	}
+	go func() {
+		m.workers.Wait()
+		close(m.done)
+	}()
+	return m
+}
+
+type asyncModule struct {
+	namedModule
+	metricsEngine pbsmetrics.MetricsEngine
+	queue         chan func()
+	workers       sync.WaitGroup
+	// done is closed once the workers have logged everything in the queue.
+	done chan struct{}
+
+	// closed prevents sends to the queue after it's been closed.
+	closed     bool
+	closeMutex sync.RWMutex
+}
+
+func (m *asyncModule) enqueue(eventType pbsmetrics.AnalyticsEventType, log func()) {
+	m.closeMutex.RLock()
+	defer m.closeMutex.RUnlock()
+	if m.closed {
+		m.metricsEngine.RecordAnalyticsEventDropped(m.name, eventType)
+		return
+	}
+
+	select {
+	case m.queue <- log:
+	default:
+		m.metricsEngine.RecordAnalyticsEventDropped(m.name, eventType)
+	}
+}
+
+func (m *asyncModule) work() {
+	defer m.workers.Done()
+	for log := range m.queue {
+		log()
+	}
+}
+
+// shutdown waits for the workers to log the queued events, and then closes the module if it can be closed.
+func (m *asyncModule) shutdown(timeout time.Duration) {
+	m.closeMutex.Lock()
+	if !m.closed {
+		m.closed = true
+		close(m.queue)
+	}
+	m.closeMutex.Unlock()
+
+	select {
+	case <-m.done:
+	case <-time.After(timeout):
+		glog.Errorf("The %s analytics module didn't log its queued events within %v. Some will be lost.", m.name, timeout)
+		return
+	}
+
+	if closer, ok := m.module.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			glog.Errorf("Failed to close the %s analytics module: %v", m.name, err)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package config
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prebid/prebid-server/analytics"
+	"github.com/prebid/prebid-server/config"
+	"github.com/prebid/prebid-server/pbsmetrics"
+	"github.com/rcrowley/go-metrics"
+)
+
+var sampleAll = config.AnalyticsSampling{
+	Auction:    1,
+	Amp:        1,
+	CookieSync: 1,
+	SetUID:     1,
+	Event:      1,
+}
+
+func TestAsyncLogsToEveryModule(t *testing.T) {
+	file, stream := newRecordingModule(), newRecordingModule()
+	module, shutdown := newAsyncAnalytics([]namedModule{
+		{pbsmetrics.AnalyticsModuleFile, file},
+		{pbsmetrics.AnalyticsModuleStream, stream},
+	}, config.AnalyticsAsync{Workers: 2, QueueSize: 10, ShutdownTimeout: 1000}, sampleAll, newTestMetrics())
+
+	module.LogAuctionObject(&analytics.AuctionObject{})
+	module.LogAmpObject(&analytics.AmpObject{})
+	module.LogCookieSyncObject(&analytics.CookieSyncObject{})
+	module.LogSetUIDObject(&analytics.SetUIDObject{})
+	module.LogEventObject(&analytics.EventObject{})
+	shutdown()
+
+	if file.count() != 5 || stream.count() != 5 {
+		t.Errorf("Every module should log every event. Got %d and %d", file.count(), stream.count())
+	}
+}
+
+func TestAsyncDropsWhenQueueFull(t *testing.T) {
+	blocked := newRecordingModule()
+	blocked.release = make(chan struct{})
+	metricsEngine := newTestMetrics()
+	module, shutdown := newAsyncAnalytics([]namedModule{
+		{pbsmetrics.AnalyticsModuleStream, blocked},
+	}, config.AnalyticsAsync{Workers: 1, QueueSize: 1, ShutdownTimeout: 1000}, sampleAll, metricsEngine)
+
+	// The worker blocks on the first event, the second one waits in the queue, and the third doesn't fit.
+	module.LogAuctionObject(&analytics.AuctionObject{})
+	<-blocked.started
+	module.LogAuctionObject(&analytics.AuctionObject{})
+	module.LogAuctionObject(&analytics.AuctionObject{})
+
+	if drops := metricsEngine.AnalyticsDropMeters[pbsmetrics.AnalyticsModuleStream][pbsmetrics.AnalyticsEventAuction].Count(); drops != 1 {
+		t.Errorf("Events should be dropped when the queue is full. Got %d drops", drops)
+	}
+
+	close(blocked.release)
+	shutdown()
+	if blocked.count() != 2 {
+		t.Errorf("The queued events should be logged before shutdown returns. Got %d", blocked.count())
+	}
+}
+
+func TestAsyncSampling(t *testing.T) {
+	recorder := newRecordingModule()
+	module, shutdown := newAsyncAnalytics([]namedModule{
+		{pbsmetrics.AnalyticsModuleFile, recorder},
+	}, config.AnalyticsAsync{Workers: 1, QueueSize: 10, ShutdownTimeout: 1000}, config.AnalyticsSampling{Amp: 1}, newTestMetrics())
+
+	module.LogAuctionObject(&analytics.AuctionObject{})
+	module.LogAmpObject(&analytics.AmpObject{})
+	module.LogAmpObject(&analytics.AmpObject{})
+	shutdown()
+
+	if recorder.count() != 2 {
+		t.Errorf("Auction events should be sampled out, and AMP events should be kept. Got %d events", recorder.count())
+	}
+}
+
+func TestAsyncShutdown(t *testing.T) {
+	recorder := newRecordingModule()
+	metricsEngine := newTestMetrics()
+	module, shutdown := newAsyncAnalytics([]namedModule{
+		{pbsmetrics.AnalyticsModuleFile, recorder},
+	}, config.AnalyticsAsync{Workers: 1, QueueSize: 10, ShutdownTimeout: 1000}, sampleAll, metricsEngine)
+
+	shutdown()
+	if !recorder.isClosed() {
+		t.Errorf("Modules should be closed on shutdown.")
+	}
+
+	module.LogSetUIDObject(&analytics.SetUIDObject{})
+	if drops := metricsEngine.AnalyticsDropMeters[pbsmetrics.AnalyticsModuleFile][pbsmetrics.AnalyticsEventSetUID].Count(); drops != 1 {
+		t.Errorf("Events logged after shutdown should be dropped. Got %d drops", drops)
+	}
+	shutdown()
+}
+
+func TestAsyncShutdownTimeout(t *testing.T) {
+	blocked := newRecordingModule()
+	blocked.release = make(chan struct{})
+	defer close(blocked.release)
+	module, shutdown := newAsyncAnalytics([]namedModule{
+		{pbsmetrics.AnalyticsModuleStream, blocked},
+	}, config.AnalyticsAsync{Workers: 1, QueueSize: 10, ShutdownTimeout: 10}, sampleAll, newTestMetrics())
+
+	module.LogAuctionObject(&analytics.AuctionObject{})
+	<-blocked.started
+
+	done := make(chan struct{})
+	go func() {
+		shutdown()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Shutdown should give up on modules which take longer than the timeout.")
+	}
+	if blocked.isClosed() {
+		t.Errorf("Modules which didn't finish logging shouldn't be closed.")
+	}
+}
+
+func newTestMetrics() *pbsmetrics.Metrics {
+	return pbsmetrics.NewMetrics(metrics.NewRegistry(), nil, config.AccountMetrics{})
+}
+
+// recordingModule counts the events it logs. If release is set, it blocks on each event until release is closed.
+type recordingModule struct {
+	mutex   sync.Mutex
+	events  int
+	closed  bool
+	started chan struct{}
+	release chan struct{}
+}
+
+func newRecordingModule() *recordingModule {
+	return &recordingModule{
+		started: make(chan struct{}, 1),
+	}
+}
+
+func (m *recordingModule) log() {
+	select {
+	case m.started <- struct{}{}:
+	default:
+	}
+	if m.release != nil {
+		<-m.release
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.events++
+}
+
+func (m *recordingModule) count() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.events
+}
+
+func (m *recordingModule) isClosed() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.closed
+}
+
+func (m *recordingModule) Close() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.closed = true
+	return nil
+}
+
+func (m *recordingModule) LogAuctionObject(ao *analytics.AuctionObject) { m.log() }
+
+func (m *recordingModule) LogCookieSyncObject(cso *analytics.CookieSyncObject) { m.log() }
+
+func (m *recordingModule) LogSetUIDObject(so *analytics.SetUIDObject) { m.log() }
+
+func (m *recordingModule) LogAmpObject(ao *analytics.AmpObject) { m.log() }
+
+func (m *recordingModule) LogEventObject(eo *analytics.EventObject) { m.log() }
@@ -4,19 +4,43 @@ import (
 	"github.com/golang/glog"
 	"github.com/prebid/prebid-server/analytics"
 	"github.com/prebid/prebid-server/analytics/filesystem"
+	"github.com/prebid/prebid-server/analytics/stream"
 	"github.com/prebid/prebid-server/config"
+	"github.com/prebid/prebid-server/pbsmetrics"
+	metricsConf "github.com/prebid/prebid-server/pbsmetrics/config"
 )
 
 //Modules that need to be logged to need to be initialized here
 func NewPBSAnalytics(analytics *config.Analytics) analytics.PBSAnalyticsModule {
 	modules := make(enabledAnalytics, 0)
+	for _, module := range newModules(analytics, &metricsConf.DummyMetricsEngine{}) {
+		modules = append(modules, module.module)
+	}
+	return modules
+}
+
+// namedModule pairs a module with the name which its metrics are recorded under.
+type namedModule struct {
+	name   pbsmetrics.AnalyticsModule
+	module analytics.PBSAnalyticsModule
+}
+
+func newModules(analytics *config.Analytics, metricsEngine pbsmetrics.MetricsEngine) []namedModule {
+	modules := make([]namedModule, 0)
 	if len(analytics.File.Filename) > 0 {
 		if mod, err := filesystem.NewFileLogger(analytics.File.Filename); err == nil {
-			modules = append(modules, mod)
+			modules = append(modules, namedModule{pbsmetrics.AnalyticsModuleFile, mod})
 		} else {
 			glog.Fatalf("Could not initialize FileLogger for file %v :%v", analytics.File.Filename, err)
 		}
 	}
+	if analytics.Stream.Enabled() {
+		if producer, err := stream.NewKafkaProducer(analytics.Stream.Kafka.Brokers, analytics.Stream.Kafka.Topic, analytics.Stream.Kafka.TimeoutDuration()); err == nil {
+			modules = append(modules, namedModule{pbsmetrics.AnalyticsModuleStream, stream.NewModule(producer, analytics.Stream.BufferSize, analytics.Stream.BatchSize, analytics.Stream.FlushIntervalDuration(), metricsEngine)})
+		} else {
+			glog.Fatalf("Could not connect to the Kafka brokers %v for stream analytics: %v", analytics.Stream.Kafka.Brokers, err)
+		}
+	}
 	return modules
 }
 
@@ -46,3 +70,9 @@ func (ea enabledAnalytics) LogAmpObject(ao *analytics.AmpObject) {
 		module.LogAmpObject(ao)
 	}
 }
+
+func (ea enabledAnalytics) LogEventObject(eo *analytics.EventObject) {
+	for _, module := range ea {
+		module.LogEventObject(eo)
+	}
+}
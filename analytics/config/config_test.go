@@ -45,6 +45,11 @@ func TestSampleModule(t *testing.T) {
 	if count != 4 {
 		t.Errorf("PBSAnalyticsModule failed at LogAmpObject")
 	}
+
+	am.LogEventObject(&analytics.EventObject{})
+	if count != 5 {
+		t.Errorf("PBSAnalyticsModule failed at LogEventObject")
+	}
 }
 
 type sampleModule struct {
@@ -59,6 +64,8 @@ func (m *sampleModule) LogSetUIDObject(so *analytics.SetUIDObject) { *m.count++
 
 func (m *sampleModule) LogAmpObject(ao *analytics.AmpObject) { *m.count++ }
 
+func (m *sampleModule) LogEventObject(eo *analytics.EventObject) { *m.count++ }
+
 func initAnalytics(count *int) analytics.PBSAnalyticsModule {
 	modules := make(enabledAnalytics, 0)
 	modules = append(modules, &sampleModule{count})
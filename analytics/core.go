@@ -2,6 +2,7 @@ package analytics
 
 import (
 	"github.com/mxmCherry/openrtb"
+	"github.com/prebid/prebid-server/openrtb_ext"
 	"github.com/prebid/prebid-server/usersync"
 )
 
@@ -10,7 +11,7 @@ import (
 
 	New modules can use the /analytics/endpoint_data_objects, extract the
 	information required and are responsible for handling all their logging activities inside LogAuctionObject, LogAmpObject
-	LogCookieSyncObject and LogSetUIDObject method implementations.
+	LogCookieSyncObject, LogSetUIDObject and LogEventObject method implementations.
 */
 
 type PBSAnalyticsModule interface {
@@ -18,6 +19,7 @@ type PBSAnalyticsModule interface {
 	LogCookieSyncObject(*CookieSyncObject)
 	LogSetUIDObject(*SetUIDObject)
 	LogAmpObject(*AmpObject)
+	LogEventObject(*EventObject)
 }
 
 //Loggable object of a transaction at /openrtb2/auction endpoint
@@ -26,6 +28,7 @@ type AuctionObject struct {
 	Errors   []error
 	Request  *openrtb.BidRequest
 	Response *openrtb.BidResponse
+	Details  *AuctionDetails
 }
 
 //Loggable object of a transaction at /openrtb2/amp endpoint
@@ -36,6 +39,7 @@ type AmpObject struct {
 	AuctionResponse    *openrtb.BidResponse
 	AmpTargetingValues map[string]string
 	Origin             string
+	Details            *AuctionDetails
 }
 
 //Loggable object of a transaction at /setuid
@@ -53,3 +57,55 @@ type CookieSyncObject struct {
 	Errors       []error
 	BidderStatus []*usersync.CookieSyncBidders
 }
+
+//Loggable object of a transaction at /event
+type EventObject struct {
+	Status int
+	Errors []error
+	// Type is "win" or "imp".
+	Type    string
+	BidID   string
+	Bidder  string
+	Account string
+	// AuctionTimestamp is the time of the auction which the bid came from, in milliseconds since the epoch.
+	// It's 0 if the URL didn't include one.
+	AuctionTimestamp int64
+}
+
+//Details of how the bidders took part in an auction. This is nil if the auction never ran.
+type AuctionDetails struct {
+	Bidders []*BidderResult
+}
+
+//Outcome of an auction for a single bidder
+type BidderResult struct {
+	Bidder string
+	// Request is the copy of the auction's BidRequest which was sent to this bidder.
+	Request            *openrtb.BidRequest
+	ResponseTimeMillis int
+	TimedOut           bool
+	Errors             []openrtb_ext.ExtBidderError
+	// Bids contains every bid the bidder made, including the ones which were rejected.
+	Bids []*BidRecord
+	// NonBids lists the imps which the bidder didn't get to bid on, and the bids which were rejected,
+	// in the same format as response.ext.seatnonbid.
+	NonBids []openrtb_ext.ExtNonBid
+}
+
+//Outcome of an auction for a single bid
+type BidRecord struct {
+	Seat  string
+	BidID string
+	ImpID string
+	Type  openrtb_ext.BidType
+	// OriginalPrice is the price the bidder offered. Price is the price after any bid adjustments.
+	OriginalPrice float64
+	Price         float64
+	DealID        string
+	// Won is true if this bid had the highest price on its imp.
+	Won         bool
+	CacheID     string
+	VastCacheID string
+	// Rejection is the reason the bid was removed from the auction. It's 0 if the bid wasn't rejected.
+	Rejection openrtb_ext.NonBidReason
+}
@@ -16,6 +16,7 @@ const (
 	AUCTION     RequestType = "/openrtb2/auction"
 	SETUID      RequestType = "/set_uid"
 	AMP         RequestType = "/openrtb2/amp"
+	EVENT       RequestType = "/event"
 )
 
 //Module that can perform transactional logging
@@ -62,6 +63,15 @@ func (f *FileLogger) LogAmpObject(ao *analytics.AmpObject) {
 	f.Logger.Flush()
 }
 
+//Logs EventObject to file
+func (f *FileLogger) LogEventObject(eo *analytics.EventObject) {
+	//Code to parse the object and log in a way required
+	var b bytes.Buffer
+	b.WriteString(jsonifyEventObject(eo))
+	f.Logger.Debug(b.String())
+	f.Logger.Flush()
+}
+
 //Method to initialize the analytic module
 func NewFileLogger(filename string) (analytics.PBSAnalyticsModule, error) {
 	options := glog.LogOptions{
@@ -149,3 +159,20 @@ func jsonifyAmpObject(ao *analytics.AmpObject) string {
 		return fmt.Sprintf("Transactional Logs Error: Amp object badly formed %v", err)
 	}
 }
+
+func jsonifyEventObject(eo *analytics.EventObject) string {
+	type alias analytics.EventObject
+	b, err := json.Marshal(&struct {
+		Type RequestType `json:"type"`
+		*alias
+	}{
+		Type:  EVENT,
+		alias: (*alias)(eo),
+	})
+
+	if err == nil {
+		return string(b)
+	} else {
+		return fmt.Sprintf("Transactional Logs Error: Event object badly formed %v", err)
+	}
+}
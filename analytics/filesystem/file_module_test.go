@@ -55,6 +55,17 @@ func TestCookieSyncObject_ToJson(t *testing.T) {
 	}
 }
 
+func TestEventObject_ToJson(t *testing.T) {
+	eo := &analytics.EventObject{
+		Status: http.StatusNoContent,
+		Type:   "win",
+		BidID:  "bid-id",
+	}
+	if eoJson := jsonifyEventObject(eo); strings.Contains(eoJson, "Transactional Logs Error") {
+		t.Fatalf("EventObject failed to convert to json")
+	}
+}
+
 func TestFileLogger_LogObjects(t *testing.T) {
 	if _, err := os.Stat(TEST_DIR); os.IsNotExist(err) {
 		if err = os.MkdirAll(TEST_DIR, 0755); err != nil {
@@ -67,6 +78,7 @@ func TestFileLogger_LogObjects(t *testing.T) {
 		fl.LogAmpObject(&analytics.AmpObject{})
 		fl.LogSetUIDObject(&analytics.SetUIDObject{})
 		fl.LogCookieSyncObject(&analytics.CookieSyncObject{})
+		fl.LogEventObject(&analytics.EventObject{})
 	} else {
 		t.Fatalf("Couldn't initialize file logger: %v", err)
 	}
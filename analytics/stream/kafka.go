@@ -0,0 +1,46 @@
+package stream
+
+import (
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// NewKafkaProducer makes a Producer which sends each batch of messages to a Kafka topic.
+func NewKafkaProducer(brokers []string, topic string, timeout time.Duration) (Producer, error) {
+	cfg := sarama.NewConfig()
+	cfg.Producer.RequiredAcks = sarama.WaitForLocal
+	cfg.Producer.Timeout = timeout
+	// The SyncProducer requires these. They let Send() report whether each message was delivered.
+	cfg.Producer.Return.Successes = true
+	cfg.Producer.Return.Errors = true
+
+	producer, err := sarama.NewSyncProducer(brokers, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &kafkaProducer{
+		producer: producer,
+		topic:    topic,
+	}, nil
+}
+
+type kafkaProducer struct {
+	producer sarama.SyncProducer
+	topic    string
+}
+
+func (p *kafkaProducer) Send(messages [][]byte) error {
+	kafkaMessages := make([]*sarama.ProducerMessage, len(messages))
+	for i, message := range messages {
+		kafkaMessages[i] = &sarama.ProducerMessage{
+			Topic: p.topic,
+			Value: sarama.ByteEncoder(message),
+		}
+	}
+	return p.producer.SendMessages(kafkaMessages)
+}
+
+func (p *kafkaProducer) Close() error {
+	return p.producer.Close()
+}
@@ -0,0 +1,211 @@
+package stream
+
+import (
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prebid/prebid-server/analytics"
+	"github.com/prebid/prebid-server/pbsmetrics"
+)
+
+// Producer sends batches of messages to a message broker.
+type Producer interface {
+	// Send delivers the messages. It returns an error if any of them weren't delivered.
+	// Implementations may keep the slice, so callers should not reuse it.
+	Send(messages [][]byte) error
+	// Close releases the connection to the broker.
+	Close() error
+}
+
+// NewModule makes a PBSAnalyticsModule which serializes each object into an Event, and sends them to the producer in batches.
+//
+// The request goroutines never wait on the producer. Events are buffered in memory, up to bufferSize of them,
+// and sent from a background goroutine whenever batchSize of them are waiting, or every flushInterval.
+// If the buffer is full, new Events are dropped instead. Dropped Events, and Events which the producer failed
+// to send, are recorded in the metricsEngine.
+//
+// Close() should be called to send the remaining Events when the module is no longer needed.
+func NewModule(producer Producer, bufferSize int, batchSize int, flushInterval time.Duration, metricsEngine pbsmetrics.MetricsEngine) *Module {
+	m := &Module{
+		producer:      producer,
+		metricsEngine: metricsEngine,
+		messages:      make(chan message, bufferSize),
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		done:          make(chan struct{}),
+		stopped:       make(chan struct{}),
+	}
+	go m.run()
+	return m
+}
+
+type Module struct {
+	// These are first so that they're 64-bit aligned for the atomic operations.
+	dropped uint64
+	failed  uint64
+
+	producer      Producer
+	metricsEngine pbsmetrics.MetricsEngine
+	messages      chan message
+	batchSize     int
+	flushInterval time.Duration
+	done          chan struct{}
+	stopped       chan struct{}
+	closeOnce     sync.Once
+	closeErr      error
+}
+
+// message is a serialized Event, along with the type which its metrics are recorded under.
+type message struct {
+	eventType pbsmetrics.AnalyticsEventType
+	data      []byte
+}
+
+func (m *Module) LogAuctionObject(ao *analytics.AuctionObject) {
+	if ao == nil {
+		return
+	}
+	m.enqueue(newAuctionEvent(ao, time.Now()))
+}
+
+func (m *Module) LogAmpObject(ao *analytics.AmpObject) {
+	if ao == nil {
+		return
+	}
+	m.enqueue(newAmpEvent(ao, time.Now()))
+}
+
+func (m *Module) LogCookieSyncObject(cso *analytics.CookieSyncObject) {
+	if cso == nil {
+		return
+	}
+	m.enqueue(newCookieSyncEvent(cso, time.Now()))
+}
+
+func (m *Module) LogSetUIDObject(so *analytics.SetUIDObject) {
+	if so == nil {
+		return
+	}
+	m.enqueue(newSetUIDEvent(so, time.Now()))
+}
+
+func (m *Module) LogEventObject(eo *analytics.EventObject) {
+	if eo == nil {
+		return
+	}
+	m.enqueue(newNotifyEvent(eo, time.Now()))
+}
+
+// Dropped returns the number of Events which were dropped because the buffer was full, or the module was closed.
+func (m *Module) Dropped() uint64 {
+	return atomic.LoadUint64(&m.dropped)
+}
+
+// Failed returns the number of Events which the producer failed to send.
+func (m *Module) Failed() uint64 {
+	return atomic.LoadUint64(&m.failed)
+}
+
+// Close sends any buffered Events to the producer, and then closes it.
+// Events logged after Close() is called will be dropped.
+func (m *Module) Close() error {
+	m.closeOnce.Do(func() {
+		close(m.done)
+		<-m.stopped
+		m.closeErr = m.producer.Close()
+	})
+	return m.closeErr
+}
+
+func (m *Module) enqueue(event *Event) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		glog.Errorf("Failed to serialize the %s analytics event: %v", event.Type, err)
+		return
+	}
+	// The Event types are named after the same endpoints as the metrics' event types.
+	msg := message{pbsmetrics.AnalyticsEventType(event.Type), data}
+
+	select {
+	case <-m.done:
+		m.drop(msg)
+		return
+	default:
+	}
+
+	select {
+	case m.messages <- msg:
+	default:
+		m.drop(msg)
+	}
+}
+
+func (m *Module) drop(msg message) {
+	atomic.AddUint64(&m.dropped, 1)
+	m.metricsEngine.RecordAnalyticsEventDropped(pbsmetrics.AnalyticsModuleStream, msg.eventType)
+}
+
+func (m *Module) run() {
+	ticker := time.NewTicker(m.flushInterval)
+	defer ticker.Stop()
+
+	var reportedDrops uint64
+	batch := make([]message, 0, m.batchSize)
+	for {
+		select {
+		case msg := <-m.messages:
+			batch = append(batch, msg)
+			if len(batch) >= m.batchSize {
+				batch = m.flush(batch)
+			}
+		case <-ticker.C:
+			batch = m.flush(batch)
+			if dropped := m.Dropped(); dropped > reportedDrops {
+				glog.Warningf("The analytics stream buffer was full. %d events have been dropped.", dropped)
+				reportedDrops = dropped
+			}
+		case <-m.done:
+			m.drain(batch)
+			close(m.stopped)
+			return
+		}
+	}
+}
+
+// drain sends the batch, and all the messages left in the buffer.
+func (m *Module) drain(batch []message) {
+	for {
+		select {
+		case msg := <-m.messages:
+			batch = append(batch, msg)
+			if len(batch) >= m.batchSize {
+				batch = m.flush(batch)
+			}
+		default:
+			m.flush(batch)
+			return
+		}
+	}
+}
+
+// flush sends the batch to the producer, and returns an empty batch for the next messages.
+func (m *Module) flush(batch []message) []message {
+	if len(batch) == 0 {
+		return batch
+	}
+	data := make([][]byte, len(batch))
+	for i, msg := range batch {
+		data[i] = msg.data
+	}
+	if err := m.producer.Send(data); err != nil {
+		atomic.AddUint64(&m.failed, uint64(len(batch)))
+		for _, msg := range batch {
+			m.metricsEngine.RecordAnalyticsEventFailed(pbsmetrics.AnalyticsModuleStream, msg.eventType)
+		}
+		glog.Warningf("Failed to send %d analytics events: %v", len(batch), err)
+	}
+	return make([]message, 0, m.batchSize)
+}
@@ -0,0 +1,152 @@
+package stream
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prebid/prebid-server/analytics"
+	"github.com/prebid/prebid-server/analytics/stream/streamtest"
+	"github.com/prebid/prebid-server/config"
+	"github.com/prebid/prebid-server/pbsmetrics"
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestBatchSize(t *testing.T) {
+	producer := streamtest.NewProducer()
+	module := NewModule(producer, 10, 2, time.Hour, newTestMetrics())
+	defer module.Close()
+
+	module.LogSetUIDObject(&analytics.SetUIDObject{Status: http.StatusOK, Bidder: "appnexus"})
+	module.LogSetUIDObject(&analytics.SetUIDObject{Status: http.StatusOK, Bidder: "rubicon"})
+	if !producer.WaitForMessages(2, time.Second) {
+		t.Fatalf("A full batch should be sent right away.")
+	}
+	if batches := producer.Batches(); len(batches) != 1 || len(batches[0]) != 2 {
+		t.Errorf("Expected one batch of 2 messages. Got %d batches", len(batches))
+	}
+}
+
+func TestFlushInterval(t *testing.T) {
+	producer := streamtest.NewProducer()
+	module := NewModule(producer, 10, 100, 10*time.Millisecond, newTestMetrics())
+	defer module.Close()
+
+	module.LogCookieSyncObject(&analytics.CookieSyncObject{Status: http.StatusOK})
+	if !producer.WaitForMessages(1, time.Second) {
+		t.Errorf("Partial batches should be sent every flush interval.")
+	}
+}
+
+func TestCloseFlushes(t *testing.T) {
+	producer := streamtest.NewProducer()
+	module := NewModule(producer, 10, 100, time.Hour, newTestMetrics())
+
+	module.LogAuctionObject(&analytics.AuctionObject{Status: http.StatusOK})
+	module.LogAmpObject(&analytics.AmpObject{Status: http.StatusOK})
+	if err := module.Close(); err != nil {
+		t.Errorf("Unexpected error closing the module: %v", err)
+	}
+	if messages := producer.Messages(); len(messages) != 2 {
+		t.Errorf("Close() should send the buffered events. Got %d messages", len(messages))
+	}
+	if !producer.Closed() {
+		t.Errorf("Close() should close the producer.")
+	}
+
+	module.LogAuctionObject(&analytics.AuctionObject{Status: http.StatusOK})
+	if module.Dropped() != 1 {
+		t.Errorf("Events logged after Close() should be dropped. Got %d drops", module.Dropped())
+	}
+}
+
+func TestFullBufferDrops(t *testing.T) {
+	producer := &blockingProducer{
+		Producer: streamtest.NewProducer(),
+		started:  make(chan struct{}, 1),
+		release:  make(chan struct{}),
+	}
+	metricsEngine := newTestMetrics()
+	module := NewModule(producer, 1, 1, time.Hour, metricsEngine)
+
+	// The first event is taken off the buffer and sent, the second fills up the buffer, and the third doesn't fit.
+	module.LogSetUIDObject(&analytics.SetUIDObject{Bidder: "first"})
+	<-producer.started
+	module.LogSetUIDObject(&analytics.SetUIDObject{Bidder: "second"})
+	module.LogSetUIDObject(&analytics.SetUIDObject{Bidder: "third"})
+	if module.Dropped() != 1 {
+		t.Errorf("Events should be dropped if the buffer is full. Got %d drops", module.Dropped())
+	}
+	if drops := metricsEngine.AnalyticsDropMeters[pbsmetrics.AnalyticsModuleStream][pbsmetrics.AnalyticsEventSetUID].Count(); drops != 1 {
+		t.Errorf("Dropped events should be recorded in the metrics. Got %d", drops)
+	}
+
+	close(producer.release)
+	module.Close()
+	if messages := producer.Messages(); len(messages) != 2 {
+		t.Errorf("Expected the buffered events to be sent. Got %d messages", len(messages))
+	}
+}
+
+func TestSendFailures(t *testing.T) {
+	producer := streamtest.NewProducer()
+	producer.Err = errors.New("broker unavailable")
+	metricsEngine := newTestMetrics()
+	module := NewModule(producer, 10, 2, time.Hour, metricsEngine)
+
+	module.LogSetUIDObject(&analytics.SetUIDObject{Bidder: "appnexus"})
+	module.LogSetUIDObject(&analytics.SetUIDObject{Bidder: "rubicon"})
+	module.Close()
+	if module.Failed() != 2 {
+		t.Errorf("Events which the producer couldn't send should be counted. Got %d", module.Failed())
+	}
+	if failures := metricsEngine.AnalyticsFailMeters[pbsmetrics.AnalyticsModuleStream][pbsmetrics.AnalyticsEventSetUID].Count(); failures != 2 {
+		t.Errorf("Events which the producer couldn't send should be recorded in the metrics. Got %d", failures)
+	}
+}
+
+func TestMessageSchema(t *testing.T) {
+	producer := streamtest.NewProducer()
+	module := NewModule(producer, 10, 10, time.Hour, newTestMetrics())
+	module.LogSetUIDObject(&analytics.SetUIDObject{
+		Status:  http.StatusOK,
+		Bidder:  "appnexus",
+		UID:     "some-uid",
+		Success: true,
+	})
+	module.Close()
+
+	messages := producer.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("Expected 1 message. Got %d", len(messages))
+	}
+	var event Event
+	if err := json.Unmarshal(messages[0], &event); err != nil {
+		t.Fatalf("Messages should be JSON Events. Got %s", string(messages[0]))
+	}
+	if event.Type != EventSetUID || event.Bidder != "appnexus" || !event.Success || event.Timestamp == 0 {
+		t.Errorf("Bad setuid event: %s", string(messages[0]))
+	}
+}
+
+// blockingProducer lets tests pause the module while it's sending a batch.
+type blockingProducer struct {
+	*streamtest.Producer
+	started chan struct{}
+	release chan struct{}
+}
+
+func (p *blockingProducer) Send(messages [][]byte) error {
+	select {
+	case p.started <- struct{}{}:
+	default:
+	}
+	<-p.release
+	return p.Producer.Send(messages)
+}
+
+func newTestMetrics() *pbsmetrics.Metrics {
+	return pbsmetrics.NewMetrics(metrics.NewRegistry(), nil, config.AccountMetrics{})
+}
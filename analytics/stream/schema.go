@@ -0,0 +1,247 @@
+package stream
+
+import (
+	"time"
+
+	"github.com/mxmCherry/openrtb"
+	"github.com/prebid/prebid-server/analytics"
+)
+
+type EventType string
+
+const (
+	EventAuction    EventType = "auction"
+	EventAmp        EventType = "amp"
+	EventCookieSync EventType = "cookie_sync"
+	EventSetUID     EventType = "setuid"
+	EventNotify     EventType = "event"
+)
+
+// Event is the compact schema which every analytics object is serialized into before it's sent to the broker.
+// The JSON keys are short, and empty fields are omitted, since these are sent for every request.
+//
+// Only the fields which make sense for the Type are set. User IDs are never included.
+type Event struct {
+	Type      EventType `json:"t"`
+	Timestamp int64     `json:"ts"`
+	Status    int       `json:"st"`
+	Errors    []string  `json:"err,omitempty"`
+
+	// Request, Bids and Bidders are set on auction and amp events.
+	Request *Request `json:"req,omitempty"`
+	Bids    []Bid    `json:"bids,omitempty"`
+	Bidders []Bidder `json:"bdrs,omitempty"`
+	// Origin is set on amp events.
+	Origin string `json:"org,omitempty"`
+
+	// Bidder and Success are set on setuid events. Bidder is also set on event notifications.
+	Bidder  string `json:"bdr,omitempty"`
+	Success bool   `json:"ok,omitempty"`
+
+	// Notification, BidID, Account and AuctionTimestamp are set on event notifications.
+	Notification     string `json:"ntf,omitempty"`
+	BidID            string `json:"bid,omitempty"`
+	Account          string `json:"acct,omitempty"`
+	AuctionTimestamp int64  `json:"ats,omitempty"`
+
+	// Syncs are set on cookie_sync events.
+	Syncs []Sync `json:"syn,omitempty"`
+}
+
+// Request summarizes an OpenRTB BidRequest.
+type Request struct {
+	ID        string `json:"id"`
+	Publisher string `json:"pub,omitempty"`
+	Domain    string `json:"dom,omitempty"`
+	Bundle    string `json:"app,omitempty"`
+	Imps      int    `json:"imps"`
+	Test      int8   `json:"test,omitempty"`
+}
+
+// Bid summarizes a bid from an OpenRTB BidResponse.
+type Bid struct {
+	Seat   string  `json:"seat"`
+	ImpID  string  `json:"imp"`
+	Price  float64 `json:"p"`
+	W      uint64  `json:"w,omitempty"`
+	H      uint64  `json:"h,omitempty"`
+	DealID string  `json:"deal,omitempty"`
+}
+
+// Bidder summarizes how a bidder took part in an auction.
+type Bidder struct {
+	Bidder       string `json:"bdr"`
+	ResponseTime int    `json:"rt"`
+	TimedOut     bool   `json:"to,omitempty"`
+	Errors       int    `json:"err,omitempty"`
+	Bids         int    `json:"bids,omitempty"`
+	Wins         int    `json:"wins,omitempty"`
+	Rejected     int    `json:"rej,omitempty"`
+}
+
+// Sync is the status of a bidder in a cookie_sync response.
+type Sync struct {
+	Bidder   string `json:"bdr"`
+	NoCookie bool   `json:"nc,omitempty"`
+}
+
+func newAuctionEvent(ao *analytics.AuctionObject, now time.Time) *Event {
+	return &Event{
+		Type:      EventAuction,
+		Timestamp: toMillis(now),
+		Status:    ao.Status,
+		Errors:    errorMessages(ao.Errors),
+		Request:   toRequest(ao.Request),
+		Bids:      toBids(ao.Response),
+		Bidders:   toBidders(ao.Details),
+	}
+}
+
+func newAmpEvent(ao *analytics.AmpObject, now time.Time) *Event {
+	return &Event{
+		Type:      EventAmp,
+		Timestamp: toMillis(now),
+		Status:    ao.Status,
+		Errors:    errorMessages(ao.Errors),
+		Request:   toRequest(ao.Request),
+		Bids:      toBids(ao.AuctionResponse),
+		Bidders:   toBidders(ao.Details),
+		Origin:    ao.Origin,
+	}
+}
+
+func newCookieSyncEvent(cso *analytics.CookieSyncObject, now time.Time) *Event {
+	event := &Event{
+		Type:      EventCookieSync,
+		Timestamp: toMillis(now),
+		Status:    cso.Status,
+		Errors:    errorMessages(cso.Errors),
+	}
+	if len(cso.BidderStatus) > 0 {
+		event.Syncs = make([]Sync, 0, len(cso.BidderStatus))
+		for _, status := range cso.BidderStatus {
+			if status != nil {
+				event.Syncs = append(event.Syncs, Sync{
+					Bidder:   status.BidderCode,
+					NoCookie: status.NoCookie,
+				})
+			}
+		}
+	}
+	return event
+}
+
+func newSetUIDEvent(so *analytics.SetUIDObject, now time.Time) *Event {
+	return &Event{
+		Type:      EventSetUID,
+		Timestamp: toMillis(now),
+		Status:    so.Status,
+		Errors:    errorMessages(so.Errors),
+		Bidder:    so.Bidder,
+		Success:   so.Success,
+	}
+}
+
+func newNotifyEvent(eo *analytics.EventObject, now time.Time) *Event {
+	return &Event{
+		Type:             EventNotify,
+		Timestamp:        toMillis(now),
+		Status:           eo.Status,
+		Errors:           errorMessages(eo.Errors),
+		Bidder:           eo.Bidder,
+		Notification:     eo.Type,
+		BidID:            eo.BidID,
+		Account:          eo.Account,
+		AuctionTimestamp: eo.AuctionTimestamp,
+	}
+}
+
+func toRequest(req *openrtb.BidRequest) *Request {
+	if req == nil {
+		return nil
+	}
+	summary := &Request{
+		ID:   req.ID,
+		Imps: len(req.Imp),
+		Test: req.Test,
+	}
+	if req.Site != nil {
+		summary.Domain = req.Site.Domain
+		if req.Site.Publisher != nil {
+			summary.Publisher = req.Site.Publisher.ID
+		}
+	} else if req.App != nil {
+		summary.Bundle = req.App.Bundle
+		if req.App.Publisher != nil {
+			summary.Publisher = req.App.Publisher.ID
+		}
+	}
+	return summary
+}
+
+func toBids(resp *openrtb.BidResponse) []Bid {
+	if resp == nil {
+		return nil
+	}
+	var bids []Bid
+	for _, seatBid := range resp.SeatBid {
+		for _, bid := range seatBid.Bid {
+			bids = append(bids, Bid{
+				Seat:   seatBid.Seat,
+				ImpID:  bid.ImpID,
+				Price:  bid.Price,
+				W:      bid.W,
+				H:      bid.H,
+				DealID: bid.DealID,
+			})
+		}
+	}
+	return bids
+}
+
+func toBidders(details *analytics.AuctionDetails) []Bidder {
+	if details == nil || len(details.Bidders) == 0 {
+		return nil
+	}
+	bidders := make([]Bidder, 0, len(details.Bidders))
+	for _, result := range details.Bidders {
+		if result == nil {
+			continue
+		}
+		bidder := Bidder{
+			Bidder:       result.Bidder,
+			ResponseTime: result.ResponseTimeMillis,
+			TimedOut:     result.TimedOut,
+			Errors:       len(result.Errors),
+		}
+		for _, bid := range result.Bids {
+			if bid.Rejection != 0 {
+				bidder.Rejected++
+				continue
+			}
+			bidder.Bids++
+			if bid.Won {
+				bidder.Wins++
+			}
+		}
+		bidders = append(bidders, bidder)
+	}
+	return bidders
+}
+
+func errorMessages(errs []error) []string {
+	if len(errs) == 0 {
+		return nil
+	}
+	messages := make([]string, 0, len(errs))
+	for _, err := range errs {
+		if err != nil {
+			messages = append(messages, err.Error())
+		}
+	}
+	return messages
+}
+
+func toMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
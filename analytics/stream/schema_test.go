@@ -0,0 +1,134 @@
+package stream
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mxmCherry/openrtb"
+	"github.com/prebid/prebid-server/analytics"
+	"github.com/prebid/prebid-server/errortypes"
+	"github.com/prebid/prebid-server/openrtb_ext"
+	"github.com/prebid/prebid-server/usersync"
+)
+
+func TestAuctionEvent(t *testing.T) {
+	event := newAuctionEvent(&analytics.AuctionObject{
+		Status: http.StatusOK,
+		Errors: []error{errors.New("bad imp")},
+		Request: &openrtb.BidRequest{
+			ID:   "req-id",
+			Imp:  []openrtb.Imp{{ID: "imp-1"}, {ID: "imp-2"}},
+			Site: &openrtb.Site{Domain: "example.com", Publisher: &openrtb.Publisher{ID: "pub"}},
+		},
+		Response: &openrtb.BidResponse{
+			SeatBid: []openrtb.SeatBid{{
+				Seat: "appnexus",
+				Bid:  []openrtb.Bid{{ImpID: "imp-1", Price: 1.5, W: 300, H: 250, DealID: "deal"}},
+			}},
+		},
+	}, time.Unix(10, 0))
+
+	if event.Type != EventAuction || event.Timestamp != 10000 || event.Status != http.StatusOK {
+		t.Errorf("Bad event header: %#v", event)
+	}
+	if len(event.Errors) != 1 || event.Errors[0] != "bad imp" {
+		t.Errorf("Errors should be serialized as messages. Got %v", event.Errors)
+	}
+	if req := event.Request; req == nil || req.ID != "req-id" || req.Imps != 2 || req.Domain != "example.com" || req.Publisher != "pub" {
+		t.Errorf("Bad request summary: %#v", event.Request)
+	}
+	expectedBid := Bid{Seat: "appnexus", ImpID: "imp-1", Price: 1.5, W: 300, H: 250, DealID: "deal"}
+	if len(event.Bids) != 1 || event.Bids[0] != expectedBid {
+		t.Errorf("Bad bids: %#v", event.Bids)
+	}
+}
+
+func TestAuctionEventBidders(t *testing.T) {
+	event := newAuctionEvent(&analytics.AuctionObject{
+		Details: &analytics.AuctionDetails{
+			Bidders: []*analytics.BidderResult{{
+				Bidder:             "appnexus",
+				ResponseTimeMillis: 120,
+				Bids: []*analytics.BidRecord{
+					{ImpID: "imp-1", Price: 1.5, Won: true},
+					{ImpID: "imp-2", Price: 0.5},
+					{ImpID: "imp-2", Rejection: openrtb_ext.NonBidInvalidBid},
+				},
+			}, {
+				Bidder:   "rubicon",
+				TimedOut: true,
+				Errors:   []openrtb_ext.ExtBidderError{{Code: errortypes.TimeoutCode, Message: "timeout"}},
+			}},
+		},
+	}, time.Now())
+
+	expected := []Bidder{
+		{Bidder: "appnexus", ResponseTime: 120, Bids: 2, Wins: 1, Rejected: 1},
+		{Bidder: "rubicon", TimedOut: true, Errors: 1},
+	}
+	if len(event.Bidders) != len(expected) {
+		t.Fatalf("Expected %d bidders. Got %#v", len(expected), event.Bidders)
+	}
+	for i, bidder := range expected {
+		if event.Bidders[i] != bidder {
+			t.Errorf("Bad summary for bidder %d. Expected %#v, got %#v", i, bidder, event.Bidders[i])
+		}
+	}
+}
+
+func TestAmpEvent(t *testing.T) {
+	event := newAmpEvent(&analytics.AmpObject{
+		Status:  http.StatusBadRequest,
+		Request: &openrtb.BidRequest{ID: "req-id", App: &openrtb.App{Bundle: "com.example"}},
+		Origin:  "https://example.com",
+	}, time.Now())
+
+	if event.Type != EventAmp || event.Origin != "https://example.com" || event.Bids != nil {
+		t.Errorf("Bad amp event: %#v", event)
+	}
+	if event.Request == nil || event.Request.Bundle != "com.example" {
+		t.Errorf("App requests should include the bundle. Got %#v", event.Request)
+	}
+}
+
+func TestCookieSyncEvent(t *testing.T) {
+	event := newCookieSyncEvent(&analytics.CookieSyncObject{
+		Status: http.StatusOK,
+		BidderStatus: []*usersync.CookieSyncBidders{
+			{BidderCode: "appnexus", NoCookie: true},
+			nil,
+		},
+	}, time.Now())
+
+	if event.Type != EventCookieSync || len(event.Syncs) != 1 || event.Syncs[0] != (Sync{Bidder: "appnexus", NoCookie: true}) {
+		t.Errorf("Bad cookie_sync event: %#v", event)
+	}
+}
+
+func TestNotifyEvent(t *testing.T) {
+	event := newNotifyEvent(&analytics.EventObject{
+		Status:           http.StatusNoContent,
+		Type:             "win",
+		BidID:            "bid-id",
+		Bidder:           "appnexus",
+		Account:          "pub",
+		AuctionTimestamp: 1000,
+	}, time.Unix(10, 0))
+
+	expected := Event{
+		Type:             EventNotify,
+		Timestamp:        10000,
+		Status:           http.StatusNoContent,
+		Bidder:           "appnexus",
+		Notification:     "win",
+		BidID:            "bid-id",
+		Account:          "pub",
+		AuctionTimestamp: 1000,
+	}
+	if !reflect.DeepEqual(*event, expected) {
+		t.Errorf("Bad event notification. Expected %#v, got %#v", expected, *event)
+	}
+}
@@ -0,0 +1,81 @@
+package streamtest
+
+import (
+	"sync"
+	"time"
+)
+
+// Producer is an in-memory stream.Producer which can be used in place of a real broker.
+// It's safe for concurrent use.
+type Producer struct {
+	// Err will be returned from Send(), if set.
+	Err error
+
+	mutex   sync.Mutex
+	batches [][][]byte
+	closed  bool
+	sent    chan struct{}
+}
+
+func NewProducer() *Producer {
+	return &Producer{
+		sent: make(chan struct{}, 1),
+	}
+}
+
+func (p *Producer) Send(messages [][]byte) error {
+	p.mutex.Lock()
+	p.batches = append(p.batches, messages)
+	err := p.Err
+	p.mutex.Unlock()
+
+	select {
+	case p.sent <- struct{}{}:
+	default:
+	}
+	return err
+}
+
+func (p *Producer) Close() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.closed = true
+	return nil
+}
+
+// Batches returns every batch passed to Send() so far.
+func (p *Producer) Batches() [][][]byte {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	batches := make([][][]byte, len(p.batches))
+	copy(batches, p.batches)
+	return batches
+}
+
+// Messages returns every message passed to Send() so far, in order.
+func (p *Producer) Messages() [][]byte {
+	var messages [][]byte
+	for _, batch := range p.Batches() {
+		messages = append(messages, batch...)
+	}
+	return messages
+}
+
+func (p *Producer) Closed() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.closed
+}
+
+// WaitForMessages waits until at least n messages have been sent. It returns false if that doesn't happen before the timeout.
+func (p *Producer) WaitForMessages(n int, timeout time.Duration) bool {
+	deadline := time.After(timeout)
+	for len(p.Messages()) < n {
+		select {
+		case <-p.sent:
+		case <-deadline:
+			return false
+		}
+	}
+	return true
+}
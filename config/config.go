@@ -2,8 +2,11 @@ package config
 
 import (
 	"bytes"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"reflect"
+	"regexp"
 	"strings"
 	"time"
 
@@ -36,6 +39,16 @@ type Configuration struct {
 	Analytics            Analytics          `mapstructure:"analytics"`
 	AMPTimeoutAdjustment int64              `mapstructure:"amp_timeout_adjustment_ms"`
 	GDPR                 GDPR               `mapstructure:"gdpr"`
+	UIDStore             UIDStore           `mapstructure:"uid_store"`
+	CategoryMapping      CategoryMapping    `mapstructure:"category_mapping"`
+	LegacyAuction        LegacyAuction      `mapstructure:"legacy_auction"`
+	CookieSync           CookieSync         `mapstructure:"cookie_sync"`
+	VASTModification     VASTModification   `mapstructure:"vast_modification"`
+	Tracing              Tracing            `mapstructure:"tracing"`
+	CircuitBreaker       CircuitBreaker     `mapstructure:"circuit_breaker"`
+
+	// MaxVideoPodImps caps the number of imps which a single /openrtb2/video pod can expand into.
+	MaxVideoPodImps int `mapstructure:"max_video_pod_imps"`
 }
 
 type configErrors []error
@@ -62,7 +75,21 @@ func (cfg *Configuration) validate() configErrors {
 	if cfg.MaxRequestSize < 0 {
 		errs = append(errs, fmt.Errorf("cfg.max_request_size must be >= 0. Got %d", cfg.MaxRequestSize))
 	}
+	if cfg.MaxVideoPodImps < 1 {
+		errs = append(errs, fmt.Errorf("cfg.max_video_pod_imps must be > 0. Got %d", cfg.MaxVideoPodImps))
+	}
 	errs = cfg.GDPR.validate(errs)
+	errs = cfg.HostCookie.validate(errs)
+	errs = cfg.UIDStore.validate(errs, &cfg.HostCookie)
+	errs = cfg.LegacyAuction.validate(errs)
+	errs = cfg.CookieSync.validate(errs)
+	errs = cfg.Analytics.validate(errs)
+	errs = cfg.Metrics.validate(errs)
+	errs = cfg.Tracing.validate(errs)
+	errs = cfg.CircuitBreaker.validate(errs)
+	for bidder, adapter := range cfg.Adapters {
+		errs = adapter.HTTPClient.validate(errs, bidder)
+	}
 	return errs
 }
 
@@ -122,7 +149,67 @@ func (t *GDPRTimeouts) ActiveTimeout() time.Duration {
 }
 
 type Analytics struct {
-	File FileLogs `mapstructure:"file"`
+	File     FileLogs          `mapstructure:"file"`
+	Stream   StreamAnalytics   `mapstructure:"stream"`
+	Async    AnalyticsAsync    `mapstructure:"async"`
+	Sampling AnalyticsSampling `mapstructure:"sampling"`
+}
+
+func (cfg *Analytics) validate(errs configErrors) configErrors {
+	errs = cfg.Stream.validate(errs)
+	errs = cfg.Async.validate(errs)
+	return cfg.Sampling.validate(errs)
+}
+
+// AnalyticsAsync configures how events are handed off to the analytics modules, so that the endpoints don't wait on them.
+// Each module gets its own queue and workers.
+type AnalyticsAsync struct {
+	Workers   int `mapstructure:"workers"`
+	QueueSize int `mapstructure:"queue_size"`
+	// ShutdownTimeout is the max time to wait for the modules to finish with their queued events when PBS shuts down.
+	ShutdownTimeout int `mapstructure:"shutdown_timeout_ms"`
+}
+
+func (cfg *AnalyticsAsync) ShutdownTimeoutDuration() time.Duration {
+	return time.Duration(cfg.ShutdownTimeout) * time.Millisecond
+}
+
+func (cfg *AnalyticsAsync) validate(errs configErrors) configErrors {
+	if cfg.Workers < 0 {
+		errs = append(errs, fmt.Errorf("analytics.async.workers must be >= 0. Got %d", cfg.Workers))
+	}
+	if cfg.QueueSize < 0 {
+		errs = append(errs, fmt.Errorf("analytics.async.queue_size must be >= 0. Got %d", cfg.QueueSize))
+	}
+	if cfg.ShutdownTimeout < 0 {
+		errs = append(errs, fmt.Errorf("analytics.async.shutdown_timeout_ms must be >= 0. Got %d", cfg.ShutdownTimeout))
+	}
+	return errs
+}
+
+// AnalyticsSampling is the fraction of each type of event which gets logged to the analytics modules.
+// 1 logs every event, and 0 logs none of them.
+type AnalyticsSampling struct {
+	Auction    float64 `mapstructure:"auction"`
+	Amp        float64 `mapstructure:"amp"`
+	CookieSync float64 `mapstructure:"cookie_sync"`
+	SetUID     float64 `mapstructure:"setuid"`
+	Event      float64 `mapstructure:"event"`
+}
+
+func (cfg *AnalyticsSampling) validate(errs configErrors) configErrors {
+	errs = validateSampleRate(errs, "auction", cfg.Auction)
+	errs = validateSampleRate(errs, "amp", cfg.Amp)
+	errs = validateSampleRate(errs, "cookie_sync", cfg.CookieSync)
+	errs = validateSampleRate(errs, "setuid", cfg.SetUID)
+	return validateSampleRate(errs, "event", cfg.Event)
+}
+
+func validateSampleRate(errs configErrors, eventType string, rate float64) configErrors {
+	if rate < 0 || rate > 1 {
+		errs = append(errs, fmt.Errorf("analytics.sampling.%s must be between 0 and 1. Got %f", eventType, rate))
+	}
+	return errs
 }
 
 //Corresponding config for FileLogger as a PBS Analytics Module
@@ -130,6 +217,60 @@ type FileLogs struct {
 	Filename string `mapstructure:"filename"`
 }
 
+// StreamAnalytics configures the analytics module which ships events to a message broker in batches.
+// It's enabled if a broker is configured.
+type StreamAnalytics struct {
+	Kafka KafkaAnalytics `mapstructure:"kafka"`
+	// BufferSize is the max number of events which can wait to be sent. Events beyond that are dropped.
+	BufferSize int `mapstructure:"buffer_size"`
+	// BatchSize is the max number of events sent to the broker at once.
+	BatchSize int `mapstructure:"batch_size"`
+	// FlushInterval is the max time that an event will wait for its batch to fill up.
+	FlushInterval int `mapstructure:"flush_interval_ms"`
+}
+
+type KafkaAnalytics struct {
+	Brokers []string `mapstructure:"brokers"`
+	Topic   string   `mapstructure:"topic"`
+	// Timeout is the max time to wait for the brokers to acknowledge a batch.
+	Timeout int `mapstructure:"timeout_ms"`
+}
+
+// Enabled returns true if the stream analytics module should be used.
+func (cfg *StreamAnalytics) Enabled() bool {
+	return len(cfg.Kafka.Brokers) > 0
+}
+
+func (cfg *StreamAnalytics) FlushIntervalDuration() time.Duration {
+	return time.Duration(cfg.FlushInterval) * time.Millisecond
+}
+
+func (cfg *KafkaAnalytics) TimeoutDuration() time.Duration {
+	return time.Duration(cfg.Timeout) * time.Millisecond
+}
+
+func (cfg *StreamAnalytics) validate(errs configErrors) configErrors {
+	if !cfg.Enabled() {
+		return errs
+	}
+	if cfg.Kafka.Topic == "" {
+		errs = append(errs, errors.New("analytics.stream.kafka.topic must be defined if analytics.stream.kafka.brokers are"))
+	}
+	if cfg.Kafka.Timeout <= 0 {
+		errs = append(errs, fmt.Errorf("analytics.stream.kafka.timeout_ms must be > 0. Got %d", cfg.Kafka.Timeout))
+	}
+	if cfg.BufferSize <= 0 {
+		errs = append(errs, fmt.Errorf("analytics.stream.buffer_size must be > 0. Got %d", cfg.BufferSize))
+	}
+	if cfg.BatchSize <= 0 {
+		errs = append(errs, fmt.Errorf("analytics.stream.batch_size must be > 0. Got %d", cfg.BatchSize))
+	}
+	if cfg.FlushInterval <= 0 {
+		errs = append(errs, fmt.Errorf("analytics.stream.flush_interval_ms must be > 0. Got %d", cfg.FlushInterval))
+	}
+	return errs
+}
+
 type HostCookie struct {
 	Domain       string `mapstructure:"domain"`
 	Family       string `mapstructure:"family"`
@@ -139,12 +280,130 @@ type HostCookie struct {
 	OptOutCookie Cookie `mapstructure:"optout_cookie"`
 	// Cookie timeout in days
 	TTL int64 `mapstructure:"ttl_days"`
+	// UIDCookie configures the format of the "uids" cookie, which stores the IDs from each Bidder.
+	UIDCookie UIDCookie `mapstructure:"uid_cookie"`
 }
 
 func (cfg *HostCookie) TTLDuration() time.Duration {
 	return time.Duration(cfg.TTL) * time.Hour * 24
 }
 
+func (cfg *HostCookie) validate(errs configErrors) configErrors {
+	return cfg.UIDCookie.validate(errs)
+}
+
+// UIDCookie configures the signed (and optionally encrypted) format of the "uids" cookie.
+//
+// Legacy cookies are plain base64-encoded JSON, which means that any script on the page can forge Bidder IDs.
+// Signed cookies carry an HMAC which is checked with the Keys before any IDs are trusted.
+type UIDCookie struct {
+	// Sign makes PBS write signed cookies. Signed cookies are always verified on read if any Keys exist.
+	Sign bool `mapstructure:"sign"`
+	// Encrypt makes PBS encrypt the cookie contents with AES-GCM before signing them. This implies Sign.
+	Encrypt bool `mapstructure:"encrypt"`
+	// RejectLegacy makes PBS ignore unsigned cookies. This should be false while existing cookies are migrated,
+	// and true afterwards.
+	RejectLegacy bool `mapstructure:"reject_legacy"`
+	// Keys are used to sign and encrypt cookies. New cookies are written with the first key, and cookies written
+	// with any of them are accepted. Keys can be rotated by adding a new one at the front of the list, and removing
+	// the last one after all cookies written with it have expired.
+	Keys []CookieKey `mapstructure:"keys"`
+}
+
+// CookieKey is a secret used to sign and encrypt the uids cookie.
+type CookieKey struct {
+	// ID is written into the cookie so that PBS knows which key to use when reading it.
+	ID string `mapstructure:"id"`
+	// Secret is the key material. Separate signing and encryption keys are derived from it.
+	Secret string `mapstructure:"secret"`
+}
+
+// minCookieSecretLength is the shortest allowed CookieKey.Secret, in bytes.
+const minCookieSecretLength = 32
+
+func (cfg *UIDCookie) validate(errs configErrors) configErrors {
+	if (cfg.Sign || cfg.Encrypt) && len(cfg.Keys) == 0 {
+		errs = append(errs, errors.New("host_cookie.uid_cookie.keys must not be empty if host_cookie.uid_cookie.sign or host_cookie.uid_cookie.encrypt is true"))
+	}
+	if cfg.RejectLegacy && !cfg.Sign && !cfg.Encrypt {
+		errs = append(errs, errors.New("host_cookie.uid_cookie.reject_legacy must be false if PBS writes legacy cookies"))
+	}
+	ids := make(map[string]struct{}, len(cfg.Keys))
+	for i, key := range cfg.Keys {
+		if key.ID == "" || strings.Contains(key.ID, ".") {
+			errs = append(errs, fmt.Errorf("host_cookie.uid_cookie.keys[%d].id must be non-empty and must not contain a \".\". Got %s", i, key.ID))
+		}
+		if _, ok := ids[key.ID]; ok {
+			errs = append(errs, fmt.Errorf("host_cookie.uid_cookie.keys[%d].id %s is used by more than one key", i, key.ID))
+		}
+		ids[key.ID] = struct{}{}
+		if len(key.Secret) < minCookieSecretLength {
+			errs = append(errs, fmt.Errorf("host_cookie.uid_cookie.keys[%d].secret must be at least %d bytes long", i, minCookieSecretLength))
+		}
+	}
+	return errs
+}
+
+// UIDStore configures the server-side copy of each user's syncs. See usersync/uidstores.
+type UIDStore struct {
+	// Type is "none", "memory" or "postgres".
+	Type string `mapstructure:"type"`
+	// Timeout is the max number of milliseconds to wait on each load or save. Use 0 for no timeout.
+	Timeout int `mapstructure:"timeout_ms"`
+	// MemorySize is the max number of bytes used by the "memory" store. Least recently used syncs are evicted first.
+	MemorySize int `mapstructure:"memory_size_bytes"`
+	// Postgres configures the "postgres" store.
+	Postgres UIDStorePostgres `mapstructure:"postgres"`
+}
+
+// UIDStorePostgres configures usersync/uidstores/postgres. The table should be created with:
+//
+//   CREATE TABLE uids (
+//     id varchar(128) PRIMARY KEY,
+//     data text NOT NULL,
+//     expires timestamp with time zone NOT NULL
+//   );
+//
+// Expired rows are never read, but must be deleted by the host.
+type UIDStorePostgres struct {
+	ConnectionInfo PostgresConnection `mapstructure:"connection"`
+	Table          string             `mapstructure:"table"`
+}
+
+func (cfg *UIDStore) TimeoutDuration() time.Duration {
+	return time.Duration(cfg.Timeout) * time.Millisecond
+}
+
+var validTableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+func (cfg *UIDStore) validate(errs configErrors, hostCookie *HostCookie) configErrors {
+	switch cfg.Type {
+	case "", "none":
+		return errs
+	case "memory":
+		if cfg.MemorySize <= 0 {
+			errs = append(errs, fmt.Errorf("uid_store.memory_size_bytes must be > 0 when uid_store.type=memory. Got %d", cfg.MemorySize))
+		}
+	case "postgres":
+		if cfg.Postgres.ConnectionInfo.Database == "" {
+			errs = append(errs, errors.New("uid_store.postgres.connection.dbname must be set when uid_store.type=postgres"))
+		}
+		if !validTableName.MatchString(cfg.Postgres.Table) {
+			errs = append(errs, fmt.Errorf("uid_store.postgres.table must be a valid table name when uid_store.type=postgres. Got %s", cfg.Postgres.Table))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("uid_store.type %s is invalid", cfg.Type))
+		return errs
+	}
+	if cfg.Timeout < 0 {
+		errs = append(errs, fmt.Errorf("uid_store.timeout_ms must be >= 0. Got %d", cfg.Timeout))
+	}
+	if hostCookie.CookieName == "" {
+		errs = append(errs, errors.New("host_cookie.cookie_name must be set if uid_store.type is not none, because UIDs are stored under it"))
+	}
+	return errs
+}
+
 type Adapter struct {
 	Endpoint    string `mapstructure:"endpoint"` // Required
 	UserSyncURL string `mapstructure:"usersync_url"`
@@ -154,11 +413,289 @@ type Adapter struct {
 		Password string `mapstructure:"password"`
 		Tracker  string `mapstructure:"tracker"`
 	} `mapstructure:"xapi"` // needed for Rubicon
+	// RewriteNativeAssetIDs should be set for Bidders which don't echo the native asset IDs from the request.
+	// Their native assets will be matched up with the requested ones by type, in order.
+	RewriteNativeAssetIDs bool `mapstructure:"rewrite_native_asset_ids"`
+	// HTTPClient configures the connections to the Bidder. Legacy adapters ignore it.
+	HTTPClient AdapterHTTPClient `mapstructure:"http_client"`
+}
+
+// AdapterHTTPClient configures the HTTP client which the exchange uses to call a Bidder.
+// Each Bidder gets its own connection pool, so that a Bidder with lots of traffic can't use up the
+// connections which the others need. Zero values use the defaults in parentheses.
+type AdapterHTTPClient struct {
+	// MaxConns limits the number of requests which can be in flight to the Bidder at once, and so the
+	// number of connections open to it. Requests wait for a free connection until the auction times out. (no limit)
+	MaxConns int `mapstructure:"max_conns"`
+	// MaxIdleConns is the number of idle connections which are kept open to the Bidder. (10)
+	MaxIdleConns int `mapstructure:"max_idle_conns"`
+	// IdleConnTimeout is how long an idle connection is kept open, in milliseconds. (60000)
+	IdleConnTimeout int `mapstructure:"idle_conn_timeout_ms"`
+	// HTTP2 lets the client use HTTP/2 with https endpoints which support it.
+	HTTP2 bool `mapstructure:"http2"`
+	// CompressRequests gzips the request bodies sent to the Bidder.
+	CompressRequests bool `mapstructure:"compress_requests"`
+	// TLS configures the connections to https endpoints.
+	TLS AdapterTLS `mapstructure:"tls"`
+}
+
+// IdleConnTimeoutDuration returns the IdleConnTimeout, or 0 if the default should be used.
+func (cfg *AdapterHTTPClient) IdleConnTimeoutDuration() time.Duration {
+	return time.Duration(cfg.IdleConnTimeout) * time.Millisecond
+}
+
+func (cfg *AdapterHTTPClient) validate(errs configErrors, bidder string) configErrors {
+	if cfg.MaxConns < 0 {
+		errs = append(errs, fmt.Errorf("adapters.%s.http_client.max_conns must be >= 0. Got %d", bidder, cfg.MaxConns))
+	}
+	if cfg.MaxIdleConns < 0 {
+		errs = append(errs, fmt.Errorf("adapters.%s.http_client.max_idle_conns must be >= 0. Got %d", bidder, cfg.MaxIdleConns))
+	}
+	if cfg.IdleConnTimeout < 0 {
+		errs = append(errs, fmt.Errorf("adapters.%s.http_client.idle_conn_timeout_ms must be >= 0. Got %d", bidder, cfg.IdleConnTimeout))
+	}
+	if _, ok := tlsVersions[cfg.TLS.MinVersion]; !ok {
+		errs = append(errs, fmt.Errorf("adapters.%s.http_client.tls.min_version must be 1.0, 1.1 or 1.2. Got %s", bidder, cfg.TLS.MinVersion))
+	}
+	return errs
+}
+
+// AdapterTLS configures the TLS connections to a Bidder's https endpoint.
+type AdapterTLS struct {
+	// MinVersion is the oldest TLS version which the Bidder may use: "1.0", "1.1" or "1.2". (1.0)
+	MinVersion string `mapstructure:"min_version"`
+	// InsecureSkipVerify turns off the checks on the Bidder's certificate. This should only be used for testing.
+	InsecureSkipVerify bool `mapstructure:"insecure_skip_verify"`
+}
+
+var tlsVersions = map[string]uint16{
+	"":    tls.VersionTLS10,
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+}
+
+// MinTLSVersion returns the MinVersion as one of the crypto/tls constants.
+func (cfg *AdapterTLS) MinTLSVersion() uint16 {
+	return tlsVersions[cfg.MinVersion]
+}
+
+// CategoryMapping translates the IAB categories on bids into the categories used by the host's ad server.
+type CategoryMapping struct {
+	// Filename points to a JSON object whose keys are IAB categories (e.g. "IAB1-5") and whose values
+	// are the ad server's categories. If empty, bids will keep their IAB categories.
+	Filename string `mapstructure:"filename"`
+}
+
+// LegacyAuction configures the legacy /auction endpoint.
+type LegacyAuction struct {
+	// OpenRTBBidders lists the bidder codes which should be served by the OpenRTB Exchange, rather than
+	// their legacy adapters. Their requests get translated into OpenRTB, and Stored Imps are merged in
+	// for any Ad Units whose config_id matches one.
+	OpenRTBBidders []string `mapstructure:"openrtb_bidders"`
+}
+
+func (cfg *LegacyAuction) validate(errs configErrors) configErrors {
+	for _, bidder := range cfg.OpenRTBBidders {
+		// "districtm" is a legacy alias for appnexus.
+		if _, ok := openrtb_ext.BidderMap[bidder]; !ok && bidder != "districtm" {
+			errs = append(errs, fmt.Errorf("legacy_auction.openrtb_bidders contains unknown bidder: %s", bidder))
+		}
+	}
+	return errs
+}
+
+// UsesOpenRTB returns true if the bidder code should be served by the OpenRTB Exchange.
+func (cfg *LegacyAuction) UsesOpenRTB(bidderCode string) bool {
+	for _, bidder := range cfg.OpenRTBBidders {
+		if bidder == bidderCode {
+			return true
+		}
+	}
+	return false
+}
+
+// CookieSync configures the /cookie_sync endpoint.
+type CookieSync struct {
+	// DefaultBidders lists the bidders which should be synced if the request doesn't define "bidders".
+	// If empty, every bidder with a Usersyncer will be synced.
+	DefaultBidders []string `mapstructure:"default_bidders"`
+}
+
+func (cfg *CookieSync) validate(errs configErrors) configErrors {
+	for _, bidder := range cfg.DefaultBidders {
+		if _, ok := openrtb_ext.BidderMap[bidder]; !ok {
+			errs = append(errs, fmt.Errorf("cookie_sync.default_bidders contains unknown bidder: %s", bidder))
+		}
+	}
+	return errs
+}
+
+// VASTModification configures the changes which are made to the VAST of video bids before it's cached.
+type VASTModification struct {
+	// Enabled adds an <Impression> tracker which calls the /event endpoint to the VAST of each video bid
+	// which gets cached. Bids whose VAST can't be parsed are rejected.
+	Enabled bool `mapstructure:"enabled"`
+	// Accounts lists extra trackers to add to the VAST, keyed by publisher ID.
+	// Viper lower-cases the keys, so these should be read with TrackersFor().
+	Accounts map[string]VASTTrackers `mapstructure:"accounts"`
+}
+
+// TrackersFor returns the third-party trackers for an account. Accounts which aren't configured don't have any.
+func (cfg *VASTModification) TrackersFor(account string) VASTTrackers {
+	return cfg.Accounts[strings.ToLower(account)]
+}
+
+// VASTTrackers are the URLs of an account's third-party trackers.
+type VASTTrackers struct {
+	Impression []string `mapstructure:"impression"`
+	Error      []string `mapstructure:"error"`
+}
+
+// CircuitBreaker configures the breakers which stop the exchange from calling bidders whose endpoints are failing.
+//
+// Each bidder's breaker looks at the outcome of its last WindowSize requests. Once at least MinRequests have been
+// made, and ErrorRate of them failed or timed out, the breaker opens and the bidder is skipped for OpenTime.
+// After that, ProbeRequests requests are let through. The breaker closes if they all succeed, and opens again if any fail.
+type CircuitBreaker struct {
+	Enabled       bool    `mapstructure:"enabled"`
+	WindowSize    int     `mapstructure:"window_size"`
+	MinRequests   int     `mapstructure:"min_requests"`
+	ErrorRate     float64 `mapstructure:"error_rate"`
+	OpenTime      int     `mapstructure:"open_time_ms"`
+	ProbeRequests int     `mapstructure:"probe_requests"`
+}
+
+func (cfg *CircuitBreaker) OpenTimeDuration() time.Duration {
+	return time.Duration(cfg.OpenTime) * time.Millisecond
+}
+
+func (cfg *CircuitBreaker) validate(errs configErrors) configErrors {
+	if !cfg.Enabled {
+		return errs
+	}
+	if cfg.WindowSize <= 0 {
+		errs = append(errs, fmt.Errorf("circuit_breaker.window_size must be > 0. Got %d", cfg.WindowSize))
+	}
+	if cfg.MinRequests <= 0 || cfg.MinRequests > cfg.WindowSize {
+		errs = append(errs, fmt.Errorf("circuit_breaker.min_requests must be > 0 and <= circuit_breaker.window_size. Got %d", cfg.MinRequests))
+	}
+	if cfg.ErrorRate <= 0 || cfg.ErrorRate > 1 {
+		errs = append(errs, fmt.Errorf("circuit_breaker.error_rate must be > 0 and <= 1. Got %f", cfg.ErrorRate))
+	}
+	if cfg.OpenTime <= 0 {
+		errs = append(errs, fmt.Errorf("circuit_breaker.open_time_ms must be > 0. Got %d", cfg.OpenTime))
+	}
+	if cfg.ProbeRequests <= 0 {
+		errs = append(errs, fmt.Errorf("circuit_breaker.probe_requests must be > 0. Got %d", cfg.ProbeRequests))
+	}
+	return errs
+}
+
+// Tracing configures the spans which PBS records for each auction.
+// Tracing is off unless an exporter is chosen.
+type Tracing struct {
+	// Exporter is where the finished spans get sent. It may be "none", "log" or "zipkin".
+	Exporter string `mapstructure:"exporter"`
+	// SampleRate is the fraction of new traces which get recorded. Requests which come in with a
+	// traceparent header follow the caller's sampling decision instead.
+	SampleRate float64 `mapstructure:"sample_rate"`
+	// PropagateToBidders adds a W3C traceparent header to the requests sent to bidders.
+	PropagateToBidders bool          `mapstructure:"propagate_to_bidders"`
+	Zipkin             ZipkinTracing `mapstructure:"zipkin"`
+}
+
+// ZipkinTracing configures the exporter which sends spans to a Zipkin compatible collector in batches.
+type ZipkinTracing struct {
+	// Endpoint is the collector's span API, e.g. http://localhost:9411/api/v2/spans
+	Endpoint    string `mapstructure:"endpoint"`
+	ServiceName string `mapstructure:"service_name"`
+	// BufferSize is the max number of spans which can wait to be sent. Spans beyond that are dropped.
+	BufferSize int `mapstructure:"buffer_size"`
+	// BatchSize is the max number of spans sent to the collector at once.
+	BatchSize int `mapstructure:"batch_size"`
+	// FlushInterval is the max time that a span will wait for its batch to fill up.
+	FlushInterval int `mapstructure:"flush_interval_ms"`
+	// Timeout is the max time to wait for the collector to accept a batch.
+	Timeout int `mapstructure:"timeout_ms"`
+}
+
+func (cfg *ZipkinTracing) FlushIntervalDuration() time.Duration {
+	return time.Duration(cfg.FlushInterval) * time.Millisecond
+}
+
+func (cfg *ZipkinTracing) TimeoutDuration() time.Duration {
+	return time.Duration(cfg.Timeout) * time.Millisecond
+}
+
+func (cfg *Tracing) validate(errs configErrors) configErrors {
+	switch cfg.Exporter {
+	case "", "none", "log":
+	case "zipkin":
+		errs = cfg.Zipkin.validate(errs)
+	default:
+		errs = append(errs, fmt.Errorf("tracing.exporter must be one of none, log or zipkin. Got %s", cfg.Exporter))
+	}
+	if cfg.SampleRate < 0 || cfg.SampleRate > 1 {
+		errs = append(errs, fmt.Errorf("tracing.sample_rate must be between 0 and 1. Got %f", cfg.SampleRate))
+	}
+	return errs
+}
+
+func (cfg *ZipkinTracing) validate(errs configErrors) configErrors {
+	if cfg.Endpoint == "" {
+		errs = append(errs, errors.New("tracing.zipkin.endpoint must be defined if tracing.exporter is zipkin"))
+	}
+	if cfg.BufferSize <= 0 {
+		errs = append(errs, fmt.Errorf("tracing.zipkin.buffer_size must be > 0. Got %d", cfg.BufferSize))
+	}
+	if cfg.BatchSize <= 0 {
+		errs = append(errs, fmt.Errorf("tracing.zipkin.batch_size must be > 0. Got %d", cfg.BatchSize))
+	}
+	if cfg.FlushInterval <= 0 {
+		errs = append(errs, fmt.Errorf("tracing.zipkin.flush_interval_ms must be > 0. Got %d", cfg.FlushInterval))
+	}
+	if cfg.Timeout <= 0 {
+		errs = append(errs, fmt.Errorf("tracing.zipkin.timeout_ms must be > 0. Got %d", cfg.Timeout))
+	}
+	return errs
 }
 
 type Metrics struct {
 	Influxdb   InfluxMetrics     `mapstructure:"influxdb"`
 	Prometheus PrometheusMetrics `mapstructure:"prometheus"`
+	Statsd     StatsdMetrics     `mapstructure:"statsd"`
+	Accounts   AccountMetrics    `mapstructure:"accounts"`
+}
+
+func (cfg *Metrics) validate(errs configErrors) configErrors {
+	errs = cfg.Statsd.validate(errs)
+	return cfg.Accounts.validate(errs)
+}
+
+// AccountMetrics configures the metrics which are broken down by publisher account.
+// Every account adds its own time series, so they're limited to the accounts in AllowList if it's defined,
+// or else the first FirstN accounts which PBS sees after it starts. The rest are grouped together under "other".
+//
+// FirstN doesn't rank the accounts by traffic: an account which only shows up after the limit is reached goes
+// into "other", no matter how many requests it sends. Hosts who need dashboards for specific publishers should
+// list them in AllowList.
+//
+// If these are disabled, Influx still gets metrics for every account unless InfluxMetrics.LegacyAccountMetrics
+// is turned off.
+type AccountMetrics struct {
+	Enabled   bool     `mapstructure:"enabled"`
+	AllowList []string `mapstructure:"allow_list"`
+	FirstN    int      `mapstructure:"first_n"`
+	// AdapterMetrics breaks the account metrics down further by bidder.
+	AdapterMetrics bool `mapstructure:"adapter_metrics"`
+}
+
+func (cfg *AccountMetrics) validate(errs configErrors) configErrors {
+	if cfg.Enabled && len(cfg.AllowList) == 0 && cfg.FirstN <= 0 {
+		errs = append(errs, fmt.Errorf("metrics.accounts.first_n must be > 0 if metrics.accounts.enabled is true and there is no metrics.accounts.allow_list. Got %d", cfg.FirstN))
+	}
+	return errs
 }
 
 type InfluxMetrics struct {
@@ -166,6 +703,9 @@ type InfluxMetrics struct {
 	Database string `mapstructure:"database"`
 	Username string `mapstructure:"username"`
 	Password string `mapstructure:"password"`
+	// LegacyAccountMetrics keeps the account.{id}.* metrics which Influx has always had, for every account and
+	// broken down by bidder, as long as metrics.accounts isn't enabled. Set it to false to drop them.
+	LegacyAccountMetrics bool `mapstructure:"legacy_account_metrics"`
 }
 
 type PrometheusMetrics struct {
@@ -175,6 +715,42 @@ type PrometheusMetrics struct {
 	Subsystem string `mapstructure:"subsystem"`
 }
 
+// StatsdMetrics configures a StatsD metrics engine, which sends metrics over UDP.
+// It's enabled if Host is defined.
+type StatsdMetrics struct {
+	// Host is the "host:port" of the StatsD agent.
+	Host   string `mapstructure:"host"`
+	Prefix string `mapstructure:"prefix"`
+	// SampleRate is the fraction of counts, timings and histograms which get sent. StatsD scales them back up.
+	SampleRate float64 `mapstructure:"sample_rate"`
+	// BufferSize is the max number of bytes in each UDP packet. Metrics are buffered until a packet is full,
+	// or until FlushInterval has passed.
+	BufferSize    int `mapstructure:"buffer_size"`
+	FlushInterval int `mapstructure:"flush_interval_ms"`
+	// DogStatsD sends the labels as DogStatsD tags. Otherwise they're appended to the metric names.
+	DogStatsD bool `mapstructure:"dogstatsd"`
+}
+
+func (cfg *StatsdMetrics) FlushIntervalDuration() time.Duration {
+	return time.Duration(cfg.FlushInterval) * time.Millisecond
+}
+
+func (cfg *StatsdMetrics) validate(errs configErrors) configErrors {
+	if cfg.Host == "" {
+		return errs
+	}
+	if cfg.SampleRate <= 0 || cfg.SampleRate > 1 {
+		errs = append(errs, fmt.Errorf("metrics.statsd.sample_rate must be > 0 and <= 1. Got %f", cfg.SampleRate))
+	}
+	if cfg.BufferSize <= 0 {
+		errs = append(errs, fmt.Errorf("metrics.statsd.buffer_size must be > 0. Got %d", cfg.BufferSize))
+	}
+	if cfg.FlushInterval <= 0 {
+		errs = append(errs, fmt.Errorf("metrics.statsd.flush_interval_ms must be > 0. Got %d", cfg.FlushInterval))
+	}
+	return errs
+}
+
 type DataCache struct {
 	Type       string `mapstructure:"type"`
 	Filename   string `mapstructure:"filename"`
@@ -263,15 +839,29 @@ func SetupViper(v *viper.Viper, filename string) {
 	v.SetDefault("host_cookie.optout_cookie.name", "")
 	v.SetDefault("host_cookie.value", "")
 	v.SetDefault("host_cookie.ttl_days", 90)
+	v.SetDefault("host_cookie.uid_cookie.sign", false)
+	v.SetDefault("host_cookie.uid_cookie.encrypt", false)
+	v.SetDefault("host_cookie.uid_cookie.reject_legacy", false)
 	// no metrics configured by default (metrics{host|database|username|password})
 	v.SetDefault("metrics.influxdb.host", "")
 	v.SetDefault("metrics.influxdb.database", "")
 	v.SetDefault("metrics.influxdb.username", "")
 	v.SetDefault("metrics.influxdb.password", "")
+	v.SetDefault("metrics.influxdb.legacy_account_metrics", true)
 	v.SetDefault("metrics.prometheus.endpoint", "")
 	v.SetDefault("metrics.prometheus.port", 0)
 	v.SetDefault("metrics.prometheus.namespace", "")
 	v.SetDefault("metrics.prometheus.subsystem", "")
+	v.SetDefault("metrics.statsd.host", "")
+	v.SetDefault("metrics.statsd.prefix", "prebidserver")
+	v.SetDefault("metrics.statsd.sample_rate", 1.0)
+	v.SetDefault("metrics.statsd.buffer_size", 1432)
+	v.SetDefault("metrics.statsd.flush_interval_ms", 1000)
+	v.SetDefault("metrics.statsd.dogstatsd", false)
+	v.SetDefault("metrics.accounts.enabled", false)
+	v.SetDefault("metrics.accounts.allow_list", []string{})
+	v.SetDefault("metrics.accounts.first_n", 100)
+	v.SetDefault("metrics.accounts.adapter_metrics", false)
 	v.SetDefault("datacache.type", "dummy")
 	v.SetDefault("datacache.filename", "")
 	v.SetDefault("datacache.cache_size", 0)
@@ -291,17 +881,37 @@ func SetupViper(v *viper.Viper, filename string) {
 	v.SetDefault("stored_requests.postgres.poll_for_updates.timeout_ms", 0)
 	v.SetDefault("stored_requests.postgres.poll_for_updates.query", "")
 	v.SetDefault("stored_requests.postgres.poll_for_updates.amp_query", "")
+	v.SetDefault("stored_requests.postgres.poll_for_updates.delete_query", "")
+	v.SetDefault("stored_requests.postgres.poll_for_updates.amp_delete_query", "")
+	v.SetDefault("stored_requests.postgres.poll_for_updates.request_refresh_rate_seconds", 0)
+	v.SetDefault("stored_requests.postgres.poll_for_updates.imp_refresh_rate_seconds", 0)
 	v.SetDefault("stored_requests.http.endpoint", "")
 	v.SetDefault("stored_requests.http.amp_endpoint", "")
 	v.SetDefault("stored_requests.in_memory_cache.type", "none")
 	v.SetDefault("stored_requests.in_memory_cache.ttl_seconds", 0)
 	v.SetDefault("stored_requests.in_memory_cache.request_cache_size_bytes", 0)
 	v.SetDefault("stored_requests.in_memory_cache.imp_cache_size_bytes", 0)
+	v.SetDefault("stored_requests.caching.refresh_after_seconds", 0)
+	v.SetDefault("stored_requests.caching.refresh_timeout_ms", 1000)
+	v.SetDefault("stored_requests.caching.not_found_ttl_seconds", 0)
 	v.SetDefault("stored_requests.cache_events_api", false)
+	v.SetDefault("stored_requests.admin_api.enabled", false)
+	v.SetDefault("stored_requests.admin_api.auth_token", "")
+	v.SetDefault("stored_requests.admin_api.timeout_ms", 1000)
+	v.SetDefault("stored_requests.admin_api.max_body_size_bytes", 1024*256)
 	v.SetDefault("stored_requests.http_events.endpoint", "")
 	v.SetDefault("stored_requests.http_events.amp_endpoint", "")
 	v.SetDefault("stored_requests.http_events.refresh_rate_seconds", 0)
 	v.SetDefault("stored_requests.http_events.timeout_ms", 0)
+	v.SetDefault("stored_requests.redis.connection.address", "")
+	v.SetDefault("stored_requests.redis.connection.password", "")
+	v.SetDefault("stored_requests.redis.connection.db", 0)
+	v.SetDefault("stored_requests.redis.connection.timeout_ms", 100)
+	v.SetDefault("stored_requests.redis.cache.enabled", false)
+	v.SetDefault("stored_requests.redis.cache.ttl_seconds", 0)
+	v.SetDefault("stored_requests.redis.cache.key_prefix", "stored_requests:")
+	v.SetDefault("stored_requests.redis.events.channel", "")
+	v.SetDefault("stored_requests.redis.events.amp_channel", "")
 
 	v.SetDefault("adapters.adtelligent.endpoint", "http://hb.adtelligent.com/auction")
 	v.SetDefault("adapters.adtelligent.usersync_url", "")
@@ -339,12 +949,55 @@ func SetupViper(v *viper.Viper, filename string) {
 	v.SetDefault("adapters.adkerneladn.endpoint", "http://{{.Host}}/rtbpub?account={{.PublisherID}}")
 
 	v.SetDefault("max_request_size", 1024*256)
+	v.SetDefault("max_video_pod_imps", 100)
+	v.SetDefault("category_mapping.filename", "")
+	v.SetDefault("legacy_auction.openrtb_bidders", []string{})
+	v.SetDefault("cookie_sync.default_bidders", []string{})
+	v.SetDefault("vast_modification.enabled", false)
+	v.SetDefault("circuit_breaker.enabled", false)
+	v.SetDefault("circuit_breaker.window_size", 100)
+	v.SetDefault("circuit_breaker.min_requests", 20)
+	v.SetDefault("circuit_breaker.error_rate", 0.5)
+	v.SetDefault("circuit_breaker.open_time_ms", 30000)
+	v.SetDefault("circuit_breaker.probe_requests", 5)
+	v.SetDefault("tracing.exporter", "none")
+	v.SetDefault("tracing.sample_rate", 1.0)
+	v.SetDefault("tracing.propagate_to_bidders", false)
+	v.SetDefault("tracing.zipkin.endpoint", "")
+	v.SetDefault("tracing.zipkin.service_name", "prebid-server")
+	v.SetDefault("tracing.zipkin.buffer_size", 10000)
+	v.SetDefault("tracing.zipkin.batch_size", 100)
+	v.SetDefault("tracing.zipkin.flush_interval_ms", 1000)
+	v.SetDefault("tracing.zipkin.timeout_ms", 1000)
 	v.SetDefault("analytics.file.filename", "")
+	v.SetDefault("analytics.stream.kafka.brokers", []string{})
+	v.SetDefault("analytics.stream.kafka.topic", "")
+	v.SetDefault("analytics.stream.kafka.timeout_ms", 1000)
+	v.SetDefault("analytics.stream.buffer_size", 10000)
+	v.SetDefault("analytics.stream.batch_size", 100)
+	v.SetDefault("analytics.stream.flush_interval_ms", 1000)
+	v.SetDefault("analytics.async.workers", 1)
+	v.SetDefault("analytics.async.queue_size", 1000)
+	v.SetDefault("analytics.async.shutdown_timeout_ms", 5000)
+	v.SetDefault("analytics.sampling.auction", 1.0)
+	v.SetDefault("analytics.sampling.amp", 1.0)
+	v.SetDefault("analytics.sampling.cookie_sync", 1.0)
+	v.SetDefault("analytics.sampling.setuid", 1.0)
+	v.SetDefault("analytics.sampling.event", 1.0)
 	v.SetDefault("amp_timeout_adjustment_ms", 0)
 	v.SetDefault("gdpr.host_vendor_id", 0)
 	v.SetDefault("gdpr.usersync_if_ambiguous", false)
 	v.SetDefault("gdpr.timeouts_ms.init_vendorlist_fetches", 0)
 	v.SetDefault("gdpr.timeouts_ms.active_vendorlist_fetch", 0)
+	v.SetDefault("uid_store.type", "none")
+	v.SetDefault("uid_store.timeout_ms", 50)
+	v.SetDefault("uid_store.memory_size_bytes", 0)
+	v.SetDefault("uid_store.postgres.connection.dbname", "")
+	v.SetDefault("uid_store.postgres.connection.host", "")
+	v.SetDefault("uid_store.postgres.connection.port", 0)
+	v.SetDefault("uid_store.postgres.connection.user", "")
+	v.SetDefault("uid_store.postgres.connection.password", "")
+	v.SetDefault("uid_store.postgres.table", "uids")
 
 	// Set environment variable support:
 	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
@@ -23,6 +23,8 @@ func TestDefaults(t *testing.T) {
 	cmpInts(t, "admin_port", cfg.AdminPort, 6060)
 	cmpInts(t, "auction_timeouts_ms.max", int(cfg.AuctionTimeouts.Max), 0)
 	cmpInts(t, "max_request_size", int(cfg.MaxRequestSize), 1024*256)
+	cmpInts(t, "max_video_pod_imps", cfg.MaxVideoPodImps, 100)
+	cmpBools(t, "metrics.influxdb.legacy_account_metrics", cfg.Metrics.Influxdb.LegacyAccountMetrics, true)
 	cmpInts(t, "host_cookie.ttl_days", int(cfg.HostCookie.TTL), 90)
 	cmpStrings(t, "datacache.type", cfg.DataCache.Type, "dummy")
 	cmpStrings(t, "adapters.pubmatic.endpoint", cfg.Adapters[string(openrtb_ext.BidderPubmatic)].Endpoint, "http://hbopenbid.pubmatic.com/translator?source=prebid-server")
@@ -64,6 +66,14 @@ datacache:
 adapters:
   appnexus:
     endpoint: http://ib.adnxs.com/some/endpoint
+    http_client:
+      max_conns: 200
+      max_idle_conns: 50
+      idle_conn_timeout_ms: 30000
+      http2: true
+      compress_requests: true
+      tls:
+        min_version: "1.2"
   audienceNetwork:
     endpoint: http://facebook.com/pbs
     usersync_url: http://facebook.com/ortb/prebid-s2s
@@ -81,6 +91,12 @@ adapters:
     endpoint: http://east-bid.ybp.yahoo.com/bid/appnexuspbs
   adkerneladn:
      usersync_url: https://tag.adkernel.com/syncr?gdpr={{gdpr}}&gdpr_consent={{gdpr_consent}}&r=
+vast_modification:
+  enabled: true
+  accounts:
+    Pub-1:
+      impression: ["http://tracker.com/imp"]
+      error: ["http://tracker.com/err"]
 `)
 
 func cmpStrings(t *testing.T, key string, a string, b string) {
@@ -141,6 +157,13 @@ func TestFullConfig(t *testing.T) {
 	cmpStrings(t, "", cfg.CacheURL.GetBaseURL(), "http://prebidcache.net")
 	cmpStrings(t, "", cfg.GetCachedAssetURL("a0eebc99-9c0b-4ef8-bb00-6bb9bd380a11"), "http://prebidcache.net/cache?uuid=a0eebc99-9c0b-4ef8-bb00-6bb9bd380a11")
 	cmpStrings(t, "adapters.appnexus.endpoint", cfg.Adapters[string(openrtb_ext.BidderAppnexus)].Endpoint, "http://ib.adnxs.com/some/endpoint")
+	appnexusClient := cfg.Adapters[string(openrtb_ext.BidderAppnexus)].HTTPClient
+	cmpInts(t, "adapters.appnexus.http_client.max_conns", appnexusClient.MaxConns, 200)
+	cmpInts(t, "adapters.appnexus.http_client.max_idle_conns", appnexusClient.MaxIdleConns, 50)
+	cmpInts(t, "adapters.appnexus.http_client.idle_conn_timeout_ms", appnexusClient.IdleConnTimeout, 30000)
+	cmpBools(t, "adapters.appnexus.http_client.http2", appnexusClient.HTTP2, true)
+	cmpBools(t, "adapters.appnexus.http_client.compress_requests", appnexusClient.CompressRequests, true)
+	cmpStrings(t, "adapters.appnexus.http_client.tls.min_version", appnexusClient.TLS.MinVersion, "1.2")
 	cmpStrings(t, "adapters.audiencenetwork.endpoint", cfg.Adapters[strings.ToLower(string(openrtb_ext.BidderFacebook))].Endpoint, "http://facebook.com/pbs")
 	cmpStrings(t, "adapters.audiencenetwork.usersync_url", cfg.Adapters[strings.ToLower(string(openrtb_ext.BidderFacebook))].UserSyncURL, "http://facebook.com/ortb/prebid-s2s")
 	cmpStrings(t, "adapters.audiencenetwork.platform_id", cfg.Adapters[strings.ToLower(string(openrtb_ext.BidderFacebook))].PlatformID, "abcdefgh1234")
@@ -152,6 +175,13 @@ func TestFullConfig(t *testing.T) {
 	cmpStrings(t, "adapters.brightroll.endpoint", cfg.Adapters[string(openrtb_ext.BidderBrightroll)].Endpoint, "http://east-bid.ybp.yahoo.com/bid/appnexuspbs")
 	cmpStrings(t, "adapters.brightroll.usersync_url", cfg.Adapters[string(openrtb_ext.BidderBrightroll)].UserSyncURL, "http://east-bid.ybp.yahoo.com/sync/appnexuspbs?gdpr={{gdpr}}&euconsent={{gdpr_consent}}&url=%s")
 	cmpStrings(t, "adapters.adkerneladn.usersync_url", cfg.Adapters[strings.ToLower(string(openrtb_ext.BidderAdkernelAdn))].UserSyncURL, "https://tag.adkernel.com/syncr?gdpr={{gdpr}}&gdpr_consent={{gdpr_consent}}&r=")
+	cmpBools(t, "vast_modification.enabled", cfg.VASTModification.Enabled, true)
+	if trackers := cfg.VASTModification.TrackersFor("Pub-1"); len(trackers.Impression) != 1 || trackers.Impression[0] != "http://tracker.com/imp" || len(trackers.Error) != 1 {
+		t.Errorf("vast_modification.accounts should be read regardless of case. Got %#v", trackers)
+	}
+	if trackers := cfg.VASTModification.TrackersFor("pub-2"); len(trackers.Impression) != 0 || len(trackers.Error) != 0 {
+		t.Errorf("Accounts without trackers shouldn't get any. Got %#v", trackers)
+	}
 }
 
 func TestValidConfig(t *testing.T) {
@@ -162,6 +192,7 @@ func TestValidConfig(t *testing.T) {
 				Type: "none",
 			},
 		},
+		MaxVideoPodImps: 100,
 	}
 
 	if err := cfg.validate(); err != nil {
@@ -169,6 +200,19 @@ func TestValidConfig(t *testing.T) {
 	}
 }
 
+// validConfig returns a Configuration which passes validation. Tests which check a single section
+// should start from it, so that errors from the other sections don't hide the ones they expect.
+func validConfig() Configuration {
+	return Configuration{
+		StoredRequests: StoredRequests{
+			InMemoryCache: InMemoryCache{
+				Type: "none",
+			},
+		},
+		MaxVideoPodImps: 100,
+	}
+}
+
 func TestNegativeRequestSize(t *testing.T) {
 	cfg := Configuration{
 		MaxRequestSize: -1,
@@ -179,6 +223,15 @@ func TestNegativeRequestSize(t *testing.T) {
 	}
 }
 
+func TestInvalidVideoPodImps(t *testing.T) {
+	cfg := validConfig()
+	cfg.MaxVideoPodImps = 0
+
+	if err := cfg.validate(); err == nil || !strings.Contains(err.Error(), "max_video_pod_imps") {
+		t.Errorf("cfg.max_video_pod_imps should require a positive value. Got %v", err)
+	}
+}
+
 func TestNegativeVendorID(t *testing.T) {
 	cfg := Configuration{
 		GDPR: GDPR{
@@ -203,6 +256,219 @@ func TestOverflowedVendorID(t *testing.T) {
 	}
 }
 
+func TestLegacyAuctionBidders(t *testing.T) {
+	cfg := validConfig()
+	cfg.LegacyAuction = LegacyAuction{
+		OpenRTBBidders: []string{"appnexus", "districtm"},
+	}
+	if err := cfg.validate(); err != nil {
+		t.Errorf("legacy_auction.openrtb_bidders should allow core bidders and districtm. %v", err)
+	}
+	if !cfg.LegacyAuction.UsesOpenRTB("districtm") {
+		t.Error("districtm should use OpenRTB")
+	}
+	if cfg.LegacyAuction.UsesOpenRTB("rubicon") {
+		t.Error("rubicon should not use OpenRTB")
+	}
+
+	cfg.LegacyAuction.OpenRTBBidders = []string{"unknown"}
+	if err := cfg.validate(); err == nil {
+		t.Error("legacy_auction.openrtb_bidders should prevent unknown bidders, but it doesn't")
+	}
+}
+
+func TestCookieSyncDefaultBidders(t *testing.T) {
+	cfg := validConfig()
+	cfg.CookieSync.DefaultBidders = []string{"appnexus", "rubicon"}
+	if err := cfg.validate(); err != nil {
+		t.Errorf("cookie_sync.default_bidders should allow core bidders. %v", err)
+	}
+
+	cfg.CookieSync.DefaultBidders = []string{"unknown"}
+	if err := cfg.validate(); err == nil {
+		t.Error("cookie_sync.default_bidders should prevent unknown bidders, but it doesn't")
+	}
+}
+
+func TestStreamAnalytics(t *testing.T) {
+	cfg := validConfig()
+	cfg.Analytics = Analytics{
+		Stream: StreamAnalytics{
+			Kafka: KafkaAnalytics{
+				Brokers: []string{"localhost:9092"},
+				Topic:   "pbs-analytics",
+				Timeout: 1000,
+			},
+			BufferSize:    100,
+			BatchSize:     10,
+			FlushInterval: 1000,
+		},
+	}
+	if err := cfg.validate(); err != nil {
+		t.Errorf("analytics.stream should be valid. %v", err)
+	}
+	if !cfg.Analytics.Stream.Enabled() {
+		t.Error("analytics.stream should be enabled if kafka brokers are defined")
+	}
+
+	cfg.Analytics.Stream.Kafka.Topic = ""
+	cfg.Analytics.Stream.BatchSize = 0
+	if err := cfg.validate(); len(err) != 2 {
+		t.Errorf("analytics.stream should require a topic and a positive batch_size. Got %v", err)
+	}
+
+	cfg.Analytics.Stream.Kafka.Brokers = nil
+	if err := cfg.validate(); err != nil {
+		t.Errorf("analytics.stream should not be validated unless it's enabled. %v", err)
+	}
+}
+
+func TestAnalyticsAsync(t *testing.T) {
+	cfg := validConfig()
+	cfg.Analytics = Analytics{
+		Async: AnalyticsAsync{Workers: 2, QueueSize: 100, ShutdownTimeout: 1000},
+		Sampling: AnalyticsSampling{
+			Auction: 0.5,
+			Amp:     1,
+		},
+	}
+	if err := cfg.validate(); err != nil {
+		t.Errorf("analytics.async and analytics.sampling should be valid. %v", err)
+	}
+
+	cfg.Analytics.Async.Workers = -1
+	cfg.Analytics.Sampling.SetUID = 1.5
+	if err := cfg.validate(); len(err) != 2 {
+		t.Errorf("analytics.async.workers must be non-negative, and sample rates must be between 0 and 1. Got %v", err)
+	}
+}
+
+func TestAccountMetrics(t *testing.T) {
+	cfg := validConfig()
+	cfg.Metrics = Metrics{
+		Accounts: AccountMetrics{Enabled: true, AllowList: []string{"pub-1"}},
+	}
+	if err := cfg.validate(); err != nil {
+		t.Errorf("metrics.accounts should be valid with an allow_list. %v", err)
+	}
+
+	cfg.Metrics.Accounts.AllowList = nil
+	if err := cfg.validate(); len(err) != 1 {
+		t.Errorf("metrics.accounts should need an allow_list or first_n. Got %v", err)
+	}
+
+	cfg.Metrics.Accounts.FirstN = 10
+	if err := cfg.validate(); err != nil {
+		t.Errorf("metrics.accounts should be valid with first_n. %v", err)
+	}
+}
+
+func TestStatsdMetrics(t *testing.T) {
+	cfg := validConfig()
+	cfg.Metrics = Metrics{
+		Statsd: StatsdMetrics{SampleRate: 2},
+	}
+	if err := cfg.validate(); err != nil {
+		t.Errorf("metrics.statsd should not be validated unless it's enabled. %v", err)
+	}
+
+	cfg.Metrics.Statsd.Host = "localhost:8125"
+	if err := cfg.validate(); len(err) != 3 {
+		t.Errorf("metrics.statsd should need a valid sample_rate, buffer_size and flush_interval_ms. Got %v", err)
+	}
+
+	cfg.Metrics.Statsd.SampleRate = 0.5
+	cfg.Metrics.Statsd.BufferSize = 1432
+	cfg.Metrics.Statsd.FlushInterval = 1000
+	if err := cfg.validate(); err != nil {
+		t.Errorf("metrics.statsd should be valid. %v", err)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	cfg := validConfig()
+	cfg.CircuitBreaker = CircuitBreaker{MinRequests: 5}
+	if err := cfg.validate(); err != nil {
+		t.Errorf("circuit_breaker should not be validated unless it's enabled. %v", err)
+	}
+
+	cfg.CircuitBreaker.Enabled = true
+	if err := cfg.validate(); len(err) != 5 {
+		t.Errorf("circuit_breaker should need a valid window_size, min_requests, error_rate, open_time_ms and probe_requests. Got %v", err)
+	}
+
+	cfg.CircuitBreaker = CircuitBreaker{
+		Enabled:       true,
+		WindowSize:    10,
+		MinRequests:   5,
+		ErrorRate:     0.5,
+		OpenTime:      1000,
+		ProbeRequests: 2,
+	}
+	if err := cfg.validate(); err != nil {
+		t.Errorf("circuit_breaker should be valid. %v", err)
+	}
+}
+
+func TestAdapterHTTPClient(t *testing.T) {
+	cfg := validConfig()
+	cfg.Adapters = map[string]Adapter{
+		"appnexus": {
+			HTTPClient: AdapterHTTPClient{
+				MaxConns:        -1,
+				MaxIdleConns:    -1,
+				IdleConnTimeout: -1,
+				TLS:             AdapterTLS{MinVersion: "1.3"},
+			},
+		},
+	}
+	if err := cfg.validate(); len(err) != 4 {
+		t.Errorf("adapters.appnexus.http_client should need a valid max_conns, max_idle_conns, idle_conn_timeout_ms and tls.min_version. Got %v", err)
+	}
+
+	cfg.Adapters["appnexus"] = Adapter{
+		HTTPClient: AdapterHTTPClient{
+			MaxConns:        100,
+			MaxIdleConns:    20,
+			IdleConnTimeout: 30000,
+			TLS:             AdapterTLS{MinVersion: "1.2"},
+		},
+	}
+	if err := cfg.validate(); err != nil {
+		t.Errorf("adapters.appnexus.http_client should be valid. %v", err)
+	}
+}
+
+func TestTracing(t *testing.T) {
+	cfg := validConfig()
+	cfg.Tracing = Tracing{Exporter: "jaeger", SampleRate: 1}
+	if err := cfg.validate(); len(err) != 1 {
+		t.Errorf("tracing.exporter should be validated. Got %v", err)
+	}
+
+	cfg.Tracing.Exporter = "zipkin"
+	if err := cfg.validate(); len(err) != 5 {
+		t.Errorf("tracing.zipkin should need an endpoint, buffer_size, batch_size, flush_interval_ms and timeout_ms. Got %v", err)
+	}
+
+	cfg.Tracing.SampleRate = 1.5
+	cfg.Tracing.Zipkin = ZipkinTracing{
+		Endpoint:      "http://localhost:9411/api/v2/spans",
+		BufferSize:    100,
+		BatchSize:     10,
+		FlushInterval: 1000,
+		Timeout:       1000,
+	}
+	if err := cfg.validate(); len(err) != 1 {
+		t.Errorf("tracing.sample_rate should be between 0 and 1. Got %v", err)
+	}
+
+	cfg.Tracing.SampleRate = 0.1
+	if err := cfg.validate(); err != nil {
+		t.Errorf("tracing should be valid. %v", err)
+	}
+}
+
 func TestLimitTimeout(t *testing.T) {
 	doTimeoutTest(t, 10, 15, 10, 0)
 	doTimeoutTest(t, 10, 0, 10, 0)
@@ -224,3 +490,91 @@ func doTimeoutTest(t *testing.T, expected int, requested int, max uint64, def ui
 		t.Errorf("Expected %dms timeout, got %dms", expectedDuration, limited/time.Millisecond)
 	}
 }
+
+func TestValidUIDCookie(t *testing.T) {
+	cfg := validConfig()
+	cfg.HostCookie = HostCookie{
+		UIDCookie: UIDCookie{
+			Sign:         true,
+			Encrypt:      true,
+			RejectLegacy: true,
+			Keys: []CookieKey{
+				{ID: "2018-06", Secret: "0123456789abcdef0123456789abcdef"},
+				{ID: "2018-05", Secret: "fedcba9876543210fedcba9876543210"},
+			},
+		},
+	}
+
+	if err := cfg.validate(); err != nil {
+		t.Errorf("Signed uids cookie config should work. %v", err)
+	}
+}
+
+func TestInvalidUIDCookie(t *testing.T) {
+	assertInvalidUIDCookie(t, "no keys", UIDCookie{Sign: true})
+	assertInvalidUIDCookie(t, "rejecting the cookies we write", UIDCookie{RejectLegacy: true})
+	assertInvalidUIDCookie(t, "empty key ID", UIDCookie{Sign: true, Keys: []CookieKey{{Secret: "0123456789abcdef0123456789abcdef"}}})
+	assertInvalidUIDCookie(t, "key ID with a separator", UIDCookie{Sign: true, Keys: []CookieKey{{ID: "a.b", Secret: "0123456789abcdef0123456789abcdef"}}})
+	assertInvalidUIDCookie(t, "short secret", UIDCookie{Sign: true, Keys: []CookieKey{{ID: "a", Secret: "tooshort"}}})
+	assertInvalidUIDCookie(t, "duplicate key IDs", UIDCookie{Sign: true, Keys: []CookieKey{
+		{ID: "a", Secret: "0123456789abcdef0123456789abcdef"},
+		{ID: "a", Secret: "fedcba9876543210fedcba9876543210"},
+	}})
+}
+
+func assertInvalidUIDCookie(t *testing.T, description string, uidCookie UIDCookie) {
+	t.Helper()
+	cfg := validConfig()
+	cfg.HostCookie.UIDCookie = uidCookie
+	if err := cfg.validate(); err == nil || !strings.Contains(err.Error(), "host_cookie.uid_cookie") {
+		t.Errorf("host_cookie.uid_cookie should be invalid with %s. Got %v", description, err)
+	}
+}
+
+func TestValidUIDStores(t *testing.T) {
+	cfg := validConfig()
+	cfg.HostCookie = HostCookie{
+		CookieName: "userid",
+	}
+	cfg.UIDStore = UIDStore{
+		Type:       "memory",
+		MemorySize: 1024 * 1024,
+	}
+	if err := cfg.validate(); err != nil {
+		t.Errorf("In-memory UID store config should work. %v", err)
+	}
+
+	cfg.UIDStore = UIDStore{
+		Type:    "postgres",
+		Timeout: 20,
+		Postgres: UIDStorePostgres{
+			ConnectionInfo: PostgresConnection{Database: "pbs"},
+			Table:          "public.uids",
+		},
+	}
+	if err := cfg.validate(); err != nil {
+		t.Errorf("Postgres UID store config should work. %v", err)
+	}
+}
+
+func TestInvalidUIDStores(t *testing.T) {
+	assertInvalidUIDStore(t, "an unknown type", "userid", UIDStore{Type: "redis"})
+	assertInvalidUIDStore(t, "no host cookie", "", UIDStore{Type: "memory", MemorySize: 1024})
+	assertInvalidUIDStore(t, "no memory limit", "userid", UIDStore{Type: "memory"})
+	assertInvalidUIDStore(t, "a negative timeout", "userid", UIDStore{Type: "memory", MemorySize: 1024, Timeout: -1})
+	assertInvalidUIDStore(t, "no database", "userid", UIDStore{Type: "postgres", Postgres: UIDStorePostgres{Table: "uids"}})
+	assertInvalidUIDStore(t, "a malicious table name", "userid", UIDStore{Type: "postgres", Postgres: UIDStorePostgres{
+		ConnectionInfo: PostgresConnection{Database: "pbs"},
+		Table:          "uids; DROP TABLE stored_requests",
+	}})
+}
+
+func assertInvalidUIDStore(t *testing.T, description string, cookieName string, uidStore UIDStore) {
+	t.Helper()
+	cfg := validConfig()
+	cfg.HostCookie.CookieName = cookieName
+	cfg.UIDStore = uidStore
+	if err := cfg.validate(); err == nil || !strings.Contains(err.Error(), "uid_store") {
+		t.Errorf("uid_store should be invalid with %s. Got %v", description, err)
+	}
+}
@@ -25,14 +25,78 @@ type StoredRequests struct {
 	// InMemoryCache configures an instance of stored_requests/caches/memory/cache.go.
 	// If non-nil, Stored Requests will be saved in an in-memory cache.
 	InMemoryCache InMemoryCache `mapstructure:"in_memory_cache"`
+	// Caching configures how the caches are used in front of the backends.
+	// See stored_requests.WithCache
+	Caching StoredRequestsCaching `mapstructure:"caching"`
 	// CacheEventsAPI configures an instance of stored_requests/events/api/api.go.
 	// If non-nil, Stored Request Caches can be updated or invalidated through API endpoints.
 	// This is intended to be a useful development tool and not recommended for a production environment.
-	// It should not be exposed to public networks without authentication.
+	// It should not be exposed to public networks without authentication. For production use, see AdminAPI.
 	CacheEventsAPI bool `mapstructure:"cache_events_api"`
+	// AdminAPI configures an instance of stored_requests/admin/admin.go on the admin server.
+	// If enabled, Stored Requests and Imps can be managed through authenticated API endpoints.
+	// Changes are saved to the filesystem or Postgres backend, and then pushed into the caches.
+	AdminAPI StoredRequestsAdminAPI `mapstructure:"admin_api"`
 	// HTTPEvents configures an instance of stored_requests/events/http/http.go.
 	// If non-nil, the server will use those endpoints to populate and update the cache.
 	HTTPEvents HTTPEventsConfig `mapstructure:"http_events"`
+	// Redis configures a Cache and EventProducers which are shared by every PBS instance through a Redis server.
+	// The Cache is in stored_requests/caches/redis
+	// EventProducers are in stored_requests/events/redis
+	Redis RedisConfig `mapstructure:"redis"`
+}
+
+// StoredRequestsCaching configures the way that stored_requests.WithCache uses the caches.
+type StoredRequestsCaching struct {
+	// RefreshAfter is the number of seconds after which cached data gets refreshed from the backends.
+	// The cached data is still used until the refresh finishes. Use 0 to never refresh.
+	// This should be shorter than the cache TTLs, or data will usually expire before it gets refreshed.
+	RefreshAfter int `mapstructure:"refresh_after_seconds"`
+	// RefreshTimeout is the amount of time allowed for each background refresh.
+	RefreshTimeout int `mapstructure:"refresh_timeout_ms"`
+	// NotFoundTTL is the number of seconds to remember IDs which the backends couldn't find.
+	// Until it expires, requests for them will fail without calling the backends. Use 0 to never remember them.
+	NotFoundTTL int `mapstructure:"not_found_ttl_seconds"`
+}
+
+func (cfg StoredRequestsCaching) RefreshAfterDuration() time.Duration {
+	return time.Duration(cfg.RefreshAfter) * time.Second
+}
+
+func (cfg StoredRequestsCaching) RefreshTimeoutDuration() time.Duration {
+	return time.Duration(cfg.RefreshTimeout) * time.Millisecond
+}
+
+func (cfg StoredRequestsCaching) NotFoundTTLDuration() time.Duration {
+	return time.Duration(cfg.NotFoundTTL) * time.Second
+}
+
+func (cfg *StoredRequestsCaching) validate(errs configErrors) configErrors {
+	if cfg.RefreshAfter < 0 {
+		errs = append(errs, fmt.Errorf("stored_requests.caching.refresh_after_seconds must be >= 0. Got %d", cfg.RefreshAfter))
+	}
+	if cfg.RefreshAfter > 0 && cfg.RefreshTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("stored_requests.caching.refresh_timeout_ms must be > 0 if stored_requests.caching.refresh_after_seconds is set. Got %d", cfg.RefreshTimeout))
+	}
+	if cfg.NotFoundTTL < 0 {
+		errs = append(errs, fmt.Errorf("stored_requests.caching.not_found_ttl_seconds must be >= 0. Got %d", cfg.NotFoundTTL))
+	}
+	return errs
+}
+
+// StoredRequestsAdminAPI configures stored_requests/admin/admin.go
+type StoredRequestsAdminAPI struct {
+	Enabled bool `mapstructure:"enabled"`
+	// AuthToken must be sent as "Authorization: Bearer {auth_token}" on every call to the admin API.
+	AuthToken string `mapstructure:"auth_token"`
+	// Timeout is the amount of time before a call to the backend is aborted.
+	Timeout int `mapstructure:"timeout_ms"`
+	// MaxBodySize is the largest request body, in bytes, which the admin API will read.
+	MaxBodySize int64 `mapstructure:"max_body_size_bytes"`
+}
+
+func (cfg StoredRequestsAdminAPI) TimeoutDuration() time.Duration {
+	return time.Duration(cfg.Timeout) * time.Millisecond
 }
 
 // HTTPEventsConfig configures stored_requests/events/http/http.go
@@ -58,7 +122,7 @@ type HTTPFetcherConfig struct {
 }
 
 func (cfg *StoredRequests) validate(errs configErrors) configErrors {
-	if cfg.InMemoryCache.Type == "none" {
+	if cfg.InMemoryCache.Type == "none" && !cfg.Redis.Cache.Enabled {
 		if cfg.CacheEventsAPI {
 			errs = append(errs, errors.New("stored_requests.cache_events_api must be false if stored_requests.in_memory_cache=none"))
 		}
@@ -73,12 +137,102 @@ func (cfg *StoredRequests) validate(errs configErrors) configErrors {
 		if cfg.Postgres.CacheInitialization.Query != "" {
 			errs = append(errs, errors.New("stored_requests.postgres.initialize_caches.query must be empty if stored_requests.in_memory_cache=none"))
 		}
+		if cfg.Redis.Events.Channel != "" || cfg.Redis.Events.AmpChannel != "" {
+			errs = append(errs, errors.New("stored_requests.redis.events must be empty if stored_requests.in_memory_cache=none and stored_requests.redis.cache.enabled=false"))
+		}
 	}
 	errs = cfg.InMemoryCache.validate(errs)
+	errs = cfg.Caching.validate(errs)
 	errs = cfg.Postgres.validate(errs)
+	errs = cfg.Redis.validate(errs)
+	errs = cfg.validateAdminAPI(errs)
+	return errs
+}
+
+func (cfg *StoredRequests) validateAdminAPI(errs configErrors) configErrors {
+	if !cfg.AdminAPI.Enabled {
+		return errs
+	}
+	if cfg.AdminAPI.AuthToken == "" {
+		errs = append(errs, errors.New("stored_requests.admin_api.auth_token must be set if stored_requests.admin_api.enabled=true"))
+	}
+	if cfg.AdminAPI.Timeout <= 0 {
+		errs = append(errs, errors.New("stored_requests.admin_api.timeout_ms must be > 0"))
+	}
+	if cfg.AdminAPI.MaxBodySize <= 0 {
+		errs = append(errs, fmt.Errorf("stored_requests.admin_api.max_body_size_bytes must be > 0. Got %d", cfg.AdminAPI.MaxBodySize))
+	}
+	hasPostgres := cfg.Postgres.ConnectionInfo.Database != ""
+	if cfg.Files == hasPostgres {
+		errs = append(errs, errors.New("stored_requests.admin_api requires exactly one of stored_requests.filesystem or stored_requests.postgres to save changes to"))
+	}
+	return errs
+}
+
+// RedisConfig configures the Stored Request ecosystem to share data through Redis.
+// The Cache lets every PBS instance reuse data which any of them has fetched, and the
+// EventProducers let a single update or invalidation reach every instance.
+type RedisConfig struct {
+	ConnectionInfo RedisConnection   `mapstructure:"connection"`
+	Cache          RedisCache        `mapstructure:"cache"`
+	Events         RedisEventsConfig `mapstructure:"events"`
+}
+
+func (cfg *RedisConfig) validate(errs configErrors) configErrors {
+	if cfg.ConnectionInfo.Address == "" {
+		if cfg.Cache.Enabled {
+			errs = append(errs, errors.New("stored_requests.redis.connection.address must be set if stored_requests.redis.cache.enabled=true"))
+		}
+		if cfg.Events.Channel != "" || cfg.Events.AmpChannel != "" {
+			errs = append(errs, errors.New("stored_requests.redis.connection.address must be set if stored_requests.redis.events are used"))
+		}
+		return errs
+	}
+	if cfg.ConnectionInfo.Timeout <= 0 {
+		errs = append(errs, fmt.Errorf("stored_requests.redis.connection.timeout_ms must be > 0. Got %d", cfg.ConnectionInfo.Timeout))
+	}
 	return errs
 }
 
+// RedisConnection has the info needed to connect to a Redis server.
+type RedisConnection struct {
+	// Address is the "host:port" of the Redis server.
+	Address  string `mapstructure:"address"`
+	Password string `mapstructure:"password"`
+	Database int    `mapstructure:"db"`
+	// Timeout is the amount of time allowed for each command sent to Redis.
+	Timeout int `mapstructure:"timeout_ms"`
+}
+
+func (cfg RedisConnection) TimeoutDuration() time.Duration {
+	return time.Duration(cfg.Timeout) * time.Millisecond
+}
+
+// RedisCache configures stored_requests/caches/redis/cache.go
+type RedisCache struct {
+	Enabled bool `mapstructure:"enabled"`
+	// TTL is the number of seconds before a value expires from Redis. TTL <= 0 can be used for "no ttl".
+	TTL int `mapstructure:"ttl_seconds"`
+	// KeyPrefix is prepended to every key, so that the cache can share a Redis DB with other data.
+	// AMP data is saved separately, under "{key_prefix}amp:".
+	KeyPrefix string `mapstructure:"key_prefix"`
+}
+
+func (cfg RedisCache) TTLDuration() time.Duration {
+	if cfg.TTL <= 0 {
+		return 0
+	}
+	return time.Duration(cfg.TTL) * time.Second
+}
+
+// RedisEventsConfig configures stored_requests/events/redis/redis.go
+type RedisEventsConfig struct {
+	// Channel is the pub/sub channel which carries updates to the Stored Requests used by /openrtb2/auction.
+	Channel string `mapstructure:"channel"`
+	// AmpChannel is the pub/sub channel which carries updates to the Stored Requests used by /openrtb2/amp.
+	AmpChannel string `mapstructure:"amp_channel"`
+}
+
 // PostgresConfig configures the Stored Request ecosystem to use Postgres. This must include a Fetcher,
 // and may optionally include some EventProducers to populate and refresh the caches.
 type PostgresConfig struct {
@@ -209,6 +363,11 @@ type PostgresUpdatePolling struct {
 	// RefreshRate determines how frequently the Query and AmpQuery are run.
 	RefreshRate int `mapstructure:"refresh_rate_seconds"`
 
+	// RequestRefreshRate and ImpRefreshRate override the RefreshRate for Stored Requests and Stored Imps.
+	// If either is 0, that type will use the RefreshRate.
+	RequestRefreshRate int `mapstructure:"request_refresh_rate_seconds"`
+	ImpRefreshRate     int `mapstructure:"imp_refresh_rate_seconds"`
+
 	// Timeout is the amount of time before a call to the database is aborted.
 	Timeout int `mapstructure:"timeout_ms"`
 
@@ -227,6 +386,35 @@ type PostgresUpdatePolling struct {
 
 	// AmpQuery is the same as Query, but used for the `/openrtb2/amp` endpoint.
 	AmpQuery string `mapstructure:"amp_query"`
+
+	// DeleteQuery is optional. It finds the IDs which have been deleted since the last poll, so that they can
+	// be invalidated. This is useful if the database soft-deletes rows, or logs deletes to a tombstone table.
+	// An example DeleteQuery is:
+	//
+	// SELECT id, type
+	//   FROM stored_data_deletes
+	//   WHERE deleted_at > $1
+	DeleteQuery string `mapstructure:"delete_query"`
+
+	// AmpDeleteQuery is the same as DeleteQuery, but used for the `/openrtb2/amp` endpoint.
+	AmpDeleteQuery string `mapstructure:"amp_delete_query"`
+}
+
+// RequestRefreshDuration is the time between polls for Stored Request updates.
+func (cfg *PostgresUpdatePolling) RequestRefreshDuration() time.Duration {
+	return cfg.refreshDuration(cfg.RequestRefreshRate)
+}
+
+// ImpRefreshDuration is the time between polls for Stored Imp updates.
+func (cfg *PostgresUpdatePolling) ImpRefreshDuration() time.Duration {
+	return cfg.refreshDuration(cfg.ImpRefreshRate)
+}
+
+func (cfg *PostgresUpdatePolling) refreshDuration(override int) time.Duration {
+	if override > 0 {
+		return time.Duration(override) * time.Second
+	}
+	return time.Duration(cfg.RefreshRate) * time.Second
 }
 
 func (cfg *PostgresUpdatePolling) validate(errs configErrors) configErrors {
@@ -248,6 +436,20 @@ func (cfg *PostgresUpdatePolling) validate(errs configErrors) configErrors {
 	if !strings.Contains(cfg.AmpQuery, "$1") || strings.Contains(cfg.AmpQuery, "$2") {
 		errs = append(errs, errors.New("stored_requests.postgres.poll_for_updates.amp_query must contain exactly one wildcard"))
 	}
+
+	if cfg.RequestRefreshRate < 0 {
+		errs = append(errs, errors.New("stored_requests.postgres.poll_for_updates.request_refresh_rate_seconds must be >= 0"))
+	}
+	if cfg.ImpRefreshRate < 0 {
+		errs = append(errs, errors.New("stored_requests.postgres.poll_for_updates.imp_refresh_rate_seconds must be >= 0"))
+	}
+
+	if cfg.DeleteQuery != "" && (!strings.Contains(cfg.DeleteQuery, "$1") || strings.Contains(cfg.DeleteQuery, "$2")) {
+		errs = append(errs, errors.New("stored_requests.postgres.poll_for_updates.delete_query must contain exactly one wildcard"))
+	}
+	if cfg.AmpDeleteQuery != "" && (!strings.Contains(cfg.AmpDeleteQuery, "$1") || strings.Contains(cfg.AmpDeleteQuery, "$2")) {
+		errs = append(errs, errors.New("stored_requests.postgres.poll_for_updates.amp_delete_query must contain exactly one wildcard"))
+	}
 	return errs
 }
 
@@ -4,6 +4,7 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 const sampleQueryTemplate = "SELECT id, requestData, 'request' as type FROM stored_requests WHERE id in %REQUEST_ID_LIST% UNION ALL SELECT id, impData, 'imp' as type FROM stored_requests WHERE id in %IMP_ID_LIST%"
@@ -114,6 +115,175 @@ func TestInMemoryCacheValidation(t *testing.T) {
 	}).validate(nil))
 }
 
+func TestAdminAPIValidation(t *testing.T) {
+	validAPI := StoredRequestsAdminAPI{
+		Enabled:     true,
+		AuthToken:   "secret",
+		Timeout:     1000,
+		MaxBodySize: 1024,
+	}
+	assertNoErrs(t, (&StoredRequests{}).validateAdminAPI(nil))
+	assertNoErrs(t, (&StoredRequests{
+		Files:    true,
+		AdminAPI: validAPI,
+	}).validateAdminAPI(nil))
+	assertNoErrs(t, (&StoredRequests{
+		Postgres: PostgresConfig{ConnectionInfo: PostgresConnection{Database: "pbs"}},
+		AdminAPI: validAPI,
+	}).validateAdminAPI(nil))
+	assertErrsExist(t, (&StoredRequests{
+		AdminAPI: validAPI,
+	}).validateAdminAPI(nil))
+	assertErrsExist(t, (&StoredRequests{
+		Files:    true,
+		Postgres: PostgresConfig{ConnectionInfo: PostgresConnection{Database: "pbs"}},
+		AdminAPI: validAPI,
+	}).validateAdminAPI(nil))
+	assertErrsExist(t, (&StoredRequests{
+		Files: true,
+		AdminAPI: StoredRequestsAdminAPI{
+			Enabled: true,
+			Timeout: 1000,
+		},
+	}).validateAdminAPI(nil))
+	assertErrsExist(t, (&StoredRequests{
+		Files: true,
+		AdminAPI: StoredRequestsAdminAPI{
+			Enabled:   true,
+			AuthToken: "secret",
+		},
+	}).validateAdminAPI(nil))
+	assertErrsExist(t, (&StoredRequests{
+		Files: true,
+		AdminAPI: StoredRequestsAdminAPI{
+			Enabled:   true,
+			AuthToken: "secret",
+			Timeout:   1000,
+		},
+	}).validateAdminAPI(nil))
+}
+
+func TestRedisValidation(t *testing.T) {
+	connection := RedisConnection{
+		Address: "localhost:6379",
+		Timeout: 100,
+	}
+	assertNoErrs(t, (&RedisConfig{}).validate(nil))
+	assertNoErrs(t, (&RedisConfig{
+		ConnectionInfo: connection,
+		Cache:          RedisCache{Enabled: true},
+		Events:         RedisEventsConfig{Channel: "updates"},
+	}).validate(nil))
+	assertErrsExist(t, (&RedisConfig{
+		Cache: RedisCache{Enabled: true},
+	}).validate(nil))
+	assertErrsExist(t, (&RedisConfig{
+		Events: RedisEventsConfig{AmpChannel: "amp-updates"},
+	}).validate(nil))
+	assertErrsExist(t, (&RedisConfig{
+		ConnectionInfo: RedisConnection{Address: "localhost:6379"},
+	}).validate(nil))
+}
+
+func TestRedisEventsNeedCache(t *testing.T) {
+	connection := RedisConnection{
+		Address: "localhost:6379",
+		Timeout: 100,
+	}
+	assertErrsExist(t, (&StoredRequests{
+		InMemoryCache: InMemoryCache{Type: "none"},
+		Redis: RedisConfig{
+			ConnectionInfo: connection,
+			Events:         RedisEventsConfig{Channel: "updates"},
+		},
+	}).validate(nil))
+	assertNoErrs(t, (&StoredRequests{
+		InMemoryCache: InMemoryCache{Type: "none"},
+		Redis: RedisConfig{
+			ConnectionInfo: connection,
+			Cache:          RedisCache{Enabled: true},
+			Events:         RedisEventsConfig{Channel: "updates"},
+		},
+	}).validate(nil))
+}
+
+func TestRedisTTL(t *testing.T) {
+	if ttl := (RedisCache{TTL: 60}).TTLDuration(); ttl != time.Minute {
+		t.Errorf("Expected a TTL of 1 minute. Got %v", ttl)
+	}
+	if ttl := (RedisCache{TTL: -1}).TTLDuration(); ttl != 0 {
+		t.Errorf("Negative TTLs should mean no expiration. Got %v", ttl)
+	}
+}
+
+func TestCachingValidation(t *testing.T) {
+	assertNoErrs(t, (&StoredRequestsCaching{}).validate(nil))
+	assertNoErrs(t, (&StoredRequestsCaching{
+		RefreshAfter:   60,
+		RefreshTimeout: 1000,
+		NotFoundTTL:    30,
+	}).validate(nil))
+	assertErrsExist(t, (&StoredRequestsCaching{
+		RefreshAfter: -1,
+	}).validate(nil))
+	assertErrsExist(t, (&StoredRequestsCaching{
+		RefreshAfter: 60,
+	}).validate(nil))
+	assertErrsExist(t, (&StoredRequestsCaching{
+		NotFoundTTL: -1,
+	}).validate(nil))
+}
+
+func TestCachingDurations(t *testing.T) {
+	cfg := StoredRequestsCaching{
+		RefreshAfter:   60,
+		RefreshTimeout: 500,
+		NotFoundTTL:    30,
+	}
+	if refreshAfter := cfg.RefreshAfterDuration(); refreshAfter != time.Minute {
+		t.Errorf("Expected a refresh after 1 minute. Got %v", refreshAfter)
+	}
+	if timeout := cfg.RefreshTimeoutDuration(); timeout != 500*time.Millisecond {
+		t.Errorf("Expected a refresh timeout of 500ms. Got %v", timeout)
+	}
+	if ttl := cfg.NotFoundTTLDuration(); ttl != 30*time.Second {
+		t.Errorf("Expected a NotFound TTL of 30 seconds. Got %v", ttl)
+	}
+}
+
+func TestPollingValidation(t *testing.T) {
+	valid := PostgresUpdatePolling{
+		RefreshRate:        60,
+		RequestRefreshRate: 10,
+		Timeout:            100,
+		Query:              "SELECT id, data, type FROM stored_data WHERE last_updated > $1",
+		AmpQuery:           "SELECT id, data, type FROM stored_amp_data WHERE last_updated > $1",
+		DeleteQuery:        "SELECT id, type FROM stored_data_deletes WHERE deleted_at > $1",
+	}
+	assertNoErrs(t, valid.validate(nil))
+
+	negativeRate := valid
+	negativeRate.ImpRefreshRate = -1
+	assertErrsExist(t, negativeRate.validate(nil))
+
+	badDelete := valid
+	badDelete.AmpDeleteQuery = "SELECT id, type FROM stored_amp_data_deletes"
+	assertErrsExist(t, badDelete.validate(nil))
+}
+
+func TestPollingDurations(t *testing.T) {
+	cfg := PostgresUpdatePolling{
+		RefreshRate:        60,
+		RequestRefreshRate: 10,
+	}
+	if rate := cfg.RequestRefreshDuration(); rate != 10*time.Second {
+		t.Errorf("The request_refresh_rate_seconds should override the refresh_rate_seconds. Got %v", rate)
+	}
+	if rate := cfg.ImpRefreshDuration(); rate != time.Minute {
+		t.Errorf("Stored Imps should use the refresh_rate_seconds by default. Got %v", rate)
+	}
+}
+
 func assertErrsExist(t *testing.T, err configErrors) {
 	t.Helper()
 	if len(err) == 0 {
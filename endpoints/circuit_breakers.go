@@ -0,0 +1,25 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/golang/glog"
+	"github.com/prebid/prebid-server/exchange"
+)
+
+// NewCircuitBreakersEndpoint returns the state of each bidder's circuit breaker.
+// If circuit breakers aren't enabled, it returns an empty object.
+func NewCircuitBreakersEndpoint(breakers *exchange.CircuitBreakers) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jsonOutput, err := json.Marshal(breakers.Status())
+		if err != nil {
+			glog.Errorf("/circuit_breakers Critical error when trying to marshal the circuit breaker status: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonOutput)
+	}
+}
@@ -0,0 +1,46 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prebid/prebid-server/config"
+	"github.com/prebid/prebid-server/exchange"
+	"github.com/prebid/prebid-server/openrtb_ext"
+	"github.com/prebid/prebid-server/pbsmetrics"
+	metricsConf "github.com/prebid/prebid-server/pbsmetrics/config"
+)
+
+func TestCircuitBreakersDisabled(t *testing.T) {
+	handler := NewCircuitBreakersEndpoint(nil)
+	w := httptest.NewRecorder()
+	handler(w, nil)
+
+	if w.Body.String() != "{}" {
+		t.Errorf("Expected an empty object when circuit breakers are disabled. Got %s", w.Body.String())
+	}
+}
+
+func TestCircuitBreakers(t *testing.T) {
+	cfg := config.CircuitBreaker{
+		Enabled:       true,
+		WindowSize:    10,
+		MinRequests:   5,
+		ErrorRate:     0.5,
+		OpenTime:      1000,
+		ProbeRequests: 1,
+	}
+	breakers := exchange.NewCircuitBreakers(cfg, []openrtb_ext.BidderName{openrtb_ext.BidderAppnexus}, &metricsConf.DummyMetricsEngine{})
+	handler := NewCircuitBreakersEndpoint(breakers)
+	w := httptest.NewRecorder()
+	handler(w, nil)
+
+	var result map[openrtb_ext.BidderName]exchange.CircuitBreakerStatus
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatalf("Bad response body: %v", err)
+	}
+	if len(result) != 1 || result[openrtb_ext.BidderAppnexus].State != pbsmetrics.CircuitBreakerClosed {
+		t.Errorf("Expected a closed breaker for appnexus. Got %v", result)
+	}
+}
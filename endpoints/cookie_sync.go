@@ -20,14 +20,16 @@ import (
 	"github.com/prebid/prebid-server/usersync"
 )
 
-func NewCookieSyncEndpoint(syncers map[openrtb_ext.BidderName]usersync.Usersyncer, cfg *config.Configuration, syncPermissions gdpr.Permissions, metrics pbsmetrics.MetricsEngine, pbsAnalytics analytics.PBSAnalyticsModule) httprouter.Handle {
+func NewCookieSyncEndpoint(syncers map[openrtb_ext.BidderName]usersync.Usersyncer, cfg *config.Configuration, syncPermissions gdpr.Permissions, metrics pbsmetrics.MetricsEngine, pbsAnalytics analytics.PBSAnalyticsModule, uidStore *usersync.UIDStoreClient) httprouter.Handle {
 	deps := &cookieSyncDeps{
 		syncers:         syncers,
 		hostCookie:      &cfg.HostCookie,
 		gDPR:            &cfg.GDPR,
+		defaultBidders:  cfg.CookieSync.DefaultBidders,
 		syncPermissions: syncPermissions,
 		metrics:         metrics,
 		pbsAnalytics:    pbsAnalytics,
+		uidStore:        uidStore,
 	}
 	return deps.Endpoint
 }
@@ -36,9 +38,11 @@ type cookieSyncDeps struct {
 	syncers         map[openrtb_ext.BidderName]usersync.Usersyncer
 	hostCookie      *config.HostCookie
 	gDPR            *config.GDPR
+	defaultBidders  []string
 	syncPermissions gdpr.Permissions
 	metrics         pbsmetrics.MetricsEngine
 	pbsAnalytics    analytics.PBSAnalyticsModule
+	uidStore        *usersync.UIDStoreClient
 }
 
 func (deps *cookieSyncDeps) Endpoint(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
@@ -52,7 +56,7 @@ func (deps *cookieSyncDeps) Endpoint(w http.ResponseWriter, r *http.Request, _ h
 	defer deps.pbsAnalytics.LogCookieSyncObject(&co)
 
 	deps.metrics.RecordCookieSync(pbsmetrics.Labels{})
-	userSyncCookie := usersync.ParsePBSCookieFromRequest(r, deps.hostCookie)
+	userSyncCookie := deps.uidStore.ParsePBSCookieFromRequest(r, deps.hostCookie)
 	if !userSyncCookie.AllowSyncs() {
 		http.Error(w, "User has opted out", http.StatusUnauthorized)
 		co.Status = http.StatusUnauthorized
@@ -100,9 +104,13 @@ func (deps *cookieSyncDeps) Endpoint(w http.ResponseWriter, r *http.Request, _ h
 	}
 
 	if len(biddersJSON) == 0 {
-		parsedReq.Bidders = make([]string, 0, len(deps.syncers))
-		for bidder := range deps.syncers {
-			parsedReq.Bidders = append(parsedReq.Bidders, string(bidder))
+		if len(deps.defaultBidders) > 0 {
+			parsedReq.Bidders = append(make([]string, 0, len(deps.defaultBidders)), deps.defaultBidders...)
+		} else {
+			parsedReq.Bidders = make([]string, 0, len(deps.syncers))
+			for bidder := range deps.syncers {
+				parsedReq.Bidders = append(parsedReq.Bidders, string(bidder))
+			}
 		}
 	}
 
@@ -84,6 +84,24 @@ func TestCookieSyncNoBidders(t *testing.T) {
 	assertStatus(t, rr.Body.Bytes(), "no_cookie")
 }
 
+// Make sure that the host's default bidders are synced if "bidders" isn't a key
+func TestCookieSyncDefaultBidders(t *testing.T) {
+	endpoint := NewCookieSyncEndpoint(syncersForTest(), &config.Configuration{CookieSync: config.CookieSync{DefaultBidders: []string{"appnexus", "pubmatic"}}}, mockPermissions(true, syncersForTest()), &metricsConf.DummyMetricsEngine{}, analyticsConf.NewPBSAnalytics(&config.Analytics{}), nil)
+
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/cookie_sync", strings.NewReader("{}"))
+	endpoint(rr, req, nil)
+	assertIntsMatch(t, http.StatusOK, rr.Code)
+	assertSyncsExist(t, rr.Body.Bytes(), "appnexus", "pubmatic")
+	assertStatus(t, rr.Body.Bytes(), "no_cookie")
+
+	rr = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/cookie_sync", strings.NewReader(`{"bidders":["lifestreet"]}`))
+	endpoint(rr, req, nil)
+	assertIntsMatch(t, http.StatusOK, rr.Code)
+	assertSyncsExist(t, rr.Body.Bytes(), "lifestreet")
+}
+
 func TestCookieSyncNoCookiesBrokenGDPR(t *testing.T) {
 	rr := doConfigurablePost(`{"bidders":["appnexus", "audienceNetwork", "random"],"gdpr_consent":"GLKHGKGKKGK"}`, nil, true, map[openrtb_ext.BidderName]usersync.Usersyncer{}, config.GDPR{UsersyncIfAmbiguous: true})
 	assertIntsMatch(t, http.StatusOK, rr.Code)
@@ -114,7 +132,7 @@ func doConfigurablePost(body string, existingSyncs map[string]string, gdprHostCo
 }
 
 func testableEndpoint(perms gdpr.Permissions, cfgGDPR config.GDPR) httprouter.Handle {
-	return NewCookieSyncEndpoint(syncersForTest(), &config.Configuration{GDPR: cfgGDPR}, perms, &metricsConf.DummyMetricsEngine{}, analyticsConf.NewPBSAnalytics(&config.Analytics{}))
+	return NewCookieSyncEndpoint(syncersForTest(), &config.Configuration{GDPR: cfgGDPR}, perms, &metricsConf.DummyMetricsEngine{}, analyticsConf.NewPBSAnalytics(&config.Analytics{}), nil)
 }
 
 func syncersForTest() map[openrtb_ext.BidderName]usersync.Usersyncer {
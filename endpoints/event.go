@@ -0,0 +1,51 @@
+package endpoints
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/prebid/prebid-server/analytics"
+	"github.com/prebid/prebid-server/pbsmetrics"
+)
+
+// NewEventEndpoint handles the win and impression notifications for bids from requests which had ext.prebid.events.
+// The exchange builds the URLs which call it, so the query params here must match the ones it uses.
+func NewEventEndpoint(pbsanalytics analytics.PBSAnalyticsModule, metrics pbsmetrics.MetricsEngine) httprouter.Handle {
+	return httprouter.Handle(func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		eo := analytics.EventObject{
+			Status: http.StatusNoContent,
+			Errors: make([]error, 0),
+		}
+
+		defer pbsanalytics.LogEventObject(&eo)
+
+		query := r.URL.Query()
+		eo.Type = query.Get("t")
+		eo.BidID = query.Get("b")
+		eo.Bidder = query.Get("bidder")
+		eo.Account = query.Get("a")
+
+		var err error
+		if eo.Type != string(pbsmetrics.EventWin) && eo.Type != string(pbsmetrics.EventImp) {
+			err = fmt.Errorf(`"t" query param must be "%s" or "%s"`, pbsmetrics.EventWin, pbsmetrics.EventImp)
+		} else if eo.BidID == "" {
+			err = fmt.Errorf(`"b" query param is required`)
+		} else if ts := query.Get("ts"); ts != "" {
+			if eo.AuctionTimestamp, err = strconv.ParseInt(ts, 10, 64); err != nil {
+				err = fmt.Errorf(`"ts" query param must be an integer. Got %s`, ts)
+			}
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			eo.Status = http.StatusBadRequest
+			eo.Errors = append(eo.Errors, err)
+			return
+		}
+
+		metrics.RecordEvent(pbsmetrics.EventType(eo.Type))
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,92 @@
+package endpoints
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/prebid/prebid-server/analytics"
+	"github.com/prebid/prebid-server/config"
+	"github.com/prebid/prebid-server/openrtb_ext"
+	"github.com/prebid/prebid-server/pbsmetrics"
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestWinEvent(t *testing.T) {
+	logger, metricsEngine, resp := doEventRequest("/event?t=win&b=bid-id&bidder=appnexus&a=pub&ts=1000")
+
+	if resp.Code != http.StatusNoContent {
+		t.Errorf("Expected a 204. Got %d: %s", resp.Code, resp.Body.String())
+	}
+	expected := analytics.EventObject{
+		Status:           http.StatusNoContent,
+		Errors:           []error{},
+		Type:             "win",
+		BidID:            "bid-id",
+		Bidder:           "appnexus",
+		Account:          "pub",
+		AuctionTimestamp: 1000,
+	}
+	if logger.event == nil || !reflect.DeepEqual(*logger.event, expected) {
+		t.Errorf("Bad analytics event. Expected %#v, got %#v", expected, logger.event)
+	}
+	if wins := metricsEngine.EventMeters[pbsmetrics.EventWin].Count(); wins != 1 {
+		t.Errorf("The win should be counted. Got %d", wins)
+	}
+}
+
+func TestImpEventWithoutOptionalParams(t *testing.T) {
+	_, metricsEngine, resp := doEventRequest("/event?t=imp&b=bid-id")
+
+	if resp.Code != http.StatusNoContent {
+		t.Errorf("Expected a 204. Got %d: %s", resp.Code, resp.Body.String())
+	}
+	if imps := metricsEngine.EventMeters[pbsmetrics.EventImp].Count(); imps != 1 {
+		t.Errorf("The impression should be counted. Got %d", imps)
+	}
+}
+
+func TestBadEvents(t *testing.T) {
+	for _, uri := range []string{
+		"/event?b=bid-id",
+		"/event?t=click&b=bid-id",
+		"/event?t=win",
+		"/event?t=win&b=bid-id&ts=yesterday",
+	} {
+		logger, metricsEngine, resp := doEventRequest(uri)
+		if resp.Code != http.StatusBadRequest {
+			t.Errorf("%s should be rejected. Got %d", uri, resp.Code)
+		}
+		if logger.event == nil || logger.event.Status != http.StatusBadRequest || len(logger.event.Errors) != 1 {
+			t.Errorf("%s should be logged as an error. Got %#v", uri, logger.event)
+		}
+		if wins := metricsEngine.EventMeters[pbsmetrics.EventWin].Count(); wins != 0 {
+			t.Errorf("%s shouldn't be counted. Got %d wins", uri, wins)
+		}
+	}
+}
+
+func doEventRequest(uri string) (*eventLogger, *pbsmetrics.Metrics, *httptest.ResponseRecorder) {
+	logger := &eventLogger{}
+	metricsEngine := pbsmetrics.NewMetrics(metrics.NewRegistry(), openrtb_ext.BidderList(), config.AccountMetrics{})
+	endpoint := NewEventEndpoint(logger, metricsEngine)
+	resp := httptest.NewRecorder()
+	endpoint(resp, httptest.NewRequest("GET", uri, nil), nil)
+	return logger, metricsEngine, resp
+}
+
+// eventLogger is a PBSAnalyticsModule which remembers the last EventObject it logged.
+type eventLogger struct {
+	event *analytics.EventObject
+}
+
+func (l *eventLogger) LogAuctionObject(ao *analytics.AuctionObject) {}
+
+func (l *eventLogger) LogCookieSyncObject(cso *analytics.CookieSyncObject) {}
+
+func (l *eventLogger) LogSetUIDObject(so *analytics.SetUIDObject) {}
+
+func (l *eventLogger) LogAmpObject(ao *analytics.AmpObject) {}
+
+func (l *eventLogger) LogEventObject(eo *analytics.EventObject) { l.event = eo }
@@ -20,6 +20,7 @@ import (
 	"github.com/prebid/prebid-server/openrtb_ext"
 	"github.com/prebid/prebid-server/pbsmetrics"
 	"github.com/prebid/prebid-server/stored_requests"
+	"github.com/prebid/prebid-server/tracing"
 	"github.com/prebid/prebid-server/usersync"
 )
 
@@ -33,12 +34,12 @@ type AmpResponse struct {
 
 // NewAmpEndpoint modifies the OpenRTB endpoint to handle AMP requests. This will basically modify the parsing
 // of the request, and the return value, using the OpenRTB machinery to handle everything inbetween.
-func NewAmpEndpoint(ex exchange.Exchange, validator openrtb_ext.BidderParamValidator, requestsById stored_requests.Fetcher, cfg *config.Configuration, met pbsmetrics.MetricsEngine, pbsAnalytics analytics.PBSAnalyticsModule) (httprouter.Handle, error) {
+func NewAmpEndpoint(ex exchange.Exchange, validator openrtb_ext.BidderParamValidator, requestsById stored_requests.Fetcher, cfg *config.Configuration, met pbsmetrics.MetricsEngine, pbsAnalytics analytics.PBSAnalyticsModule, uidStore *usersync.UIDStoreClient) (httprouter.Handle, error) {
 	if ex == nil || validator == nil || requestsById == nil || cfg == nil || met == nil {
 		return nil, errors.New("NewAmpEndpoint requires non-nil arguments.")
 	}
 
-	return httprouter.Handle((&endpointDeps{ex, validator, requestsById, cfg, met, pbsAnalytics}).AmpAuction), nil
+	return httprouter.Handle((&endpointDeps{ex, validator, requestsById, cfg, met, pbsAnalytics, uidStore}).AmpAuction), nil
 }
 
 func (deps *endpointDeps) AmpAuction(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
@@ -66,11 +67,17 @@ func (deps *endpointDeps) AmpAuction(w http.ResponseWriter, r *http.Request, _ h
 		CookieFlag:    pbsmetrics.CookieFlagUnknown,
 		RequestStatus: pbsmetrics.RequestStatusOK,
 	}
+	phases := make(phaseTimes)
+	var timeout time.Duration
+	spanCtx, span := tracing.StartSpan(tracing.Extract(context.Background(), r.Header), "openrtb2.amp")
 	defer func() {
 		deps.metricsEngine.RecordRequest(labels)
 		deps.metricsEngine.RecordImps(labels, 1)
 		deps.metricsEngine.RecordRequestTime(labels, time.Since(start))
+		phases.record(deps.metricsEngine, labels)
+		recordTMaxExceeded(deps.metricsEngine, labels, start, timeout)
 		deps.analytics.LogAmpObject(&ao)
+		endRequestSpan(span, labels)
 	}()
 
 	isSafari := checkSafari(r)
@@ -91,7 +98,10 @@ func (deps *endpointDeps) AmpAuction(w http.ResponseWriter, r *http.Request, _ h
 	w.Header().Set("AMP-Access-Control-Allow-Source-Origin", origin)
 	w.Header().Set("Access-Control-Expose-Headers", "AMP-Access-Control-Allow-Source-Origin")
 
-	req, errL := deps.parseAmpRequest(r)
+	parseStart := time.Now()
+	req, errL := deps.parseAmpRequest(spanCtx, r, phases)
+	// The Stored Request fetch has a phase of its own, so it doesn't count towards the parse time.
+	phases[pbsmetrics.PhaseParse] = time.Since(parseStart) - phases[pbsmetrics.PhaseStoredRequests]
 
 	if len(errL) > 0 {
 		w.WriteHeader(http.StatusBadRequest)
@@ -103,16 +113,18 @@ func (deps *endpointDeps) AmpAuction(w http.ResponseWriter, r *http.Request, _ h
 		return
 	}
 
-	ctx := context.Background()
-	cancel := func() {}
+	if req.Site != nil && req.Site.Publisher != nil {
+		labels.PubID = req.Site.Publisher.ID
+	}
+
+	timeout = time.Duration(defaultAmpRequestTimeoutMillis) * time.Millisecond
 	if req.TMax > 0 {
-		ctx, cancel = context.WithDeadline(ctx, start.Add(time.Duration(req.TMax)*time.Millisecond))
-	} else {
-		ctx, cancel = context.WithDeadline(ctx, start.Add(time.Duration(defaultAmpRequestTimeoutMillis)*time.Millisecond))
+		timeout = time.Duration(req.TMax) * time.Millisecond
 	}
+	ctx, cancel := context.WithDeadline(spanCtx, start.Add(timeout))
 	defer cancel()
 
-	usersyncs := usersync.ParsePBSCookieFromRequest(r, &(deps.cfg.HostCookie))
+	usersyncs := deps.uidStore.ParsePBSCookieFromRequest(r, &(deps.cfg.HostCookie))
 	if req.App != nil {
 		labels.Source = pbsmetrics.DemandApp
 	} else {
@@ -123,8 +135,11 @@ func (deps *endpointDeps) AmpAuction(w http.ResponseWriter, r *http.Request, _ h
 			labels.CookieFlag = pbsmetrics.CookieFlagYes
 		}
 	}
-	response, err := deps.ex.HoldAuction(ctx, req, usersyncs, labels)
+	details := &analytics.AuctionDetails{}
+	response, err := deps.ex.HoldAuction(ctx, req, usersyncs, labels, details)
+	ao.Request = req
 	ao.AuctionResponse = response
+	ao.Details = details
 
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -135,6 +150,8 @@ func (deps *endpointDeps) AmpAuction(w http.ResponseWriter, r *http.Request, _ h
 		return
 	}
 
+	defer phases.since(pbsmetrics.PhaseResponse, time.Now())
+
 	// Need to extract the targeting parameters from the response, as those are all that
 	// go in the AMP response
 	targets := map[string]string{}
@@ -208,9 +225,11 @@ func (deps *endpointDeps) AmpAuction(w http.ResponseWriter, r *http.Request, _ h
 // possible, it will return errors with messages that suggest improvements.
 //
 // If the errors list has at least one element, then no guarantees are made about the returned request.
-func (deps *endpointDeps) parseAmpRequest(httpRequest *http.Request) (req *openrtb.BidRequest, errs []error) {
+//
+// The time spent fetching the Stored Request is added to phases. ctx is used to trace the fetch.
+func (deps *endpointDeps) parseAmpRequest(ctx context.Context, httpRequest *http.Request, phases phaseTimes) (req *openrtb.BidRequest, errs []error) {
 	// Load the stored request for the AMP ID.
-	req, errs = deps.loadRequestJSONForAmp(httpRequest)
+	req, errs = deps.loadRequestJSONForAmp(ctx, httpRequest, phases)
 	if len(errs) > 0 {
 		return
 	}
@@ -234,7 +253,7 @@ func (deps *endpointDeps) parseAmpRequest(httpRequest *http.Request) (req *openr
 }
 
 // Load the stored OpenRTB request for an incoming AMP request, or return the errors found.
-func (deps *endpointDeps) loadRequestJSONForAmp(httpRequest *http.Request) (req *openrtb.BidRequest, errs []error) {
+func (deps *endpointDeps) loadRequestJSONForAmp(ctx context.Context, httpRequest *http.Request, phases phaseTimes) (req *openrtb.BidRequest, errs []error) {
 	req = &openrtb.BidRequest{}
 	errs = nil
 
@@ -243,24 +262,39 @@ func (deps *endpointDeps) loadRequestJSONForAmp(httpRequest *http.Request) (req
 		errs = []error{errors.New("AMP requests require an AMP tag_id")}
 		return
 	}
+	// Publishers can pin an AMP page to a specific version of its config with tag_id={id}@{version}.
+	ampID = stored_requests.NormalizeID(ampID)
 
 	debugParam := httpRequest.FormValue("debug")
 	debug := debugParam == "1"
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(storedRequestTimeoutMillis)*time.Millisecond)
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(storedRequestTimeoutMillis)*time.Millisecond)
 	defer cancel()
 
-	storedRequests, _, errs := deps.storedReqFetcher.FetchRequests(ctx, []string{ampID}, nil)
+	storedStart := time.Now()
+	storedRequests, _, errs := deps.fetchStoredData(ctx, []string{ampID}, nil)
+	phases.since(pbsmetrics.PhaseStoredRequests, storedStart)
 	if len(errs) > 0 {
 		return nil, errs
 	}
-	if len(storedRequests) == 0 {
+	if len(storedRequests[ampID]) == 0 {
 		errs = []error{fmt.Errorf("No AMP config found for tag_id '%s'", ampID)}
 		return
 	}
 
-	// The fetched config becomes the entire OpenRTB request
-	requestJSON := storedRequests[ampID]
+	// The fetched config becomes the entire OpenRTB request, once any Stored Requests which it
+	// references have been merged underneath it.
+	requestJSON, err := applyStoredData(ampID, storedRequests, storedRequests[ampID])
+	if err != nil {
+		errs = []error{err}
+		return
+	}
+	if debug {
+		if requestJSON, err = setStoredChain(requestJSON, ampID, storedRequests); err != nil {
+			errs = []error{err}
+			return
+		}
+	}
 	if err := json.Unmarshal(requestJSON, req); err != nil {
 		errs = []error{err}
 		return
@@ -10,7 +10,9 @@ import (
 	"strconv"
 	"testing"
 
+	"github.com/buger/jsonparser"
 	"github.com/mxmCherry/openrtb"
+	"github.com/prebid/prebid-server/analytics"
 	analyticsConf "github.com/prebid/prebid-server/analytics/config"
 
 	"github.com/prebid/prebid-server/config"
@@ -39,8 +41,8 @@ func TestGoodAmpRequests(t *testing.T) {
 
 	// NewMetrics() will create a new go_metrics MetricsEngine, bypassing the need for a crafted configuration set to support it.
 	// As a side effect this gives us some coverage of the go_metrics piece of the metrics engine.
-	theMetrics := pbsmetrics.NewMetrics(metrics.NewRegistry(), openrtb_ext.BidderList())
-	endpoint, _ := NewAmpEndpoint(&mockAmpExchange{}, newParamsValidator(t), &mockAmpStoredReqFetcher{goodRequests}, &config.Configuration{MaxRequestSize: maxSize}, theMetrics, analyticsConf.NewPBSAnalytics(&config.Analytics{}))
+	theMetrics := pbsmetrics.NewMetrics(metrics.NewRegistry(), openrtb_ext.BidderList(), config.AccountMetrics{})
+	endpoint, _ := NewAmpEndpoint(&mockAmpExchange{}, newParamsValidator(t), &mockAmpStoredReqFetcher{goodRequests}, &config.Configuration{MaxRequestSize: maxSize}, theMetrics, analyticsConf.NewPBSAnalytics(&config.Analytics{}), nil)
 
 	for requestID := range goodRequests {
 		request := httptest.NewRequest("GET", fmt.Sprintf("/openrtb2/auction/amp?tag_id=%s", requestID), nil)
@@ -84,8 +86,8 @@ func TestAmpBadRequests(t *testing.T) {
 
 	// NewMetrics() will create a new go_metrics MetricsEngine, bypassing the need for a crafted configuration set to support it.
 	// As a side effect this gives us some coverage of the go_metrics piece of the metrics engine.
-	theMetrics := pbsmetrics.NewMetrics(metrics.NewRegistry(), openrtb_ext.BidderList())
-	endpoint, _ := NewEndpoint(&mockAmpExchange{}, newParamsValidator(t), &mockAmpStoredReqFetcher{badRequests}, &config.Configuration{MaxRequestSize: maxSize}, theMetrics, analyticsConf.NewPBSAnalytics(&config.Analytics{}))
+	theMetrics := pbsmetrics.NewMetrics(metrics.NewRegistry(), openrtb_ext.BidderList(), config.AccountMetrics{})
+	endpoint, _ := NewEndpoint(&mockAmpExchange{}, newParamsValidator(t), &mockAmpStoredReqFetcher{badRequests}, &config.Configuration{MaxRequestSize: maxSize}, theMetrics, analyticsConf.NewPBSAnalytics(&config.Analytics{}), nil)
 	for requestID := range badRequests {
 		request := httptest.NewRequest("GET", fmt.Sprintf("/openrtb2/auction/amp?tag_id=%s", requestID), nil)
 		recorder := httptest.NewRecorder()
@@ -105,8 +107,8 @@ func TestAmpDebug(t *testing.T) {
 		"2": json.RawMessage(validRequest(t, "site.json")),
 	}
 
-	theMetrics := pbsmetrics.NewMetrics(metrics.NewRegistry(), openrtb_ext.BidderList())
-	endpoint, _ := NewAmpEndpoint(&mockAmpExchange{}, newParamsValidator(t), &mockAmpStoredReqFetcher{requests}, &config.Configuration{MaxRequestSize: maxSize}, theMetrics, analyticsConf.NewPBSAnalytics(&config.Analytics{}))
+	theMetrics := pbsmetrics.NewMetrics(metrics.NewRegistry(), openrtb_ext.BidderList(), config.AccountMetrics{})
+	endpoint, _ := NewAmpEndpoint(&mockAmpExchange{}, newParamsValidator(t), &mockAmpStoredReqFetcher{requests}, &config.Configuration{MaxRequestSize: maxSize}, theMetrics, analyticsConf.NewPBSAnalytics(&config.Analytics{}), nil)
 
 	for requestID := range requests {
 		request := httptest.NewRequest("GET", fmt.Sprintf("/openrtb2/auction/amp?tag_id=%s&debug=1", requestID), nil)
@@ -137,6 +139,50 @@ func TestAmpDebug(t *testing.T) {
 	}
 }
 
+// TestAmpNestedStoredRequests makes sure that AMP configs are merged on top of the Stored Requests they reference.
+func TestAmpNestedStoredRequests(t *testing.T) {
+	requests := map[string]json.RawMessage{
+		"amp":  json.RawMessage(`{"id":"some-request","imp":[{"id":"my-imp","banner":{"format":[{"w":300,"h":250}]},"ext":{"appnexus":{"placementId":10433394}}}],"ext":{"prebid":{"storedrequest":{"id":"site"}}}}`),
+		"site": json.RawMessage(`{"tmax":500,"site":{"page":"prebid.org","ext":{"amp":1}},"imp":[{"id":"ignored"}],"ext":{"prebid":{"targeting":{"pricegranularity":"low"}}}}`),
+		"self": json.RawMessage(`{"id":"some-request","imp":[{"id":"my-imp","banner":{"format":[{"w":300,"h":250}]},"ext":{"appnexus":{"placementId":10433394}}}],"ext":{"prebid":{"storedrequest":{"id":"self"}}}}`),
+		"a":    json.RawMessage(`{"id":"some-request","imp":[{"id":"my-imp","banner":{"format":[{"w":300,"h":250}]},"ext":{"appnexus":{"placementId":10433394}}}],"ext":{"prebid":{"storedrequest":{"id":"b"}}}}`),
+		"b":    json.RawMessage(`{"ext":{"prebid":{"storedrequest":{"id":"a"}}}}`),
+	}
+	for i := 1; i <= storedRequestMaxDepth; i++ {
+		requests[fmt.Sprintf("deep-%d", i)] = json.RawMessage(fmt.Sprintf(`{"ext":{"prebid":{"storedrequest":{"id":"deep-%d"}}}}`, i+1))
+	}
+	requests[fmt.Sprintf("deep-%d", storedRequestMaxDepth+1)] = json.RawMessage(`{}`)
+
+	ex := &mockAmpExchange{}
+	theMetrics := pbsmetrics.NewMetrics(metrics.NewRegistry(), openrtb_ext.BidderList(), config.AccountMetrics{})
+	endpoint, _ := NewAmpEndpoint(ex, newParamsValidator(t), &nestedStoredReqFetcher{requests: requests}, &config.Configuration{MaxRequestSize: maxSize}, theMetrics, analyticsConf.NewPBSAnalytics(&config.Analytics{}), nil)
+
+	request := httptest.NewRequest("GET", "/openrtb2/auction/amp?tag_id=amp&debug=1", nil)
+	recorder := httptest.NewRecorder()
+	endpoint(recorder, request, nil)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status %d. Got %d. Response body was: %s", http.StatusOK, recorder.Code, recorder.Body)
+	}
+	if ex.lastRequest.TMax != 500 || ex.lastRequest.Site == nil || ex.lastRequest.Site.Page != "prebid.org" {
+		t.Errorf("The AMP config should be merged on top of the Stored Request it references. Got %#v", ex.lastRequest)
+	}
+	if len(ex.lastRequest.Imp) != 1 || ex.lastRequest.Imp[0].ID != "my-imp" {
+		t.Errorf("The AMP config's imp should take precedence. Got %#v", ex.lastRequest.Imp)
+	}
+	if chain, _, _, _ := jsonparser.Get(ex.lastRequest.Ext, "prebid", "storedrequest", "chain"); string(chain) != `["amp","site"]` {
+		t.Errorf("Debug requests should list the chain of Stored Requests which were merged. Got %s", string(ex.lastRequest.Ext))
+	}
+
+	for _, id := range []string{"self", "a", "deep-1"} {
+		request := httptest.NewRequest("GET", "/openrtb2/auction/amp?tag_id="+id, nil)
+		recorder := httptest.NewRecorder()
+		endpoint(recorder, request, nil)
+		if recorder.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d resolving AMP config %s. Got %d", http.StatusBadRequest, id, recorder.Code)
+		}
+	}
+}
+
 // Prevents #452
 func TestAmpTargetingDefaults(t *testing.T) {
 	req := &openrtb.BidRequest{}
@@ -166,8 +212,8 @@ func TestQueryParamOverrides(t *testing.T) {
 	requests := map[string]json.RawMessage{
 		"1": json.RawMessage(validRequest(t, "site.json")),
 	}
-	theMetrics := pbsmetrics.NewMetrics(metrics.NewRegistry(), openrtb_ext.BidderList())
-	endpoint, _ := NewAmpEndpoint(&mockAmpExchange{}, newParamsValidator(t), &mockAmpStoredReqFetcher{requests}, &config.Configuration{MaxRequestSize: maxSize}, theMetrics, analyticsConf.NewPBSAnalytics(&config.Analytics{}))
+	theMetrics := pbsmetrics.NewMetrics(metrics.NewRegistry(), openrtb_ext.BidderList(), config.AccountMetrics{})
+	endpoint, _ := NewAmpEndpoint(&mockAmpExchange{}, newParamsValidator(t), &mockAmpStoredReqFetcher{requests}, &config.Configuration{MaxRequestSize: maxSize}, theMetrics, analyticsConf.NewPBSAnalytics(&config.Analytics{}), nil)
 
 	requestID := "1"
 	curl := "http://example.com"
@@ -283,8 +329,8 @@ func (s formatOverrideSpec) execute(t *testing.T) {
 	requests := map[string]json.RawMessage{
 		"1": json.RawMessage(validRequest(t, "site.json")),
 	}
-	theMetrics := pbsmetrics.NewMetrics(metrics.NewRegistry(), openrtb_ext.BidderList())
-	endpoint, _ := NewAmpEndpoint(&mockAmpExchange{}, newParamsValidator(t), &mockAmpStoredReqFetcher{requests}, &config.Configuration{MaxRequestSize: maxSize}, theMetrics, analyticsConf.NewPBSAnalytics(&config.Analytics{}))
+	theMetrics := pbsmetrics.NewMetrics(metrics.NewRegistry(), openrtb_ext.BidderList(), config.AccountMetrics{})
+	endpoint, _ := NewAmpEndpoint(&mockAmpExchange{}, newParamsValidator(t), &mockAmpStoredReqFetcher{requests}, &config.Configuration{MaxRequestSize: maxSize}, theMetrics, analyticsConf.NewPBSAnalytics(&config.Analytics{}), nil)
 
 	url := fmt.Sprintf("/openrtb2/auction/amp?tag_id=1&debug=1&w=%d&h=%d&ow=%d&oh=%d&ms=%s", s.width, s.height, s.overrideWidth, s.overrideHeight, s.multisize)
 	request := httptest.NewRequest("GET", url, nil)
@@ -326,7 +372,7 @@ type mockAmpExchange struct {
 	lastRequest *openrtb.BidRequest
 }
 
-func (m *mockAmpExchange) HoldAuction(ctx context.Context, bidRequest *openrtb.BidRequest, ids exchange.IdFetcher, labels pbsmetrics.Labels) (*openrtb.BidResponse, error) {
+func (m *mockAmpExchange) HoldAuction(ctx context.Context, bidRequest *openrtb.BidRequest, ids exchange.IdFetcher, labels pbsmetrics.Labels, details *analytics.AuctionDetails) (*openrtb.BidResponse, error) {
 	m.lastRequest = bidRequest
 
 	response := &openrtb.BidResponse{
@@ -10,6 +10,7 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/buger/jsonparser"
@@ -26,18 +27,22 @@ import (
 	"github.com/prebid/prebid-server/pbsmetrics"
 	"github.com/prebid/prebid-server/prebid"
 	"github.com/prebid/prebid-server/stored_requests"
+	"github.com/prebid/prebid-server/tracing"
 	"github.com/prebid/prebid-server/usersync"
 	"golang.org/x/net/publicsuffix"
 )
 
 const storedRequestTimeoutMillis = 50
 
-func NewEndpoint(ex exchange.Exchange, validator openrtb_ext.BidderParamValidator, requestsById stored_requests.Fetcher, cfg *config.Configuration, met pbsmetrics.MetricsEngine, pbsAnalytics analytics.PBSAnalyticsModule) (httprouter.Handle, error) {
+// storedRequestMaxDepth is the longest chain of Stored Requests which may reference one another.
+const storedRequestMaxDepth = 5
+
+func NewEndpoint(ex exchange.Exchange, validator openrtb_ext.BidderParamValidator, requestsById stored_requests.Fetcher, cfg *config.Configuration, met pbsmetrics.MetricsEngine, pbsAnalytics analytics.PBSAnalyticsModule, uidStore *usersync.UIDStoreClient) (httprouter.Handle, error) {
 	if ex == nil || validator == nil || requestsById == nil || cfg == nil || met == nil {
 		return nil, errors.New("NewEndpoint requires non-nil arguments.")
 	}
 
-	return httprouter.Handle((&endpointDeps{ex, validator, requestsById, cfg, met, pbsAnalytics}).Auction), nil
+	return httprouter.Handle((&endpointDeps{ex, validator, requestsById, cfg, met, pbsAnalytics, uidStore}).Auction), nil
 }
 
 type endpointDeps struct {
@@ -47,6 +52,8 @@ type endpointDeps struct {
 	cfg              *config.Configuration
 	metricsEngine    pbsmetrics.MetricsEngine
 	analytics        analytics.PBSAnalyticsModule
+	// uidStore restores the user syncs which the browser dropped. It's nil unless the host configured one.
+	uidStore *usersync.UIDStoreClient
 }
 
 func (deps *endpointDeps) Auction(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
@@ -72,11 +79,17 @@ func (deps *endpointDeps) Auction(w http.ResponseWriter, r *http.Request, _ http
 		RequestStatus: pbsmetrics.RequestStatusOK,
 	}
 	numImps := 0
+	phases := make(phaseTimes)
+	var timeout time.Duration
+	spanCtx, span := tracing.StartSpan(tracing.Extract(context.Background(), r.Header), "openrtb2.auction")
 	defer func() {
 		deps.metricsEngine.RecordRequest(labels)
 		deps.metricsEngine.RecordImps(labels, numImps)
 		deps.metricsEngine.RecordRequestTime(labels, time.Since(start))
+		phases.record(deps.metricsEngine, labels)
+		recordTMaxExceeded(deps.metricsEngine, labels, start, timeout)
 		deps.analytics.LogAuctionObject(&ao)
+		endRequestSpan(span, labels)
 	}()
 
 	isSafari := checkSafari(r)
@@ -84,7 +97,10 @@ func (deps *endpointDeps) Auction(w http.ResponseWriter, r *http.Request, _ http
 		labels.Browser = pbsmetrics.BrowserSafari
 	}
 
-	req, errL := deps.parseRequest(r)
+	parseStart := time.Now()
+	req, errL := deps.parseRequest(spanCtx, r, phases)
+	// The Stored Request fetch has a phase of its own, so it doesn't count towards the parse time.
+	phases[pbsmetrics.PhaseParse] = time.Since(parseStart) - phases[pbsmetrics.PhaseStoredRequests]
 
 	if writeError(errL, w) {
 		labels.RequestStatus = pbsmetrics.RequestStatusBadInput
@@ -101,15 +117,15 @@ func (deps *endpointDeps) Auction(w http.ResponseWriter, r *http.Request, _ http
 		}
 	}
 
-	ctx := context.Background()
+	ctx := spanCtx
 	cancel := func() {}
-	timeout := deps.cfg.AuctionTimeouts.LimitAuctionTimeout(time.Duration(req.TMax) * time.Millisecond)
+	timeout = deps.cfg.AuctionTimeouts.LimitAuctionTimeout(time.Duration(req.TMax) * time.Millisecond)
 	if timeout > 0 {
 		ctx, cancel = context.WithDeadline(ctx, start.Add(timeout))
 	}
 	defer cancel()
 
-	usersyncs := usersync.ParsePBSCookieFromRequest(r, &(deps.cfg.HostCookie))
+	usersyncs := deps.uidStore.ParsePBSCookieFromRequest(r, &(deps.cfg.HostCookie))
 	if req.App != nil {
 		labels.Source = pbsmetrics.DemandApp
 	} else {
@@ -122,9 +138,11 @@ func (deps *endpointDeps) Auction(w http.ResponseWriter, r *http.Request, _ http
 	}
 
 	numImps = len(req.Imp)
-	response, err := deps.ex.HoldAuction(ctx, req, usersyncs, labels)
+	details := &analytics.AuctionDetails{}
+	response, err := deps.ex.HoldAuction(ctx, req, usersyncs, labels, details)
 	ao.Request = req
 	ao.Response = response
+	ao.Details = details
 	if err != nil {
 		labels.RequestStatus = pbsmetrics.RequestStatusErr
 		w.WriteHeader(http.StatusInternalServerError)
@@ -135,6 +153,8 @@ func (deps *endpointDeps) Auction(w http.ResponseWriter, r *http.Request, _ http
 		return
 	}
 
+	defer phases.since(pbsmetrics.PhaseResponse, time.Now())
+
 	// Fixes #231
 	enc := json.NewEncoder(w)
 	enc.SetEscapeHTML(false)
@@ -162,34 +182,28 @@ func (deps *endpointDeps) Auction(w http.ResponseWriter, r *http.Request, _ http
 // possible, it will return errors with messages that suggest improvements.
 //
 // If the errors list has at least one element, then no guarantees are made about the returned request.
-func (deps *endpointDeps) parseRequest(httpRequest *http.Request) (req *openrtb.BidRequest, errs []error) {
+//
+// The time spent fetching Stored Requests is added to phases. ctx is used to trace the fetch.
+func (deps *endpointDeps) parseRequest(ctx context.Context, httpRequest *http.Request, phases phaseTimes) (req *openrtb.BidRequest, errs []error) {
 	req = &openrtb.BidRequest{}
 	errs = nil
 
 	// Pull the request body into a buffer, so we have it for later usage.
-	lr := &io.LimitedReader{
-		R: httpRequest.Body,
-		N: deps.cfg.MaxRequestSize,
-	}
-	requestJson, err := ioutil.ReadAll(lr)
+	requestJson, err := deps.readRequestBody(httpRequest)
 	if err != nil {
 		errs = []error{err}
 		return
 	}
-	// If the request size was too large, read through the rest of the request body so that the connection can be reused.
-	if lr.N <= 0 {
-		if written, err := io.Copy(ioutil.Discard, httpRequest.Body); written > 0 || err != nil {
-			errs = []error{fmt.Errorf("Request size exceeded max size of %d bytes.", deps.cfg.MaxRequestSize)}
-			return
-		}
-	}
 
 	timeout := parseTimeout(requestJson, time.Duration(storedRequestTimeoutMillis)*time.Millisecond)
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
 	// Fetch the Stored Request data and merge it into the HTTP request.
-	if requestJson, errs = deps.processStoredRequests(ctx, requestJson); len(errs) > 0 {
+	storedStart := time.Now()
+	requestJson, errs = deps.processStoredRequests(ctx, requestJson)
+	phases.since(pbsmetrics.PhaseStoredRequests, storedStart)
+	if len(errs) > 0 {
 		return
 	}
 
@@ -209,6 +223,25 @@ func (deps *endpointDeps) parseRequest(httpRequest *http.Request) (req *openrtb.
 	return
 }
 
+// readRequestBody reads the whole request body, or returns an error if it's larger than the host allows.
+func (deps *endpointDeps) readRequestBody(httpRequest *http.Request) ([]byte, error) {
+	lr := &io.LimitedReader{
+		R: httpRequest.Body,
+		N: deps.cfg.MaxRequestSize,
+	}
+	requestJson, err := ioutil.ReadAll(lr)
+	if err != nil {
+		return nil, err
+	}
+	// If the request size was too large, read through the rest of the request body so that the connection can be reused.
+	if lr.N <= 0 {
+		if written, err := io.Copy(ioutil.Discard, httpRequest.Body); written > 0 || err != nil {
+			return nil, fmt.Errorf("Request size exceeded max size of %d bytes.", deps.cfg.MaxRequestSize)
+		}
+	}
+	return requestJson, nil
+}
+
 // parseTimeout returns parses tmax from the requestJson, or returns the default if it doesn't exist.
 //
 // requestJson should be the content of the POST body.
@@ -743,6 +776,11 @@ func setImpsImplicitly(httpReq *http.Request, imps []openrtb.Imp) {
 	}
 }
 
+// processStoredRequests merges the Stored BidRequest and Stored Imps into the request JSON.
+//
+// Stored data may reference other Stored data through its own ext.prebid.storedrequest.id. Those are merged
+// underneath it, so that the outermost data takes precedence. If the request is a test request, the IDs which
+// were merged are listed in ext.prebid.storedrequest.chain so that they show up in the debug output.
 func (deps *endpointDeps) processStoredRequests(ctx context.Context, requestJson []byte) ([]byte, []error) {
 	// Parse the Stored Request IDs from the BidRequest and Imps.
 	storedBidRequestId, hasStoredBidRequest, err := getStoredRequestId(requestJson)
@@ -759,7 +797,7 @@ func (deps *endpointDeps) processStoredRequests(ctx context.Context, requestJson
 	if hasStoredBidRequest {
 		storedReqIds = []string{storedBidRequestId}
 	}
-	storedRequests, storedImps, errs := deps.storedReqFetcher.FetchRequests(ctx, storedReqIds, impIds)
+	storedRequests, storedImps, errs := deps.fetchStoredData(ctx, storedReqIds, impIds)
 	if len(errs) != 0 {
 		return nil, errs
 	}
@@ -767,20 +805,34 @@ func (deps *endpointDeps) processStoredRequests(ctx context.Context, requestJson
 	// Apply the Stored BidRequest, if it exists
 	resolvedRequest := requestJson
 	if hasStoredBidRequest {
-		resolvedRequest, err = jsonpatch.MergePatch(storedRequests[storedBidRequestId], requestJson)
+		resolvedRequest, err = applyStoredData(storedBidRequestId, storedRequests, requestJson)
 		if err != nil {
 			return nil, []error{err}
 		}
 	}
+	isTest := false
+	if test, err := jsonparser.GetInt(resolvedRequest, "test"); err == nil && test == 1 {
+		isTest = true
+	}
+	if isTest && hasStoredBidRequest {
+		if resolvedRequest, err = setStoredChain(resolvedRequest, storedBidRequestId, storedRequests); err != nil {
+			return nil, []error{err}
+		}
+	}
 
 	// Apply any Stored Imps, if they exist. Since the JSON Merge Patch overrides arrays,
 	// and Prebid Server defers to the HTTP Request to resolve conflicts, it's safe to
 	// assume that the request.imp data did not change when applying the Stored BidRequest.
 	for i := 0; i < len(impIds); i++ {
-		resolvedImp, err := jsonpatch.MergePatch(storedImps[impIds[i]], imps[idIndices[i]])
+		resolvedImp, err := applyStoredData(impIds[i], storedImps, imps[idIndices[i]])
 		if err != nil {
 			return nil, []error{err}
 		}
+		if isTest {
+			if resolvedImp, err = setStoredChain(resolvedImp, impIds[i], storedImps); err != nil {
+				return nil, []error{err}
+			}
+		}
 		imps[idIndices[i]] = resolvedImp
 	}
 	if len(impIds) > 0 {
@@ -797,6 +849,102 @@ func (deps *endpointDeps) processStoredRequests(ctx context.Context, requestJson
 	return resolvedRequest, nil
 }
 
+// fetchStoredData fetches the Stored Requests and Stored Imps with the given IDs, along with any others
+// which they reference. Every level of nesting is fetched from the Fetcher in a single batch.
+func (deps *endpointDeps) fetchStoredData(ctx context.Context, requestIds []string, impIds []string) (map[string]json.RawMessage, map[string]json.RawMessage, []error) {
+	storedRequests := make(map[string]json.RawMessage, len(requestIds))
+	storedImps := make(map[string]json.RawMessage, len(impIds))
+	for depth := 1; len(requestIds) > 0 || len(impIds) > 0; depth++ {
+		if depth > storedRequestMaxDepth {
+			return nil, nil, []error{fmt.Errorf("Stored Requests can only be nested %d levels deep. These were nested deeper: %s", storedRequestMaxDepth, strings.Join(append(requestIds, impIds...), ", "))}
+		}
+		fetchedRequests, fetchedImps, errs := deps.storedReqFetcher.FetchRequests(ctx, requestIds, impIds)
+		if len(errs) != 0 {
+			return nil, nil, errs
+		}
+		if requestIds, errs = collectStoredData(requestIds, fetchedRequests, storedRequests); len(errs) != 0 {
+			return nil, nil, errs
+		}
+		if impIds, errs = collectStoredData(impIds, fetchedImps, storedImps); len(errs) != 0 {
+			return nil, nil, errs
+		}
+	}
+	return storedRequests, storedImps, nil
+}
+
+// collectStoredData saves the fetched data for each ID into storedData, and returns the IDs which they
+// reference that haven't been fetched yet.
+func collectStoredData(ids []string, fetched map[string]json.RawMessage, storedData map[string]json.RawMessage) (nextIds []string, errs []error) {
+	for _, id := range ids {
+		storedData[id] = fetched[id]
+	}
+	for _, id := range ids {
+		nextId, hasNext, err := getStoredRequestId(fetched[id])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Stored Request %s: %v", id, err))
+		} else if _, seen := storedData[nextId]; hasNext && !seen {
+			// Set a placeholder so that IDs which are referenced more than once are only fetched once.
+			storedData[nextId] = nil
+			nextIds = append(nextIds, nextId)
+		}
+	}
+	return nextIds, errs
+}
+
+// storedChain returns the IDs of the Stored data which should be merged to resolve the given ID,
+// starting with the ID itself and ending with the data which doesn't reference anything else.
+func storedChain(id string, storedData map[string]json.RawMessage) ([]string, error) {
+	chain := make([]string, 0, 1)
+	seen := make(map[string]bool, 1)
+	for {
+		chain = append(chain, id)
+		if seen[id] {
+			return nil, fmt.Errorf("Stored Requests can't reference themselves. Found a cycle: %s", strings.Join(chain, " -> "))
+		}
+		if len(chain) > storedRequestMaxDepth {
+			return nil, fmt.Errorf("Stored Requests can only be nested %d levels deep. Found: %s", storedRequestMaxDepth, strings.Join(chain, " -> "))
+		}
+		seen[id] = true
+
+		nextId, hasNext, err := getStoredRequestId(storedData[id])
+		if err != nil {
+			return nil, err
+		}
+		if !hasNext {
+			return chain, nil
+		}
+		id = nextId
+	}
+}
+
+// applyStoredData merges the chain of Stored data for the given ID underneath the JSON.
+func applyStoredData(id string, storedData map[string]json.RawMessage, data []byte) ([]byte, error) {
+	chain, err := storedChain(id, storedData)
+	if err != nil {
+		return nil, err
+	}
+	resolved := data
+	for i := 0; i < len(chain); i++ {
+		if resolved, err = jsonpatch.MergePatch(storedData[chain[i]], resolved); err != nil {
+			return nil, err
+		}
+	}
+	return resolved, nil
+}
+
+// setStoredChain lists the IDs which were merged to resolve the given ID at ext.prebid.storedrequest.chain.
+func setStoredChain(data []byte, id string, storedData map[string]json.RawMessage) ([]byte, error) {
+	chain, err := storedChain(id, storedData)
+	if err != nil {
+		return nil, err
+	}
+	chainJson, err := json.Marshal(chain)
+	if err != nil {
+		return nil, err
+	}
+	return jsonparser.Set(data, chainJson, "ext", "prebid", "storedrequest", "chain")
+}
+
 // parseImpInfo parses the request JSON and returns several things about the Imps
 //
 // 1. A list of the JSON for every Imp.
@@ -821,6 +969,7 @@ func parseImpInfo(requestJson []byte) (imps []json.RawMessage, ids []string, imp
 }
 
 // getStoredRequestId parses a Stored Request ID from some json, without doing a full (slow) unmarshal.
+// Versioned IDs are normalized to the form which the Fetcher expects (see stored_requests.NormalizeID).
 // It returns the ID, true/false whether a stored request key existed, and an error if anything went wrong
 // (e.g. malformed json, id not a string, etc).
 func getStoredRequestId(data []byte) (string, bool, error) {
@@ -836,7 +985,8 @@ func getStoredRequestId(data []byte) (string, bool, error) {
 		return "", true, errors.New("ext.prebid.storedrequest.id must be a string")
 	}
 
-	return string(value), true, nil
+	// IDs may reference a specific version, like "{id}@{version}".
+	return stored_requests.NormalizeID(string(value)), true, nil
 }
 
 // setUserImplicitly uses implicit info from httpReq to populate bidReq.User
@@ -909,3 +1059,13 @@ func writeError(errs []error, w http.ResponseWriter) bool {
 	}
 	return false
 }
+
+// endRequestSpan labels the span for an incoming request with the request's outcome, and ends it.
+func endRequestSpan(span *tracing.Span, labels pbsmetrics.Labels) {
+	span.SetAttribute("request_type", string(labels.RType))
+	span.SetAttribute("request_status", string(labels.RequestStatus))
+	if labels.PubID != "" {
+		span.SetAttribute("pub_id", labels.PubID)
+	}
+	span.End()
+}
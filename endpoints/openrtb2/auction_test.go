@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -17,11 +18,13 @@ import (
 	"github.com/buger/jsonparser"
 	"github.com/evanphx/json-patch"
 	"github.com/mxmCherry/openrtb"
+	"github.com/prebid/prebid-server/analytics"
 	analyticsConf "github.com/prebid/prebid-server/analytics/config"
 	"github.com/prebid/prebid-server/config"
 	"github.com/prebid/prebid-server/exchange"
 	"github.com/prebid/prebid-server/openrtb_ext"
 	"github.com/prebid/prebid-server/pbsmetrics"
+	"github.com/prebid/prebid-server/stored_requests"
 	"github.com/prebid/prebid-server/stored_requests/backends/empty_fetcher"
 	"github.com/rcrowley/go-metrics"
 	"github.com/stretchr/testify/assert"
@@ -81,8 +84,8 @@ func TestExplicitUserId(t *testing.T) {
 	})
 	// NewMetrics() will create a new go_metrics MetricsEngine, bypassing the need for a crafted configuration set to support it.
 	// As a side effect this gives us some coverage of the go_metrics piece of the metrics engine.
-	theMetrics := pbsmetrics.NewMetrics(metrics.NewRegistry(), openrtb_ext.BidderList())
-	endpoint, _ := NewEndpoint(ex, newParamsValidator(t), empty_fetcher.EmptyFetcher{}, cfg, theMetrics, analyticsConf.NewPBSAnalytics(&config.Analytics{}))
+	theMetrics := pbsmetrics.NewMetrics(metrics.NewRegistry(), openrtb_ext.BidderList(), config.AccountMetrics{})
+	endpoint, _ := NewEndpoint(ex, newParamsValidator(t), empty_fetcher.EmptyFetcher{}, cfg, theMetrics, analyticsConf.NewPBSAnalytics(&config.Analytics{}), nil)
 	endpoint(httptest.NewRecorder(), request, nil)
 
 	if ex.lastRequest == nil {
@@ -117,8 +120,8 @@ func TestImplicitUserId(t *testing.T) {
 	})
 	// NewMetrics() will create a new go_metrics MetricsEngine, bypassing the need for a crafted configuration set to support it.
 	// As a side effect this gives us some coverage of the go_metrics piece of the metrics engine.
-	theMetrics := pbsmetrics.NewMetrics(metrics.NewRegistry(), openrtb_ext.BidderList())
-	endpoint, _ := NewEndpoint(ex, newParamsValidator(t), empty_fetcher.EmptyFetcher{}, cfg, theMetrics, analyticsConf.NewPBSAnalytics(&config.Analytics{}))
+	theMetrics := pbsmetrics.NewMetrics(metrics.NewRegistry(), openrtb_ext.BidderList(), config.AccountMetrics{})
+	endpoint, _ := NewEndpoint(ex, newParamsValidator(t), empty_fetcher.EmptyFetcher{}, cfg, theMetrics, analyticsConf.NewPBSAnalytics(&config.Analytics{}), nil)
 	endpoint(httptest.NewRecorder(), request, nil)
 
 	if ex.lastRequest == nil {
@@ -194,8 +197,8 @@ func readFile(t *testing.T, filename string) []byte {
 func doRequest(t *testing.T, requestData []byte) (int, string) {
 	// NewMetrics() will create a new go_metrics MetricsEngine, bypassing the need for a crafted configuration set to support it.
 	// As a side effect this gives us some coverage of the go_metrics piece of the metrics engine.
-	theMetrics := pbsmetrics.NewMetrics(metrics.NewRegistry(), openrtb_ext.BidderList())
-	endpoint, _ := NewEndpoint(&nobidExchange{}, newParamsValidator(t), empty_fetcher.EmptyFetcher{}, &config.Configuration{MaxRequestSize: maxSize}, theMetrics, analyticsConf.NewPBSAnalytics(&config.Analytics{}))
+	theMetrics := pbsmetrics.NewMetrics(metrics.NewRegistry(), openrtb_ext.BidderList(), config.AccountMetrics{})
+	endpoint, _ := NewEndpoint(&nobidExchange{}, newParamsValidator(t), empty_fetcher.EmptyFetcher{}, &config.Configuration{MaxRequestSize: maxSize}, theMetrics, analyticsConf.NewPBSAnalytics(&config.Analytics{}), nil)
 
 	request := httptest.NewRequest("POST", "/openrtb2/auction", bytes.NewReader(requestData))
 	recorder := httptest.NewRecorder()
@@ -264,8 +267,8 @@ func getMessage(t *testing.T, example []byte) []byte {
 func TestNilExchange(t *testing.T) {
 	// NewMetrics() will create a new go_metrics MetricsEngine, bypassing the need for a crafted configuration set to support it.
 	// As a side effect this gives us some coverage of the go_metrics piece of the metrics engine.
-	theMetrics := pbsmetrics.NewMetrics(metrics.NewRegistry(), openrtb_ext.BidderList())
-	_, err := NewEndpoint(nil, newParamsValidator(t), empty_fetcher.EmptyFetcher{}, &config.Configuration{MaxRequestSize: maxSize}, theMetrics, analyticsConf.NewPBSAnalytics(&config.Analytics{}))
+	theMetrics := pbsmetrics.NewMetrics(metrics.NewRegistry(), openrtb_ext.BidderList(), config.AccountMetrics{})
+	_, err := NewEndpoint(nil, newParamsValidator(t), empty_fetcher.EmptyFetcher{}, &config.Configuration{MaxRequestSize: maxSize}, theMetrics, analyticsConf.NewPBSAnalytics(&config.Analytics{}), nil)
 	if err == nil {
 		t.Errorf("NewEndpoint should return an error when given a nil Exchange.")
 	}
@@ -275,8 +278,8 @@ func TestNilExchange(t *testing.T) {
 func TestNilValidator(t *testing.T) {
 	// NewMetrics() will create a new go_metrics MetricsEngine, bypassing the need for a crafted configuration set to support it.
 	// As a side effect this gives us some coverage of the go_metrics piece of the metrics engine.
-	theMetrics := pbsmetrics.NewMetrics(metrics.NewRegistry(), openrtb_ext.BidderList())
-	_, err := NewEndpoint(&nobidExchange{}, nil, empty_fetcher.EmptyFetcher{}, &config.Configuration{MaxRequestSize: maxSize}, theMetrics, analyticsConf.NewPBSAnalytics(&config.Analytics{}))
+	theMetrics := pbsmetrics.NewMetrics(metrics.NewRegistry(), openrtb_ext.BidderList(), config.AccountMetrics{})
+	_, err := NewEndpoint(&nobidExchange{}, nil, empty_fetcher.EmptyFetcher{}, &config.Configuration{MaxRequestSize: maxSize}, theMetrics, analyticsConf.NewPBSAnalytics(&config.Analytics{}), nil)
 	if err == nil {
 		t.Errorf("NewEndpoint should return an error when given a nil BidderParamValidator.")
 	}
@@ -286,8 +289,8 @@ func TestNilValidator(t *testing.T) {
 func TestExchangeError(t *testing.T) {
 	// NewMetrics() will create a new go_metrics MetricsEngine, bypassing the need for a crafted configuration set to support it.
 	// As a side effect this gives us some coverage of the go_metrics piece of the metrics engine.
-	theMetrics := pbsmetrics.NewMetrics(metrics.NewRegistry(), openrtb_ext.BidderList())
-	endpoint, _ := NewEndpoint(&brokenExchange{}, newParamsValidator(t), empty_fetcher.EmptyFetcher{}, &config.Configuration{MaxRequestSize: maxSize}, theMetrics, analyticsConf.NewPBSAnalytics(&config.Analytics{}))
+	theMetrics := pbsmetrics.NewMetrics(metrics.NewRegistry(), openrtb_ext.BidderList(), config.AccountMetrics{})
+	endpoint, _ := NewEndpoint(&brokenExchange{}, newParamsValidator(t), empty_fetcher.EmptyFetcher{}, &config.Configuration{MaxRequestSize: maxSize}, theMetrics, analyticsConf.NewPBSAnalytics(&config.Analytics{}), nil)
 	request := httptest.NewRequest("POST", "/openrtb2/auction", strings.NewReader(validRequest(t, "site.json")))
 	recorder := httptest.NewRecorder()
 	endpoint(recorder, request, nil)
@@ -344,8 +347,8 @@ func TestImplicitIPs(t *testing.T) {
 	ex := &nobidExchange{}
 	// NewMetrics() will create a new go_metrics MetricsEngine, bypassing the need for a crafted configuration set to support it.
 	// As a side effect this gives us some coverage of the go_metrics piece of the metrics engine.
-	theMetrics := pbsmetrics.NewMetrics(metrics.NewRegistry(), openrtb_ext.BidderList())
-	endpoint, _ := NewEndpoint(ex, newParamsValidator(t), &mockStoredReqFetcher{}, &config.Configuration{MaxRequestSize: maxSize}, theMetrics, analyticsConf.NewPBSAnalytics(&config.Analytics{}))
+	theMetrics := pbsmetrics.NewMetrics(metrics.NewRegistry(), openrtb_ext.BidderList(), config.AccountMetrics{})
+	endpoint, _ := NewEndpoint(ex, newParamsValidator(t), &mockStoredReqFetcher{}, &config.Configuration{MaxRequestSize: maxSize}, theMetrics, analyticsConf.NewPBSAnalytics(&config.Analytics{}), nil)
 	httpReq := httptest.NewRequest("POST", "/openrtb2/auction", strings.NewReader(validRequest(t, "site.json")))
 	httpReq.Header.Set("X-Forwarded-For", "123.456.78.90")
 	recorder := httptest.NewRecorder()
@@ -400,8 +403,8 @@ func TestRefererParsing(t *testing.T) {
 func TestStoredRequests(t *testing.T) {
 	// NewMetrics() will create a new go_metrics MetricsEngine, bypassing the need for a crafted configuration set to support it.
 	// As a side effect this gives us some coverage of the go_metrics piece of the metrics engine.
-	theMetrics := pbsmetrics.NewMetrics(metrics.NewRegistry(), openrtb_ext.BidderList())
-	edep := &endpointDeps{&nobidExchange{}, newParamsValidator(t), &mockStoredReqFetcher{}, &config.Configuration{MaxRequestSize: maxSize}, theMetrics, analyticsConf.NewPBSAnalytics(&config.Analytics{})}
+	theMetrics := pbsmetrics.NewMetrics(metrics.NewRegistry(), openrtb_ext.BidderList(), config.AccountMetrics{})
+	edep := &endpointDeps{&nobidExchange{}, newParamsValidator(t), &mockStoredReqFetcher{}, &config.Configuration{MaxRequestSize: maxSize}, theMetrics, analyticsConf.NewPBSAnalytics(&config.Analytics{}), nil}
 
 	for i, requestData := range testStoredRequests {
 		newRequest, errList := edep.processStoredRequests(context.Background(), json.RawMessage(requestData))
@@ -421,6 +424,95 @@ func TestStoredRequests(t *testing.T) {
 	}
 }
 
+// TestNestedStoredRequests makes sure that Stored Requests which reference one another are merged in the right order.
+func TestNestedStoredRequests(t *testing.T) {
+	fetcher := &nestedStoredReqFetcher{
+		requests: map[string]json.RawMessage{
+			"slot": json.RawMessage(`{"tmax":100,"ext":{"prebid":{"storedrequest":{"id":"page"}}}}`),
+			"page": json.RawMessage(`{"tmax":200,"site":{"page":"prebid.org/page"},"ext":{"prebid":{"storedrequest":{"id":"site"}}}}`),
+			"site": json.RawMessage(`{"tmax":300,"site":{"page":"prebid.org","domain":"prebid.org"},"test":1}`),
+		},
+		imps: map[string]json.RawMessage{
+			"imp-slot": json.RawMessage(`{"banner":{"format":[{"w":728,"h":90}]},"ext":{"prebid":{"storedrequest":{"id":"imp-base"}}}}`),
+			"imp-base": json.RawMessage(`{"banner":{"format":[{"w":300,"h":250}]},"ext":{"appnexus":{"placementId":10433394}}}`),
+		},
+	}
+	edep := &endpointDeps{storedReqFetcher: fetcher}
+
+	request := `{"id":"some-request","imp":[{"id":"my-imp","ext":{"prebid":{"storedrequest":{"id":"imp-slot"}}}}],"ext":{"prebid":{"storedrequest":{"id":"slot"}}}}`
+	resolved, errs := edep.processStoredRequests(context.Background(), []byte(request))
+	if len(errs) != 0 {
+		t.Fatalf("Unexpected errors resolving nested Stored Requests: %v", errs)
+	}
+	expected := `{
+		"id": "some-request",
+		"tmax": 100,
+		"test": 1,
+		"site": {"page": "prebid.org/page", "domain": "prebid.org"},
+		"imp": [{
+			"id": "my-imp",
+			"banner": {"format": [{"w": 728, "h": 90}]},
+			"ext": {
+				"appnexus": {"placementId": 10433394},
+				"prebid": {"storedrequest": {"id": "imp-slot", "chain": ["imp-slot", "imp-base"]}}
+			}
+		}],
+		"ext": {"prebid": {"storedrequest": {"id": "slot", "chain": ["slot", "page", "site"]}}}
+	}`
+	if !jsonpatch.Equal(resolved, []byte(expected)) {
+		t.Errorf("Nested Stored Requests were merged incorrectly.\nFound:\n%s\nExpected:\n%s", string(resolved), expected)
+	}
+	if fetcher.calls != 3 {
+		t.Errorf("Each level of nesting should be fetched in one batch. Expected 3 calls, got %d", fetcher.calls)
+	}
+}
+
+func TestBadNestedStoredRequests(t *testing.T) {
+	requests := map[string]json.RawMessage{
+		"self":   json.RawMessage(`{"ext":{"prebid":{"storedrequest":{"id":"self"}}}}`),
+		"a":      json.RawMessage(`{"ext":{"prebid":{"storedrequest":{"id":"b"}}}}`),
+		"b":      json.RawMessage(`{"ext":{"prebid":{"storedrequest":{"id":"a"}}}}`),
+		"bad":    json.RawMessage(`{"ext":{"prebid":{"storedrequest":{"id":5}}}}`),
+		"dangle": json.RawMessage(`{"ext":{"prebid":{"storedrequest":{"id":"missing"}}}}`),
+	}
+	for i := 1; i <= storedRequestMaxDepth; i++ {
+		requests[fmt.Sprintf("deep-%d", i)] = json.RawMessage(fmt.Sprintf(`{"ext":{"prebid":{"storedrequest":{"id":"deep-%d"}}}}`, i+1))
+	}
+	requests[fmt.Sprintf("deep-%d", storedRequestMaxDepth+1)] = json.RawMessage(`{}`)
+	edep := &endpointDeps{storedReqFetcher: &nestedStoredReqFetcher{requests: requests}}
+
+	for _, id := range []string{"self", "a", "bad", "dangle", "deep-1"} {
+		request := `{"id":"some-request","ext":{"prebid":{"storedrequest":{"id":"` + id + `"}}}}`
+		if _, errs := edep.processStoredRequests(context.Background(), []byte(request)); len(errs) == 0 {
+			t.Errorf("Expected an error resolving Stored Request %s", id)
+		}
+	}
+
+	// The deepest chain which is allowed should still work.
+	request := `{"id":"some-request","ext":{"prebid":{"storedrequest":{"id":"deep-2"}}}}`
+	if _, errs := edep.processStoredRequests(context.Background(), []byte(request)); len(errs) != 0 {
+		t.Errorf("Stored Requests nested %d levels deep should be allowed. Got %v", storedRequestMaxDepth, errs)
+	}
+}
+
+// TestVersionedStoredRequestIds makes sure that Stored Request IDs are normalized before they're fetched.
+func TestVersionedStoredRequestIds(t *testing.T) {
+	expected := map[string]string{
+		"1":          "1",
+		"1@latest":   "1",
+		"1@2":        "1@2",
+		"pub@site":   "pub@site",
+		"pub@site@2": "pub@site@2",
+	}
+	for storedID, fetchedID := range expected {
+		data := []byte(`{"ext":{"prebid":{"storedrequest":{"id":"` + storedID + `"}}}}`)
+		id, hasID, err := getStoredRequestId(data)
+		if err != nil || !hasID || id != fetchedID {
+			t.Errorf("Expected %s to be fetched as %s. Got %s, %t, %v", storedID, fetchedID, id, hasID, err)
+		}
+	}
+}
+
 // TestOversizedRequest makes sure we behave properly when the request size exceeds the configured max.
 func TestOversizedRequest(t *testing.T) {
 	reqBody := `{"id":"request-id"}`
@@ -429,8 +521,9 @@ func TestOversizedRequest(t *testing.T) {
 		newParamsValidator(t),
 		&mockStoredReqFetcher{},
 		&config.Configuration{MaxRequestSize: int64(len(reqBody) - 1)},
-		pbsmetrics.NewMetrics(metrics.NewRegistry(), openrtb_ext.BidderList()),
+		pbsmetrics.NewMetrics(metrics.NewRegistry(), openrtb_ext.BidderList(), config.AccountMetrics{}),
 		analyticsConf.NewPBSAnalytics(&config.Analytics{}),
+		nil,
 	}
 
 	req := httptest.NewRequest("POST", "/openrtb2/auction", strings.NewReader(reqBody))
@@ -455,8 +548,9 @@ func TestRequestSizeEdgeCase(t *testing.T) {
 		newParamsValidator(t),
 		&mockStoredReqFetcher{},
 		&config.Configuration{MaxRequestSize: int64(len(reqBody))},
-		pbsmetrics.NewMetrics(metrics.NewRegistry(), openrtb_ext.BidderList()),
+		pbsmetrics.NewMetrics(metrics.NewRegistry(), openrtb_ext.BidderList(), config.AccountMetrics{}),
 		analyticsConf.NewPBSAnalytics(&config.Analytics{}),
+		nil,
 	}
 
 	req := httptest.NewRequest("POST", "/openrtb2/auction", strings.NewReader(reqBody))
@@ -480,8 +574,8 @@ func TestNoEncoding(t *testing.T) {
 		newParamsValidator(t),
 		&mockStoredReqFetcher{},
 		&config.Configuration{MaxRequestSize: maxSize},
-		pbsmetrics.NewMetrics(metrics.NewRegistry(), openrtb_ext.BidderList()),
-		analyticsConf.NewPBSAnalytics(&config.Analytics{}))
+		pbsmetrics.NewMetrics(metrics.NewRegistry(), openrtb_ext.BidderList(), config.AccountMetrics{}),
+		analyticsConf.NewPBSAnalytics(&config.Analytics{}), nil)
 	request := httptest.NewRequest("POST", "/openrtb2/auction", strings.NewReader(validRequest(t, "site.json")))
 	recorder := httptest.NewRecorder()
 	endpoint(recorder, request, nil)
@@ -507,8 +601,8 @@ func TestContentType(t *testing.T) {
 		newParamsValidator(t),
 		&mockStoredReqFetcher{},
 		&config.Configuration{MaxRequestSize: maxSize},
-		pbsmetrics.NewMetrics(metrics.NewRegistry(), openrtb_ext.BidderList()),
-		analyticsConf.NewPBSAnalytics(&config.Analytics{}))
+		pbsmetrics.NewMetrics(metrics.NewRegistry(), openrtb_ext.BidderList(), config.AccountMetrics{}),
+		analyticsConf.NewPBSAnalytics(&config.Analytics{}), nil)
 	request := httptest.NewRequest("POST", "/openrtb2/auction", strings.NewReader(validRequest(t, "site.json")))
 	recorder := httptest.NewRecorder()
 	endpoint(recorder, request, nil)
@@ -531,7 +625,7 @@ type nobidExchange struct {
 	gotRequest *openrtb.BidRequest
 }
 
-func (e *nobidExchange) HoldAuction(ctx context.Context, bidRequest *openrtb.BidRequest, ids exchange.IdFetcher, labels pbsmetrics.Labels) (*openrtb.BidResponse, error) {
+func (e *nobidExchange) HoldAuction(ctx context.Context, bidRequest *openrtb.BidRequest, ids exchange.IdFetcher, labels pbsmetrics.Labels, details *analytics.AuctionDetails) (*openrtb.BidResponse, error) {
 	e.gotRequest = bidRequest
 	return &openrtb.BidResponse{
 		ID:    bidRequest.ID,
@@ -542,7 +636,7 @@ func (e *nobidExchange) HoldAuction(ctx context.Context, bidRequest *openrtb.Bid
 
 type brokenExchange struct{}
 
-func (e *brokenExchange) HoldAuction(ctx context.Context, bidRequest *openrtb.BidRequest, ids exchange.IdFetcher, labels pbsmetrics.Labels) (*openrtb.BidResponse, error) {
+func (e *brokenExchange) HoldAuction(ctx context.Context, bidRequest *openrtb.BidRequest, ids exchange.IdFetcher, labels pbsmetrics.Labels, details *analytics.AuctionDetails) (*openrtb.BidResponse, error) {
 	return nil, errors.New("Critical, unrecoverable error.")
 }
 
@@ -841,11 +935,39 @@ func (cf mockStoredReqFetcher) FetchRequests(ctx context.Context, requestIDs []s
 	return testStoredRequestData, testStoredImpData, nil
 }
 
+// nestedStoredReqFetcher only returns the data which was asked for, and counts how many times it was called.
+type nestedStoredReqFetcher struct {
+	requests map[string]json.RawMessage
+	imps     map[string]json.RawMessage
+	calls    int
+}
+
+func (cf *nestedStoredReqFetcher) FetchRequests(ctx context.Context, requestIDs []string, impIDs []string) (requestData map[string]json.RawMessage, impData map[string]json.RawMessage, errs []error) {
+	cf.calls++
+	requestData = make(map[string]json.RawMessage, len(requestIDs))
+	for _, id := range requestIDs {
+		if data, ok := cf.requests[id]; ok {
+			requestData[id] = data
+		} else {
+			errs = append(errs, stored_requests.NotFoundError{ID: id, DataType: "Request"})
+		}
+	}
+	impData = make(map[string]json.RawMessage, len(impIDs))
+	for _, id := range impIDs {
+		if data, ok := cf.imps[id]; ok {
+			impData[id] = data
+		} else {
+			errs = append(errs, stored_requests.NotFoundError{ID: id, DataType: "Imp"})
+		}
+	}
+	return
+}
+
 type mockExchange struct {
 	lastRequest *openrtb.BidRequest
 }
 
-func (m *mockExchange) HoldAuction(ctx context.Context, bidRequest *openrtb.BidRequest, ids exchange.IdFetcher, labels pbsmetrics.Labels) (*openrtb.BidResponse, error) {
+func (m *mockExchange) HoldAuction(ctx context.Context, bidRequest *openrtb.BidRequest, ids exchange.IdFetcher, labels pbsmetrics.Labels, details *analytics.AuctionDetails) (*openrtb.BidResponse, error) {
 	m.lastRequest = bidRequest
 	return &openrtb.BidResponse{
 		SeatBid: []openrtb.SeatBid{{
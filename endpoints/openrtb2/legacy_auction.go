@@ -0,0 +1,158 @@
+package openrtb2
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/golang/glog"
+	"github.com/mxmCherry/openrtb"
+	"github.com/prebid/prebid-server/config"
+	"github.com/prebid/prebid-server/exchange"
+	"github.com/prebid/prebid-server/openrtb_ext"
+	"github.com/prebid/prebid-server/pbs"
+	"github.com/prebid/prebid-server/pbsmetrics"
+	"github.com/prebid/prebid-server/stored_requests"
+)
+
+// legacyAliases are the legacy bidder codes which aren't core BidderNames.
+var legacyAliases = map[string]string{
+	"districtm": string(openrtb_ext.BidderAppnexus),
+}
+
+// NewLegacyAuction makes a LegacyAuction which runs legacy /auction requests through the Exchange.
+func NewLegacyAuction(ex exchange.Exchange, requestsById stored_requests.Fetcher, cfg *config.Configuration, met pbsmetrics.MetricsEngine) (*LegacyAuction, error) {
+	if ex == nil || requestsById == nil || cfg == nil || met == nil {
+		return nil, errors.New("NewLegacyAuction requires non-nil arguments.")
+	}
+
+	return &LegacyAuction{
+		deps: &endpointDeps{
+			ex:               ex,
+			storedReqFetcher: requestsById,
+			cfg:              cfg,
+			metricsEngine:    met,
+		},
+	}, nil
+}
+
+// LegacyAuction translates requests from the legacy /auction endpoint into OpenRTB, so that they can be
+// served by the Exchange. This lets the legacy adapters.Adapter implementations be retired one at a time.
+// See config.LegacyAuction for the Bidders which use it.
+type LegacyAuction struct {
+	deps *endpointDeps
+}
+
+// HoldAuction asks the Bidders for bids on the legacy request's Ad Units.
+//
+// Stored Imps are merged into the Ad Units whose config_id matches one. Each Bidder's status fields
+// are updated from the results, and its bids are returned at the same index as the Bidder.
+func (a *LegacyAuction) HoldAuction(ctx context.Context, pbsReq *pbs.PBSRequest, bidders []*pbs.PBSBidder, labels pbsmetrics.Labels) ([]pbs.PBSBidSlice, error) {
+	req, err := pbsReq.ToOpenRTB(bidders, legacyAliases)
+	if err != nil {
+		return nil, err
+	}
+	if req, err = a.mergeStoredImps(ctx, req); err != nil {
+		return nil, err
+	}
+
+	resp, err := a.deps.ex.HoldAuction(ctx, req, pbsReq.Cookie, labels, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var respExt openrtb_ext.ExtBidResponse
+	if len(resp.Ext) > 0 {
+		if err := json.Unmarshal(resp.Ext, &respExt); err != nil {
+			glog.Warningf("Failed to parse the Exchange's response.ext for a legacy auction: %v", err)
+		}
+	}
+
+	bids := make([]pbs.PBSBidSlice, len(bidders))
+	for i, bidder := range bidders {
+		bids[i] = bidder.FromOpenRTB(resp, &respExt)
+	}
+	return bids, nil
+}
+
+// mergeStoredImps applies the Stored Imps referenced by the Imps. The legacy config_ids often point to the
+// host's legacy configs instead, so Imps whose ID doesn't match a Stored Imp are sent as they are.
+//
+// The legacy request decides which Bidders are called on each Ad Unit, so any other Bidders in the
+// Stored Imps are dropped.
+func (a *LegacyAuction) mergeStoredImps(ctx context.Context, req *openrtb.BidRequest) (*openrtb.BidRequest, error) {
+	ids := make([]string, 0, len(req.Imp))
+	for i := 0; i < len(req.Imp); i++ {
+		if id, err := jsonparser.GetString(req.Imp[i].Ext, "prebid", "storedrequest", "id"); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return req, nil
+	}
+
+	fetchCtx, cancel := context.WithTimeout(ctx, time.Duration(storedRequestTimeoutMillis)*time.Millisecond)
+	defer cancel()
+
+	_, storedImps, errs := a.deps.storedReqFetcher.FetchRequests(fetchCtx, nil, ids)
+	for _, err := range errs {
+		if _, ok := err.(stored_requests.NotFoundError); !ok {
+			return nil, fmt.Errorf("Failed to fetch Stored Imps for the legacy request: %v", err)
+		}
+	}
+
+	impExtKeys := make(map[string]map[string]bool, len(req.Imp))
+	for i := 0; i < len(req.Imp); i++ {
+		if id, err := jsonparser.GetString(req.Imp[i].Ext, "prebid", "storedrequest", "id"); err == nil {
+			if _, ok := storedImps[id]; !ok {
+				req.Imp[i].Ext = deleteStoredRequestID(req.Imp[i].Ext)
+			}
+		}
+		impExtKeys[req.Imp[i].ID] = objectKeys(req.Imp[i].Ext)
+	}
+
+	requestJson, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	resolvedJson, errs := a.deps.processStoredRequests(fetchCtx, requestJson)
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("Failed to merge Stored Imps into the legacy request: %v", errs)
+	}
+
+	resolved := &openrtb.BidRequest{}
+	if err := json.Unmarshal(resolvedJson, resolved); err != nil {
+		return nil, err
+	}
+	for i := 0; i < len(resolved.Imp); i++ {
+		requested := impExtKeys[resolved.Imp[i].ID]
+		for key := range objectKeys(resolved.Imp[i].Ext) {
+			if key != "prebid" && !requested[key] {
+				resolved.Imp[i].Ext = jsonparser.Delete(resolved.Imp[i].Ext, key)
+			}
+		}
+	}
+	return resolved, nil
+}
+
+// deleteStoredRequestID removes imp.ext.prebid.storedrequest, along with imp.ext.prebid if nothing else is left in it.
+func deleteStoredRequestID(impExt openrtb.RawJSON) openrtb.RawJSON {
+	impExt = jsonparser.Delete(impExt, "prebid", "storedrequest")
+	if prebid, _, _, err := jsonparser.Get(impExt, "prebid"); err == nil && len(objectKeys(prebid)) == 0 {
+		impExt = jsonparser.Delete(impExt, "prebid")
+	}
+	return impExt
+}
+
+// objectKeys returns the keys of a JSON object, or an empty set if the data isn't one.
+func objectKeys(data []byte) map[string]bool {
+	keys := make(map[string]bool)
+	jsonparser.ObjectEach(data, func(key []byte, _ []byte, _ jsonparser.ValueType, _ int) error {
+		keys[string(key)] = true
+		return nil
+	})
+	return keys
+}
@@ -0,0 +1,164 @@
+package openrtb2
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/buger/jsonparser"
+	"github.com/mxmCherry/openrtb"
+	"github.com/prebid/prebid-server/analytics"
+	"github.com/prebid/prebid-server/config"
+	"github.com/prebid/prebid-server/exchange"
+	"github.com/prebid/prebid-server/pbs"
+	"github.com/prebid/prebid-server/pbsmetrics"
+	"github.com/prebid/prebid-server/stored_requests"
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestLegacyAuction(t *testing.T) {
+	ex := &legacyExchange{
+		response: &openrtb.BidResponse{
+			SeatBid: []openrtb.SeatBid{{
+				Seat: "appnexus",
+				Bid: []openrtb.Bid{
+					{ImpID: "div1", Price: 1, AdM: "<div>1</div>", W: 300, H: 250, Ext: openrtb.RawJSON(`{"prebid":{"type":"video"}}`)},
+					{ImpID: "div2", Price: 2, AdM: "<div>2</div>", W: 728, H: 90, Ext: openrtb.RawJSON(`{"prebid":{"type":"banner"}}`)},
+				},
+			}},
+			Ext: openrtb.RawJSON(`{"errors":{"districtm":[{"code":1,"message":"Timed out"}]},"responsetimemillis":{"appnexus":15,"districtm":100}}`),
+		},
+	}
+	fetcher := &nestedStoredReqFetcher{
+		imps: map[string]json.RawMessage{
+			"stored-imp": json.RawMessage(`{"video":{"mimes":["video/mp4"]},"ext":{"appnexus":{"placementId":5},"rubicon":{"accountId":6}}}`),
+		},
+	}
+	auction := newTestLegacyAuction(t, ex, fetcher)
+
+	pbsReq, bidders := newLegacyRequest()
+	bids, err := auction.HoldAuction(context.Background(), pbsReq, bidders, pbsmetrics.Labels{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	imps := ex.lastRequest.Imp
+	if len(imps) != 2 {
+		t.Fatalf("Expected 2 Imps. Got %d", len(imps))
+	}
+	if imps[0].Video == nil || len(imps[0].Video.MIMEs) != 1 {
+		t.Errorf("The Stored Imp should be merged into div1. Got %s", mustMarshal(t, imps[0]))
+	}
+	if placementId, _ := jsonparser.GetInt(imps[0].Ext, "appnexus", "placementId"); placementId != 1 {
+		t.Errorf("The legacy params should take precedence over the Stored Imp. Got placementId %d", placementId)
+	}
+	if _, _, _, err := jsonparser.Get(imps[0].Ext, "rubicon"); err == nil {
+		t.Errorf("Bidders which the legacy request didn't ask for should be dropped from the Stored Imp. Got %s", string(imps[0].Ext))
+	}
+	if placementId, _ := jsonparser.GetInt(imps[0].Ext, "districtm", "placementId"); placementId != 3 {
+		t.Errorf("The legacy Bidders should be kept. Got %s", string(imps[0].Ext))
+	}
+	if _, _, _, err := jsonparser.Get(imps[1].Ext, "prebid"); err == nil {
+		t.Errorf("config_ids without a Stored Imp should be dropped. Got %s", string(imps[1].Ext))
+	}
+	if alias, _ := jsonparser.GetString(ex.lastRequest.Ext, "prebid", "aliases", "districtm"); alias != "appnexus" {
+		t.Errorf("districtm should be aliased to appnexus. Got %s", string(ex.lastRequest.Ext))
+	}
+
+	if len(bids) != 2 {
+		t.Fatalf("Expected bids for 2 bidders. Got %d", len(bids))
+	}
+	appnexus, districtm := bidders[0], bidders[1]
+	if len(bids[0]) != 2 || appnexus.NumBids != 2 || appnexus.ResponseTime != 15 {
+		t.Errorf("Expected 2 bids from appnexus in 15ms. Got %d bids, %d NumBids, %dms", len(bids[0]), appnexus.NumBids, appnexus.ResponseTime)
+	}
+	if bids[0][0].BidID != "bid1" || bids[0][0].CreativeMediaType != "video" || bids[0][0].Adm != "<div>1</div>" {
+		t.Errorf("The first bid was not translated properly. Got %#v", bids[0][0])
+	}
+	if len(bids[1]) != 0 || districtm.Error != "Timed out" || districtm.NoBid {
+		t.Errorf("districtm should have an error and no bids. Got %d bids, error %q", len(bids[1]), districtm.Error)
+	}
+}
+
+func TestLegacyAuctionExchangeError(t *testing.T) {
+	auction := newTestLegacyAuction(t, &brokenExchange{}, &nestedStoredReqFetcher{})
+	pbsReq, bidders := newLegacyRequest()
+	if _, err := auction.HoldAuction(context.Background(), pbsReq, bidders, pbsmetrics.Labels{}); err == nil {
+		t.Error("Errors from the Exchange should be returned.")
+	}
+}
+
+func TestLegacyAuctionStoredImpError(t *testing.T) {
+	auction := newTestLegacyAuction(t, &legacyExchange{}, &brokenStoredReqFetcher{})
+	pbsReq, bidders := newLegacyRequest()
+	if _, err := auction.HoldAuction(context.Background(), pbsReq, bidders, pbsmetrics.Labels{}); err == nil {
+		t.Error("Errors other than NotFoundErrors from the Fetcher should be returned.")
+	}
+}
+
+func TestNewLegacyAuctionNils(t *testing.T) {
+	if _, err := NewLegacyAuction(nil, &nestedStoredReqFetcher{}, &config.Configuration{}, pbsmetrics.NewMetrics(metrics.NewRegistry(), nil, config.AccountMetrics{})); err == nil {
+		t.Error("NewLegacyAuction should reject a nil Exchange.")
+	}
+}
+
+func newTestLegacyAuction(t *testing.T, ex exchange.Exchange, fetcher stored_requests.Fetcher) *LegacyAuction {
+	t.Helper()
+	auction, err := NewLegacyAuction(ex, fetcher, &config.Configuration{}, pbsmetrics.NewMetrics(metrics.NewRegistry(), nil, config.AccountMetrics{}))
+	if err != nil {
+		t.Fatalf("Failed to create the legacy auction: %v", err)
+	}
+	return auction
+}
+
+func newLegacyRequest() (*pbs.PBSRequest, []*pbs.PBSBidder) {
+	pbsReq := &pbs.PBSRequest{
+		AccountID: "acct",
+		Tid:       "tid",
+		AdUnits: []pbs.AdUnit{
+			{Code: "div1", ConfigID: "stored-imp"},
+			{Code: "div2", ConfigID: "legacy-config"},
+		},
+		Url:    "http://example.com/page",
+		Domain: "example.com",
+	}
+	bidders := []*pbs.PBSBidder{{
+		BidderCode: "appnexus",
+		AdUnits: []pbs.PBSAdUnit{
+			{Code: "div1", BidID: "bid1", Params: json.RawMessage(`{"placementId":1}`), MediaTypes: []pbs.MediaType{pbs.MEDIA_TYPE_BANNER}, Sizes: []openrtb.Format{{W: 300, H: 250}}},
+			{Code: "div2", BidID: "bid2", Params: json.RawMessage(`{"placementId":2}`), MediaTypes: []pbs.MediaType{pbs.MEDIA_TYPE_BANNER}, Sizes: []openrtb.Format{{W: 728, H: 90}}},
+		},
+	}, {
+		BidderCode: "districtm",
+		AdUnits: []pbs.PBSAdUnit{
+			{Code: "div1", BidID: "bid3", Params: json.RawMessage(`{"placementId":3}`), MediaTypes: []pbs.MediaType{pbs.MEDIA_TYPE_BANNER}, Sizes: []openrtb.Format{{W: 300, H: 250}}},
+		},
+	}}
+	return pbsReq, bidders
+}
+
+func mustMarshal(t *testing.T, value interface{}) string {
+	t.Helper()
+	data, err := json.Marshal(value)
+	if err != nil {
+		t.Fatalf("Failed to marshal %v: %v", value, err)
+	}
+	return string(data)
+}
+
+type legacyExchange struct {
+	lastRequest *openrtb.BidRequest
+	response    *openrtb.BidResponse
+}
+
+func (e *legacyExchange) HoldAuction(ctx context.Context, bidRequest *openrtb.BidRequest, ids exchange.IdFetcher, labels pbsmetrics.Labels, details *analytics.AuctionDetails) (*openrtb.BidResponse, error) {
+	e.lastRequest = bidRequest
+	return e.response, nil
+}
+
+type brokenStoredReqFetcher struct{}
+
+func (f *brokenStoredReqFetcher) FetchRequests(ctx context.Context, requestIDs []string, impIDs []string) (map[string]json.RawMessage, map[string]json.RawMessage, []error) {
+	return nil, nil, []error{errors.New("database is down")}
+}
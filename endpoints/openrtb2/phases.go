@@ -0,0 +1,31 @@
+package openrtb2
+
+import (
+	"time"
+
+	"github.com/prebid/prebid-server/pbsmetrics"
+)
+
+// phaseTimes holds how long each phase of a request took.
+//
+// Some phases finish before the request's metrics labels are known, so the times are
+// collected here and recorded when the request ends.
+type phaseTimes map[pbsmetrics.RequestPhase]time.Duration
+
+// since adds the time elapsed since start to the given phase.
+func (p phaseTimes) since(phase pbsmetrics.RequestPhase, start time.Time) {
+	p[phase] += time.Since(start)
+}
+
+func (p phaseTimes) record(metricsEngine pbsmetrics.MetricsEngine, labels pbsmetrics.Labels) {
+	for phase, length := range p {
+		metricsEngine.RecordRequestPhaseTime(labels, phase, length)
+	}
+}
+
+// recordTMaxExceeded counts the request if it ran past its timeout. A timeout of 0 means there wasn't one.
+func recordTMaxExceeded(metricsEngine pbsmetrics.MetricsEngine, labels pbsmetrics.Labels, start time.Time, timeout time.Duration) {
+	if timeout > 0 && time.Since(start) > timeout {
+		metricsEngine.RecordTMaxExceeded(labels)
+	}
+}
@@ -0,0 +1,103 @@
+package openrtb2
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/mxmCherry/openrtb"
+	"github.com/prebid/prebid-server/config"
+	"github.com/prebid/prebid-server/openrtb_ext"
+	"github.com/prebid/prebid-server/stored_requests/admin"
+)
+
+// NewStoredDataValidator returns an admin.Validator which checks Stored Requests and Stored Imps
+// with the same rules which parseRequest applies to the requests that use them.
+func NewStoredDataValidator(validator openrtb_ext.BidderParamValidator, cfg *config.Configuration) (admin.Validator, error) {
+	if validator == nil || cfg == nil {
+		return nil, errors.New("NewStoredDataValidator requires non-nil arguments.")
+	}
+	deps := &endpointDeps{paramsValidator: validator, cfg: cfg}
+	return deps.validateStoredData, nil
+}
+
+func (deps *endpointDeps) validateStoredData(dataType admin.DataType, data json.RawMessage) error {
+	if dataType == admin.ImpDataType {
+		var imp openrtb.Imp
+		if err := json.Unmarshal(data, &imp); err != nil {
+			return err
+		}
+		// Stored Imps which build on another Stored Imp can't be checked until the two are merged.
+		if _, hasParent, err := getStoredRequestId(data); err != nil || hasParent {
+			return err
+		}
+		return deps.validateImp(&imp, nil, 0)
+	}
+	return deps.validateStoredRequest(data)
+}
+
+// validateStoredRequest runs the checks from validateRequest on everything defined in the Stored Request.
+//
+// Stored Requests are merged with the HTTP request before the auction, so required fields (e.g. "id")
+// may come from the caller instead. Those are checked when the request is parsed.
+func (deps *endpointDeps) validateStoredRequest(data json.RawMessage) error {
+	var req openrtb.BidRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return err
+	}
+
+	if req.TMax < 0 {
+		return fmt.Errorf("request.tmax must be nonnegative. Got %d", req.TMax)
+	}
+
+	var aliases map[string]string
+	if bidExt, err := deps.parseBidExt(req.Ext); err != nil {
+		return err
+	} else if bidExt != nil {
+		aliases = bidExt.Prebid.Aliases
+
+		if err := deps.validateAliases(aliases); err != nil {
+			return err
+		}
+
+		if err := validateBidAdjustmentFactors(bidExt.Prebid.BidAdjustmentFactors, aliases); err != nil {
+			return err
+		}
+	}
+
+	// Imps which use a Stored Imp can't be checked until the two are merged.
+	_, _, storedImpIndices, errs := parseImpInfo(data)
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	usesStoredImp := make(map[int]bool, len(storedImpIndices))
+	for _, index := range storedImpIndices {
+		usesStoredImp[index] = true
+	}
+	for index, imp := range req.Imp {
+		if usesStoredImp[index] {
+			continue
+		}
+		if err := deps.validateImp(&imp, aliases, index); err != nil {
+			return err
+		}
+	}
+
+	if req.Site != nil && req.App != nil {
+		return errors.New("request.site or request.app must be defined, but not both.")
+	}
+
+	if err := deps.validateSite(req.Site); err != nil {
+		return err
+	}
+
+	if err := validateUser(req.User, aliases); err != nil {
+		return err
+	}
+
+	if err := validateRegs(req.Regs); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package openrtb2
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/prebid/prebid-server/config"
+	"github.com/prebid/prebid-server/stored_requests/admin"
+)
+
+func TestValidStoredData(t *testing.T) {
+	validate, err := NewStoredDataValidator(newParamsValidator(t), &config.Configuration{})
+	if err != nil {
+		t.Fatalf("Unexpected error making the validator: %v", err)
+	}
+
+	validData := map[admin.DataType][]string{
+		admin.RequestDataType: {
+			// Stored Requests don't need anything which the HTTP request could supply.
+			`{}`,
+			`{"site":{"page":"prebid.org"},"tmax":500}`,
+			`{"imp":[{"id":"some-imp","banner":{"format":[{"w":300,"h":250}]},"ext":{"appnexus":{"placementId":10433394}}}]}`,
+			// Imps which use a Stored Imp are checked once they're merged.
+			`{"imp":[{"id":"some-imp","ext":{"prebid":{"storedrequest":{"id":"stored-imp"}}}}]}`,
+			`{"ext":{"prebid":{"aliases":{"districtm":"appnexus"}}},"imp":[{"id":"some-imp","banner":{"format":[{"w":300,"h":250}]},"ext":{"districtm":{"placementId":10433394}}}]}`,
+		},
+		admin.ImpDataType: {
+			`{"id":"some-imp","banner":{"format":[{"w":300,"h":250}]},"ext":{"appnexus":{"placementId":10433394}}}`,
+			// Stored Imps which build on another Stored Imp are checked once they're merged.
+			`{"banner":{"format":[{"w":728,"h":90}]},"ext":{"prebid":{"storedrequest":{"id":"base-imp"}}}}`,
+		},
+	}
+	for dataType, examples := range validData {
+		for _, data := range examples {
+			if err := validate(dataType, json.RawMessage(data)); err != nil {
+				t.Errorf("Stored %s should be valid: %s. Got %v", dataType, data, err)
+			}
+		}
+	}
+}
+
+func TestInvalidStoredData(t *testing.T) {
+	validate, err := NewStoredDataValidator(newParamsValidator(t), &config.Configuration{})
+	if err != nil {
+		t.Fatalf("Unexpected error making the validator: %v", err)
+	}
+
+	invalidData := map[admin.DataType][]string{
+		admin.RequestDataType: {
+			`[]`,
+			`{"tmax":-1}`,
+			`{"site":{"page":"prebid.org"},"app":{"id":"some-app"}}`,
+			`{"site":{}}`,
+			`{"ext":{"prebid":{"aliases":{"districtm":"not-a-bidder"}}}}`,
+			`{"imp":[{"id":"some-imp","banner":{"format":[{"w":300,"h":250}]},"ext":{"unknown":{}}}]}`,
+			`{"imp":[{"id":"some-imp","ext":{"appnexus":{"placementId":10433394}}}]}`,
+			`{"regs":{"ext":{"gdpr":2}}}`,
+		},
+		admin.ImpDataType: {
+			`"not-an-imp"`,
+			`{"id":"some-imp","ext":{"prebid":{"storedrequest":{"id":5}}}}`,
+			`{"banner":{"format":[{"w":300,"h":250}]},"ext":{"appnexus":{"placementId":10433394}}}`,
+			`{"id":"some-imp","banner":{"format":[{"w":300,"h":250}]},"ext":{"appnexus":{"placementId":"bad"}}}`,
+		},
+	}
+	for dataType, examples := range invalidData {
+		for _, data := range examples {
+			if err := validate(dataType, json.RawMessage(data)); err == nil {
+				t.Errorf("Stored %s should be invalid: %s", dataType, data)
+			}
+		}
+	}
+}
+
+func TestNilStoredDataValidator(t *testing.T) {
+	if _, err := NewStoredDataValidator(nil, &config.Configuration{}); err == nil {
+		t.Error("NewStoredDataValidator should return an error when given a nil BidderParamValidator.")
+	}
+}
@@ -0,0 +1,494 @@
+package openrtb2
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/julienschmidt/httprouter"
+	"github.com/mxmCherry/openrtb"
+	"github.com/prebid/prebid-server/analytics"
+	"github.com/prebid/prebid-server/config"
+	"github.com/prebid/prebid-server/exchange"
+	"github.com/prebid/prebid-server/openrtb_ext"
+	"github.com/prebid/prebid-server/pbsmetrics"
+	"github.com/prebid/prebid-server/stored_requests"
+	"github.com/prebid/prebid-server/usersync"
+)
+
+// VideoRequest defines the contract for /openrtb2/video requests.
+//
+// Each request describes a single ad pod (for example, a mid-roll break in long-form video).
+// Prebid Server expands the pod into an OpenRTB imp for each slot which might be filled,
+// and picks the set of winning bids which best fills the pod.
+type VideoRequest struct {
+	// ID becomes the OpenRTB request.id. If empty, one will be generated.
+	ID  string   `json:"id,omitempty"`
+	Pod VideoPod `json:"pod"`
+	// Video is used as a template for every imp in the pod. The duration fields will be overwritten.
+	Video *openrtb.Video `json:"video"`
+	// Bidders holds the Bidder params for the pod, in the same format as imp.ext on /openrtb2/auction requests.
+	Bidders openrtb.RawJSON `json:"bidders"`
+
+	Site   *openrtb.Site   `json:"site,omitempty"`
+	App    *openrtb.App    `json:"app,omitempty"`
+	Device *openrtb.Device `json:"device,omitempty"`
+	User   *openrtb.User   `json:"user,omitempty"`
+	Regs   *openrtb.Regs   `json:"regs,omitempty"`
+	Test   int8            `json:"test,omitempty"`
+	TMax   int64           `json:"tmax,omitempty"`
+	Cur    []string        `json:"cur,omitempty"`
+	BCat   []string        `json:"bcat,omitempty"`
+	BAdv   []string        `json:"badv,omitempty"`
+	// Ext is used as the request.ext in the OpenRTB auction. Targeting and VAST caching will always be turned on.
+	Ext openrtb.RawJSON `json:"ext,omitempty"`
+}
+
+// VideoPod describes the ad break which should be filled.
+type VideoPod struct {
+	// DurationSec is the total length of the pod.
+	DurationSec int `json:"durationsec"`
+	// DurationRangeSec lists the ad durations which can go into the pod. Each one becomes a duration bucket,
+	// and bids are assumed to last as long as the bucket of the imp which they bid on.
+	DurationRangeSec []int `json:"durationrangesec"`
+	// RequireExactDuration is true if ads must be exactly as long as their bucket, rather than at most that long.
+	RequireExactDuration bool `json:"requireexactduration,omitempty"`
+	// MaxSlots caps the number of ads in the pod. If zero, the pod is only limited by its duration.
+	MaxSlots int `json:"maxslots,omitempty"`
+}
+
+// VideoResponse defines the contract for /openrtb2/video responses.
+type VideoResponse struct {
+	// AdPod holds the targeting keys for each ad in the pod, with the highest priced ads first.
+	AdPod  []map[string]string                                     `json:"adpod"`
+	Debug  *openrtb_ext.ExtResponseDebug                           `json:"debug,omitempty"`
+	Errors map[openrtb_ext.BidderName][]openrtb_ext.ExtBidderError `json:"errors,omitempty"`
+}
+
+// podCandidate is a bid which could fill one of the slots in the pod.
+type podCandidate struct {
+	bidder      string
+	bid         *openrtb.Bid
+	durationSec int
+	category    string
+	priceBucket string
+	vastCacheID string
+}
+
+// NewVideoEndpoint modifies the OpenRTB endpoint to handle ad pod requests. The request is expanded into
+// an OpenRTB request and auctioned as usual. The winners which fill the pod are then picked from the response.
+func NewVideoEndpoint(ex exchange.Exchange, validator openrtb_ext.BidderParamValidator, requestsById stored_requests.Fetcher, cfg *config.Configuration, met pbsmetrics.MetricsEngine, pbsAnalytics analytics.PBSAnalyticsModule, uidStore *usersync.UIDStoreClient) (httprouter.Handle, error) {
+	if ex == nil || validator == nil || requestsById == nil || cfg == nil || met == nil {
+		return nil, errors.New("NewVideoEndpoint requires non-nil arguments.")
+	}
+
+	return httprouter.Handle((&endpointDeps{ex, validator, requestsById, cfg, met, pbsAnalytics, uidStore}).VideoAuction), nil
+}
+
+func (deps *endpointDeps) VideoAuction(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+
+	ao := analytics.AuctionObject{
+		Status: http.StatusOK,
+		Errors: make([]error, 0),
+	}
+
+	start := time.Now()
+	labels := pbsmetrics.Labels{
+		Source:        pbsmetrics.DemandUnknown,
+		RType:         pbsmetrics.ReqTypeVideo,
+		PubID:         "",
+		Browser:       pbsmetrics.BrowserOther,
+		CookieFlag:    pbsmetrics.CookieFlagUnknown,
+		RequestStatus: pbsmetrics.RequestStatusOK,
+	}
+	numImps := 0
+	defer func() {
+		deps.metricsEngine.RecordRequest(labels)
+		deps.metricsEngine.RecordImps(labels, numImps)
+		deps.metricsEngine.RecordRequestTime(labels, time.Since(start))
+		deps.analytics.LogAuctionObject(&ao)
+	}()
+
+	if checkSafari(r) {
+		labels.Browser = pbsmetrics.BrowserSafari
+	}
+
+	videoReq, req, impDurations, errL := deps.parseVideoRequest(r)
+	if len(errL) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		for _, err := range errL {
+			w.Write([]byte(fmt.Sprintf("Invalid request format: %s\n", err.Error())))
+		}
+		ao.Errors = append(ao.Errors, errL...)
+		labels.RequestStatus = pbsmetrics.RequestStatusBadInput
+		return
+	}
+
+	if req.Site != nil && req.Site.Publisher != nil {
+		labels.PubID = req.Site.Publisher.ID
+	}
+	if req.App != nil && req.App.Publisher != nil {
+		labels.PubID = req.App.Publisher.ID
+	}
+
+	ctx := context.Background()
+	cancel := func() {}
+	timeout := deps.cfg.AuctionTimeouts.LimitAuctionTimeout(time.Duration(req.TMax) * time.Millisecond)
+	if timeout > 0 {
+		ctx, cancel = context.WithDeadline(ctx, start.Add(timeout))
+	}
+	defer cancel()
+
+	usersyncs := deps.uidStore.ParsePBSCookieFromRequest(r, &(deps.cfg.HostCookie))
+	if req.App != nil {
+		labels.Source = pbsmetrics.DemandApp
+	} else {
+		labels.Source = pbsmetrics.DemandWeb
+		if usersyncs.LiveSyncCount() == 0 {
+			labels.CookieFlag = pbsmetrics.CookieFlagNo
+		} else {
+			labels.CookieFlag = pbsmetrics.CookieFlagYes
+		}
+	}
+
+	numImps = len(req.Imp)
+	details := &analytics.AuctionDetails{}
+	response, err := deps.ex.HoldAuction(ctx, req, usersyncs, labels, details)
+	ao.Request = req
+	ao.Response = response
+	ao.Details = details
+	if err != nil {
+		labels.RequestStatus = pbsmetrics.RequestStatusErr
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "Critical error while running the auction: %v", err)
+		glog.Errorf("/openrtb2/video Critical error: %v", err)
+		ao.Status = http.StatusInternalServerError
+		ao.Errors = append(ao.Errors, err)
+		return
+	}
+
+	granularity := openrtb_ext.PriceGranularityFromString("med")
+	var requestExt openrtb_ext.ExtRequest
+	if err := json.Unmarshal(req.Ext, &requestExt); err == nil && requestExt.Prebid.Targeting != nil {
+		granularity = requestExt.Prebid.Targeting.PriceGranularity
+	}
+	candidates := makePodCandidates(response, impDurations, granularity)
+	winners := selectPodWinners(candidates, videoReq.Pod)
+
+	videoResponse := VideoResponse{
+		AdPod: make([]map[string]string, len(winners)),
+	}
+	for i, winner := range winners {
+		videoResponse.AdPod[i] = winner.targeting()
+	}
+
+	var extResponse openrtb_ext.ExtBidResponse
+	if err := json.Unmarshal(response.Ext, &extResponse); err != nil {
+		ao.Errors = append(ao.Errors, fmt.Errorf("Video response: failed to unpack OpenRTB response.ext, debug info cannot be forwarded: %v", err))
+	} else {
+		videoResponse.Errors = extResponse.Errors
+		if req.Test == 1 {
+			videoResponse.Debug = extResponse.Debug
+		}
+	}
+
+	// Fixes #231
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+
+	// Fixes #328
+	w.Header().Set("Content-Type", "application/json")
+
+	// If an error happens when encoding the response, there isn't much we can do.
+	// If we've sent _any_ bytes, then Go would have sent the 200 status code first.
+	// That status code can't be un-sent... so the best we can do is log the error.
+	if err := enc.Encode(videoResponse); err != nil {
+		glog.Warningf("/openrtb2/video Failed to send response: %v", err)
+		labels.RequestStatus = pbsmetrics.RequestStatusNetworkErr
+		ao.Errors = append(ao.Errors, fmt.Errorf("/openrtb2/video Failed to send response: %v", err))
+	}
+}
+
+// parseVideoRequest turns the HTTP request into an OpenRTB request with an imp for each slot which might be filled.
+// It also returns the duration bucket for each imp ID.
+//
+// If the errors list is empty, then the returned request will be valid according to the OpenRTB 2.5 spec.
+// If the errors list has at least one element, then no guarantees are made about the returned values.
+func (deps *endpointDeps) parseVideoRequest(httpRequest *http.Request) (videoReq *VideoRequest, req *openrtb.BidRequest, impDurations map[string]int, errs []error) {
+	requestJson, err := deps.readRequestBody(httpRequest)
+	if err != nil {
+		errs = []error{err}
+		return
+	}
+
+	videoReq = &VideoRequest{}
+	if err := json.Unmarshal(requestJson, videoReq); err != nil {
+		errs = []error{err}
+		return
+	}
+	if err := validateVideoRequest(videoReq, deps.cfg.MaxVideoPodImps); err != nil {
+		errs = []error{err}
+		return
+	}
+
+	req, impDurations = expandVideoPod(videoReq)
+
+	// Populate any "missing" OpenRTB fields with info from other sources, (e.g. HTTP request headers).
+	deps.setFieldsImplicitly(httpRequest, req)
+
+	if errs = defaultVideoRequestExt(req); len(errs) > 0 {
+		return
+	}
+
+	if err := deps.validateRequest(req); err != nil {
+		errs = []error{err}
+		return
+	}
+	return
+}
+
+func validateVideoRequest(videoReq *VideoRequest, maxImps int) error {
+	if videoReq.Video == nil {
+		return errors.New("request missing required field: \"video\"")
+	}
+	if len(videoReq.Bidders) == 0 {
+		return errors.New("request missing required field: \"bidders\"")
+	}
+	if videoReq.Pod.DurationSec < 1 {
+		return errors.New("request.pod.durationsec must be a positive integer")
+	}
+	if len(videoReq.Pod.DurationRangeSec) == 0 {
+		return errors.New("request.pod.durationrangesec must contain at least one duration")
+	}
+	for i, duration := range videoReq.Pod.DurationRangeSec {
+		if duration < 1 {
+			return fmt.Errorf("request.pod.durationrangesec[%d] must be a positive integer", i)
+		}
+	}
+	if videoReq.Pod.MaxSlots < 0 {
+		return errors.New("request.pod.maxslots must not be negative")
+	}
+	numImps := 0
+	for _, duration := range uniqueDurations(videoReq.Pod.DurationRangeSec) {
+		numImps += podSlots(videoReq.Pod, duration)
+		if numImps > maxImps {
+			return fmt.Errorf("request.pod would expand into more than %d imps. Use fewer durations, a shorter pod or a lower maxslots", maxImps)
+		}
+	}
+	return nil
+}
+
+// podSlots returns the number of ads from the given duration bucket which might go into the pod.
+func podSlots(pod VideoPod, duration int) int {
+	slots := pod.DurationSec / duration
+	if pod.MaxSlots > 0 && slots > pod.MaxSlots {
+		slots = pod.MaxSlots
+	}
+	return slots
+}
+
+// expandVideoPod makes an OpenRTB request with enough imps in each duration bucket to fill the pod.
+// Imps are given IDs like "{duration}_{slot}", and the returned map gives the duration bucket for each one.
+func expandVideoPod(videoReq *VideoRequest) (*openrtb.BidRequest, map[string]int) {
+	durations := uniqueDurations(videoReq.Pod.DurationRangeSec)
+	imps := make([]openrtb.Imp, 0, len(durations))
+	impDurations := make(map[string]int, len(durations))
+	for _, duration := range durations {
+		slots := podSlots(videoReq.Pod, duration)
+		for slot := 1; slot <= slots; slot++ {
+			video := *videoReq.Video
+			video.MaxDuration = int64(duration)
+			if videoReq.Pod.RequireExactDuration {
+				video.MinDuration = int64(duration)
+			}
+			id := fmt.Sprintf("%d_%d", duration, slot)
+			imps = append(imps, openrtb.Imp{
+				ID:    id,
+				Video: &video,
+				Ext:   videoReq.Bidders,
+			})
+			impDurations[id] = duration
+		}
+	}
+
+	id := videoReq.ID
+	if id == "" {
+		id = "video-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+
+	return &openrtb.BidRequest{
+		ID:     id,
+		Imp:    imps,
+		Site:   videoReq.Site,
+		App:    videoReq.App,
+		Device: videoReq.Device,
+		User:   videoReq.User,
+		Regs:   videoReq.Regs,
+		Test:   videoReq.Test,
+		TMax:   videoReq.TMax,
+		Cur:    videoReq.Cur,
+		BCat:   videoReq.BCat,
+		BAdv:   videoReq.BAdv,
+		Ext:    videoReq.Ext,
+	}, impDurations
+}
+
+func uniqueDurations(durations []int) []int {
+	seen := make(map[int]bool, len(durations))
+	unique := make([]int, 0, len(durations))
+	for _, duration := range durations {
+		if !seen[duration] {
+			seen[duration] = true
+			unique = append(unique, duration)
+		}
+	}
+	sort.Ints(unique)
+	return unique
+}
+
+// The pod can't be filled without the bidders' targeting keys and VAST XML in the cache.
+// Turn them on here, while respecting any other options which the caller chose.
+func defaultVideoRequestExt(req *openrtb.BidRequest) (errs []error) {
+	extRequest := &openrtb_ext.ExtRequest{}
+	if len(req.Ext) > 0 {
+		if err := json.Unmarshal(req.Ext, extRequest); err != nil {
+			return []error{err}
+		}
+	}
+
+	if extRequest.Prebid.Targeting == nil {
+		extRequest.Prebid.Targeting = &openrtb_ext.ExtRequestTargeting{
+			PriceGranularity: openrtb_ext.PriceGranularityFromString("med"),
+		}
+	}
+	// Every Bidder's best bid on each imp is a candidate for the pod, so each one needs its own keys.
+	extRequest.Prebid.Targeting.IncludeBidderKeys = true
+	if extRequest.Prebid.Cache == nil {
+		extRequest.Prebid.Cache = &openrtb_ext.ExtRequestPrebidCache{}
+	}
+	if extRequest.Prebid.Cache.VastXML == nil {
+		extRequest.Prebid.Cache.VastXML = &openrtb_ext.ExtRequestPrebidCacheVAST{}
+	}
+
+	newExt, err := json.Marshal(extRequest)
+	if err != nil {
+		return []error{err}
+	}
+	req.Ext = newExt
+	return nil
+}
+
+// makePodCandidates finds the bids in the response which could go into the pod.
+// Bids only qualify if their VAST XML was cached, since the ad server has no other way to fetch them.
+func makePodCandidates(response *openrtb.BidResponse, impDurations map[string]int, granularity openrtb_ext.PriceGranularity) []podCandidate {
+	candidates := make([]podCandidate, 0, len(impDurations))
+	for _, seatBid := range response.SeatBid {
+		for i := 0; i < len(seatBid.Bid); i++ {
+			bid := &seatBid.Bid[i]
+			duration, ok := impDurations[bid.ImpID]
+			if !ok {
+				continue
+			}
+			var bidExt openrtb_ext.ExtBid
+			if err := json.Unmarshal(bid.Ext, &bidExt); err != nil || bidExt.Prebid == nil {
+				continue
+			}
+			vastCacheID := findVastCacheID(bidExt.Prebid.Targeting)
+			if vastCacheID == "" {
+				continue
+			}
+			priceBucket, err := exchange.GetCpmStringValue(bid.Price, granularity)
+			if err != nil {
+				continue
+			}
+			candidate := podCandidate{
+				bidder:      seatBid.Seat,
+				bid:         bid,
+				durationSec: duration,
+				priceBucket: priceBucket,
+				vastCacheID: vastCacheID,
+			}
+			// The exchange fills in the ad server's category if the host has configured a mapping.
+			if bidExt.Prebid.Category != "" {
+				candidate.category = bidExt.Prebid.Category
+			} else if len(bid.Cat) > 0 {
+				candidate.category = bid.Cat[0]
+			}
+			candidates = append(candidates, candidate)
+		}
+	}
+	return candidates
+}
+
+func findVastCacheID(targeting map[string]string) string {
+	// The exchange writes this as hb_uuid_{bidder}, but truncates long bidder names.
+	for key, value := range targeting {
+		if strings.HasPrefix(key, string(openrtb_ext.HbVastCacheKey)) {
+			return value
+		}
+	}
+	return ""
+}
+
+// selectPodWinners picks the bids which fill the pod, from the highest price down.
+//
+// A bid is skipped if it doesn't fit in the time left in the pod, if the pod has no slots left,
+// if another winner already has the same category, or if its creative has already won another slot.
+func selectPodWinners(candidates []podCandidate, pod VideoPod) []podCandidate {
+	sorted := make([]podCandidate, len(candidates))
+	copy(sorted, candidates)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].bid.Price > sorted[j].bid.Price
+	})
+
+	remainingSec := pod.DurationSec
+	usedCategories := make(map[string]bool)
+	usedCreatives := make(map[string]bool)
+	winners := make([]podCandidate, 0, len(sorted))
+	for _, candidate := range sorted {
+		if pod.MaxSlots > 0 && len(winners) >= pod.MaxSlots {
+			break
+		}
+		if candidate.durationSec > remainingSec {
+			continue
+		}
+		if candidate.category != "" && usedCategories[candidate.category] {
+			continue
+		}
+		// Bids without a crid can't be told apart, so they're never treated as duplicates.
+		creative := ""
+		if candidate.bid.CrID != "" {
+			creative = candidate.bidder + "|" + candidate.bid.CrID
+		}
+		if creative != "" && usedCreatives[creative] {
+			continue
+		}
+
+		winners = append(winners, candidate)
+		remainingSec -= candidate.durationSec
+		if creative != "" {
+			usedCreatives[creative] = true
+		}
+		if candidate.category != "" {
+			usedCategories[candidate.category] = true
+		}
+	}
+	return winners
+}
+
+// targeting returns the keys which the ad server needs to serve this bid in its slot.
+func (candidate podCandidate) targeting() map[string]string {
+	return map[string]string{
+		string(openrtb_ext.HbpbConstantKey):       candidate.priceBucket,
+		string(openrtb_ext.HbBidderConstantKey):   candidate.bidder,
+		string(openrtb_ext.HbVastCacheKey):        candidate.vastCacheID,
+		string(openrtb_ext.HbCategoryDurationKey): fmt.Sprintf("%s_%s_%ds", candidate.priceBucket, candidate.category, candidate.durationSec),
+	}
+}
@@ -0,0 +1,290 @@
+package openrtb2
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mxmCherry/openrtb"
+	"github.com/prebid/prebid-server/analytics"
+	analyticsConf "github.com/prebid/prebid-server/analytics/config"
+	"github.com/prebid/prebid-server/config"
+	"github.com/prebid/prebid-server/exchange"
+	"github.com/prebid/prebid-server/openrtb_ext"
+	"github.com/prebid/prebid-server/pbsmetrics"
+	"github.com/prebid/prebid-server/stored_requests/backends/empty_fetcher"
+	"github.com/rcrowley/go-metrics"
+)
+
+const maxVideoPodImps = 10
+
+const validVideoRequest = `{
+	"id": "some-pod-id",
+	"pod": {
+		"durationsec": 60,
+		"durationrangesec": [30, 15],
+		"maxslots": 3
+	},
+	"video": {
+		"mimes": ["video/mp4"],
+		"w": 640,
+		"h": 480
+	},
+	"bidders": {
+		"appnexus": {
+			"placementId": 10433394
+		}
+	},
+	"site": {
+		"page": "prebid.org"
+	}
+}`
+
+// TestGoodVideoRequest makes sure that the pod is expanded into imps, and filled with the best bids that fit.
+func TestGoodVideoRequest(t *testing.T) {
+	ex := &mockVideoExchange{}
+	recorder := doVideoRequest(t, ex, validVideoRequest)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status %d. Got %d. Response was: %s", http.StatusOK, recorder.Code, recorder.Body.String())
+	}
+
+	// 60 seconds fits two 30 second ads, and four 15 second ones... but the pod has a maximum of 3 slots.
+	if len(ex.lastRequest.Imp) != 5 {
+		t.Errorf("Expected the pod to expand into 5 imps. Got %d", len(ex.lastRequest.Imp))
+	}
+	for _, imp := range ex.lastRequest.Imp {
+		if imp.Video == nil || len(imp.Video.MIMEs) != 1 {
+			t.Errorf("imp %s should copy the video template. Got %#v", imp.ID, imp.Video)
+		}
+	}
+
+	var requestExt openrtb_ext.ExtRequest
+	if err := json.Unmarshal(ex.lastRequest.Ext, &requestExt); err != nil {
+		t.Fatalf("Failed to unmarshal request.ext: %v", err)
+	}
+	if requestExt.Prebid.Targeting == nil || !requestExt.Prebid.Targeting.IncludeBidderKeys {
+		t.Errorf("Video requests must include the targeting keys for each bidder.")
+	}
+	if requestExt.Prebid.Cache == nil || requestExt.Prebid.Cache.VastXML == nil {
+		t.Errorf("Video requests must cache the VAST XML.")
+	}
+
+	var response VideoResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Error unmarshalling response: %v", err)
+	}
+	if len(response.AdPod) != 2 {
+		t.Fatalf("Expected 2 ads in the pod. Got %d: %v", len(response.AdPod), response.AdPod)
+	}
+	assertTargeting(t, response.AdPod[0], "hb_pb_cat_dur", "10.00_IAB1_30s")
+	assertTargeting(t, response.AdPod[0], "hb_uuid", "appnexus-30")
+	assertTargeting(t, response.AdPod[0], "hb_bidder", "appnexus")
+	assertTargeting(t, response.AdPod[1], "hb_pb_cat_dur", "6.00_news_15s")
+	assertTargeting(t, response.AdPod[1], "hb_uuid", "rubicon-15")
+}
+
+func TestVideoExactDurations(t *testing.T) {
+	videoReq := &VideoRequest{
+		Pod: VideoPod{
+			DurationSec:          30,
+			DurationRangeSec:     []int{15, 30, 15},
+			RequireExactDuration: true,
+		},
+		Video: &openrtb.Video{MIMEs: []string{"video/mp4"}},
+	}
+	req, impDurations := expandVideoPod(videoReq)
+	if len(req.Imp) != 3 {
+		t.Fatalf("Expected 3 imps. Got %d", len(req.Imp))
+	}
+	for _, imp := range req.Imp {
+		duration := int64(impDurations[imp.ID])
+		if imp.Video.MinDuration != duration || imp.Video.MaxDuration != duration {
+			t.Errorf("imp %s should require a duration of exactly %d. Got [%d, %d]", imp.ID, duration, imp.Video.MinDuration, imp.Video.MaxDuration)
+		}
+	}
+	if videoReq.Video.MaxDuration != 0 {
+		t.Errorf("Expanding the pod should not modify the video template.")
+	}
+}
+
+func TestSelectPodWinners(t *testing.T) {
+	candidates := []podCandidate{
+		newPodCandidate("appnexus", "a1", 5, 30, ""),
+		newPodCandidate("appnexus", "a2", 9, 30, "IAB1"),
+		newPodCandidate("rubicon", "r1", 8, 15, "IAB1"),
+		newPodCandidate("rubicon", "r2", 7, 45, "IAB2"),
+		newPodCandidate("rubicon", "r3", 4, 15, ""),
+		newPodCandidate("appnexus", "a2", 3, 15, "IAB3"),
+	}
+	winners := selectPodWinners(candidates, VideoPod{DurationSec: 60})
+
+	// a2 wins first. r1 has the same category. r2 is too long for the time left. a1 fits.
+	// Then r3 can't fit, and a2 has already won.
+	expected := []string{"a2", "a1"}
+	if len(winners) != len(expected) {
+		t.Fatalf("Expected %d winners. Got %d", len(expected), len(winners))
+	}
+	for i, winner := range winners {
+		if winner.bid.CrID != expected[i] {
+			t.Errorf("Expected winner %d to be %s. Got %s", i, expected[i], winner.bid.CrID)
+		}
+	}
+
+	winners = selectPodWinners(candidates, VideoPod{DurationSec: 120, MaxSlots: 1})
+	if len(winners) != 1 || winners[0].bid.CrID != "a2" {
+		t.Errorf("Expected a single winner when the pod only has one slot. Got %d", len(winners))
+	}
+}
+
+// TestSelectPodWinnersWithoutCrID makes sure that bids with no creative ID aren't mistaken for the same creative.
+func TestSelectPodWinnersWithoutCrID(t *testing.T) {
+	candidates := []podCandidate{
+		newPodCandidate("appnexus", "", 5, 15, ""),
+		newPodCandidate("appnexus", "", 4, 15, ""),
+		newPodCandidate("appnexus", "", 3, 15, ""),
+	}
+	winners := selectPodWinners(candidates, VideoPod{DurationSec: 60})
+	if len(winners) != 3 {
+		t.Errorf("Expected all 3 bids without a crid to win. Got %d", len(winners))
+	}
+}
+
+func TestBadVideoRequests(t *testing.T) {
+	badRequests := []string{
+		`{"pod":{"durationsec":60,"durationrangesec":[30]},"bidders":{"appnexus":{"placementId":10433394}},"site":{"page":"prebid.org"}}`,
+		`{"pod":{"durationsec":60,"durationrangesec":[30]},"video":{"mimes":["video/mp4"]},"site":{"page":"prebid.org"}}`,
+		`{"pod":{"durationrangesec":[30]},"video":{"mimes":["video/mp4"]},"bidders":{"appnexus":{"placementId":10433394}},"site":{"page":"prebid.org"}}`,
+		`{"pod":{"durationsec":60},"video":{"mimes":["video/mp4"]},"bidders":{"appnexus":{"placementId":10433394}},"site":{"page":"prebid.org"}}`,
+		`{"pod":{"durationsec":60,"durationrangesec":[0]},"video":{"mimes":["video/mp4"]},"bidders":{"appnexus":{"placementId":10433394}},"site":{"page":"prebid.org"}}`,
+		`{"pod":{"durationsec":60,"durationrangesec":[30],"maxslots":-1},"video":{"mimes":["video/mp4"]},"bidders":{"appnexus":{"placementId":10433394}},"site":{"page":"prebid.org"}}`,
+		`{"pod":{"durationsec":60,"durationrangesec":[30]},"video":{"mimes":[]},"bidders":{"appnexus":{"placementId":10433394}},"site":{"page":"prebid.org"}}`,
+		`{"pod":{"durationsec":60,"durationrangesec":[30]},"video":{"mimes":["video/mp4"]},"bidders":{"appnexus":{"placementId":10433394}}}`,
+		`{"pod":{"durationsec":60,"durationrangesec":[30]},"video":{"mimes":["video/mp4"]},"bidders":{"unknown":{}},"site":{"page":"prebid.org"}}`,
+		`{"pod":{"durationsec":10,"durationrangesec":[30]},"video":{"mimes":["video/mp4"]},"bidders":{"appnexus":{"placementId":10433394}},"site":{"page":"prebid.org"}}`,
+		`{"pod":{"durationsec":2147483647,"durationrangesec":[1]},"video":{"mimes":["video/mp4"]},"bidders":{"appnexus":{"placementId":10433394}},"site":{"page":"prebid.org"}}`,
+		`{"pod":{"durationsec":60,"durationrangesec":[10,15,30]},"video":{"mimes":["video/mp4"]},"bidders":{"appnexus":{"placementId":10433394}},"site":{"page":"prebid.org"}}`,
+		`not json`,
+	}
+	for _, badRequest := range badRequests {
+		recorder := doVideoRequest(t, &mockVideoExchange{}, badRequest)
+		if recorder.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d. Got %d. Request was: %s", http.StatusBadRequest, recorder.Code, badRequest)
+		}
+	}
+}
+
+// TestVideoPodImpLimit makes sure that pods are allowed up to the host's imp limit, and no further.
+func TestVideoPodImpLimit(t *testing.T) {
+	pod := VideoPod{DurationSec: 60, DurationRangeSec: []int{10, 15, 30}}
+	videoReq := &VideoRequest{
+		Pod:     pod,
+		Video:   &openrtb.Video{MIMEs: []string{"video/mp4"}},
+		Bidders: openrtb.RawJSON(`{"appnexus":{"placementId":10433394}}`),
+	}
+
+	// 6 + 4 + 2 imps
+	if err := validateVideoRequest(videoReq, 12); err != nil {
+		t.Errorf("A pod with 12 imps should be allowed when the limit is 12. Got %v", err)
+	}
+	if err := validateVideoRequest(videoReq, 11); err == nil {
+		t.Errorf("A pod with 12 imps should be rejected when the limit is 11.")
+	}
+
+	videoReq.Pod.MaxSlots = 3
+	if err := validateVideoRequest(videoReq, 8); err != nil {
+		t.Errorf("maxslots should count towards the imp limit. Got %v", err)
+	}
+}
+
+// TestVideoExchangeError makes sure we return a 500 if the exchange auction fails.
+func TestVideoExchangeError(t *testing.T) {
+	recorder := doVideoRequest(t, &brokenExchange{}, validVideoRequest)
+	if recorder.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status %d. Got %d", http.StatusInternalServerError, recorder.Code)
+	}
+}
+
+func TestNilVideoExchange(t *testing.T) {
+	theMetrics := pbsmetrics.NewMetrics(metrics.NewRegistry(), openrtb_ext.BidderList(), config.AccountMetrics{})
+	_, err := NewVideoEndpoint(nil, newParamsValidator(t), empty_fetcher.EmptyFetcher{}, &config.Configuration{MaxRequestSize: maxSize, MaxVideoPodImps: maxVideoPodImps}, theMetrics, analyticsConf.NewPBSAnalytics(&config.Analytics{}), nil)
+	if err == nil {
+		t.Errorf("NewVideoEndpoint should return an error when given a nil Exchange.")
+	}
+}
+
+func doVideoRequest(t *testing.T, ex exchange.Exchange, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	theMetrics := pbsmetrics.NewMetrics(metrics.NewRegistry(), openrtb_ext.BidderList(), config.AccountMetrics{})
+	endpoint, err := NewVideoEndpoint(ex, newParamsValidator(t), empty_fetcher.EmptyFetcher{}, &config.Configuration{MaxRequestSize: maxSize, MaxVideoPodImps: maxVideoPodImps}, theMetrics, analyticsConf.NewPBSAnalytics(&config.Analytics{}), nil)
+	if err != nil {
+		t.Fatalf("Failed to create the video endpoint: %v", err)
+	}
+	request := httptest.NewRequest("POST", "/openrtb2/video", strings.NewReader(body))
+	recorder := httptest.NewRecorder()
+	endpoint(recorder, request, nil)
+	return recorder
+}
+
+func assertTargeting(t *testing.T, targeting map[string]string, key string, expected string) {
+	t.Helper()
+	if targeting[key] != expected {
+		t.Errorf("Expected targeting %s to be %s. Got %s", key, expected, targeting[key])
+	}
+}
+
+func newPodCandidate(bidder string, crID string, price float64, duration int, category string) podCandidate {
+	return podCandidate{
+		bidder:      bidder,
+		bid:         &openrtb.Bid{CrID: crID, Price: price},
+		durationSec: duration,
+		category:    category,
+	}
+}
+
+// mockVideoExchange bids on the imps in the pod with a mix of bids, only some of which should win.
+type mockVideoExchange struct {
+	lastRequest *openrtb.BidRequest
+}
+
+func (m *mockVideoExchange) HoldAuction(ctx context.Context, bidRequest *openrtb.BidRequest, ids exchange.IdFetcher, labels pbsmetrics.Labels, details *analytics.AuctionDetails) (*openrtb.BidResponse, error) {
+	m.lastRequest = bidRequest
+	return &openrtb.BidResponse{
+		ID: bidRequest.ID,
+		SeatBid: []openrtb.SeatBid{{
+			Seat: "appnexus",
+			Bid: []openrtb.Bid{
+				mockVideoBid("30_1", "appnexus-creative", 10, "IAB1", `{"prebid":{"targeting":{"hb_uuid_appnexus":"appnexus-30"}}}`),
+				// The same creative shouldn't fill two slots in the pod.
+				mockVideoBid("30_2", "appnexus-creative", 10, "IAB1", `{"prebid":{"targeting":{"hb_uuid_appnexus":"appnexus-30"}}}`),
+			},
+		}, {
+			Seat: "rubicon",
+			Bid: []openrtb.Bid{
+				// Another ad in the same category shouldn't go into the pod.
+				mockVideoBid("15_1", "rubicon-1", 8, "IAB1", `{"prebid":{"targeting":{"hb_uuid_rubicon":"rubicon-15-cat"}}}`),
+				mockVideoBid("15_2", "rubicon-2", 6, "IAB2", `{"prebid":{"targeting":{"hb_uuid_rubicon":"rubicon-15"},"category":"news"}}`),
+			},
+		}, {
+			Seat: "openx",
+			Bid: []openrtb.Bid{
+				// The ad server can't fetch bids which didn't make it into the cache.
+				mockVideoBid("30_1", "openx-creative", 12, "IAB3", `{"prebid":{"targeting":{}}}`),
+			},
+		}},
+		Ext: openrtb.RawJSON(`{}`),
+	}, nil
+}
+
+func mockVideoBid(impID string, crID string, price float64, category string, ext string) openrtb.Bid {
+	return openrtb.Bid{
+		ID:    impID + "-" + crID,
+		ImpID: impID,
+		CrID:  crID,
+		Price: price,
+		Cat:   []string{category},
+		Ext:   openrtb.RawJSON(ext),
+	}
+}
@@ -8,6 +8,7 @@ const (
 	BadInputCode
 	BadServerResponseCode
 	FailedToRequestBidsCode
+	BidderTemporarilyDisabledCode
 )
 
 // We should use this code for any Error interface that is not in this package
@@ -88,6 +89,20 @@ func (err *FailedToRequestBids) Code() int {
 	return FailedToRequestBidsCode
 }
 
+// BidderTemporarilyDisabled should be used when a bidder was skipped because its circuit breaker is open.
+// This happens after too many of the bidder's recent requests failed or timed out.
+type BidderTemporarilyDisabled struct {
+	Message string
+}
+
+func (err *BidderTemporarilyDisabled) Error() string {
+	return err.Message
+}
+
+func (err *BidderTemporarilyDisabled) Code() int {
+	return BidderTemporarilyDisabledCode
+}
+
 // DecodeError provides the error code for an error, as defined above
 func DecodeError(err error) int {
 	if ce, ok := err.(Coder); ok {
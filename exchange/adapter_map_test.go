@@ -9,7 +9,7 @@ import (
 )
 
 func TestNewAdapterMap(t *testing.T) {
-	adapterMap := newAdapterMap(nil, &config.Configuration{}, adapters.ParseBidderInfos("../static/bidder-info", openrtb_ext.BidderList()))
+	adapterMap := newAdapterMap(&config.Configuration{}, adapters.ParseBidderInfos("../static/bidder-info", openrtb_ext.BidderList()))
 	for _, bidderName := range openrtb_ext.BidderMap {
 		if bidder, ok := adapterMap[bidderName]; bidder == nil || !ok {
 			t.Errorf("adapterMap missing expected Bidder: %s", string(bidderName))
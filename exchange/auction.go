@@ -78,7 +78,10 @@ func (a *auction) doCache(ctx context.Context, cache prebid_cache_client.Client,
 				}
 			}
 			if vast && topBidPerBidder.bidType == openrtb_ext.BidTypeVideo {
-				vast := makeVAST(topBidPerBidder.bid)
+				vast := topBidPerBidder.vast
+				if vast == "" {
+					vast = makeVAST(topBidPerBidder.bid)
+				}
 				if jsonBytes, err := json.Marshal(vast); err == nil {
 					toCache = append(toCache, prebid_cache_client.Cacheable{
 						Type: prebid_cache_client.TypeXML,
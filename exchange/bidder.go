@@ -6,11 +6,14 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strconv"
 
 	"github.com/mxmCherry/openrtb"
 	"github.com/prebid/prebid-server/adapters"
 	"github.com/prebid/prebid-server/errortypes"
 	"github.com/prebid/prebid-server/openrtb_ext"
+	"github.com/prebid/prebid-server/tracing"
 	"golang.org/x/net/context/ctxhttp"
 )
 
@@ -44,10 +47,16 @@ type adaptedBidder interface {
 // pbsOrtbBid.bid.Ext will become "response.seatbid[i].bid.ext.bidder" in the final OpenRTB response.
 // pbsOrtbBid.bidType will become "response.seatbid[i].bid.ext.prebid.type" in the final OpenRTB response.
 // pbsOrtbBid.bidTargets does not need to be filled out by the Bidder. It will be set later by the exchange.
+// pbsOrtbBid.bidEvents will become "response.seatbid[i].bid.ext.prebid.events". It's also set by the exchange.
 type pbsOrtbBid struct {
 	bid        *openrtb.Bid
 	bidType    openrtb_ext.BidType
 	bidTargets map[string]string
+	bidEvents  *openrtb_ext.ExtBidPrebidEvents
+	// vast is the modified VAST which gets cached for video bids. If it's empty, the bid's own VAST is cached.
+	vast string
+	// originalPrice is the price which the Bidder offered, before any bid adjustments were applied.
+	originalPrice float64
 }
 
 // pbsOrtbSeatBid is a SeatBid returned by an adaptedBidder.
@@ -66,6 +75,8 @@ type pbsOrtbSeatBid struct {
 	// if len(bids) > 0, this will become response.seatbid[i].ext.{bidder} on the final OpenRTB response.
 	// if len(bids) == 0, this will be ignored because the OpenRTB spec doesn't allow a SeatBid with 0 Bids.
 	ext openrtb.RawJSON
+	// nonBids lists the imps which this seat didn't get to bid on, and the bids which the exchange rejected.
+	nonBids []*nonBid
 }
 
 // adaptBidder converts an adapters.Bidder into an exchange.adaptedBidder.
@@ -85,13 +96,25 @@ type bidderAdapter struct {
 }
 
 func (bidder *bidderAdapter) requestBid(ctx context.Context, request *openrtb.BidRequest, name openrtb_ext.BidderName, bidAdjustment float64) (*pbsOrtbSeatBid, []error) {
+	// This span groups together the bidder's HTTP calls, so they can be told apart from the other bidders'.
+	ctx, span := tracing.StartSpan(ctx, "exchange.requestBid")
+	span.SetAttribute("bidder", string(name))
+	defer span.End()
+
 	reqData, errs := bidder.Bidder.MakeRequests(request)
+	// The bidder may have removed imps with media types it doesn't support. See adapters.EnforceBidderInfo.
+	removedImps := findUnsupportedImps(errs)
 
 	if len(reqData) == 0 {
 		// If the adapter failed to generate both requests and errors, this is an error.
 		if len(errs) == 0 {
 			errs = append(errs, &errortypes.FailedToRequestBids{Message: "The adapter failed to generate any bid requests, but also failed to generate an error explaining why"})
 		}
+		if len(removedImps) > 0 {
+			seatBid := &pbsOrtbSeatBid{currency: "USD"}
+			seatBid.rejectUnsupportedImps(removedImps)
+			return seatBid, errs
+		}
 		return nil, errs
 	}
 
@@ -113,6 +136,7 @@ func (bidder *bidderAdapter) requestBid(ctx context.Context, request *openrtb.Bi
 		currency:  "USD",
 		httpCalls: make([]*openrtb_ext.ExtHttpCall, 0, len(reqData)),
 	}
+	seatBid.rejectUnsupportedImps(removedImps)
 
 	firstHTTPCallCurrency := ""
 
@@ -148,16 +172,28 @@ func (bidder *bidderAdapter) requestBid(ctx context.Context, request *openrtb.Bi
 				// need to convert the bid price based on the currency.
 				if firstHTTPCallCurrency == bidResponse.Currency {
 					for i := 0; i < len(bidResponse.Bids); i++ {
+						var originalPrice float64
 						if bidResponse.Bids[i].Bid != nil {
+							originalPrice = bidResponse.Bids[i].Bid.Price
 							// TODO #280: Convert the bid price
 							bidResponse.Bids[i].Bid.Price = bidResponse.Bids[i].Bid.Price * bidAdjustment
 						}
 						seatBid.bids = append(seatBid.bids, &pbsOrtbBid{
-							bid:     bidResponse.Bids[i].Bid,
-							bidType: bidResponse.Bids[i].BidType,
+							bid:           bidResponse.Bids[i].Bid,
+							bidType:       bidResponse.Bids[i].BidType,
+							originalPrice: originalPrice,
 						})
 					}
 				} else {
+					for i := 0; i < len(bidResponse.Bids); i++ {
+						if bidResponse.Bids[i].Bid != nil {
+							seatBid.rejectBid(&pbsOrtbBid{
+								bid:           bidResponse.Bids[i].Bid,
+								bidType:       bidResponse.Bids[i].BidType,
+								originalPrice: bidResponse.Bids[i].Bid.Price,
+							}, openrtb_ext.NonBidInvalidCurrency)
+						}
+					}
 					errs = append(errs, fmt.Errorf(
 						"Bid currencies mistmatch found. Expected all bids to have the same currencies. Expected '%s', was: '%s'",
 						firstHTTPCallCurrency,
@@ -192,6 +228,17 @@ func makeExt(httpInfo *httpCallInfo) *openrtb_ext.ExtHttpCall {
 	}
 }
 
+// spanURL returns the URL without its query string, fragment or credentials, since bidders
+// often put the publisher's account details there.
+func spanURL(u *url.URL) string {
+	stripped := *u
+	stripped.User = nil
+	stripped.RawQuery = ""
+	stripped.ForceQuery = false
+	stripped.Fragment = ""
+	return stripped.String()
+}
+
 // doRequest makes a request, handles the response, and returns the data needed by the
 // Bidder interface.
 func (bidder *bidderAdapter) doRequest(ctx context.Context, req *adapters.RequestData) *httpCallInfo {
@@ -204,30 +251,40 @@ func (bidder *bidderAdapter) doRequest(ctx context.Context, req *adapters.Reques
 	}
 	httpReq.Header = req.Headers
 
+	ctx, span := tracing.StartSpan(ctx, "bidder.doRequest")
+	span.SetAttribute("http.method", req.Method)
+	span.SetAttribute("http.url", spanURL(httpReq.URL))
+	defer span.End()
+	tracing.InjectBidderRequest(ctx, httpReq)
+
 	httpResp, err := ctxhttp.Do(ctx, bidder.Client, httpReq)
 	if err != nil {
 		if err == context.DeadlineExceeded {
 			err = &errortypes.Timeout{Message: err.Error()}
 		}
+		span.SetError(err)
 		return &httpCallInfo{
 			request: req,
 			err:     err,
 		}
 	}
 
+	defer httpResp.Body.Close()
 	respBody, err := ioutil.ReadAll(httpResp.Body)
 	if err != nil {
+		span.SetError(err)
 		return &httpCallInfo{
 			request: req,
 			err:     err,
 		}
 	}
-	defer httpResp.Body.Close()
 
+	span.SetAttribute("http.status_code", strconv.Itoa(httpResp.StatusCode))
 	if httpResp.StatusCode < 200 || httpResp.StatusCode >= 400 {
 		err = &errortypes.BadServerResponse{
 			Message: fmt.Sprintf("Server responded with failure status: %d. Set request.test = 1 for debugging info.", httpResp.StatusCode),
 		}
+		span.SetError(err)
 	}
 
 	return &httpCallInfo{
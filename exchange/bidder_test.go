@@ -11,6 +11,7 @@ import (
 	"github.com/mxmCherry/openrtb"
 	"github.com/prebid/prebid-server/adapters"
 	"github.com/prebid/prebid-server/openrtb_ext"
+	"github.com/prebid/prebid-server/tracing"
 )
 
 // TestSingleBidder makes sure that the following things work if the Bidder needs only one request.
@@ -90,6 +91,9 @@ func TestSingleBidder(t *testing.T) {
 	if mockBidderResponse.Bids[1].Bid.Price != bidAdjustment*secondInitialPrice {
 		t.Errorf("Bid[1].Price was not adjusted properly. Expected %f, got %f", bidAdjustment*secondInitialPrice, mockBidderResponse.Bids[1].Bid.Price)
 	}
+	if seatBid.bids[0].originalPrice != firstInitialPrice || seatBid.bids[1].originalPrice != secondInitialPrice {
+		t.Errorf("The prices before adjustment should be kept. Got %f and %f", seatBid.bids[0].originalPrice, seatBid.bids[1].originalPrice)
+	}
 	if len(seatBid.httpCalls) != 0 {
 		t.Errorf("The bidder shouldn't log HttpCalls when request.test == 0. Found %d", len(seatBid.httpCalls))
 	}
@@ -208,6 +212,32 @@ func TestInvalidRequest(t *testing.T) {
 	}
 }
 
+// TestUnsupportedImpNonBids makes sure that only the imps removed by adapters.EnforceBidderInfo are reported
+// as non-bids, even if the Bidder changes request.Imp itself.
+func TestUnsupportedImpNonBids(t *testing.T) {
+	bidder := adapters.EnforceBidderInfo(&impSplittingBidder{}, adapters.BidderInfo{
+		Capabilities: &adapters.CapabilitiesInfo{
+			Site: &adapters.PlatformInfo{MediaTypes: []openrtb_ext.BidType{openrtb_ext.BidTypeBanner}},
+		},
+	})
+	request := &openrtb.BidRequest{
+		Imp: []openrtb.Imp{
+			{ID: "banner-1", Banner: &openrtb.Banner{}},
+			{ID: "video", Video: &openrtb.Video{}},
+			{ID: "banner-2", Banner: &openrtb.Banner{}},
+		},
+		Site: &openrtb.Site{},
+	}
+
+	seatBid, _ := adaptBidder(bidder, http.DefaultClient).requestBid(context.Background(), request, "test", 1)
+	if seatBid == nil || len(seatBid.nonBids) != 1 {
+		t.Fatalf("Expected one non-bid for the video imp. Got %v", seatBid)
+	}
+	if nonBid := seatBid.nonBids[0]; nonBid.impID != "video" || nonBid.reason != openrtb_ext.NonBidUnsupportedMediaType {
+		t.Errorf("Expected a %d non-bid for the video imp. Got %d for %s", openrtb_ext.NonBidUnsupportedMediaType, nonBid.reason, nonBid.impID)
+	}
+}
+
 // TestConnectionClose makes sure that bidderAdapter.doRequest returns errors if the connection closes unexpectedly.
 func TestConnectionClose(t *testing.T) {
 	var server *httptest.Server
@@ -230,6 +260,94 @@ func TestConnectionClose(t *testing.T) {
 	}
 }
 
+// TestTraceParentPropagation makes sure that bidderAdapter.doRequest sends a traceparent header to the bidder,
+// without changing the headers which the Bidder returned.
+func TestTraceParentPropagation(t *testing.T) {
+	tracing.SetTracer(tracing.NewTracer(discardSpans{}, 1, true))
+	defer tracing.SetTracer(nil)
+
+	var received string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get(tracing.TraceParentHeader)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	bidder := &bidderAdapter{
+		Bidder: &mixedMultiBidder{},
+		Client: server.Client(),
+	}
+	ctx, span := tracing.StartSpan(context.Background(), "auction")
+	headers := http.Header{}
+	callInfo := bidder.doRequest(ctx, &adapters.RequestData{
+		Method:  "POST",
+		Uri:     server.URL,
+		Headers: headers,
+	})
+	if callInfo.err != nil {
+		t.Fatalf("Unexpected error: %v", callInfo.err)
+	}
+
+	parent, ok := tracing.ParseTraceParent(received)
+	if !ok {
+		t.Fatalf("The bidder should get a valid traceparent header. Got %q", received)
+	}
+	if parent.TraceID != span.Context().TraceID {
+		t.Error("The traceparent header should continue the auction's trace")
+	}
+	if len(headers) != 0 {
+		t.Errorf("The Bidder's headers shouldn't be modified. Got %v", headers)
+	}
+}
+
+// TestSpanURL makes sure that the query string isn't recorded in bidder.doRequest spans.
+func TestSpanURL(t *testing.T) {
+	spans := &recordedSpans{}
+	tracing.SetTracer(tracing.NewTracer(spans, 1, false))
+	defer tracing.SetTracer(nil)
+
+	server := httptest.NewServer(mockHandler(204, "getBody", "postBody"))
+	defer server.Close()
+
+	bidder := &bidderAdapter{
+		Bidder: &mixedMultiBidder{},
+		Client: server.Client(),
+	}
+	ctx, span := tracing.StartSpan(context.Background(), "auction")
+	defer span.End()
+	bidder.doRequest(ctx, &adapters.RequestData{
+		Method: "GET",
+		Uri:    server.URL + "/bid?account=some-account#fragment",
+	})
+
+	if len(spans.spans) != 1 {
+		t.Fatalf("Expected a span for the request. Got %d", len(spans.spans))
+	}
+	if url := spans.spans[0].Attributes["http.url"]; url != server.URL+"/bid" {
+		t.Errorf("The span's http.url shouldn't have a query string or fragment. Got %s", url)
+	}
+}
+
+type discardSpans struct{}
+
+func (discardSpans) Export(span *tracing.SpanData) {}
+
+func (discardSpans) Close() error {
+	return nil
+}
+
+type recordedSpans struct {
+	spans []*tracing.SpanData
+}
+
+func (r *recordedSpans) Export(span *tracing.SpanData) {
+	r.spans = append(r.spans, span)
+}
+
+func (r *recordedSpans) Close() error {
+	return nil
+}
+
 // TestMultiCurrencies makes sure that bidderAdapter.requestBid returns errors if the bidder pass several currencies in case of multi HTTP calls.
 func TestMultiCurrencies(t *testing.T) {
 	// Setup:
@@ -543,6 +661,18 @@ func (bidder *mixedMultiBidder) MakeBids(internalRequest *openrtb.BidRequest, ex
 	return bidder.bidResponse, []error{errors.New("The bidResponse weren't ideal.")}
 }
 
+// impSplittingBidder keeps only the first imp in the request, like the Bidders which send one imp per call.
+type impSplittingBidder struct{}
+
+func (bidder *impSplittingBidder) MakeRequests(request *openrtb.BidRequest) ([]*adapters.RequestData, []error) {
+	request.Imp = request.Imp[:1]
+	return nil, []error{errors.New("The other imps will be sent later.")}
+}
+
+func (bidder *impSplittingBidder) MakeBids(internalRequest *openrtb.BidRequest, externalRequest *adapters.RequestData, response *adapters.ResponseData) (*adapters.BidderResponse, []error) {
+	return nil, nil
+}
+
 type bidRejector struct {
 	httpRequest  *adapters.RequestData
 	httpResponse *adapters.ResponseData
@@ -0,0 +1,169 @@
+package exchange
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/mxmCherry/openrtb"
+	"github.com/prebid/prebid-server/errortypes"
+	"github.com/prebid/prebid-server/openrtb_ext"
+)
+
+// categoryMapping translates IAB categories into the host's ad server categories.
+//
+// All functions on this type are nil-safe. A nil mapping leaves categories untranslated.
+type categoryMapping map[string]string
+
+// loadCategoryMapping reads the mapping from a JSON file. It returns a nil mapping if the filename is empty.
+func loadCategoryMapping(filename string) (categoryMapping, error) {
+	if filename == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var mapping categoryMapping
+	if err := json.Unmarshal(data, &mapping); err != nil {
+		return nil, fmt.Errorf("%s must contain a JSON object mapping IAB categories to ad server categories: %v", filename, err)
+	}
+	return mapping, nil
+}
+
+// translate returns the ad server category for an IAB category.
+// Subcategories which aren't in the mapping fall back to their parent (e.g. "IAB1-5" becomes whatever "IAB1" maps to).
+// Categories which can't be translated are returned unchanged.
+func (mapping categoryMapping) translate(iabCategory string) string {
+	if mapping == nil {
+		return iabCategory
+	}
+	if category, ok := mapping[iabCategory]; ok {
+		return category
+	}
+	if dash := strings.Index(iabCategory, "-"); dash > 0 {
+		if category, ok := mapping[iabCategory[:dash]]; ok {
+			return category
+		}
+	}
+	return iabCategory
+}
+
+// primaryCategory returns the translated category of the bid, or an empty string if it doesn't have one.
+func (mapping categoryMapping) primaryCategory(bid *openrtb.Bid) string {
+	if len(bid.Cat) == 0 {
+		return ""
+	}
+	return mapping.translate(bid.Cat[0])
+}
+
+// enforceBlocks removes the bids whose categories or advertiser domains are blocked by the request.
+// Bidders are expected to respect bcat and badv themselves, so the removed bids are reported as errors.
+func enforceBlocks(adapterBids map[openrtb_ext.BidderName]*pbsOrtbSeatBid, adapterExtra map[openrtb_ext.BidderName]*seatResponseExtra, bcat []string, badv []string) {
+	if len(bcat) == 0 && len(badv) == 0 {
+		return
+	}
+	for bidderName, seatBid := range adapterBids {
+		if seatBid == nil {
+			continue
+		}
+		var errs []error
+		allowedBids := make([]*pbsOrtbBid, 0, len(seatBid.bids))
+		for _, bid := range seatBid.bids {
+			if reason, err := checkBlocks(bid.bid, bcat, badv); err != nil {
+				seatBid.rejectBid(bid, reason)
+				errs = append(errs, err)
+			} else {
+				allowedBids = append(allowedBids, bid)
+			}
+		}
+		if len(errs) > 0 {
+			seatBid.bids = allowedBids
+			if extra := adapterExtra[bidderName]; extra != nil {
+				extra.Errors = append(extra.Errors, errsToBidderErrors(errs)...)
+			}
+		}
+	}
+}
+
+// checkBlocks returns an error if the bid is blocked by bcat or badv, along with the reason to record for it.
+func checkBlocks(bid *openrtb.Bid, bcat []string, badv []string) (openrtb_ext.NonBidReason, error) {
+	for _, category := range bid.Cat {
+		for _, blocked := range bcat {
+			// Blocking a tier 1 category (e.g. "IAB25") blocks all its subcategories too.
+			if category == blocked || strings.HasPrefix(category, blocked+"-") {
+				return openrtb_ext.NonBidBlockedCategory, &errortypes.BadServerResponse{
+					Message: fmt.Sprintf("Bid \"%s\" has category \"%s\", which is blocked by request.bcat", bid.ID, category),
+				}
+			}
+		}
+	}
+	for _, domain := range bid.ADomain {
+		domain = strings.ToLower(domain)
+		for _, blocked := range badv {
+			// Blocking a domain blocks its subdomains too.
+			blocked = strings.ToLower(blocked)
+			if domain == blocked || strings.HasSuffix(domain, "."+blocked) {
+				return openrtb_ext.NonBidBlockedAdvertiser, &errortypes.BadServerResponse{
+					Message: fmt.Sprintf("Bid \"%s\" has advertiser domain \"%s\", which is blocked by request.badv", bid.ID, domain),
+				}
+			}
+		}
+	}
+	return 0, nil
+}
+
+// dedupCategories removes bids so that no two imps are won by bids with the same primary category.
+//
+// Bids are considered from the highest price down. The best bid on each imp wins it, unless a better bid on another
+// imp already won with the same category. In that case the bid is removed, so that the next best one can win instead.
+// Bids without a category never conflict with each other.
+func dedupCategories(adapterBids map[openrtb_ext.BidderName]*pbsOrtbSeatBid, mapping categoryMapping) {
+	allBids := make([]*pbsOrtbBid, 0, len(adapterBids))
+	for _, seatBid := range adapterBids {
+		if seatBid != nil {
+			allBids = append(allBids, seatBid.bids...)
+		}
+	}
+	sort.SliceStable(allBids, func(i, j int) bool {
+		return allBids[i].bid.Price > allBids[j].bid.Price
+	})
+
+	wonImps := make(map[string]bool, len(allBids))
+	wonCategories := make(map[string]bool, len(allBids))
+	removed := make(map[*pbsOrtbBid]bool)
+	for _, bid := range allBids {
+		if wonImps[bid.bid.ImpID] {
+			continue
+		}
+		category := mapping.primaryCategory(bid.bid)
+		if category != "" && wonCategories[category] {
+			removed[bid] = true
+			continue
+		}
+		wonImps[bid.bid.ImpID] = true
+		if category != "" {
+			wonCategories[category] = true
+		}
+	}
+
+	if len(removed) == 0 {
+		return
+	}
+	for _, seatBid := range adapterBids {
+		if seatBid == nil {
+			continue
+		}
+		keptBids := make([]*pbsOrtbBid, 0, len(seatBid.bids))
+		for _, bid := range seatBid.bids {
+			if removed[bid] {
+				seatBid.rejectBid(bid, openrtb_ext.NonBidDuplicateCategory)
+			} else {
+				keptBids = append(keptBids, bid)
+			}
+		}
+		seatBid.bids = keptBids
+	}
+}
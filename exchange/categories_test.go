@@ -0,0 +1,173 @@
+package exchange
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/mxmCherry/openrtb"
+	"github.com/prebid/prebid-server/errortypes"
+	"github.com/prebid/prebid-server/openrtb_ext"
+)
+
+func TestCategoryTranslation(t *testing.T) {
+	mapping := categoryMapping{
+		"IAB1":   "arts",
+		"IAB1-5": "movies",
+	}
+	expected := map[string]string{
+		"IAB1":   "arts",
+		"IAB1-5": "movies",
+		"IAB1-6": "arts",
+		"IAB2":   "IAB2",
+		"IAB2-1": "IAB2-1",
+	}
+	for iab, category := range expected {
+		if translated := mapping.translate(iab); translated != category {
+			t.Errorf("Expected %s to translate to %s. Got %s", iab, category, translated)
+		}
+	}
+
+	var noMapping categoryMapping
+	if translated := noMapping.translate("IAB1-5"); translated != "IAB1-5" {
+		t.Errorf("A nil mapping should leave categories alone. Got %s", translated)
+	}
+}
+
+func TestLoadCategoryMapping(t *testing.T) {
+	mapping, err := loadCategoryMapping("")
+	if mapping != nil || err != nil {
+		t.Errorf("An empty filename should return no mapping. Got %v, %v", mapping, err)
+	}
+
+	file, err := ioutil.TempFile("", "categories")
+	if err != nil {
+		t.Fatalf("Failed to create a temp file: %v", err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString(`{"IAB1": "arts"}`)
+	file.Close()
+
+	mapping, err = loadCategoryMapping(file.Name())
+	if err != nil {
+		t.Fatalf("Unexpected error loading the category mapping: %v", err)
+	}
+	if mapping.translate("IAB1-1") != "arts" {
+		t.Errorf("The mapping wasn't loaded correctly. Got %v", mapping)
+	}
+
+	if _, err := loadCategoryMapping(file.Name() + "-missing"); err == nil {
+		t.Error("Expected an error loading a missing file.")
+	}
+}
+
+func TestEnforceBlocks(t *testing.T) {
+	adapterBids := map[openrtb_ext.BidderName]*pbsOrtbSeatBid{
+		"appnexus": {
+			bids: []*pbsOrtbBid{
+				categoryTestBid("allowed", "imp", 1, "IAB1-1", "example.com"),
+				categoryTestBid("blocked-cat", "imp", 2, "IAB25-3", "example.com"),
+				categoryTestBid("blocked-domain", "imp", 3, "IAB1", "ads.Blocked.com"),
+			},
+		},
+		"rubicon": {
+			bids: []*pbsOrtbBid{
+				categoryTestBid("no-cat", "imp", 4, "", ""),
+			},
+		},
+	}
+	adapterExtra := map[openrtb_ext.BidderName]*seatResponseExtra{
+		"appnexus": {},
+		"rubicon":  {},
+	}
+	enforceBlocks(adapterBids, adapterExtra, []string{"IAB25"}, []string{"blocked.com"})
+
+	assertBidIDs(t, adapterBids["appnexus"], "allowed")
+	assertBidIDs(t, adapterBids["rubicon"], "no-cat")
+	if len(adapterExtra["appnexus"].Errors) != 2 {
+		t.Fatalf("Expected 2 errors for the blocked bids. Got %d", len(adapterExtra["appnexus"].Errors))
+	}
+	for _, err := range adapterExtra["appnexus"].Errors {
+		if err.Code != errortypes.BadServerResponseCode {
+			t.Errorf("Blocked bids should be reported as bad server responses. Got code %d", err.Code)
+		}
+	}
+	if len(adapterExtra["rubicon"].Errors) != 0 {
+		t.Errorf("Expected no errors for rubicon. Got %v", adapterExtra["rubicon"].Errors)
+	}
+}
+
+func TestDedupCategories(t *testing.T) {
+	adapterBids := map[openrtb_ext.BidderName]*pbsOrtbSeatBid{
+		"appnexus": {
+			bids: []*pbsOrtbBid{
+				categoryTestBid("imp1-best", "imp1", 10, "IAB1-1", ""),
+				categoryTestBid("imp2-dup", "imp2", 9, "IAB1-2", ""),
+				categoryTestBid("imp3-nocat", "imp3", 5, "", ""),
+			},
+		},
+		"rubicon": {
+			bids: []*pbsOrtbBid{
+				categoryTestBid("imp2-next", "imp2", 7, "IAB2", ""),
+				categoryTestBid("imp1-loser", "imp1", 3, "IAB1-1", ""),
+				categoryTestBid("imp3-nocat-too", "imp3", 4, "", ""),
+			},
+		},
+	}
+	// Both IAB1 subcategories map to the same ad server category, so they conflict.
+	dedupCategories(adapterBids, categoryMapping{"IAB1": "arts"})
+
+	// imp1-loser can stay, since it doesn't win imp1 anyway.
+	assertBidIDs(t, adapterBids["appnexus"], "imp1-best", "imp3-nocat")
+	assertBidIDs(t, adapterBids["rubicon"], "imp2-next", "imp1-loser", "imp3-nocat-too")
+
+	auc := newAuction(adapterBids, 3)
+	if winner := auc.winningBids["imp2"]; winner == nil || winner.bid.ID != "imp2-next" {
+		t.Errorf("Expected imp2-next to win imp2 after deduplication.")
+	}
+}
+
+func TestDedupCategoriesWithoutMapping(t *testing.T) {
+	adapterBids := map[openrtb_ext.BidderName]*pbsOrtbSeatBid{
+		"appnexus": {
+			bids: []*pbsOrtbBid{
+				categoryTestBid("imp1", "imp1", 10, "IAB1-1", ""),
+				categoryTestBid("imp2", "imp2", 9, "IAB1-2", ""),
+			},
+		},
+		"rubicon": nil,
+	}
+	dedupCategories(adapterBids, nil)
+	assertBidIDs(t, adapterBids["appnexus"], "imp1", "imp2")
+}
+
+func categoryTestBid(id string, impID string, price float64, category string, domain string) *pbsOrtbBid {
+	bid := &openrtb.Bid{
+		ID:    id,
+		ImpID: impID,
+		Price: price,
+		CrID:  "creative",
+	}
+	if category != "" {
+		bid.Cat = []string{category}
+	}
+	if domain != "" {
+		bid.ADomain = []string{domain}
+	}
+	return &pbsOrtbBid{
+		bid:     bid,
+		bidType: openrtb_ext.BidTypeBanner,
+	}
+}
+
+func assertBidIDs(t *testing.T, seatBid *pbsOrtbSeatBid, expected ...string) {
+	t.Helper()
+	if len(seatBid.bids) != len(expected) {
+		t.Fatalf("Expected %d bids. Got %d", len(expected), len(seatBid.bids))
+	}
+	for i, bid := range seatBid.bids {
+		if bid.bid.ID != expected[i] {
+			t.Errorf("Expected bid %d to be %s. Got %s", i, expected[i], bid.bid.ID)
+		}
+	}
+}
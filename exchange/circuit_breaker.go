@@ -0,0 +1,234 @@
+package exchange
+
+import (
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prebid/prebid-server/config"
+	"github.com/prebid/prebid-server/errortypes"
+	"github.com/prebid/prebid-server/openrtb_ext"
+	"github.com/prebid/prebid-server/pbsmetrics"
+)
+
+// CircuitBreakers stop the exchange from calling bidders whose endpoints are failing, so that they don't
+// tie up connections until every auction's deadline. There's one breaker per core bidder, which is shared by its aliases.
+//
+// A nil *CircuitBreakers lets every request through.
+type CircuitBreakers struct {
+	breakers map[openrtb_ext.BidderName]*circuitBreaker
+}
+
+// CircuitBreakerStatus describes a bidder's circuit breaker, for the admin endpoint.
+type CircuitBreakerStatus struct {
+	State pbsmetrics.CircuitBreakerState `json:"state"`
+	// Requests and Failures count the outcomes in the breaker's window. They're only tracked while it's closed.
+	Requests int `json:"requests"`
+	Failures int `json:"failures"`
+	// OpenedAt is the last time the breaker opened, if it ever has.
+	OpenedAt *time.Time `json:"opened_at,omitempty"`
+}
+
+// NewCircuitBreakers makes a closed breaker for each bidder. It returns nil if circuit breakers aren't enabled.
+func NewCircuitBreakers(cfg config.CircuitBreaker, bidders []openrtb_ext.BidderName, metricsEngine pbsmetrics.MetricsEngine) *CircuitBreakers {
+	if !cfg.Enabled {
+		return nil
+	}
+	breakers := &CircuitBreakers{
+		breakers: make(map[openrtb_ext.BidderName]*circuitBreaker, len(bidders)),
+	}
+	for _, bidder := range bidders {
+		breakers.breakers[bidder] = newCircuitBreaker(bidder, cfg, metricsEngine, time.Now)
+	}
+	return breakers
+}
+
+// Status returns the current state of every bidder's circuit breaker.
+func (cb *CircuitBreakers) Status() map[openrtb_ext.BidderName]CircuitBreakerStatus {
+	if cb == nil {
+		return map[openrtb_ext.BidderName]CircuitBreakerStatus{}
+	}
+	status := make(map[openrtb_ext.BidderName]CircuitBreakerStatus, len(cb.breakers))
+	for bidder, breaker := range cb.breakers {
+		status[bidder] = breaker.status()
+	}
+	return status
+}
+
+// allow returns true if the bidder should be called. probe is true if the call will decide whether a
+// half-open breaker closes. The call's errors must then be passed to record(), along with probe.
+func (cb *CircuitBreakers) allow(bidder openrtb_ext.BidderName) (allowed bool, probe bool) {
+	if cb == nil {
+		return true, false
+	}
+	if breaker, ok := cb.breakers[bidder]; ok {
+		return breaker.allow()
+	}
+	return true, false
+}
+
+// record reports the errors from a call to the bidder.
+func (cb *CircuitBreakers) record(bidder openrtb_ext.BidderName, errs []error, probe bool) {
+	if cb == nil {
+		return
+	}
+	if breaker, ok := cb.breakers[bidder]; ok {
+		breaker.record(hasBidderFailure(errs), probe)
+	}
+}
+
+// hasBidderFailure returns true if any of the errors suggest that the bidder's endpoint is unhealthy.
+// Errors caused by the request itself, like BadInput, don't count.
+func hasBidderFailure(errs []error) bool {
+	for _, err := range errs {
+		switch errortypes.DecodeError(err) {
+		case errortypes.TimeoutCode, errortypes.BadServerResponseCode:
+			return true
+		}
+		// Connection errors aren't classified by errortypes, but the http.Client returns them as a *url.Error.
+		if _, ok := err.(*url.Error); ok {
+			return true
+		}
+	}
+	return false
+}
+
+type circuitBreaker struct {
+	bidder        openrtb_ext.BidderName
+	cfg           config.CircuitBreaker
+	metricsEngine pbsmetrics.MetricsEngine
+	now           func() time.Time
+
+	mutex sync.Mutex
+	state pbsmetrics.CircuitBreakerState
+	// outcomes is a ring buffer of the last cfg.WindowSize requests. true means that the request failed.
+	outcomes []bool
+	next     int
+	requests int
+	failures int
+	openedAt time.Time
+	// These track the probe requests while the breaker is half-open.
+	probesInFlight int
+	probeSuccesses int
+}
+
+func newCircuitBreaker(bidder openrtb_ext.BidderName, cfg config.CircuitBreaker, metricsEngine pbsmetrics.MetricsEngine, now func() time.Time) *circuitBreaker {
+	breaker := &circuitBreaker{
+		bidder:        bidder,
+		cfg:           cfg,
+		metricsEngine: metricsEngine,
+		now:           now,
+		state:         pbsmetrics.CircuitBreakerClosed,
+		outcomes:      make([]bool, cfg.WindowSize),
+	}
+	metricsEngine.RecordAdapterCircuitBreakerState(bidder, breaker.state)
+	return breaker
+}
+
+func (b *circuitBreaker) allow() (allowed bool, probe bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.state {
+	case pbsmetrics.CircuitBreakerClosed:
+		return true, false
+	case pbsmetrics.CircuitBreakerOpen:
+		if b.now().Sub(b.openedAt) < b.cfg.OpenTimeDuration() {
+			return false, false
+		}
+		b.probesInFlight = 0
+		b.probeSuccesses = 0
+		b.setState(pbsmetrics.CircuitBreakerHalfOpen)
+	}
+
+	// The breaker is half-open. Only send enough probes to decide whether it should close.
+	if b.probesInFlight+b.probeSuccesses >= b.cfg.ProbeRequests {
+		return false, false
+	}
+	b.probesInFlight++
+	return true, true
+}
+
+func (b *circuitBreaker) record(failed bool, probe bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.state {
+	case pbsmetrics.CircuitBreakerClosed:
+		b.addOutcome(failed)
+		if b.requests >= b.cfg.MinRequests && float64(b.failures) >= b.cfg.ErrorRate*float64(b.requests) {
+			b.open()
+		}
+	case pbsmetrics.CircuitBreakerHalfOpen:
+		// Requests which were sent before the breaker opened may still be coming back. Only the probes count.
+		if !probe {
+			return
+		}
+		b.probesInFlight--
+		if failed {
+			b.open()
+			return
+		}
+		b.probeSuccesses++
+		if b.probeSuccesses >= b.cfg.ProbeRequests {
+			b.close()
+		}
+	}
+}
+
+// addOutcome adds a request to the window, pushing out the oldest one if it's full.
+func (b *circuitBreaker) addOutcome(failed bool) {
+	if b.requests == len(b.outcomes) {
+		if b.outcomes[b.next] {
+			b.failures--
+		}
+	} else {
+		b.requests++
+	}
+	b.outcomes[b.next] = failed
+	if failed {
+		b.failures++
+	}
+	b.next = (b.next + 1) % len(b.outcomes)
+}
+
+func (b *circuitBreaker) open() {
+	b.openedAt = b.now()
+	if b.state == pbsmetrics.CircuitBreakerClosed {
+		glog.Warningf("Circuit breaker opened for %s. %d of its last %d requests failed.", b.bidder, b.failures, b.requests)
+	}
+	b.setState(pbsmetrics.CircuitBreakerOpen)
+}
+
+func (b *circuitBreaker) close() {
+	glog.Infof("Circuit breaker closed for %s.", b.bidder)
+	for i := range b.outcomes {
+		b.outcomes[i] = false
+	}
+	b.next = 0
+	b.requests = 0
+	b.failures = 0
+	b.setState(pbsmetrics.CircuitBreakerClosed)
+}
+
+func (b *circuitBreaker) setState(state pbsmetrics.CircuitBreakerState) {
+	b.state = state
+	b.metricsEngine.RecordAdapterCircuitBreakerState(b.bidder, state)
+}
+
+func (b *circuitBreaker) status() CircuitBreakerStatus {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	status := CircuitBreakerStatus{
+		State:    b.state,
+		Requests: b.requests,
+		Failures: b.failures,
+	}
+	if !b.openedAt.IsZero() {
+		openedAt := b.openedAt
+		status.OpenedAt = &openedAt
+	}
+	return status
+}
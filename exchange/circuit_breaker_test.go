@@ -0,0 +1,175 @@
+package exchange
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/prebid/prebid-server/config"
+	"github.com/prebid/prebid-server/errortypes"
+	"github.com/prebid/prebid-server/openrtb_ext"
+	"github.com/prebid/prebid-server/pbsmetrics"
+	metricsConf "github.com/prebid/prebid-server/pbsmetrics/config"
+)
+
+func TestCircuitBreakerOpens(t *testing.T) {
+	breaker, _ := newTestBreaker()
+	for i := 0; i < 4; i++ {
+		breaker.record(i%2 == 0, false)
+	}
+	assertBreakerState(t, breaker, pbsmetrics.CircuitBreakerClosed)
+
+	// The 5th request reaches min_requests, with 3 of 5 failures.
+	breaker.record(true, false)
+	assertBreakerState(t, breaker, pbsmetrics.CircuitBreakerOpen)
+	if allowed, _ := breaker.allow(); allowed {
+		t.Error("An open breaker shouldn't allow requests")
+	}
+}
+
+func TestCircuitBreakerStaysClosed(t *testing.T) {
+	breaker, _ := newTestBreaker()
+	for i := 0; i < 20; i++ {
+		breaker.record(i%3 == 0 && i < 10, false)
+	}
+	assertBreakerState(t, breaker, pbsmetrics.CircuitBreakerClosed)
+
+	// The early failures have left the window, so these shouldn't push it over the error rate.
+	for i := 0; i < 4; i++ {
+		breaker.record(true, false)
+	}
+	assertBreakerState(t, breaker, pbsmetrics.CircuitBreakerClosed)
+	if status := breaker.status(); status.Requests != 10 || status.Failures != 4 {
+		t.Errorf("Expected 4 failures in a window of 10. Got %d in %d", status.Failures, status.Requests)
+	}
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	breaker, clock := newTestBreaker()
+	openBreaker(breaker)
+
+	clock.now = clock.now.Add(999 * time.Millisecond)
+	if allowed, _ := breaker.allow(); allowed {
+		t.Error("The breaker should stay open until open_time_ms has passed")
+	}
+
+	clock.now = clock.now.Add(time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if allowed, probe := breaker.allow(); !allowed || !probe {
+			t.Errorf("Probe %d should be allowed", i)
+		}
+	}
+	assertBreakerState(t, breaker, pbsmetrics.CircuitBreakerHalfOpen)
+	if allowed, _ := breaker.allow(); allowed {
+		t.Error("Only probe_requests requests should be allowed while the breaker is half-open")
+	}
+
+	// Late results from before the breaker opened shouldn't affect it.
+	breaker.record(true, false)
+	assertBreakerState(t, breaker, pbsmetrics.CircuitBreakerHalfOpen)
+
+	breaker.record(false, true)
+	breaker.record(false, true)
+	assertBreakerState(t, breaker, pbsmetrics.CircuitBreakerClosed)
+	if status := breaker.status(); status.Requests != 0 || status.Failures != 0 {
+		t.Errorf("The window should be reset when the breaker closes. Got %d failures in %d", status.Failures, status.Requests)
+	}
+}
+
+func TestCircuitBreakerProbeFails(t *testing.T) {
+	breaker, clock := newTestBreaker()
+	openBreaker(breaker)
+	clock.now = clock.now.Add(time.Second)
+
+	breaker.allow()
+	breaker.record(false, true)
+	breaker.allow()
+	breaker.record(true, true)
+	assertBreakerState(t, breaker, pbsmetrics.CircuitBreakerOpen)
+	if status := breaker.status(); status.OpenedAt == nil || !status.OpenedAt.Equal(clock.now) {
+		t.Errorf("The breaker should have reopened when the probe failed. Got %v", status.OpenedAt)
+	}
+	if allowed, _ := breaker.allow(); allowed {
+		t.Error("A reopened breaker shouldn't allow requests")
+	}
+}
+
+func TestNilCircuitBreakers(t *testing.T) {
+	breakers := NewCircuitBreakers(config.CircuitBreaker{Enabled: false}, openrtb_ext.BidderList(), &metricsConf.DummyMetricsEngine{})
+	if breakers != nil {
+		t.Fatal("Circuit breakers shouldn't be made unless they're enabled")
+	}
+	if allowed, probe := breakers.allow(openrtb_ext.BidderAppnexus); !allowed || probe {
+		t.Error("Requests should always be allowed without circuit breakers")
+	}
+	breakers.record(openrtb_ext.BidderAppnexus, []error{&errortypes.Timeout{}}, false)
+	if len(breakers.Status()) != 0 {
+		t.Error("There shouldn't be any status without circuit breakers")
+	}
+}
+
+func TestHasBidderFailure(t *testing.T) {
+	testCases := []struct {
+		description string
+		errs        []error
+		expected    bool
+	}{
+		{"no errors", nil, false},
+		{"bad input", []error{&errortypes.BadInput{Message: "bad"}}, false},
+		{"unclassified error", []error{errors.New("unknown")}, false},
+		{"timeout", []error{&errortypes.Timeout{Message: "timed out"}}, true},
+		{"bad server response", []error{&errortypes.BadInput{}, &errortypes.BadServerResponse{Message: "500"}}, true},
+		{"connection error", []error{&url.Error{Op: "Post", URL: "http://bidder.com", Err: errors.New("connection refused")}}, true},
+	}
+	for _, test := range testCases {
+		if actual := hasBidderFailure(test.errs); actual != test.expected {
+			t.Errorf("%s: expected %t. Got %t", test.description, test.expected, actual)
+		}
+	}
+}
+
+func TestSkippedBidder(t *testing.T) {
+	brw := skippedBidder(openrtb_ext.BidderAppnexus)
+	if brw.bidder != openrtb_ext.BidderAppnexus || brw.adapterBids != nil {
+		t.Errorf("A skipped bidder shouldn't have any bids. Got %v", brw)
+	}
+	if len(brw.adapterExtra.Errors) != 1 || brw.adapterExtra.Errors[0].Code != errortypes.BidderTemporarilyDisabledCode {
+		t.Errorf("A skipped bidder should have a BidderTemporarilyDisabled error. Got %v", brw.adapterExtra.Errors)
+	}
+}
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+// newTestBreaker makes a breaker which opens once half of at least 5 requests fail, and closes after 2 successful probes.
+func newTestBreaker() (*circuitBreaker, *fakeClock) {
+	clock := &fakeClock{now: time.Unix(1500000000, 0)}
+	cfg := config.CircuitBreaker{
+		Enabled:       true,
+		WindowSize:    10,
+		MinRequests:   5,
+		ErrorRate:     0.5,
+		OpenTime:      1000,
+		ProbeRequests: 2,
+	}
+	return newCircuitBreaker(openrtb_ext.BidderAppnexus, cfg, &metricsConf.DummyMetricsEngine{}, clock.Now), clock
+}
+
+func openBreaker(breaker *circuitBreaker) {
+	for i := 0; i < 5; i++ {
+		breaker.record(true, false)
+	}
+}
+
+func assertBreakerState(t *testing.T, breaker *circuitBreaker, expected pbsmetrics.CircuitBreakerState) {
+	t.Helper()
+	if state := breaker.status().State; state != expected {
+		t.Errorf("Expected the breaker to be %s. Got %s", expected, state)
+	}
+}
@@ -0,0 +1,77 @@
+package exchange
+
+import (
+	"sort"
+
+	"github.com/mxmCherry/openrtb"
+	"github.com/prebid/prebid-server/analytics"
+	"github.com/prebid/prebid-server/errortypes"
+	"github.com/prebid/prebid-server/openrtb_ext"
+)
+
+// fillAuctionDetails records what each bidder was sent, how it responded, and what happened to its bids.
+// The bidders are sorted by name, so that the details don't depend on the order in which they were called.
+func fillAuctionDetails(details *analytics.AuctionDetails, cleanRequests map[openrtb_ext.BidderName]*openrtb.BidRequest, adapterBids map[openrtb_ext.BidderName]*pbsOrtbSeatBid, adapterExtra map[openrtb_ext.BidderName]*seatResponseExtra, auc *auction) {
+	details.Bidders = make([]*analytics.BidderResult, 0, len(cleanRequests))
+	for bidderName, request := range cleanRequests {
+		result := &analytics.BidderResult{
+			Bidder:  bidderName.String(),
+			Request: request,
+		}
+		if extra := adapterExtra[bidderName]; extra != nil {
+			result.ResponseTimeMillis = extra.ResponseTimeMillis
+			result.Errors = extra.Errors
+			result.TimedOut = hasTimeout(extra.Errors)
+		}
+		if seatBid := adapterBids[bidderName]; seatBid != nil {
+			result.Bids = make([]*analytics.BidRecord, 0, len(seatBid.bids)+len(seatBid.nonBids))
+			records := make(map[*pbsOrtbBid]*analytics.BidRecord, len(seatBid.bids))
+			for _, bid := range seatBid.bids {
+				record := newBidRecord(bidderName, bid)
+				record.Won = auc.winningBids[bid.bid.ImpID] == bid
+				record.CacheID = auc.cacheIds[bid.bid]
+				record.VastCacheID = auc.vastCacheIds[bid.bid]
+				records[bid] = record
+				result.Bids = append(result.Bids, record)
+			}
+			for _, nonBid := range seatBid.nonBids {
+				if nonBid.bid == nil {
+					continue
+				}
+				// Bids which didn't get targeting keys are still in the response, so they already have a record.
+				record, ok := records[nonBid.bid]
+				if !ok {
+					record = newBidRecord(bidderName, nonBid.bid)
+					result.Bids = append(result.Bids, record)
+				}
+				record.Rejection = nonBid.reason
+			}
+			result.NonBids = seatBid.makeExtNonBids()
+		}
+		details.Bidders = append(details.Bidders, result)
+	}
+	sort.Slice(details.Bidders, func(i, j int) bool {
+		return details.Bidders[i].Bidder < details.Bidders[j].Bidder
+	})
+}
+
+func newBidRecord(bidderName openrtb_ext.BidderName, bid *pbsOrtbBid) *analytics.BidRecord {
+	return &analytics.BidRecord{
+		Seat:          bidderName.String(),
+		BidID:         bid.bid.ID,
+		ImpID:         bid.bid.ImpID,
+		Type:          bid.bidType,
+		OriginalPrice: bid.originalPrice,
+		Price:         bid.bid.Price,
+		DealID:        bid.bid.DealID,
+	}
+}
+
+func hasTimeout(errs []openrtb_ext.ExtBidderError) bool {
+	for _, err := range errs {
+		if err.Code == errortypes.TimeoutCode {
+			return true
+		}
+	}
+	return false
+}
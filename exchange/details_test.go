@@ -0,0 +1,129 @@
+package exchange
+
+import (
+	"testing"
+
+	"github.com/mxmCherry/openrtb"
+	"github.com/prebid/prebid-server/analytics"
+	"github.com/prebid/prebid-server/errortypes"
+	"github.com/prebid/prebid-server/openrtb_ext"
+)
+
+func TestAuctionDetails(t *testing.T) {
+	winner := &pbsOrtbBid{
+		bid:           &openrtb.Bid{ID: "winner", ImpID: "imp", Price: 2},
+		bidType:       openrtb_ext.BidTypeBanner,
+		originalPrice: 1,
+	}
+	loser := &pbsOrtbBid{
+		bid:           &openrtb.Bid{ID: "loser", ImpID: "imp", Price: 1.5},
+		bidType:       openrtb_ext.BidTypeBanner,
+		originalPrice: 1.5,
+	}
+	invalid := &pbsOrtbBid{
+		bid:           &openrtb.Bid{ID: "invalid", ImpID: "imp", Price: 3},
+		originalPrice: 3,
+	}
+	rubicon := &pbsOrtbSeatBid{bids: []*pbsOrtbBid{loser}}
+	rubicon.rejectBid(invalid, openrtb_ext.NonBidInvalidBid)
+	rubicon.rejectBid(&pbsOrtbBid{}, openrtb_ext.NonBidInvalidBid)
+	rubicon.rejectImp("video-imp", openrtb_ext.NonBidUnsupportedMediaType)
+
+	cleanRequests := map[openrtb_ext.BidderName]*openrtb.BidRequest{
+		"rubicon":         {ID: "rubicon-request"},
+		"appnexus":        {ID: "appnexus-request"},
+		"audienceNetwork": {ID: "audienceNetwork-request"},
+	}
+	adapterBids := map[openrtb_ext.BidderName]*pbsOrtbSeatBid{
+		"appnexus":        {bids: []*pbsOrtbBid{winner}},
+		"rubicon":         rubicon,
+		"audienceNetwork": nil,
+	}
+	adapterExtra := map[openrtb_ext.BidderName]*seatResponseExtra{
+		"appnexus": {ResponseTimeMillis: 50},
+		"rubicon":  {ResponseTimeMillis: 80},
+		"audienceNetwork": {
+			ResponseTimeMillis: 200,
+			Errors:             []openrtb_ext.ExtBidderError{{Code: errortypes.TimeoutCode, Message: "timed out"}},
+		},
+	}
+	auc := &auction{
+		winningBids: map[string]*pbsOrtbBid{"imp": winner},
+		cacheIds:    map[*openrtb.Bid]string{winner.bid: "cache-id"},
+	}
+
+	details := &analytics.AuctionDetails{}
+	fillAuctionDetails(details, cleanRequests, adapterBids, adapterExtra, auc)
+
+	if len(details.Bidders) != 3 {
+		t.Fatalf("Expected a result for each bidder. Got %d", len(details.Bidders))
+	}
+	appnexus, audienceNetwork, rubiconResult := details.Bidders[0], details.Bidders[1], details.Bidders[2]
+	if appnexus.Bidder != "appnexus" || audienceNetwork.Bidder != "audienceNetwork" || rubiconResult.Bidder != "rubicon" {
+		t.Fatalf("Bidders should be sorted by name. Got %s, %s, %s", appnexus.Bidder, audienceNetwork.Bidder, rubiconResult.Bidder)
+	}
+	if appnexus.Request != cleanRequests["appnexus"] || appnexus.ResponseTimeMillis != 50 || appnexus.TimedOut {
+		t.Errorf("Bad result for appnexus: %#v", appnexus)
+	}
+	expectedWinner := analytics.BidRecord{
+		Seat:          "appnexus",
+		BidID:         "winner",
+		ImpID:         "imp",
+		Type:          openrtb_ext.BidTypeBanner,
+		OriginalPrice: 1,
+		Price:         2,
+		Won:           true,
+		CacheID:       "cache-id",
+	}
+	if len(appnexus.Bids) != 1 || *appnexus.Bids[0] != expectedWinner {
+		t.Errorf("Bad bid records for appnexus: %#v", appnexus.Bids)
+	}
+
+	if !audienceNetwork.TimedOut || len(audienceNetwork.Bids) != 0 {
+		t.Errorf("Bidders which time out should be marked. Got %#v", audienceNetwork)
+	}
+
+	if len(rubiconResult.Bids) != 2 {
+		t.Fatalf("Rejected bids should be recorded, unless they're empty. Got %d records", len(rubiconResult.Bids))
+	}
+	if loserRecord := rubiconResult.Bids[0]; loserRecord.BidID != "loser" || loserRecord.Won || loserRecord.Rejection != 0 {
+		t.Errorf("Bad record for a losing bid: %#v", loserRecord)
+	}
+	if rejectedRecord := rubiconResult.Bids[1]; rejectedRecord.BidID != "invalid" || rejectedRecord.Rejection != openrtb_ext.NonBidInvalidBid {
+		t.Errorf("Bad record for a rejected bid: %#v", rejectedRecord)
+	}
+	expectedNonBids := []openrtb_ext.ExtNonBid{
+		{ImpID: "imp", StatusCode: openrtb_ext.NonBidInvalidBid, BidID: "invalid", Price: 3},
+		{ImpID: "video-imp", StatusCode: openrtb_ext.NonBidUnsupportedMediaType},
+	}
+	if len(rubiconResult.NonBids) != len(expectedNonBids) {
+		t.Fatalf("Expected %d non-bids. Got %#v", len(expectedNonBids), rubiconResult.NonBids)
+	}
+	for i, expected := range expectedNonBids {
+		if rubiconResult.NonBids[i] != expected {
+			t.Errorf("Bad non-bid %d. Expected %#v, got %#v", i, expected, rubiconResult.NonBids[i])
+		}
+	}
+}
+
+func TestAuctionDetailsUntargetedBids(t *testing.T) {
+	top := &pbsOrtbBid{bid: &openrtb.Bid{ID: "top", ImpID: "imp", Price: 2}}
+	second := &pbsOrtbBid{bid: &openrtb.Bid{ID: "second", ImpID: "imp", Price: 1}}
+	seatBid := &pbsOrtbSeatBid{bids: []*pbsOrtbBid{top, second}}
+	seatBid.rejectBid(second, openrtb_ext.NonBidNoTargeting)
+
+	details := &analytics.AuctionDetails{}
+	fillAuctionDetails(details,
+		map[openrtb_ext.BidderName]*openrtb.BidRequest{"appnexus": {}},
+		map[openrtb_ext.BidderName]*pbsOrtbSeatBid{"appnexus": seatBid},
+		map[openrtb_ext.BidderName]*seatResponseExtra{"appnexus": {}},
+		&auction{winningBids: map[string]*pbsOrtbBid{"imp": top}})
+
+	bids := details.Bidders[0].Bids
+	if len(bids) != 2 {
+		t.Fatalf("Bids which are still in the response should only be recorded once. Got %d records", len(bids))
+	}
+	if bids[0].Rejection != 0 || bids[1].Rejection != openrtb_ext.NonBidNoTargeting {
+		t.Errorf("Only the bid without targeting should be marked. Got %v and %v", bids[0].Rejection, bids[1].Rejection)
+	}
+}
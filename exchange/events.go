@@ -0,0 +1,60 @@
+package exchange
+
+import (
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/mxmCherry/openrtb"
+	"github.com/prebid/prebid-server/openrtb_ext"
+	"github.com/prebid/prebid-server/pbsmetrics"
+)
+
+// eventTracking makes the /event URLs which get added to the bids when a request has ext.prebid.events.
+type eventTracking struct {
+	externalURL string
+	account     string
+	// auctionTimestamp is in milliseconds since the epoch.
+	auctionTimestamp int64
+}
+
+func newEventTracking(externalURL string, bidRequest *openrtb.BidRequest, auctionStart time.Time) *eventTracking {
+	ev := &eventTracking{
+		externalURL:      externalURL,
+		auctionTimestamp: auctionStart.UnixNano() / int64(time.Millisecond),
+	}
+	if bidRequest.Site != nil && bidRequest.Site.Publisher != nil {
+		ev.account = bidRequest.Site.Publisher.ID
+	} else if bidRequest.App != nil && bidRequest.App.Publisher != nil {
+		ev.account = bidRequest.App.Publisher.ID
+	}
+	return ev
+}
+
+// addEventURLs gives every bid which is still in the auction a win and an imp URL.
+func (ev *eventTracking) addEventURLs(adapterBids map[openrtb_ext.BidderName]*pbsOrtbSeatBid) {
+	for bidderName, seatBid := range adapterBids {
+		if seatBid == nil {
+			continue
+		}
+		for _, bid := range seatBid.bids {
+			bid.bidEvents = &openrtb_ext.ExtBidPrebidEvents{
+				Win: ev.makeEventURL(pbsmetrics.EventWin, bid.bid.ID, bidderName),
+				Imp: ev.makeEventURL(pbsmetrics.EventImp, bid.bid.ID, bidderName),
+			}
+		}
+	}
+}
+
+// makeEventURL builds a URL in the format which the /event endpoint expects.
+func (ev *eventTracking) makeEventURL(eventType pbsmetrics.EventType, bidID string, bidder openrtb_ext.BidderName) string {
+	values := url.Values{}
+	values.Set("t", string(eventType))
+	values.Set("b", bidID)
+	values.Set("bidder", string(bidder))
+	if ev.account != "" {
+		values.Set("a", ev.account)
+	}
+	values.Set("ts", strconv.FormatInt(ev.auctionTimestamp, 10))
+	return ev.externalURL + "/event?" + values.Encode()
+}
@@ -0,0 +1,41 @@
+package exchange
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mxmCherry/openrtb"
+	"github.com/prebid/prebid-server/openrtb_ext"
+)
+
+func TestEventURLs(t *testing.T) {
+	events := newEventTracking("http://prebid.example.com", &openrtb.BidRequest{
+		Site: &openrtb.Site{Publisher: &openrtb.Publisher{ID: "pub&1"}},
+	}, time.Unix(10, 0))
+
+	bid := &pbsOrtbBid{bid: &openrtb.Bid{ID: "bid-id"}}
+	events.addEventURLs(map[openrtb_ext.BidderName]*pbsOrtbSeatBid{
+		"appnexus": {bids: []*pbsOrtbBid{bid}},
+		"rubicon":  nil,
+	})
+
+	if bid.bidEvents == nil {
+		t.Fatalf("Bids should get event URLs.")
+	}
+	expectedWin := "http://prebid.example.com/event?a=pub%261&b=bid-id&bidder=appnexus&t=win&ts=10000"
+	if bid.bidEvents.Win != expectedWin {
+		t.Errorf("Bad win URL. Expected %s, got %s", expectedWin, bid.bidEvents.Win)
+	}
+	expectedImp := "http://prebid.example.com/event?a=pub%261&b=bid-id&bidder=appnexus&t=imp&ts=10000"
+	if bid.bidEvents.Imp != expectedImp {
+		t.Errorf("Bad imp URL. Expected %s, got %s", expectedImp, bid.bidEvents.Imp)
+	}
+}
+
+func TestEventURLsWithoutAccount(t *testing.T) {
+	events := newEventTracking("http://prebid.example.com", &openrtb.BidRequest{App: &openrtb.App{}}, time.Unix(0, 0))
+	expected := "http://prebid.example.com/event?b=bid-id&bidder=rubicon&t=win&ts=0"
+	if url := events.makeEventURL("win", "bid-id", "rubicon"); url != expected {
+		t.Errorf("Requests without a publisher should leave out the account. Expected %s, got %s", expected, url)
+	}
+}
@@ -4,8 +4,9 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"net/http"
 	"runtime/debug"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -15,18 +16,22 @@ import (
 	"github.com/mxmCherry/openrtb"
 
 	"github.com/prebid/prebid-server/adapters"
+	"github.com/prebid/prebid-server/analytics"
 	"github.com/prebid/prebid-server/config"
 	"github.com/prebid/prebid-server/errortypes"
 	"github.com/prebid/prebid-server/gdpr"
 	"github.com/prebid/prebid-server/openrtb_ext"
 	"github.com/prebid/prebid-server/pbsmetrics"
 	"github.com/prebid/prebid-server/prebid_cache_client"
+	"github.com/prebid/prebid-server/tracing"
 )
 
 // Exchange runs Auctions. Implementations must be threadsafe, and will be shared across many goroutines.
 type Exchange interface {
 	// HoldAuction executes an OpenRTB v2.5 Auction.
-	HoldAuction(ctx context.Context, bidRequest *openrtb.BidRequest, usersyncs IdFetcher, labels pbsmetrics.Labels) (*openrtb.BidResponse, error)
+	//
+	// If details is non-nil, it will be filled with information about how each bidder took part in the auction.
+	HoldAuction(ctx context.Context, bidRequest *openrtb.BidRequest, usersyncs IdFetcher, labels pbsmetrics.Labels, details *analytics.AuctionDetails) (*openrtb.BidResponse, error)
 }
 
 // IdFetcher can find the user's ID for a specific Bidder.
@@ -42,6 +47,12 @@ type exchange struct {
 	cacheTime           time.Duration
 	gDPR                gdpr.Permissions
 	UsersyncIfAmbiguous bool
+	// rewriteNativeAssetIDs lists the Bidders whose native asset IDs should be fixed before their bids are validated.
+	rewriteNativeAssetIDs map[openrtb_ext.BidderName]bool
+	categories            categoryMapping
+	externalURL           string
+	vastModification      config.VASTModification
+	breakers              *CircuitBreakers
 }
 
 // Container to pass out response ext data from the GetAllBids goroutines back into the main thread
@@ -56,19 +67,39 @@ type bidResponseWrapper struct {
 	bidder       openrtb_ext.BidderName
 }
 
-func NewExchange(client *http.Client, cache prebid_cache_client.Client, cfg *config.Configuration, metricsEngine pbsmetrics.MetricsEngine, infos adapters.BidderInfos, gDPR gdpr.Permissions) Exchange {
+// NewExchange makes an Exchange. Each Bidder gets its own http.Client, configured by adapters.{bidder}.http_client.
+// breakers may be nil if the bidders shouldn't be skipped when they're failing.
+func NewExchange(cache prebid_cache_client.Client, cfg *config.Configuration, metricsEngine pbsmetrics.MetricsEngine, infos adapters.BidderInfos, gDPR gdpr.Permissions, breakers *CircuitBreakers) Exchange {
 	e := new(exchange)
 
-	e.adapterMap = newAdapterMap(client, cfg, infos)
+	e.adapterMap = newAdapterMap(cfg, infos)
 	e.cache = cache
 	e.cacheTime = time.Duration(cfg.CacheURL.ExpectedTimeMillis) * time.Millisecond
 	e.me = metricsEngine
 	e.gDPR = gDPR
+	e.externalURL = cfg.ExternalURL
+	e.vastModification = cfg.VASTModification
+	e.breakers = breakers
 	e.UsersyncIfAmbiguous = cfg.GDPR.UsersyncIfAmbiguous
+	e.rewriteNativeAssetIDs = make(map[openrtb_ext.BidderName]bool)
+	for name, adapterCfg := range cfg.Adapters {
+		if adapterCfg.RewriteNativeAssetIDs {
+			e.rewriteNativeAssetIDs[openrtb_ext.BidderName(name)] = true
+		}
+	}
+	categories, err := loadCategoryMapping(cfg.CategoryMapping.Filename)
+	if err != nil {
+		glog.Fatalf("Failed to load the category mapping: %v", err)
+	}
+	e.categories = categories
 	return e
 }
 
-func (e *exchange) HoldAuction(ctx context.Context, bidRequest *openrtb.BidRequest, usersyncs IdFetcher, labels pbsmetrics.Labels) (*openrtb.BidResponse, error) {
+func (e *exchange) HoldAuction(ctx context.Context, bidRequest *openrtb.BidRequest, usersyncs IdFetcher, labels pbsmetrics.Labels, details *analytics.AuctionDetails) (*openrtb.BidResponse, error) {
+	auctionStart := time.Now()
+	ctx, span := tracing.StartSpan(ctx, "exchange.HoldAuction")
+	span.SetAttribute("imps", strconv.Itoa(len(bidRequest.Imp)))
+	defer span.End()
 	// Snapshot of resolved bid request for debug if test request
 	var resolvedRequest json.RawMessage
 	if bidRequest.Test == 1 {
@@ -97,6 +128,8 @@ func (e *exchange) HoldAuction(ctx context.Context, bidRequest *openrtb.BidReque
 	shouldCacheBids := false
 	shouldCacheVAST := false
 	var bidAdjustmentFactors map[string]float64
+	events := newEventTracking(e.externalURL, bidRequest, auctionStart)
+	includeEvents := false
 	if len(bidRequest.Ext) > 0 {
 		var requestExt openrtb_ext.ExtRequest
 		err := json.Unmarshal(bidRequest.Ext, &requestExt)
@@ -108,12 +141,15 @@ func (e *exchange) HoldAuction(ctx context.Context, bidRequest *openrtb.BidReque
 			shouldCacheBids = requestExt.Prebid.Cache.Bids != nil
 			shouldCacheVAST = requestExt.Prebid.Cache.VastXML != nil
 		}
+		includeEvents = requestExt.Prebid.Events != nil
 
 		if requestExt.Prebid.Targeting != nil {
 			targData = &targetData{
 				priceGranularity:  requestExt.Prebid.Targeting.PriceGranularity,
 				includeWinners:    requestExt.Prebid.Targeting.IncludeWinners,
 				includeBidderKeys: requestExt.Prebid.Targeting.IncludeBidderKeys,
+				enforceBlocks:     requestExt.Prebid.Targeting.EnforceBlocks,
+				dedupCategories:   requestExt.Prebid.Targeting.DedupCategories,
 			}
 			if shouldCacheBids {
 				targData.includeCacheBids = true
@@ -129,15 +165,44 @@ func (e *exchange) HoldAuction(ctx context.Context, bidRequest *openrtb.BidReque
 	auctionCtx, cancel := e.makeAuctionContext(ctx, shouldCacheBids)
 	defer cancel()
 
+	biddersStart := time.Now()
 	adapterBids, adapterExtra := e.getAllBids(auctionCtx, cleanRequests, aliases, bidAdjustmentFactors, blabels)
+	e.me.RecordRequestPhaseTime(labels, pbsmetrics.PhaseBidders, time.Since(biddersStart))
+	if targData != nil {
+		// Many bidders ignore bcat and badv, so these need to be enforced before the winners are picked.
+		if targData.enforceBlocks {
+			enforceBlocks(adapterBids, adapterExtra, bidRequest.BCat, bidRequest.BAdv)
+		}
+		if targData.includeCacheVast && e.vastModification.Enabled {
+			trackers := e.vastModification.TrackersFor(events.account)
+			modifier := &vastModifier{events: events, impressionTrackers: trackers.Impression, errorTrackers: trackers.Error}
+			modifier.modifyBids(adapterBids, adapterExtra)
+		}
+		if targData.dedupCategories {
+			dedupCategories(adapterBids, e.categories)
+		}
+	}
 	auc := newAuction(adapterBids, len(bidRequest.Imp))
+	if includeEvents {
+		events.addEventURLs(adapterBids)
+	}
 	if targData != nil {
 		auc.setRoundedPrices(targData.priceGranularity)
+		cacheStart := time.Now()
 		auc.doCache(ctx, e.cache, targData.includeCacheBids, targData.includeCacheVast)
+		if targData.includeCacheBids || targData.includeCacheVast {
+			e.me.RecordRequestPhaseTime(labels, pbsmetrics.PhaseCache, time.Since(cacheStart))
+		}
 		targData.setTargeting(auc, bidRequest.App != nil)
+		rejectUntargetedBids(adapterBids)
 	}
+	e.recordNonBids(adapterBids, aliases)
 	// Build the response
-	return e.buildBidResponse(ctx, liveAdapters, adapterBids, bidRequest, resolvedRequest, adapterExtra, errs)
+	bidResponse, err := e.buildBidResponse(ctx, liveAdapters, adapterBids, bidRequest, resolvedRequest, adapterExtra, errs)
+	if details != nil {
+		fillAuctionDetails(details, cleanRequests, adapterBids, adapterExtra, auc)
+	}
+	return bidResponse, err
 }
 
 func (e *exchange) makeAuctionContext(ctx context.Context, needsCache bool) (auctionCtx context.Context, cancel func()) {
@@ -161,6 +226,11 @@ func (e *exchange) getAllBids(ctx context.Context, cleanRequests map[openrtb_ext
 	for bidderName, req := range cleanRequests {
 		// Here we actually call the adapters and collect the bids.
 		coreBidder := resolveBidder(string(bidderName), aliases)
+		allowed, probe := e.breakers.allow(coreBidder)
+		if !allowed {
+			chBids <- skippedBidder(bidderName)
+			continue
+		}
 		bidderRunner := recoverSafely(func(aName openrtb_ext.BidderName, coreBidder openrtb_ext.BidderName, request *openrtb.BidRequest, bidlabels *pbsmetrics.AdapterLabels) {
 			// Passing in aName so a doesn't change out from under the go routine
 			if bidlabels.Adapter == "" {
@@ -179,13 +249,19 @@ func (e *exchange) getAllBids(ctx context.Context, cleanRequests map[openrtb_ext
 			if givenAdjustment, ok := bidAdjustments[string(aName)]; ok {
 				adjustmentFactor = givenAdjustment
 			}
+			// A panic counts as a failure, so that a half-open breaker doesn't wait forever for its probe.
+			bidderErrs := []error{&errortypes.BadServerResponse{Message: "The bidder panicked"}}
+			defer func() {
+				e.breakers.record(coreBidder, bidderErrs, probe)
+			}()
 			bids, err := e.adapterMap[coreBidder].requestBid(ctx, request, aName, adjustmentFactor)
+			bidderErrs = err
 
 			// Add in time reporting
 			elapsed := time.Since(start)
 			brw.adapterBids = bids
 			// validate bids ASAP, so we don't waste time on invalid bids.
-			err2 := brw.validateBids(request)
+			err2 := brw.validateBids(request, e.rewriteNativeAssetIDs[coreBidder])
 			if len(err2) > 0 {
 				err = append(err, err2...)
 			}
@@ -221,6 +297,18 @@ func (e *exchange) getAllBids(ctx context.Context, cleanRequests map[openrtb_ext
 	return adapterBids, adapterExtra
 }
 
+// skippedBidder is the result for a bidder which wasn't called, because its circuit breaker is open.
+func skippedBidder(bidder openrtb_ext.BidderName) *bidResponseWrapper {
+	return &bidResponseWrapper{
+		bidder: bidder,
+		adapterExtra: &seatResponseExtra{
+			Errors: errsToBidderErrors([]error{&errortypes.BidderTemporarilyDisabled{
+				Message: "This bidder was skipped because too many of its recent requests failed. It will be tried again shortly.",
+			}}),
+		},
+	}
+}
+
 func recoverSafely(inner func(openrtb_ext.BidderName, openrtb_ext.BidderName, *openrtb.BidRequest, *pbsmetrics.AdapterLabels), chBids chan *bidResponseWrapper) func(openrtb_ext.BidderName, openrtb_ext.BidderName, *openrtb.BidRequest, *pbsmetrics.AdapterLabels) {
 	return func(aName openrtb_ext.BidderName, coreBidder openrtb_ext.BidderName, request *openrtb.BidRequest, bidlabels *pbsmetrics.AdapterLabels) {
 		defer func() {
@@ -321,8 +409,16 @@ func (e *exchange) makeExtBidResponse(adapterBids map[openrtb_ext.BidderName]*pb
 	for a, b := range adapterBids {
 		if b != nil {
 			if req.Test == 1 {
-				// Fill debug info
-				bidResponseExt.Debug.HttpCalls[a] = b.httpCalls
+				// Fill debug info. Seats may exist without any calls if the bidder couldn't bid on any of the imps.
+				if b.httpCalls != nil {
+					bidResponseExt.Debug.HttpCalls[a] = b.httpCalls
+				}
+				if nonBids := b.makeExtNonBids(); len(nonBids) > 0 {
+					bidResponseExt.SeatNonBid = append(bidResponseExt.SeatNonBid, openrtb_ext.ExtSeatNonBid{
+						Seat:   a.String(),
+						NonBid: nonBids,
+					})
+				}
 			}
 		}
 		// Only make an entry for bidder errors if the bidder reported any.
@@ -336,6 +432,9 @@ func (e *exchange) makeExtBidResponse(adapterBids map[openrtb_ext.BidderName]*pb
 		// Defering the filling of bidResponseExt.Usersync[a] until later
 
 	}
+	sort.Slice(bidResponseExt.SeatNonBid, func(i, j int) bool {
+		return bidResponseExt.SeatNonBid[i].Seat < bidResponseExt.SeatNonBid[j].Seat
+	})
 	return bidResponseExt
 }
 
@@ -382,8 +481,12 @@ func (e *exchange) makeBid(Bids []*pbsOrtbBid, adapter openrtb_ext.BidderName) (
 			Prebid: &openrtb_ext.ExtBidPrebid{
 				Targeting: thisBid.bidTargets,
 				Type:      thisBid.bidType,
+				Events:    thisBid.bidEvents,
 			},
 		}
+		if e.categories != nil {
+			bidExt.Prebid.Category = e.categories.primaryCategory(thisBid.bid)
+		}
 
 		ext, err := json.Marshal(bidExt)
 		if err != nil {
@@ -396,8 +499,9 @@ func (e *exchange) makeBid(Bids []*pbsOrtbBid, adapter openrtb_ext.BidderName) (
 	return bids, errList
 }
 
-// validateBids will run some validation checks on the returned bids and excise any invalid bids
-func (brw *bidResponseWrapper) validateBids(request *openrtb.BidRequest) (err []error) {
+// validateBids will run some validation checks on the returned bids and excise any invalid bids.
+// If rewriteNativeAssetIDs is true, the asset IDs in native bids will be fixed up before they're checked.
+func (brw *bidResponseWrapper) validateBids(request *openrtb.BidRequest, rewriteNativeAssetIDs bool) (err []error) {
 	// Exit early if there is nothing to do.
 	if brw.adapterBids == nil || len(brw.adapterBids.bids) == 0 {
 		return
@@ -407,6 +511,9 @@ func (brw *bidResponseWrapper) validateBids(request *openrtb.BidRequest) (err []
 
 	// By design, default currency is USD.
 	if cerr := validateCurrency(request.Cur, brw.adapterBids.currency); cerr != nil {
+		for _, bid := range brw.adapterBids.bids {
+			brw.adapterBids.rejectBid(bid, openrtb_ext.NonBidInvalidCurrency)
+		}
 		brw.adapterBids.bids = nil
 		err = append(err, cerr)
 		return
@@ -414,10 +521,16 @@ func (brw *bidResponseWrapper) validateBids(request *openrtb.BidRequest) (err []
 
 	validBids := make([]*pbsOrtbBid, 0, len(brw.adapterBids.bids))
 	for _, bid := range brw.adapterBids.bids {
-		if ok, berr := validateBid(bid); ok {
+		if ok, berr := validateBid(bid); !ok {
+			brw.adapterBids.rejectBid(bid, openrtb_ext.NonBidInvalidBid)
+			err = append(err, berr)
+		} else if bid.bidType != openrtb_ext.BidTypeNative {
 			validBids = append(validBids, bid)
+		} else if nerr := validateNativeBid(bid.bid, request, rewriteNativeAssetIDs); nerr != nil {
+			brw.adapterBids.rejectBid(bid, openrtb_ext.NonBidInvalidBid)
+			err = append(err, nerr)
 		} else {
-			err = append(err, berr)
+			validBids = append(validBids, bid)
 		}
 	}
 	if len(validBids) != len(brw.adapterBids.bids) {
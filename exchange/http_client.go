@@ -0,0 +1,160 @@
+package exchange
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prebid/prebid-server/config"
+	"github.com/prebid/prebid-server/openrtb_ext"
+	"github.com/prebid/prebid-server/ssl"
+	"golang.org/x/net/http2"
+)
+
+const (
+	defaultMaxIdleConns    = 10
+	defaultIdleConnTimeout = 60 * time.Second
+)
+
+// newBidderClients makes an http.Client for each Bidder, using the settings in its adapters.{bidder}.http_client config.
+// The Bidders don't share connection pools, so a Bidder with lots of traffic can't starve the others.
+func newBidderClients(cfg *config.Configuration) map[openrtb_ext.BidderName]*http.Client {
+	rootCAs := ssl.GetRootCAPool()
+	bidders := openrtb_ext.BidderList()
+	clients := make(map[openrtb_ext.BidderName]*http.Client, len(bidders))
+	for _, bidder := range bidders {
+		clients[bidder] = newBidderClient(bidder, cfg.Adapters[strings.ToLower(string(bidder))].HTTPClient, rootCAs)
+	}
+	return clients
+}
+
+func newBidderClient(bidder openrtb_ext.BidderName, cfg config.AdapterHTTPClient, rootCAs *x509.CertPool) *http.Client {
+	var transport http.RoundTripper = newBidderTransport(bidder, cfg, rootCAs)
+	if cfg.CompressRequests {
+		transport = &gzipTransport{next: transport}
+	}
+	if cfg.MaxConns > 0 {
+		transport = &limitedTransport{
+			next:  transport,
+			slots: make(chan struct{}, cfg.MaxConns),
+		}
+	}
+	return &http.Client{Transport: transport}
+}
+
+func newBidderTransport(bidder openrtb_ext.BidderName, cfg config.AdapterHTTPClient, rootCAs *x509.CertPool) *http.Transport {
+	maxIdleConns := cfg.MaxIdleConns
+	if maxIdleConns == 0 {
+		maxIdleConns = defaultMaxIdleConns
+	}
+	idleConnTimeout := cfg.IdleConnTimeoutDuration()
+	if idleConnTimeout == 0 {
+		idleConnTimeout = defaultIdleConnTimeout
+	}
+	transport := &http.Transport{
+		// Bidders almost always have a single host, so the per-host limit is the one which matters.
+		MaxIdleConns:        maxIdleConns,
+		MaxIdleConnsPerHost: maxIdleConns,
+		IdleConnTimeout:     idleConnTimeout,
+		TLSClientConfig: &tls.Config{
+			RootCAs:            rootCAs,
+			MinVersion:         cfg.TLS.MinTLSVersion(),
+			InsecureSkipVerify: cfg.TLS.InsecureSkipVerify,
+		},
+	}
+	// The http package turns off HTTP/2 for Transports with a custom TLSClientConfig, so it has to be added back explicitly.
+	if cfg.HTTP2 {
+		if err := http2.ConfigureTransport(transport); err != nil {
+			glog.Errorf("Failed to enable HTTP/2 for %s. It will use HTTP/1.1: %v", bidder, err)
+		}
+	}
+	return transport
+}
+
+// gzipTransport compresses the bodies of the requests which it sends.
+type gzipTransport struct {
+	next http.RoundTripper
+}
+
+func (t *gzipTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body == nil || req.Header.Get("Content-Encoding") != "" {
+		return t.next.RoundTrip(req)
+	}
+
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	_, err := io.Copy(writer, req.Body)
+	req.Body.Close()
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	body := buffer.Bytes()
+
+	// RoundTrippers mustn't modify the request, so send a copy with the compressed body.
+	compressed := new(http.Request)
+	*compressed = *req
+	compressed.Header = make(http.Header, len(req.Header)+1)
+	for key, values := range req.Header {
+		compressed.Header[key] = values
+	}
+	compressed.Header.Set("Content-Encoding", "gzip")
+	compressed.Body = ioutil.NopCloser(bytes.NewReader(body))
+	compressed.ContentLength = int64(len(body))
+	compressed.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	return t.next.RoundTrip(compressed)
+}
+
+// limitedTransport limits the number of requests in flight at once. Each request holds its slot until
+// the response body is closed, or the request fails. Requests wait for a slot until their context is done.
+type limitedTransport struct {
+	next  http.RoundTripper
+	slots chan struct{}
+}
+
+func (t *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	select {
+	case t.slots <- struct{}{}:
+	case <-req.Context().Done():
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, req.Context().Err()
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		<-t.slots
+		return nil, err
+	}
+	resp.Body = &releasingBody{
+		ReadCloser: resp.Body,
+		release:    func() { <-t.slots },
+	}
+	return resp, nil
+}
+
+// releasingBody frees a limitedTransport slot when it's closed.
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
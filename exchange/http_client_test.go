@@ -0,0 +1,148 @@
+package exchange
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prebid/prebid-server/config"
+	"github.com/prebid/prebid-server/openrtb_ext"
+	"github.com/prebid/prebid-server/ssl"
+)
+
+func TestNewBidderClients(t *testing.T) {
+	cfg := &config.Configuration{
+		Adapters: map[string]config.Adapter{
+			"appnexus": {
+				HTTPClient: config.AdapterHTTPClient{
+					MaxIdleConns:    50,
+					IdleConnTimeout: 30000,
+					TLS:             config.AdapterTLS{MinVersion: "1.2"},
+				},
+			},
+		},
+	}
+	clients := newBidderClients(cfg)
+	for _, bidder := range openrtb_ext.BidderList() {
+		if clients[bidder] == nil {
+			t.Errorf("Missing a client for %s", bidder)
+		}
+	}
+	if clients[openrtb_ext.BidderAppnexus] == clients[openrtb_ext.BidderRubicon] {
+		t.Error("Bidders shouldn't share a client")
+	}
+
+	appnexus := clients[openrtb_ext.BidderAppnexus].Transport.(*http.Transport)
+	if appnexus.MaxIdleConnsPerHost != 50 || appnexus.IdleConnTimeout != 30*time.Second || appnexus.TLSClientConfig.MinVersion != tls.VersionTLS12 {
+		t.Errorf("The appnexus transport should use its config. Got %d idle conns, %v idle timeout and TLS version %x",
+			appnexus.MaxIdleConnsPerHost, appnexus.IdleConnTimeout, appnexus.TLSClientConfig.MinVersion)
+	}
+	rubicon := clients[openrtb_ext.BidderRubicon].Transport.(*http.Transport)
+	if rubicon.MaxIdleConnsPerHost != defaultMaxIdleConns || rubicon.IdleConnTimeout != defaultIdleConnTimeout || rubicon.TLSClientConfig.MinVersion != tls.VersionTLS10 {
+		t.Errorf("The rubicon transport should use the defaults. Got %d idle conns, %v idle timeout and TLS version %x",
+			rubicon.MaxIdleConnsPerHost, rubicon.IdleConnTimeout, rubicon.TLSClientConfig.MinVersion)
+	}
+}
+
+func TestHTTP2(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}))
+	server.TLS = &tls.Config{NextProtos: []string{"h2", "http/1.1"}}
+	server.StartTLS()
+	defer server.Close()
+
+	// The test server's certificate is self-signed.
+	cfg := config.AdapterHTTPClient{TLS: config.AdapterTLS{InsecureSkipVerify: true}}
+	if proto := requestProto(t, newBidderClient(openrtb_ext.BidderAppnexus, cfg, ssl.GetRootCAPool()), server.URL); proto != "HTTP/1.1" {
+		t.Errorf("HTTP/2 shouldn't be used unless it's enabled. Got %s", proto)
+	}
+	cfg.HTTP2 = true
+	if proto := requestProto(t, newBidderClient(openrtb_ext.BidderAppnexus, cfg, ssl.GetRootCAPool()), server.URL); proto != "HTTP/2.0" {
+		t.Errorf("HTTP/2 should be used if it's enabled. Got %s", proto)
+	}
+}
+
+func TestCompressRequests(t *testing.T) {
+	var encoding string
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding = r.Header.Get("Content-Encoding")
+		reader, err := gzip.NewReader(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, _ = ioutil.ReadAll(reader)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	client := newBidderClient(openrtb_ext.BidderAppnexus, config.AdapterHTTPClient{CompressRequests: true}, ssl.GetRootCAPool())
+	req, _ := http.NewRequest("POST", server.URL, bytes.NewReader([]byte(`{"id":"some-request-id"}`)))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("The server couldn't decompress the request. Got status %d", resp.StatusCode)
+	}
+	if encoding != "gzip" {
+		t.Errorf("Bad Content-Encoding: %s", encoding)
+	}
+	if string(body) != `{"id":"some-request-id"}` {
+		t.Errorf("Bad request body: %s", string(body))
+	}
+	if req.Header.Get("Content-Encoding") != "" {
+		t.Error("The caller's request shouldn't be modified")
+	}
+}
+
+func TestMaxConns(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	client := newBidderClient(openrtb_ext.BidderAppnexus, config.AdapterHTTPClient{MaxConns: 1}, ssl.GetRootCAPool())
+	first, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// The first response hasn't been closed, so the second request shouldn't get a connection.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	if _, err := client.Do(req.WithContext(ctx)); err == nil {
+		t.Error("The second request should wait for a connection until its context is done")
+	}
+
+	first.Body.Close()
+	second, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("The second request should be sent once the first response is closed. Got %v", err)
+	}
+	second.Body.Close()
+}
+
+// requestProto returns the protocol which the server saw the request arrive with.
+func requestProto(t *testing.T, client *http.Client, url string) string {
+	t.Helper()
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	proto, _ := ioutil.ReadAll(resp.Body)
+	return string(proto)
+}
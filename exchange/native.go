@@ -0,0 +1,260 @@
+package exchange
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/mxmCherry/openrtb"
+	nativeRequests "github.com/mxmCherry/openrtb/native/request"
+	"github.com/prebid/prebid-server/errortypes"
+)
+
+// Native v1.0 and v1.1 responses wrap the payload in a "native" object. Native v1.2 dropped the wrapper.
+const nativeWrapperKey = "native"
+
+// The keys which identify what kind of asset a Native response asset holds.
+var nativeAssetKinds = []string{"title", "img", "video", "data"}
+
+// validateNativeBid checks the bid's adm against the Native request on the imp it bids on.
+//
+// Each asset in the response must refer to an asset in the request of the same kind, and every required
+// asset in the request must be present. If rewriteAssetIDs is true, assets with unknown or mismatched IDs
+// will be assigned the ID of an unused request asset of the same kind before they're validated.
+//
+// Bids without an adm can't be checked here, since the creative will be fetched from the nurl later.
+func validateNativeBid(bid *openrtb.Bid, request *openrtb.BidRequest, rewriteAssetIDs bool) error {
+	if bid.AdM == "" {
+		return nil
+	}
+	imp := findImp(request.Imp, bid.ImpID)
+	if imp == nil || imp.Native == nil {
+		return &errortypes.BadServerResponse{
+			Message: fmt.Sprintf("Bid \"%s\" is a native bid, but imp \"%s\" has no native request", bid.ID, bid.ImpID),
+		}
+	}
+
+	var nativeReq nativeRequests.Request
+	if err := json.Unmarshal([]byte(imp.Native.Request), &nativeReq); err != nil {
+		// The endpoints validate this before the auction, so it isn't the Bidder's fault if it can't be read.
+		return nil
+	}
+
+	adm, err := parseNativeAdm(bid.AdM)
+	if err != nil {
+		return &errortypes.BadServerResponse{
+			Message: fmt.Sprintf("Bid \"%s\" has a native adm which could not be parsed: %s", bid.ID, err.Error()),
+		}
+	}
+
+	if rewriteAssetIDs && adm.rewriteAssetIDs(nativeReq.Assets) {
+		rewritten, err := adm.marshal()
+		if err != nil {
+			return &errortypes.BadServerResponse{
+				Message: fmt.Sprintf("Bid \"%s\" has a native adm which could not be rewritten: %s", bid.ID, err.Error()),
+			}
+		}
+		bid.AdM = rewritten
+	}
+
+	if err := adm.validate(nativeReq.Assets); err != nil {
+		return &errortypes.BadServerResponse{
+			Message: fmt.Sprintf("Bid \"%s\" has an invalid native adm: %s", bid.ID, err.Error()),
+		}
+	}
+	return nil
+}
+
+func findImp(imps []openrtb.Imp, id string) *openrtb.Imp {
+	for i := 0; i < len(imps); i++ {
+		if imps[i].ID == id {
+			return &imps[i]
+		}
+	}
+	return nil
+}
+
+// nativeAdm is a Native response payload.
+//
+// Assets are kept as raw JSON objects so that any fields which Prebid Server doesn't look at
+// are preserved if the adm needs to be rewritten.
+type nativeAdm struct {
+	wrapped bool
+	payload map[string]json.RawMessage
+	assets  []map[string]json.RawMessage
+}
+
+func parseNativeAdm(adm string) (*nativeAdm, error) {
+	var payload map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(adm), &payload); err != nil {
+		return nil, err
+	}
+	parsed := &nativeAdm{payload: payload}
+	if inner, ok := payload[nativeWrapperKey]; ok {
+		parsed.wrapped = true
+		parsed.payload = nil
+		if err := json.Unmarshal(inner, &parsed.payload); err != nil {
+			return nil, err
+		}
+	}
+	if rawAssets, ok := parsed.payload["assets"]; ok {
+		if err := json.Unmarshal(rawAssets, &parsed.assets); err != nil {
+			return nil, err
+		}
+	}
+	return parsed, nil
+}
+
+func (adm *nativeAdm) marshal() (string, error) {
+	assets, err := json.Marshal(adm.assets)
+	if err != nil {
+		return "", err
+	}
+	adm.payload["assets"] = assets
+
+	var toMarshal interface{} = adm.payload
+	if adm.wrapped {
+		toMarshal = map[string]interface{}{nativeWrapperKey: adm.payload}
+	}
+	serialized, err := json.Marshal(toMarshal)
+	return string(serialized), err
+}
+
+// validate returns an error if the response assets don't satisfy the requested ones.
+func (adm *nativeAdm) validate(requested []nativeRequests.Asset) error {
+	found := make(map[int64]bool, len(adm.assets))
+	for i, asset := range adm.assets {
+		id, hasID, err := nativeAssetID(asset)
+		if err != nil {
+			return fmt.Errorf("assets[%d].id must be an integer", i)
+		}
+		if !hasID {
+			// Native v1.2 made the id optional. There's nothing to check it against, so it can't fill a required asset.
+			continue
+		}
+		reqAsset := findNativeAsset(requested, id)
+		if reqAsset == nil {
+			return fmt.Errorf("assets[%d].id is %d, which doesn't match any asset in the request", i, id)
+		}
+		if err := validateNativeAssetKind(asset, reqAsset, i); err != nil {
+			return err
+		}
+		found[id] = true
+	}
+	for _, reqAsset := range requested {
+		if reqAsset.Required == 1 && !found[reqAsset.ID] {
+			return fmt.Errorf("required asset %d is missing", reqAsset.ID)
+		}
+	}
+	return nil
+}
+
+// validateNativeAssetKind makes sure that the response asset holds the same kind of data as the requested one.
+func validateNativeAssetKind(asset map[string]json.RawMessage, reqAsset *nativeRequests.Asset, index int) error {
+	expected := requestedAssetKind(reqAsset)
+	if actual := responseAssetKind(asset); actual != expected {
+		return fmt.Errorf("assets[%d] should contain a %s, but contained %s", index, expected, describeAssetKind(actual))
+	}
+
+	var subtype struct {
+		Type int64 `json:"type"`
+	}
+	switch expected {
+	case "img":
+		if reqAsset.Img.Type == 0 || json.Unmarshal(asset["img"], &subtype) != nil || subtype.Type == 0 {
+			return nil
+		}
+		if subtype.Type != int64(reqAsset.Img.Type) {
+			return fmt.Errorf("assets[%d].img.type should be %d, but was %d", index, reqAsset.Img.Type, subtype.Type)
+		}
+	case "data":
+		if json.Unmarshal(asset["data"], &subtype) != nil || subtype.Type == 0 {
+			return nil
+		}
+		if subtype.Type != int64(reqAsset.Data.Type) {
+			return fmt.Errorf("assets[%d].data.type should be %d, but was %d", index, reqAsset.Data.Type, subtype.Type)
+		}
+	}
+	return nil
+}
+
+// rewriteAssetIDs fixes the IDs on any response assets which don't refer to a requested asset of the same kind.
+// Each one gets the ID of the first unused requested asset of its kind, in the order they were requested.
+//
+// It returns true if any IDs were changed.
+func (adm *nativeAdm) rewriteAssetIDs(requested []nativeRequests.Asset) bool {
+	claimed := make(map[int64]bool, len(requested))
+	needsID := make([]int, 0, len(adm.assets))
+	for i, asset := range adm.assets {
+		id, hasID, err := nativeAssetID(asset)
+		if err == nil && hasID && !claimed[id] {
+			if reqAsset := findNativeAsset(requested, id); reqAsset != nil && requestedAssetKind(reqAsset) == responseAssetKind(asset) {
+				claimed[id] = true
+				continue
+			}
+		}
+		needsID = append(needsID, i)
+	}
+
+	changed := false
+	for _, i := range needsID {
+		kind := responseAssetKind(adm.assets[i])
+		for j := 0; j < len(requested); j++ {
+			if claimed[requested[j].ID] || requestedAssetKind(&requested[j]) != kind {
+				continue
+			}
+			claimed[requested[j].ID] = true
+			adm.assets[i]["id"] = json.RawMessage(fmt.Sprintf("%d", requested[j].ID))
+			changed = true
+			break
+		}
+	}
+	return changed
+}
+
+func nativeAssetID(asset map[string]json.RawMessage) (id int64, hasID bool, err error) {
+	raw, ok := asset["id"]
+	if !ok || string(raw) == "null" {
+		return 0, false, nil
+	}
+	err = json.Unmarshal(raw, &id)
+	return id, err == nil, err
+}
+
+func findNativeAsset(assets []nativeRequests.Asset, id int64) *nativeRequests.Asset {
+	for i := 0; i < len(assets); i++ {
+		if assets[i].ID == id {
+			return &assets[i]
+		}
+	}
+	return nil
+}
+
+func requestedAssetKind(asset *nativeRequests.Asset) string {
+	switch {
+	case asset.Title != nil:
+		return "title"
+	case asset.Img != nil:
+		return "img"
+	case asset.Video != nil:
+		return "video"
+	case asset.Data != nil:
+		return "data"
+	}
+	return ""
+}
+
+func responseAssetKind(asset map[string]json.RawMessage) string {
+	for _, kind := range nativeAssetKinds {
+		if raw, ok := asset[kind]; ok && string(raw) != "null" {
+			return kind
+		}
+	}
+	return ""
+}
+
+func describeAssetKind(kind string) string {
+	if kind == "" {
+		return "none of {title, img, video, data}"
+	}
+	return "a " + kind
+}
@@ -0,0 +1,157 @@
+package exchange
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/mxmCherry/openrtb"
+	"github.com/prebid/prebid-server/errortypes"
+	"github.com/prebid/prebid-server/openrtb_ext"
+)
+
+// nativeTestRequest asks for a required title, a required main image, and an optional sponsor.
+// The IDs match what the endpoints assign during request validation.
+const nativeTestRequest = `{"context":1,"plcmttype":1,"assets":[` +
+	`{"id":0,"required":1,"title":{"len":90}},` +
+	`{"id":1,"required":1,"img":{"type":3,"w":300,"h":250}},` +
+	`{"id":2,"data":{"type":1}}]}`
+
+func TestValidNativeBids(t *testing.T) {
+	admList := []string{
+		`{"assets":[{"id":0,"title":{"text":"Buy it"}},{"id":1,"img":{"url":"http://test.com/img.png"}}]}`,
+		`{"native":{"assets":[{"id":0,"title":{"text":"Buy it"}},{"id":1,"img":{"url":"http://test.com/img.png"}}]}}`,
+		`{"assets":[{"id":0,"title":{"text":"Buy it"}},{"id":1,"img":{"type":3,"url":"http://test.com/img.png"}},{"id":2,"data":{"type":1,"value":"Acme"}}]}`,
+		`{"assets":[{"id":0,"title":{"text":"Buy it"}},{"id":1,"img":{"url":"http://test.com/img.png"}},{"data":{"value":"No ID"}}]}`,
+		``,
+	}
+	for _, adm := range admList {
+		if err := validateNativeBid(nativeTestBid(adm), nativeTestBidRequest(), false); err != nil {
+			t.Errorf("Unexpected error validating adm %s: %v", adm, err)
+		}
+	}
+}
+
+func TestInvalidNativeBids(t *testing.T) {
+	admList := []string{
+		`{"assets":[{"id":0,"title":{"text":"Buy it"}}]}`,
+		`{"assets":[{"id":0,"title":{"text":"Buy it"}},{"id":1,"data":{"value":"Not an image"}}]}`,
+		`{"assets":[{"id":0,"title":{"text":"Buy it"}},{"id":1,"img":{"url":"http://test.com/img.png"}},{"id":7,"data":{"value":"Acme"}}]}`,
+		`{"assets":[{"id":0,"title":{"text":"Buy it"}},{"id":1,"img":{"type":1,"url":"http://test.com/img.png"}}]}`,
+		`{"assets":[{"id":0,"title":{"text":"Buy it"}},{"id":1,"img":{"url":"http://test.com/img.png"}},{"id":2,"data":{"type":2,"value":"Acme"}}]}`,
+		`{"assets":[{"id":"0","title":{"text":"Buy it"}},{"id":1,"img":{"url":"http://test.com/img.png"}}]}`,
+		`{"assets":[{"id":0},{"id":1,"img":{"url":"http://test.com/img.png"}}]}`,
+		`<div>Not native</div>`,
+	}
+	for _, adm := range admList {
+		err := validateNativeBid(nativeTestBid(adm), nativeTestBidRequest(), false)
+		if err == nil {
+			t.Errorf("Expected an error validating adm %s", adm)
+			continue
+		}
+		if code := errortypes.DecodeError(err); code != errortypes.BadServerResponseCode {
+			t.Errorf("Expected a BadServerResponse for adm %s. Got code %d: %v", adm, code, err)
+		}
+	}
+}
+
+func TestNativeBidWithoutNativeImp(t *testing.T) {
+	bid := nativeTestBid(`{"assets":[]}`)
+	bid.ImpID = "unknown-imp"
+	if err := validateNativeBid(bid, nativeTestBidRequest(), false); err == nil {
+		t.Error("Expected an error for a native bid on an unknown imp.")
+	}
+}
+
+func TestRewriteNativeAssetIDs(t *testing.T) {
+	bid := nativeTestBid(`{"native":{"ver":"1.1","assets":[{"id":3,"img":{"url":"http://test.com/img.png"}},{"id":1,"title":{"text":"Buy it"}},{"id":2,"data":{"value":"Acme"}}]}}`)
+	if err := validateNativeBid(bid, nativeTestBidRequest(), false); err == nil {
+		t.Fatal("The adm in this test should be invalid until the asset IDs are rewritten.")
+	}
+	if err := validateNativeBid(bid, nativeTestBidRequest(), true); err != nil {
+		t.Fatalf("Unexpected error validating rewritten adm: %v", err)
+	}
+
+	var adm struct {
+		Native struct {
+			Ver    string `json:"ver"`
+			Assets []struct {
+				ID    int64           `json:"id"`
+				Title json.RawMessage `json:"title"`
+				Img   json.RawMessage `json:"img"`
+				Data  json.RawMessage `json:"data"`
+			} `json:"assets"`
+		} `json:"native"`
+	}
+	if err := json.Unmarshal([]byte(bid.AdM), &adm); err != nil {
+		t.Fatalf("Rewritten adm could not be parsed: %v", err)
+	}
+	if adm.Native.Ver != "1.1" {
+		t.Errorf("Rewriting should preserve the other adm fields. Got ver %q", adm.Native.Ver)
+	}
+	expectedIDs := []int64{1, 0, 2}
+	if len(adm.Native.Assets) != len(expectedIDs) {
+		t.Fatalf("Expected %d assets. Got %d", len(expectedIDs), len(adm.Native.Assets))
+	}
+	for i, asset := range adm.Native.Assets {
+		if asset.ID != expectedIDs[i] {
+			t.Errorf("Expected assets[%d].id to be %d. Got %d", i, expectedIDs[i], asset.ID)
+		}
+	}
+	if string(adm.Native.Assets[1].Title) != `{"text":"Buy it"}` {
+		t.Errorf("Rewriting should preserve the asset data. Got title %s", string(adm.Native.Assets[1].Title))
+	}
+}
+
+func TestRewriteNativeAssetIDsUnchanged(t *testing.T) {
+	adm := `{"assets":[{"id":0,"title":{"text":"Buy it"}},{"id":1,"img":{"url":"http://test.com/img.png"}}]}`
+	bid := nativeTestBid(adm)
+	if err := validateNativeBid(bid, nativeTestBidRequest(), true); err != nil {
+		t.Fatalf("Unexpected error validating adm: %v", err)
+	}
+	if bid.AdM != adm {
+		t.Errorf("The adm should be untouched if the IDs were already correct. Got %s", bid.AdM)
+	}
+}
+
+func TestValidateBidsDropsInvalidNative(t *testing.T) {
+	brw := &bidResponseWrapper{
+		adapterBids: &pbsOrtbSeatBid{
+			bids: []*pbsOrtbBid{
+				{
+					bid:     nativeTestBid(`{"assets":[{"id":0,"title":{"text":"Buy it"}},{"id":1,"img":{"url":"http://test.com/img.png"}}]}`),
+					bidType: openrtb_ext.BidTypeNative,
+				},
+				{
+					bid:     nativeTestBid(`{"assets":[{"id":0,"title":{"text":"Buy it"}}]}`),
+					bidType: openrtb_ext.BidTypeNative,
+				},
+				{
+					bid:     nativeTestBid(`<div>Banners aren't checked</div>`),
+					bidType: openrtb_ext.BidTypeBanner,
+				},
+			},
+		},
+	}
+	assertBids(t, nativeTestBidRequest(), brw, 2, 1)
+}
+
+func nativeTestBidRequest() *openrtb.BidRequest {
+	return &openrtb.BidRequest{
+		Imp: []openrtb.Imp{{
+			ID: "native-imp",
+			Native: &openrtb.Native{
+				Request: nativeTestRequest,
+			},
+		}},
+	}
+}
+
+func nativeTestBid(adm string) *openrtb.Bid {
+	return &openrtb.Bid{
+		ID:    "native-bid",
+		ImpID: "native-imp",
+		Price: 1.5,
+		CrID:  "native-creative",
+		AdM:   adm,
+	}
+}
@@ -0,0 +1,84 @@
+package exchange
+
+import (
+	"github.com/prebid/prebid-server/adapters"
+	"github.com/prebid/prebid-server/openrtb_ext"
+)
+
+// nonBid records an imp which a seat didn't get to bid on, or a bid from the seat which the exchange rejected.
+type nonBid struct {
+	impID string
+	// bid is nil if the imp was removed from the bidder's request.
+	bid    *pbsOrtbBid
+	reason openrtb_ext.NonBidReason
+}
+
+// rejectBid records that the bid was removed from the auction.
+// The caller is still responsible for taking it out of seatBid.bids.
+func (seatBid *pbsOrtbSeatBid) rejectBid(bid *pbsOrtbBid, reason openrtb_ext.NonBidReason) {
+	// Empty bids don't have an imp, so there's nothing worth recording.
+	if bid == nil || bid.bid == nil {
+		return
+	}
+	seatBid.nonBids = append(seatBid.nonBids, &nonBid{
+		impID:  bid.bid.ImpID,
+		bid:    bid,
+		reason: reason,
+	})
+}
+
+// rejectImp records that the imp was removed from the bidder's request, so the seat couldn't bid on it.
+func (seatBid *pbsOrtbSeatBid) rejectImp(impID string, reason openrtb_ext.NonBidReason) {
+	seatBid.nonBids = append(seatBid.nonBids, &nonBid{
+		impID:  impID,
+		reason: reason,
+	})
+}
+
+// rejectUnsupportedImps records that the imps were removed from the bidder's request because it doesn't support their media types.
+func (seatBid *pbsOrtbSeatBid) rejectUnsupportedImps(impIDs []string) {
+	for _, impID := range impIDs {
+		seatBid.rejectImp(impID, openrtb_ext.NonBidUnsupportedMediaType)
+	}
+}
+
+// findUnsupportedImps returns the IDs of the imps which adapters.EnforceBidderInfo removed from the bidder's request.
+func findUnsupportedImps(errs []error) []string {
+	var impIDs []string
+	for _, err := range errs {
+		if unsupported, ok := err.(*adapters.UnsupportedImpError); ok {
+			impIDs = append(impIDs, unsupported.ImpID)
+		}
+	}
+	return impIDs
+}
+
+// makeExtNonBids converts the seat's non-bids into the format used by response.ext.seatnonbid.
+func (seatBid *pbsOrtbSeatBid) makeExtNonBids() []openrtb_ext.ExtNonBid {
+	if len(seatBid.nonBids) == 0 {
+		return nil
+	}
+	extNonBids := make([]openrtb_ext.ExtNonBid, len(seatBid.nonBids))
+	for i, nonBid := range seatBid.nonBids {
+		extNonBids[i].ImpID = nonBid.impID
+		extNonBids[i].StatusCode = nonBid.reason
+		if nonBid.bid != nil {
+			extNonBids[i].BidID = nonBid.bid.bid.ID
+			extNonBids[i].Price = nonBid.bid.bid.Price
+		}
+	}
+	return extNonBids
+}
+
+// recordNonBids counts the non-bids for each bidder. Aliases are counted under the bidder they alias.
+func (e *exchange) recordNonBids(adapterBids map[openrtb_ext.BidderName]*pbsOrtbSeatBid, aliases map[string]string) {
+	for bidderName, seatBid := range adapterBids {
+		if seatBid == nil {
+			continue
+		}
+		coreBidder := resolveBidder(string(bidderName), aliases)
+		for _, nonBid := range seatBid.nonBids {
+			e.me.RecordAdapterNonBid(coreBidder, nonBid.reason)
+		}
+	}
+}
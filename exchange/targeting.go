@@ -22,6 +22,8 @@ type targetData struct {
 	includeBidderKeys bool
 	includeCacheBids  bool
 	includeCacheVast  bool
+	enforceBlocks     bool
+	dedupCategories   bool
 }
 
 // setTargeting writes all the targeting params into the bids.
@@ -53,6 +55,9 @@ func (targData *targetData) setTargeting(auc *auction, isApp bool) {
 			if deal := topBidPerBidder.bid.DealID; len(deal) > 0 {
 				targData.addKeys(targets, openrtb_ext.HbDealIdConstantKey, deal, bidderName, isOverallWinner)
 			}
+			if events := topBidPerBidder.bidEvents; events != nil {
+				targData.addKeys(targets, openrtb_ext.HbWinURLKey, events.Win, bidderName, isOverallWinner)
+			}
 
 			if bidderName == "audienceNetwork" {
 				targets[string(openrtb_ext.HbCreativeLoadMethodConstantKey)] = openrtb_ext.HbCreativeLoadMethodDemandSDK
@@ -69,6 +74,21 @@ func (targData *targetData) setTargeting(auc *auction, isApp bool) {
 	}
 }
 
+// rejectUntargetedBids records the bids which didn't get any targeting keys. They stay in the response,
+// but they can't win in the ad server.
+func rejectUntargetedBids(adapterBids map[openrtb_ext.BidderName]*pbsOrtbSeatBid) {
+	for _, seatBid := range adapterBids {
+		if seatBid == nil {
+			continue
+		}
+		for _, bid := range seatBid.bids {
+			if bid.bidTargets == nil {
+				seatBid.rejectBid(bid, openrtb_ext.NonBidNoTargeting)
+			}
+		}
+	}
+}
+
 func (targData *targetData) addKeys(keys map[string]string, key openrtb_ext.TargetingKey, value string, bidderName openrtb_ext.BidderName, overallWinner bool) {
 	if targData.includeBidderKeys {
 		keys[key.BidderKey(bidderName, maxKeyLength)] = value
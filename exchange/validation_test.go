@@ -248,7 +248,7 @@ func TestCurrencyBids(t *testing.T) {
 }
 
 func assertBids(t *testing.T, brq *openrtb.BidRequest, brw *bidResponseWrapper, ebids int, eerrs int) {
-	errs := brw.validateBids(brq)
+	errs := brw.validateBids(brq, false)
 	if len(errs) != eerrs {
 		t.Errorf("Expected %d Errors validating bids, found %d", eerrs, len(errs))
 	}
@@ -0,0 +1,145 @@
+package exchange
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mxmCherry/openrtb"
+	"github.com/prebid/prebid-server/errortypes"
+	"github.com/prebid/prebid-server/openrtb_ext"
+	"github.com/prebid/prebid-server/pbsmetrics"
+)
+
+// vastModifier adds tracking to the VAST of video bids before it's cached.
+type vastModifier struct {
+	events             *eventTracking
+	impressionTrackers []string
+	errorTrackers      []string
+}
+
+// modifyBids sets the VAST which will be cached for each video bid. Bids whose VAST can't be parsed are removed
+// from the auction. Like blocked bids, they're reported as errors.
+func (m *vastModifier) modifyBids(adapterBids map[openrtb_ext.BidderName]*pbsOrtbSeatBid, adapterExtra map[openrtb_ext.BidderName]*seatResponseExtra) {
+	for bidderName, seatBid := range adapterBids {
+		if seatBid == nil {
+			continue
+		}
+		var errs []error
+		validBids := make([]*pbsOrtbBid, 0, len(seatBid.bids))
+		for _, bid := range seatBid.bids {
+			if bid.bidType != openrtb_ext.BidTypeVideo {
+				validBids = append(validBids, bid)
+				continue
+			}
+			vast, err := m.modify(bidderName, bid.bid)
+			if err != nil {
+				seatBid.rejectBid(bid, openrtb_ext.NonBidMalformedVAST)
+				errs = append(errs, &errortypes.BadServerResponse{
+					Message: fmt.Sprintf("Bid \"%s\" has malformed VAST: %v", bid.bid.ID, err),
+				})
+				continue
+			}
+			bid.vast = vast
+			validBids = append(validBids, bid)
+		}
+		if len(errs) > 0 {
+			seatBid.bids = validBids
+			if extra := adapterExtra[bidderName]; extra != nil {
+				extra.Errors = append(extra.Errors, errsToBidderErrors(errs)...)
+			}
+		}
+	}
+}
+
+// modify returns the bid's VAST with the trackers added. Bids which only have an nurl get wrapped first.
+func (m *vastModifier) modify(bidderName openrtb_ext.BidderName, bid *openrtb.Bid) (string, error) {
+	impressions := make([]string, 0, len(m.impressionTrackers)+1)
+	impressions = append(impressions, m.events.makeEventURL(pbsmetrics.EventImp, bid.ID, bidderName))
+	impressions = append(impressions, m.impressionTrackers...)
+	return injectVASTTrackers(makeVAST(bid), impressions, m.errorTrackers)
+}
+
+// injectVASTTrackers adds <Error> and <Impression> elements to every InLine and Wrapper ad in the VAST.
+// It returns an error if the VAST isn't well-formed XML, or doesn't have any ads.
+//
+// The VAST is edited as text, rather than re-encoded, so that everything else in it stays exactly as the bidder sent it.
+// The new elements go just before <Creatives>, since the VAST schemas expect them in that order.
+func injectVASTTrackers(vast string, impressions []string, errorTrackers []string) (string, error) {
+	insertAt, err := findTrackerOffsets(vast)
+	if err != nil {
+		return "", err
+	}
+
+	var trackers bytes.Buffer
+	for _, url := range errorTrackers {
+		trackers.WriteString("<Error><![CDATA[" + url + "]]></Error>")
+	}
+	for _, url := range impressions {
+		trackers.WriteString("<Impression><![CDATA[" + url + "]]></Impression>")
+	}
+
+	var modified bytes.Buffer
+	modified.Grow(len(vast) + len(insertAt)*trackers.Len())
+	var last int64
+	for _, offset := range insertAt {
+		modified.WriteString(vast[last:offset])
+		modified.Write(trackers.Bytes())
+		last = offset
+	}
+	modified.WriteString(vast[last:])
+	return modified.String(), nil
+}
+
+// findTrackerOffsets returns the offsets in the VAST where the trackers for each ad should be inserted.
+func findTrackerOffsets(vast string) ([]int64, error) {
+	decoder := xml.NewDecoder(strings.NewReader(vast))
+	var insertAt []int64
+	var path []string
+	// creativesAt is the offset of the <Creatives> in the current ad, or -1 if there isn't one yet.
+	var creativesAt int64 = -1
+	for {
+		offset := decoder.InputOffset()
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch element := token.(type) {
+		case xml.StartElement:
+			if len(path) == 0 && element.Name.Local != "VAST" {
+				return nil, fmt.Errorf("the root element is <%s>, not <VAST>", element.Name.Local)
+			}
+			if element.Name.Local == "Creatives" && isVASTAd(path) && creativesAt < 0 {
+				creativesAt = offset
+			}
+			path = append(path, element.Name.Local)
+		case xml.EndElement:
+			if isVASTAd(path) {
+				if creativesAt < 0 {
+					creativesAt = offset
+				}
+				insertAt = append(insertAt, creativesAt)
+				creativesAt = -1
+			}
+			path = path[:len(path)-1]
+		}
+	}
+	if len(insertAt) == 0 {
+		return nil, errors.New("it has no InLine or Wrapper ads")
+	}
+	return insertAt, nil
+}
+
+// isVASTAd returns true if the path leads to an <InLine> or <Wrapper> ad.
+func isVASTAd(path []string) bool {
+	if len(path) != 3 || path[0] != "VAST" || path[1] != "Ad" {
+		return false
+	}
+	return path[2] == "InLine" || path[2] == "Wrapper"
+}
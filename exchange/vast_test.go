@@ -0,0 +1,77 @@
+package exchange
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mxmCherry/openrtb"
+	"github.com/prebid/prebid-server/openrtb_ext"
+)
+
+func TestInjectVASTTrackers(t *testing.T) {
+	vast := `<?xml version="1.0"?><VAST version="3.0">` +
+		`<Ad id="1"><InLine><AdSystem>bidder</AdSystem><Impression><![CDATA[http://bidder.com/imp]]></Impression><Creatives><Creative></Creative></Creatives></InLine></Ad>` +
+		`<Ad id="2"><Wrapper><AdSystem>bidder</AdSystem><VASTAdTagURI>http://bidder.com/vast</VASTAdTagURI></Wrapper></Ad>` +
+		`</VAST>`
+	modified, err := injectVASTTrackers(vast, []string{"http://pbs.com/imp", "http://tracker.com/imp"}, []string{"http://tracker.com/err"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	trackers := `<Error><![CDATA[http://tracker.com/err]]></Error>` +
+		`<Impression><![CDATA[http://pbs.com/imp]]></Impression>` +
+		`<Impression><![CDATA[http://tracker.com/imp]]></Impression>`
+	expected := `<?xml version="1.0"?><VAST version="3.0">` +
+		`<Ad id="1"><InLine><AdSystem>bidder</AdSystem><Impression><![CDATA[http://bidder.com/imp]]></Impression>` + trackers + `<Creatives><Creative></Creative></Creatives></InLine></Ad>` +
+		`<Ad id="2"><Wrapper><AdSystem>bidder</AdSystem><VASTAdTagURI>http://bidder.com/vast</VASTAdTagURI>` + trackers + `</Wrapper></Ad>` +
+		`</VAST>`
+	if modified != expected {
+		t.Errorf("Trackers should go before the Creatives, or at the end of the ad.\nExpected %s\nGot      %s", expected, modified)
+	}
+}
+
+func TestInjectVASTTrackersMalformed(t *testing.T) {
+	for _, vast := range []string{
+		`<VAST version="3.0"><Ad><InLine></Ad></VAST>`,
+		`<VAST version="3.0"></VAST>`,
+		`<div>not a video</div>`,
+		``,
+	} {
+		if _, err := injectVASTTrackers(vast, []string{"http://pbs.com/imp"}, nil); err == nil {
+			t.Errorf("Expected an error for %s", vast)
+		}
+	}
+}
+
+func TestModifyVASTBids(t *testing.T) {
+	wrapped := &pbsOrtbBid{bid: &openrtb.Bid{ID: "wrapped", ImpID: "imp", NURL: "http://bidder.com/vast"}, bidType: openrtb_ext.BidTypeVideo}
+	malformed := &pbsOrtbBid{bid: &openrtb.Bid{ID: "malformed", ImpID: "imp", AdM: "<VAST>"}, bidType: openrtb_ext.BidTypeVideo}
+	banner := &pbsOrtbBid{bid: &openrtb.Bid{ID: "banner", ImpID: "imp", AdM: "<div></div>"}, bidType: openrtb_ext.BidTypeBanner}
+	seatBid := &pbsOrtbSeatBid{bids: []*pbsOrtbBid{wrapped, malformed, banner}}
+	adapterExtra := map[openrtb_ext.BidderName]*seatResponseExtra{"appnexus": {}}
+
+	modifier := &vastModifier{
+		events:        newEventTracking("http://pbs.com", &openrtb.BidRequest{}, time.Unix(0, 0)),
+		errorTrackers: []string{"http://tracker.com/err"},
+	}
+	modifier.modifyBids(map[openrtb_ext.BidderName]*pbsOrtbSeatBid{"appnexus": seatBid}, adapterExtra)
+
+	if len(seatBid.bids) != 2 || seatBid.bids[0] != wrapped || seatBid.bids[1] != banner {
+		t.Fatalf("Only the malformed bid should be removed. Got %d bids", len(seatBid.bids))
+	}
+	if len(seatBid.nonBids) != 1 || seatBid.nonBids[0].bid != malformed || seatBid.nonBids[0].reason != openrtb_ext.NonBidMalformedVAST {
+		t.Errorf("The malformed bid should be rejected. Got %#v", seatBid.nonBids)
+	}
+	if len(adapterExtra["appnexus"].Errors) != 1 {
+		t.Errorf("The malformed bid should be reported as an error. Got %#v", adapterExtra["appnexus"].Errors)
+	}
+	if !strings.Contains(wrapped.vast, "<VASTAdTagURI><![CDATA[http://bidder.com/vast]]></VASTAdTagURI>") ||
+		!strings.Contains(wrapped.vast, "<Error><![CDATA[http://tracker.com/err]]></Error>") ||
+		!strings.Contains(wrapped.vast, "<Impression><![CDATA[http://pbs.com/event?b=wrapped&bidder=appnexus&t=imp&ts=0]]></Impression>") {
+		t.Errorf("Bids with only an nurl should be wrapped, with tracking. Got %s", wrapped.vast)
+	}
+	if banner.vast != "" {
+		t.Errorf("Only video bids should be modified. Got %s", banner.vast)
+	}
+}
@@ -17,6 +17,11 @@ type ExtBidPrebid struct {
 	Cache     *ExtBidPrebidCache `json:"cache,omitempty"`
 	Targeting map[string]string  `json:"targeting,omitempty"`
 	Type      BidType            `json:"type"`
+	// Category is the bid's primary category, translated into the host's ad server categories.
+	// It's only set if the host has configured a category mapping.
+	Category string `json:"category,omitempty"`
+	// Events are only set if the request asked for them with ext.prebid.events.
+	Events *ExtBidPrebidEvents `json:"events,omitempty"`
 }
 
 // ExtBidPrebidCache defines the contract for  bidresponse.seatbid.bid[i].ext.prebid.cache
@@ -25,6 +30,13 @@ type ExtBidPrebidCache struct {
 	Url string `json:"url"`
 }
 
+// ExtBidPrebidEvents defines the contract for bidresponse.seatbid.bid[i].ext.prebid.events
+// These URLs should be called when the bid wins in the ad server, and when its creative renders.
+type ExtBidPrebidEvents struct {
+	Win string `json:"win"`
+	Imp string `json:"imp"`
+}
+
 // BidType describes the allowed values for bidresponse.seatbid.bid[i].ext.prebid.type
 type BidType string
 
@@ -96,6 +108,14 @@ const (
 	HbCacheKey     TargetingKey = "hb_cache_id"
 	HbVastCacheKey TargetingKey = "hb_uuid"
 
+	// HbCategoryDurationKey is used by the /openrtb2/video endpoint for ad pods. Its value is "{hb_pb}_{category}_{duration}s",
+	// which lets ad servers target line items on the price, category and duration of each ad in the pod at once.
+	HbCategoryDurationKey TargetingKey = "hb_pb_cat_dur"
+
+	// HbWinURLKey is the URL which reports the bid's win to the /event endpoint. It only exists if the request
+	// asked for events with ext.prebid.events.
+	HbWinURLKey TargetingKey = "hb_winurl"
+
 	// These are not keys, but values used by hbCreativeLoadMethodConstantKey
 	HbCreativeLoadMethodHTML      string = "html"
 	HbCreativeLoadMethodDemandSDK string = "demand_sdk"
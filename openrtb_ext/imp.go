@@ -16,4 +16,7 @@ type ExtImpPrebid struct {
 // ExtStoredRequest defines the contract for bidrequest.imp[i].ext.prebid.storedrequest
 type ExtStoredRequest struct {
 	ID string `json:"id"`
+	// Chain lists the Stored Requests which were merged to build this one, starting with ID.
+	// Prebid Server only fills this in on test requests, so that it shows up in the debug output.
+	Chain []string `json:"chain,omitempty"`
 }
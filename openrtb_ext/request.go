@@ -12,11 +12,12 @@ type ExtRequest struct {
 
 // ExtRequestPrebid defines the contract for bidrequest.ext.prebid
 type ExtRequestPrebid struct {
-	Aliases              map[string]string      `json:"aliases,omitempty"`
-	BidAdjustmentFactors map[string]float64     `json:"bidadjustmentfactors,omitempty"`
-	Cache                *ExtRequestPrebidCache `json:"cache,omitempty"`
-	StoredRequest        *ExtStoredRequest      `json:"storedrequest,omitempty"`
-	Targeting            *ExtRequestTargeting   `json:"targeting,omitempty"`
+	Aliases              map[string]string       `json:"aliases,omitempty"`
+	BidAdjustmentFactors map[string]float64      `json:"bidadjustmentfactors,omitempty"`
+	Cache                *ExtRequestPrebidCache  `json:"cache,omitempty"`
+	Events               *ExtRequestPrebidEvents `json:"events,omitempty"`
+	StoredRequest        *ExtStoredRequest       `json:"storedrequest,omitempty"`
+	Targeting            *ExtRequestTargeting    `json:"targeting,omitempty"`
 }
 
 // ExtRequestPrebidCache defines the contract for bidrequest.ext.prebid.cache
@@ -47,11 +48,19 @@ type ExtRequestPrebidCacheBids struct{}
 // ExtRequestPrebidCacheVAST defines the contract for bidrequest.ext.prebid.cache.vastxml
 type ExtRequestPrebidCacheVAST struct{}
 
+// ExtRequestPrebidEvents defines the contract for bidrequest.ext.prebid.events
+// If it's present, each bid in the response will include URLs which report its wins and impressions to /event.
+type ExtRequestPrebidEvents struct{}
+
 // ExtRequestTargeting defines the contract for bidrequest.ext.prebid.targeting
 type ExtRequestTargeting struct {
 	PriceGranularity  PriceGranularity `json:"pricegranularity"`
 	IncludeWinners    bool             `json:"includewinners"`
 	IncludeBidderKeys bool             `json:"includebidderkeys"`
+	// EnforceBlocks drops any bids whose cat or adomain is blocked by request.bcat or request.badv.
+	EnforceBlocks bool `json:"enforceblocks,omitempty"`
+	// DedupCategories makes sure that no two winning bids share the same primary category.
+	DedupCategories bool `json:"dedupcategories,omitempty"`
 }
 
 // Make an unmarshaller that will set a default PriceGranularity
@@ -13,6 +13,8 @@ type ExtBidResponse struct {
 	ResponseTimeMillis map[BidderName]int `json:"responsetimemillis,omitempty"`
 	// ExtResponseUserSync defines the contract for bidresponse.ext.usersync
 	Usersync map[BidderName]*ExtResponseSyncData `json:"usersync,omitempty"`
+	// SeatNonBid defines the contract for bidresponse.ext.seatnonbid. It's only included on test requests.
+	SeatNonBid []ExtSeatNonBid `json:"seatnonbid,omitempty"`
 }
 
 // ExtResponseDebug defines the contract for bidresponse.ext.debug
@@ -50,6 +52,88 @@ type ExtHttpCall struct {
 	Status       int    `json:"status"`
 }
 
+// ExtSeatNonBid defines the contract for bidresponse.ext.seatnonbid[i]
+type ExtSeatNonBid struct {
+	Seat   string      `json:"seat"`
+	NonBid []ExtNonBid `json:"nonbid"`
+}
+
+// ExtNonBid defines the contract for bidresponse.ext.seatnonbid[i].nonbid[j]
+//
+// It describes an imp which the seat didn't get to bid on, or a bid from the seat which was rejected.
+// BidID and Price are only set in the second case.
+type ExtNonBid struct {
+	ImpID      string       `json:"impid"`
+	StatusCode NonBidReason `json:"statuscode"`
+	BidID      string       `json:"bidid,omitempty"`
+	Price      float64      `json:"price,omitempty"`
+}
+
+// NonBidReason is the standardized code for why a seat has no bid on an imp.
+//
+// The codes follow the ranges used by the Prebid seatnonbid proposal. 2xx means the imp was removed from
+// the bidder's request, and 3xx means the bidder's bid was rejected.
+type NonBidReason int
+
+const (
+	// NonBidUnsupportedMediaType means the bidder doesn't support any of the imp's media types.
+	NonBidUnsupportedMediaType NonBidReason = 202
+	// NonBidInvalidBid means the bid was missing a required field, or had invalid native assets.
+	NonBidInvalidBid NonBidReason = 300
+	// NonBidInvalidCurrency means the bid's currency wasn't allowed by request.cur, or didn't match
+	// the currency of the bidder's other responses.
+	NonBidInvalidCurrency NonBidReason = 301
+	// NonBidBlockedCategory means the bid had a category which was blocked by request.bcat.
+	NonBidBlockedCategory NonBidReason = 303
+	// NonBidBlockedAdvertiser means the bid had an advertiser domain which was blocked by request.badv.
+	NonBidBlockedAdvertiser NonBidReason = 304
+	// NonBidDuplicateCategory means a higher bid on another imp had the same primary category.
+	NonBidDuplicateCategory NonBidReason = 305
+	// NonBidNoTargeting means targeting was requested, but the bid didn't get any keys, because
+	// the bidder made a higher bid on the same imp.
+	NonBidNoTargeting NonBidReason = 306
+	// NonBidMalformedVAST means the host modifies VAST before caching it, but the bid's VAST couldn't be parsed.
+	NonBidMalformedVAST NonBidReason = 307
+)
+
+// NonBidReasons returns all possible values of NonBidReason.
+func NonBidReasons() []NonBidReason {
+	return []NonBidReason{
+		NonBidUnsupportedMediaType,
+		NonBidInvalidBid,
+		NonBidInvalidCurrency,
+		NonBidBlockedCategory,
+		NonBidBlockedAdvertiser,
+		NonBidDuplicateCategory,
+		NonBidNoTargeting,
+		NonBidMalformedVAST,
+	}
+}
+
+// String returns a name for the reason which is safe to use in metric names.
+func (reason NonBidReason) String() string {
+	switch reason {
+	case NonBidUnsupportedMediaType:
+		return "unsupported_media_type"
+	case NonBidInvalidBid:
+		return "invalid_bid"
+	case NonBidInvalidCurrency:
+		return "invalid_currency"
+	case NonBidBlockedCategory:
+		return "blocked_category"
+	case NonBidBlockedAdvertiser:
+		return "blocked_advertiser"
+	case NonBidDuplicateCategory:
+		return "duplicate_category"
+	case NonBidNoTargeting:
+		return "no_targeting"
+	case NonBidMalformedVAST:
+		return "malformed_vast"
+	default:
+		return "unknown"
+	}
+}
+
 // CookieStatus describes the allowed values for bidresponse.ext.usersync.{bidder}.status
 type CookieStatus string
 
@@ -0,0 +1,221 @@
+package pbs
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/mxmCherry/openrtb"
+	"github.com/prebid/prebid-server/openrtb_ext"
+)
+
+// ToOpenRTB is a best-effort transformation of a legacy request into an OpenRTB BidRequest, which asks the given
+// Bidders for bids on their Ad Units. It's the inverse of the transformations in exchange/legacy.go, and lets
+// legacy traffic run through the Exchange.
+//
+// Each Ad Unit becomes an Imp whose ID is the Ad Unit code. Its ext has the params for every Bidder which bids on it.
+// If the Ad Unit has a config_id, it's also used as the ID of a Stored Imp.
+//
+// The aliases map the BidderCodes which aren't core BidderNames (e.g. "districtm") onto the Bidders which should handle them.
+func (req *PBSRequest) ToOpenRTB(bidders []*PBSBidder, aliases map[string]string) (*openrtb.BidRequest, error) {
+	impExts := make(map[string]map[string]json.RawMessage, len(req.AdUnits))
+	units := make(map[string]*PBSAdUnit, len(req.AdUnits))
+	usedAliases := make(map[string]string)
+	for _, bidder := range bidders {
+		if coreBidder, ok := aliases[bidder.BidderCode]; ok {
+			usedAliases[bidder.BidderCode] = coreBidder
+		}
+		for i := range bidder.AdUnits {
+			unit := &bidder.AdUnits[i]
+			if _, ok := units[unit.Code]; !ok {
+				units[unit.Code] = unit
+				impExts[unit.Code] = make(map[string]json.RawMessage)
+			}
+			params := unit.Params
+			if len(params) == 0 {
+				params = json.RawMessage(`{}`)
+			}
+			impExts[unit.Code][bidder.BidderCode] = params
+		}
+	}
+
+	secure := req.Secure
+	bidReq := &openrtb.BidRequest{
+		ID:     req.Tid,
+		Imp:    make([]openrtb.Imp, 0, len(units)),
+		Device: req.Device,
+		User:   req.User,
+		TMax:   req.TimeoutMillis,
+		Source: &openrtb.Source{
+			TID: req.Tid,
+		},
+		Regs: req.Regs,
+	}
+	if req.IsDebug {
+		bidReq.Test = 1
+	}
+
+	// Keep the Imps in the same order as the Ad Units
+	for _, adUnit := range req.AdUnits {
+		unit, ok := units[adUnit.Code]
+		if !ok {
+			continue
+		}
+		delete(units, adUnit.Code)
+		if adUnit.ConfigID != "" {
+			impExts[unit.Code]["prebid"] = storedImpExt(adUnit.ConfigID)
+		}
+		ext, err := json.Marshal(impExts[unit.Code])
+		if err != nil {
+			return nil, err
+		}
+		bidReq.Imp = append(bidReq.Imp, toImp(unit, &secure, ext))
+	}
+
+	if req.App != nil {
+		app := *req.App
+		if app.Publisher == nil {
+			app.Publisher = &openrtb.Publisher{ID: req.AccountID}
+		}
+		bidReq.App = &app
+	} else {
+		bidReq.Site = &openrtb.Site{
+			Page:   req.Url,
+			Domain: req.Domain,
+			Publisher: &openrtb.Publisher{
+				ID: req.AccountID,
+			},
+		}
+	}
+
+	if len(usedAliases) > 0 {
+		ext, err := json.Marshal(openrtb_ext.ExtRequest{
+			Prebid: openrtb_ext.ExtRequestPrebid{
+				Aliases: usedAliases,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		bidReq.Ext = ext
+	}
+
+	return bidReq, nil
+}
+
+func storedImpExt(id string) json.RawMessage {
+	ext, _ := json.Marshal(openrtb_ext.ExtImpPrebid{
+		StoredRequest: &openrtb_ext.ExtStoredRequest{
+			ID: id,
+		},
+	})
+	return ext
+}
+
+func toImp(unit *PBSAdUnit, secure *int8, ext json.RawMessage) openrtb.Imp {
+	imp := openrtb.Imp{
+		ID:     unit.Code,
+		TagID:  unit.Code,
+		Instl:  unit.Instl,
+		Secure: secure,
+		Ext:    openrtb.RawJSON(ext),
+	}
+	for _, mediaType := range unit.MediaTypes {
+		switch mediaType {
+		case MEDIA_TYPE_BANNER:
+			imp.Banner = &openrtb.Banner{
+				Format:   unit.Sizes,
+				TopFrame: unit.TopFrame,
+			}
+		case MEDIA_TYPE_VIDEO:
+			imp.Video = toVideo(unit)
+		}
+	}
+	return imp
+}
+
+func toVideo(unit *PBSAdUnit) *openrtb.Video {
+	startDelay := openrtb.StartDelay(unit.Video.Startdelay)
+	skip := int8(unit.Video.Skippable)
+	video := &openrtb.Video{
+		MIMEs:       unit.Video.Mimes,
+		MinDuration: unit.Video.Minduration,
+		MaxDuration: unit.Video.Maxduration,
+		StartDelay:  &startDelay,
+		Skip:        &skip,
+	}
+	if unit.Video.PlaybackMethod != 0 {
+		video.PlaybackMethod = []openrtb.PlaybackMethod{openrtb.PlaybackMethod(unit.Video.PlaybackMethod)}
+	}
+	if len(unit.Video.Protocols) > 0 {
+		video.Protocols = make([]openrtb.Protocol, len(unit.Video.Protocols))
+		for i, protocol := range unit.Video.Protocols {
+			video.Protocols[i] = openrtb.Protocol(protocol)
+		}
+	}
+	if len(unit.Sizes) > 0 {
+		video.W = unit.Sizes[0].W
+		video.H = unit.Sizes[0].H
+	}
+	return video
+}
+
+// FromOpenRTB is a best-effort transformation of the Exchange's response to a request made by ToOpenRTB.
+// It returns the Bidder's bids, and updates the Bidder's status fields to describe them.
+func (bidder *PBSBidder) FromOpenRTB(resp *openrtb.BidResponse, respExt *openrtb_ext.ExtBidResponse) PBSBidSlice {
+	name := openrtb_ext.BidderName(bidder.BidderCode)
+	if respExt != nil {
+		bidder.ResponseTime = respExt.ResponseTimeMillis[name]
+		if bidderErrs := respExt.Errors[name]; len(bidderErrs) > 0 {
+			messages := make([]string, len(bidderErrs))
+			for i, bidderErr := range bidderErrs {
+				messages[i] = bidderErr.Message
+			}
+			bidder.Error = strings.Join(messages, "; ")
+		}
+	}
+
+	var bids PBSBidSlice
+	if resp != nil {
+		for _, seatBid := range resp.SeatBid {
+			if seatBid.Seat != bidder.BidderCode {
+				continue
+			}
+			for i := range seatBid.Bid {
+				bid := &seatBid.Bid[i]
+				// Some Bidders (e.g. indexExchange) make a separate PBSBidder for each Ad Unit.
+				if bidder.LookupAdUnit(bid.ImpID) == nil {
+					continue
+				}
+				bids = append(bids, bidder.toPBSBid(bid))
+			}
+		}
+	}
+
+	bidder.NumBids = len(bids)
+	if len(bids) == 0 && bidder.Error == "" {
+		bidder.NoBid = true
+	}
+	return bids
+}
+
+func (bidder *PBSBidder) toPBSBid(bid *openrtb.Bid) *PBSBid {
+	var mediaType string
+	var bidExt openrtb_ext.ExtBid
+	if err := json.Unmarshal(bid.Ext, &bidExt); err == nil && bidExt.Prebid != nil {
+		mediaType = string(bidExt.Prebid.Type)
+	}
+	return &PBSBid{
+		BidID:             bidder.LookupBidID(bid.ImpID),
+		AdUnitCode:        bid.ImpID,
+		Creative_id:       bid.CrID,
+		CreativeMediaType: mediaType,
+		BidderCode:        bidder.BidderCode,
+		Price:             bid.Price,
+		NURL:              bid.NURL,
+		Adm:               bid.AdM,
+		Width:             bid.W,
+		Height:            bid.H,
+		DealId:            bid.DealID,
+		ResponseTime:      bidder.ResponseTime,
+	}
+}
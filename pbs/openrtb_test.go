@@ -0,0 +1,211 @@
+package pbs
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/buger/jsonparser"
+	"github.com/mxmCherry/openrtb"
+	"github.com/prebid/prebid-server/openrtb_ext"
+)
+
+func TestToOpenRTBSite(t *testing.T) {
+	req := &PBSRequest{
+		AccountID:     "acct",
+		Tid:           "tid",
+		Secure:        1,
+		TimeoutMillis: 500,
+		IsDebug:       true,
+		AdUnits: []AdUnit{
+			{Code: "first"},
+			{Code: "unused"},
+			{Code: "second", ConfigID: "config"},
+		},
+		Url:    "http://example.com/page",
+		Domain: "example.com",
+		User:   &openrtb.User{ID: "user"},
+	}
+	bidders := []*PBSBidder{{
+		BidderCode: "appnexus",
+		AdUnits: []PBSAdUnit{
+			{Code: "second", Params: json.RawMessage(`{"placementId":2}`), MediaTypes: []MediaType{MEDIA_TYPE_BANNER}, Sizes: []openrtb.Format{{W: 728, H: 90}}, TopFrame: 1},
+			{Code: "first", Params: json.RawMessage(`{"placementId":1}`), MediaTypes: []MediaType{MEDIA_TYPE_BANNER}, Sizes: []openrtb.Format{{W: 300, H: 250}}},
+		},
+	}, {
+		BidderCode: "rubicon",
+		AdUnits: []PBSAdUnit{
+			{Code: "second", MediaTypes: []MediaType{MEDIA_TYPE_BANNER}, Sizes: []openrtb.Format{{W: 728, H: 90}}},
+		},
+	}}
+
+	bidReq, err := req.ToOpenRTB(bidders, map[string]string{"districtm": "appnexus"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if bidReq.ID != "tid" || bidReq.Source == nil || bidReq.Source.TID != "tid" {
+		t.Errorf("The transaction ID should be used for the request ID and source.tid. Got %s", mustMarshal(t, bidReq))
+	}
+	if bidReq.TMax != 500 || bidReq.Test != 1 || bidReq.User.ID != "user" {
+		t.Errorf("The request-level fields were not copied. Got %s", mustMarshal(t, bidReq))
+	}
+	if bidReq.Site == nil || bidReq.Site.Page != "http://example.com/page" || bidReq.Site.Domain != "example.com" || bidReq.Site.Publisher.ID != "acct" {
+		t.Errorf("Web requests should have a site. Got %s", mustMarshal(t, bidReq.Site))
+	}
+	if len(bidReq.Ext) != 0 {
+		t.Errorf("Aliases should only be set if they're used. Got %s", string(bidReq.Ext))
+	}
+
+	if len(bidReq.Imp) != 2 {
+		t.Fatalf("Expected an Imp for each Ad Unit with bidders. Got %s", mustMarshal(t, bidReq.Imp))
+	}
+	first, second := bidReq.Imp[0], bidReq.Imp[1]
+	if first.ID != "first" || second.ID != "second" {
+		t.Errorf("The Imps should be in the same order as the Ad Units. Got %s, %s", first.ID, second.ID)
+	}
+	if second.Banner == nil || len(second.Banner.Format) != 1 || second.Banner.TopFrame != 1 || second.Video != nil {
+		t.Errorf("Banner Ad Units should become banner Imps. Got %s", mustMarshal(t, second))
+	}
+	if second.Secure == nil || *second.Secure != 1 {
+		t.Errorf("Imps should be secure if the request was. Got %s", mustMarshal(t, second))
+	}
+	if placementId, _ := jsonparser.GetInt(second.Ext, "appnexus", "placementId"); placementId != 2 {
+		t.Errorf("The appnexus params were not copied. Got %s", string(second.Ext))
+	}
+	if rubicon, _, _, _ := jsonparser.Get(second.Ext, "rubicon"); string(rubicon) != "{}" {
+		t.Errorf("Bidders without params should get an empty object. Got %s", string(second.Ext))
+	}
+	if id, _ := jsonparser.GetString(second.Ext, "prebid", "storedrequest", "id"); id != "config" {
+		t.Errorf("The config_id should be used as a Stored Imp ID. Got %s", string(second.Ext))
+	}
+	if _, _, _, err := jsonparser.Get(first.Ext, "prebid"); err == nil {
+		t.Errorf("Ad Units without a config_id should not reference a Stored Imp. Got %s", string(first.Ext))
+	}
+}
+
+func TestToOpenRTBApp(t *testing.T) {
+	req := &PBSRequest{
+		AccountID: "acct",
+		AdUnits:   []AdUnit{{Code: "video"}},
+		App:       &openrtb.App{Bundle: "com.example"},
+	}
+	bidders := []*PBSBidder{{
+		BidderCode: "districtm",
+		AdUnits: []PBSAdUnit{{
+			Code:       "video",
+			MediaTypes: []MediaType{MEDIA_TYPE_VIDEO},
+			Sizes:      []openrtb.Format{{W: 640, H: 480}},
+			Video: PBSVideo{
+				Mimes:          []string{"video/mp4"},
+				Minduration:    5,
+				Maxduration:    30,
+				Startdelay:     -1,
+				Skippable:      1,
+				PlaybackMethod: 2,
+				Protocols:      []int8{2, 3},
+			},
+		}},
+	}}
+
+	bidReq, err := req.ToOpenRTB(bidders, map[string]string{"districtm": "appnexus"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if bidReq.Site != nil || bidReq.App == nil || bidReq.App.Bundle != "com.example" || bidReq.App.Publisher.ID != "acct" {
+		t.Errorf("App requests should have an app with the account as its publisher. Got %s", mustMarshal(t, bidReq))
+	}
+	if req.App.Publisher != nil {
+		t.Error("The legacy request's App should not be modified.")
+	}
+	if alias, _ := jsonparser.GetString(bidReq.Ext, "prebid", "aliases", "districtm"); alias != "appnexus" {
+		t.Errorf("districtm should be aliased to appnexus. Got %s", string(bidReq.Ext))
+	}
+
+	video := bidReq.Imp[0].Video
+	if video == nil || bidReq.Imp[0].Banner != nil {
+		t.Fatalf("Video Ad Units should become video Imps. Got %s", mustMarshal(t, bidReq.Imp[0]))
+	}
+	if len(video.MIMEs) != 1 || video.MinDuration != 5 || video.MaxDuration != 30 || video.W != 640 || video.H != 480 {
+		t.Errorf("The video fields were not copied. Got %s", mustMarshal(t, video))
+	}
+	if *video.StartDelay != -1 || *video.Skip != 1 || len(video.PlaybackMethod) != 1 || video.PlaybackMethod[0] != 2 || len(video.Protocols) != 2 {
+		t.Errorf("The video enums were not copied. Got %s", mustMarshal(t, video))
+	}
+}
+
+func TestFromOpenRTB(t *testing.T) {
+	resp := &openrtb.BidResponse{
+		SeatBid: []openrtb.SeatBid{{
+			Seat: "indexExchange",
+			Bid: []openrtb.Bid{
+				{ImpID: "mine", Price: 1.5, AdM: "<div></div>", CrID: "creative", DealID: "deal", W: 300, H: 250, Ext: openrtb.RawJSON(`{"prebid":{"type":"banner"}}`)},
+				{ImpID: "other", Price: 2},
+			},
+		}, {
+			Seat: "appnexus",
+			Bid:  []openrtb.Bid{{ImpID: "mine", Price: 3}},
+		}},
+	}
+	respExt := &openrtb_ext.ExtBidResponse{
+		ResponseTimeMillis: map[openrtb_ext.BidderName]int{"indexExchange": 20},
+	}
+	bidder := &PBSBidder{
+		BidderCode: "indexExchange",
+		AdUnitCode: "mine",
+		AdUnits:    []PBSAdUnit{{Code: "mine", BidID: "bid-id"}},
+	}
+
+	bids := bidder.FromOpenRTB(resp, respExt)
+	if len(bids) != 1 {
+		t.Fatalf("Only the bids for this bidder's Ad Units should be returned. Got %s", mustMarshal(t, bids))
+	}
+	expected := PBSBid{
+		BidID:             "bid-id",
+		AdUnitCode:        "mine",
+		Creative_id:       "creative",
+		CreativeMediaType: "banner",
+		BidderCode:        "indexExchange",
+		Price:             1.5,
+		Adm:               "<div></div>",
+		Width:             300,
+		Height:            250,
+		DealId:            "deal",
+		ResponseTime:      20,
+	}
+	if actual := mustMarshal(t, bids[0]); actual != mustMarshal(t, expected) {
+		t.Errorf("Expected %s. Got %s", mustMarshal(t, expected), actual)
+	}
+	if bidder.NumBids != 1 || bidder.NoBid || bidder.ResponseTime != 20 {
+		t.Errorf("The bidder's status was not updated. Got %#v", bidder)
+	}
+}
+
+func TestFromOpenRTBNoBids(t *testing.T) {
+	bidder := &PBSBidder{BidderCode: "appnexus"}
+	if bids := bidder.FromOpenRTB(&openrtb.BidResponse{}, &openrtb_ext.ExtBidResponse{}); len(bids) != 0 {
+		t.Errorf("Expected no bids. Got %s", mustMarshal(t, bids))
+	}
+	if !bidder.NoBid {
+		t.Error("Bidders without bids or errors should be flagged with NoBid.")
+	}
+
+	bidder = &PBSBidder{BidderCode: "appnexus"}
+	bidder.FromOpenRTB(&openrtb.BidResponse{}, &openrtb_ext.ExtBidResponse{
+		Errors: map[openrtb_ext.BidderName][]openrtb_ext.ExtBidderError{
+			"appnexus": {{Message: "first"}, {Message: "second"}},
+		},
+	})
+	if bidder.Error != "first; second" || bidder.NoBid {
+		t.Errorf("Bidder errors should be joined. Got %q", bidder.Error)
+	}
+}
+
+func mustMarshal(t *testing.T, value interface{}) string {
+	t.Helper()
+	data, err := json.Marshal(value)
+	if err != nil {
+		t.Fatalf("Failed to marshal %v: %v", value, err)
+	}
+	return string(data)
+}
@@ -78,7 +78,7 @@ func TestParseSimpleRequest(t *testing.T) {
 	pbs_req, err := ParsePBSRequest(r, &config.AuctionTimeouts{
 		Default: 2000,
 		Max:     2000,
-	}, d, &hcc)
+	}, d, &hcc, nil)
 	if err != nil {
 		t.Fatalf("Parse simple request failed: %v", err)
 	}
@@ -163,7 +163,7 @@ func TestHeaderParsing(t *testing.T) {
 	pbs_req, err := ParsePBSRequest(r, &config.AuctionTimeouts{
 		Default: 2000,
 		Max:     2000,
-	}, d, &hcc)
+	}, d, &hcc, nil)
 	if err != nil {
 		t.Fatalf("Parse simple request failed")
 	}
@@ -248,7 +248,7 @@ func TestParseConfig(t *testing.T) {
 	pbs_req, err := ParsePBSRequest(r, &config.AuctionTimeouts{
 		Default: 2000,
 		Max:     2000,
-	}, d, &hcc)
+	}, d, &hcc, nil)
 	if err != nil {
 		t.Fatalf("Parse simple request failed: %v", err)
 	}
@@ -332,7 +332,7 @@ func TestParseMobileRequestFirstVersion(t *testing.T) {
 	pbs_req, err := ParsePBSRequest(r, &config.AuctionTimeouts{
 		Default: 2000,
 		Max:     2000,
-	}, d, &hcc)
+	}, d, &hcc, nil)
 	if err != nil {
 		t.Fatalf("Parse simple request failed: %v", err)
 	}
@@ -431,7 +431,7 @@ func TestParseMobileRequest(t *testing.T) {
 	pbs_req, err := ParsePBSRequest(r, &config.AuctionTimeouts{
 		Default: 2000,
 		Max:     2000,
-	}, d, &hcc)
+	}, d, &hcc, nil)
 	if err != nil {
 		t.Fatalf("Parse simple request failed: %v", err)
 	}
@@ -534,7 +534,7 @@ func TestParseMalformedMobileRequest(t *testing.T) {
 	pbs_req, err := ParsePBSRequest(r, &config.AuctionTimeouts{
 		Default: 2000,
 		Max:     2000,
-	}, d, &hcc)
+	}, d, &hcc, nil)
 	if err != nil {
 		t.Fatalf("Parse simple request failed: %v", err)
 	}
@@ -641,7 +641,7 @@ func TestParseRequestWithInstl(t *testing.T) {
 	pbs_req, err := ParsePBSRequest(r, &config.AuctionTimeouts{
 		Default: 2000,
 		Max:     2000,
-	}, d, &hcc)
+	}, d, &hcc, nil)
 	if err != nil {
 		t.Fatalf("Parse simple request failed: %v", err)
 	}
@@ -692,7 +692,7 @@ func doTimeoutTest(t *testing.T, expected int, requested int, max uint64, def ui
 }`, requested)
 	r := httptest.NewRequest("POST", "/auction", strings.NewReader(body))
 	d, _ := dummycache.New()
-	parsed, err := ParsePBSRequest(r, cfg, d, &config.HostCookie{})
+	parsed, err := ParsePBSRequest(r, cfg, d, &config.HostCookie{}, nil)
 	if err != nil {
 		t.Fatalf("Unexpected err: %v", err)
 	}
@@ -740,7 +740,7 @@ func TestParsePBSRequestUsesHostCookie(t *testing.T) {
 	pbs_req, err2 := ParsePBSRequest(r, &config.AuctionTimeouts{
 		Default: 2000,
 		Max:     2000,
-	}, d, &hcc)
+	}, d, &hcc, nil)
 	if err2 != nil {
 		t.Fatalf("Parse simple request failed %v", err2)
 	}
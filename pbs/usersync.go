@@ -42,6 +42,7 @@ type UserSyncDeps struct {
 	HostCookieConfig *config.HostCookie
 	MetricsEngine    pbsmetrics.MetricsEngine
 	PBSAnalytics     analytics.PBSAnalyticsModule
+	UIDStore         *usersync.UIDStoreClient
 }
 
 // pbsCookieJson defines the JSON contract for the cookie data's storage format.
@@ -103,8 +104,9 @@ func (deps *UserSyncDeps) OptOut(w http.ResponseWriter, r *http.Request, _ httpr
 		return
 	}
 
-	pc := usersync.ParsePBSCookieFromRequest(r, deps.HostCookieConfig)
+	pc := deps.UIDStore.ParsePBSCookieFromRequest(r, deps.HostCookieConfig)
 	pc.SetPreference(optout == "")
+	deps.UIDStore.Save(r, deps.HostCookieConfig, pc)
 
 	pc.SetCookieOnResponse(w, deps.HostCookieConfig.Domain, deps.HostCookieConfig.TTLDuration())
 	if optout == "" {
@@ -55,7 +55,9 @@ import (
 	pbc "github.com/prebid/prebid-server/prebid_cache_client"
 	"github.com/prebid/prebid-server/server"
 	"github.com/prebid/prebid-server/ssl"
+	"github.com/prebid/prebid-server/tracing"
 	"github.com/prebid/prebid-server/usersync"
+	uidStoreConf "github.com/prebid/prebid-server/usersync/uidstores/config"
 	"github.com/prebid/prebid-server/usersync/usersyncers"
 
 	storedRequestsConf "github.com/prebid/prebid-server/stored_requests/config"
@@ -122,6 +124,10 @@ type auctionDeps struct {
 	syncers       map[openrtb_ext.BidderName]usersync.Usersyncer
 	gdprPerms     gdpr.Permissions
 	metricsEngine pbsmetrics.MetricsEngine
+	// legacyAuction serves the bidders in cfg.LegacyAuction.OpenRTBBidders through the OpenRTB Exchange.
+	legacyAuction *openrtb2.LegacyAuction
+	// uidStore restores the user syncs which the browser dropped. It's nil unless the host configured one.
+	uidStore *usersync.UIDStoreClient
 }
 
 func (deps *auctionDeps) auction(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
@@ -143,7 +149,7 @@ func (deps *auctionDeps) auction(w http.ResponseWriter, r *http.Request, _ httpr
 		}
 	}
 
-	pbs_req, err := pbs.ParsePBSRequest(r, &deps.cfg.AuctionTimeouts, dataCache, &(deps.cfg.HostCookie))
+	pbs_req, err := pbs.ParsePBSRequest(r, &deps.cfg.AuctionTimeouts, dataCache, &(deps.cfg.HostCookie), deps.uidStore)
 	// Defer here because we need pbs_req defined.
 	defer func() {
 		if pbs_req == nil {
@@ -201,8 +207,10 @@ func (deps *auctionDeps) auction(w http.ResponseWriter, r *http.Request, _ httpr
 
 	ch := make(chan bidResult)
 	sentBids := 0
+	var openrtbBidders []*pbs.PBSBidder
 	for _, bidder := range pbs_req.Bidders {
-		if ex, ok := exchanges[bidder.BidderCode]; ok {
+		useOpenRTB := deps.cfg.LegacyAuction.UsesOpenRTB(bidder.BidderCode)
+		if ex, ok := exchanges[bidder.BidderCode]; ok || useOpenRTB {
 			// Make sure we have an independent label struct for each bidder. We don't want to run into issues with the goroutine below.
 			blabels := pbsmetrics.AdapterLabels{
 				Source:      labels.Source,
@@ -238,11 +246,16 @@ func (deps *auctionDeps) auction(w http.ResponseWriter, r *http.Request, _ httpr
 						bidder.UsersyncInfo = syncer.GetUsersyncInfo(gdprApplies, consent)
 					}
 					blabels.CookieFlag = pbsmetrics.CookieFlagNo
-					if ex.SkipNoCookies() {
+					if !useOpenRTB && ex.SkipNoCookies() {
 						continue
 					}
 				}
 			}
+			if useOpenRTB {
+				// The Exchange records the adapter metrics for these.
+				openrtbBidders = append(openrtbBidders, bidder)
+				continue
+			}
 			sentBids++
 			bidderRunner := recoverSafely(func(bidder *pbs.PBSBidder, blables pbsmetrics.AdapterLabels) {
 				start := time.Now()
@@ -303,6 +316,11 @@ func (deps *auctionDeps) auction(w http.ResponseWriter, r *http.Request, _ httpr
 		}
 	}
 
+	if len(openrtbBidders) > 0 {
+		sentBids += len(openrtbBidders)
+		go deps.runOpenRTBBidders(ctx, pbs_req, openrtbBidders, labels, ch)
+	}
+
 	for i := 0; i < sentBids; i++ {
 		result := <-ch
 
@@ -361,6 +379,45 @@ func (deps *auctionDeps) auction(w http.ResponseWriter, r *http.Request, _ httpr
 	enc.Encode(pbs_resp)
 }
 
+// runOpenRTBBidders gets bids from the Exchange, and sends a bidResult for each bidder.
+// If anything panics, the bidders which haven't gotten a bidResult yet are sent one with an error,
+// so that the auction doesn't wait on them forever.
+func (deps *auctionDeps) runOpenRTBBidders(ctx context.Context, pbs_req *pbs.PBSRequest, bidders []*pbs.PBSBidder, labels pbsmetrics.Labels, ch chan<- bidResult) {
+	start := time.Now()
+	sent := 0
+	defer func() {
+		if r := recover(); r != nil {
+			glog.Errorf("Legacy auction recovered panic from the OpenRTB Exchange: %v. Stack trace is: %v", r, string(debug.Stack()))
+			for _, bidder := range bidders[sent:] {
+				bidder.Error = "Internal error"
+				bidder.ResponseTime = int(time.Since(start) / time.Millisecond)
+				ch <- bidResult{bidder: bidder}
+			}
+		}
+	}()
+
+	bidLists, err := deps.legacyAuction.HoldAuction(ctx, pbs_req, bidders, labels)
+	if err != nil {
+		glog.Warningf("Error from the OpenRTB Exchange. Ignoring all bids from %d bidders: %v", len(bidders), err)
+	}
+	for i, bidder := range bidders {
+		if err != nil {
+			bidder.Error = err.Error()
+			bidder.ResponseTime = int(time.Since(start) / time.Millisecond)
+			ch <- bidResult{bidder: bidder}
+			sent++
+			continue
+		}
+		bid_list := checkForValidBidSize(bidLists[i], bidder)
+		bidder.NumBids = len(bid_list)
+		ch <- bidResult{
+			bidder:   bidder,
+			bid_list: bid_list,
+		}
+		sent++
+	}
+}
+
 func recoverSafely(inner func(*pbs.PBSBidder, pbsmetrics.AdapterLabels)) func(*pbs.PBSBidder, pbsmetrics.AdapterLabels) {
 	return func(bidder *pbs.PBSBidder, labels pbsmetrics.AdapterLabels) {
 		defer func() {
@@ -673,52 +730,81 @@ func serve(revision string, cfg *config.Configuration) error {
 			TLSClientConfig:     &tls.Config{RootCAs: ssl.GetRootCAPool()},
 		},
 	}
-	fetcher, ampFetcher, db, shutdown := storedRequestsConf.NewStoredRequests(&cfg.StoredRequests, theClient, router)
-	defer shutdown()
-
-	if err := loadDataCache(cfg, db); err != nil {
-		return fmt.Errorf("Prebid Server could not load data cache: %v", err)
+	paramsValidator, err := openrtb_ext.NewBidderParamsValidator(schemaDirectory)
+	if err != nil {
+		glog.Fatalf("Failed to create the bidder params validator. %v", err)
 	}
 
-	pbsAnalytics := analyticsConf.NewPBSAnalytics(&cfg.Analytics)
+	storedDataValidator, err := openrtb2.NewStoredDataValidator(paramsValidator, cfg)
+	if err != nil {
+		glog.Fatalf("Failed to create the stored data validator. %v", err)
+	}
 
 	// Hack because of how legacy handles districtm
 	bidderList := openrtb_ext.BidderList()
 	bidderList = append(bidderList, openrtb_ext.BidderName("districtm"))
 
 	metricsEngine := metricsConf.NewMetricsEngine(cfg, bidderList)
+	defer metricsEngine.Shutdown()
 
-	paramsValidator, err := openrtb_ext.NewBidderParamsValidator(schemaDirectory)
-	if err != nil {
-		glog.Fatalf("Failed to create the bidder params validator. %v", err)
+	if tracer := tracing.NewTracerFromConfig(&cfg.Tracing); tracer != nil {
+		tracing.SetTracer(tracer)
+		defer tracer.Close()
+	}
+
+	fetcher, ampFetcher, db, storedRequestsAdmin, shutdown := storedRequestsConf.NewStoredRequests(&cfg.StoredRequests, theClient, router, storedDataValidator, metricsEngine)
+	defer shutdown()
+
+	uidStore, shutdownUIDStore := uidStoreConf.NewUIDStore(&cfg.UIDStore)
+	defer shutdownUIDStore()
+	uidStoreClient := usersync.NewUIDStoreClient(uidStore, cfg.UIDStore.TimeoutDuration())
+
+	if err := loadDataCache(cfg, db); err != nil {
+		return fmt.Errorf("Prebid Server could not load data cache: %v", err)
 	}
 
+	pbsAnalytics, shutdownAnalytics := analyticsConf.NewAsyncPBSAnalytics(&cfg.Analytics, metricsEngine)
+	defer shutdownAnalytics()
+
 	bidderInfos := adapters.ParseBidderInfos("./static/bidder-info", openrtb_ext.BidderList())
 
 	syncers := usersyncers.NewSyncerMap(cfg)
 	gdprPerms := gdpr.NewPermissions(context.Background(), cfg.GDPR, usersyncers.GDPRAwareSyncerIDs(syncers), theClient)
 
 	exchanges = newExchangeMap(cfg)
-	theExchange := exchange.NewExchange(theClient, pbc.NewClient(&cfg.CacheURL), cfg, metricsEngine, bidderInfos, gdprPerms)
+	breakers := exchange.NewCircuitBreakers(cfg.CircuitBreaker, openrtb_ext.BidderList(), metricsEngine)
+	theExchange := exchange.NewExchange(pbc.NewClient(&cfg.CacheURL), cfg, metricsEngine, bidderInfos, gdprPerms, breakers)
 
-	openrtbEndpoint, err := openrtb2.NewEndpoint(theExchange, paramsValidator, fetcher, cfg, metricsEngine, pbsAnalytics)
+	openrtbEndpoint, err := openrtb2.NewEndpoint(theExchange, paramsValidator, fetcher, cfg, metricsEngine, pbsAnalytics, uidStoreClient)
 	if err != nil {
 		glog.Fatalf("Failed to create the openrtb endpoint handler. %v", err)
 	}
 
-	ampEndpoint, err := openrtb2.NewAmpEndpoint(theExchange, paramsValidator, ampFetcher, cfg, metricsEngine, pbsAnalytics)
+	ampEndpoint, err := openrtb2.NewAmpEndpoint(theExchange, paramsValidator, ampFetcher, cfg, metricsEngine, pbsAnalytics, uidStoreClient)
 	if err != nil {
 		glog.Fatalf("Failed to create the amp endpoint handler. %v", err)
 	}
 
-	router.POST("/auction", (&auctionDeps{cfg, syncers, gdprPerms, metricsEngine}).auction)
+	videoEndpoint, err := openrtb2.NewVideoEndpoint(theExchange, paramsValidator, fetcher, cfg, metricsEngine, pbsAnalytics, uidStoreClient)
+	if err != nil {
+		glog.Fatalf("Failed to create the video endpoint handler. %v", err)
+	}
+
+	legacyAuction, err := openrtb2.NewLegacyAuction(theExchange, fetcher, cfg, metricsEngine)
+	if err != nil {
+		glog.Fatalf("Failed to create the legacy auction handler. %v", err)
+	}
+
+	router.POST("/auction", (&auctionDeps{cfg, syncers, gdprPerms, metricsEngine, legacyAuction, uidStoreClient}).auction)
 	router.POST("/openrtb2/auction", openrtbEndpoint)
 	router.GET("/openrtb2/amp", ampEndpoint)
+	router.POST("/openrtb2/video", videoEndpoint)
 	router.GET("/info/bidders", infoEndpoints.NewBiddersEndpoint())
 	router.GET("/info/bidders/:bidderName", infoEndpoints.NewBidderDetailsEndpoint(bidderInfos))
 	router.GET("/bidders/params", NewJsonDirectoryServer(paramsValidator))
-	router.POST("/cookie_sync", endpoints.NewCookieSyncEndpoint(syncers, cfg, gdprPerms, metricsEngine, pbsAnalytics))
+	router.POST("/cookie_sync", endpoints.NewCookieSyncEndpoint(syncers, cfg, gdprPerms, metricsEngine, pbsAnalytics, uidStoreClient))
 	router.GET("/status", endpoints.NewStatusEndpoint(cfg.StatusResponse))
+	router.GET("/event", endpoints.NewEventEndpoint(pbsAnalytics, metricsEngine))
 	router.GET("/", serveIndex)
 	router.ServeFiles("/static/*filepath", http.Dir("static"))
 
@@ -728,9 +814,10 @@ func serve(revision string, cfg *config.Configuration) error {
 		RecaptchaSecret:  cfg.RecaptchaSecret,
 		MetricsEngine:    metricsEngine,
 		PBSAnalytics:     pbsAnalytics,
+		UIDStore:         uidStoreClient,
 	}
 
-	router.GET("/setuid", endpoints.NewSetUIDEndpoint(cfg.HostCookie, gdprPerms, pbsAnalytics, metricsEngine))
+	router.GET("/setuid", endpoints.NewSetUIDEndpoint(cfg.HostCookie, gdprPerms, pbsAnalytics, metricsEngine, uidStoreClient))
 	router.POST("/optout", userSyncDeps.OptOut)
 	router.GET("/optout", userSyncDeps.OptOut)
 
@@ -754,6 +841,10 @@ func serve(revision string, cfg *config.Configuration) error {
 
 	// Register prebid-server defined admin handlers
 	adminRouter.HandleFunc("/version", endpoints.NewVersionEndpoint(revision))
+	adminRouter.HandleFunc("/circuit_breakers", endpoints.NewCircuitBreakersEndpoint(breakers))
+	if storedRequestsAdmin != nil {
+		adminRouter.Handle("/stored_requests/", storedRequestsAdmin)
+	}
 
 	server.Listen(cfg, noCacheHandler, adminRouter, metricsEngine)
 	return nil
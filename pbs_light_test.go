@@ -79,7 +79,7 @@ func TestSortBidsAndAddKeywordsForMobile(t *testing.T) {
 	pbs_req, err := pbs.ParsePBSRequest(r, &config.AuctionTimeouts{
 		Default: 2000,
 		Max:     2000,
-	}, d, &hcc)
+	}, d, &hcc, nil)
 	if err != nil {
 		t.Errorf("Unexpected error on parsing %v", err)
 	}
@@ -361,7 +361,7 @@ func TestCacheVideoOnly(t *testing.T) {
 		HostVendorID: 0,
 	}, nil, nil)
 	prebid_cache_client.InitPrebidCache(server.URL)
-	cacheVideoOnly(bids, ctx, w, &auctionDeps{cfg, syncers, gdprPerms, &metricsConf.DummyMetricsEngine{}}, &pbsmetrics.Labels{})
+	cacheVideoOnly(bids, ctx, w, &auctionDeps{cfg, syncers, gdprPerms, &metricsConf.DummyMetricsEngine{}, nil, nil}, &pbsmetrics.Labels{})
 	if bids[0].CacheID != "UUID-1" {
 		t.Errorf("UUID was '%s', should have been 'UUID-1'", bids[0].CacheID)
 	}
@@ -694,6 +694,23 @@ func TestPanicRecovery(t *testing.T) {
 	recovered(nil, pbsmetrics.AdapterLabels{})
 }
 
+func TestOpenRTBBiddersPanicRecovery(t *testing.T) {
+	deps := &auctionDeps{}
+	bidders := []*pbs.PBSBidder{{BidderCode: "appnexus"}, {BidderCode: "districtm"}}
+	ch := make(chan bidResult, len(bidders))
+	// The PBSRequest is nil, so translating it to OpenRTB panics.
+	deps.runOpenRTBBidders(context.Background(), nil, bidders, pbsmetrics.Labels{}, ch)
+
+	if len(ch) != len(bidders) {
+		t.Fatalf("Expected a bidResult for each of the %d bidders. Got %d", len(bidders), len(ch))
+	}
+	for range bidders {
+		if result := <-ch; result.bidder.Error == "" || len(result.bid_list) != 0 {
+			t.Errorf("Bidder %s should have an error and no bids. Got error %q and %d bids", result.bidder.BidderCode, result.bidder.Error, len(result.bid_list))
+		}
+	}
+}
+
 // Prevents #648
 func TestCORSSupport(t *testing.T) {
 	const origin = "https://publisher-domain.com"
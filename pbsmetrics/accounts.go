@@ -0,0 +1,91 @@
+package pbsmetrics
+
+import (
+	"sync"
+
+	"github.com/prebid/prebid-server/config"
+)
+
+// Accounts which don't get metrics of their own
+const (
+	// AccountOther groups together the accounts which were filtered out by the allow list or first_n.
+	AccountOther = "other"
+	// AccountUnknown is used for requests which didn't include a publisher ID.
+	AccountUnknown = "unknown"
+)
+
+// AccountFilter decides which accounts get their own metrics, so that the number of time series stays bounded.
+// It's safe for concurrent use.
+type AccountFilter struct {
+	enabled        bool
+	adapterMetrics bool
+	allowList      map[string]struct{}
+	firstN         int
+
+	seen      map[string]struct{}
+	seenMutex sync.RWMutex
+}
+
+// NewAccountFilter makes an AccountFilter from the metrics.accounts config.
+func NewAccountFilter(cfg config.AccountMetrics) *AccountFilter {
+	filter := &AccountFilter{
+		enabled:        cfg.Enabled,
+		adapterMetrics: cfg.AdapterMetrics,
+		firstN:         cfg.FirstN,
+		seen:           make(map[string]struct{}),
+	}
+	if len(cfg.AllowList) > 0 {
+		filter.allowList = make(map[string]struct{}, len(cfg.AllowList))
+		for _, account := range cfg.AllowList {
+			filter.allowList[account] = struct{}{}
+		}
+	}
+	return filter
+}
+
+// Account returns the name which the metrics for pubID should be recorded under.
+// It returns false if account metrics are disabled.
+//
+// Without an allow list, accounts keep their own metrics on a first come, first served basis.
+// If first_n isn't positive either, every account gets its own metrics.
+func (f *AccountFilter) Account(pubID string) (string, bool) {
+	if !f.enabled {
+		return "", false
+	}
+	if pubID == "" {
+		return AccountUnknown, true
+	}
+	if f.allowList != nil {
+		if _, ok := f.allowList[pubID]; ok {
+			return pubID, true
+		}
+		return AccountOther, true
+	}
+
+	if f.firstN <= 0 {
+		return pubID, true
+	}
+
+	f.seenMutex.RLock()
+	_, ok := f.seen[pubID]
+	f.seenMutex.RUnlock()
+	if ok {
+		return pubID, true
+	}
+
+	f.seenMutex.Lock()
+	defer f.seenMutex.Unlock()
+	if _, ok := f.seen[pubID]; ok {
+		return pubID, true
+	}
+	if len(f.seen) >= f.firstN {
+		return AccountOther, true
+	}
+	f.seen[pubID] = struct{}{}
+	return pubID, true
+}
+
+// AdapterMetrics returns true if the account metrics should also be broken down by bidder.
+func (f *AccountFilter) AdapterMetrics() bool {
+	return f.enabled && f.adapterMetrics
+}
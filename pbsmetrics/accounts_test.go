@@ -0,0 +1,49 @@
+package pbsmetrics
+
+import (
+	"testing"
+
+	"github.com/prebid/prebid-server/config"
+)
+
+func TestAccountFilterDisabled(t *testing.T) {
+	filter := NewAccountFilter(config.AccountMetrics{AllowList: []string{"pub-1"}, AdapterMetrics: true})
+	if _, ok := filter.Account("pub-1"); ok {
+		t.Error("Account metrics should be disabled")
+	}
+	if filter.AdapterMetrics() {
+		t.Error("Account adapter metrics should be disabled if account metrics are")
+	}
+}
+
+func TestAccountFilterAllowList(t *testing.T) {
+	filter := NewAccountFilter(config.AccountMetrics{Enabled: true, AllowList: []string{"pub-1"}, FirstN: 5})
+	assertAccount(t, filter, "pub-1", "pub-1")
+	assertAccount(t, filter, "pub-2", AccountOther)
+	assertAccount(t, filter, "", AccountUnknown)
+}
+
+func TestAccountFilterFirstN(t *testing.T) {
+	filter := NewAccountFilter(config.AccountMetrics{Enabled: true, FirstN: 2})
+	assertAccount(t, filter, "pub-1", "pub-1")
+	assertAccount(t, filter, "pub-2", "pub-2")
+	assertAccount(t, filter, "pub-3", AccountOther)
+	assertAccount(t, filter, "pub-1", "pub-1")
+}
+
+func TestAccountFilterUnlimited(t *testing.T) {
+	filter := NewAccountFilter(config.AccountMetrics{Enabled: true})
+	assertAccount(t, filter, "pub-1", "pub-1")
+	assertAccount(t, filter, "pub-2", "pub-2")
+}
+
+func assertAccount(t *testing.T, filter *AccountFilter, pubID string, expected string) {
+	t.Helper()
+	account, ok := filter.Account(pubID)
+	if !ok {
+		t.Fatalf("Account metrics should be enabled")
+	}
+	if account != expected {
+		t.Errorf("Bad account for %q. Expected %s, got %s", pubID, expected, account)
+	}
+}
@@ -3,10 +3,12 @@ package config
 import (
 	"time"
 
+	"github.com/golang/glog"
 	"github.com/prebid/prebid-server/config"
 	"github.com/prebid/prebid-server/openrtb_ext"
 	"github.com/prebid/prebid-server/pbsmetrics"
 	"github.com/prebid/prebid-server/pbsmetrics/prometheus"
+	"github.com/prebid/prebid-server/pbsmetrics/statsd"
 	"github.com/rcrowley/go-metrics"
 	"github.com/vrischmann/go-metrics-influxdb"
 )
@@ -15,14 +17,14 @@ import (
 // for this instance.
 func NewMetricsEngine(cfg *config.Configuration, adapterList []openrtb_ext.BidderName) *DetailedMetricsEngine {
 	// Create a list of metrics engines to use.
-	// Capacity of 2, as unlikely to have more than 2 metrics backends, and in the case
+	// Capacity of 3, as there are 3 metrics backends, and in the case
 	// of 1 we won't use the list so it will be garbage collected.
-	engineList := make(MultiMetricsEngine, 0, 2)
+	engineList := make(MultiMetricsEngine, 0, 3)
 	returnEngine := DetailedMetricsEngine{}
 
 	if cfg.Metrics.Influxdb.Host != "" {
 		// Currently use go-metrics as the metrics piece for influx
-		returnEngine.GoMetrics = pbsmetrics.NewMetrics(metrics.NewPrefixedRegistry("prebidserver."), adapterList)
+		returnEngine.GoMetrics = pbsmetrics.NewMetrics(metrics.NewPrefixedRegistry("prebidserver."), adapterList, influxAccountMetrics(&cfg.Metrics))
 		engineList = append(engineList, returnEngine.GoMetrics)
 		// Set up the Influx logger
 		go influxdb.InfluxDB(
@@ -37,9 +39,17 @@ func NewMetricsEngine(cfg *config.Configuration, adapterList []openrtb_ext.Bidde
 	}
 	if cfg.Metrics.Prometheus.Port != 0 {
 		// Set up the Prometheus metrics.
-		returnEngine.PrometheusMetrics = prometheusmetrics.NewMetrics(cfg.Metrics.Prometheus)
+		returnEngine.PrometheusMetrics = prometheusmetrics.NewMetrics(cfg.Metrics.Prometheus, cfg.Metrics.Accounts)
 		engineList = append(engineList, returnEngine.PrometheusMetrics)
 	}
+	if cfg.Metrics.Statsd.Host != "" {
+		statsdMetrics, err := statsdmetrics.NewMetrics(cfg.Metrics.Statsd, cfg.Metrics.Accounts)
+		if err != nil {
+			glog.Fatalf("Failed to set up the StatsD metrics. %v", err)
+		}
+		returnEngine.StatsdMetrics = statsdMetrics
+		engineList = append(engineList, returnEngine.StatsdMetrics)
+	}
 
 	// Now return the proper metrics engine
 	if len(engineList) > 1 {
@@ -53,11 +63,31 @@ func NewMetricsEngine(cfg *config.Configuration, adapterList []openrtb_ext.Bidde
 	return &returnEngine
 }
 
+// influxAccountMetrics returns the account metrics config for the go-metrics engine behind Influx.
+// Influx has always had metrics for every account, so it keeps them until the host opts out, or
+// limits them with metrics.accounts.
+func influxAccountMetrics(cfg *config.Metrics) config.AccountMetrics {
+	if cfg.Accounts.Enabled || !cfg.Influxdb.LegacyAccountMetrics {
+		return cfg.Accounts
+	}
+	return config.AccountMetrics{Enabled: true, AdapterMetrics: true}
+}
+
 // DetailedMetricsEngine is a MultiMetricsEngine that preserves links to unerlying metrics engines.
 type DetailedMetricsEngine struct {
 	pbsmetrics.MetricsEngine
 	GoMetrics         *pbsmetrics.Metrics
 	PrometheusMetrics *prometheusmetrics.Metrics
+	StatsdMetrics     *statsdmetrics.Metrics
+}
+
+// Shutdown sends any metrics which the engines have buffered. It should be called when PBS shuts down.
+func (me *DetailedMetricsEngine) Shutdown() {
+	if me.StatsdMetrics != nil {
+		if err := me.StatsdMetrics.Close(); err != nil {
+			glog.Errorf("Failed to close the StatsD metrics. %v", err)
+		}
+	}
 }
 
 // MultiMetricsEngine logs metrics to multiple metrics databases The can be useful in transitioning
@@ -97,6 +127,20 @@ func (me *MultiMetricsEngine) RecordRequestTime(labels pbsmetrics.Labels, length
 	}
 }
 
+// RecordRequestPhaseTime across all engines
+func (me *MultiMetricsEngine) RecordRequestPhaseTime(labels pbsmetrics.Labels, phase pbsmetrics.RequestPhase, length time.Duration) {
+	for _, thisME := range *me {
+		thisME.RecordRequestPhaseTime(labels, phase, length)
+	}
+}
+
+// RecordTMaxExceeded across all engines
+func (me *MultiMetricsEngine) RecordTMaxExceeded(labels pbsmetrics.Labels) {
+	for _, thisME := range *me {
+		thisME.RecordTMaxExceeded(labels)
+	}
+}
+
 // RecordAdapterRequest across all engines
 func (me *MultiMetricsEngine) RecordAdapterRequest(labels pbsmetrics.AdapterLabels) {
 	for _, thisME := range *me {
@@ -125,6 +169,20 @@ func (me *MultiMetricsEngine) RecordAdapterTime(labels pbsmetrics.AdapterLabels,
 	}
 }
 
+// RecordAdapterNonBid across all engines
+func (me *MultiMetricsEngine) RecordAdapterNonBid(adapter openrtb_ext.BidderName, reason openrtb_ext.NonBidReason) {
+	for _, thisME := range *me {
+		thisME.RecordAdapterNonBid(adapter, reason)
+	}
+}
+
+// RecordAdapterCircuitBreakerState across all engines
+func (me *MultiMetricsEngine) RecordAdapterCircuitBreakerState(adapter openrtb_ext.BidderName, state pbsmetrics.CircuitBreakerState) {
+	for _, thisME := range *me {
+		thisME.RecordAdapterCircuitBreakerState(adapter, state)
+	}
+}
+
 // RecordCookieSync across all engines
 func (me *MultiMetricsEngine) RecordCookieSync(labels pbsmetrics.Labels) {
 	for _, thisME := range *me {
@@ -139,6 +197,62 @@ func (me *MultiMetricsEngine) RecordUserIDSet(userLabels pbsmetrics.UserLabels)
 	}
 }
 
+// RecordStoredDataCacheResult across all engines
+func (me *MultiMetricsEngine) RecordStoredDataCacheResult(dataType pbsmetrics.StoredDataType, hits int, misses int) {
+	for _, thisME := range *me {
+		thisME.RecordStoredDataCacheResult(dataType, hits, misses)
+	}
+}
+
+// RecordStoredDataFetchTime across all engines
+func (me *MultiMetricsEngine) RecordStoredDataFetchTime(fetcherType pbsmetrics.StoredDataFetcherType, length time.Duration) {
+	for _, thisME := range *me {
+		thisME.RecordStoredDataFetchTime(fetcherType, length)
+	}
+}
+
+// RecordStoredDataError across all engines
+func (me *MultiMetricsEngine) RecordStoredDataError(labels pbsmetrics.StoredDataLabels) {
+	for _, thisME := range *me {
+		thisME.RecordStoredDataError(labels)
+	}
+}
+
+// RecordStoredDataEvent across all engines
+func (me *MultiMetricsEngine) RecordStoredDataEvent(source pbsmetrics.StoredDataEventSource, eventType pbsmetrics.StoredDataEventType) {
+	for _, thisME := range *me {
+		thisME.RecordStoredDataEvent(source, eventType)
+	}
+}
+
+// RecordStoredDataPollRows across all engines
+func (me *MultiMetricsEngine) RecordStoredDataPollRows(dataType pbsmetrics.StoredDataType, eventType pbsmetrics.StoredDataEventType, rows int) {
+	for _, thisME := range *me {
+		thisME.RecordStoredDataPollRows(dataType, eventType, rows)
+	}
+}
+
+// RecordAnalyticsEventDropped across all engines
+func (me *MultiMetricsEngine) RecordAnalyticsEventDropped(module pbsmetrics.AnalyticsModule, eventType pbsmetrics.AnalyticsEventType) {
+	for _, thisME := range *me {
+		thisME.RecordAnalyticsEventDropped(module, eventType)
+	}
+}
+
+// RecordAnalyticsEventFailed across all engines
+func (me *MultiMetricsEngine) RecordAnalyticsEventFailed(module pbsmetrics.AnalyticsModule, eventType pbsmetrics.AnalyticsEventType) {
+	for _, thisME := range *me {
+		thisME.RecordAnalyticsEventFailed(module, eventType)
+	}
+}
+
+// RecordEvent across all engines
+func (me *MultiMetricsEngine) RecordEvent(eventType pbsmetrics.EventType) {
+	for _, thisME := range *me {
+		thisME.RecordEvent(eventType)
+	}
+}
+
 // DummyMetricsEngine is a Noop metrics engine in case no metrics are configured. (may also be useful for tests)
 type DummyMetricsEngine struct{}
 
@@ -167,6 +281,16 @@ func (me *DummyMetricsEngine) RecordRequestTime(labels pbsmetrics.Labels, length
 	return
 }
 
+// RecordRequestPhaseTime as a noop
+func (me *DummyMetricsEngine) RecordRequestPhaseTime(labels pbsmetrics.Labels, phase pbsmetrics.RequestPhase, length time.Duration) {
+	return
+}
+
+// RecordTMaxExceeded as a noop
+func (me *DummyMetricsEngine) RecordTMaxExceeded(labels pbsmetrics.Labels) {
+	return
+}
+
 // RecordAdapterRequest as a noop
 func (me *DummyMetricsEngine) RecordAdapterRequest(labels pbsmetrics.AdapterLabels) {
 	return
@@ -187,6 +311,16 @@ func (me *DummyMetricsEngine) RecordAdapterTime(labels pbsmetrics.AdapterLabels,
 	return
 }
 
+// RecordAdapterNonBid as a noop
+func (me *DummyMetricsEngine) RecordAdapterNonBid(adapter openrtb_ext.BidderName, reason openrtb_ext.NonBidReason) {
+	return
+}
+
+// RecordAdapterCircuitBreakerState as a noop
+func (me *DummyMetricsEngine) RecordAdapterCircuitBreakerState(adapter openrtb_ext.BidderName, state pbsmetrics.CircuitBreakerState) {
+	return
+}
+
 // RecordCookieSync as a noop
 func (me *DummyMetricsEngine) RecordCookieSync(labels pbsmetrics.Labels) {
 	return
@@ -196,3 +330,43 @@ func (me *DummyMetricsEngine) RecordCookieSync(labels pbsmetrics.Labels) {
 func (me *DummyMetricsEngine) RecordUserIDSet(userLabels pbsmetrics.UserLabels) {
 	return
 }
+
+// RecordStoredDataCacheResult as a noop
+func (me *DummyMetricsEngine) RecordStoredDataCacheResult(dataType pbsmetrics.StoredDataType, hits int, misses int) {
+	return
+}
+
+// RecordStoredDataFetchTime as a noop
+func (me *DummyMetricsEngine) RecordStoredDataFetchTime(fetcherType pbsmetrics.StoredDataFetcherType, length time.Duration) {
+	return
+}
+
+// RecordStoredDataError as a noop
+func (me *DummyMetricsEngine) RecordStoredDataError(labels pbsmetrics.StoredDataLabels) {
+	return
+}
+
+// RecordStoredDataEvent as a noop
+func (me *DummyMetricsEngine) RecordStoredDataEvent(source pbsmetrics.StoredDataEventSource, eventType pbsmetrics.StoredDataEventType) {
+	return
+}
+
+// RecordStoredDataPollRows as a noop
+func (me *DummyMetricsEngine) RecordStoredDataPollRows(dataType pbsmetrics.StoredDataType, eventType pbsmetrics.StoredDataEventType, rows int) {
+	return
+}
+
+// RecordAnalyticsEventDropped as a noop
+func (me *DummyMetricsEngine) RecordAnalyticsEventDropped(module pbsmetrics.AnalyticsModule, eventType pbsmetrics.AnalyticsEventType) {
+	return
+}
+
+// RecordAnalyticsEventFailed as a noop
+func (me *DummyMetricsEngine) RecordAnalyticsEventFailed(module pbsmetrics.AnalyticsModule, eventType pbsmetrics.AnalyticsEventType) {
+	return
+}
+
+// RecordEvent as a noop
+func (me *DummyMetricsEngine) RecordEvent(eventType pbsmetrics.EventType) {
+	return
+}
@@ -32,12 +32,47 @@ func TestGoMetricsEngine(t *testing.T) {
 	}
 }
 
+// TestGoMetricsLegacyAccounts makes sure that Influx keeps its metrics for every account until the host opts out.
+func TestGoMetricsLegacyAccounts(t *testing.T) {
+	cfg := mainConfig.Configuration{}
+	cfg.Metrics.Influxdb.Host = "localhost"
+	cfg.Metrics.Influxdb.LegacyAccountMetrics = true
+	testEngine := NewMetricsEngine(&cfg, []openrtb_ext.BidderName{openrtb_ext.BidderAppnexus})
+	testEngine.RecordRequest(pbsmetrics.Labels{RType: pbsmetrics.ReqTypeORTB2Web, RequestStatus: pbsmetrics.RequestStatusOK, PubID: "pub-1"})
+	if testEngine.GoMetrics.MetricsRegistry.Get("account.pub-1.requests") == nil {
+		t.Error("Influx should get metrics for every account by default")
+	}
+
+	cfg.Metrics.Influxdb.LegacyAccountMetrics = false
+	testEngine = NewMetricsEngine(&cfg, []openrtb_ext.BidderName{openrtb_ext.BidderAppnexus})
+	testEngine.RecordRequest(pbsmetrics.Labels{RType: pbsmetrics.ReqTypeORTB2Web, RequestStatus: pbsmetrics.RequestStatusOK, PubID: "pub-1"})
+	if testEngine.GoMetrics.MetricsRegistry.Get("account.pub-1.requests") != nil {
+		t.Error("Influx shouldn't get account metrics once the host opts out")
+	}
+}
+
+func TestStatsdMetricsEngine(t *testing.T) {
+	cfg := mainConfig.Configuration{}
+	cfg.Metrics.Statsd = mainConfig.StatsdMetrics{
+		Host:          "localhost:8125",
+		SampleRate:    1,
+		BufferSize:    1432,
+		FlushInterval: 1000,
+	}
+	adapterList := make([]openrtb_ext.BidderName, 0, 2)
+	testEngine := NewMetricsEngine(&cfg, adapterList)
+	defer testEngine.Shutdown()
+	if testEngine.StatsdMetrics == nil || testEngine.MetricsEngine != testEngine.StatsdMetrics {
+		t.Error("Expected a StatsD Metrics as MetricsEngine, but didn't get it")
+	}
+}
+
 // Test the multiengine
 func TestMultiMetricsEngine(t *testing.T) {
 	cfg := mainConfig.Configuration{}
 	cfg.Metrics.Influxdb.Host = "localhost"
 	adapterList := openrtb_ext.BidderList()
-	goEngine := pbsmetrics.NewMetrics(metrics.NewPrefixedRegistry("prebidserver."), adapterList)
+	goEngine := pbsmetrics.NewMetrics(metrics.NewPrefixedRegistry("prebidserver."), adapterList, mainConfig.AccountMetrics{})
 	engineList := make(MultiMetricsEngine, 2)
 	engineList[0] = goEngine
 	engineList[1] = &DummyMetricsEngine{}
@@ -78,6 +113,11 @@ func TestMultiMetricsEngine(t *testing.T) {
 		metricsEngine.RecordAdapterPrice(pubLabels, 1.34)
 		metricsEngine.RecordAdapterBidReceived(pubLabels, openrtb_ext.BidTypeBanner, true)
 		metricsEngine.RecordAdapterTime(pubLabels, time.Millisecond*20)
+		metricsEngine.RecordAdapterNonBid(openrtb_ext.BidderPubmatic, openrtb_ext.NonBidInvalidCurrency)
+		metricsEngine.RecordStoredDataCacheResult(pbsmetrics.StoredDataTypeImp, 2, 1)
+		metricsEngine.RecordStoredDataEvent(pbsmetrics.StoredDataEventSourceHTTP, pbsmetrics.StoredDataEventInvalidate)
+		metricsEngine.RecordStoredDataPollRows(pbsmetrics.StoredDataTypeRequest, pbsmetrics.StoredDataEventSave, 2)
+		metricsEngine.RecordEvent(pbsmetrics.EventWin)
 	}
 	VerifyMetrics(t, "RequestStatuses.OpenRTB2.OK", goEngine.RequestStatuses[pbsmetrics.ReqTypeORTB2Web][pbsmetrics.RequestStatusOK].Count(), 5)
 	VerifyMetrics(t, "RequestStatuses.Legacy.OK", goEngine.RequestStatuses[pbsmetrics.ReqTypeLegacy][pbsmetrics.RequestStatusOK].Count(), 0)
@@ -94,8 +134,14 @@ func TestMultiMetricsEngine(t *testing.T) {
 	for _, err := range pbsmetrics.AdapterErrors() {
 		VerifyMetrics(t, "AdapterMetrics.Pubmatic.Request.ErrorMeter."+string(err), goEngine.AdapterMetrics[openrtb_ext.BidderPubmatic].ErrorMeters[err].Count(), 0)
 	}
+	VerifyMetrics(t, "AdapterMetrics.Pubmatic.NonBidMeters.InvalidCurrency", goEngine.AdapterMetrics[openrtb_ext.BidderPubmatic].NonBidMeters[openrtb_ext.NonBidInvalidCurrency].Count(), 5)
 	VerifyMetrics(t, "AdapterMetrics.AppNexus.GotBidsMeter", goEngine.AdapterMetrics[openrtb_ext.BidderAppnexus].GotBidsMeter.Count(), 0)
 	VerifyMetrics(t, "AdapterMetrics.AppNexus.NoBidMeter", goEngine.AdapterMetrics[openrtb_ext.BidderAppnexus].NoBidMeter.Count(), 5)
+	VerifyMetrics(t, "StoredDataMetrics.Imp.CacheHitMeter", goEngine.StoredDataMetrics[pbsmetrics.StoredDataTypeImp].CacheHitMeter.Count(), 10)
+	VerifyMetrics(t, "StoredDataMetrics.Imp.CacheMissMeter", goEngine.StoredDataMetrics[pbsmetrics.StoredDataTypeImp].CacheMissMeter.Count(), 5)
+	VerifyMetrics(t, "StoredDataEventMeters.HTTP.Invalidate", goEngine.StoredDataEventMeters[pbsmetrics.StoredDataEventSourceHTTP][pbsmetrics.StoredDataEventInvalidate].Count(), 5)
+	VerifyMetrics(t, "StoredDataMetrics.Request.PollRows.Save", goEngine.StoredDataMetrics[pbsmetrics.StoredDataTypeRequest].PollRows[pbsmetrics.StoredDataEventSave].Sum(), 10)
+	VerifyMetrics(t, "EventMeters.Win", goEngine.EventMeters[pbsmetrics.EventWin].Count(), 5)
 }
 
 func VerifyMetrics(t *testing.T, name string, expected int64, actual int64) {
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/golang/glog"
+	"github.com/prebid/prebid-server/config"
 	"github.com/prebid/prebid-server/openrtb_ext"
 	"github.com/rcrowley/go-metrics"
 )
@@ -22,6 +23,9 @@ type Metrics struct {
 	SafariRequestMeter         metrics.Meter
 	SafariNoCookieMeter        metrics.Meter
 	RequestTimer               metrics.Timer
+	// Metrics for how long each phase of an auction takes, and how many auctions run past their timeout.
+	RequestPhaseTimers map[RequestPhase]metrics.Timer
+	TMaxExceededMeters map[RequestType]metrics.Meter
 	// Metrics for OpenRTB requests specifically. So we can track what % of RequestsMeter are OpenRTB
 	// and know when legacy requests have been abandoned.
 	RequestStatuses     map[RequestType]map[RequestStatus]metrics.Meter
@@ -33,8 +37,21 @@ type Metrics struct {
 	userSyncGDPRPrevent map[openrtb_ext.BidderName]metrics.Meter
 
 	AdapterMetrics map[openrtb_ext.BidderName]*AdapterMetrics
+	// The state of each adapter's circuit breaker. See CircuitBreakerState.Value() for what the numbers mean.
+	CircuitBreakerGauges map[openrtb_ext.BidderName]metrics.Gauge
+	// Metrics for Stored Requests and Stored Imps.
+	StoredDataMetrics     map[StoredDataType]*StoredDataMetrics
+	StoredDataFetchTimers map[StoredDataFetcherType]metrics.Timer
+	StoredDataEventMeters map[StoredDataEventSource]map[StoredDataEventType]metrics.Meter
+	// Metrics for analytics events which were dropped before reaching their module.
+	AnalyticsDropMeters map[AnalyticsModule]map[AnalyticsEventType]metrics.Meter
+	// Metrics for analytics events which their module failed to deliver.
+	AnalyticsFailMeters map[AnalyticsModule]map[AnalyticsEventType]metrics.Meter
+	// Metrics for the win and impression notifications sent to /event.
+	EventMeters map[EventType]metrics.Meter
 	// Don't export accountMetrics because we need helper functions here to insure its properly populated dynamically
 	accountMetrics        map[string]*accountMetrics
+	accountFilter         *AccountFilter
 	accountMetricsRWMutex sync.RWMutex
 	userSyncRwMutex       sync.RWMutex
 
@@ -51,6 +68,15 @@ type AdapterMetrics struct {
 	PriceHistogram    metrics.Histogram
 	BidsReceivedMeter metrics.Meter
 	MarkupMetrics     map[openrtb_ext.BidType]*MarkupDeliveryMetrics
+	NonBidMeters      map[openrtb_ext.NonBidReason]metrics.Meter
+}
+
+// StoredDataMetrics houses the cache and backend metrics for either Stored Requests or Stored Imps
+type StoredDataMetrics struct {
+	CacheHitMeter  metrics.Meter
+	CacheMissMeter metrics.Meter
+	ErrorMeters    map[StoredDataFetcherType]map[StoredDataError]metrics.Meter
+	PollRows       map[StoredDataEventType]metrics.Histogram
 }
 
 type MarkupDeliveryMetrics struct {
@@ -61,8 +87,10 @@ type MarkupDeliveryMetrics struct {
 type accountMetrics struct {
 	requestMeter      metrics.Meter
 	bidsReceivedMeter metrics.Meter
+	timeoutMeter      metrics.Meter
 	priceHistogram    metrics.Histogram
 	// store account by adapter metrics. Type is map[PBSBidder.BidderCode]
+	// This is empty unless metrics.accounts.adapter_metrics is enabled.
 	adapterMetrics map[openrtb_ext.BidderName]*AdapterMetrics
 }
 
@@ -90,6 +118,8 @@ func NewBlankMetrics(registry metrics.Registry, exchanges []openrtb_ext.BidderNa
 		SafariRequestMeter:         blankMeter,
 		SafariNoCookieMeter:        blankMeter,
 		RequestTimer:               &metrics.NilTimer{},
+		RequestPhaseTimers:         make(map[RequestPhase]metrics.Timer),
+		TMaxExceededMeters:         make(map[RequestType]metrics.Meter),
 		AmpNoCookieMeter:           blankMeter,
 		CookieSyncMeter:            blankMeter,
 		userSyncOptout:             blankMeter,
@@ -97,13 +127,22 @@ func NewBlankMetrics(registry metrics.Registry, exchanges []openrtb_ext.BidderNa
 		userSyncSet:                make(map[openrtb_ext.BidderName]metrics.Meter),
 		userSyncGDPRPrevent:        make(map[openrtb_ext.BidderName]metrics.Meter),
 
-		AdapterMetrics: make(map[openrtb_ext.BidderName]*AdapterMetrics, len(exchanges)),
-		accountMetrics: make(map[string]*accountMetrics),
+		AdapterMetrics:        make(map[openrtb_ext.BidderName]*AdapterMetrics, len(exchanges)),
+		CircuitBreakerGauges:  make(map[openrtb_ext.BidderName]metrics.Gauge, len(exchanges)),
+		StoredDataMetrics:     make(map[StoredDataType]*StoredDataMetrics),
+		StoredDataFetchTimers: make(map[StoredDataFetcherType]metrics.Timer),
+		StoredDataEventMeters: make(map[StoredDataEventSource]map[StoredDataEventType]metrics.Meter),
+		AnalyticsDropMeters:   make(map[AnalyticsModule]map[AnalyticsEventType]metrics.Meter),
+		AnalyticsFailMeters:   make(map[AnalyticsModule]map[AnalyticsEventType]metrics.Meter),
+		EventMeters:           make(map[EventType]metrics.Meter),
+		accountMetrics:        make(map[string]*accountMetrics),
+		accountFilter:         NewAccountFilter(config.AccountMetrics{}),
 
 		exchanges: exchanges,
 	}
 	for _, a := range exchanges {
 		newMetrics.AdapterMetrics[a] = makeBlankAdapterMetrics()
+		newMetrics.CircuitBreakerGauges[a] = metrics.NilGauge{}
 	}
 
 	for _, t := range RequestTypes() {
@@ -111,6 +150,34 @@ func NewBlankMetrics(registry metrics.Registry, exchanges []openrtb_ext.BidderNa
 		for _, s := range RequestStatuses() {
 			newMetrics.RequestStatuses[t][s] = blankMeter
 		}
+		newMetrics.TMaxExceededMeters[t] = blankMeter
+	}
+	for _, p := range RequestPhases() {
+		newMetrics.RequestPhaseTimers[p] = &metrics.NilTimer{}
+	}
+
+	for _, t := range StoredDataTypes() {
+		newMetrics.StoredDataMetrics[t] = makeBlankStoredDataMetrics()
+	}
+	for _, f := range StoredDataFetcherTypes() {
+		newMetrics.StoredDataFetchTimers[f] = &metrics.NilTimer{}
+	}
+	for _, src := range StoredDataEventSources() {
+		newMetrics.StoredDataEventMeters[src] = make(map[StoredDataEventType]metrics.Meter)
+		for _, e := range StoredDataEventTypes() {
+			newMetrics.StoredDataEventMeters[src][e] = blankMeter
+		}
+	}
+	for _, module := range AnalyticsModules() {
+		newMetrics.AnalyticsDropMeters[module] = make(map[AnalyticsEventType]metrics.Meter)
+		newMetrics.AnalyticsFailMeters[module] = make(map[AnalyticsEventType]metrics.Meter)
+		for _, e := range AnalyticsEventTypes() {
+			newMetrics.AnalyticsDropMeters[module][e] = blankMeter
+			newMetrics.AnalyticsFailMeters[module][e] = blankMeter
+		}
+	}
+	for _, e := range EventTypes() {
+		newMetrics.EventMeters[e] = blankMeter
 	}
 
 	return newMetrics
@@ -121,8 +188,11 @@ func NewBlankMetrics(registry metrics.Registry, exchanges []openrtb_ext.BidderNa
 // metrics object to contain only the metrics we are interested in. This would allow for debug
 // mode metrics. The code would allways try to record the metrics, but effectively noop if we are
 // using a blank meter/timer.
-func NewMetrics(registry metrics.Registry, exchanges []openrtb_ext.BidderName) *Metrics {
+//
+// Account metrics are registered as each account is seen, if the accounts config enables them.
+func NewMetrics(registry metrics.Registry, exchanges []openrtb_ext.BidderName, accounts config.AccountMetrics) *Metrics {
 	newMetrics := NewBlankMetrics(registry, exchanges)
+	newMetrics.accountFilter = NewAccountFilter(accounts)
 	newMetrics.ConnectionCounter = metrics.GetOrRegisterCounter("active_connections", registry)
 	newMetrics.ConnectionAcceptErrorMeter = metrics.GetOrRegisterMeter("connection_accept_errors", registry)
 	newMetrics.ConnectionCloseErrorMeter = metrics.GetOrRegisterMeter("connection_close_errors", registry)
@@ -132,6 +202,12 @@ func NewMetrics(registry metrics.Registry, exchanges []openrtb_ext.BidderName) *
 	newMetrics.AppRequestMeter = metrics.GetOrRegisterMeter("app_requests", registry)
 	newMetrics.SafariNoCookieMeter = metrics.GetOrRegisterMeter("safari_no_cookie_requests", registry)
 	newMetrics.RequestTimer = metrics.GetOrRegisterTimer("request_time", registry)
+	for p := range newMetrics.RequestPhaseTimers {
+		newMetrics.RequestPhaseTimers[p] = metrics.GetOrRegisterTimer(fmt.Sprintf("request_phase.%s.time", p), registry)
+	}
+	for t := range newMetrics.TMaxExceededMeters {
+		newMetrics.TMaxExceededMeters[t] = metrics.GetOrRegisterMeter(fmt.Sprintf("requests.tmax_exceeded.%s", t), registry)
+	}
 	newMetrics.AmpNoCookieMeter = metrics.GetOrRegisterMeter("amp_no_cookie_requests", registry)
 	newMetrics.CookieSyncMeter = metrics.GetOrRegisterMeter("cookie_sync_requests", registry)
 	newMetrics.userSyncBadRequest = metrics.GetOrRegisterMeter("usersync.bad_requests", registry)
@@ -140,6 +216,7 @@ func NewMetrics(registry metrics.Registry, exchanges []openrtb_ext.BidderName) *
 		newMetrics.userSyncSet[a] = metrics.GetOrRegisterMeter(fmt.Sprintf("usersync.%s.sets", string(a)), registry)
 		newMetrics.userSyncGDPRPrevent[a] = metrics.GetOrRegisterMeter(fmt.Sprintf("usersync.%s.gdpr_prevent", string(a)), registry)
 		registerAdapterMetrics(registry, "adapter", string(a), newMetrics.AdapterMetrics[a])
+		newMetrics.CircuitBreakerGauges[a] = metrics.GetOrRegisterGauge(fmt.Sprintf("adapter.%s.circuit_breaker_state", string(a)), registry)
 	}
 	for typ, statusMap := range newMetrics.RequestStatuses {
 		for stat := range statusMap {
@@ -148,9 +225,67 @@ func NewMetrics(registry metrics.Registry, exchanges []openrtb_ext.BidderName) *
 	}
 	newMetrics.userSyncSet[unknownBidder] = metrics.GetOrRegisterMeter("usersync.unknown.sets", registry)
 	newMetrics.userSyncGDPRPrevent[unknownBidder] = metrics.GetOrRegisterMeter("usersync.unknown.gdpr_prevent", registry)
+	for dataType, sdm := range newMetrics.StoredDataMetrics {
+		registerStoredDataMetrics(registry, dataType, sdm)
+	}
+	for fetcherType := range newMetrics.StoredDataFetchTimers {
+		newMetrics.StoredDataFetchTimers[fetcherType] = metrics.GetOrRegisterTimer(fmt.Sprintf("stored_data.%s.fetch_time", fetcherType), registry)
+	}
+	for src, eventMap := range newMetrics.StoredDataEventMeters {
+		for e := range eventMap {
+			eventMap[e] = metrics.GetOrRegisterMeter(fmt.Sprintf("stored_data.events.%s.%s", src, e), registry)
+		}
+	}
+	for module, eventMap := range newMetrics.AnalyticsDropMeters {
+		for e := range eventMap {
+			eventMap[e] = metrics.GetOrRegisterMeter(fmt.Sprintf("analytics.%s.%s.dropped", module, e), registry)
+		}
+	}
+	for module, eventMap := range newMetrics.AnalyticsFailMeters {
+		for e := range eventMap {
+			eventMap[e] = metrics.GetOrRegisterMeter(fmt.Sprintf("analytics.%s.%s.failed", module, e), registry)
+		}
+	}
+	for e := range newMetrics.EventMeters {
+		newMetrics.EventMeters[e] = metrics.GetOrRegisterMeter(fmt.Sprintf("events.%s", e), registry)
+	}
 	return newMetrics
 }
 
+// Part of setting up blank metrics, the Stored Request and Stored Imp metrics.
+func makeBlankStoredDataMetrics() *StoredDataMetrics {
+	blankMeter := &metrics.NilMeter{}
+	sdm := &StoredDataMetrics{
+		CacheHitMeter:  blankMeter,
+		CacheMissMeter: blankMeter,
+		ErrorMeters:    make(map[StoredDataFetcherType]map[StoredDataError]metrics.Meter),
+		PollRows:       make(map[StoredDataEventType]metrics.Histogram),
+	}
+	for _, e := range StoredDataEventTypes() {
+		sdm.PollRows[e] = &metrics.NilHistogram{}
+	}
+	for _, f := range StoredDataFetcherTypes() {
+		sdm.ErrorMeters[f] = make(map[StoredDataError]metrics.Meter)
+		for _, err := range StoredDataErrors() {
+			sdm.ErrorMeters[f][err] = blankMeter
+		}
+	}
+	return sdm
+}
+
+func registerStoredDataMetrics(registry metrics.Registry, dataType StoredDataType, sdm *StoredDataMetrics) {
+	sdm.CacheHitMeter = metrics.GetOrRegisterMeter(fmt.Sprintf("stored_%s.cache.hits", dataType), registry)
+	sdm.CacheMissMeter = metrics.GetOrRegisterMeter(fmt.Sprintf("stored_%s.cache.misses", dataType), registry)
+	for fetcherType, errMap := range sdm.ErrorMeters {
+		for err := range errMap {
+			errMap[err] = metrics.GetOrRegisterMeter(fmt.Sprintf("stored_%s.%s.errors.%s", dataType, fetcherType, err), registry)
+		}
+	}
+	for eventType := range sdm.PollRows {
+		sdm.PollRows[eventType] = metrics.GetOrRegisterHistogram(fmt.Sprintf("stored_%s.poll_rows.%s", dataType, eventType), registry, metrics.NewExpDecaySample(1028, 0.015))
+	}
+}
+
 // Part of setting up blank metrics, the adapter metrics.
 func makeBlankAdapterMetrics() *AdapterMetrics {
 	blankMeter := &metrics.NilMeter{}
@@ -163,10 +298,14 @@ func makeBlankAdapterMetrics() *AdapterMetrics {
 		PriceHistogram:    &metrics.NilHistogram{},
 		BidsReceivedMeter: blankMeter,
 		MarkupMetrics:     makeBlankBidMarkupMetrics(),
+		NonBidMeters:      make(map[openrtb_ext.NonBidReason]metrics.Meter),
 	}
 	for _, err := range AdapterErrors() {
 		newAdapter.ErrorMeters[err] = blankMeter
 	}
+	for _, reason := range openrtb_ext.NonBidReasons() {
+		newAdapter.NonBidMeters[reason] = blankMeter
+	}
 	return newAdapter
 }
 
@@ -203,6 +342,11 @@ func registerAdapterMetrics(registry metrics.Registry, adapterOrAccount string,
 	}
 	if adapterOrAccount != "adapter" {
 		am.BidsReceivedMeter = metrics.GetOrRegisterMeter(fmt.Sprintf("%[1]s.%[2]s.bids_received", adapterOrAccount, exchange), registry)
+	} else {
+		// Non-bids aren't broken down by account, since there would be one meter per account, adapter and reason.
+		for reason := range am.NonBidMeters {
+			am.NonBidMeters[reason] = metrics.GetOrRegisterMeter(fmt.Sprintf("%s.%s.nonbids.%s", adapterOrAccount, exchange, reason), registry)
+		}
 	}
 }
 
@@ -213,12 +357,18 @@ func makeDeliveryMetrics(registry metrics.Registry, prefix string, bidType openr
 	}
 }
 
-// getAccountMetrics gets or registers the account metrics for account "id".
+// getAccountMetrics gets or registers the account metrics for the account with publisher ID "pubID".
 // There is no getBlankAccountMetrics() as all metrics are generated dynamically.
-func (me *Metrics) getAccountMetrics(id string) *accountMetrics {
+// This returns nil if account metrics are disabled.
+func (me *Metrics) getAccountMetrics(pubID string) *accountMetrics {
 	var am *accountMetrics
 	var ok bool
 
+	id, enabled := me.accountFilter.Account(pubID)
+	if !enabled {
+		return nil
+	}
+
 	me.accountMetricsRWMutex.RLock()
 	am, ok = me.accountMetrics[id]
 	me.accountMetricsRWMutex.RUnlock()
@@ -239,11 +389,14 @@ func (me *Metrics) getAccountMetrics(id string) *accountMetrics {
 	am = &accountMetrics{}
 	am.requestMeter = metrics.GetOrRegisterMeter(fmt.Sprintf("account.%s.requests", id), me.MetricsRegistry)
 	am.bidsReceivedMeter = metrics.GetOrRegisterMeter(fmt.Sprintf("account.%s.bids_received", id), me.MetricsRegistry)
+	am.timeoutMeter = metrics.GetOrRegisterMeter(fmt.Sprintf("account.%s.timeouts", id), me.MetricsRegistry)
 	am.priceHistogram = metrics.GetOrRegisterHistogram(fmt.Sprintf("account.%s.prices", id), me.MetricsRegistry, metrics.NewExpDecaySample(1028, 0.015))
 	am.adapterMetrics = make(map[openrtb_ext.BidderName]*AdapterMetrics, len(me.exchanges))
-	for _, a := range me.exchanges {
-		am.adapterMetrics[a] = makeBlankAdapterMetrics()
-		registerAdapterMetrics(me.MetricsRegistry, fmt.Sprintf("account.%s", id), string(a), am.adapterMetrics[a])
+	if me.accountFilter.AdapterMetrics() {
+		for _, a := range me.exchanges {
+			am.adapterMetrics[a] = makeBlankAdapterMetrics()
+			registerAdapterMetrics(me.MetricsRegistry, fmt.Sprintf("account.%s", id), string(a), am.adapterMetrics[a])
+		}
 	}
 
 	me.accountMetrics[id] = am
@@ -251,6 +404,20 @@ func (me *Metrics) getAccountMetrics(id string) *accountMetrics {
 	return am
 }
 
+// adapter gets the metrics for one adapter within this account. If they aren't being recorded,
+// this returns blank metrics so that the caller doesn't need to check.
+func (am *accountMetrics) adapter(adapter openrtb_ext.BidderName) *AdapterMetrics {
+	if am != nil {
+		if aam, ok := am.adapterMetrics[adapter]; ok {
+			return aam
+		}
+	}
+	return blankAccountAdapterMetrics
+}
+
+// blankAccountAdapterMetrics are shared by every account and adapter whose metrics aren't being recorded.
+var blankAccountAdapterMetrics = makeBlankAdapterMetrics()
+
 // Implement the MetricsEngine interface
 
 // RecordRequest implements a part of the MetricsEngine interface
@@ -274,8 +441,9 @@ func (me *Metrics) RecordRequest(labels Labels) {
 	}
 
 	// Handle the account metrics now.
-	am := me.getAccountMetrics(labels.PubID)
-	am.requestMeter.Mark(1)
+	if am := me.getAccountMetrics(labels.PubID); am != nil {
+		am.requestMeter.Mark(1)
+	}
 }
 
 func (me *Metrics) RecordImps(labels Labels, numImps int) {
@@ -307,6 +475,24 @@ func (me *Metrics) RecordRequestTime(labels Labels, length time.Duration) {
 	}
 }
 
+// RecordRequestPhaseTime implements a part of the MetricsEngine interface. Records how long one phase of an auction took.
+func (me *Metrics) RecordRequestPhaseTime(labels Labels, phase RequestPhase, length time.Duration) {
+	if timer, ok := me.RequestPhaseTimers[phase]; ok {
+		timer.Update(length)
+	} else {
+		glog.Errorf("Request phase metrics map entry does not exist for %s. This is a bug, and should be reported.", phase)
+	}
+}
+
+// RecordTMaxExceeded implements a part of the MetricsEngine interface. Counts the auctions which ran past their timeout.
+func (me *Metrics) RecordTMaxExceeded(labels Labels) {
+	if meter, ok := me.TMaxExceededMeters[labels.RType]; ok {
+		meter.Mark(1)
+	} else {
+		glog.Errorf("Timeout metrics map entry does not exist for %s. This is a bug, and should be reported.", labels.RType)
+	}
+}
+
 // RecordAdapterRequest implements a part of the MetricsEngine interface
 func (me *Metrics) RecordAdapterRequest(labels AdapterLabels) {
 	am, ok := me.AdapterMetrics[labels.Adapter]
@@ -315,7 +501,8 @@ func (me *Metrics) RecordAdapterRequest(labels AdapterLabels) {
 		return
 	}
 
-	aam := me.getAccountMetrics(labels.PubID).adapterMetrics[labels.Adapter]
+	acct := me.getAccountMetrics(labels.PubID)
+	aam := acct.adapter(labels.Adapter)
 	switch labels.AdapterBids {
 	case AdapterBidNone:
 		am.NoBidMeter.Mark(1)
@@ -328,6 +515,10 @@ func (me *Metrics) RecordAdapterRequest(labels AdapterLabels) {
 	}
 	for errType := range labels.AdapterErrors {
 		am.ErrorMeters[errType].Mark(1)
+		aam.ErrorMeters[errType].Mark(1)
+	}
+	if _, ok := labels.AdapterErrors[AdapterErrorTimeout]; ok && acct != nil {
+		acct.timeoutMeter.Mark(1)
 	}
 
 	if labels.CookieFlag == CookieFlagNo {
@@ -346,9 +537,12 @@ func (me *Metrics) RecordAdapterBidReceived(labels AdapterLabels, bidType openrt
 
 	// Adapter metrics
 	am.BidsReceivedMeter.Mark(1)
-	// Account-Adapter metrics
-	aam := me.getAccountMetrics(labels.PubID).adapterMetrics[labels.Adapter]
-	aam.BidsReceivedMeter.Mark(1)
+	// Account and Account-Adapter metrics
+	acct := me.getAccountMetrics(labels.PubID)
+	if acct != nil {
+		acct.bidsReceivedMeter.Mark(1)
+	}
+	acct.adapter(labels.Adapter).BidsReceivedMeter.Mark(1)
 
 	if metricsForType, ok := am.MarkupMetrics[bidType]; ok {
 		if hasAdm {
@@ -371,9 +565,12 @@ func (me *Metrics) RecordAdapterPrice(labels AdapterLabels, cpm float64) {
 	}
 	// Adapter metrics
 	am.PriceHistogram.Update(int64(cpm))
-	// Account-Adapter metrics
-	aam := me.getAccountMetrics(labels.PubID).adapterMetrics[labels.Adapter]
-	aam.PriceHistogram.Update(int64(cpm))
+	// Account and Account-Adapter metrics
+	acct := me.getAccountMetrics(labels.PubID)
+	if acct != nil {
+		acct.priceHistogram.Update(int64(cpm))
+	}
+	acct.adapter(labels.Adapter).PriceHistogram.Update(int64(cpm))
 }
 
 // RecordAdapterTime implements a part of the MetricsEngine interface. Records the adapter response time
@@ -386,8 +583,7 @@ func (me *Metrics) RecordAdapterTime(labels AdapterLabels, length time.Duration)
 	// Adapter metrics
 	am.RequestTimer.Update(length)
 	// Account-Adapter metrics
-	aam := me.getAccountMetrics(labels.PubID).adapterMetrics[labels.Adapter]
-	aam.RequestTimer.Update(length)
+	me.getAccountMetrics(labels.PubID).adapter(labels.Adapter).RequestTimer.Update(length)
 }
 
 // RecordCookieSync implements a part of the MetricsEngine interface. Records a cookie sync request
@@ -417,3 +613,113 @@ func doMark(bidder openrtb_ext.BidderName, meters map[openrtb_ext.BidderName]met
 		meters[unknownBidder].Mark(1)
 	}
 }
+
+// RecordStoredDataCacheResult implements a part of the MetricsEngine interface. Counts the IDs which were found
+// in the Stored Request caches, and the ones which had to be fetched from a backend.
+func (me *Metrics) RecordStoredDataCacheResult(dataType StoredDataType, hits int, misses int) {
+	sdm, ok := me.StoredDataMetrics[dataType]
+	if !ok {
+		glog.Errorf("Trying to run stored data cache metrics on %s: stored data metrics not found", string(dataType))
+		return
+	}
+	sdm.CacheHitMeter.Mark(int64(hits))
+	sdm.CacheMissMeter.Mark(int64(misses))
+}
+
+// RecordStoredDataFetchTime implements a part of the MetricsEngine interface. Records how long a Stored Request backend took.
+func (me *Metrics) RecordStoredDataFetchTime(fetcherType StoredDataFetcherType, length time.Duration) {
+	timer, ok := me.StoredDataFetchTimers[fetcherType]
+	if !ok {
+		glog.Errorf("Trying to run stored data latency metrics on %s: stored data metrics not found", string(fetcherType))
+		return
+	}
+	timer.Update(length)
+}
+
+// RecordStoredDataError implements a part of the MetricsEngine interface. Records an error from a Stored Request backend.
+func (me *Metrics) RecordStoredDataError(labels StoredDataLabels) {
+	sdm, ok := me.StoredDataMetrics[labels.DataType]
+	if !ok {
+		glog.Errorf("Trying to run stored data error metrics on %s: stored data metrics not found", string(labels.DataType))
+		return
+	}
+	if meter, ok := sdm.ErrorMeters[labels.FetcherType][labels.Error]; ok {
+		meter.Mark(1)
+	} else {
+		glog.Errorf("stored data error metrics map entry does not exist for %s %s. This is a bug, and should be reported.", labels.FetcherType, labels.Error)
+	}
+}
+
+// RecordStoredDataEvent implements a part of the MetricsEngine interface. Counts the cache updates from each EventProducer.
+func (me *Metrics) RecordStoredDataEvent(source StoredDataEventSource, eventType StoredDataEventType) {
+	if meter, ok := me.StoredDataEventMeters[source][eventType]; ok {
+		meter.Mark(1)
+	} else {
+		glog.Errorf("stored data event metrics map entry does not exist for %s %s. This is a bug, and should be reported.", source, eventType)
+	}
+}
+
+// RecordStoredDataPollRows implements a part of the MetricsEngine interface. Records the number of rows from each poll of a backend.
+func (me *Metrics) RecordStoredDataPollRows(dataType StoredDataType, eventType StoredDataEventType, rows int) {
+	sdm, ok := me.StoredDataMetrics[dataType]
+	if !ok {
+		glog.Errorf("Trying to run stored data poll metrics on %s: stored data metrics not found", string(dataType))
+		return
+	}
+	if histogram, ok := sdm.PollRows[eventType]; ok {
+		histogram.Update(int64(rows))
+	} else {
+		glog.Errorf("stored data poll metrics map entry does not exist for %s %s. This is a bug, and should be reported.", dataType, eventType)
+	}
+}
+
+// RecordAdapterCircuitBreakerState implements a part of the MetricsEngine interface. Records the state of an adapter's circuit breaker.
+func (me *Metrics) RecordAdapterCircuitBreakerState(adapter openrtb_ext.BidderName, state CircuitBreakerState) {
+	if gauge, ok := me.CircuitBreakerGauges[adapter]; ok {
+		gauge.Update(state.Value())
+	} else {
+		glog.Errorf("Trying to run adapter circuit breaker metrics on %s: adapter metrics not found", string(adapter))
+	}
+}
+
+// RecordAdapterNonBid implements a part of the MetricsEngine interface. Records imps which an adapter didn't get to
+// bid on, and bids of theirs which were rejected.
+func (me *Metrics) RecordAdapterNonBid(adapter openrtb_ext.BidderName, reason openrtb_ext.NonBidReason) {
+	am, ok := me.AdapterMetrics[adapter]
+	if !ok {
+		glog.Errorf("Trying to run adapter non-bid metrics on %s: adapter metrics not found", string(adapter))
+		return
+	}
+	if meter, ok := am.NonBidMeters[reason]; ok {
+		meter.Mark(1)
+	} else {
+		glog.Errorf("Non-bid metrics map entry does not exist for reason %d. This is a bug, and should be reported.", reason)
+	}
+}
+
+// RecordAnalyticsEventDropped implements a part of the MetricsEngine interface. Records analytics events which were dropped.
+func (me *Metrics) RecordAnalyticsEventDropped(module AnalyticsModule, eventType AnalyticsEventType) {
+	if meter, ok := me.AnalyticsDropMeters[module][eventType]; ok {
+		meter.Mark(1)
+	} else {
+		glog.Errorf("Analytics drop metrics map entry does not exist for %s %s. This is a bug, and should be reported.", module, eventType)
+	}
+}
+
+// RecordAnalyticsEventFailed implements a part of the MetricsEngine interface. Records analytics events which weren't delivered.
+func (me *Metrics) RecordAnalyticsEventFailed(module AnalyticsModule, eventType AnalyticsEventType) {
+	if meter, ok := me.AnalyticsFailMeters[module][eventType]; ok {
+		meter.Mark(1)
+	} else {
+		glog.Errorf("Analytics failure metrics map entry does not exist for %s %s. This is a bug, and should be reported.", module, eventType)
+	}
+}
+
+// RecordEvent implements a part of the MetricsEngine interface. Records the notifications sent to /event.
+func (me *Metrics) RecordEvent(eventType EventType) {
+	if meter, ok := me.EventMeters[eventType]; ok {
+		meter.Mark(1)
+	} else {
+		glog.Errorf("Event metrics map entry does not exist for %s. This is a bug, and should be reported.", eventType)
+	}
+}
@@ -2,14 +2,16 @@ package pbsmetrics
 
 import (
 	"testing"
+	"time"
 
+	"github.com/prebid/prebid-server/config"
 	"github.com/prebid/prebid-server/openrtb_ext"
 	"github.com/rcrowley/go-metrics"
 )
 
 func TestNewMetrics(t *testing.T) {
 	registry := metrics.NewRegistry()
-	m := NewMetrics(registry, []openrtb_ext.BidderName{openrtb_ext.BidderAppnexus, openrtb_ext.BidderRubicon})
+	m := NewMetrics(registry, []openrtb_ext.BidderName{openrtb_ext.BidderAppnexus, openrtb_ext.BidderRubicon}, config.AccountMetrics{})
 
 	ensureContains(t, registry, "app_requests", m.AppRequestMeter)
 	ensureContains(t, registry, "no_cookie_requests", m.NoCookieMeter)
@@ -39,11 +41,15 @@ func TestNewMetrics(t *testing.T) {
 	ensureContains(t, registry, "requests.badinput.amp", m.RequestStatuses[ReqTypeAMP][RequestStatusBadInput])
 	ensureContains(t, registry, "requests.err.amp", m.RequestStatuses[ReqTypeAMP][RequestStatusErr])
 	ensureContains(t, registry, "requests.networkerr.amp", m.RequestStatuses[ReqTypeAMP][RequestStatusNetworkErr])
+	ensureContains(t, registry, "requests.ok.video", m.RequestStatuses[ReqTypeVideo][RequestStatusOK])
+	ensureContains(t, registry, "requests.badinput.video", m.RequestStatuses[ReqTypeVideo][RequestStatusBadInput])
+	ensureContains(t, registry, "requests.err.video", m.RequestStatuses[ReqTypeVideo][RequestStatusErr])
+	ensureContains(t, registry, "requests.networkerr.video", m.RequestStatuses[ReqTypeVideo][RequestStatusNetworkErr])
 }
 
 func TestRecordBidType(t *testing.T) {
 	registry := metrics.NewRegistry()
-	m := NewMetrics(registry, []openrtb_ext.BidderName{openrtb_ext.BidderAppnexus})
+	m := NewMetrics(registry, []openrtb_ext.BidderName{openrtb_ext.BidderAppnexus}, config.AccountMetrics{})
 
 	m.RecordAdapterBidReceived(AdapterLabels{
 		Adapter: openrtb_ext.BidderAppnexus,
@@ -60,7 +66,7 @@ func TestRecordBidType(t *testing.T) {
 
 func TestRecordGDPRRejection(t *testing.T) {
 	registry := metrics.NewRegistry()
-	m := NewMetrics(registry, []openrtb_ext.BidderName{openrtb_ext.BidderAppnexus})
+	m := NewMetrics(registry, []openrtb_ext.BidderName{openrtb_ext.BidderAppnexus}, config.AccountMetrics{})
 	m.RecordUserIDSet(UserLabels{
 		Action: RequestActionGDPR,
 		Bidder: openrtb_ext.BidderAppnexus,
@@ -68,6 +74,181 @@ func TestRecordGDPRRejection(t *testing.T) {
 	VerifyMetrics(t, "GDPR sync rejects", m.userSyncGDPRPrevent[openrtb_ext.BidderAppnexus].Count(), 1)
 }
 
+func TestRecordStoredData(t *testing.T) {
+	registry := metrics.NewRegistry()
+	m := NewMetrics(registry, []openrtb_ext.BidderName{openrtb_ext.BidderAppnexus}, config.AccountMetrics{})
+
+	ensureContains(t, registry, "stored_request.cache.hits", m.StoredDataMetrics[StoredDataTypeRequest].CacheHitMeter)
+	ensureContains(t, registry, "stored_imp.cache.misses", m.StoredDataMetrics[StoredDataTypeImp].CacheMissMeter)
+	ensureContains(t, registry, "stored_imp.http.errors.miss", m.StoredDataMetrics[StoredDataTypeImp].ErrorMeters[StoredDataFetcherHTTP][StoredDataErrorMiss])
+	ensureContains(t, registry, "stored_data.file.fetch_time", m.StoredDataFetchTimers[StoredDataFetcherFile])
+	ensureContains(t, registry, "stored_data.events.postgres.invalidate", m.StoredDataEventMeters[StoredDataEventSourcePostgres][StoredDataEventInvalidate])
+	ensureContains(t, registry, "stored_imp.poll_rows.invalidate", m.StoredDataMetrics[StoredDataTypeImp].PollRows[StoredDataEventInvalidate])
+
+	m.RecordStoredDataCacheResult(StoredDataTypeRequest, 2, 1)
+	m.RecordStoredDataFetchTime(StoredDataFetcherPostgres, 5*time.Millisecond)
+	m.RecordStoredDataError(StoredDataLabels{
+		DataType:    StoredDataTypeImp,
+		FetcherType: StoredDataFetcherPostgres,
+		Error:       StoredDataErrorMiss,
+	})
+	m.RecordStoredDataEvent(StoredDataEventSourceAPI, StoredDataEventSave)
+	m.RecordStoredDataPollRows(StoredDataTypeImp, StoredDataEventInvalidate, 3)
+
+	VerifyMetrics(t, "Stored Request cache hits", m.StoredDataMetrics[StoredDataTypeRequest].CacheHitMeter.Count(), 2)
+	VerifyMetrics(t, "Stored Request cache misses", m.StoredDataMetrics[StoredDataTypeRequest].CacheMissMeter.Count(), 1)
+	VerifyMetrics(t, "Stored Imp cache hits", m.StoredDataMetrics[StoredDataTypeImp].CacheHitMeter.Count(), 0)
+	VerifyMetrics(t, "Postgres fetches", m.StoredDataFetchTimers[StoredDataFetcherPostgres].Count(), 1)
+	VerifyMetrics(t, "Stored Imp Postgres miss", m.StoredDataMetrics[StoredDataTypeImp].ErrorMeters[StoredDataFetcherPostgres][StoredDataErrorMiss].Count(), 1)
+	VerifyMetrics(t, "Stored Request Postgres miss", m.StoredDataMetrics[StoredDataTypeRequest].ErrorMeters[StoredDataFetcherPostgres][StoredDataErrorMiss].Count(), 0)
+	VerifyMetrics(t, "API saves", m.StoredDataEventMeters[StoredDataEventSourceAPI][StoredDataEventSave].Count(), 1)
+	VerifyMetrics(t, "Stored Imp polled invalidations", m.StoredDataMetrics[StoredDataTypeImp].PollRows[StoredDataEventInvalidate].Sum(), 3)
+	VerifyMetrics(t, "Stored Request polled saves", m.StoredDataMetrics[StoredDataTypeRequest].PollRows[StoredDataEventSave].Count(), 0)
+}
+
+func TestRecordAnalyticsEventDropped(t *testing.T) {
+	registry := metrics.NewRegistry()
+	m := NewMetrics(registry, []openrtb_ext.BidderName{openrtb_ext.BidderAppnexus}, config.AccountMetrics{})
+
+	ensureContains(t, registry, "analytics.stream.auction.dropped", m.AnalyticsDropMeters[AnalyticsModuleStream][AnalyticsEventAuction])
+
+	m.RecordAnalyticsEventDropped(AnalyticsModuleFile, AnalyticsEventSetUID)
+	m.RecordAnalyticsEventDropped(AnalyticsModuleFile, AnalyticsEventSetUID)
+
+	VerifyMetrics(t, "file setuid drops", m.AnalyticsDropMeters[AnalyticsModuleFile][AnalyticsEventSetUID].Count(), 2)
+	VerifyMetrics(t, "file auction drops", m.AnalyticsDropMeters[AnalyticsModuleFile][AnalyticsEventAuction].Count(), 0)
+}
+
+func TestRecordAnalyticsEventFailed(t *testing.T) {
+	registry := metrics.NewRegistry()
+	m := NewMetrics(registry, []openrtb_ext.BidderName{openrtb_ext.BidderAppnexus}, config.AccountMetrics{})
+
+	ensureContains(t, registry, "analytics.stream.auction.failed", m.AnalyticsFailMeters[AnalyticsModuleStream][AnalyticsEventAuction])
+
+	m.RecordAnalyticsEventFailed(AnalyticsModuleStream, AnalyticsEventAmp)
+
+	VerifyMetrics(t, "stream amp failures", m.AnalyticsFailMeters[AnalyticsModuleStream][AnalyticsEventAmp].Count(), 1)
+	VerifyMetrics(t, "stream auction failures", m.AnalyticsFailMeters[AnalyticsModuleStream][AnalyticsEventAuction].Count(), 0)
+}
+
+func TestRecordAdapterNonBid(t *testing.T) {
+	registry := metrics.NewRegistry()
+	m := NewMetrics(registry, []openrtb_ext.BidderName{openrtb_ext.BidderAppnexus, openrtb_ext.BidderRubicon}, config.AccountMetrics{})
+
+	ensureContains(t, registry, "adapter.appnexus.nonbids.invalid_currency", m.AdapterMetrics[openrtb_ext.BidderAppnexus].NonBidMeters[openrtb_ext.NonBidInvalidCurrency])
+	ensureContains(t, registry, "adapter.rubicon.nonbids.unsupported_media_type", m.AdapterMetrics[openrtb_ext.BidderRubicon].NonBidMeters[openrtb_ext.NonBidUnsupportedMediaType])
+
+	m.RecordAdapterNonBid(openrtb_ext.BidderAppnexus, openrtb_ext.NonBidBlockedAdvertiser)
+	m.RecordAdapterNonBid(openrtb_ext.BidderAppnexus, openrtb_ext.NonBidBlockedAdvertiser)
+	m.RecordAdapterNonBid(openrtb_ext.BidderRubicon, openrtb_ext.NonBidInvalidBid)
+
+	VerifyMetrics(t, "appnexus blocked advertiser", m.AdapterMetrics[openrtb_ext.BidderAppnexus].NonBidMeters[openrtb_ext.NonBidBlockedAdvertiser].Count(), 2)
+	VerifyMetrics(t, "appnexus invalid bid", m.AdapterMetrics[openrtb_ext.BidderAppnexus].NonBidMeters[openrtb_ext.NonBidInvalidBid].Count(), 0)
+	VerifyMetrics(t, "rubicon invalid bid", m.AdapterMetrics[openrtb_ext.BidderRubicon].NonBidMeters[openrtb_ext.NonBidInvalidBid].Count(), 1)
+}
+
+func TestRecordEvent(t *testing.T) {
+	registry := metrics.NewRegistry()
+	m := NewMetrics(registry, []openrtb_ext.BidderName{openrtb_ext.BidderAppnexus}, config.AccountMetrics{})
+
+	ensureContains(t, registry, "events.win", m.EventMeters[EventWin])
+	ensureContains(t, registry, "events.imp", m.EventMeters[EventImp])
+
+	m.RecordEvent(EventWin)
+	m.RecordEvent(EventWin)
+	m.RecordEvent(EventImp)
+
+	VerifyMetrics(t, "win events", m.EventMeters[EventWin].Count(), 2)
+	VerifyMetrics(t, "imp events", m.EventMeters[EventImp].Count(), 1)
+}
+
+func TestRecordRequestPhaseTime(t *testing.T) {
+	registry := metrics.NewRegistry()
+	m := NewMetrics(registry, []openrtb_ext.BidderName{openrtb_ext.BidderAppnexus}, config.AccountMetrics{})
+
+	m.RecordRequestPhaseTime(Labels{RType: ReqTypeAMP}, PhaseStoredRequests, 20*time.Millisecond)
+	m.RecordRequestPhaseTime(Labels{RType: ReqTypeORTB2Web}, PhaseBidders, 150*time.Millisecond)
+	m.RecordRequestPhaseTime(Labels{RType: ReqTypeORTB2Web}, PhaseBidders, 250*time.Millisecond)
+
+	for _, phase := range RequestPhases() {
+		ensureContains(t, registry, "request_phase."+string(phase)+".time", m.RequestPhaseTimers[phase])
+	}
+	VerifyMetrics(t, "stored_requests phase", m.RequestPhaseTimers[PhaseStoredRequests].Count(), 1)
+	VerifyMetrics(t, "bidders phase", m.RequestPhaseTimers[PhaseBidders].Count(), 2)
+	VerifyMetrics(t, "bidders phase max", m.RequestPhaseTimers[PhaseBidders].Max(), int64(250*time.Millisecond))
+	VerifyMetrics(t, "cache phase", m.RequestPhaseTimers[PhaseCache].Count(), 0)
+}
+
+func TestRecordTMaxExceeded(t *testing.T) {
+	registry := metrics.NewRegistry()
+	m := NewMetrics(registry, []openrtb_ext.BidderName{openrtb_ext.BidderAppnexus}, config.AccountMetrics{})
+
+	m.RecordTMaxExceeded(Labels{RType: ReqTypeAMP})
+	m.RecordTMaxExceeded(Labels{RType: ReqTypeAMP})
+	m.RecordTMaxExceeded(Labels{RType: ReqTypeORTB2App})
+
+	ensureContains(t, registry, "requests.tmax_exceeded.amp", m.TMaxExceededMeters[ReqTypeAMP])
+	VerifyMetrics(t, "amp tmax exceeded", m.TMaxExceededMeters[ReqTypeAMP].Count(), 2)
+	VerifyMetrics(t, "app tmax exceeded", m.TMaxExceededMeters[ReqTypeORTB2App].Count(), 1)
+	VerifyMetrics(t, "web tmax exceeded", m.TMaxExceededMeters[ReqTypeORTB2Web].Count(), 0)
+}
+
+func TestRecordAdapterCircuitBreakerState(t *testing.T) {
+	registry := metrics.NewRegistry()
+	m := NewMetrics(registry, []openrtb_ext.BidderName{openrtb_ext.BidderAppnexus}, config.AccountMetrics{})
+
+	m.RecordAdapterCircuitBreakerState(openrtb_ext.BidderAppnexus, CircuitBreakerOpen)
+	ensureContains(t, registry, "adapter.appnexus.circuit_breaker_state", m.CircuitBreakerGauges[openrtb_ext.BidderAppnexus])
+	VerifyMetrics(t, "appnexus circuit breaker open", m.CircuitBreakerGauges[openrtb_ext.BidderAppnexus].Value(), 2)
+
+	m.RecordAdapterCircuitBreakerState(openrtb_ext.BidderAppnexus, CircuitBreakerHalfOpen)
+	VerifyMetrics(t, "appnexus circuit breaker half-open", m.CircuitBreakerGauges[openrtb_ext.BidderAppnexus].Value(), 1)
+
+	// Unknown adapters shouldn't panic.
+	m.RecordAdapterCircuitBreakerState(openrtb_ext.BidderName("unknown"), CircuitBreakerOpen)
+}
+
+func TestAccountMetrics(t *testing.T) {
+	registry := metrics.NewRegistry()
+	m := NewMetrics(registry, []openrtb_ext.BidderName{openrtb_ext.BidderAppnexus}, config.AccountMetrics{
+		Enabled:        true,
+		FirstN:         1,
+		AdapterMetrics: true,
+	})
+
+	m.RecordRequest(Labels{RType: ReqTypeORTB2Web, RequestStatus: RequestStatusOK, PubID: "pub-1"})
+	m.RecordRequest(Labels{RType: ReqTypeORTB2Web, RequestStatus: RequestStatusOK, PubID: "pub-2"})
+	m.RecordAdapterRequest(AdapterLabels{
+		Adapter:       openrtb_ext.BidderAppnexus,
+		PubID:         "pub-1",
+		AdapterBids:   AdapterBidNone,
+		AdapterErrors: map[AdapterError]struct{}{AdapterErrorTimeout: {}},
+	})
+	m.RecordAdapterBidReceived(AdapterLabels{Adapter: openrtb_ext.BidderAppnexus, PubID: "pub-1"}, openrtb_ext.BidTypeBanner, true)
+
+	ensureContainsAdapterMetrics(t, registry, "account.pub-1.appnexus", m.accountMetrics["pub-1"].adapterMetrics[openrtb_ext.BidderAppnexus])
+	if registry.Get("account.pub-2.requests") != nil {
+		t.Error("pub-2 should be past the first_n limit")
+	}
+	VerifyMetrics(t, "pub-1 requests", m.accountMetrics["pub-1"].requestMeter.Count(), 1)
+	VerifyMetrics(t, "other requests", m.accountMetrics[AccountOther].requestMeter.Count(), 1)
+	VerifyMetrics(t, "pub-1 timeouts", m.accountMetrics["pub-1"].timeoutMeter.Count(), 1)
+	VerifyMetrics(t, "pub-1 bids", m.accountMetrics["pub-1"].bidsReceivedMeter.Count(), 1)
+	VerifyMetrics(t, "pub-1 appnexus timeouts", m.accountMetrics["pub-1"].adapterMetrics[openrtb_ext.BidderAppnexus].ErrorMeters[AdapterErrorTimeout].Count(), 1)
+}
+
+func TestAccountMetricsDisabled(t *testing.T) {
+	registry := metrics.NewRegistry()
+	m := NewMetrics(registry, []openrtb_ext.BidderName{openrtb_ext.BidderAppnexus}, config.AccountMetrics{})
+
+	m.RecordRequest(Labels{RType: ReqTypeORTB2Web, RequestStatus: RequestStatusOK, PubID: "pub-1"})
+	m.RecordAdapterPrice(AdapterLabels{Adapter: openrtb_ext.BidderAppnexus, PubID: "pub-1"}, 1.5)
+
+	if len(m.accountMetrics) != 0 || registry.Get("account.pub-1.requests") != nil {
+		t.Error("No account metrics should be registered unless they're enabled")
+	}
+}
+
 func ensureContains(t *testing.T, registry metrics.Registry, name string, metric interface{}) {
 	t.Helper()
 	if inRegistry := registry.Get(name); inRegistry == nil {
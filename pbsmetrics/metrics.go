@@ -72,6 +72,7 @@ const (
 	ReqTypeORTB2Web RequestType = "openrtb2-web"
 	ReqTypeORTB2App RequestType = "openrtb2-app"
 	ReqTypeAMP      RequestType = "amp"
+	ReqTypeVideo    RequestType = "video"
 )
 
 func RequestTypes() []RequestType {
@@ -80,6 +81,7 @@ func RequestTypes() []RequestType {
 		ReqTypeORTB2Web,
 		ReqTypeORTB2App,
 		ReqTypeAMP,
+		ReqTypeVideo,
 	}
 }
 
@@ -160,6 +162,67 @@ func AdapterErrors() []AdapterError {
 	}
 }
 
+// CircuitBreakerState : The state of a bidder's circuit breaker
+type CircuitBreakerState string
+
+// Circuit breaker states
+const (
+	// CircuitBreakerClosed means the bidder is being called as usual.
+	CircuitBreakerClosed CircuitBreakerState = "closed"
+	// CircuitBreakerOpen means the bidder is being skipped, because too many of its requests failed.
+	CircuitBreakerOpen CircuitBreakerState = "open"
+	// CircuitBreakerHalfOpen means a few probe requests are being sent, to see if the bidder has recovered.
+	CircuitBreakerHalfOpen CircuitBreakerState = "half_open"
+)
+
+func CircuitBreakerStates() []CircuitBreakerState {
+	return []CircuitBreakerState{
+		CircuitBreakerClosed,
+		CircuitBreakerOpen,
+		CircuitBreakerHalfOpen,
+	}
+}
+
+// Value turns the state into a number, for metrics systems whose gauges can't hold strings.
+// Closed is 0, half-open is 1 and open is 2.
+func (state CircuitBreakerState) Value() int64 {
+	switch state {
+	case CircuitBreakerHalfOpen:
+		return 1
+	case CircuitBreakerOpen:
+		return 2
+	default:
+		return 0
+	}
+}
+
+// RequestPhase : A part of the work done to handle an auction request
+type RequestPhase string
+
+// Request phases
+const (
+	// PhaseStoredRequests is the time spent fetching Stored Requests and Stored Imps.
+	PhaseStoredRequests RequestPhase = "stored_requests"
+	// PhaseParse is the time spent reading, parsing and validating the request, apart from the Stored Request fetch.
+	PhaseParse RequestPhase = "parse"
+	// PhaseBidders is the time spent waiting on the bidders.
+	PhaseBidders RequestPhase = "bidders"
+	// PhaseCache is the time spent saving bids to Prebid Cache.
+	PhaseCache RequestPhase = "cache"
+	// PhaseResponse is the time spent building and writing the response.
+	PhaseResponse RequestPhase = "response"
+)
+
+func RequestPhases() []RequestPhase {
+	return []RequestPhase{
+		PhaseStoredRequests,
+		PhaseParse,
+		PhaseBidders,
+		PhaseCache,
+		PhaseResponse,
+	}
+}
+
 // UserLabels : Labels for /setuid endpoint
 type UserLabels struct {
 	Action RequestAction
@@ -177,6 +240,156 @@ const (
 	RequestActionErr    RequestAction = "err"
 )
 
+// StoredDataLabels : Labels for Stored Request and Stored Imp fetches
+type StoredDataLabels struct {
+	DataType    StoredDataType
+	FetcherType StoredDataFetcherType
+	Error       StoredDataError
+}
+
+// StoredDataType : Whether the data is a Stored Request or a Stored Imp
+type StoredDataType string
+
+// StoredDataFetcherType : The backend which Stored data is fetched from
+type StoredDataFetcherType string
+
+// StoredDataError : Errors which may occur while fetching Stored data
+type StoredDataError string
+
+// StoredDataEventSource : The EventProducer which updated the Stored data caches
+type StoredDataEventSource string
+
+// StoredDataEventType : The kind of update which an EventProducer sent
+type StoredDataEventType string
+
+// Stored data types
+const (
+	StoredDataTypeRequest StoredDataType = "request"
+	StoredDataTypeImp     StoredDataType = "imp"
+)
+
+func StoredDataTypes() []StoredDataType {
+	return []StoredDataType{
+		StoredDataTypeRequest,
+		StoredDataTypeImp,
+	}
+}
+
+// Stored data backends
+const (
+	StoredDataFetcherFile     StoredDataFetcherType = "file"
+	StoredDataFetcherPostgres StoredDataFetcherType = "postgres"
+	StoredDataFetcherHTTP     StoredDataFetcherType = "http"
+)
+
+func StoredDataFetcherTypes() []StoredDataFetcherType {
+	return []StoredDataFetcherType{
+		StoredDataFetcherFile,
+		StoredDataFetcherPostgres,
+		StoredDataFetcherHTTP,
+	}
+}
+
+// Stored data fetch errors. Each backend is measured separately, so a miss only means that one backend
+// didn't have the data. Another backend may still have found it.
+const (
+	StoredDataErrorMiss    StoredDataError = "miss"
+	StoredDataErrorUnknown StoredDataError = "unknown_error"
+)
+
+func StoredDataErrors() []StoredDataError {
+	return []StoredDataError{
+		StoredDataErrorMiss,
+		StoredDataErrorUnknown,
+	}
+}
+
+// Stored data event sources
+const (
+	StoredDataEventSourceAPI      StoredDataEventSource = "api"
+	StoredDataEventSourceAdmin    StoredDataEventSource = "admin"
+	StoredDataEventSourceHTTP     StoredDataEventSource = "http"
+	StoredDataEventSourcePostgres StoredDataEventSource = "postgres"
+	StoredDataEventSourceRedis    StoredDataEventSource = "redis"
+)
+
+func StoredDataEventSources() []StoredDataEventSource {
+	return []StoredDataEventSource{
+		StoredDataEventSourceAPI,
+		StoredDataEventSourceAdmin,
+		StoredDataEventSourceHTTP,
+		StoredDataEventSourcePostgres,
+		StoredDataEventSourceRedis,
+	}
+}
+
+// Stored data event types
+const (
+	StoredDataEventSave       StoredDataEventType = "save"
+	StoredDataEventInvalidate StoredDataEventType = "invalidate"
+)
+
+func StoredDataEventTypes() []StoredDataEventType {
+	return []StoredDataEventType{
+		StoredDataEventSave,
+		StoredDataEventInvalidate,
+	}
+}
+
+// AnalyticsModule : The analytics module which an event was logged to
+type AnalyticsModule string
+
+// AnalyticsEventType : The endpoint which logged an analytics event
+type AnalyticsEventType string
+
+// Analytics modules
+const (
+	AnalyticsModuleFile   AnalyticsModule = "file"
+	AnalyticsModuleStream AnalyticsModule = "stream"
+)
+
+func AnalyticsModules() []AnalyticsModule {
+	return []AnalyticsModule{
+		AnalyticsModuleFile,
+		AnalyticsModuleStream,
+	}
+}
+
+// Analytics event types
+const (
+	AnalyticsEventAuction    AnalyticsEventType = "auction"
+	AnalyticsEventAmp        AnalyticsEventType = "amp"
+	AnalyticsEventCookieSync AnalyticsEventType = "cookie_sync"
+	AnalyticsEventSetUID     AnalyticsEventType = "setuid"
+	AnalyticsEventNotify     AnalyticsEventType = "event"
+)
+
+func AnalyticsEventTypes() []AnalyticsEventType {
+	return []AnalyticsEventType{
+		AnalyticsEventAuction,
+		AnalyticsEventAmp,
+		AnalyticsEventCookieSync,
+		AnalyticsEventSetUID,
+		AnalyticsEventNotify,
+	}
+}
+
+// EventType : The type of notification which was sent to the /event endpoint
+type EventType string
+
+// Event types
+const (
+	EventWin EventType = "win"
+	EventImp EventType = "imp"
+)
+
+func EventTypes() []EventType {
+	return []EventType{
+		EventWin,
+		EventImp,
+	}
+}
+
 // MetricsEngine is a generic interface to record PBS metrics into the desired backend
 // The first three metrics function fire off once per incoming request, so total metrics
 // will equal the total numer of incoming requests. The remaining 5 fire off per outgoing
@@ -189,12 +402,35 @@ type MetricsEngine interface {
 	RecordRequest(labels Labels)                           // ignores adapter. only statusOk and statusErr fom status
 	RecordImps(labels Labels, numImps int)                 // ignores adapter. only statusOk and statusErr fom status
 	RecordRequestTime(labels Labels, length time.Duration) // ignores adapter. only statusOk and statusErr fom status
+	// RecordRequestPhaseTime records how long one phase of an auction request took.
+	RecordRequestPhaseTime(labels Labels, phase RequestPhase, length time.Duration)
+	// RecordTMaxExceeded records an auction request which took longer than its timeout.
+	RecordTMaxExceeded(labels Labels)
 	RecordAdapterRequest(labels AdapterLabels)
 	// This records whether or not a bid of a particular type uses `adm` or `nurl`.
 	// Since the legacy endpoints don't have a bid type, it can only count bids from OpenRTB and AMP.
 	RecordAdapterBidReceived(labels AdapterLabels, bidType openrtb_ext.BidType, hasAdm bool)
 	RecordAdapterPrice(labels AdapterLabels, cpm float64)
 	RecordAdapterTime(labels AdapterLabels, length time.Duration)
+	// RecordAdapterNonBid records an imp which the adapter didn't get to bid on, or a bid of theirs which was rejected.
+	RecordAdapterNonBid(adapter openrtb_ext.BidderName, reason openrtb_ext.NonBidReason)
+	// RecordAdapterCircuitBreakerState records the state which an adapter's circuit breaker has changed to.
+	RecordAdapterCircuitBreakerState(adapter openrtb_ext.BidderName, state CircuitBreakerState)
 	RecordCookieSync(labels Labels)        // May ignore all labels
 	RecordUserIDSet(userLabels UserLabels) // Function should verify bidder values
+	// These record how Stored Requests and Stored Imps are found. Cache results are counted per ID,
+	// while fetch times are counted per call to the backend. The error type is taken from labels.Error.
+	RecordStoredDataCacheResult(dataType StoredDataType, hits int, misses int)
+	RecordStoredDataFetchTime(fetcherType StoredDataFetcherType, length time.Duration)
+	RecordStoredDataError(labels StoredDataLabels)
+	RecordStoredDataEvent(source StoredDataEventSource, eventType StoredDataEventType)
+	// RecordStoredDataPollRows records the number of rows which a polling EventProducer loaded from its backend.
+	// The eventType tells whether the rows were saved or invalidated.
+	RecordStoredDataPollRows(dataType StoredDataType, eventType StoredDataEventType, rows int)
+	// RecordAnalyticsEventDropped records an analytics event which a module never got, because its queue was full.
+	RecordAnalyticsEventDropped(module AnalyticsModule, eventType AnalyticsEventType)
+	// RecordAnalyticsEventFailed records an analytics event which a module got, but failed to deliver to its backend.
+	RecordAnalyticsEventFailed(module AnalyticsModule, eventType AnalyticsEventType)
+	// RecordEvent records a win or impression notification which was sent to the /event endpoint.
+	RecordEvent(eventType EventType)
 }
@@ -18,18 +18,42 @@ type Metrics struct {
 	imps          *prometheus.CounterVec
 	requests      *prometheus.CounterVec
 	reqTimer      *prometheus.HistogramVec
+	phaseTimer    *prometheus.HistogramVec
+	tmaxExceeded  *prometheus.CounterVec
 	adaptRequests *prometheus.CounterVec
 	adaptTimer    *prometheus.HistogramVec
 	adaptBids     *prometheus.CounterVec
 	adaptPrices   *prometheus.HistogramVec
 	adaptErrors   *prometheus.CounterVec
+	adaptNonBids  *prometheus.CounterVec
+	adaptBreakers *prometheus.GaugeVec
 	cookieSync    prometheus.Counter
 	userID        *prometheus.CounterVec
+	storedCache   *prometheus.CounterVec
+	storedTimer   *prometheus.HistogramVec
+	storedErrors  *prometheus.CounterVec
+	storedEvents  *prometheus.CounterVec
+	storedPolls   *prometheus.HistogramVec
+	// Analytics metrics
+	analyticsDropped *prometheus.CounterVec
+	analyticsFailed  *prometheus.CounterVec
+	// Event notification metrics
+	events *prometheus.CounterVec
+	// Account metrics. These only get time series for the accounts which the filter lets through.
+	accountFilter        *pbsmetrics.AccountFilter
+	accountRequests      *prometheus.CounterVec
+	accountBids          *prometheus.CounterVec
+	accountTimeouts      *prometheus.CounterVec
+	accountPrices        *prometheus.HistogramVec
+	accountAdaptRequests *prometheus.CounterVec
+	accountAdaptBids     *prometheus.CounterVec
+	accountAdaptTimeouts *prometheus.CounterVec
+	accountAdaptPrices   *prometheus.HistogramVec
 }
 
 // NewMetrics constructs the appropriate options for the Prometheus metrics. Needs to be fed the promethus config
 // Its own function to keep the metric creation function cleaner.
-func NewMetrics(cfg config.PrometheusMetrics) *Metrics {
+func NewMetrics(cfg config.PrometheusMetrics, accounts config.AccountMetrics) *Metrics {
 	// define the buckets for timers
 	timerBuckets := prometheus.LinearBuckets(0.05, 0.05, 20)
 	timerBuckets = append(timerBuckets, []float64{1.5, 2.0, 3.0, 5.0, 10.0, 50.0}...)
@@ -64,6 +88,16 @@ func NewMetrics(cfg config.PrometheusMetrics) *Metrics {
 		standardLabelNames, timerBuckets,
 	)
 	metrics.Registry.MustRegister(metrics.reqTimer)
+	metrics.phaseTimer = newHistogram(cfg, "request_phase_time_seconds",
+		"Seconds spent in each phase of a PBS auction request.",
+		[]string{"request_type", "phase"}, timerBuckets,
+	)
+	metrics.Registry.MustRegister(metrics.phaseTimer)
+	metrics.tmaxExceeded = newCounter(cfg, "requests_tmax_exceeded_total",
+		"Number of auction requests which took longer than their timeout.",
+		[]string{"request_type"},
+	)
+	metrics.Registry.MustRegister(metrics.tmaxExceeded)
 	metrics.adaptRequests = newCounter(cfg, "adapter_requests_total",
 		"Number of requests sent out to each bidder.",
 		adapterLabelNames,
@@ -89,6 +123,16 @@ func NewMetrics(cfg config.PrometheusMetrics) *Metrics {
 		errorLabelNames,
 	)
 	metrics.Registry.MustRegister(metrics.adaptErrors)
+	metrics.adaptNonBids = newCounter(cfg, "adapter_nonbids_total",
+		"Number of imps which each adapter didn't get to bid on, and bids of theirs which were rejected.",
+		[]string{"adapter", "nonbid_reason"},
+	)
+	metrics.Registry.MustRegister(metrics.adaptNonBids)
+	metrics.adaptBreakers = newGauge(cfg, "adapter_circuit_breaker_state",
+		"The state of each adapter's circuit breaker. The current state is 1, and the others are 0.",
+		[]string{"adapter", "state"},
+	)
+	metrics.Registry.MustRegister(metrics.adaptBreakers)
 	metrics.cookieSync = newCookieSync(cfg)
 	metrics.Registry.MustRegister(metrics.cookieSync)
 	metrics.userID = newCounter(cfg, "usersync_total",
@@ -96,6 +140,88 @@ func NewMetrics(cfg config.PrometheusMetrics) *Metrics {
 		[]string{"action", "bidder"},
 	)
 	metrics.Registry.MustRegister(metrics.userID)
+	metrics.storedCache = newCounter(cfg, "stored_data_cache_total",
+		"Number of Stored Request and Stored Imp IDs which were looked up in the cache.",
+		[]string{"stored_data_type", "cache_result"},
+	)
+	metrics.Registry.MustRegister(metrics.storedCache)
+	metrics.storedTimer = newHistogram(cfg, "stored_data_fetch_time_seconds",
+		"Seconds to fetch Stored Requests and Stored Imps from each backend.",
+		[]string{"stored_data_fetcher"}, timerBuckets,
+	)
+	metrics.Registry.MustRegister(metrics.storedTimer)
+	metrics.storedErrors = newCounter(cfg, "stored_data_errors_total",
+		"Number of errors from each Stored Request backend.",
+		[]string{"stored_data_type", "stored_data_fetcher", "stored_data_error"},
+	)
+	metrics.Registry.MustRegister(metrics.storedErrors)
+	metrics.storedEvents = newCounter(cfg, "stored_data_events_total",
+		"Number of Stored Request cache updates from each event source.",
+		[]string{"event_source", "event_type"},
+	)
+	metrics.Registry.MustRegister(metrics.storedEvents)
+	metrics.storedPolls = newHistogram(cfg, "stored_data_poll_rows",
+		"Number of rows loaded by each poll for Stored Request updates.",
+		[]string{"stored_data_type", "event_type"}, prometheus.ExponentialBuckets(1, 4, 8),
+	)
+	metrics.Registry.MustRegister(metrics.storedPolls)
+	metrics.analyticsDropped = newCounter(cfg, "analytics_events_dropped_total",
+		"Number of analytics events which were dropped because the module's queue was full.",
+		[]string{"analytics_module", "event_type"},
+	)
+	metrics.Registry.MustRegister(metrics.analyticsDropped)
+	metrics.analyticsFailed = newCounter(cfg, "analytics_events_failed_total",
+		"Number of analytics events which the module failed to deliver.",
+		[]string{"analytics_module", "event_type"},
+	)
+	metrics.Registry.MustRegister(metrics.analyticsFailed)
+	metrics.events = newCounter(cfg, "events_total",
+		"Number of win and impression notifications sent to the /event endpoint.",
+		[]string{"event_type"},
+	)
+	metrics.Registry.MustRegister(metrics.events)
+
+	metrics.accountFilter = pbsmetrics.NewAccountFilter(accounts)
+	metrics.accountRequests = newCounter(cfg, "account_requests_total",
+		"Number of requests made to PBS by each account.",
+		[]string{"account", "request_type"},
+	)
+	metrics.Registry.MustRegister(metrics.accountRequests)
+	metrics.accountBids = newCounter(cfg, "account_bids_received_total",
+		"Number of bids received for each account.",
+		[]string{"account"},
+	)
+	metrics.Registry.MustRegister(metrics.accountBids)
+	metrics.accountTimeouts = newCounter(cfg, "account_timeouts_total",
+		"Number of requests to bidders which timed out, for each account.",
+		[]string{"account"},
+	)
+	metrics.Registry.MustRegister(metrics.accountTimeouts)
+	metrics.accountPrices = newHistogram(cfg, "account_prices",
+		"Values of the bids received for each account.",
+		[]string{"account"}, prometheus.LinearBuckets(0.1, 0.1, 200),
+	)
+	metrics.Registry.MustRegister(metrics.accountPrices)
+	metrics.accountAdaptRequests = newCounter(cfg, "account_adapter_requests_total",
+		"Number of requests sent out to each bidder, for each account.",
+		[]string{"account", "adapter", "adapter_bid"},
+	)
+	metrics.Registry.MustRegister(metrics.accountAdaptRequests)
+	metrics.accountAdaptBids = newCounter(cfg, "account_adapter_bids_received_total",
+		"Number of bids received from each bidder, for each account.",
+		[]string{"account", "adapter"},
+	)
+	metrics.Registry.MustRegister(metrics.accountAdaptBids)
+	metrics.accountAdaptTimeouts = newCounter(cfg, "account_adapter_timeouts_total",
+		"Number of requests to each bidder which timed out, for each account.",
+		[]string{"account", "adapter"},
+	)
+	metrics.Registry.MustRegister(metrics.accountAdaptTimeouts)
+	metrics.accountAdaptPrices = newHistogram(cfg, "account_adapter_prices",
+		"Values of the bids from each bidder, for each account.",
+		[]string{"account", "adapter"}, prometheus.LinearBuckets(0.1, 0.1, 200),
+	)
+	metrics.Registry.MustRegister(metrics.accountAdaptPrices)
 
 	initializeTimeSeries(&metrics)
 
@@ -132,6 +258,16 @@ func newCounter(cfg config.PrometheusMetrics, name string, help string, labels [
 	return prometheus.NewCounterVec(opts, labels)
 }
 
+func newGauge(cfg config.PrometheusMetrics, name string, help string, labels []string) *prometheus.GaugeVec {
+	opts := prometheus.GaugeOpts{
+		Namespace: cfg.Namespace,
+		Subsystem: cfg.Subsystem,
+		Name:      name,
+		Help:      help,
+	}
+	return prometheus.NewGaugeVec(opts, labels)
+}
+
 func newHistogram(cfg config.PrometheusMetrics, name string, help string, labels []string, buckets []float64) *prometheus.HistogramVec {
 	opts := prometheus.HistogramOpts{
 		Namespace: cfg.Namespace,
@@ -162,6 +298,9 @@ func (me *Metrics) RecordConnectionClose(success bool) {
 
 func (me *Metrics) RecordRequest(labels pbsmetrics.Labels) {
 	me.requests.With(resolveLabels(labels)).Inc()
+	if account, ok := me.accountFilter.Account(labels.PubID); ok {
+		me.accountRequests.WithLabelValues(account, string(labels.RType)).Inc()
+	}
 }
 
 func (me *Metrics) RecordImps(labels pbsmetrics.Labels, numImps int) {
@@ -173,19 +312,55 @@ func (me *Metrics) RecordRequestTime(labels pbsmetrics.Labels, length time.Durat
 	me.reqTimer.With(resolveLabels(labels)).Observe(time)
 }
 
+func (me *Metrics) RecordRequestPhaseTime(labels pbsmetrics.Labels, phase pbsmetrics.RequestPhase, length time.Duration) {
+	time := float64(length) / float64(time.Second)
+	me.phaseTimer.With(resolvePhaseLabels(labels, phase)).Observe(time)
+}
+
+func (me *Metrics) RecordTMaxExceeded(labels pbsmetrics.Labels) {
+	me.tmaxExceeded.WithLabelValues(string(labels.RType)).Inc()
+}
+
 func (me *Metrics) RecordAdapterRequest(labels pbsmetrics.AdapterLabels) {
 	me.adaptRequests.With(resolveAdapterLabels(labels)).Inc()
 	for k, _ := range labels.AdapterErrors {
 		me.adaptErrors.With(resolveAdapterErrorLabels(labels, string(k))).Inc()
 	}
+
+	account, ok := me.accountFilter.Account(labels.PubID)
+	if !ok {
+		return
+	}
+	_, timedOut := labels.AdapterErrors[pbsmetrics.AdapterErrorTimeout]
+	if timedOut {
+		me.accountTimeouts.WithLabelValues(account).Inc()
+	}
+	if me.accountFilter.AdapterMetrics() {
+		me.accountAdaptRequests.WithLabelValues(account, string(labels.Adapter), string(labels.AdapterBids)).Inc()
+		if timedOut {
+			me.accountAdaptTimeouts.WithLabelValues(account, string(labels.Adapter)).Inc()
+		}
+	}
 }
 
 func (me *Metrics) RecordAdapterBidReceived(labels pbsmetrics.AdapterLabels, bidType openrtb_ext.BidType, hasAdm bool) {
 	me.adaptBids.With(resolveBidLabels(labels, bidType, hasAdm)).Inc()
+	if account, ok := me.accountFilter.Account(labels.PubID); ok {
+		me.accountBids.WithLabelValues(account).Inc()
+		if me.accountFilter.AdapterMetrics() {
+			me.accountAdaptBids.WithLabelValues(account, string(labels.Adapter)).Inc()
+		}
+	}
 }
 
 func (me *Metrics) RecordAdapterPrice(labels pbsmetrics.AdapterLabels, cpm float64) {
 	me.adaptPrices.With(resolveAdapterLabels(labels)).Observe(cpm)
+	if account, ok := me.accountFilter.Account(labels.PubID); ok {
+		me.accountPrices.WithLabelValues(account).Observe(cpm)
+		if me.accountFilter.AdapterMetrics() {
+			me.accountAdaptPrices.WithLabelValues(account, string(labels.Adapter)).Observe(cpm)
+		}
+	}
 }
 
 func (me *Metrics) RecordAdapterTime(labels pbsmetrics.AdapterLabels, length time.Duration) {
@@ -201,6 +376,54 @@ func (me *Metrics) RecordUserIDSet(userLabels pbsmetrics.UserLabels) {
 	me.userID.With(resolveUserSyncLabels(userLabels)).Inc()
 }
 
+func (me *Metrics) RecordStoredDataCacheResult(dataType pbsmetrics.StoredDataType, hits int, misses int) {
+	me.storedCache.With(resolveStoredCacheLabels(dataType, "hit")).Add(float64(hits))
+	me.storedCache.With(resolveStoredCacheLabels(dataType, "miss")).Add(float64(misses))
+}
+
+func (me *Metrics) RecordStoredDataFetchTime(fetcherType pbsmetrics.StoredDataFetcherType, length time.Duration) {
+	time := float64(length) / float64(time.Second)
+	me.storedTimer.WithLabelValues(string(fetcherType)).Observe(time)
+}
+
+func (me *Metrics) RecordStoredDataError(labels pbsmetrics.StoredDataLabels) {
+	me.storedErrors.With(resolveStoredErrorLabels(labels)).Inc()
+}
+
+func (me *Metrics) RecordAdapterNonBid(adapter openrtb_ext.BidderName, reason openrtb_ext.NonBidReason) {
+	me.adaptNonBids.With(resolveNonBidLabels(adapter, reason)).Inc()
+}
+
+func (me *Metrics) RecordAdapterCircuitBreakerState(adapter openrtb_ext.BidderName, state pbsmetrics.CircuitBreakerState) {
+	for _, s := range pbsmetrics.CircuitBreakerStates() {
+		value := 0.0
+		if s == state {
+			value = 1
+		}
+		me.adaptBreakers.WithLabelValues(string(adapter), string(s)).Set(value)
+	}
+}
+
+func (me *Metrics) RecordStoredDataEvent(source pbsmetrics.StoredDataEventSource, eventType pbsmetrics.StoredDataEventType) {
+	me.storedEvents.With(resolveStoredEventLabels(source, eventType)).Inc()
+}
+
+func (me *Metrics) RecordStoredDataPollRows(dataType pbsmetrics.StoredDataType, eventType pbsmetrics.StoredDataEventType, rows int) {
+	me.storedPolls.With(resolveStoredPollLabels(dataType, eventType)).Observe(float64(rows))
+}
+
+func (me *Metrics) RecordAnalyticsEventDropped(module pbsmetrics.AnalyticsModule, eventType pbsmetrics.AnalyticsEventType) {
+	me.analyticsDropped.With(resolveAnalyticsLabels(module, eventType)).Inc()
+}
+
+func (me *Metrics) RecordAnalyticsEventFailed(module pbsmetrics.AnalyticsModule, eventType pbsmetrics.AnalyticsEventType) {
+	me.analyticsFailed.With(resolveAnalyticsLabels(module, eventType)).Inc()
+}
+
+func (me *Metrics) RecordEvent(eventType pbsmetrics.EventType) {
+	me.events.With(prometheus.Labels{"event_type": string(eventType)}).Inc()
+}
+
 func resolveLabels(labels pbsmetrics.Labels) prometheus.Labels {
 	return prometheus.Labels{
 		"demand_source": string(labels.Source),
@@ -212,6 +435,13 @@ func resolveLabels(labels pbsmetrics.Labels) prometheus.Labels {
 	}
 }
 
+func resolvePhaseLabels(labels pbsmetrics.Labels, phase pbsmetrics.RequestPhase) prometheus.Labels {
+	return prometheus.Labels{
+		"request_type": string(labels.RType),
+		"phase":        string(phase),
+	}
+}
+
 func resolveAdapterLabels(labels pbsmetrics.AdapterLabels) prometheus.Labels {
 	return prometheus.Labels{
 		"demand_source": string(labels.Source),
@@ -261,6 +491,49 @@ func resolveUserSyncLabels(userLabels pbsmetrics.UserLabels) prometheus.Labels {
 	}
 }
 
+func resolveStoredCacheLabels(dataType pbsmetrics.StoredDataType, result string) prometheus.Labels {
+	return prometheus.Labels{
+		"stored_data_type": string(dataType),
+		"cache_result":     result,
+	}
+}
+
+func resolveStoredErrorLabels(labels pbsmetrics.StoredDataLabels) prometheus.Labels {
+	return prometheus.Labels{
+		"stored_data_type":    string(labels.DataType),
+		"stored_data_fetcher": string(labels.FetcherType),
+		"stored_data_error":   string(labels.Error),
+	}
+}
+
+func resolveStoredEventLabels(source pbsmetrics.StoredDataEventSource, eventType pbsmetrics.StoredDataEventType) prometheus.Labels {
+	return prometheus.Labels{
+		"event_source": string(source),
+		"event_type":   string(eventType),
+	}
+}
+
+func resolveStoredPollLabels(dataType pbsmetrics.StoredDataType, eventType pbsmetrics.StoredDataEventType) prometheus.Labels {
+	return prometheus.Labels{
+		"stored_data_type": string(dataType),
+		"event_type":       string(eventType),
+	}
+}
+
+func resolveNonBidLabels(adapter openrtb_ext.BidderName, reason openrtb_ext.NonBidReason) prometheus.Labels {
+	return prometheus.Labels{
+		"adapter":       string(adapter),
+		"nonbid_reason": reason.String(),
+	}
+}
+
+func resolveAnalyticsLabels(module pbsmetrics.AnalyticsModule, eventType pbsmetrics.AnalyticsEventType) prometheus.Labels {
+	return prometheus.Labels{
+		"analytics_module": string(module),
+		"event_type":       string(eventType),
+	}
+}
+
 // initializeTimeSeries precreates all possible metric label values, so there is no locking needed at run time creating new instances
 func initializeTimeSeries(m *Metrics) {
 	// Connection errors
@@ -282,6 +555,15 @@ func initializeTimeSeries(m *Metrics) {
 		_ = m.reqTimer.With(l)
 	}
 
+	labels = addDimension([]prometheus.Labels{}, "request_type", requestTypesAsString())
+	for _, l := range labels {
+		_ = m.tmaxExceeded.With(l)
+	}
+	labels = addDimension(labels, "phase", requestPhasesAsString())
+	for _, l := range labels {
+		_ = m.phaseTimer.With(l)
+	}
+
 	// Adapter labels
 	labels = addDimension(adapterLabels, "adapter", adaptersAsString())
 	errorLabels := labels // save regenerating these dimensions for adapter errors
@@ -301,6 +583,45 @@ func initializeTimeSeries(m *Metrics) {
 	for _, l := range labels {
 		_ = m.adaptErrors.With(l)
 	}
+	labels = addDimension([]prometheus.Labels{}, "adapter", adaptersAsString())
+	labels = addDimension(labels, "nonbid_reason", nonBidReasonsAsString())
+	for _, l := range labels {
+		_ = m.adaptNonBids.With(l)
+	}
+
+	// Stored data labels
+	labels = addDimension([]prometheus.Labels{}, "stored_data_type", storedDataTypesAsString())
+	cacheLabels := addDimension(labels, "cache_result", []string{"hit", "miss"})
+	for _, l := range cacheLabels {
+		_ = m.storedCache.With(l)
+	}
+	labels = addDimension(labels, "stored_data_fetcher", storedDataFetcherTypesAsString())
+	labels = addDimension(labels, "stored_data_error", storedDataErrorsAsString())
+	for _, l := range labels {
+		_ = m.storedErrors.With(l)
+	}
+	for _, f := range storedDataFetcherTypesAsString() {
+		_ = m.storedTimer.WithLabelValues(f)
+	}
+	labels = addDimension([]prometheus.Labels{}, "event_source", storedDataEventSourcesAsString())
+	labels = addDimension(labels, "event_type", storedDataEventTypesAsString())
+	for _, l := range labels {
+		_ = m.storedEvents.With(l)
+	}
+	labels = addDimension([]prometheus.Labels{}, "stored_data_type", storedDataTypesAsString())
+	labels = addDimension(labels, "event_type", storedDataEventTypesAsString())
+	for _, l := range labels {
+		_ = m.storedPolls.With(l)
+	}
+	labels = addDimension([]prometheus.Labels{}, "analytics_module", analyticsModulesAsString())
+	labels = addDimension(labels, "event_type", analyticsEventTypesAsString())
+	for _, l := range labels {
+		_ = m.analyticsDropped.With(l)
+		_ = m.analyticsFailed.With(l)
+	}
+	for _, e := range eventTypesAsString() {
+		_ = m.events.WithLabelValues(e)
+	}
 }
 
 // addDimesion will expand a slice of labels to add the dimension of a new set of values for a new label name
@@ -381,6 +702,15 @@ func requestStatusesAsString() []string {
 	return output
 }
 
+func requestPhasesAsString() []string {
+	list := pbsmetrics.RequestPhases()
+	output := make([]string, len(list))
+	for i, s := range list {
+		output[i] = string(s)
+	}
+	return output
+}
+
 func adapterBidsAsString() []string {
 	list := pbsmetrics.AdapterBids()
 	output := make([]string, len(list))
@@ -409,6 +739,15 @@ func adaptersAsString() []string {
 
 }
 
+func nonBidReasonsAsString() []string {
+	list := openrtb_ext.NonBidReasons()
+	output := make([]string, len(list))
+	for i, s := range list {
+		output[i] = s.String()
+	}
+	return output
+}
+
 func bidTypesAsString() []string {
 	list := openrtb_ext.BidTypes()
 	output := make([]string, len(list))
@@ -418,3 +757,75 @@ func bidTypesAsString() []string {
 	return output
 
 }
+
+func storedDataTypesAsString() []string {
+	list := pbsmetrics.StoredDataTypes()
+	output := make([]string, len(list))
+	for i, s := range list {
+		output[i] = string(s)
+	}
+	return output
+}
+
+func storedDataFetcherTypesAsString() []string {
+	list := pbsmetrics.StoredDataFetcherTypes()
+	output := make([]string, len(list))
+	for i, s := range list {
+		output[i] = string(s)
+	}
+	return output
+}
+
+func storedDataErrorsAsString() []string {
+	list := pbsmetrics.StoredDataErrors()
+	output := make([]string, len(list))
+	for i, s := range list {
+		output[i] = string(s)
+	}
+	return output
+}
+
+func storedDataEventSourcesAsString() []string {
+	list := pbsmetrics.StoredDataEventSources()
+	output := make([]string, len(list))
+	for i, s := range list {
+		output[i] = string(s)
+	}
+	return output
+}
+
+func storedDataEventTypesAsString() []string {
+	list := pbsmetrics.StoredDataEventTypes()
+	output := make([]string, len(list))
+	for i, s := range list {
+		output[i] = string(s)
+	}
+	return output
+}
+
+func analyticsModulesAsString() []string {
+	list := pbsmetrics.AnalyticsModules()
+	output := make([]string, len(list))
+	for i, s := range list {
+		output[i] = string(s)
+	}
+	return output
+}
+
+func analyticsEventTypesAsString() []string {
+	list := pbsmetrics.AnalyticsEventTypes()
+	output := make([]string, len(list))
+	for i, s := range list {
+		output[i] = string(s)
+	}
+	return output
+}
+
+func eventTypesAsString() []string {
+	list := pbsmetrics.EventTypes()
+	output := make([]string, len(list))
+	for i, s := range list {
+		output[i] = string(s)
+	}
+	return output
+}
@@ -121,6 +121,46 @@ func TestTimerMetrics(t *testing.T) {
 	assertHistogramValue(t, "request_time[3]", &metrics3, 0)
 }
 
+func TestRequestPhaseMetrics(t *testing.T) {
+	proMetrics := newTestMetricsEngine()
+
+	storedMetrics := dto.Metric{}
+	biddersMetrics := dto.Metric{}
+	cacheMetrics := dto.Metric{}
+	tmaxMetrics := dto.Metric{}
+
+	proMetrics.RecordRequestPhaseTime(labels[0], pbsmetrics.PhaseStoredRequests, 10*time.Millisecond)
+	proMetrics.RecordRequestPhaseTime(labels[0], pbsmetrics.PhaseBidders, 200*time.Millisecond)
+	proMetrics.RecordRequestPhaseTime(labels[1], pbsmetrics.PhaseBidders, 300*time.Millisecond)
+	proMetrics.RecordTMaxExceeded(labels[0])
+
+	proMetrics.phaseTimer.With(resolvePhaseLabels(labels[0], pbsmetrics.PhaseStoredRequests)).(prometheus.Histogram).Write(&storedMetrics)
+	proMetrics.phaseTimer.With(resolvePhaseLabels(labels[0], pbsmetrics.PhaseBidders)).(prometheus.Histogram).Write(&biddersMetrics)
+	proMetrics.phaseTimer.With(resolvePhaseLabels(labels[0], pbsmetrics.PhaseCache)).(prometheus.Histogram).Write(&cacheMetrics)
+	proMetrics.tmaxExceeded.WithLabelValues(string(pbsmetrics.ReqTypeLegacy)).Write(&tmaxMetrics)
+
+	assertHistogramValue(t, "request_phase_time[stored_requests]", &storedMetrics, 1)
+	assertHistogramValue(t, "request_phase_time[bidders]", &biddersMetrics, 2)
+	assertHistogramValue(t, "request_phase_time[cache]", &cacheMetrics, 0)
+	assertCounterValue(t, "requests_tmax_exceeded[legacy]", &tmaxMetrics, 1)
+}
+
+func TestAdapterCircuitBreakerMetrics(t *testing.T) {
+	proMetrics := newTestMetricsEngine()
+
+	openMetrics := dto.Metric{}
+	closedMetrics := dto.Metric{}
+
+	proMetrics.RecordAdapterCircuitBreakerState(openrtb_ext.BidderAppnexus, pbsmetrics.CircuitBreakerClosed)
+	proMetrics.RecordAdapterCircuitBreakerState(openrtb_ext.BidderAppnexus, pbsmetrics.CircuitBreakerOpen)
+
+	proMetrics.adaptBreakers.WithLabelValues(string(openrtb_ext.BidderAppnexus), string(pbsmetrics.CircuitBreakerOpen)).Write(&openMetrics)
+	proMetrics.adaptBreakers.WithLabelValues(string(openrtb_ext.BidderAppnexus), string(pbsmetrics.CircuitBreakerClosed)).Write(&closedMetrics)
+
+	assertGaugeValue(t, "adapter_circuit_breaker_state[open]", &openMetrics, 1)
+	assertGaugeValue(t, "adapter_circuit_breaker_state[closed]", &closedMetrics, 0)
+}
+
 func TestAdapterRequestMetrics(t *testing.T) {
 	proMetrics := newTestMetricsEngine()
 
@@ -292,6 +332,140 @@ func TestUserMetrics(t *testing.T) {
 	assertCounterValue(t, "usersync[3]", &metrics3, 0)
 }
 
+func TestStoredDataMetrics(t *testing.T) {
+	proMetrics := newTestMetricsEngine()
+
+	hits := dto.Metric{}
+	misses := dto.Metric{}
+	impHits := dto.Metric{}
+	fetchTime := dto.Metric{}
+	notFound := dto.Metric{}
+	unknown := dto.Metric{}
+	saves := dto.Metric{}
+	invalidations := dto.Metric{}
+	pollRows := dto.Metric{}
+
+	proMetrics.RecordStoredDataCacheResult(pbsmetrics.StoredDataTypeRequest, 3, 1)
+	proMetrics.RecordStoredDataCacheResult(pbsmetrics.StoredDataTypeRequest, 2, 0)
+	proMetrics.RecordStoredDataFetchTime(pbsmetrics.StoredDataFetcherPostgres, 12*time.Millisecond)
+	proMetrics.RecordStoredDataFetchTime(pbsmetrics.StoredDataFetcherPostgres, 35*time.Millisecond)
+	proMetrics.RecordStoredDataError(storedDataLabels[0])
+	proMetrics.RecordStoredDataError(storedDataLabels[0])
+	proMetrics.RecordStoredDataError(storedDataLabels[1])
+	proMetrics.RecordStoredDataEvent(pbsmetrics.StoredDataEventSourceAdmin, pbsmetrics.StoredDataEventSave)
+	proMetrics.RecordStoredDataEvent(pbsmetrics.StoredDataEventSourceAdmin, pbsmetrics.StoredDataEventSave)
+	proMetrics.RecordStoredDataPollRows(pbsmetrics.StoredDataTypeRequest, pbsmetrics.StoredDataEventSave, 10)
+
+	proMetrics.storedCache.With(resolveStoredCacheLabels(pbsmetrics.StoredDataTypeRequest, "hit")).Write(&hits)
+	proMetrics.storedCache.With(resolveStoredCacheLabels(pbsmetrics.StoredDataTypeRequest, "miss")).Write(&misses)
+	proMetrics.storedCache.With(resolveStoredCacheLabels(pbsmetrics.StoredDataTypeImp, "hit")).Write(&impHits)
+	proMetrics.storedTimer.WithLabelValues(string(pbsmetrics.StoredDataFetcherPostgres)).(prometheus.Histogram).Write(&fetchTime)
+	proMetrics.storedErrors.With(resolveStoredErrorLabels(storedDataLabels[0])).Write(&notFound)
+	proMetrics.storedErrors.With(resolveStoredErrorLabels(storedDataLabels[1])).Write(&unknown)
+	proMetrics.storedEvents.With(resolveStoredEventLabels(pbsmetrics.StoredDataEventSourceAdmin, pbsmetrics.StoredDataEventSave)).Write(&saves)
+	proMetrics.storedEvents.With(resolveStoredEventLabels(pbsmetrics.StoredDataEventSourceAdmin, pbsmetrics.StoredDataEventInvalidate)).Write(&invalidations)
+	proMetrics.storedPolls.With(resolveStoredPollLabels(pbsmetrics.StoredDataTypeRequest, pbsmetrics.StoredDataEventSave)).(prometheus.Histogram).Write(&pollRows)
+
+	assertCounterValue(t, "stored_data_cache[request,hit]", &hits, 5)
+	assertCounterValue(t, "stored_data_cache[request,miss]", &misses, 1)
+	assertCounterValue(t, "stored_data_cache[imp,hit]", &impHits, 0)
+	assertHistogramValue(t, "stored_data_fetch_time[postgres]", &fetchTime, 2)
+	assertCounterValue(t, "stored_data_errors[0]", &notFound, 2)
+	assertCounterValue(t, "stored_data_errors[1]", &unknown, 1)
+	assertCounterValue(t, "stored_data_events[admin,save]", &saves, 2)
+	assertCounterValue(t, "stored_data_events[admin,invalidate]", &invalidations, 0)
+	assertHistogramValue(t, "stored_data_poll_rows[request,save]", &pollRows, 1)
+}
+
+func TestAdapterNonBidMetrics(t *testing.T) {
+	proMetrics := newTestMetricsEngine()
+
+	blocked := dto.Metric{}
+	invalid := dto.Metric{}
+
+	proMetrics.RecordAdapterNonBid(openrtb_ext.BidderAppnexus, openrtb_ext.NonBidBlockedCategory)
+	proMetrics.RecordAdapterNonBid(openrtb_ext.BidderAppnexus, openrtb_ext.NonBidBlockedCategory)
+
+	proMetrics.adaptNonBids.With(resolveNonBidLabels(openrtb_ext.BidderAppnexus, openrtb_ext.NonBidBlockedCategory)).Write(&blocked)
+	proMetrics.adaptNonBids.With(resolveNonBidLabels(openrtb_ext.BidderAppnexus, openrtb_ext.NonBidInvalidBid)).Write(&invalid)
+
+	assertCounterValue(t, "adapter_nonbids[appnexus,blocked_category]", &blocked, 2)
+	assertCounterValue(t, "adapter_nonbids[appnexus,invalid_bid]", &invalid, 0)
+}
+
+func TestAnalyticsMetrics(t *testing.T) {
+	proMetrics := newTestMetricsEngine()
+
+	auctionDrops := dto.Metric{}
+	ampDrops := dto.Metric{}
+
+	proMetrics.RecordAnalyticsEventDropped(pbsmetrics.AnalyticsModuleStream, pbsmetrics.AnalyticsEventAuction)
+	proMetrics.RecordAnalyticsEventDropped(pbsmetrics.AnalyticsModuleStream, pbsmetrics.AnalyticsEventAuction)
+
+	proMetrics.analyticsDropped.With(resolveAnalyticsLabels(pbsmetrics.AnalyticsModuleStream, pbsmetrics.AnalyticsEventAuction)).Write(&auctionDrops)
+	proMetrics.analyticsDropped.With(resolveAnalyticsLabels(pbsmetrics.AnalyticsModuleStream, pbsmetrics.AnalyticsEventAmp)).Write(&ampDrops)
+
+	assertCounterValue(t, "analytics_events_dropped[stream,auction]", &auctionDrops, 2)
+	assertCounterValue(t, "analytics_events_dropped[stream,amp]", &ampDrops, 0)
+
+	auctionFailures := dto.Metric{}
+	proMetrics.RecordAnalyticsEventFailed(pbsmetrics.AnalyticsModuleStream, pbsmetrics.AnalyticsEventAuction)
+	proMetrics.analyticsFailed.With(resolveAnalyticsLabels(pbsmetrics.AnalyticsModuleStream, pbsmetrics.AnalyticsEventAuction)).Write(&auctionFailures)
+	assertCounterValue(t, "analytics_events_failed[stream,auction]", &auctionFailures, 1)
+}
+
+func TestEventMetrics(t *testing.T) {
+	proMetrics := newTestMetricsEngine()
+
+	wins := dto.Metric{}
+	imps := dto.Metric{}
+
+	proMetrics.RecordEvent(pbsmetrics.EventWin)
+
+	proMetrics.events.WithLabelValues(string(pbsmetrics.EventWin)).Write(&wins)
+	proMetrics.events.WithLabelValues(string(pbsmetrics.EventImp)).Write(&imps)
+
+	assertCounterValue(t, "events[win]", &wins, 1)
+	assertCounterValue(t, "events[imp]", &imps, 0)
+}
+
+func TestAccountMetrics(t *testing.T) {
+	proMetrics := NewMetrics(config.PrometheusMetrics{Namespace: "prebid"}, config.AccountMetrics{
+		Enabled:        true,
+		AllowList:      []string{"Pub1"},
+		AdapterMetrics: true,
+	})
+
+	pub1Requests := dto.Metric{}
+	otherRequests := dto.Metric{}
+	pub1Timeouts := dto.Metric{}
+	pub1AdapterTimeouts := dto.Metric{}
+	pub1Prices := dto.Metric{}
+
+	proMetrics.RecordRequest(labels[0])
+	proMetrics.RecordRequest(labels[0])
+	proMetrics.RecordRequest(pbsmetrics.Labels{RType: pbsmetrics.ReqTypeLegacy, PubID: "Pub2"})
+	proMetrics.RecordAdapterRequest(pbsmetrics.AdapterLabels{
+		Adapter:       openrtb_ext.BidderAppnexus,
+		PubID:         "Pub1",
+		AdapterBids:   pbsmetrics.AdapterBidNone,
+		AdapterErrors: map[pbsmetrics.AdapterError]struct{}{pbsmetrics.AdapterErrorTimeout: {}},
+	})
+	proMetrics.RecordAdapterPrice(pbsmetrics.AdapterLabels{Adapter: openrtb_ext.BidderAppnexus, PubID: "Pub1"}, 1.5)
+
+	proMetrics.accountRequests.WithLabelValues("Pub1", string(pbsmetrics.ReqTypeLegacy)).Write(&pub1Requests)
+	proMetrics.accountRequests.WithLabelValues(pbsmetrics.AccountOther, string(pbsmetrics.ReqTypeLegacy)).Write(&otherRequests)
+	proMetrics.accountTimeouts.WithLabelValues("Pub1").Write(&pub1Timeouts)
+	proMetrics.accountAdaptTimeouts.WithLabelValues("Pub1", string(openrtb_ext.BidderAppnexus)).Write(&pub1AdapterTimeouts)
+	proMetrics.accountPrices.WithLabelValues("Pub1").(prometheus.Histogram).Write(&pub1Prices)
+
+	assertCounterValue(t, "account_requests[Pub1]", &pub1Requests, 2)
+	assertCounterValue(t, "account_requests[other]", &otherRequests, 1)
+	assertCounterValue(t, "account_timeouts[Pub1]", &pub1Timeouts, 1)
+	assertCounterValue(t, "account_adapter_timeouts[Pub1,appnexus]", &pub1AdapterTimeouts, 1)
+	assertHistogramValue(t, "account_prices[Pub1]", &pub1Prices, 1)
+}
+
 func TestMetricsExist(t *testing.T) {
 	// Initialize the metrics engine -> register the metrics to prometheus
 	metrics := newTestMetricsEngine()
@@ -314,7 +488,7 @@ func newTestMetricsEngine() *Metrics {
 		Port:      8080,
 		Namespace: "prebid",
 		Subsystem: "",
-	})
+	}, config.AccountMetrics{})
 }
 
 var labels = []pbsmetrics.Labels{
@@ -419,6 +593,19 @@ var userLabels = []pbsmetrics.UserLabels{
 	},
 }
 
+var storedDataLabels = []pbsmetrics.StoredDataLabels{
+	{
+		DataType:    pbsmetrics.StoredDataTypeRequest,
+		FetcherType: pbsmetrics.StoredDataFetcherPostgres,
+		Error:       pbsmetrics.StoredDataErrorMiss,
+	},
+	{
+		DataType:    pbsmetrics.StoredDataTypeImp,
+		FetcherType: pbsmetrics.StoredDataFetcherHTTP,
+		Error:       pbsmetrics.StoredDataErrorUnknown,
+	},
+}
+
 func assertMetricValue(t *testing.T, name string, m *dto.Metric, expected string) {
 	v := m.String()
 	if v != expected {
@@ -0,0 +1,191 @@
+package statsdmetrics
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prebid/prebid-server/config"
+)
+
+// tag is a label attached to a metric. DogStatsD sends these as tags, while plain StatsD appends the values to the name.
+type tag struct {
+	key   string
+	value string
+}
+
+// client buffers StatsD lines in memory, and writes them out once a packet is full or the flush interval passes.
+type client struct {
+	conn          io.WriteCloser
+	prefix        string
+	sampleRate    float64
+	dogStatsD     bool
+	maxPacketSize int
+
+	buffer      bytes.Buffer
+	bufferMutex sync.Mutex
+
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+func newClient(conn io.WriteCloser, cfg config.StatsdMetrics) *client {
+	c := &client{
+		conn:          conn,
+		prefix:        cfg.Prefix,
+		sampleRate:    cfg.SampleRate,
+		dogStatsD:     cfg.DogStatsD,
+		maxPacketSize: cfg.BufferSize,
+		done:          make(chan struct{}),
+		stopped:       make(chan struct{}),
+	}
+	if c.prefix != "" && !strings.HasSuffix(c.prefix, ".") {
+		c.prefix += "."
+	}
+	go c.flushPeriodically(cfg.FlushIntervalDuration())
+	return c
+}
+
+func (c *client) count(name string, value int64, tags ...tag) {
+	c.sampled(name, strconv.FormatInt(value, 10), "c", tags)
+}
+
+// gauge sets a gauge to value. Like gaugeDelta, these are never sampled.
+// StatsD reads a leading sign as a change, so negative values can't be sent this way.
+func (c *client) gauge(name string, value int64, tags ...tag) {
+	c.write(name, strconv.FormatInt(value, 10), "g", 1, tags)
+}
+
+// gaugeDelta changes a gauge by value. These are never sampled, because StatsD can't scale a gauge back up.
+func (c *client) gaugeDelta(name string, value int64, tags ...tag) {
+	delta := strconv.FormatInt(value, 10)
+	if value >= 0 {
+		delta = "+" + delta
+	}
+	c.write(name, delta, "g", 1, tags)
+}
+
+func (c *client) timing(name string, length time.Duration, tags ...tag) {
+	c.sampled(name, strconv.FormatFloat(float64(length)/float64(time.Millisecond), 'f', -1, 64), "ms", tags)
+}
+
+// histogram records a value which isn't a time. Plain StatsD doesn't have histograms, but its timers work the same way.
+func (c *client) histogram(name string, value float64, tags ...tag) {
+	metricType := "ms"
+	if c.dogStatsD {
+		metricType = "h"
+	}
+	c.sampled(name, strconv.FormatFloat(value, 'f', -1, 64), metricType, tags)
+}
+
+func (c *client) sampled(name string, value string, metricType string, tags []tag) {
+	if c.sampleRate < 1 && rand.Float64() >= c.sampleRate {
+		return
+	}
+	c.write(name, value, metricType, c.sampleRate, tags)
+}
+
+// write formats the line for a metric and adds it to the buffer.
+func (c *client) write(name string, value string, metricType string, sampleRate float64, tags []tag) {
+	var line bytes.Buffer
+	line.WriteString(c.prefix)
+	line.WriteString(name)
+	if !c.dogStatsD {
+		for _, t := range tags {
+			line.WriteByte('.')
+			line.WriteString(sanitize(t.value, true))
+		}
+	}
+	line.WriteByte(':')
+	line.WriteString(value)
+	line.WriteByte('|')
+	line.WriteString(metricType)
+	if sampleRate < 1 {
+		line.WriteString("|@")
+		line.WriteString(strconv.FormatFloat(sampleRate, 'f', -1, 64))
+	}
+	if c.dogStatsD && len(tags) > 0 {
+		line.WriteString("|#")
+		for i, t := range tags {
+			if i > 0 {
+				line.WriteByte(',')
+			}
+			line.WriteString(t.key)
+			line.WriteByte(':')
+			line.WriteString(sanitize(t.value, false))
+		}
+	}
+	line.WriteByte('\n')
+
+	c.bufferMutex.Lock()
+	defer c.bufferMutex.Unlock()
+	if c.buffer.Len()+line.Len() > c.maxPacketSize {
+		c.flushLocked()
+	}
+	c.buffer.Write(line.Bytes())
+}
+
+// sanitize replaces the characters which have a meaning in the StatsD protocol.
+// Periods only need replacing when the value becomes part of the metric name.
+func sanitize(value string, inName bool) string {
+	// An empty value would leave an empty node in the metric name, which Graphite can't handle.
+	if value == "" {
+		return "unknown"
+	}
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '|', '@', '#', ',', '\n':
+			return '_'
+		case '.':
+			if inName {
+				return '_'
+			}
+		}
+		return r
+	}, value)
+}
+
+func (c *client) flushPeriodically(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	defer close(c.stopped)
+	for {
+		select {
+		case <-ticker.C:
+			c.flush()
+		case <-c.done:
+			c.flush()
+			return
+		}
+	}
+}
+
+func (c *client) flush() {
+	c.bufferMutex.Lock()
+	defer c.bufferMutex.Unlock()
+	c.flushLocked()
+}
+
+// flushLocked sends the buffered lines as a single packet. The caller must hold bufferMutex.
+func (c *client) flushLocked() {
+	if c.buffer.Len() == 0 {
+		return
+	}
+	// The last line's newline is dropped, since some StatsD agents would read it as an empty metric.
+	if _, err := c.conn.Write(c.buffer.Bytes()[:c.buffer.Len()-1]); err != nil {
+		glog.Warningf("Failed to send metrics to StatsD: %v", err)
+	}
+	c.buffer.Reset()
+}
+
+// Close flushes the buffered metrics and closes the connection.
+func (c *client) Close() error {
+	close(c.done)
+	<-c.stopped
+	return c.conn.Close()
+}
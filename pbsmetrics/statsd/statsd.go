@@ -0,0 +1,204 @@
+package statsdmetrics
+
+import (
+	"io"
+	"net"
+	"time"
+
+	"github.com/prebid/prebid-server/config"
+	"github.com/prebid/prebid-server/openrtb_ext"
+	"github.com/prebid/prebid-server/pbsmetrics"
+)
+
+// Metrics sends PBS metrics to a StatsD agent. Satisfies interface MetricsEngine
+type Metrics struct {
+	client        *client
+	accountFilter *pbsmetrics.AccountFilter
+}
+
+// NewMetrics makes a StatsD metrics engine which sends its metrics over UDP to cfg.Host.
+// Close() should be called on shutdown, so that the buffered metrics get sent.
+func NewMetrics(cfg config.StatsdMetrics, accounts config.AccountMetrics) (*Metrics, error) {
+	conn, err := net.Dial("udp", cfg.Host)
+	if err != nil {
+		return nil, err
+	}
+	return newMetrics(conn, cfg, accounts), nil
+}
+
+func newMetrics(conn io.WriteCloser, cfg config.StatsdMetrics, accounts config.AccountMetrics) *Metrics {
+	return &Metrics{
+		client:        newClient(conn, cfg),
+		accountFilter: pbsmetrics.NewAccountFilter(accounts),
+	}
+}
+
+// Close sends any buffered metrics and closes the connection to StatsD.
+func (me *Metrics) Close() error {
+	return me.client.Close()
+}
+
+func (me *Metrics) RecordConnectionAccept(success bool) {
+	if success {
+		me.client.gaugeDelta("active_connections", 1)
+	} else {
+		me.client.count("connection_errors", 1, tag{"error_type", "accept_error"})
+	}
+}
+
+func (me *Metrics) RecordConnectionClose(success bool) {
+	if success {
+		me.client.gaugeDelta("active_connections", -1)
+	} else {
+		me.client.count("connection_errors", 1, tag{"error_type", "close_error"})
+	}
+}
+
+func (me *Metrics) RecordRequest(labels pbsmetrics.Labels) {
+	me.client.count("requests", 1, resolveTags(labels)...)
+	if account, ok := me.accountFilter.Account(labels.PubID); ok {
+		me.client.count("account_requests", 1, tag{"account", account}, tag{"request_type", string(labels.RType)})
+	}
+}
+
+func (me *Metrics) RecordImps(labels pbsmetrics.Labels, numImps int) {
+	me.client.count("imps_requested", int64(numImps), resolveTags(labels)...)
+}
+
+func (me *Metrics) RecordRequestTime(labels pbsmetrics.Labels, length time.Duration) {
+	me.client.timing("request_time", length, resolveTags(labels)...)
+}
+
+func (me *Metrics) RecordRequestPhaseTime(labels pbsmetrics.Labels, phase pbsmetrics.RequestPhase, length time.Duration) {
+	me.client.timing("request_phase_time", length, tag{"request_type", string(labels.RType)}, tag{"phase", string(phase)})
+}
+
+func (me *Metrics) RecordTMaxExceeded(labels pbsmetrics.Labels) {
+	me.client.count("requests_tmax_exceeded", 1, tag{"request_type", string(labels.RType)})
+}
+
+func (me *Metrics) RecordAdapterRequest(labels pbsmetrics.AdapterLabels) {
+	me.client.count("adapter_requests", 1, resolveAdapterTags(labels)...)
+	for errType := range labels.AdapterErrors {
+		me.client.count("adapter_errors", 1, append(resolveAdapterTags(labels), tag{"adapter_error", string(errType)})...)
+	}
+
+	account, ok := me.accountFilter.Account(labels.PubID)
+	if !ok {
+		return
+	}
+	_, timedOut := labels.AdapterErrors[pbsmetrics.AdapterErrorTimeout]
+	if timedOut {
+		me.client.count("account_timeouts", 1, tag{"account", account})
+	}
+	if me.accountFilter.AdapterMetrics() {
+		me.client.count("account_adapter_requests", 1, tag{"account", account}, tag{"adapter", string(labels.Adapter)}, tag{"adapter_bid", string(labels.AdapterBids)})
+		if timedOut {
+			me.client.count("account_adapter_timeouts", 1, tag{"account", account}, tag{"adapter", string(labels.Adapter)})
+		}
+	}
+}
+
+func (me *Metrics) RecordAdapterBidReceived(labels pbsmetrics.AdapterLabels, bidType openrtb_ext.BidType, hasAdm bool) {
+	markupType := "unknown"
+	if hasAdm {
+		markupType = "adm"
+	}
+	me.client.count("adapter_bids_received", 1, append(resolveAdapterTags(labels), tag{"bidtype", string(bidType)}, tag{"markup_type", markupType})...)
+
+	if account, ok := me.accountFilter.Account(labels.PubID); ok {
+		me.client.count("account_bids_received", 1, tag{"account", account})
+		if me.accountFilter.AdapterMetrics() {
+			me.client.count("account_adapter_bids_received", 1, tag{"account", account}, tag{"adapter", string(labels.Adapter)})
+		}
+	}
+}
+
+func (me *Metrics) RecordAdapterPrice(labels pbsmetrics.AdapterLabels, cpm float64) {
+	me.client.histogram("adapter_prices", cpm, resolveAdapterTags(labels)...)
+
+	if account, ok := me.accountFilter.Account(labels.PubID); ok {
+		me.client.histogram("account_prices", cpm, tag{"account", account})
+		if me.accountFilter.AdapterMetrics() {
+			me.client.histogram("account_adapter_prices", cpm, tag{"account", account}, tag{"adapter", string(labels.Adapter)})
+		}
+	}
+}
+
+func (me *Metrics) RecordAdapterTime(labels pbsmetrics.AdapterLabels, length time.Duration) {
+	me.client.timing("adapter_time", length, resolveAdapterTags(labels)...)
+}
+
+func (me *Metrics) RecordAdapterNonBid(adapter openrtb_ext.BidderName, reason openrtb_ext.NonBidReason) {
+	me.client.count("adapter_nonbids", 1, tag{"adapter", string(adapter)}, tag{"nonbid_reason", reason.String()})
+}
+
+func (me *Metrics) RecordAdapterCircuitBreakerState(adapter openrtb_ext.BidderName, state pbsmetrics.CircuitBreakerState) {
+	me.client.gauge("adapter_circuit_breaker_state", state.Value(), tag{"adapter", string(adapter)})
+}
+
+func (me *Metrics) RecordCookieSync(labels pbsmetrics.Labels) {
+	me.client.count("cookie_sync_requests", 1)
+}
+
+func (me *Metrics) RecordUserIDSet(userLabels pbsmetrics.UserLabels) {
+	me.client.count("usersync", 1, tag{"action", string(userLabels.Action)}, tag{"bidder", string(userLabels.Bidder)})
+}
+
+func (me *Metrics) RecordStoredDataCacheResult(dataType pbsmetrics.StoredDataType, hits int, misses int) {
+	me.client.count("stored_data_cache", int64(hits), tag{"stored_data_type", string(dataType)}, tag{"cache_result", "hit"})
+	me.client.count("stored_data_cache", int64(misses), tag{"stored_data_type", string(dataType)}, tag{"cache_result", "miss"})
+}
+
+func (me *Metrics) RecordStoredDataFetchTime(fetcherType pbsmetrics.StoredDataFetcherType, length time.Duration) {
+	me.client.timing("stored_data_fetch_time", length, tag{"stored_data_fetcher", string(fetcherType)})
+}
+
+func (me *Metrics) RecordStoredDataError(labels pbsmetrics.StoredDataLabels) {
+	me.client.count("stored_data_errors", 1,
+		tag{"stored_data_type", string(labels.DataType)},
+		tag{"stored_data_fetcher", string(labels.FetcherType)},
+		tag{"stored_data_error", string(labels.Error)},
+	)
+}
+
+func (me *Metrics) RecordStoredDataEvent(source pbsmetrics.StoredDataEventSource, eventType pbsmetrics.StoredDataEventType) {
+	me.client.count("stored_data_events", 1, tag{"event_source", string(source)}, tag{"event_type", string(eventType)})
+}
+
+func (me *Metrics) RecordStoredDataPollRows(dataType pbsmetrics.StoredDataType, eventType pbsmetrics.StoredDataEventType, rows int) {
+	me.client.histogram("stored_data_poll_rows", float64(rows), tag{"stored_data_type", string(dataType)}, tag{"event_type", string(eventType)})
+}
+
+func (me *Metrics) RecordAnalyticsEventDropped(module pbsmetrics.AnalyticsModule, eventType pbsmetrics.AnalyticsEventType) {
+	me.client.count("analytics_events_dropped", 1, tag{"analytics_module", string(module)}, tag{"event_type", string(eventType)})
+}
+
+func (me *Metrics) RecordAnalyticsEventFailed(module pbsmetrics.AnalyticsModule, eventType pbsmetrics.AnalyticsEventType) {
+	me.client.count("analytics_events_failed", 1, tag{"analytics_module", string(module)}, tag{"event_type", string(eventType)})
+}
+
+func (me *Metrics) RecordEvent(eventType pbsmetrics.EventType) {
+	me.client.count("events", 1, tag{"event_type", string(eventType)})
+}
+
+func resolveTags(labels pbsmetrics.Labels) []tag {
+	return []tag{
+		{"demand_source", string(labels.Source)},
+		{"request_type", string(labels.RType)},
+		{"browser", string(labels.Browser)},
+		{"cookie", string(labels.CookieFlag)},
+		{"response_status", string(labels.RequestStatus)},
+	}
+}
+
+func resolveAdapterTags(labels pbsmetrics.AdapterLabels) []tag {
+	return []tag{
+		{"demand_source", string(labels.Source)},
+		{"request_type", string(labels.RType)},
+		{"browser", string(labels.Browser)},
+		{"cookie", string(labels.CookieFlag)},
+		{"adapter_bid", string(labels.AdapterBids)},
+		{"adapter", string(labels.Adapter)},
+	}
+}
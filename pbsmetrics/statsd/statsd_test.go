@@ -0,0 +1,174 @@
+package statsdmetrics
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prebid/prebid-server/config"
+	"github.com/prebid/prebid-server/openrtb_ext"
+	"github.com/prebid/prebid-server/pbsmetrics"
+)
+
+func TestPlainStatsD(t *testing.T) {
+	conn := &recordingConn{}
+	m := newMetrics(conn, testConfig(false), config.AccountMetrics{})
+
+	m.RecordRequest(pbsmetrics.Labels{
+		Source:        pbsmetrics.DemandWeb,
+		RType:         pbsmetrics.ReqTypeORTB2Web,
+		Browser:       pbsmetrics.BrowserSafari,
+		CookieFlag:    pbsmetrics.CookieFlagYes,
+		RequestStatus: pbsmetrics.RequestStatusOK,
+	})
+	m.RecordConnectionAccept(true)
+	m.RecordConnectionClose(true)
+	m.RecordStoredDataFetchTime(pbsmetrics.StoredDataFetcherHTTP, 1500*time.Microsecond)
+	m.RecordAdapterPrice(pbsmetrics.AdapterLabels{Adapter: openrtb_ext.BidderAppnexus}, 1.25)
+	m.Close()
+
+	assertPackets(t, conn, []string{
+		"pbs.requests.web.openrtb2-web.safari.exists.ok:1|c\n" +
+			"pbs.active_connections:+1|g\n" +
+			"pbs.active_connections:-1|g\n" +
+			"pbs.stored_data_fetch_time.http:1.5|ms\n" +
+			"pbs.adapter_prices.unknown.unknown.unknown.unknown.unknown.appnexus:1.25|ms",
+	})
+}
+
+func TestDogStatsD(t *testing.T) {
+	conn := &recordingConn{}
+	m := newMetrics(conn, testConfig(true), config.AccountMetrics{Enabled: true, AllowList: []string{"pub.1"}})
+
+	m.RecordEvent(pbsmetrics.EventWin)
+	m.RecordRequest(pbsmetrics.Labels{RType: pbsmetrics.ReqTypeAMP, PubID: "pub.1"})
+	m.RecordStoredDataPollRows(pbsmetrics.StoredDataTypeImp, pbsmetrics.StoredDataEventSave, 3)
+	m.Close()
+
+	assertPackets(t, conn, []string{
+		"pbs.events:1|c|#event_type:win\n" +
+			"pbs.requests:1|c|#demand_source:unknown,request_type:amp,browser:unknown,cookie:unknown,response_status:unknown\n" +
+			"pbs.account_requests:1|c|#account:pub.1,request_type:amp\n" +
+			"pbs.stored_data_poll_rows:3|h|#stored_data_type:imp,event_type:save",
+	})
+}
+
+func TestRequestPhases(t *testing.T) {
+	conn := &recordingConn{}
+	m := newMetrics(conn, testConfig(true), config.AccountMetrics{})
+
+	m.RecordRequestPhaseTime(pbsmetrics.Labels{RType: pbsmetrics.ReqTypeAMP}, pbsmetrics.PhaseStoredRequests, 12*time.Millisecond)
+	m.RecordTMaxExceeded(pbsmetrics.Labels{RType: pbsmetrics.ReqTypeORTB2Web})
+	m.Close()
+
+	assertPackets(t, conn, []string{
+		"pbs.request_phase_time:12|ms|#request_type:amp,phase:stored_requests\n" +
+			"pbs.requests_tmax_exceeded:1|c|#request_type:openrtb2-web",
+	})
+}
+
+func TestAdapterCircuitBreakerState(t *testing.T) {
+	conn := &recordingConn{}
+	m := newMetrics(conn, testConfig(true), config.AccountMetrics{})
+
+	m.RecordAdapterCircuitBreakerState(openrtb_ext.BidderAppnexus, pbsmetrics.CircuitBreakerOpen)
+	m.Close()
+
+	assertPackets(t, conn, []string{
+		"pbs.adapter_circuit_breaker_state:2|g|#adapter:appnexus",
+	})
+}
+
+func TestPacketSize(t *testing.T) {
+	conn := &recordingConn{}
+	cfg := testConfig(true)
+	cfg.BufferSize = 70
+	m := newMetrics(conn, cfg, config.AccountMetrics{})
+
+	m.RecordEvent(pbsmetrics.EventWin)
+	m.RecordEvent(pbsmetrics.EventImp)
+	m.RecordCookieSync(pbsmetrics.Labels{})
+	m.Close()
+
+	assertPackets(t, conn, []string{
+		"pbs.events:1|c|#event_type:win\npbs.events:1|c|#event_type:imp",
+		"pbs.cookie_sync_requests:1|c",
+	})
+}
+
+func TestSampling(t *testing.T) {
+	conn := &recordingConn{}
+	cfg := testConfig(false)
+	cfg.SampleRate = 0.000001
+	m := newMetrics(conn, cfg, config.AccountMetrics{})
+
+	for i := 0; i < 100; i++ {
+		m.RecordCookieSync(pbsmetrics.Labels{})
+	}
+	m.RecordConnectionAccept(true)
+	m.Close()
+
+	// Gauges shouldn't be sampled, since StatsD can't scale them back up.
+	assertPackets(t, conn, []string{"pbs.active_connections:+1|g"})
+}
+
+func TestSampleRateSent(t *testing.T) {
+	conn := &recordingConn{}
+	cfg := testConfig(false)
+	cfg.SampleRate = 0.9999999
+	m := newMetrics(conn, cfg, config.AccountMetrics{})
+
+	m.RecordCookieSync(pbsmetrics.Labels{})
+	m.Close()
+
+	assertPackets(t, conn, []string{"pbs.cookie_sync_requests:1|c|@0.9999999"})
+}
+
+func testConfig(dogStatsD bool) config.StatsdMetrics {
+	return config.StatsdMetrics{
+		Host:          "localhost:8125",
+		Prefix:        "pbs",
+		SampleRate:    1,
+		BufferSize:    1432,
+		FlushInterval: 60000,
+		DogStatsD:     dogStatsD,
+	}
+}
+
+func assertPackets(t *testing.T, conn *recordingConn, expected []string) {
+	t.Helper()
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	if !conn.closed {
+		t.Error("The connection should be closed")
+	}
+	if len(conn.packets) != len(expected) {
+		t.Fatalf("Expected %d packets. Got %d: %s", len(expected), len(conn.packets), strings.Join(conn.packets, "\n---\n"))
+	}
+	for i, packet := range conn.packets {
+		if packet != expected[i] {
+			t.Errorf("Bad packet %d. Expected:\n%s\nGot:\n%s", i, expected[i], packet)
+		}
+	}
+}
+
+type recordingConn struct {
+	mutex   sync.Mutex
+	packets []string
+	closed  bool
+}
+
+func (c *recordingConn) Write(p []byte) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.packets = append(c.packets, string(p))
+	return len(p), nil
+}
+
+func (c *recordingConn) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.closed = true
+	return nil
+}
@@ -4,12 +4,15 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 
 	"github.com/buger/jsonparser"
 	"github.com/golang/glog"
 	"github.com/prebid/prebid-server/config"
+	"github.com/prebid/prebid-server/tracing"
 	"golang.org/x/net/context/ctxhttp"
 )
 
@@ -59,14 +62,20 @@ func (c *clientImpl) PutJson(ctx context.Context, values []Cacheable) (uuids []s
 
 	uuidsToReturn := make([]string, len(values))
 
+	ctx, span := tracing.StartSpan(ctx, "prebid_cache.PutJson")
+	span.SetAttribute("values", strconv.Itoa(len(values)))
+	defer span.End()
+
 	postBody, err := encodeValues(values)
 	if err != nil {
 		glog.Errorf("Error creating JSON for prebid cache: %v", err)
+		span.SetError(err)
 		return uuidsToReturn
 	}
 	httpReq, err := http.NewRequest("POST", c.putUrl, bytes.NewReader(postBody))
 	if err != nil {
 		glog.Errorf("Error creating POST request to prebid cache: %v", err)
+		span.SetError(err)
 		return uuidsToReturn
 	}
 	httpReq.Header.Add("Content-Type", "application/json;charset=utf-8")
@@ -75,13 +84,16 @@ func (c *clientImpl) PutJson(ctx context.Context, values []Cacheable) (uuids []s
 	anResp, err := ctxhttp.Do(ctx, c.httpClient, httpReq)
 	if err != nil {
 		glog.Errorf("Error sending the request to Prebid Cache: %v", err)
+		span.SetError(err)
 		return uuidsToReturn
 	}
 	defer anResp.Body.Close()
 
 	responseBody, err := ioutil.ReadAll(anResp.Body)
+	span.SetAttribute("http.status_code", strconv.Itoa(anResp.StatusCode))
 	if anResp.StatusCode != 200 {
 		glog.Errorf("Prebid Cache call to %s returned %d: %s", putURL, anResp.StatusCode, responseBody)
+		span.SetError(fmt.Errorf("Prebid Cache returned status %d", anResp.StatusCode))
 		return uuidsToReturn
 	}
 
@@ -102,6 +114,7 @@ func (c *clientImpl) PutJson(ctx context.Context, values []Cacheable) (uuids []s
 
 	if _, err := jsonparser.ArrayEach(responseBody, processResponse, "responses"); err != nil {
 		glog.Errorf("Error interpreting Prebid Cache response: %v\nResponse was: %s", err, string(responseBody))
+		span.SetError(err)
 		return uuidsToReturn
 	}
 
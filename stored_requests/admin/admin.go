@@ -0,0 +1,456 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prebid/prebid-server/stored_requests"
+	"github.com/prebid/prebid-server/stored_requests/events"
+)
+
+// DataType identifies the kind of Stored data being managed.
+type DataType string
+
+const (
+	RequestDataType DataType = "request"
+	ImpDataType     DataType = "imp"
+)
+
+// notFoundName returns the DataType in the format used by stored_requests.NotFoundError.
+func (dataType DataType) notFoundName() string {
+	if dataType == ImpDataType {
+		return "Imp"
+	}
+	return "Request"
+}
+
+// Entry is a single version of some Stored Request or Stored Imp data.
+type Entry struct {
+	ID      string          `json:"id"`
+	Version int             `json:"version"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// Store persists the data managed by the admin API.
+//
+// Implementations must be safe for concurrent access by multiple goroutines.
+type Store interface {
+	// List returns every ID of the given type, along with its current version. The Data will be left empty.
+	List(ctx context.Context, dataType DataType) ([]Entry, error)
+
+	// Get returns the current version of some data. If it doesn't exist, the error will be a stored_requests.NotFoundError.
+	Get(ctx context.Context, dataType DataType, id string) (Entry, error)
+
+	// GetVersion returns an older version of some data. If it doesn't exist, the error will be a stored_requests.NotFoundError.
+	GetVersion(ctx context.Context, dataType DataType, id string, version int) (Entry, error)
+
+	// History returns every saved version of some data, oldest first. The Data will be left empty.
+	History(ctx context.Context, dataType DataType, id string) ([]Entry, error)
+
+	// Save creates or overwrites some data, and returns its new version. Versions start at 1.
+	// The data must also be saved in the history, so that Fetchers can find it at stored_requests.VersionedID(id, version).
+	//
+	// If expectedVersion is positive, the data will only be saved if that's its current version.
+	// Otherwise, the error will be a VersionConflictError.
+	Save(ctx context.Context, dataType DataType, id string, data json.RawMessage, expectedVersion int) (version int, err error)
+
+	// Delete removes some data. If it doesn't exist, the error will be a stored_requests.NotFoundError.
+	// The history should be kept, so that requests which use an older version still work.
+	Delete(ctx context.Context, dataType DataType, id string) error
+}
+
+// VersionConflictError is returned by Store.Save if the data was changed by someone else.
+type VersionConflictError struct {
+	ID       string
+	Expected int
+}
+
+func (e VersionConflictError) Error() string {
+	return fmt.Sprintf(`Stored data with ID="%s" is no longer at version %d.`, e.ID, e.Expected)
+}
+
+// Validator checks some Stored Request or Stored Imp data before the admin API saves it.
+type Validator func(dataType DataType, data json.RawMessage) error
+
+// API is an http.Handler which manages Stored Requests and Stored Imps.
+//
+// Every change is written to the Store first, and then sent to all the EventProducers made by NewEventProducer().
+// These should be hooked up to every Stored Request cache, so that the changes take effect right away.
+//
+// It serves the following routes:
+//
+//   GET    /stored_requests/requests                   Lists the IDs and versions of all Stored Requests
+//   GET    /stored_requests/requests/{id}              Returns the current version of a Stored Request
+//   GET    /stored_requests/requests/{id}@{version}    Returns an older version of a Stored Request
+//   GET    /stored_requests/requests/{id}/versions     Lists every version of a Stored Request
+//   PUT    /stored_requests/requests/{id}              Creates or updates a Stored Request. The body should be the new data
+//   POST   /stored_requests/requests/{id}/rollback     Saves an older version as the newest one. The body should be {"version": 3}
+//   DELETE /stored_requests/requests/{id}              Deletes a Stored Request
+//
+// ... and the same for Stored Imps under /stored_requests/imps.
+//
+// Updates and rollbacks may send an "If-Match: {version}" header. If the data isn't at that version anymore,
+// the update will be rejected with a 409 so that the caller doesn't overwrite someone else's change.
+//
+// If a change is saved, but the request is cancelled before every EventProducer has received it,
+// the API responds with a 503. Some caches may be stale until the data expires or changes again.
+type API struct {
+	store       Store
+	validator   Validator
+	authToken   string
+	timeout     time.Duration
+	maxBodySize int64
+
+	producersLock sync.Mutex
+	producers     []*eventProducer
+}
+
+// NewAdminAPI makes an API which saves data to the store. Callers must send "Authorization: Bearer {authToken}" on every request.
+// If validator is nil, any JSON will be accepted. Request bodies larger than maxBodySize bytes will be rejected with a 413.
+func NewAdminAPI(store Store, validator Validator, authToken string, timeout time.Duration, maxBodySize int64) *API {
+	if store == nil {
+		glog.Fatalf("The Stored Request admin API requires a Store. Please report this as a bug.")
+	}
+	if authToken == "" {
+		glog.Fatalf("The Stored Request admin API requires an auth token. Please report this as a bug.")
+	}
+	return &API{
+		store:       store,
+		validator:   validator,
+		authToken:   authToken,
+		timeout:     timeout,
+		maxBodySize: maxBodySize,
+	}
+}
+
+// NewEventProducer returns an EventProducer which will see every change made through this API.
+// Each EventProducer must be listened to, or the API will block.
+func (api *API) NewEventProducer() events.EventProducer {
+	producer := &eventProducer{
+		saves:         make(chan events.Save),
+		invalidations: make(chan events.Invalidation),
+	}
+	api.producersLock.Lock()
+	api.producers = append(api.producers, producer)
+	api.producersLock.Unlock()
+	return producer
+}
+
+const pathPrefix = "/stored_requests/"
+
+func (api *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !api.isAuthorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="stored_requests"`)
+		writeMessage(w, http.StatusUnauthorized, "A valid bearer token is required.")
+		return
+	}
+
+	dataType, id, action, ok := parsePath(r.URL.Path)
+	if !ok {
+		writeMessage(w, http.StatusNotFound, "Expected a path like /stored_requests/requests/{id} or /stored_requests/imps/{id}.")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, api.maxBodySize)
+	ctx, cancel := context.WithTimeout(r.Context(), api.timeout)
+	defer cancel()
+
+	if id == "" {
+		if r.Method != "GET" {
+			writeMessage(w, http.StatusMethodNotAllowed, "Only GET is allowed on a list of Stored data.")
+			return
+		}
+		api.list(ctx, w, dataType)
+		return
+	}
+
+	// Older versions can be read, but all changes must go through the plain ID.
+	id, version := stored_requests.ParseVersionedID(id)
+	if !isValidID(id) || (version > 0 && (r.Method != "GET" || action != "")) {
+		writeMessage(w, http.StatusBadRequest, fmt.Sprintf("Invalid ID: %s", strings.TrimPrefix(r.URL.Path, pathPrefix)))
+		return
+	}
+
+	switch {
+	case action == "versions" && r.Method == "GET":
+		api.history(ctx, w, dataType, id)
+	case action == "rollback" && r.Method == "POST":
+		api.rollback(ctx, w, r, dataType, id)
+	case action == "versions" || action == "rollback":
+		writeMessage(w, http.StatusMethodNotAllowed, fmt.Sprintf("%s is not allowed on %s.", r.Method, action))
+	case action != "":
+		writeMessage(w, http.StatusNotFound, fmt.Sprintf("Unknown operation: %s", action))
+	case r.Method == "GET":
+		api.get(ctx, w, dataType, id, version)
+	case r.Method == "PUT":
+		api.save(ctx, w, r, dataType, id)
+	case r.Method == "DELETE":
+		api.delete(ctx, w, r, dataType, id)
+	default:
+		writeMessage(w, http.StatusMethodNotAllowed, "Only GET, PUT and DELETE are allowed on Stored data.")
+	}
+}
+
+func (api *API) isAuthorized(r *http.Request) bool {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return false
+	}
+	token := strings.TrimPrefix(header, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(api.authToken)) == 1
+}
+
+func (api *API) list(ctx context.Context, w http.ResponseWriter, dataType DataType) {
+	entries, err := api.store.List(ctx, dataType)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if entries == nil {
+		entries = []Entry{}
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+func (api *API) get(ctx context.Context, w http.ResponseWriter, dataType DataType, id string, version int) {
+	var entry Entry
+	var err error
+	if version > 0 {
+		entry, err = api.store.GetVersion(ctx, dataType, id, version)
+	} else {
+		entry, err = api.store.Get(ctx, dataType, id)
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, entry)
+}
+
+func (api *API) history(ctx context.Context, w http.ResponseWriter, dataType DataType, id string) {
+	entries, err := api.store.History(ctx, dataType, id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if len(entries) == 0 {
+		writeStoreError(w, stored_requests.NotFoundError{ID: id, DataType: dataType.notFoundName()})
+		return
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+func (api *API) save(ctx context.Context, w http.ResponseWriter, r *http.Request, dataType DataType, id string) {
+	expectedVersion, ok := parseExpectedVersion(w, r)
+	if !ok {
+		return
+	}
+
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		// The MaxBytesReader returns exactly maxBodySize bytes before it fails on a body which is too large.
+		if int64(len(data)) >= api.maxBodySize {
+			writeMessage(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("The request body must be at most %d bytes.", api.maxBodySize))
+		} else {
+			writeMessage(w, http.StatusBadRequest, "Failed to read the request body.")
+		}
+		return
+	}
+	if !json.Valid(data) {
+		writeMessage(w, http.StatusBadRequest, "The request body must be valid JSON.")
+		return
+	}
+	if api.validator != nil {
+		if err := api.validator(dataType, data); err != nil {
+			writeMessage(w, http.StatusBadRequest, fmt.Sprintf("Invalid Stored %s: %v", dataType.notFoundName(), err))
+			return
+		}
+	}
+
+	api.saveAndPublish(ctx, w, r, dataType, id, data, expectedVersion)
+}
+
+// rollback saves an older version of some data as the newest one. The data isn't validated again,
+// since this is meant to undo bad changes as quickly as possible.
+func (api *API) rollback(ctx context.Context, w http.ResponseWriter, r *http.Request, dataType DataType, id string) {
+	expectedVersion, ok := parseExpectedVersion(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Version int `json:"version"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Version <= 0 {
+		writeMessage(w, http.StatusBadRequest, `The request body must look like {"version": 3}.`)
+		return
+	}
+
+	old, err := api.store.GetVersion(ctx, dataType, id, body.Version)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	api.saveAndPublish(ctx, w, r, dataType, id, old.Data, expectedVersion)
+}
+
+func (api *API) saveAndPublish(ctx context.Context, w http.ResponseWriter, r *http.Request, dataType DataType, id string, data json.RawMessage, expectedVersion int) {
+	version, err := api.store.Save(ctx, dataType, id, data, expectedVersion)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	save := events.Save{}
+	if dataType == ImpDataType {
+		save.Imps = map[string]json.RawMessage{id: data}
+	} else {
+		save.Requests = map[string]json.RawMessage{id: data}
+	}
+	for _, producer := range api.currentProducers() {
+		select {
+		case producer.saves <- save:
+		case <-r.Context().Done():
+			writeUnpublished(w, id, version)
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, Entry{ID: id, Version: version})
+}
+
+// parseExpectedVersion reads the If-Match header. If it's invalid, an error response will be written.
+func parseExpectedVersion(w http.ResponseWriter, r *http.Request) (expectedVersion int, ok bool) {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		return 0, true
+	}
+	version, err := strconv.Atoi(strings.Trim(ifMatch, `"`))
+	if err != nil || version <= 0 {
+		writeMessage(w, http.StatusBadRequest, fmt.Sprintf("If-Match must be a positive version number. Got %s", ifMatch))
+		return 0, false
+	}
+	return version, true
+}
+
+func (api *API) delete(ctx context.Context, w http.ResponseWriter, r *http.Request, dataType DataType, id string) {
+	if err := api.store.Delete(ctx, dataType, id); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	invalidation := events.Invalidation{}
+	if dataType == ImpDataType {
+		invalidation.Imps = []string{id}
+	} else {
+		invalidation.Requests = []string{id}
+	}
+	for _, producer := range api.currentProducers() {
+		select {
+		case producer.invalidations <- invalidation:
+		case <-r.Context().Done():
+			writeUnpublished(w, id, 0)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (api *API) currentProducers() []*eventProducer {
+	api.producersLock.Lock()
+	defer api.producersLock.Unlock()
+	return api.producers
+}
+
+// parsePath splits a path like /stored_requests/imps/{id}/{action} into its parts.
+// The id will be empty for list requests, and the action will be empty unless one was given.
+func parsePath(path string) (dataType DataType, id string, action string, ok bool) {
+	if !strings.HasPrefix(path, pathPrefix) {
+		return "", "", "", false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(path, pathPrefix), "/", 3)
+	switch parts[0] {
+	case "requests":
+		dataType = RequestDataType
+	case "imps":
+		dataType = ImpDataType
+	default:
+		return "", "", "", false
+	}
+	if len(parts) > 1 {
+		id = parts[1]
+	}
+	if len(parts) > 2 {
+		action = parts[2]
+	}
+	return dataType, id, action, true
+}
+
+// isValidID makes sure that IDs are safe to use as filenames, since the filesystem backend stores them that way.
+// IDs can't contain an "@" either, since that's how requests refer to specific versions.
+func isValidID(id string) bool {
+	return id != "" && !strings.HasPrefix(id, ".") && !strings.ContainsAny(id, `/\@`)
+}
+
+func writeStoreError(w http.ResponseWriter, err error) {
+	switch err.(type) {
+	case stored_requests.NotFoundError:
+		writeMessage(w, http.StatusNotFound, err.Error())
+	case VersionConflictError:
+		writeMessage(w, http.StatusConflict, err.Error())
+	default:
+		glog.Errorf("Stored Request admin API failed to access the store: %v", err)
+		writeMessage(w, http.StatusInternalServerError, "Failed to access the Stored Request backend.")
+	}
+}
+
+// writeUnpublished responds to a change which was made in the Store, but didn't reach every EventProducer
+// before the request was cancelled. A version of 0 means the data was deleted.
+func writeUnpublished(w http.ResponseWriter, id string, version int) {
+	change := "deleted"
+	if version > 0 {
+		change = fmt.Sprintf("saved as version %d", version)
+	}
+	glog.Warningf("Stored data with ID=%s was %s, but the request was cancelled before every cache was updated.", id, change)
+	writeMessage(w, http.StatusServiceUnavailable, fmt.Sprintf("Stored data with ID=%s was %s, but some caches may not have been updated.", id, change))
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	body, err := json.Marshal(value)
+	if err != nil {
+		writeMessage(w, http.StatusInternalServerError, fmt.Sprintf("Failed to write the response: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+func writeMessage(w http.ResponseWriter, status int, message string) {
+	w.WriteHeader(status)
+	io.WriteString(w, message+"\n")
+}
+
+type eventProducer struct {
+	saves         chan events.Save
+	invalidations chan events.Invalidation
+}
+
+func (p *eventProducer) Saves() <-chan events.Save {
+	return p.saves
+}
+
+func (p *eventProducer) Invalidations() <-chan events.Invalidation {
+	return p.invalidations
+}
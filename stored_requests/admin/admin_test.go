@@ -0,0 +1,304 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prebid/prebid-server/config"
+	"github.com/prebid/prebid-server/stored_requests"
+	"github.com/prebid/prebid-server/stored_requests/caches/memory"
+	"github.com/prebid/prebid-server/stored_requests/events"
+)
+
+const testToken = "secret"
+const testMaxBodySize = 1024
+
+func TestSaveAndDelete(t *testing.T) {
+	api, _, cleanup := newTestAPI(t, nil)
+	defer cleanup()
+	cache, saved, invalidated, stop := listenTo(api)
+	defer stop()
+
+	recorder := doRequest(api, "PUT", "/stored_requests/imps/imp-1", `{"id":"imp-1"}`, nil)
+	assertStatus(t, recorder, http.StatusOK)
+	assertEntry(t, recorder, "imp-1", 1)
+	<-saved
+	_, imps := cache.Get(context.Background(), nil, []string{"imp-1"})
+	if string(imps["imp-1"]) != `{"id":"imp-1"}` {
+		t.Errorf("The cache should have the new Stored Imp. Got %s", string(imps["imp-1"]))
+	}
+
+	recorder = doRequest(api, "PUT", "/stored_requests/imps/imp-1", `{"id":"imp-1","updated":true}`, nil)
+	assertStatus(t, recorder, http.StatusOK)
+	assertEntry(t, recorder, "imp-1", 2)
+	<-saved
+
+	recorder = doRequest(api, "GET", "/stored_requests/imps/imp-1", "", nil)
+	assertStatus(t, recorder, http.StatusOK)
+	var entry Entry
+	if err := json.Unmarshal(recorder.Body.Bytes(), &entry); err != nil {
+		t.Fatalf("Failed to parse the response: %v", err)
+	}
+	if entry.Version != 2 || string(entry.Data) != `{"id":"imp-1","updated":true}` {
+		t.Errorf("Got the wrong Stored Imp: %v", entry)
+	}
+
+	recorder = doRequest(api, "DELETE", "/stored_requests/imps/imp-1", "", nil)
+	assertStatus(t, recorder, http.StatusNoContent)
+	<-invalidated
+	if _, imps := cache.Get(context.Background(), nil, []string{"imp-1"}); len(imps) != 0 {
+		t.Errorf("The cache should not have the deleted Stored Imp. Got %v", imps)
+	}
+
+	recorder = doRequest(api, "GET", "/stored_requests/imps/imp-1", "", nil)
+	assertStatus(t, recorder, http.StatusNotFound)
+	recorder = doRequest(api, "DELETE", "/stored_requests/imps/imp-1", "", nil)
+	assertStatus(t, recorder, http.StatusNotFound)
+}
+
+func TestList(t *testing.T) {
+	api, _, cleanup := newTestAPI(t, nil)
+	defer cleanup()
+
+	recorder := doRequest(api, "GET", "/stored_requests/requests", "", nil)
+	assertStatus(t, recorder, http.StatusOK)
+	if body := strings.TrimSpace(recorder.Body.String()); body != `[{"id":"existing","version":1}]` {
+		t.Errorf("Got the wrong list of Stored Requests: %s", body)
+	}
+
+	recorder = doRequest(api, "GET", "/stored_requests/imps", "", nil)
+	assertStatus(t, recorder, http.StatusOK)
+	if body := strings.TrimSpace(recorder.Body.String()); body != `[]` {
+		t.Errorf("There should be no Stored Imps. Got %s", body)
+	}
+
+	recorder = doRequest(api, "POST", "/stored_requests/imps", "", nil)
+	assertStatus(t, recorder, http.StatusMethodNotAllowed)
+}
+
+func TestVersionConflict(t *testing.T) {
+	api, _, cleanup := newTestAPI(t, nil)
+	defer cleanup()
+	_, saved, _, stop := listenTo(api)
+	defer stop()
+
+	recorder := doRequest(api, "PUT", "/stored_requests/requests/existing", `{"tmax":100}`, map[string]string{"If-Match": "2"})
+	assertStatus(t, recorder, http.StatusConflict)
+
+	recorder = doRequest(api, "PUT", "/stored_requests/requests/existing", `{"tmax":100}`, map[string]string{"If-Match": `"1"`})
+	assertStatus(t, recorder, http.StatusOK)
+	assertEntry(t, recorder, "existing", 2)
+	<-saved
+
+	recorder = doRequest(api, "PUT", "/stored_requests/requests/existing", `{"tmax":200}`, map[string]string{"If-Match": "1"})
+	assertStatus(t, recorder, http.StatusConflict)
+
+	recorder = doRequest(api, "PUT", "/stored_requests/requests/existing", `{"tmax":200}`, map[string]string{"If-Match": "latest"})
+	assertStatus(t, recorder, http.StatusBadRequest)
+}
+
+func TestRollback(t *testing.T) {
+	api, store, cleanup := newTestAPI(t, nil)
+	defer cleanup()
+	cache, saved, _, stop := listenTo(api)
+	defer stop()
+
+	recorder := doRequest(api, "PUT", "/stored_requests/requests/existing", `{"tmax":"broken"}`, nil)
+	assertStatus(t, recorder, http.StatusOK)
+	<-saved
+
+	recorder = doRequest(api, "GET", "/stored_requests/requests/existing/versions", "", nil)
+	assertStatus(t, recorder, http.StatusOK)
+	if body := strings.TrimSpace(recorder.Body.String()); body != `[{"id":"existing","version":1},{"id":"existing","version":2}]` {
+		t.Errorf("Got the wrong history: %s", body)
+	}
+
+	recorder = doRequest(api, "GET", "/stored_requests/requests/existing@1", "", nil)
+	assertStatus(t, recorder, http.StatusOK)
+	var entry Entry
+	if err := json.Unmarshal(recorder.Body.Bytes(), &entry); err != nil {
+		t.Fatalf("Failed to parse the response: %v", err)
+	}
+	if entry.Version != 1 || string(entry.Data) != `{"tmax":50}` {
+		t.Errorf("Got the wrong version of the Stored Request: %v", entry)
+	}
+
+	recorder = doRequest(api, "POST", "/stored_requests/requests/existing/rollback", `{"version":1}`, map[string]string{"If-Match": "2"})
+	assertStatus(t, recorder, http.StatusOK)
+	assertEntry(t, recorder, "existing", 3)
+	<-saved
+	if requests, _ := cache.Get(context.Background(), []string{"existing"}, nil); string(requests["existing"]) != `{"tmax":50}` {
+		t.Errorf("The cache should have the rolled back Stored Request. Got %s", string(requests["existing"]))
+	}
+	if requests, _, _ := store.FetchRequests(context.Background(), []string{"existing", "existing@2"}, nil); string(requests["existing"]) != `{"tmax":50}` || string(requests["existing@2"]) != `{"tmax":"broken"}` {
+		t.Errorf("The store should have the rolled back Stored Request, and keep the broken version. Got %v", requests)
+	}
+
+	assertStatus(t, doRequest(api, "POST", "/stored_requests/requests/existing/rollback", `{"version":9}`, nil), http.StatusNotFound)
+	assertStatus(t, doRequest(api, "POST", "/stored_requests/requests/existing/rollback", `{}`, nil), http.StatusBadRequest)
+	assertStatus(t, doRequest(api, "POST", "/stored_requests/requests/missing/rollback", `{"version":1}`, nil), http.StatusNotFound)
+	assertStatus(t, doRequest(api, "GET", "/stored_requests/requests/missing/versions", "", nil), http.StatusNotFound)
+}
+
+func TestValidation(t *testing.T) {
+	validator := func(dataType DataType, data json.RawMessage) error {
+		if string(data) == `{"bad":true}` {
+			return errors.New("this data is bad")
+		}
+		return nil
+	}
+	api, store, cleanup := newTestAPI(t, validator)
+	defer cleanup()
+
+	recorder := doRequest(api, "PUT", "/stored_requests/requests/new", `{"bad":true}`, nil)
+	assertStatus(t, recorder, http.StatusBadRequest)
+	if !strings.Contains(recorder.Body.String(), "this data is bad") {
+		t.Errorf("The response should explain why the data was rejected. Got %s", recorder.Body.String())
+	}
+
+	recorder = doRequest(api, "PUT", "/stored_requests/requests/new", `{"malformed`, nil)
+	assertStatus(t, recorder, http.StatusBadRequest)
+
+	if _, err := store.Get(context.Background(), RequestDataType, "new"); err == nil {
+		t.Error("Invalid data should not be saved.")
+	}
+}
+
+func TestBadRequests(t *testing.T) {
+	api, _, cleanup := newTestAPI(t, nil)
+	defer cleanup()
+
+	assertStatus(t, doRequest(api, "GET", "/stored_requests/accounts/1", "", nil), http.StatusNotFound)
+	assertStatus(t, doRequest(api, "GET", "/stored_requests/requests/../../secrets", "", nil), http.StatusBadRequest)
+	assertStatus(t, doRequest(api, "PUT", "/stored_requests/requests/.versions", `{}`, nil), http.StatusBadRequest)
+	assertStatus(t, doRequest(api, "POST", "/stored_requests/requests/existing", `{}`, nil), http.StatusMethodNotAllowed)
+	assertStatus(t, doRequest(api, "PUT", "/stored_requests/requests/existing@1", `{}`, nil), http.StatusBadRequest)
+	assertStatus(t, doRequest(api, "GET", "/stored_requests/requests/existing@first", "", nil), http.StatusBadRequest)
+	assertStatus(t, doRequest(api, "GET", "/stored_requests/requests/existing/unknown", "", nil), http.StatusNotFound)
+	assertStatus(t, doRequest(api, "GET", "/stored_requests/requests/existing/rollback", "", nil), http.StatusMethodNotAllowed)
+}
+
+func TestBodySize(t *testing.T) {
+	api, store, cleanup := newTestAPI(t, nil)
+	defer cleanup()
+
+	largeData := `{"padding":"` + strings.Repeat("a", testMaxBodySize) + `"}`
+	assertStatus(t, doRequest(api, "PUT", "/stored_requests/requests/large", largeData, nil), http.StatusRequestEntityTooLarge)
+	if _, err := store.Get(context.Background(), RequestDataType, "large"); err == nil {
+		t.Error("Data which is too large should not be saved.")
+	}
+	assertStatus(t, doRequest(api, "POST", "/stored_requests/requests/existing/rollback", `{"version":1,"padding":"`+largeData, nil), http.StatusBadRequest)
+}
+
+func TestUnpublishedChanges(t *testing.T) {
+	api, store, cleanup := newTestAPI(t, nil)
+	defer cleanup()
+	// Nobody listens to this producer, so the changes can never be published.
+	api.NewEventProducer()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	request := httptest.NewRequest("PUT", "/stored_requests/imps/imp-1", strings.NewReader(`{"id":"imp-1"}`)).WithContext(ctx)
+	request.Header.Set("Authorization", "Bearer "+testToken)
+	recorder := httptest.NewRecorder()
+	api.ServeHTTP(recorder, request)
+	assertStatus(t, recorder, http.StatusServiceUnavailable)
+	if entry, err := store.Get(context.Background(), ImpDataType, "imp-1"); err != nil || entry.Version != 1 {
+		t.Errorf("The Stored Imp should be saved even if it couldn't be published. Got %v, %v", entry, err)
+	}
+
+	request = httptest.NewRequest("DELETE", "/stored_requests/imps/imp-1", nil).WithContext(ctx)
+	request.Header.Set("Authorization", "Bearer "+testToken)
+	recorder = httptest.NewRecorder()
+	api.ServeHTTP(recorder, request)
+	assertStatus(t, recorder, http.StatusServiceUnavailable)
+}
+
+func TestAuthorization(t *testing.T) {
+	api, _, cleanup := newTestAPI(t, nil)
+	defer cleanup()
+
+	request := httptest.NewRequest("GET", "/stored_requests/requests", nil)
+	recorder := httptest.NewRecorder()
+	api.ServeHTTP(recorder, request)
+	assertStatus(t, recorder, http.StatusUnauthorized)
+
+	request = httptest.NewRequest("GET", "/stored_requests/requests", nil)
+	request.Header.Set("Authorization", "Bearer wrong")
+	recorder = httptest.NewRecorder()
+	api.ServeHTTP(recorder, request)
+	assertStatus(t, recorder, http.StatusUnauthorized)
+}
+
+func newTestAPI(t *testing.T, validator Validator) (*API, *FileStore, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "stored-requests")
+	if err != nil {
+		t.Fatalf("Failed to make a temp dir: %v", err)
+	}
+	os.Mkdir(dir+"/stored_requests", 0755)
+	os.Mkdir(dir+"/stored_imps", 0755)
+	if err := ioutil.WriteFile(dir+"/stored_requests/existing.json", []byte(`{"tmax":50}`), 0644); err != nil {
+		t.Fatalf("Failed to write a Stored Request: %v", err)
+	}
+
+	store, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("Failed to make the FileStore: %v", err)
+	}
+	return NewAdminAPI(store, validator, testToken, time.Second, testMaxBodySize), store, func() { os.RemoveAll(dir) }
+}
+
+// listenTo hooks a cache up to the API, and returns channels which signal when saves and invalidations finish.
+func listenTo(api *API) (cache stored_requests.Cache, saved chan struct{}, invalidated chan struct{}, stop func()) {
+	cache = memory.NewCache(&config.InMemoryCache{
+		RequestCacheSize: 256 * 1024,
+		ImpCacheSize:     256 * 1024,
+		TTL:              -1,
+	})
+	saved = make(chan struct{})
+	invalidated = make(chan struct{})
+	listener := events.NewEventListener(
+		func() { saved <- struct{}{} },
+		func() { invalidated <- struct{}{} },
+	)
+	go listener.Listen(cache, api.NewEventProducer())
+	return cache, saved, invalidated, listener.Stop
+}
+
+func doRequest(api *API, method string, path string, body string, headers map[string]string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	request.Header.Set("Authorization", "Bearer "+testToken)
+	for key, value := range headers {
+		request.Header.Set(key, value)
+	}
+	recorder := httptest.NewRecorder()
+	api.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func assertStatus(t *testing.T, recorder *httptest.ResponseRecorder, expected int) {
+	t.Helper()
+	if recorder.Code != expected {
+		t.Errorf("Expected a %d response. Got %d: %s", expected, recorder.Code, recorder.Body.String())
+	}
+}
+
+func assertEntry(t *testing.T, recorder *httptest.ResponseRecorder, id string, version int) {
+	t.Helper()
+	var entry Entry
+	if err := json.Unmarshal(recorder.Body.Bytes(), &entry); err != nil {
+		t.Fatalf("Failed to parse the response: %v", err)
+	}
+	if entry.ID != id || entry.Version != version {
+		t.Errorf("Expected %s at version %d. Got %s at version %d", id, version, entry.ID, entry.Version)
+	}
+}
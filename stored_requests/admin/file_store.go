@@ -0,0 +1,292 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prebid/prebid-server/stored_requests"
+)
+
+// versionsFile holds the version of every file in a directory. It doesn't end in .json, so the file_fetcher skips it.
+const versionsFile = ".versions"
+
+// NewFileStore loads all the data from a directory laid out like the one file_fetcher uses.
+//
+// Every version is also saved as "{id}@{version}.json", so that both the FileStore and the file_fetcher
+// can serve requests for a specific version.
+//
+// The returned store is also a stored_requests.Fetcher. It should be used in place of the file_fetcher,
+// so that changes made through the admin API are served even if they get evicted from the caches.
+func NewFileStore(directory string) (*FileStore, error) {
+	store := &FileStore{
+		directories: map[DataType]string{
+			RequestDataType: filepath.Join(directory, "stored_requests"),
+			ImpDataType:     filepath.Join(directory, "stored_imps"),
+		},
+		data:     make(map[DataType]map[string]json.RawMessage, 2),
+		versions: make(map[DataType]map[string]int, 2),
+	}
+	for dataType, dir := range store.directories {
+		data, versions, err := loadDirectory(dir)
+		if err != nil {
+			return nil, err
+		}
+		store.data[dataType] = data
+		store.versions[dataType] = versions
+	}
+	return store, nil
+}
+
+// FileStore is a Store which saves data to the filesystem. This should be instantiated through the NewFileStore() function.
+type FileStore struct {
+	directories map[DataType]string
+
+	lock     sync.RWMutex
+	data     map[DataType]map[string]json.RawMessage
+	versions map[DataType]map[string]int
+}
+
+func (store *FileStore) FetchRequests(ctx context.Context, requestIDs []string, impIDs []string) (map[string]json.RawMessage, map[string]json.RawMessage, []error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	requestData, errs := store.collect(RequestDataType, requestIDs, nil)
+	impData, errs := store.collect(ImpDataType, impIDs, errs)
+	return requestData, impData, errs
+}
+
+func (store *FileStore) collect(dataType DataType, ids []string, errs []error) (map[string]json.RawMessage, []error) {
+	found := make(map[string]json.RawMessage, len(ids))
+	for _, id := range ids {
+		if data, ok := store.lookup(dataType, id); ok {
+			found[id] = data
+		} else {
+			errs = append(errs, stored_requests.NotFoundError{
+				ID:       id,
+				DataType: dataType.notFoundName(),
+			})
+		}
+	}
+	return found, errs
+}
+
+// lookup finds the data for an ID which may include a version.
+func (store *FileStore) lookup(dataType DataType, id string) (json.RawMessage, bool) {
+	if data, ok := store.data[dataType][id]; ok {
+		return data, true
+	}
+	if baseID, version := stored_requests.ParseVersionedID(id); version > 0 {
+		return store.versionData(dataType, baseID, version)
+	}
+	return nil, false
+}
+
+func (store *FileStore) List(ctx context.Context, dataType DataType) ([]Entry, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	entries := make([]Entry, 0, len(store.data[dataType]))
+	for id := range store.data[dataType] {
+		if strings.Contains(id, "@") {
+			continue
+		}
+		entries = append(entries, Entry{
+			ID:      id,
+			Version: store.versions[dataType][id],
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ID < entries[j].ID
+	})
+	return entries, nil
+}
+
+func (store *FileStore) Get(ctx context.Context, dataType DataType, id string) (Entry, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	data, ok := store.data[dataType][id]
+	if !ok {
+		return Entry{}, stored_requests.NotFoundError{ID: id, DataType: dataType.notFoundName()}
+	}
+	return Entry{
+		ID:      id,
+		Version: store.versions[dataType][id],
+		Data:    data,
+	}, nil
+}
+
+func (store *FileStore) GetVersion(ctx context.Context, dataType DataType, id string, version int) (Entry, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	data, ok := store.versionData(dataType, id, version)
+	if !ok {
+		return Entry{}, stored_requests.NotFoundError{ID: stored_requests.VersionedID(id, version), DataType: dataType.notFoundName()}
+	}
+	return Entry{
+		ID:      id,
+		Version: version,
+		Data:    data,
+	}, nil
+}
+
+// versionData finds the data for a specific version. Data which was written before the admin API was used
+// won't have a history file yet, so the current data counts as its current version too.
+func (store *FileStore) versionData(dataType DataType, id string, version int) (json.RawMessage, bool) {
+	if data, ok := store.data[dataType][stored_requests.VersionedID(id, version)]; ok {
+		return data, true
+	}
+	if data, ok := store.data[dataType][id]; ok && store.versions[dataType][id] == version {
+		return data, true
+	}
+	return nil, false
+}
+
+func (store *FileStore) History(ctx context.Context, dataType DataType, id string) ([]Entry, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	var entries []Entry
+	for i := 1; i <= store.versions[dataType][id]; i++ {
+		if _, ok := store.versionData(dataType, id, i); ok {
+			entries = append(entries, Entry{ID: id, Version: i})
+		}
+	}
+	return entries, nil
+}
+
+func (store *FileStore) Save(ctx context.Context, dataType DataType, id string, data json.RawMessage, expectedVersion int) (int, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	version := store.versions[dataType][id]
+	if expectedVersion > 0 && expectedVersion != version {
+		return 0, VersionConflictError{ID: id, Expected: expectedVersion}
+	}
+
+	dir := store.directories[dataType]
+	if oldData, ok := store.data[dataType][id]; ok {
+		if err := store.writeHistory(dataType, id, version, oldData); err != nil {
+			return 0, err
+		}
+	}
+	if err := store.writeHistory(dataType, id, version+1, data); err != nil {
+		return 0, err
+	}
+	if err := writeFileAtomically(filepath.Join(dir, id+".json"), data); err != nil {
+		return 0, err
+	}
+	store.data[dataType][id] = data
+	store.versions[dataType][id] = version + 1
+	if err := store.writeVersions(dataType); err != nil {
+		return 0, err
+	}
+	return version + 1, nil
+}
+
+// writeHistory saves a copy of some data for the given version, unless one exists already.
+func (store *FileStore) writeHistory(dataType DataType, id string, version int, data json.RawMessage) error {
+	versionedID := stored_requests.VersionedID(id, version)
+	if _, ok := store.data[dataType][versionedID]; ok {
+		return nil
+	}
+	if err := writeFileAtomically(filepath.Join(store.directories[dataType], versionedID+".json"), data); err != nil {
+		return err
+	}
+	store.data[dataType][versionedID] = data
+	return nil
+}
+
+func (store *FileStore) Delete(ctx context.Context, dataType DataType, id string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	data, ok := store.data[dataType][id]
+	if !ok {
+		return stored_requests.NotFoundError{ID: id, DataType: dataType.notFoundName()}
+	}
+	if err := store.writeHistory(dataType, id, store.versions[dataType][id], data); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(store.directories[dataType], id+".json")); err != nil {
+		return err
+	}
+	// The version is kept so that the history doesn't get overwritten if the ID is used again.
+	delete(store.data[dataType], id)
+	return nil
+}
+
+func (store *FileStore) writeVersions(dataType DataType) error {
+	versions, err := json.Marshal(store.versions[dataType])
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(filepath.Join(store.directories[dataType], versionsFile), versions)
+}
+
+// loadDirectory reads every {id}.json and {id}@{version}.json file in the directory, along with the current versions.
+// Files which have never been saved through the admin API are at version 1.
+func loadDirectory(dir string) (map[string]json.RawMessage, map[string]int, error) {
+	fileInfos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	var savedVersions map[string]int
+	if versionData, err := ioutil.ReadFile(filepath.Join(dir, versionsFile)); err == nil {
+		if err := json.Unmarshal(versionData, &savedVersions); err != nil {
+			return nil, nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+
+	data := make(map[string]json.RawMessage, len(fileInfos))
+	versions := make(map[string]int, len(fileInfos))
+	for id, version := range savedVersions {
+		versions[id] = version
+	}
+	for _, fileInfo := range fileInfos {
+		if fileInfo.IsDir() || !strings.HasSuffix(fileInfo.Name(), ".json") {
+			continue
+		}
+		fileData, err := ioutil.ReadFile(filepath.Join(dir, fileInfo.Name()))
+		if err != nil {
+			return nil, nil, err
+		}
+		id := strings.TrimSuffix(fileInfo.Name(), ".json")
+		data[id] = json.RawMessage(fileData)
+		if versions[id] <= 0 && !strings.Contains(id, "@") {
+			versions[id] = 1
+		}
+	}
+	return data, versions, nil
+}
+
+// writeFileAtomically makes sure that the file_fetcher never sees a half-written file, even if the server crashes.
+func writeFileAtomically(filename string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
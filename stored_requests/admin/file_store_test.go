@@ -0,0 +1,119 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/prebid/prebid-server/stored_requests"
+)
+
+func TestFileStorePersistence(t *testing.T) {
+	_, store, cleanup := newTestAPI(t, nil)
+	defer cleanup()
+	ctx := context.Background()
+
+	if _, err := store.Save(ctx, ImpDataType, "imp-1", json.RawMessage(`{"id":"imp-1"}`), 0); err != nil {
+		t.Fatalf("Unexpected error saving a Stored Imp: %v", err)
+	}
+	if version, err := store.Save(ctx, RequestDataType, "existing", json.RawMessage(`{"tmax":100}`), 1); err != nil || version != 2 {
+		t.Fatalf("Expected the Stored Request to be saved at version 2. Got %d, %v", version, err)
+	}
+
+	// Reloading the directory should pick up the files and versions which were saved.
+	reloaded, err := NewFileStore(store.directories[RequestDataType] + "/..")
+	if err != nil {
+		t.Fatalf("Failed to reload the FileStore: %v", err)
+	}
+	entry, err := reloaded.Get(ctx, RequestDataType, "existing")
+	if err != nil || entry.Version != 2 || string(entry.Data) != `{"tmax":100}` {
+		t.Errorf("The updated Stored Request wasn't reloaded. Got %v, %v", entry, err)
+	}
+	entry, err = reloaded.Get(ctx, ImpDataType, "imp-1")
+	if err != nil || entry.Version != 1 || string(entry.Data) != `{"id":"imp-1"}` {
+		t.Errorf("The new Stored Imp wasn't reloaded. Got %v, %v", entry, err)
+	}
+
+	if err := store.Delete(ctx, ImpDataType, "imp-1"); err != nil {
+		t.Fatalf("Unexpected error deleting a Stored Imp: %v", err)
+	}
+	if _, err := os.Stat(store.directories[ImpDataType] + "/imp-1.json"); !os.IsNotExist(err) {
+		t.Errorf("The deleted Stored Imp's file should be removed. Got %v", err)
+	}
+}
+
+func TestFileStoreFetcher(t *testing.T) {
+	_, store, cleanup := newTestAPI(t, nil)
+	defer cleanup()
+
+	if _, err := store.Save(context.Background(), ImpDataType, "imp-1", json.RawMessage(`{"id":"imp-1"}`), 0); err != nil {
+		t.Fatalf("Unexpected error saving a Stored Imp: %v", err)
+	}
+
+	requests, imps, errs := store.FetchRequests(context.Background(), []string{"existing", "missing"}, []string{"imp-1"})
+	if string(requests["existing"]) != `{"tmax":50}` {
+		t.Errorf("Expected the existing Stored Request. Got %s", string(requests["existing"]))
+	}
+	if string(imps["imp-1"]) != `{"id":"imp-1"}` {
+		t.Errorf("Expected the saved Stored Imp. Got %s", string(imps["imp-1"]))
+	}
+	if len(errs) != 1 {
+		t.Fatalf("Expected one error for the missing Stored Request. Got %v", errs)
+	}
+	if notFound, ok := errs[0].(stored_requests.NotFoundError); !ok || notFound.ID != "missing" || notFound.DataType != "Request" {
+		t.Errorf("Expected a NotFoundError for the missing Stored Request. Got %v", errs[0])
+	}
+}
+
+func TestFileStoreHistory(t *testing.T) {
+	_, store, cleanup := newTestAPI(t, nil)
+	defer cleanup()
+	ctx := context.Background()
+
+	// "existing" was written before the admin API, so it has no history file for version 1 yet.
+	if entries, err := store.History(ctx, RequestDataType, "existing"); err != nil || len(entries) != 1 || entries[0].Version != 1 {
+		t.Errorf("Data without a history file should count as version 1. Got %v, %v", entries, err)
+	}
+	if _, err := store.Save(ctx, RequestDataType, "existing", json.RawMessage(`{"tmax":100}`), 0); err != nil {
+		t.Fatalf("Unexpected error saving a Stored Request: %v", err)
+	}
+	if err := store.Delete(ctx, RequestDataType, "existing"); err != nil {
+		t.Fatalf("Unexpected error deleting a Stored Request: %v", err)
+	}
+
+	// Old versions should still be served after the ID is deleted.
+	for _, versionedID := range []string{"existing@1", "existing@2"} {
+		if _, err := os.Stat(store.directories[RequestDataType] + "/" + versionedID + ".json"); err != nil {
+			t.Errorf("Expected a history file for %s. Got %v", versionedID, err)
+		}
+	}
+	requests, _, errs := store.FetchRequests(ctx, []string{"existing@1", "existing@2", "existing"}, nil)
+	if string(requests["existing@1"]) != `{"tmax":50}` || string(requests["existing@2"]) != `{"tmax":100}` {
+		t.Errorf("Old versions should be fetchable after a delete. Got %v", requests)
+	}
+	if len(errs) != 1 {
+		t.Errorf("The deleted ID should not be fetchable. Got %v", errs)
+	}
+
+	// Re-using the ID shouldn't overwrite its history.
+	if version, err := store.Save(ctx, RequestDataType, "existing", json.RawMessage(`{"tmax":150}`), 0); err != nil || version != 3 {
+		t.Errorf("Expected a re-created ID to continue at version 3. Got %d, %v", version, err)
+	}
+	if entries, err := store.List(ctx, RequestDataType); err != nil || len(entries) != 1 || entries[0].ID != "existing" {
+		t.Errorf("History files should not be listed. Got %v, %v", entries, err)
+	}
+}
+
+func TestFileStoreBadDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stored-requests")
+	if err != nil {
+		t.Fatalf("Failed to make a temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := NewFileStore(dir); err == nil {
+		t.Error("NewFileStore should fail if the stored_requests and stored_imps directories don't exist.")
+	}
+}
@@ -0,0 +1,169 @@
+package admin
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/golang/glog"
+	"github.com/prebid/prebid-server/stored_requests"
+)
+
+// NewPostgresStore makes a Store which saves data to the stored_requests and stored_imps tables.
+// Every version is also saved to the stored_requests_history and stored_imps_history tables.
+// See docs/developers/stored-requests.md for the schema which it expects.
+func NewPostgresStore(db *sql.DB) Store {
+	if db == nil {
+		glog.Fatalf("The Postgres Stored Request admin Store requires a database connection. Please report this as a bug.")
+	}
+	return &postgresStore{
+		db: db,
+		tables: map[DataType]postgresTable{
+			RequestDataType: {name: "stored_requests", history: "stored_requests_history", dataColumn: "requestData"},
+			ImpDataType:     {name: "stored_imps", history: "stored_imps_history", dataColumn: "impData"},
+		},
+	}
+}
+
+type postgresTable struct {
+	name       string
+	history    string
+	dataColumn string
+}
+
+// postgresStore is a Store backed by Postgres. This should be instantiated through the NewPostgresStore() function.
+type postgresStore struct {
+	db     *sql.DB
+	tables map[DataType]postgresTable
+}
+
+func (store *postgresStore) List(ctx context.Context, dataType DataType) ([]Entry, error) {
+	table := store.tables[dataType]
+	rows, err := store.db.QueryContext(ctx, fmt.Sprintf("SELECT id, version FROM %s ORDER BY id", table.name))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			glog.Errorf("error closing DB connection: %v", err)
+		}
+	}()
+
+	var entries []Entry
+	for rows.Next() {
+		var entry Entry
+		if err := rows.Scan(&entry.ID, &entry.Version); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func (store *postgresStore) Get(ctx context.Context, dataType DataType, id string) (Entry, error) {
+	table := store.tables[dataType]
+	entry := Entry{ID: id}
+	var data []byte
+	err := store.db.QueryRowContext(ctx, fmt.Sprintf("SELECT %s, version FROM %s WHERE id = $1", table.dataColumn, table.name), id).Scan(&data, &entry.Version)
+	if err == sql.ErrNoRows {
+		return Entry{}, stored_requests.NotFoundError{ID: id, DataType: dataType.notFoundName()}
+	}
+	if err != nil {
+		return Entry{}, err
+	}
+	entry.Data = data
+	return entry, nil
+}
+
+func (store *postgresStore) GetVersion(ctx context.Context, dataType DataType, id string, version int) (Entry, error) {
+	table := store.tables[dataType]
+	var data []byte
+	err := store.db.QueryRowContext(ctx, fmt.Sprintf("SELECT %s FROM %s WHERE id = $1 AND version = $2", table.dataColumn, table.history), id, version).Scan(&data)
+	if err == sql.ErrNoRows {
+		return Entry{}, stored_requests.NotFoundError{ID: stored_requests.VersionedID(id, version), DataType: dataType.notFoundName()}
+	}
+	if err != nil {
+		return Entry{}, err
+	}
+	return Entry{ID: id, Version: version, Data: data}, nil
+}
+
+func (store *postgresStore) History(ctx context.Context, dataType DataType, id string) ([]Entry, error) {
+	table := store.tables[dataType]
+	rows, err := store.db.QueryContext(ctx, fmt.Sprintf("SELECT version FROM %s WHERE id = $1 ORDER BY version", table.history), id)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			glog.Errorf("error closing DB connection: %v", err)
+		}
+	}()
+
+	var entries []Entry
+	for rows.Next() {
+		entry := Entry{ID: id}
+		if err := rows.Scan(&entry.Version); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func (store *postgresStore) Save(ctx context.Context, dataType DataType, id string, data json.RawMessage, expectedVersion int) (version int, err error) {
+	table := store.tables[dataType]
+	tx, err := store.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			if rollbackErr := tx.Rollback(); rollbackErr != nil && rollbackErr != sql.ErrTxDone {
+				glog.Errorf("Failed to roll back a Stored Request save: %v", rollbackErr)
+			}
+		}
+	}()
+
+	var row *sql.Row
+	if expectedVersion > 0 {
+		query := fmt.Sprintf("UPDATE %s SET %s = $2, version = version + 1, last_updated = now() WHERE id = $1 AND version = $3 RETURNING version", table.name, table.dataColumn)
+		row = tx.QueryRowContext(ctx, query, id, []byte(data), expectedVersion)
+	} else {
+		// If the ID was deleted before, keep counting from its last version so that the history stays intact.
+		query := fmt.Sprintf("INSERT INTO %[1]s (id, %[2]s, version, last_updated) VALUES ($1, $2, (SELECT COALESCE(MAX(version), 0) + 1 FROM %[3]s WHERE id = $1), now()) "+
+			"ON CONFLICT (id) DO UPDATE SET %[2]s = EXCLUDED.%[2]s, version = %[1]s.version + 1, last_updated = now() RETURNING version", table.name, table.dataColumn, table.history)
+		row = tx.QueryRowContext(ctx, query, id, []byte(data))
+	}
+
+	if err = row.Scan(&version); err != nil {
+		if err == sql.ErrNoRows {
+			err = VersionConflictError{ID: id, Expected: expectedVersion}
+		}
+		return 0, err
+	}
+
+	historyQuery := fmt.Sprintf("INSERT INTO %s (id, version, %s, created) VALUES ($1, $2, $3, now())", table.history, table.dataColumn)
+	if _, err = tx.ExecContext(ctx, historyQuery, id, version, []byte(data)); err != nil {
+		return 0, err
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+func (store *postgresStore) Delete(ctx context.Context, dataType DataType, id string) error {
+	table := store.tables[dataType]
+	result, err := store.db.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE id = $1", table.name), id)
+	if err != nil {
+		return err
+	}
+	if deleted, err := result.RowsAffected(); err != nil {
+		return err
+	} else if deleted == 0 {
+		return stored_requests.NotFoundError{ID: id, DataType: dataType.notFoundName()}
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/prebid/prebid-server/stored_requests"
+)
+
+func TestPostgresList(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, version FROM stored_imps ORDER BY id")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "version"}).AddRow("imp-1", 3).AddRow("imp-2", 1))
+
+	entries, err := NewPostgresStore(db).List(context.Background(), ImpDataType)
+	if err != nil {
+		t.Fatalf("Unexpected error listing Stored Imps: %v", err)
+	}
+	if len(entries) != 2 || entries[0].ID != "imp-1" || entries[0].Version != 3 || entries[1].ID != "imp-2" {
+		t.Errorf("Got the wrong list of Stored Imps: %v", entries)
+	}
+	assertExpectationsMet(t, mock)
+}
+
+func TestPostgresGet(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	query := regexp.QuoteMeta("SELECT requestData, version FROM stored_requests WHERE id = $1")
+	mock.ExpectQuery(query).WithArgs("req-1").
+		WillReturnRows(sqlmock.NewRows([]string{"requestData", "version"}).AddRow(`{"tmax":50}`, 2))
+	mock.ExpectQuery(query).WithArgs("missing").
+		WillReturnRows(sqlmock.NewRows([]string{"requestData", "version"}))
+
+	store := NewPostgresStore(db)
+	entry, err := store.Get(context.Background(), RequestDataType, "req-1")
+	if err != nil || entry.Version != 2 || string(entry.Data) != `{"tmax":50}` {
+		t.Errorf("Got the wrong Stored Request: %v, %v", entry, err)
+	}
+	if _, err := store.Get(context.Background(), RequestDataType, "missing"); err == nil {
+		t.Error("Expected an error for a missing Stored Request.")
+	} else if _, ok := err.(stored_requests.NotFoundError); !ok {
+		t.Errorf("Missing Stored Requests should return a NotFoundError. Got %v", err)
+	}
+	assertExpectationsMet(t, mock)
+}
+
+func TestPostgresGetVersion(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	query := regexp.QuoteMeta("SELECT impData FROM stored_imps_history WHERE id = $1 AND version = $2")
+	mock.ExpectQuery(query).WithArgs("imp-1", 2).
+		WillReturnRows(sqlmock.NewRows([]string{"impData"}).AddRow(`{"id":"old"}`))
+	mock.ExpectQuery(query).WithArgs("imp-1", 9).
+		WillReturnRows(sqlmock.NewRows([]string{"impData"}))
+
+	store := NewPostgresStore(db)
+	entry, err := store.GetVersion(context.Background(), ImpDataType, "imp-1", 2)
+	if err != nil || entry.Version != 2 || string(entry.Data) != `{"id":"old"}` {
+		t.Errorf("Got the wrong version of the Stored Imp: %v, %v", entry, err)
+	}
+	if _, err := store.GetVersion(context.Background(), ImpDataType, "imp-1", 9); err == nil {
+		t.Error("Expected an error for a missing version.")
+	} else if notFound, ok := err.(stored_requests.NotFoundError); !ok || notFound.ID != "imp-1@9" {
+		t.Errorf("Missing versions should return a NotFoundError for imp-1@9. Got %v", err)
+	}
+	assertExpectationsMet(t, mock)
+}
+
+func TestPostgresHistory(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT version FROM stored_requests_history WHERE id = $1 ORDER BY version")).WithArgs("req-1").
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(1).AddRow(2))
+
+	entries, err := NewPostgresStore(db).History(context.Background(), RequestDataType, "req-1")
+	if err != nil {
+		t.Fatalf("Unexpected error reading the history: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Version != 1 || entries[1].Version != 2 || entries[1].ID != "req-1" {
+		t.Errorf("Got the wrong history: %v", entries)
+	}
+	assertExpectationsMet(t, mock)
+}
+
+func TestPostgresSave(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	data := json.RawMessage(`{"id":"imp-1"}`)
+	history := regexp.QuoteMeta("INSERT INTO stored_imps_history (id, version, impData, created) VALUES ($1, $2, $3, now())")
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("INSERT INTO stored_imps (id, impData, version, last_updated) VALUES ($1, $2, (SELECT COALESCE(MAX(version), 0) + 1 FROM stored_imps_history WHERE id = $1), now()) ON CONFLICT (id)")).
+		WithArgs("imp-1", []byte(data)).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(4))
+	mock.ExpectExec(history).WithArgs("imp-1", 4, []byte(data)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	update := regexp.QuoteMeta("UPDATE stored_imps SET impData = $2, version = version + 1, last_updated = now() WHERE id = $1 AND version = $3 RETURNING version")
+	mock.ExpectBegin()
+	mock.ExpectQuery(update).WithArgs("imp-1", []byte(data), 4).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(5))
+	mock.ExpectExec(history).WithArgs("imp-1", 5, []byte(data)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(update).WithArgs("imp-1", []byte(data), 4).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}))
+	mock.ExpectRollback()
+
+	store := NewPostgresStore(db)
+	if version, err := store.Save(context.Background(), ImpDataType, "imp-1", data, 0); err != nil || version != 4 {
+		t.Errorf("Expected the Stored Imp to be saved at version 4. Got %d, %v", version, err)
+	}
+	if version, err := store.Save(context.Background(), ImpDataType, "imp-1", data, 4); err != nil || version != 5 {
+		t.Errorf("Expected the Stored Imp to be saved at version 5. Got %d, %v", version, err)
+	}
+	if _, err := store.Save(context.Background(), ImpDataType, "imp-1", data, 4); err == nil {
+		t.Error("Expected a conflict when saving over an old version.")
+	} else if _, ok := err.(VersionConflictError); !ok {
+		t.Errorf("Saving over an old version should return a VersionConflictError. Got %v", err)
+	}
+	assertExpectationsMet(t, mock)
+}
+
+func TestPostgresDelete(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	query := regexp.QuoteMeta("DELETE FROM stored_requests WHERE id = $1")
+	mock.ExpectExec(query).WithArgs("req-1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(query).WithArgs("missing").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	store := NewPostgresStore(db)
+	if err := store.Delete(context.Background(), RequestDataType, "req-1"); err != nil {
+		t.Errorf("Unexpected error deleting a Stored Request: %v", err)
+	}
+	if err := store.Delete(context.Background(), RequestDataType, "missing"); err == nil {
+		t.Error("Expected an error deleting a missing Stored Request.")
+	} else if _, ok := err.(stored_requests.NotFoundError); !ok {
+		t.Errorf("Deleting a missing Stored Request should return a NotFoundError. Got %v", err)
+	}
+	assertExpectationsMet(t, mock)
+}
+
+func assertExpectationsMet(t *testing.T, mock sqlmock.Sqlmock) {
+	t.Helper()
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("sqlmock expectations were not met: %v", err)
+	}
+}
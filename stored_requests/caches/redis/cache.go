@@ -0,0 +1,107 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/golang/glog"
+	"github.com/prebid/prebid-server/stored_requests"
+)
+
+// NewCache returns a Cache which saves data in Redis. Since the data lives outside the process,
+// every PBS instance which points at the same server can use the values fetched by any of them.
+//
+// Stored Requests are saved at "{keyPrefix}request:{id}" and Stored Imps at "{keyPrefix}imp:{id}".
+// Values expire after the ttl. For no TTL, use ttl <= 0.
+//
+// Redis errors are logged, and treated like cache misses.
+func NewCache(client *redis.Client, keyPrefix string, ttl time.Duration) stored_requests.Cache {
+	if ttl < 0 {
+		ttl = 0
+	}
+	glog.Infof("Using a Stored Request Redis cache. Key prefix: %s. TTL: %v.", keyPrefix, ttl)
+	return &cache{
+		client:        client,
+		requestPrefix: keyPrefix + "request:",
+		impPrefix:     keyPrefix + "imp:",
+		ttl:           ttl,
+	}
+}
+
+type cache struct {
+	client        *redis.Client
+	requestPrefix string
+	impPrefix     string
+	ttl           time.Duration
+}
+
+func (c *cache) Get(ctx context.Context, requestIDs []string, impIDs []string) (requestData map[string]json.RawMessage, impData map[string]json.RawMessage) {
+	requestData = make(map[string]json.RawMessage, len(requestIDs))
+	impData = make(map[string]json.RawMessage, len(impIDs))
+
+	keys := c.makeKeys(requestIDs, impIDs)
+	if len(keys) == 0 {
+		return
+	}
+
+	values, err := c.client.WithContext(ctx).MGet(keys...).Result()
+	if err != nil {
+		glog.Errorf("Failed to get Stored Requests from Redis: %v", err)
+		return
+	}
+
+	doGet(requestData, requestIDs, values[:len(requestIDs)])
+	doGet(impData, impIDs, values[len(requestIDs):])
+	return
+}
+
+func doGet(data map[string]json.RawMessage, ids []string, values []interface{}) {
+	for i, id := range ids {
+		// MGET returns nil for keys which don't exist
+		if value, ok := values[i].(string); ok {
+			data[id] = json.RawMessage(value)
+		}
+	}
+}
+
+func (c *cache) Save(ctx context.Context, requestData map[string]json.RawMessage, impData map[string]json.RawMessage) {
+	if len(requestData) == 0 && len(impData) == 0 {
+		return
+	}
+
+	pipe := c.client.WithContext(ctx).Pipeline()
+	defer pipe.Close()
+	for id, data := range requestData {
+		pipe.Set(c.requestPrefix+id, []byte(data), c.ttl)
+	}
+	for id, data := range impData {
+		pipe.Set(c.impPrefix+id, []byte(data), c.ttl)
+	}
+	if _, err := pipe.Exec(); err != nil {
+		glog.Errorf("Failed to save Stored Requests to Redis: %v", err)
+	}
+}
+
+func (c *cache) Invalidate(ctx context.Context, requestIDs []string, impIDs []string) {
+	keys := c.makeKeys(requestIDs, impIDs)
+	if len(keys) == 0 {
+		return
+	}
+
+	if err := c.client.WithContext(ctx).Del(keys...).Err(); err != nil {
+		glog.Errorf("Failed to invalidate Stored Requests in Redis: %v", err)
+	}
+}
+
+func (c *cache) makeKeys(requestIDs []string, impIDs []string) []string {
+	keys := make([]string, 0, len(requestIDs)+len(impIDs))
+	for _, id := range requestIDs {
+		keys = append(keys, c.requestPrefix+id)
+	}
+	for _, id := range impIDs {
+		keys = append(keys, c.impPrefix+id)
+	}
+	return keys
+}
@@ -0,0 +1,110 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/go-redis/redis"
+	"github.com/prebid/prebid-server/stored_requests"
+	"github.com/prebid/prebid-server/stored_requests/caches/cachestest"
+)
+
+func TestRedisRobustness(t *testing.T) {
+	server := newServer(t)
+	defer server.Close()
+
+	cachestest.AssertCacheRobustness(t, func() stored_requests.Cache {
+		server.FlushAll()
+		return NewCache(newClient(server), "test:", 0)
+	})
+}
+
+func TestSharedCache(t *testing.T) {
+	server := newServer(t)
+	defer server.Close()
+
+	first := NewCache(newClient(server), "test:", 0)
+	second := NewCache(newClient(server), "test:", 0)
+	amp := NewCache(newClient(server), "test:amp:", 0)
+
+	first.Save(context.Background(), map[string]json.RawMessage{"req": json.RawMessage(`{"req":true}`)}, nil)
+
+	reqData, _ := second.Get(context.Background(), []string{"req"}, nil)
+	assertHasValue(t, reqData, "req", `{"req":true}`)
+	if !server.Exists("test:request:req") {
+		t.Errorf(`The Stored Request should be saved at "test:request:req"`)
+	}
+
+	ampData, _ := amp.Get(context.Background(), []string{"req"}, nil)
+	if len(ampData) != 0 {
+		t.Errorf("Caches with different key prefixes should not share data. Got %v", ampData)
+	}
+
+	second.Invalidate(context.Background(), []string{"req"}, nil)
+	reqData, _ = first.Get(context.Background(), []string{"req"}, nil)
+	if len(reqData) != 0 {
+		t.Errorf("An invalidation from one instance should reach the others. Got %v", reqData)
+	}
+}
+
+func TestTTL(t *testing.T) {
+	server := newServer(t)
+	defer server.Close()
+
+	cache := NewCache(newClient(server), "test:", time.Minute)
+	cache.Save(context.Background(), nil, map[string]json.RawMessage{"imp": json.RawMessage(`{}`)})
+	if ttl := server.TTL("test:imp:imp"); ttl != time.Minute {
+		t.Errorf("Saved values should expire after the TTL. Got %v", ttl)
+	}
+
+	server.FastForward(2 * time.Minute)
+	_, impData := cache.Get(context.Background(), nil, []string{"imp"})
+	if len(impData) != 0 {
+		t.Errorf("Expired values should not be returned. Got %v", impData)
+	}
+}
+
+func TestUnavailable(t *testing.T) {
+	server := newServer(t)
+	cache := NewCache(newClient(server), "test:", 0)
+	server.Close()
+
+	cache.Save(context.Background(), map[string]json.RawMessage{"req": json.RawMessage(`{}`)}, nil)
+	cache.Invalidate(context.Background(), []string{"req"}, nil)
+	reqData, impData := cache.Get(context.Background(), []string{"req"}, []string{"imp"})
+	if reqData == nil || impData == nil {
+		t.Fatalf("The cache should return empty maps if Redis is unavailable.")
+	}
+	if len(reqData) != 0 || len(impData) != 0 {
+		t.Errorf("The cache should miss if Redis is unavailable. Got %v, %v", reqData, impData)
+	}
+}
+
+func newServer(t *testing.T) *miniredis.Miniredis {
+	t.Helper()
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start a local Redis server: %v", err)
+	}
+	return server
+}
+
+func newClient(server *miniredis.Miniredis) *redis.Client {
+	return redis.NewClient(&redis.Options{
+		Addr: server.Addr(),
+	})
+}
+
+func assertHasValue(t *testing.T, m map[string]json.RawMessage, key string, val string) {
+	t.Helper()
+	realVal, ok := m[key]
+	if !ok {
+		t.Errorf("Map missing required key: %s", key)
+	}
+	if val != string(realVal) {
+		t.Errorf("Unexpected value at key %s. Expected %s, Got %s", key, val, string(realVal))
+	}
+}
@@ -6,49 +6,82 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/go-redis/redis"
 	"github.com/golang/glog"
 	"github.com/julienschmidt/httprouter"
 	"github.com/prebid/prebid-server/config"
+	"github.com/prebid/prebid-server/pbsmetrics"
 	"github.com/prebid/prebid-server/stored_requests"
+	"github.com/prebid/prebid-server/stored_requests/admin"
 	"github.com/prebid/prebid-server/stored_requests/backends/db_fetcher"
 	"github.com/prebid/prebid-server/stored_requests/backends/empty_fetcher"
 	"github.com/prebid/prebid-server/stored_requests/backends/file_fetcher"
 	"github.com/prebid/prebid-server/stored_requests/backends/http_fetcher"
 	"github.com/prebid/prebid-server/stored_requests/caches/memory"
 	"github.com/prebid/prebid-server/stored_requests/caches/nil_cache"
+	redisCache "github.com/prebid/prebid-server/stored_requests/caches/redis"
 	"github.com/prebid/prebid-server/stored_requests/events"
 	apiEvents "github.com/prebid/prebid-server/stored_requests/events/api"
 	httpEvents "github.com/prebid/prebid-server/stored_requests/events/http"
 	postgresEvents "github.com/prebid/prebid-server/stored_requests/events/postgres"
+	redisEvents "github.com/prebid/prebid-server/stored_requests/events/redis"
 )
 
-// NewStoredRequests returns four things:
+// NewStoredRequests returns five things:
 //
 // 1. A Fetcher which can be used to get Stored Requests for /openrtb2/auction
 // 2. A Fetcher which can be used to get Stored Requests for /openrtb2/amp
 // 3. A DB connection, if one was created. This may be nil.
-// 4. A function which should be called on shutdown for graceful cleanups.
+// 4. An http.Handler for the Stored Request admin API, if one was configured. This may be nil.
+//    It should be registered on the admin server at "/stored_requests/".
+// 5. A function which should be called on shutdown for graceful cleanups.
 //
 // If any errors occur, the program will exit with an error message.
 // It probably means you have a bad config or networking issue.
 //
 // As a side-effect, it will add some endpoints to the router if the config calls for it.
 // In the future we should look for ways to simplify this so that it's not doing two things.
-func NewStoredRequests(cfg *config.StoredRequests, client *http.Client, router *httprouter.Router) (fetcher stored_requests.Fetcher, ampFetcher stored_requests.Fetcher, db *sql.DB, shutdown func()) {
+//
+// The validator is used by the admin API to check data before it gets saved.
+// Cache results, backend fetches and cache update events are recorded in the metricsEngine.
+func NewStoredRequests(cfg *config.StoredRequests, client *http.Client, router *httprouter.Router, validator admin.Validator, metricsEngine pbsmetrics.MetricsEngine) (fetcher stored_requests.Fetcher, ampFetcher stored_requests.Fetcher, db *sql.DB, adminHandler http.Handler, shutdown func()) {
 	if cfg.Postgres.ConnectionInfo.Database != "" {
 		glog.Infof("Connecting to Postgres for Stored Requests. DB=%s, host=%s, port=%d, user=%s", cfg.Postgres.ConnectionInfo.Database, cfg.Postgres.ConnectionInfo.Host, cfg.Postgres.ConnectionInfo.Port, cfg.Postgres.ConnectionInfo.Username)
 		db = newPostgresDB(cfg.Postgres.ConnectionInfo)
 	}
-	eventProducers, ampEventProducers := newEventProducers(cfg, client, db, router)
-	cache := newCache(cfg)
-	ampCache := newCache(cfg)
-	fetcher, ampFetcher = newFetchers(cfg, client, db)
+	var redisClient *redis.Client
+	if cfg.Redis.ConnectionInfo.Address != "" {
+		glog.Infof("Connecting to Redis for Stored Requests. address=%s, db=%d", cfg.Redis.ConnectionInfo.Address, cfg.Redis.ConnectionInfo.Database)
+		redisClient = newRedisClient(cfg.Redis.ConnectionInfo)
+	}
+	eventProducers, ampEventProducers := newEventProducers(cfg, client, db, redisClient, router, metricsEngine)
+	cache := newCache(cfg, redisClient, cfg.Redis.Cache.KeyPrefix)
+	ampCache := newCache(cfg, redisClient, cfg.Redis.Cache.KeyPrefix+"amp:")
+
+	var fileFetcher stored_requests.Fetcher
+	if cfg.AdminAPI.Enabled {
+		adminAPI, fileStore := newAdminAPI(cfg, db, validator)
+		if fileStore != nil {
+			fileFetcher = fileStore
+		}
+		eventProducers = append(eventProducers, sourcedEventProducer{adminAPI.NewEventProducer(), pbsmetrics.StoredDataEventSourceAdmin})
+		ampEventProducers = append(ampEventProducers, sourcedEventProducer{adminAPI.NewEventProducer(), pbsmetrics.StoredDataEventSourceAdmin})
+		adminHandler = adminAPI
+	}
+	fetcher, ampFetcher = newFetchers(cfg, client, db, fileFetcher, metricsEngine)
 
-	fetcher = stored_requests.WithCache(fetcher, cache)
-	ampFetcher = stored_requests.WithCache(ampFetcher, ampCache)
+	cacheOptions := stored_requests.CacheOptions{
+		RefreshAfter:   cfg.Caching.RefreshAfterDuration(),
+		RefreshTimeout: cfg.Caching.RefreshTimeoutDuration(),
+		NotFoundTTL:    cfg.Caching.NotFoundTTLDuration(),
+	}
+	cachingFetcher := stored_requests.WithCache(fetcher, cache, cacheOptions, metricsEngine)
+	ampCachingFetcher := stored_requests.WithCache(ampFetcher, ampCache, cacheOptions, metricsEngine)
+	fetcher, ampFetcher = cachingFetcher, ampCachingFetcher
 
-	shutdown1 := addListeners(cache, eventProducers)
-	shutdown2 := addListeners(ampCache, ampEventProducers)
+	// Events go through the CachingFetchers, so that they forget anything they've remembered about the updated data.
+	shutdown1 := addListeners(cachingFetcher, eventProducers, metricsEngine)
+	shutdown2 := addListeners(ampCachingFetcher, ampEventProducers, metricsEngine)
 	shutdown = func() {
 		shutdown1()
 		shutdown2()
@@ -57,15 +90,31 @@ func NewStoredRequests(cfg *config.StoredRequests, client *http.Client, router *
 				glog.Errorf("Error closing DB connection: %v", err)
 			}
 		}
+		if redisClient != nil {
+			if err := redisClient.Close(); err != nil {
+				glog.Errorf("Error closing Redis connection: %v", err)
+			}
+		}
 	}
 	return
 }
 
-func addListeners(cache stored_requests.Cache, eventProducers []events.EventProducer) (shutdown func()) {
+// sourcedEventProducer is an EventProducer, along with the source which its events are counted under in the metrics.
+type sourcedEventProducer struct {
+	events.EventProducer
+	source pbsmetrics.StoredDataEventSource
+}
+
+func addListeners(cache stored_requests.Cache, eventProducers []sourcedEventProducer, metricsEngine pbsmetrics.MetricsEngine) (shutdown func()) {
 	listeners := make([]*events.EventListener, 0, len(eventProducers))
 
 	for _, ep := range eventProducers {
-		listener := events.SimpleEventListener()
+		source := ep.source
+		listener := events.NewEventListener(func() {
+			metricsEngine.RecordStoredDataEvent(source, pbsmetrics.StoredDataEventSave)
+		}, func() {
+			metricsEngine.RecordStoredDataEvent(source, pbsmetrics.StoredDataEventInvalidate)
+		})
 		go listener.Listen(cache, ep)
 		listeners = append(listeners, listener)
 	}
@@ -77,27 +126,33 @@ func addListeners(cache stored_requests.Cache, eventProducers []events.EventProd
 	}
 }
 
-func newFetchers(cfg *config.StoredRequests, client *http.Client, db *sql.DB) (fetcher stored_requests.Fetcher, ampFetcher stored_requests.Fetcher) {
+// newFetchers builds the Fetchers described by the config. If fileFetcher is non-nil, it will be used
+// instead of loading a new file_fetcher. Each backend records its own metrics.
+func newFetchers(cfg *config.StoredRequests, client *http.Client, db *sql.DB, fileFetcher stored_requests.Fetcher, metricsEngine pbsmetrics.MetricsEngine) (fetcher stored_requests.Fetcher, ampFetcher stored_requests.Fetcher) {
 	idList := make(stored_requests.MultiFetcher, 0, 3)
 	ampIDList := make(stored_requests.MultiFetcher, 0, 3)
 
 	if cfg.Files {
-		fFetcher := newFilesystem()
+		fFetcher := fileFetcher
+		if fFetcher == nil {
+			fFetcher = newFilesystem()
+		}
+		fFetcher = stored_requests.WithMetrics(fFetcher, pbsmetrics.StoredDataFetcherFile, metricsEngine)
 		idList = append(idList, fFetcher)
 		ampIDList = append(ampIDList, fFetcher)
 	}
 	if cfg.Postgres.FetcherQueries.QueryTemplate != "" {
 		glog.Infof("Loading Stored Requests via Postgres.\nQuery: %s\nAMP Query: %s", cfg.Postgres.FetcherQueries.QueryTemplate, cfg.Postgres.FetcherQueries.AmpQueryTemplate)
-		idList = append(idList, db_fetcher.NewFetcher(db, cfg.Postgres.FetcherQueries.MakeQuery))
-		ampIDList = append(ampIDList, db_fetcher.NewFetcher(db, cfg.Postgres.FetcherQueries.MakeAmpQuery))
+		idList = append(idList, stored_requests.WithMetrics(db_fetcher.NewFetcher(db, cfg.Postgres.FetcherQueries.MakeQuery), pbsmetrics.StoredDataFetcherPostgres, metricsEngine))
+		ampIDList = append(ampIDList, stored_requests.WithMetrics(db_fetcher.NewFetcher(db, cfg.Postgres.FetcherQueries.MakeAmpQuery), pbsmetrics.StoredDataFetcherPostgres, metricsEngine))
 	}
 	if cfg.HTTP.Endpoint != "" {
 		glog.Infof("Loading Stored Requests via HTTP. endpoint=%s", cfg.HTTP.Endpoint)
-		idList = append(idList, http_fetcher.NewFetcher(client, cfg.HTTP.Endpoint))
+		idList = append(idList, stored_requests.WithMetrics(http_fetcher.NewFetcher(client, cfg.HTTP.Endpoint), pbsmetrics.StoredDataFetcherHTTP, metricsEngine))
 	}
 	if cfg.HTTP.AmpEndpoint != "" {
 		glog.Infof("Loading Stored Requests via HTTP. amp_endpoint=%s", cfg.HTTP.AmpEndpoint)
-		ampIDList = append(ampIDList, http_fetcher.NewFetcher(client, cfg.HTTP.AmpEndpoint))
+		ampIDList = append(ampIDList, stored_requests.WithMetrics(http_fetcher.NewFetcher(client, cfg.HTTP.AmpEndpoint), pbsmetrics.StoredDataFetcherHTTP, metricsEngine))
 	}
 
 	fetcher = consolidate(idList)
@@ -105,26 +160,37 @@ func newFetchers(cfg *config.StoredRequests, client *http.Client, db *sql.DB) (f
 	return
 }
 
-func newCache(cfg *config.StoredRequests) stored_requests.Cache {
-	if cfg.InMemoryCache.Type == "none" {
+// newCache builds the Caches described by the config. If there is more than one, the in-memory cache
+// is checked first, and the Redis cache is only used for its misses.
+func newCache(cfg *config.StoredRequests, redisClient *redis.Client, redisKeyPrefix string) stored_requests.Cache {
+	caches := make(stored_requests.ComposedCache, 0, 2)
+	if cfg.InMemoryCache.Type != "none" {
+		caches = append(caches, memory.NewCache(&cfg.InMemoryCache))
+	}
+	if cfg.Redis.Cache.Enabled {
+		caches = append(caches, redisCache.NewCache(redisClient, redisKeyPrefix, cfg.Redis.Cache.TTLDuration()))
+	}
+
+	if len(caches) == 0 {
 		glog.Info("No Stored Request cache configured. The Fetcher backend will be used for all Stored Requests.")
 		return &nil_cache.NilCache{}
+	} else if len(caches) == 1 {
+		return caches[0]
 	}
-
-	return memory.NewCache(&cfg.InMemoryCache)
+	return caches
 }
 
-func newEventProducers(cfg *config.StoredRequests, client *http.Client, db *sql.DB, router *httprouter.Router) (eventProducers []events.EventProducer, ampEventProducers []events.EventProducer) {
+func newEventProducers(cfg *config.StoredRequests, client *http.Client, db *sql.DB, redisClient *redis.Client, router *httprouter.Router, metricsEngine pbsmetrics.MetricsEngine) (eventProducers []sourcedEventProducer, ampEventProducers []sourcedEventProducer) {
 	if cfg.CacheEventsAPI {
-		eventProducers = append(eventProducers, newEventsAPI(router, "/storedrequests/openrtb2"))
-		ampEventProducers = append(ampEventProducers, newEventsAPI(router, "/storedrequests/amp"))
+		eventProducers = append(eventProducers, sourcedEventProducer{newEventsAPI(router, "/storedrequests/openrtb2"), pbsmetrics.StoredDataEventSourceAPI})
+		ampEventProducers = append(ampEventProducers, sourcedEventProducer{newEventsAPI(router, "/storedrequests/amp"), pbsmetrics.StoredDataEventSourceAPI})
 	}
 	if cfg.HTTPEvents.RefreshRate != 0 {
 		if cfg.HTTPEvents.Endpoint != "" {
-			eventProducers = append(eventProducers, newHttpEvents(client, cfg.HTTPEvents.TimeoutDuration(), cfg.HTTPEvents.RefreshRateDuration(), cfg.HTTPEvents.Endpoint))
+			eventProducers = append(eventProducers, sourcedEventProducer{newHttpEvents(client, cfg.HTTPEvents.TimeoutDuration(), cfg.HTTPEvents.RefreshRateDuration(), cfg.HTTPEvents.Endpoint), pbsmetrics.StoredDataEventSourceHTTP})
 		}
 		if cfg.HTTPEvents.AmpEndpoint != "" {
-			ampEventProducers = append(ampEventProducers, newHttpEvents(client, cfg.HTTPEvents.TimeoutDuration(), cfg.HTTPEvents.RefreshRateDuration(), cfg.HTTPEvents.AmpEndpoint))
+			ampEventProducers = append(ampEventProducers, sourcedEventProducer{newHttpEvents(client, cfg.HTTPEvents.TimeoutDuration(), cfg.HTTPEvents.RefreshRateDuration(), cfg.HTTPEvents.AmpEndpoint), pbsmetrics.StoredDataEventSourceHTTP})
 		}
 	}
 	if cfg.Postgres.CacheInitialization.Query != "" {
@@ -132,31 +198,44 @@ func newEventProducers(cfg *config.StoredRequests, client *http.Client, db *sql.
 		updateStartTime := time.Now()
 		timeout := time.Duration(cfg.Postgres.CacheInitialization.Timeout) * time.Millisecond
 		ctx, cancel := context.WithTimeout(context.Background(), timeout)
-		eventProducers = append(eventProducers, postgresEvents.LoadAll(ctx, db, cfg.Postgres.CacheInitialization.Query))
+		eventProducers = append(eventProducers, sourcedEventProducer{postgresEvents.LoadAll(ctx, db, cfg.Postgres.CacheInitialization.Query), pbsmetrics.StoredDataEventSourcePostgres})
 		cancel()
 
 		ctx, cancel = context.WithTimeout(context.Background(), timeout)
-		ampEventProducers = append(ampEventProducers, postgresEvents.LoadAll(ctx, db, cfg.Postgres.CacheInitialization.AmpQuery))
+		ampEventProducers = append(ampEventProducers, sourcedEventProducer{postgresEvents.LoadAll(ctx, db, cfg.Postgres.CacheInitialization.AmpQuery), pbsmetrics.StoredDataEventSourcePostgres})
 		cancel()
 
 		if cfg.Postgres.PollUpdates.Query != "" {
-			eventProducers = append(eventProducers, newPostgresPolling(cfg.Postgres.PollUpdates, db, updateStartTime, false))
-			ampEventProducers = append(ampEventProducers, newPostgresPolling(cfg.Postgres.PollUpdates, db, updateStartTime, true))
+			eventProducers = append(eventProducers, sourcedEventProducer{newPostgresPolling(cfg.Postgres.PollUpdates, db, updateStartTime, false, metricsEngine), pbsmetrics.StoredDataEventSourcePostgres})
+			ampEventProducers = append(ampEventProducers, sourcedEventProducer{newPostgresPolling(cfg.Postgres.PollUpdates, db, updateStartTime, true, metricsEngine), pbsmetrics.StoredDataEventSourcePostgres})
 		}
 	}
+	if cfg.Redis.Events.Channel != "" {
+		eventProducers = append(eventProducers, sourcedEventProducer{redisEvents.NewRedisEvents(redisClient, cfg.Redis.Events.Channel), pbsmetrics.StoredDataEventSourceRedis})
+	}
+	if cfg.Redis.Events.AmpChannel != "" {
+		ampEventProducers = append(ampEventProducers, sourcedEventProducer{redisEvents.NewRedisEvents(redisClient, cfg.Redis.Events.AmpChannel), pbsmetrics.StoredDataEventSourceRedis})
+	}
 	return
 }
 
-func newPostgresPolling(cfg config.PostgresUpdatePolling, db *sql.DB, startTime time.Time, forAmp bool) events.EventProducer {
+func newPostgresPolling(cfg config.PostgresUpdatePolling, db *sql.DB, startTime time.Time, forAmp bool, metricsEngine pbsmetrics.MetricsEngine) events.EventProducer {
 	timeout := time.Duration(cfg.Timeout) * time.Millisecond
 	ctxProducer := func() (ctx context.Context, canceller func()) {
 		return context.WithTimeout(context.Background(), timeout)
 	}
 
+	queries := postgresEvents.PollingQueries{
+		Update: cfg.Query,
+		Delete: cfg.DeleteQuery,
+	}
 	if forAmp {
-		return postgresEvents.PollForUpdates(ctxProducer, db, cfg.AmpQuery, startTime, time.Duration(cfg.RefreshRate)*time.Second)
+		queries = postgresEvents.PollingQueries{
+			Update: cfg.AmpQuery,
+			Delete: cfg.AmpDeleteQuery,
+		}
 	}
-	return postgresEvents.PollForUpdates(ctxProducer, db, cfg.Query, startTime, time.Duration(cfg.RefreshRate)*time.Second)
+	return postgresEvents.PollForUpdates(ctxProducer, db, queries, startTime, cfg.RequestRefreshDuration(), cfg.ImpRefreshDuration(), metricsEngine)
 }
 
 func newEventsAPI(router *httprouter.Router, endpoint string) events.EventProducer {
@@ -166,6 +245,21 @@ func newEventsAPI(router *httprouter.Router, endpoint string) events.EventProduc
 	return producer
 }
 
+// newAdminAPI makes the admin API described by the config. If it saves to the filesystem,
+// the FileStore will be returned too so that it can replace the file_fetcher.
+func newAdminAPI(cfg *config.StoredRequests, db *sql.DB, validator admin.Validator) (*admin.API, *admin.FileStore) {
+	if cfg.Files {
+		glog.Infof("Saving Stored Requests from the admin API to the filesystem at path %s", requestConfigPath)
+		store, err := admin.NewFileStore(requestConfigPath)
+		if err != nil {
+			glog.Fatalf("Failed to create a FileStore: %v", err)
+		}
+		return admin.NewAdminAPI(store, validator, cfg.AdminAPI.AuthToken, cfg.AdminAPI.TimeoutDuration(), cfg.AdminAPI.MaxBodySize), store
+	}
+	glog.Info("Saving Stored Requests from the admin API to Postgres")
+	return admin.NewAdminAPI(admin.NewPostgresStore(db), validator, cfg.AdminAPI.AuthToken, cfg.AdminAPI.TimeoutDuration(), cfg.AdminAPI.MaxBodySize), nil
+}
+
 func newHttpEvents(client *http.Client, timeout time.Duration, refreshRate time.Duration, endpoint string) events.EventProducer {
 	ctxProducer := func() (ctx context.Context, canceller func()) {
 		return context.WithTimeout(context.Background(), timeout)
@@ -195,6 +289,23 @@ func newPostgresDB(cfg config.PostgresConnection) *sql.DB {
 	return db
 }
 
+func newRedisClient(cfg config.RedisConnection) *redis.Client {
+	client := redis.NewClient(&redis.Options{
+		Addr:         cfg.Address,
+		Password:     cfg.Password,
+		DB:           cfg.Database,
+		DialTimeout:  cfg.TimeoutDuration(),
+		ReadTimeout:  cfg.TimeoutDuration(),
+		WriteTimeout: cfg.TimeoutDuration(),
+	})
+
+	if err := client.Ping().Err(); err != nil {
+		glog.Fatalf("Failed to ping redis: %v", err)
+	}
+
+	return client
+}
+
 // consolidate returns a single Fetcher from an array of fetchers of any size.
 func consolidate(fetchers []stored_requests.Fetcher) stored_requests.Fetcher {
 	if len(fetchers) == 0 {
@@ -8,18 +8,24 @@ import (
 	"net/http/httptest"
 	"regexp"
 	"testing"
+	"time"
 
 	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis"
+	"github.com/go-redis/redis"
 	"github.com/julienschmidt/httprouter"
 	"github.com/prebid/prebid-server/config"
+	"github.com/prebid/prebid-server/pbsmetrics"
+	"github.com/prebid/prebid-server/stored_requests"
 	"github.com/prebid/prebid-server/stored_requests/backends/empty_fetcher"
-	"github.com/prebid/prebid-server/stored_requests/backends/http_fetcher"
+	"github.com/prebid/prebid-server/stored_requests/caches/nil_cache"
 	"github.com/prebid/prebid-server/stored_requests/events"
 	httpEvents "github.com/prebid/prebid-server/stored_requests/events/http"
+	"github.com/rcrowley/go-metrics"
 )
 
 func TestNewEmptyFetcher(t *testing.T) {
-	fetcher, ampFetcher := newFetchers(&config.StoredRequests{}, nil, nil)
+	fetcher, ampFetcher := newFetchers(&config.StoredRequests{}, nil, nil, nil, newTestMetrics())
 	if fetcher == nil || ampFetcher == nil {
 		t.Errorf("The fetchers should be non-nil, even with an empty config.")
 	}
@@ -32,46 +38,47 @@ func TestNewEmptyFetcher(t *testing.T) {
 }
 
 func TestNewHTTPFetcher(t *testing.T) {
+	var gotTypes []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotTypes = append(gotTypes, r.URL.Query().Get("type"))
+		w.Write([]byte(`{"requests":{"req-1":{}}}`))
+	}))
+	defer server.Close()
+
+	metricsEngine := newTestMetrics()
 	fetcher, ampFetcher := newFetchers(&config.StoredRequests{
 		HTTP: config.HTTPFetcherConfig{
-			Endpoint:    "stored-requests.prebid.com",
-			AmpEndpoint: "stored-requests.prebid.com?type=amp",
+			Endpoint:    server.URL,
+			AmpEndpoint: server.URL + "?type=amp",
 		},
-	}, nil, nil)
-	if httpFetcher, ok := fetcher.(*http_fetcher.HttpFetcher); ok {
-		if httpFetcher.Endpoint != "stored-requests.prebid.com?" {
-			t.Errorf("The HTTP fetcher is using the wrong endpoint. Expected %s, got %s", "stored-requests.prebid.com?", httpFetcher.Endpoint)
-		}
-	} else {
-		t.Errorf("An HTTP Fetching config should return an HTTPFetcher. Got %v", ampFetcher)
+	}, server.Client(), nil, nil, metricsEngine)
+
+	if _, _, errs := fetcher.FetchRequests(context.Background(), []string{"req-1"}, nil); len(errs) != 0 {
+		t.Errorf("Unexpected errors from the HTTP fetcher: %v", errs)
 	}
-	if httpFetcher, ok := ampFetcher.(*http_fetcher.HttpFetcher); ok {
-		if httpFetcher.Endpoint != "stored-requests.prebid.com?type=amp&" {
-			t.Errorf("The AMP HTTP fetcher is using the wrong endpoint. Expected %s, got %s", "stored-requests.prebid.com?type=amp&", httpFetcher.Endpoint)
-		}
-	} else {
-		t.Errorf("An HTTP Fetching config should return an HTTPFetcher. Got %v", ampFetcher)
+	if _, _, errs := ampFetcher.FetchRequests(context.Background(), []string{"req-1"}, nil); len(errs) != 0 {
+		t.Errorf("Unexpected errors from the AMP HTTP fetcher: %v", errs)
+	}
+	if len(gotTypes) != 2 || gotTypes[0] != "" || gotTypes[1] != "amp" {
+		t.Errorf("The HTTP fetchers called the wrong endpoints. Got type=%v", gotTypes)
+	}
+	if count := metricsEngine.StoredDataFetchTimers[pbsmetrics.StoredDataFetcherHTTP].Count(); count != 2 {
+		t.Errorf("Each HTTP fetch should be timed. Expected 2, got %d", count)
 	}
 }
 
 func TestNewHTTPFetcherNoAmp(t *testing.T) {
-	fetcher, ampFetcher := newFetchers(&config.StoredRequests{
+	_, ampFetcher := newFetchers(&config.StoredRequests{
 		HTTP: config.HTTPFetcherConfig{
 			Endpoint:    "stored-requests.prebid.com",
 			AmpEndpoint: "",
 		},
-	}, nil, nil)
-	if httpFetcher, ok := fetcher.(*http_fetcher.HttpFetcher); ok {
-		if httpFetcher.Endpoint != "stored-requests.prebid.com?" {
-			t.Errorf("The HTTP fetcher is using the wrong endpoint. Expected %s, got %s", "stored-requests.prebid.com?", httpFetcher.Endpoint)
-		}
-	} else {
-		t.Errorf("An HTTP Fetching config should return an HTTPFetcher. Got %v", ampFetcher)
-	}
-	if httpAmpFetcher, ok := ampFetcher.(*http_fetcher.HttpFetcher); ok && httpAmpFetcher == nil {
-		t.Errorf("An HTTP Fetching config should not return an Amp HTTP fetcher in this case. Got %v (%v)", ampFetcher, httpAmpFetcher)
+	}, nil, nil, nil, newTestMetrics())
+	if _, ok := ampFetcher.(empty_fetcher.EmptyFetcher); !ok {
+		t.Errorf("An HTTP Fetching config should not return an Amp HTTP fetcher in this case. Got %v", ampFetcher)
 	}
 }
+
 func TestNewHTTPEvents(t *testing.T) {
 	handler := func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -87,7 +94,7 @@ func TestNewHTTPEvents(t *testing.T) {
 			Timeout:     1000,
 		},
 	}
-	evProducers, ampProducers := newEventProducers(cfg, server1.Client(), nil, nil)
+	evProducers, ampProducers := newEventProducers(cfg, server1.Client(), nil, nil, nil, newTestMetrics())
 	assertSliceLength(t, evProducers, 1)
 	assertSliceLength(t, ampProducers, 1)
 	assertHttpWithURL(t, evProducers[0], server1.URL)
@@ -95,7 +102,7 @@ func TestNewHTTPEvents(t *testing.T) {
 }
 
 func TestNewEmptyCache(t *testing.T) {
-	cache := newCache(&config.StoredRequests{InMemoryCache: config.InMemoryCache{Type: "none"}})
+	cache := newCache(&config.StoredRequests{InMemoryCache: config.InMemoryCache{Type: "none"}}, nil, "")
 	cache.Save(context.Background(), map[string]json.RawMessage{"foo": json.RawMessage("true")}, nil)
 	reqs, _ := cache.Get(context.Background(), []string{"foo"}, nil)
 	if len(reqs) != 0 {
@@ -110,7 +117,7 @@ func TestNewInMemoryCache(t *testing.T) {
 			RequestCacheSize: 100,
 			ImpCacheSize:     100,
 		},
-	})
+	}, nil, "")
 	cache.Save(context.Background(), map[string]json.RawMessage{"foo": json.RawMessage("true")}, nil)
 	reqs, _ := cache.Get(context.Background(), []string{"foo"}, nil)
 	if len(reqs) != 1 {
@@ -118,6 +125,44 @@ func TestNewInMemoryCache(t *testing.T) {
 	}
 }
 
+func TestNewRedisCache(t *testing.T) {
+	server, client := newTestRedis(t)
+	defer server.Close()
+
+	cache := newCache(&config.StoredRequests{
+		InMemoryCache: config.InMemoryCache{Type: "none"},
+		Redis: config.RedisConfig{
+			Cache: config.RedisCache{Enabled: true},
+		},
+	}, client, "test:")
+	cache.Save(context.Background(), map[string]json.RawMessage{"foo": json.RawMessage("true")}, nil)
+	if !server.Exists("test:request:foo") {
+		t.Errorf("The newCache method should return a Redis cache if the config asks for it.")
+	}
+}
+
+func TestNewComposedCache(t *testing.T) {
+	server, client := newTestRedis(t)
+	defer server.Close()
+
+	cache := newCache(&config.StoredRequests{
+		InMemoryCache: config.InMemoryCache{Type: "unbounded"},
+		Redis: config.RedisConfig{
+			Cache: config.RedisCache{Enabled: true},
+		},
+	}, client, "test:")
+	if composed, ok := cache.(stored_requests.ComposedCache); !ok || len(composed) != 2 {
+		t.Fatalf("The newCache method should compose the in-memory and Redis caches. Got %#v", cache)
+	}
+
+	// Data saved by another instance should be found in Redis.
+	server.Set("test:request:foo", "true")
+	reqs, _ := cache.Get(context.Background(), []string{"foo"}, nil)
+	if len(reqs) != 1 {
+		t.Errorf("The composed cache should fall back to Redis on in-memory misses.")
+	}
+}
+
 func TestNewPostgresEventProducers(t *testing.T) {
 	cfg := &config.StoredRequests{
 		Postgres: config.PostgresConfig{
@@ -142,11 +187,65 @@ func TestNewPostgresEventProducers(t *testing.T) {
 	mock.ExpectQuery("^" + regexp.QuoteMeta(cfg.Postgres.CacheInitialization.Query) + "$").WillReturnError(errors.New("Query failed"))
 	mock.ExpectQuery("^" + regexp.QuoteMeta(cfg.Postgres.CacheInitialization.AmpQuery) + "$").WillReturnError(errors.New("Query failed"))
 
-	evProducers, ampEvProducers := newEventProducers(cfg, client, db, nil)
+	evProducers, ampEvProducers := newEventProducers(cfg, client, db, nil, nil, newTestMetrics())
 	assertExpectationsMet(t, mock)
 	assertProducerLength(t, evProducers, 2)
 	assertProducerLength(t, ampEvProducers, 2)
 }
+func TestNewRedisEventProducers(t *testing.T) {
+	server, client := newTestRedis(t)
+	defer server.Close()
+
+	cfg := &config.StoredRequests{
+		Redis: config.RedisConfig{
+			Events: config.RedisEventsConfig{
+				Channel:    "updates",
+				AmpChannel: "amp-updates",
+			},
+		},
+	}
+	evProducers, ampEvProducers := newEventProducers(cfg, nil, nil, client, nil, newTestMetrics())
+	assertProducerLength(t, evProducers, 1)
+	assertProducerLength(t, ampEvProducers, 1)
+	if evProducers[0].source != pbsmetrics.StoredDataEventSourceRedis || ampEvProducers[0].source != pbsmetrics.StoredDataEventSourceRedis {
+		t.Errorf("Redis events should be counted under the redis source.")
+	}
+
+	server.Publish("amp-updates", `{"invalidate":{"requests":["foo"]}}`)
+	select {
+	case invalidation := <-ampEvProducers[0].Invalidations():
+		if len(invalidation.Requests) != 1 || invalidation.Requests[0] != "foo" {
+			t.Errorf("Bad invalidation from the AMP channel: %v", invalidation)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("The AMP EventProducer should subscribe to the amp_channel.")
+	}
+}
+
+func TestEventMetrics(t *testing.T) {
+	producer := &fakeEventProducer{
+		saves:         make(chan events.Save),
+		invalidations: make(chan events.Invalidation),
+	}
+	metricsEngine := newTestMetrics()
+	shutdown := addListeners(&nil_cache.NilCache{}, []sourcedEventProducer{{producer, pbsmetrics.StoredDataEventSourceAdmin}}, metricsEngine)
+	producer.saves <- events.Save{}
+	producer.invalidations <- events.Invalidation{}
+	producer.saves <- events.Save{}
+	shutdown()
+
+	adminEvents := metricsEngine.StoredDataEventMeters[pbsmetrics.StoredDataEventSourceAdmin]
+	if saves := adminEvents[pbsmetrics.StoredDataEventSave].Count(); saves != 2 {
+		t.Errorf("Expected 2 save events from the admin API. Got %d", saves)
+	}
+	if invalidations := adminEvents[pbsmetrics.StoredDataEventInvalidate].Count(); invalidations != 1 {
+		t.Errorf("Expected 1 invalidation event from the admin API. Got %d", invalidations)
+	}
+	if saves := metricsEngine.StoredDataEventMeters[pbsmetrics.StoredDataEventSourcePostgres][pbsmetrics.StoredDataEventSave].Count(); saves != 0 {
+		t.Errorf("Events should only be counted for their own source. Got %d Postgres saves", saves)
+	}
+}
+
 func TestNewEventsAPI(t *testing.T) {
 	router := httprouter.New()
 	newEventsAPI(router, "/test-endpoint")
@@ -158,7 +257,7 @@ func TestNewEventsAPI(t *testing.T) {
 	}
 }
 
-func assertProducerLength(t *testing.T, producers []events.EventProducer, expectedLength int) {
+func assertProducerLength(t *testing.T, producers []sourcedEventProducer, expectedLength int) {
 	t.Helper()
 	if len(producers) != expectedLength {
 		t.Errorf("Expected %d producers, but got %d", expectedLength, len(producers))
@@ -171,15 +270,18 @@ func assertExpectationsMet(t *testing.T, mock sqlmock.Sqlmock) {
 	}
 }
 
-func assertHttpWithURL(t *testing.T, ev events.EventProducer, url string) {
-	if casted, ok := ev.(*httpEvents.HTTPEvents); ok {
+func assertHttpWithURL(t *testing.T, ev sourcedEventProducer, url string) {
+	if ev.source != pbsmetrics.StoredDataEventSourceHTTP {
+		t.Errorf("HTTP events should be counted under the http source. Got %s", ev.source)
+	}
+	if casted, ok := ev.EventProducer.(*httpEvents.HTTPEvents); ok {
 		assertStringsEqual(t, casted.Endpoint, url)
 	} else {
 		t.Errorf("The EventProducer was not a *HTTPEvents")
 	}
 }
 
-func assertSliceLength(t *testing.T, producers []events.EventProducer, expected int) {
+func assertSliceLength(t *testing.T, producers []sourcedEventProducer, expected int) {
 	t.Helper()
 
 	if len(producers) != expected {
@@ -194,3 +296,29 @@ func assertStringsEqual(t *testing.T, actual string, expected string) {
 		t.Fatalf("String %s did not match expected %s", actual, expected)
 	}
 }
+
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start a local Redis server: %v", err)
+	}
+	return server, redis.NewClient(&redis.Options{Addr: server.Addr()})
+}
+
+func newTestMetrics() *pbsmetrics.Metrics {
+	return pbsmetrics.NewMetrics(metrics.NewRegistry(), nil, config.AccountMetrics{})
+}
+
+type fakeEventProducer struct {
+	saves         chan events.Save
+	invalidations chan events.Invalidation
+}
+
+func (p *fakeEventProducer) Saves() <-chan events.Save {
+	return p.saves
+}
+
+func (p *fakeEventProducer) Invalidations() <-chan events.Invalidation {
+	return p.invalidations
+}
@@ -8,23 +8,40 @@ import (
 	"time"
 
 	"github.com/golang/glog"
+	"github.com/prebid/prebid-server/pbsmetrics"
 	"github.com/prebid/prebid-server/stored_requests/events"
 )
 
-// PollForUpdates returns an EventProducer which checks the database for updates every refreshRate.
+// PollingQueries are the queries which a PostgresPoller runs on each poll.
+// Each of them must take the time of the last successful poll as its only argument ($1).
+type PollingQueries struct {
+	// Update should return a ResultSet with the following columns and types:
+	//
+	//   1. id: string
+	//   2. data: JSON
+	//   3. type: string ("request" or "imp")
+	//
+	// If data is empty or the JSON "null", then the ID will be invalidated (e.g. a deletion).
+	// If data is not empty, it should be the Stored Request or Stored Imp data associated with the given ID.
+	Update string
+
+	// Delete is optional. If defined, it should return a ResultSet with the following columns and types:
+	//
+	//   1. id: string
+	//   2. type: string ("request" or "imp")
+	//
+	// Each ID will be invalidated. This supports databases which soft-delete rows, or log deletes to a tombstone table.
+	Delete string
+}
+
+// PollForUpdates returns an EventProducer which checks the database for updates to Stored Requests every requestRefreshRate,
+// and for updates to Stored Imps every impRefreshRate. If the two are equal, both types are fetched by the same queries.
 //
 // This object will prioritize thoroughness over efficiency. In rare cases it may produce two "update" events for
 // the same DB save, but it should never "miss" a database update either.
 //
-// The Queries should return a ResultSet with the following columns and types:
-//
-//   1. id: string
-//   2. data: JSON
-//   3. type: string ("request" or "imp")
-//
-// If data is empty or the JSON "null", then the ID will be invalidated (e.g. a deletion).
-// If data is not empty, it should be the Stored Request or Stored Imp data associated with the given ID.
-func PollForUpdates(ctxProducer func() (ctx context.Context, canceller func()), db *sql.DB, query string, startUpdatesFrom time.Time, refreshRate time.Duration) (eventProducer *PostgresPoller) {
+// The number of rows loaded by each poll is recorded in the metricsEngine.
+func PollForUpdates(ctxProducer func() (ctx context.Context, canceller func()), db *sql.DB, queries PollingQueries, startUpdatesFrom time.Time, requestRefreshRate time.Duration, impRefreshRate time.Duration, metricsEngine pbsmetrics.MetricsEngine) (eventProducer *PostgresPoller) {
 	// If we're not given a function to produce Contexts, use the Background one.
 	if ctxProducer == nil {
 		ctxProducer = func() (ctx context.Context, canceller func()) {
@@ -38,18 +55,25 @@ func PollForUpdates(ctxProducer func() (ctx context.Context, canceller func()),
 	e := &PostgresPoller{
 		db:            db,
 		ctxProducer:   ctxProducer,
-		updateQuery:   query,
+		queries:       queries,
+		metricsEngine: metricsEngine,
 		lastUpdate:    startUpdatesFrom,
 		invalidations: make(chan events.Invalidation, 1),
 		saves:         make(chan events.Save, 1),
 	}
 
-	glog.Infof("Stored Requests will be refreshed from Postgres every %f seconds with: %s", refreshRate.Seconds(), query)
+	glog.Infof("Stored Requests will be refreshed from Postgres every %f seconds, and Stored Imps every %f seconds, with: %s", requestRefreshRate.Seconds(), impRefreshRate.Seconds(), queries.Update)
+	if queries.Delete != "" {
+		glog.Infof("Stored Request deletes will be polled from Postgres with: %s", queries.Delete)
+	}
 
-	if refreshRate > 0 {
-		go e.refresh(time.Tick(refreshRate))
+	if requestRefreshRate <= 0 || impRefreshRate <= 0 {
+		glog.Warningf("Postgres Stored Event polling refresh rates were %d and %d. These must be positive. No updates will occur.", requestRefreshRate, impRefreshRate)
+	} else if requestRefreshRate == impRefreshRate {
+		go e.refresh(time.Tick(requestRefreshRate))
 	} else {
-		glog.Warningf("Postgres Stored Event polling refreshRate was %d. This must be positive. No updates will occur.", refreshRate)
+		go e.refreshTypes(time.Tick(requestRefreshRate), startUpdatesFrom, storedDataTypes{request: true})
+		go e.refreshTypes(time.Tick(impRefreshRate), startUpdatesFrom, storedDataTypes{imp: true})
 	}
 	return e
 }
@@ -57,13 +81,29 @@ func PollForUpdates(ctxProducer func() (ctx context.Context, canceller func()),
 type PostgresPoller struct {
 	db            *sql.DB
 	ctxProducer   func() (ctx context.Context, canceller func())
-	updateQuery   string
+	queries       PollingQueries
+	metricsEngine pbsmetrics.MetricsEngine
 	lastUpdate    time.Time
 	invalidations chan events.Invalidation
 	saves         chan events.Save
 }
 
+// storedDataTypes says which types of data a poll should produce events for.
+type storedDataTypes struct {
+	request bool
+	imp     bool
+}
+
+var allStoredDataTypes = storedDataTypes{request: true, imp: true}
+
+// refresh polls for updates to all the data types on each tick.
 func (e *PostgresPoller) refresh(ticker <-chan time.Time) {
+	e.refreshTypes(ticker, e.lastUpdate, allStoredDataTypes)
+}
+
+// refreshTypes polls for updates to the given data types on each tick. Each call tracks its own lastUpdate,
+// so that data types with different refresh rates can be polled concurrently.
+func (e *PostgresPoller) refreshTypes(ticker <-chan time.Time, lastUpdate time.Time, dataTypes storedDataTypes) {
 	for {
 		select {
 		case thisTime := <-ticker:
@@ -71,35 +111,83 @@ func (e *PostgresPoller) refresh(ticker <-chan time.Time) {
 			// so that next tick's query won't miss any new updates which were made at the same time.
 			// This may duplicate some updates, but safety > efficiency.
 			thisTimeInUTC := thisTime.UTC()
-			ctx, cancel := e.ctxProducer()
-			rows, err := e.db.QueryContext(ctx, e.updateQuery, e.lastUpdate)
-			if err != nil {
-				glog.Warningf("Failed to update Stored Request data: %v", err)
-				cancel()
-				continue
+			if e.poll(lastUpdate, dataTypes) {
+				lastUpdate = thisTimeInUTC
 			}
-			if err := sendEvents(rows, e.saves, e.invalidations); err != nil {
-				glog.Warningf("Failed to update Stored Request data: %v", err)
-			} else {
-				e.lastUpdate = thisTimeInUTC
-			}
-			if err := rows.Close(); err != nil {
-				glog.Warningf("Failed to close DB connection: %v", err)
-			}
-			cancel()
 		}
 	}
 }
 
+// poll runs the queries for changes since lastUpdate, and sends events for those of the given types.
+// It returns true if all the queries succeeded.
+func (e *PostgresPoller) poll(lastUpdate time.Time, dataTypes storedDataTypes) bool {
+	ctx, cancel := e.ctxProducer()
+	defer cancel()
+
+	data := newStoredData()
+	if !e.query(ctx, e.queries.Update, lastUpdate, func(rows *sql.Rows) error {
+		return data.readUpdates(rows, dataTypes)
+	}) {
+		return false
+	}
+	if e.queries.Delete != "" && !e.query(ctx, e.queries.Delete, lastUpdate, func(rows *sql.Rows) error {
+		return data.readDeletes(rows, dataTypes)
+	}) {
+		return false
+	}
+
+	data.recordRows(e.metricsEngine, dataTypes)
+	data.send(e.saves, e.invalidations)
+	return true
+}
+
+func (e *PostgresPoller) query(ctx context.Context, query string, lastUpdate time.Time, read func(rows *sql.Rows) error) bool {
+	rows, err := e.db.QueryContext(ctx, query, lastUpdate)
+	if err != nil {
+		glog.Warningf("Failed to update Stored Request data: %v", err)
+		return false
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			glog.Warningf("Failed to close DB connection: %v", err)
+		}
+	}()
+
+	if err := read(rows); err != nil {
+		glog.Warningf("Failed to update Stored Request data: %v", err)
+		return false
+	}
+	return true
+}
+
 // sendEvents reads the rows and sends notifications into the channel for any updates.
 // If it returns an error, then callers can be certain that no events were sent to the channels.
 func sendEvents(rows *sql.Rows, saves chan<- events.Save, invalidations chan<- events.Invalidation) (err error) {
-	storedRequestData := make(map[string]json.RawMessage)
-	storedImpData := make(map[string]json.RawMessage)
+	data := newStoredData()
+	if err := data.readUpdates(rows, allStoredDataTypes); err != nil {
+		return err
+	}
+	data.send(saves, invalidations)
+	return nil
+}
+
+// storedData accumulates the changes read from the database, so that they can be sent as one event of each kind.
+type storedData struct {
+	requestData          map[string]json.RawMessage
+	impData              map[string]json.RawMessage
+	requestInvalidations []string
+	impInvalidations     []string
+}
 
-	var requestInvalidations []string
-	var impInvalidations []string
+func newStoredData() *storedData {
+	return &storedData{
+		requestData: make(map[string]json.RawMessage),
+		impData:     make(map[string]json.RawMessage),
+	}
+}
 
+// readUpdates reads rows with the columns (id, data, type). If it returns an error, some rows may have been read already.
+func (d *storedData) readUpdates(rows *sql.Rows, dataTypes storedDataTypes) error {
 	for rows.Next() {
 		var id string
 		var data []byte
@@ -109,18 +197,25 @@ func sendEvents(rows *sql.Rows, saves chan<- events.Save, invalidations chan<- e
 			return err
 		}
 
+		invalidate := len(data) == 0 || bytes.Equal(data, []byte("null"))
 		switch dataType {
 		case "request":
-			if len(data) == 0 || bytes.Equal(data, []byte("null")) {
-				requestInvalidations = append(requestInvalidations, id)
+			if !dataTypes.request {
+				continue
+			}
+			if invalidate {
+				d.requestInvalidations = append(d.requestInvalidations, id)
 			} else {
-				storedRequestData[id] = data
+				d.requestData[id] = data
 			}
 		case "imp":
-			if len(data) == 0 || bytes.Equal(data, []byte("null")) {
-				impInvalidations = append(impInvalidations, id)
+			if !dataTypes.imp {
+				continue
+			}
+			if invalidate {
+				d.impInvalidations = append(d.impInvalidations, id)
 			} else {
-				storedImpData[id] = data
+				d.impData[id] = data
 			}
 		default:
 			glog.Warningf("Stored Data with id=%s has invalid type: %s. This will be ignored.", id, dataType)
@@ -128,27 +223,69 @@ func sendEvents(rows *sql.Rows, saves chan<- events.Save, invalidations chan<- e
 	}
 
 	// Beware #338... we really don't want to save corrupt data
-	if rows.Err() != nil {
-		return rows.Err()
+	return rows.Err()
+}
+
+// readDeletes reads rows with the columns (id, type). Each of them is an invalidation.
+//
+// Deleted IDs are also dropped from the updates. Soft deletes usually bump last_updated too, and the listener
+// might apply the Save after the Invalidation, which would put the deleted data back in the cache.
+func (d *storedData) readDeletes(rows *sql.Rows, dataTypes storedDataTypes) error {
+	for rows.Next() {
+		var id string
+		var dataType string
+		if err := rows.Scan(&id, &dataType); err != nil {
+			return err
+		}
+
+		switch dataType {
+		case "request":
+			if dataTypes.request {
+				d.requestInvalidations = append(d.requestInvalidations, id)
+				delete(d.requestData, id)
+			}
+		case "imp":
+			if dataTypes.imp {
+				d.impInvalidations = append(d.impInvalidations, id)
+				delete(d.impData, id)
+			}
+		default:
+			glog.Warningf("Deleted Stored Data with id=%s has invalid type: %s. This will be ignored.", id, dataType)
+		}
 	}
+	return rows.Err()
+}
 
-	if len(storedRequestData) > 0 || len(storedImpData) > 0 && saves != nil {
+func (d *storedData) recordRows(metricsEngine pbsmetrics.MetricsEngine, dataTypes storedDataTypes) {
+	if metricsEngine == nil {
+		return
+	}
+	if dataTypes.request {
+		metricsEngine.RecordStoredDataPollRows(pbsmetrics.StoredDataTypeRequest, pbsmetrics.StoredDataEventSave, len(d.requestData))
+		metricsEngine.RecordStoredDataPollRows(pbsmetrics.StoredDataTypeRequest, pbsmetrics.StoredDataEventInvalidate, len(d.requestInvalidations))
+	}
+	if dataTypes.imp {
+		metricsEngine.RecordStoredDataPollRows(pbsmetrics.StoredDataTypeImp, pbsmetrics.StoredDataEventSave, len(d.impData))
+		metricsEngine.RecordStoredDataPollRows(pbsmetrics.StoredDataTypeImp, pbsmetrics.StoredDataEventInvalidate, len(d.impInvalidations))
+	}
+}
+
+func (d *storedData) send(saves chan<- events.Save, invalidations chan<- events.Invalidation) {
+	if (len(d.requestData) > 0 || len(d.impData) > 0) && saves != nil {
 		saves <- events.Save{
-			Requests: storedRequestData,
-			Imps:     storedImpData,
+			Requests: d.requestData,
+			Imps:     d.impData,
 		}
 	}
 
 	// There shouldn't be any invalidations with a nil channel (a "startup" query),
 	// but... if there are, we certainly don't want to block forever.
-	if len(requestInvalidations) > 0 || len(impInvalidations) > 0 && invalidations != nil {
+	if (len(d.requestInvalidations) > 0 || len(d.impInvalidations) > 0) && invalidations != nil {
 		invalidations <- events.Invalidation{
-			Requests: requestInvalidations,
-			Imps:     impInvalidations,
+			Requests: d.requestInvalidations,
+			Imps:     d.impInvalidations,
 		}
 	}
-
-	return nil
 }
 
 func (e *PostgresPoller) Saves() <-chan events.Save {
@@ -1,19 +1,28 @@
 package postgres
 
 import (
+	"errors"
 	"regexp"
 	"testing"
 	"time"
 
 	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/prebid/prebid-server/config"
+	"github.com/prebid/prebid-server/pbsmetrics"
+	metrics "github.com/rcrowley/go-metrics"
 )
 
 const updateQuery = "SELECT id, requestData, type FROM stored_data"
+const deleteQuery = "SELECT id, type FROM stored_data_deletes"
 
 func updateQueryRegex() string {
 	return "^" + regexp.QuoteMeta(updateQuery) + "$"
 }
 
+func deleteQueryRegex() string {
+	return "^" + regexp.QuoteMeta(deleteQuery) + "$"
+}
+
 func TestSuccessfulUpdates(t *testing.T) {
 	db, mock := newMock(t)
 	mockRows := sqlmock.NewRows([]string{"id", "data", "dataType"}).
@@ -27,7 +36,7 @@ func TestSuccessfulUpdates(t *testing.T) {
 
 	mock.ExpectQuery(initialQueryRegex()).WillReturnRows(mockRows)
 
-	evs := PollForUpdates(nil, db, updateQuery, updateStart, time.Duration(-1))
+	evs := PollForUpdates(nil, db, PollingQueries{Update: updateQuery}, updateStart, time.Duration(-1), time.Duration(-1), nil)
 	timeChan := make(chan time.Time)
 	go evs.refresh(timeChan)
 	timeChan <- time.Now()
@@ -46,6 +55,143 @@ func TestSuccessfulUpdates(t *testing.T) {
 	assertSliceContains(t, invalidate.Imps, "stored-imp-3")
 }
 
+func TestDeleteQuery(t *testing.T) {
+	db, mock := newMock(t)
+	updateStart := time.Now()
+	mock.ExpectQuery(updateQueryRegex()).WithArgs(updateStart).WillReturnRows(sqlmock.NewRows([]string{"id", "data", "dataType"}).
+		AddRow("stored-req-1", "true", "request").
+		AddRow("stored-imp-1", "null", "imp"))
+	mock.ExpectQuery(deleteQueryRegex()).WithArgs(updateStart).WillReturnRows(sqlmock.NewRows([]string{"id", "dataType"}).
+		AddRow("stored-req-2", "request").
+		AddRow("stored-imp-2", "imp").
+		AddRow("stored-other", "other"))
+
+	metricsEngine := pbsmetrics.NewMetrics(metrics.NewRegistry(), nil, config.AccountMetrics{})
+	evs := PollForUpdates(nil, db, PollingQueries{Update: updateQuery, Delete: deleteQuery}, updateStart, time.Duration(-1), time.Duration(-1), metricsEngine)
+	if !evs.poll(updateStart, allStoredDataTypes) {
+		t.Fatalf("The poll should succeed.")
+	}
+	assertExpectationsMet(t, mock)
+
+	save := <-evs.Saves()
+	assertMapLength(t, 1, save.Requests)
+	assertMapLength(t, 0, save.Imps)
+
+	invalidate := <-evs.Invalidations()
+	assertNumInvalidations(t, 1, invalidate.Requests)
+	assertSliceContains(t, invalidate.Requests, "stored-req-2")
+	assertNumInvalidations(t, 2, invalidate.Imps)
+	assertSliceContains(t, invalidate.Imps, "stored-imp-1")
+	assertSliceContains(t, invalidate.Imps, "stored-imp-2")
+
+	requestMetrics := metricsEngine.StoredDataMetrics[pbsmetrics.StoredDataTypeRequest]
+	impMetrics := metricsEngine.StoredDataMetrics[pbsmetrics.StoredDataTypeImp]
+	assertPollRows(t, "request saves", requestMetrics.PollRows[pbsmetrics.StoredDataEventSave], 1)
+	assertPollRows(t, "request invalidations", requestMetrics.PollRows[pbsmetrics.StoredDataEventInvalidate], 1)
+	assertPollRows(t, "imp saves", impMetrics.PollRows[pbsmetrics.StoredDataEventSave], 0)
+	assertPollRows(t, "imp invalidations", impMetrics.PollRows[pbsmetrics.StoredDataEventInvalidate], 2)
+}
+
+// TestUpdatedAndDeleted makes sure that IDs which come back from both queries are only invalidated.
+func TestUpdatedAndDeleted(t *testing.T) {
+	db, mock := newMock(t)
+	updateStart := time.Now()
+	mock.ExpectQuery(updateQueryRegex()).WithArgs(updateStart).WillReturnRows(sqlmock.NewRows([]string{"id", "data", "dataType"}).
+		AddRow("stored-req-1", "true", "request").
+		AddRow("stored-imp-1", `{"id":1}`, "imp"))
+	mock.ExpectQuery(deleteQueryRegex()).WithArgs(updateStart).WillReturnRows(sqlmock.NewRows([]string{"id", "dataType"}).
+		AddRow("stored-req-1", "request").
+		AddRow("stored-imp-1", "imp"))
+
+	evs := PollForUpdates(nil, db, PollingQueries{Update: updateQuery, Delete: deleteQuery}, updateStart, time.Duration(-1), time.Duration(-1), nil)
+	if !evs.poll(updateStart, allStoredDataTypes) {
+		t.Fatalf("The poll should succeed.")
+	}
+	assertExpectationsMet(t, mock)
+
+	invalidate := <-evs.Invalidations()
+	assertNumInvalidations(t, 1, invalidate.Requests)
+	assertSliceContains(t, invalidate.Requests, "stored-req-1")
+	assertNumInvalidations(t, 1, invalidate.Imps)
+	assertSliceContains(t, invalidate.Imps, "stored-imp-1")
+	assertNoEvents(t, evs)
+}
+
+func TestDeleteQueryError(t *testing.T) {
+	db, mock := newMock(t)
+	updateStart := time.Now()
+	mock.ExpectQuery(updateQueryRegex()).WillReturnRows(sqlmock.NewRows([]string{"id", "data", "dataType"}).
+		AddRow("stored-req-1", "true", "request"))
+	mock.ExpectQuery(deleteQueryRegex()).WillReturnError(errors.New("Query failed."))
+
+	evs := PollForUpdates(nil, db, PollingQueries{Update: updateQuery, Delete: deleteQuery}, updateStart, time.Duration(-1), time.Duration(-1), nil)
+	if evs.poll(updateStart, allStoredDataTypes) {
+		t.Errorf("The poll should fail if the delete query fails, so that the same updates get polled again.")
+	}
+	assertExpectationsMet(t, mock)
+	assertNoEvents(t, evs)
+}
+
+func TestPollDataTypes(t *testing.T) {
+	db, mock := newMock(t)
+	updateStart := time.Now()
+	mock.ExpectQuery(updateQueryRegex()).WillReturnRows(sqlmock.NewRows([]string{"id", "data", "dataType"}).
+		AddRow("stored-req-1", "true", "request").
+		AddRow("stored-imp-1", `{"id":1}`, "imp").
+		AddRow("stored-imp-2", "null", "imp"))
+
+	evs := PollForUpdates(nil, db, PollingQueries{Update: updateQuery}, updateStart, time.Duration(-1), time.Duration(-1), nil)
+	if !evs.poll(updateStart, storedDataTypes{imp: true}) {
+		t.Fatalf("The poll should succeed.")
+	}
+
+	save := <-evs.Saves()
+	assertMapLength(t, 0, save.Requests)
+	assertMapLength(t, 1, save.Imps)
+	invalidate := <-evs.Invalidations()
+	assertNumInvalidations(t, 0, invalidate.Requests)
+	assertNumInvalidations(t, 1, invalidate.Imps)
+}
+
+func TestSeparateRefreshRates(t *testing.T) {
+	db, mock := newMock(t)
+	updateStart := time.Now()
+	requestTime := updateStart.Add(time.Minute)
+	mock.ExpectQuery(updateQueryRegex()).WithArgs(updateStart).WillReturnRows(sqlmock.NewRows([]string{"id", "data", "dataType"}).
+		AddRow("stored-req-1", "true", "request"))
+	mock.ExpectQuery(updateQueryRegex()).WithArgs(requestTime.UTC()).WillReturnRows(sqlmock.NewRows([]string{"id", "data", "dataType"}))
+
+	evs := PollForUpdates(nil, db, PollingQueries{Update: updateQuery}, updateStart, time.Duration(-1), time.Duration(-1), nil)
+	timeChan := make(chan time.Time)
+	go evs.refreshTypes(timeChan, updateStart, storedDataTypes{request: true})
+	timeChan <- requestTime
+	save := <-evs.Saves()
+	assertMapLength(t, 1, save.Requests)
+
+	// The second poll should only ask for updates since the first one.
+	timeChan <- updateStart.Add(2 * time.Minute)
+	timeChan <- updateStart.Add(3 * time.Minute)
+	assertExpectationsMet(t, mock)
+}
+
+func assertPollRows(t *testing.T, description string, histogram metrics.Histogram, expected int64) {
+	t.Helper()
+	if histogram.Count() != 1 || histogram.Sum() != expected {
+		t.Errorf("Expected one poll with %d %s. Got %d polls with %d rows.", expected, description, histogram.Count(), histogram.Sum())
+	}
+}
+
+func assertNoEvents(t *testing.T, evs *PostgresPoller) {
+	t.Helper()
+	select {
+	case save := <-evs.Saves():
+		t.Errorf("Expected no saves. Got %v", save)
+	case invalidation := <-evs.Invalidations():
+		t.Errorf("Expected no invalidations. Got %v", invalidation)
+	default:
+	}
+}
+
 func assertNumInvalidations(t *testing.T, expected int, vals []string) {
 	t.Helper()
 
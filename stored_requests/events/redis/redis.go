@@ -0,0 +1,99 @@
+package redis
+
+import (
+	"encoding/json"
+
+	"github.com/go-redis/redis"
+	"github.com/golang/glog"
+	"github.com/prebid/prebid-server/stored_requests/events"
+)
+
+// NewRedisEvents makes an EventProducer which creates events from the messages published
+// to a Redis pub/sub channel. Every PBS instance which subscribes to the channel gets every message,
+// so an update or invalidation only needs to be published once to reach the whole fleet.
+//
+// Each message should be JSON like this:
+//
+// {
+//   "save": {
+//     "requests": {
+//       "request1": { ... stored request data ... },
+//     },
+//     "imps": {
+//       "imp1": { ... stored data for imp1 ... },
+//     }
+//   },
+//   "invalidate": {
+//     "requests": ["request2"],
+//     "imps": ["imp2"]
+//   }
+// }
+//
+// Either "save" or "invalidate" may be left out. For example:
+//
+// PUBLISH {channel} '{"invalidate":{"requests":["request2"]}}'
+//
+// Redis doesn't store pub/sub messages, so instances which are disconnected when a message is
+// published will miss it. Cached values should still have a TTL.
+func NewRedisEvents(client *redis.Client, channel string) *RedisEvents {
+	e := &RedisEvents{
+		Channel:       channel,
+		saves:         make(chan events.Save, 1),
+		invalidations: make(chan events.Invalidation, 1),
+	}
+
+	glog.Infof("Subscribing to Stored Request updates on Redis channel %s", channel)
+	pubsub := client.Subscribe(channel)
+	// Wait for the subscription, so that messages published after this returns are never missed.
+	if _, err := pubsub.ReceiveTimeout(client.Options().ReadTimeout); err != nil {
+		glog.Errorf("Failed to subscribe to Redis channel %s. The subscription will be retried: %v", channel, err)
+	}
+
+	go e.listen(pubsub.Channel())
+	return e
+}
+
+type RedisEvents struct {
+	Channel       string
+	saves         chan events.Save
+	invalidations chan events.Invalidation
+}
+
+// message is the format of the messages published to the channel.
+type message struct {
+	Save       *events.Save         `json:"save"`
+	Invalidate *events.Invalidation `json:"invalidate"`
+}
+
+func (e *RedisEvents) listen(messages <-chan *redis.Message) {
+	for msg := range messages {
+		e.handle(msg.Payload)
+	}
+}
+
+func (e *RedisEvents) handle(payload string) {
+	var msg message
+	if err := json.Unmarshal([]byte(payload), &msg); err != nil {
+		glog.Errorf("Invalid message on Redis channel %s: %v", e.Channel, err)
+		return
+	}
+	if msg.Save == nil && msg.Invalidate == nil {
+		glog.Errorf(`Message on Redis channel %s has no "save" or "invalidate" data`, e.Channel)
+		return
+	}
+
+	if msg.Invalidate != nil {
+		e.invalidations <- *msg.Invalidate
+	}
+	if msg.Save != nil {
+		e.saves <- *msg.Save
+	}
+}
+
+func (e *RedisEvents) Saves() <-chan events.Save {
+	return e.saves
+}
+
+func (e *RedisEvents) Invalidations() <-chan events.Invalidation {
+	return e.invalidations
+}
@@ -0,0 +1,133 @@
+package redis
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"github.com/go-redis/redis"
+	"github.com/prebid/prebid-server/stored_requests/events"
+)
+
+func TestSave(t *testing.T) {
+	server, ev := newTestEvents(t)
+	defer server.Close()
+
+	server.Publish("updates", `{"save":{"requests":{"request1":{"value":1}},"imps":{"imp1":{"value":2}}}}`)
+	theSave := nextSave(t, ev)
+
+	assertLen(t, theSave.Requests, 1)
+	assertHasValue(t, theSave.Requests, "request1", `{"value":1}`)
+	assertLen(t, theSave.Imps, 1)
+	assertHasValue(t, theSave.Imps, "imp1", `{"value":2}`)
+}
+
+func TestInvalidate(t *testing.T) {
+	server, ev := newTestEvents(t)
+	defer server.Close()
+
+	server.Publish("updates", `{"invalidate":{"requests":["request1"],"imps":["imp1","imp2"]}}`)
+	theInvalidation := nextInvalidation(t, ev)
+
+	assertStrings(t, theInvalidation.Requests, []string{"request1"})
+	assertStrings(t, theInvalidation.Imps, []string{"imp1", "imp2"})
+}
+
+func TestSaveAndInvalidate(t *testing.T) {
+	server, ev := newTestEvents(t)
+	defer server.Close()
+
+	server.Publish("updates", `{"save":{"requests":{"request1":{}}},"invalidate":{"requests":["request2"]}}`)
+	theInvalidation := nextInvalidation(t, ev)
+	theSave := nextSave(t, ev)
+
+	assertStrings(t, theInvalidation.Requests, []string{"request2"})
+	assertLen(t, theSave.Requests, 1)
+	assertHasValue(t, theSave.Requests, "request1", `{}`)
+}
+
+func TestBadMessages(t *testing.T) {
+	server, ev := newTestEvents(t)
+	defer server.Close()
+
+	server.Publish("updates", `not json`)
+	server.Publish("updates", `{}`)
+	server.Publish("other-channel", `{"invalidate":{"requests":["other"]}}`)
+	server.Publish("updates", `{"invalidate":{"requests":["request1"]}}`)
+
+	// Bad messages and other channels should be skipped, so the first event is from the last message.
+	theInvalidation := nextInvalidation(t, ev)
+	assertStrings(t, theInvalidation.Requests, []string{"request1"})
+	select {
+	case save := <-ev.Saves():
+		t.Errorf("Unexpected save: %v", save)
+	default:
+	}
+}
+
+func newTestEvents(t *testing.T) (*miniredis.Miniredis, *RedisEvents) {
+	t.Helper()
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Failed to start a local Redis server: %v", err)
+	}
+	client := redis.NewClient(&redis.Options{
+		Addr: server.Addr(),
+	})
+	return server, NewRedisEvents(client, "updates")
+}
+
+func nextSave(t *testing.T, ev events.EventProducer) events.Save {
+	t.Helper()
+	select {
+	case save := <-ev.Saves():
+		return save
+	case <-time.After(time.Second):
+		t.Fatalf("No save was received from the channel.")
+	}
+	return events.Save{}
+}
+
+func nextInvalidation(t *testing.T, ev events.EventProducer) events.Invalidation {
+	t.Helper()
+	select {
+	case invalidation := <-ev.Invalidations():
+		return invalidation
+	case <-time.After(time.Second):
+		t.Fatalf("No invalidation was received from the channel.")
+	}
+	return events.Invalidation{}
+}
+
+func assertLen(t *testing.T, m map[string]json.RawMessage, length int) {
+	t.Helper()
+	if len(m) != length {
+		t.Errorf("Expected map with %d elements, but got %v", length, m)
+	}
+}
+
+func assertHasValue(t *testing.T, m map[string]json.RawMessage, key string, val string) {
+	t.Helper()
+	if mapVal, ok := m[key]; ok {
+		if string(mapVal) != val {
+			t.Errorf("expected map[%s] to be %s, but got %s", key, val, string(mapVal))
+		}
+	} else {
+		t.Errorf("map missing expected key: %s", key)
+	}
+}
+
+func assertStrings(t *testing.T, actual []string, expected []string) {
+	t.Helper()
+	if len(actual) != len(expected) {
+		t.Errorf("Expected %v, but got %v", expected, actual)
+		return
+	}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Errorf("Expected %v, but got %v", expected, actual)
+			return
+		}
+	}
+}
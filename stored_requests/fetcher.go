@@ -2,8 +2,14 @@ package stored_requests
 
 import (
 	"context"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"time"
+
+	"github.com/coocood/freecache"
+	"github.com/golang/glog"
+	"github.com/prebid/prebid-server/pbsmetrics"
 )
 
 // Fetcher knows how to fetch Stored Request data by id.
@@ -35,6 +41,12 @@ func (e NotFoundError) Error() string {
 	return fmt.Sprintf(`Stored %s with ID="%s" not found.`, e.DataType, e.ID)
 }
 
+// The DataTypes used in NotFoundErrors.
+const (
+	requestDataType = "Request"
+	impDataType     = "Imp"
+)
+
 // Cache is an intermediate layer which can be used to create more complex Fetchers by composition.
 // Implementations must be safe for concurrent access by multiple goroutines.
 // To add a Cache layer in front of a Fetcher, see WithCache()
@@ -118,20 +130,66 @@ func (c ComposedCache) Save(ctx context.Context, requestData map[string]json.Raw
 	}
 }
 
+// CacheOptions configure how WithCache uses its Cache.
+type CacheOptions struct {
+	// RefreshAfter is the age at which cached data becomes stale. Stale data is still returned,
+	// but it gets refreshed from the Fetcher in the background. If 0, cached data is never refreshed.
+	RefreshAfter time.Duration
+	// RefreshTimeout is the amount of time allowed for each background refresh.
+	RefreshTimeout time.Duration
+	// NotFoundTTL is how long to remember the IDs which the Fetcher couldn't find. Until it expires,
+	// those IDs get a NotFoundError without calling the Fetcher again. If 0, they aren't remembered.
+	NotFoundTTL time.Duration
+}
+
+// trackingCacheSize is the number of bytes used to track the fetch times and NotFound IDs for each Fetcher.
+// If it fills up, the oldest entries are dropped. Dropped fetch times are reset to the next time the data is used.
+const trackingCacheSize = 4 * 1024 * 1024
+
 type fetcherWithCache struct {
-	fetcher Fetcher
-	cache   Cache
+	fetcher       Fetcher
+	cache         Cache
+	options       CacheOptions
+	metricsEngine pbsmetrics.MetricsEngine
+	flights       flightGroup
+	// fetchedAt holds the time when each cached ID was fetched. It's nil if cached data is never refreshed.
+	fetchedAt *freecache.Cache
+	// notFound holds the IDs which the Fetcher couldn't find. It's nil if they aren't remembered.
+	notFound *freecache.Cache
+}
+
+// CachingFetcher is a Fetcher with a Cache in front of it.
+//
+// It's also a Cache itself. Updates and invalidations from EventProducers should be sent to it rather than
+// the underlying Cache, so that it forgets the fetch times and NotFound IDs which it tracks for that data.
+type CachingFetcher interface {
+	Fetcher
+	Cache
 }
 
 // WithCache returns a Fetcher which uses the given Cache before delegating to the original.
 // This can be called multiple times to compose Cache layers onto the backing Fetcher, though
 // it is usually more desirable to first compose caches with Compose, ensuring propagation of updates
 // and invalidations through all cache layers.
-func WithCache(fetcher Fetcher, cache Cache) Fetcher {
-	return &fetcherWithCache{
-		cache:   cache,
-		fetcher: fetcher,
+//
+// Concurrent calls which miss the cache for the same ID share a single call to the Fetcher.
+// The options can enable background refreshes of stale data and caching of NotFoundErrors.
+//
+// The cache hits and misses for every ID are recorded in the metricsEngine.
+func WithCache(fetcher Fetcher, cache Cache, options CacheOptions, metricsEngine pbsmetrics.MetricsEngine) CachingFetcher {
+	f := &fetcherWithCache{
+		cache:         cache,
+		fetcher:       fetcher,
+		options:       options,
+		metricsEngine: metricsEngine,
 	}
+	if options.RefreshAfter > 0 {
+		f.fetchedAt = freecache.NewCache(trackingCacheSize)
+	}
+	if options.NotFoundTTL > 0 {
+		f.notFound = freecache.NewCache(trackingCacheSize)
+	}
+	return f
 }
 
 func (f *fetcherWithCache) FetchRequests(ctx context.Context, requestIDs []string, impIDs []string) (requestData map[string]json.RawMessage, impData map[string]json.RawMessage, errs []error) {
@@ -141,11 +199,21 @@ func (f *fetcherWithCache) FetchRequests(ctx context.Context, requestIDs []strin
 	leftoverImps := findLeftovers(impIDs, impData)
 	leftoverReqs := findLeftovers(requestIDs, requestData)
 
-	if len(leftoverReqs) > 0 || len(leftoverImps) > 0 {
-		fetcherReqData, fetcherImpData, fetcherErrs := f.fetcher.FetchRequests(ctx, leftoverReqs, leftoverImps)
-		errs = fetcherErrs
+	if len(requestIDs) > 0 {
+		f.metricsEngine.RecordStoredDataCacheResult(pbsmetrics.StoredDataTypeRequest, len(requestIDs)-len(leftoverReqs), len(leftoverReqs))
+	}
+	if len(impIDs) > 0 {
+		f.metricsEngine.RecordStoredDataCacheResult(pbsmetrics.StoredDataTypeImp, len(impIDs)-len(leftoverImps), len(leftoverImps))
+	}
+
+	f.refreshStale(requestData, impData)
 
-		f.cache.Save(ctx, fetcherReqData, fetcherImpData)
+	leftoverReqs, errs = f.dropNotFound(requestDataType, leftoverReqs, errs)
+	leftoverImps, errs = f.dropNotFound(impDataType, leftoverImps, errs)
+
+	if len(leftoverReqs) > 0 || len(leftoverImps) > 0 {
+		fetcherReqData, fetcherImpData, fetcherErrs := f.fetch(ctx, leftoverReqs, leftoverImps)
+		errs = append(errs, fetcherErrs...)
 
 		requestData = mergeData(requestData, fetcherReqData)
 		impData = mergeData(impData, fetcherImpData)
@@ -154,6 +222,189 @@ func (f *fetcherWithCache) FetchRequests(ctx context.Context, requestIDs []strin
 	return
 }
 
+func (f *fetcherWithCache) Get(ctx context.Context, requestIDs []string, impIDs []string) (requestData map[string]json.RawMessage, impData map[string]json.RawMessage) {
+	return f.cache.Get(ctx, requestIDs, impIDs)
+}
+
+// Save puts new data into the Cache. Any NotFound IDs or fetch times for it are dropped,
+// since the data is now known to exist and be fresh.
+func (f *fetcherWithCache) Save(ctx context.Context, requestData map[string]json.RawMessage, impData map[string]json.RawMessage) {
+	f.cache.Save(ctx, requestData, impData)
+	for id := range requestData {
+		f.forget(requestDataType, id)
+	}
+	for id := range impData {
+		f.forget(impDataType, id)
+	}
+}
+
+// Invalidate removes data from the Cache, along with any NotFound IDs or fetch times for it.
+// The next request for it will go to the Fetcher.
+func (f *fetcherWithCache) Invalidate(ctx context.Context, requestIDs []string, impIDs []string) {
+	f.cache.Invalidate(ctx, requestIDs, impIDs)
+	for _, id := range requestIDs {
+		f.forget(requestDataType, id)
+	}
+	for _, id := range impIDs {
+		f.forget(impDataType, id)
+	}
+}
+
+// forget drops everything which is being tracked about an ID.
+func (f *fetcherWithCache) forget(dataType string, id string) {
+	key := []byte(dataKey(dataType, id))
+	if f.fetchedAt != nil {
+		f.fetchedAt.Del(key)
+	}
+	if f.notFound != nil {
+		f.notFound.Del(key)
+	}
+}
+
+// fetch gets data from the Fetcher. If another call is already fetching some of the IDs,
+// this waits for its results rather than fetching them again.
+func (f *fetcherWithCache) fetch(ctx context.Context, requestIDs []string, impIDs []string) (requestData map[string]json.RawMessage, impData map[string]json.RawMessage, errs []error) {
+	startedReqs, joinedReqs := f.flights.start(requestDataType, requestIDs)
+	startedImps, joinedImps := f.flights.start(impDataType, impIDs)
+
+	if len(startedReqs) > 0 || len(startedImps) > 0 {
+		requestData, impData, errs = f.fetcher.FetchRequests(ctx, startedReqs, startedImps)
+		f.save(ctx, requestData, impData, errs)
+
+		batch := newBatchErrors(errs)
+		f.flights.land(requestDataType, startedReqs, requestData, errs, batch)
+		f.flights.land(impDataType, startedImps, impData, errs, batch)
+	}
+
+	if len(joinedReqs) == 0 && len(joinedImps) == 0 {
+		return
+	}
+
+	// The Fetcher's maps can't be written to, so the joined data needs new ones.
+	requestData = mergeData(make(map[string]json.RawMessage, len(requestIDs)), requestData)
+	impData = mergeData(make(map[string]json.RawMessage, len(impIDs)), impData)
+	seen := make(map[*batchErrors]bool)
+	var err error
+	if errs, err = await(ctx, joinedReqs, requestData, errs, seen); err == nil {
+		errs, err = await(ctx, joinedImps, impData, errs, seen)
+	}
+	if err != nil {
+		errs = append(errs, err)
+	}
+	return
+}
+
+// save puts the results from the Fetcher into the caches.
+func (f *fetcherWithCache) save(ctx context.Context, requestData map[string]json.RawMessage, impData map[string]json.RawMessage, errs []error) {
+	f.cache.Save(ctx, requestData, impData)
+
+	if f.fetchedAt != nil {
+		now := time.Now()
+		for id := range requestData {
+			f.setFetchedAt(requestDataType, id, now)
+		}
+		for id := range impData {
+			f.setFetchedAt(impDataType, id, now)
+		}
+	}
+
+	if f.notFound != nil {
+		ttlSeconds := int(f.options.NotFoundTTL / time.Second)
+		if ttlSeconds < 1 {
+			ttlSeconds = 1
+		}
+		for _, err := range errs {
+			if nfErr, ok := err.(NotFoundError); ok {
+				f.notFound.Set([]byte(dataKey(nfErr.DataType, nfErr.ID)), []byte{}, ttlSeconds)
+			}
+		}
+	}
+}
+
+// dropNotFound removes the IDs which the Fetcher couldn't find recently, and returns NotFoundErrors for them instead.
+func (f *fetcherWithCache) dropNotFound(dataType string, ids []string, errs []error) ([]string, []error) {
+	if f.notFound == nil || len(ids) == 0 {
+		return ids, errs
+	}
+
+	remaining := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if _, err := f.notFound.Get([]byte(dataKey(dataType, id))); err == nil {
+			errs = append(errs, NotFoundError{id, dataType})
+		} else {
+			remaining = append(remaining, id)
+		}
+	}
+	return remaining, errs
+}
+
+// refreshStale starts a background refresh for any cached data which is older than options.RefreshAfter.
+// IDs which are already being fetched are skipped.
+func (f *fetcherWithCache) refreshStale(requestData map[string]json.RawMessage, impData map[string]json.RawMessage) {
+	if f.fetchedAt == nil {
+		return
+	}
+
+	staleReqs, _ := f.flights.start(requestDataType, f.findStale(requestDataType, requestData))
+	staleImps, _ := f.flights.start(impDataType, f.findStale(impDataType, impData))
+	if len(staleReqs) > 0 || len(staleImps) > 0 {
+		go f.refresh(staleReqs, staleImps)
+	}
+}
+
+func (f *fetcherWithCache) findStale(dataType string, data map[string]json.RawMessage) (stale []string) {
+	now := time.Now()
+	for id := range data {
+		fetchedAt, err := f.fetchedAt.Get([]byte(dataKey(dataType, id)))
+		if err != nil {
+			// This data was saved by an EventProducer, or by another PBS instance sharing the Cache.
+			// Its age isn't known, so count it from now.
+			f.setFetchedAt(dataType, id, now)
+			continue
+		}
+		if now.Sub(time.Unix(0, int64(binary.BigEndian.Uint64(fetchedAt)))) >= f.options.RefreshAfter {
+			stale = append(stale, id)
+		}
+	}
+	return
+}
+
+func (f *fetcherWithCache) setFetchedAt(dataType string, id string, fetchedAt time.Time) {
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, uint64(fetchedAt.UnixNano()))
+	f.fetchedAt.Set([]byte(dataKey(dataType, id)), value, 0)
+}
+
+// refresh fetches stale data from the Fetcher and saves it into the cache. Data which the Fetcher
+// no longer has is invalidated. If the Fetcher fails for any other reason, the stale data is kept.
+func (f *fetcherWithCache) refresh(requestIDs []string, impIDs []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), f.options.RefreshTimeout)
+	defer cancel()
+
+	requestData, impData, errs := f.fetcher.FetchRequests(ctx, requestIDs, impIDs)
+	f.save(ctx, requestData, impData, errs)
+
+	var goneReqs, goneImps []string
+	for _, err := range errs {
+		if nfErr, ok := err.(NotFoundError); ok {
+			if nfErr.DataType == impDataType {
+				goneImps = append(goneImps, nfErr.ID)
+			} else {
+				goneReqs = append(goneReqs, nfErr.ID)
+			}
+		} else {
+			glog.Errorf("Failed to refresh stale Stored Requests: %v", err)
+		}
+	}
+	if len(goneReqs) > 0 || len(goneImps) > 0 {
+		f.cache.Invalidate(ctx, goneReqs, goneImps)
+	}
+
+	batch := newBatchErrors(errs)
+	f.flights.land(requestDataType, requestIDs, requestData, errs, batch)
+	f.flights.land(impDataType, impIDs, impData, errs, batch)
+}
+
 func findLeftovers(ids []string, data map[string]json.RawMessage) (leftovers []string) {
 	leftovers = make([]string, 0, len(ids)-len(data))
 	for _, id := range ids {
@@ -6,7 +6,13 @@ import (
 	"encoding/json"
 	"errors"
 	"reflect"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/prebid/prebid-server/config"
+	"github.com/prebid/prebid-server/pbsmetrics"
+	"github.com/rcrowley/go-metrics"
 )
 
 func TestPerfectCache(t *testing.T) {
@@ -19,7 +25,7 @@ func TestPerfectCache(t *testing.T) {
 		},
 	}
 	fetcher := &mockFetcher{}
-	composed := WithCache(fetcher, cache)
+	composed := WithCache(fetcher, cache, CacheOptions{}, newTestMetrics())
 	ids := []string{"known"}
 	composed.FetchRequests(context.Background(), []string{"req-id"}, ids)
 
@@ -53,7 +59,8 @@ func TestImperfectCache(t *testing.T) {
 			"uncached": json.RawMessage(`false`),
 		},
 	}
-	composed := WithCache(fetcher, cache)
+	metricsEngine := newTestMetrics()
+	composed := WithCache(fetcher, cache, CacheOptions{}, metricsEngine)
 	ids := []string{"cached", "uncached"}
 	reqData, fetchedData, errs := composed.FetchRequests(context.Background(), nil, ids)
 
@@ -92,6 +99,14 @@ func TestImperfectCache(t *testing.T) {
 	if cachedData, _ := fetchedData["uncached"]; !bytes.Equal(cachedData, []byte("false")) {
 		t.Errorf("Uncached data was corrupted. Expected false, got %s", string(cachedData))
 	}
+
+	impMetrics := metricsEngine.StoredDataMetrics[pbsmetrics.StoredDataTypeImp]
+	if hits, misses := impMetrics.CacheHitMeter.Count(), impMetrics.CacheMissMeter.Count(); hits != 1 || misses != 1 {
+		t.Errorf("Expected 1 cache hit and 1 miss for Stored Imps. Got %d hits and %d misses", hits, misses)
+	}
+	if requests := metricsEngine.StoredDataMetrics[pbsmetrics.StoredDataTypeRequest]; requests.CacheHitMeter.Count() != 0 || requests.CacheMissMeter.Count() != 0 {
+		t.Error("No cache results should be recorded for Stored Requests if none were asked for.")
+	}
 }
 
 func TestMissingData(t *testing.T) {
@@ -99,7 +114,7 @@ func TestMissingData(t *testing.T) {
 	fetcher := &mockFetcher{
 		returnErrs: []error{errors.New("Data not found")},
 	}
-	composed := WithCache(fetcher, cache)
+	composed := WithCache(fetcher, cache, CacheOptions{}, newTestMetrics())
 	_, fetchedData, errs := composed.FetchRequests(context.Background(), nil, []string{"unknown"})
 	if len(errs) != 1 {
 		t.Errorf("Errors from the delegate fetcher should be returned. Got %d errors.", len(errs))
@@ -120,7 +135,7 @@ func TestCacheSaves(t *testing.T) {
 		},
 	}
 	fetcher := &mockFetcher{}
-	composed := WithCache(fetcher, cache)
+	composed := WithCache(fetcher, cache, CacheOptions{}, newTestMetrics())
 	composed.FetchRequests(context.Background(), nil, []string{"abc", "abc"})
 	if len(fetcher.gotImpQuery) != 0 {
 		t.Errorf("No IDs should be requested from the fetcher for requests with duplicate ID. Got %#v", fetcher.gotImpQuery)
@@ -164,7 +179,7 @@ func TestComposedCache(t *testing.T) {
 	cache := ComposedCache{c1, c2, c3, c4}
 
 	fetcher := &mockFetcher{}
-	composed := WithCache(fetcher, cache)
+	composed := WithCache(fetcher, cache, CacheOptions{}, newTestMetrics())
 	fetchedReqs, fetchedImps, errs := composed.FetchRequests(context.Background(), []string{"1", "2", "3"}, []string{"1", "2", "3"})
 
 	if len(errs) != 0 {
@@ -190,6 +205,10 @@ func TestComposedCache(t *testing.T) {
 	}
 }
 
+func newTestMetrics() *pbsmetrics.Metrics {
+	return pbsmetrics.NewMetrics(metrics.NewRegistry(), nil, config.AccountMetrics{})
+}
+
 type mockFetcher struct {
 	mockGetReqs map[string]json.RawMessage
 	mockGetImps map[string]json.RawMessage
@@ -237,3 +256,259 @@ func (c *mockCache) Invalidate(ctx context.Context, requestIDs []string, impIDs
 	c.gotInvalidateReqs = requestIDs
 	c.gotInvalidateImps = impIDs
 }
+
+func TestNotFoundCaching(t *testing.T) {
+	fetcher := &countingFetcher{
+		errs: []error{NotFoundError{"missing", "Request"}},
+	}
+	composed := WithCache(fetcher, newSyncCache(), CacheOptions{NotFoundTTL: time.Minute}, newTestMetrics())
+
+	for i := 0; i < 2; i++ {
+		_, _, errs := composed.FetchRequests(context.Background(), []string{"missing"}, nil)
+		if len(errs) != 1 {
+			t.Fatalf("Expected 1 error on call %d. Got %v", i, errs)
+		}
+		if nfErr, ok := errs[0].(NotFoundError); !ok || nfErr.ID != "missing" || nfErr.DataType != "Request" {
+			t.Errorf("Expected a NotFoundError for the missing Request on call %d. Got %v", i, errs[0])
+		}
+	}
+	if calls := fetcher.callCount(); calls != 1 {
+		t.Errorf("IDs which weren't found should be remembered. Expected 1 call to the fetcher, got %d", calls)
+	}
+}
+
+func TestNotFoundCachingDisabled(t *testing.T) {
+	fetcher := &countingFetcher{
+		errs: []error{NotFoundError{"missing", "Imp"}},
+	}
+	composed := WithCache(fetcher, newSyncCache(), CacheOptions{}, newTestMetrics())
+	composed.FetchRequests(context.Background(), nil, []string{"missing"})
+	composed.FetchRequests(context.Background(), nil, []string{"missing"})
+	if calls := fetcher.callCount(); calls != 2 {
+		t.Errorf("IDs which weren't found should not be remembered if the TTL is 0. Expected 2 calls to the fetcher, got %d", calls)
+	}
+}
+
+func TestFetchCoalescing(t *testing.T) {
+	fetcher := &countingFetcher{
+		reqs:    map[string]json.RawMessage{"req": json.RawMessage(`{"req":true}`)},
+		release: make(chan struct{}),
+	}
+	cache := newSyncCache()
+	composed := WithCache(fetcher, cache, CacheOptions{}, newTestMetrics())
+
+	const callers = 5
+	results := make(chan map[string]json.RawMessage, callers)
+	for i := 0; i < callers; i++ {
+		go func() {
+			reqData, _, _ := composed.FetchRequests(context.Background(), []string{"req"}, nil)
+			results <- reqData
+		}()
+	}
+	// Give the other callers a chance to join the first one's fetch before it finishes.
+	for i := 0; i < callers; i++ {
+		<-cache.gets
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(fetcher.release)
+
+	for i := 0; i < callers; i++ {
+		reqData := <-results
+		if string(reqData["req"]) != `{"req":true}` {
+			t.Errorf("Every caller should get the fetched data. Got %v", reqData)
+		}
+	}
+	if calls := fetcher.callCount(); calls != 1 {
+		t.Errorf("Concurrent misses for the same ID should share one fetch. Got %d calls to the fetcher", calls)
+	}
+}
+
+func TestStaleRefresh(t *testing.T) {
+	fetcher := &countingFetcher{
+		reqs: map[string]json.RawMessage{"req": json.RawMessage(`{"version":2}`)},
+	}
+	cache := newSyncCache()
+	cache.Save(context.Background(), map[string]json.RawMessage{"req": json.RawMessage(`{"version":1}`)}, nil)
+	<-cache.saves
+	composed := WithCache(fetcher, cache, CacheOptions{RefreshAfter: time.Millisecond, RefreshTimeout: time.Second}, newTestMetrics())
+
+	// The age of data saved outside the fetcher isn't known, so the first call treats it as fresh.
+	assertFetchedRequest(t, composed, "req", `{"version":1}`)
+	time.Sleep(5 * time.Millisecond)
+	assertFetchedRequest(t, composed, "req", `{"version":1}`)
+
+	select {
+	case <-cache.saves:
+	case <-time.After(time.Second):
+		t.Fatalf("Stale data should be refreshed in the background.")
+	}
+	assertFetchedRequest(t, composed, "req", `{"version":2}`)
+}
+
+func TestRefreshInvalidatesNotFound(t *testing.T) {
+	fetcher := &countingFetcher{
+		errs: []error{NotFoundError{"imp", "Imp"}},
+	}
+	cache := newSyncCache()
+	cache.Save(context.Background(), nil, map[string]json.RawMessage{"imp": json.RawMessage(`{}`)})
+	<-cache.saves
+	composed := WithCache(fetcher, cache, CacheOptions{RefreshAfter: time.Millisecond, RefreshTimeout: time.Second}, newTestMetrics())
+
+	composed.FetchRequests(context.Background(), nil, []string{"imp"})
+	time.Sleep(5 * time.Millisecond)
+	composed.FetchRequests(context.Background(), nil, []string{"imp"})
+
+	select {
+	case <-cache.invalidations:
+	case <-time.After(time.Second):
+		t.Fatalf("Data which the fetcher no longer has should be invalidated.")
+	}
+	_, impData := cache.Get(context.Background(), nil, []string{"imp"})
+	if len(impData) != 0 {
+		t.Errorf("The invalidated Imp should not be cached. Got %v", impData)
+	}
+}
+
+// TestEventsResetTracking makes sure that saves and invalidations from events drop the NotFound IDs
+// and fetch times which the CachingFetcher remembered for that data.
+func TestEventsResetTracking(t *testing.T) {
+	fetcher := &countingFetcher{
+		errs: []error{NotFoundError{"missing", "Request"}},
+	}
+	composed := WithCache(fetcher, newSyncCache(), CacheOptions{NotFoundTTL: time.Minute}, newTestMetrics())
+
+	composed.FetchRequests(context.Background(), []string{"missing"}, nil)
+	composed.Invalidate(context.Background(), []string{"missing"}, nil)
+	composed.FetchRequests(context.Background(), []string{"missing"}, nil)
+	if calls := fetcher.callCount(); calls != 2 {
+		t.Errorf("Invalidations should drop the NotFound IDs. Expected 2 calls to the fetcher, got %d", calls)
+	}
+
+	fetcher = &countingFetcher{
+		reqs: map[string]json.RawMessage{"req": json.RawMessage(`{"version":1}`)},
+	}
+	cache := newSyncCache()
+	composed = WithCache(fetcher, cache, CacheOptions{RefreshAfter: 5 * time.Millisecond, RefreshTimeout: time.Second}, newTestMetrics())
+	assertFetchedRequest(t, composed, "req", `{"version":1}`)
+	<-cache.saves
+	time.Sleep(10 * time.Millisecond)
+	composed.Save(context.Background(), map[string]json.RawMessage{"req": json.RawMessage(`{"version":2}`)}, nil)
+	<-cache.saves
+
+	assertFetchedRequest(t, composed, "req", `{"version":2}`)
+	select {
+	case <-cache.saves:
+		t.Errorf("Data which was just saved by an event shouldn't be refreshed.")
+	case <-time.After(20 * time.Millisecond):
+	}
+	if calls := fetcher.callCount(); calls != 1 {
+		t.Errorf("Expected 1 call to the fetcher, got %d", calls)
+	}
+}
+
+func assertFetchedRequest(t *testing.T, fetcher Fetcher, id string, expected string) {
+	t.Helper()
+	reqData, _, errs := fetcher.FetchRequests(context.Background(), []string{id}, nil)
+	if len(errs) != 0 {
+		t.Errorf("Unexpected errors: %v", errs)
+	}
+	if string(reqData[id]) != expected {
+		t.Errorf("Expected %s, got %s", expected, string(reqData[id]))
+	}
+}
+
+// countingFetcher is a mockFetcher which can be used from several goroutines.
+// If release is non-nil, calls block until it's closed.
+type countingFetcher struct {
+	reqs    map[string]json.RawMessage
+	imps    map[string]json.RawMessage
+	errs    []error
+	release chan struct{}
+
+	mu    sync.Mutex
+	calls int
+}
+
+func (f *countingFetcher) FetchRequests(ctx context.Context, requestIDs []string, impIDs []string) (map[string]json.RawMessage, map[string]json.RawMessage, []error) {
+	f.mu.Lock()
+	f.calls++
+	f.mu.Unlock()
+	if f.release != nil {
+		<-f.release
+	}
+	return f.reqs, f.imps, f.errs
+}
+
+func (f *countingFetcher) callCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+// syncCache is a Cache which can be used from several goroutines. Each call is signalled on a channel.
+type syncCache struct {
+	mu            sync.Mutex
+	reqs          map[string]json.RawMessage
+	imps          map[string]json.RawMessage
+	gets          chan struct{}
+	saves         chan struct{}
+	invalidations chan struct{}
+}
+
+func newSyncCache() *syncCache {
+	return &syncCache{
+		reqs:          make(map[string]json.RawMessage),
+		imps:          make(map[string]json.RawMessage),
+		gets:          make(chan struct{}, 100),
+		saves:         make(chan struct{}, 100),
+		invalidations: make(chan struct{}, 100),
+	}
+}
+
+func (c *syncCache) Get(ctx context.Context, requestIDs []string, impIDs []string) (map[string]json.RawMessage, map[string]json.RawMessage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer c.signal(c.gets)
+	return copyIDs(c.reqs, requestIDs), copyIDs(c.imps, impIDs)
+}
+
+func (c *syncCache) Save(ctx context.Context, storedRequests map[string]json.RawMessage, storedImps map[string]json.RawMessage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer c.signal(c.saves)
+	for id, data := range storedRequests {
+		c.reqs[id] = data
+	}
+	for id, data := range storedImps {
+		c.imps[id] = data
+	}
+}
+
+func (c *syncCache) Invalidate(ctx context.Context, requestIDs []string, impIDs []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer c.signal(c.invalidations)
+	for _, id := range requestIDs {
+		delete(c.reqs, id)
+	}
+	for _, id := range impIDs {
+		delete(c.imps, id)
+	}
+}
+
+func (c *syncCache) signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+func copyIDs(data map[string]json.RawMessage, ids []string) map[string]json.RawMessage {
+	copied := make(map[string]json.RawMessage, len(ids))
+	for _, id := range ids {
+		if value, ok := data[id]; ok {
+			copied[id] = value
+		}
+	}
+	return copied
+}
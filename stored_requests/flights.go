@@ -0,0 +1,125 @@
+package stored_requests
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+)
+
+// flightGroup tracks the IDs which are being fetched right now, so that concurrent
+// requests for the same ID can share a single call to the Fetcher.
+type flightGroup struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+// flight is the fetch of a single ID. Its results can be read once done is closed.
+type flight struct {
+	done chan struct{}
+	data json.RawMessage
+	// notFound is set if the Fetcher returned a NotFoundError for this ID.
+	notFound error
+	// batch holds any other errors which the Fetcher returned along with this ID.
+	batch *batchErrors
+}
+
+// batchErrors are shared by every flight which was landed from the same call to the Fetcher.
+type batchErrors struct {
+	errs []error
+}
+
+// start begins flights for the IDs which aren't being fetched already. The caller must fetch the
+// started IDs and then land them. Flights for the IDs which were already being fetched are returned
+// in joined. Duplicate IDs are ignored.
+func (g *flightGroup) start(dataType string, ids []string) (started []string, joined map[string]*flight) {
+	if len(ids) == 0 {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.flights == nil {
+		g.flights = make(map[string]*flight)
+	}
+
+	started = make([]string, 0, len(ids))
+	seen := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+
+		key := dataKey(dataType, id)
+		if existing, ok := g.flights[key]; ok {
+			if joined == nil {
+				joined = make(map[string]*flight)
+			}
+			joined[id] = existing
+		} else {
+			g.flights[key] = &flight{done: make(chan struct{})}
+			started = append(started, id)
+		}
+	}
+	return
+}
+
+// land finishes the flights for IDs which were started, using the results from the Fetcher.
+func (g *flightGroup) land(dataType string, ids []string, data map[string]json.RawMessage, errs []error, batch *batchErrors) {
+	notFound := make(map[string]error)
+	for _, err := range errs {
+		if nfErr, ok := err.(NotFoundError); ok && nfErr.DataType == dataType {
+			notFound[nfErr.ID] = nfErr
+		}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, id := range ids {
+		key := dataKey(dataType, id)
+		fl := g.flights[key]
+		delete(g.flights, key)
+
+		fl.data = data[id]
+		fl.notFound = notFound[id]
+		fl.batch = batch
+		close(fl.done)
+	}
+}
+
+// newBatchErrors returns the errors from a call to the Fetcher which aren't about a specific ID.
+func newBatchErrors(errs []error) *batchErrors {
+	batch := &batchErrors{}
+	for _, err := range errs {
+		if _, ok := err.(NotFoundError); !ok {
+			batch.errs = append(batch.errs, err)
+		}
+	}
+	return batch
+}
+
+// await adds the results from joined flights to the data and errs. The errors from each batch are only added once.
+// If the context ends first, its error is returned and the remaining flights are abandoned.
+func await(ctx context.Context, joined map[string]*flight, data map[string]json.RawMessage, errs []error, seen map[*batchErrors]bool) ([]error, error) {
+	for id, fl := range joined {
+		select {
+		case <-fl.done:
+		case <-ctx.Done():
+			return errs, ctx.Err()
+		}
+
+		if fl.data != nil {
+			data[id] = fl.data
+		} else if fl.notFound != nil {
+			errs = append(errs, fl.notFound)
+		} else if !seen[fl.batch] {
+			seen[fl.batch] = true
+			errs = append(errs, fl.batch.errs...)
+		}
+	}
+	return errs, nil
+}
+
+func dataKey(dataType string, id string) string {
+	return dataType + ":" + id
+}
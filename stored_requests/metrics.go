@@ -0,0 +1,63 @@
+package stored_requests
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/prebid/prebid-server/pbsmetrics"
+	"github.com/prebid/prebid-server/tracing"
+)
+
+type fetcherWithMetrics struct {
+	fetcher       Fetcher
+	fetcherType   pbsmetrics.StoredDataFetcherType
+	metricsEngine pbsmetrics.MetricsEngine
+}
+
+// WithMetrics returns a Fetcher which records how long each call to the original takes, and the errors which it returns.
+// Each call is also traced, if tracing is on.
+// It should wrap each backend individually, before they're combined with a MultiFetcher or a Cache.
+// NotFoundErrors are recorded as misses, since the data may still be found in another backend.
+func WithMetrics(fetcher Fetcher, fetcherType pbsmetrics.StoredDataFetcherType, metricsEngine pbsmetrics.MetricsEngine) Fetcher {
+	return &fetcherWithMetrics{
+		fetcher:       fetcher,
+		fetcherType:   fetcherType,
+		metricsEngine: metricsEngine,
+	}
+}
+
+func (f *fetcherWithMetrics) FetchRequests(ctx context.Context, requestIDs []string, impIDs []string) (requestData map[string]json.RawMessage, impData map[string]json.RawMessage, errs []error) {
+	ctx, span := tracing.StartSpan(ctx, "stored_requests.FetchRequests")
+	span.SetAttribute("fetcher", string(f.fetcherType))
+	span.SetAttribute("requests", strconv.Itoa(len(requestIDs)))
+	span.SetAttribute("imps", strconv.Itoa(len(impIDs)))
+	defer span.End()
+
+	start := time.Now()
+	requestData, impData, errs = f.fetcher.FetchRequests(ctx, requestIDs, impIDs)
+	f.metricsEngine.RecordStoredDataFetchTime(f.fetcherType, time.Since(start))
+	if len(errs) > 0 {
+		span.SetError(errs[0])
+	}
+
+	for _, err := range errs {
+		labels := pbsmetrics.StoredDataLabels{
+			DataType:    pbsmetrics.StoredDataTypeRequest,
+			FetcherType: f.fetcherType,
+			Error:       pbsmetrics.StoredDataErrorUnknown,
+		}
+		if notFound, ok := err.(NotFoundError); ok {
+			labels.Error = pbsmetrics.StoredDataErrorMiss
+			if notFound.DataType == impDataType {
+				labels.DataType = pbsmetrics.StoredDataTypeImp
+			}
+		} else if len(requestIDs) == 0 {
+			// Other errors don't say which data they came from. Blame the imps if no requests were asked for.
+			labels.DataType = pbsmetrics.StoredDataTypeImp
+		}
+		f.metricsEngine.RecordStoredDataError(labels)
+	}
+	return
+}
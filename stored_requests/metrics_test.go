@@ -0,0 +1,43 @@
+package stored_requests
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/prebid/prebid-server/pbsmetrics"
+)
+
+func TestFetcherMetrics(t *testing.T) {
+	metricsEngine := newTestMetrics()
+	fetcher := WithMetrics(&mockFetcher{
+		returnErrs: []error{
+			NotFoundError{ID: "req", DataType: "Request"},
+			NotFoundError{ID: "imp-1", DataType: "Imp"},
+			NotFoundError{ID: "imp-2", DataType: "Imp"},
+			errors.New("connection refused"),
+		},
+	}, pbsmetrics.StoredDataFetcherPostgres, metricsEngine)
+
+	if _, _, errs := fetcher.FetchRequests(context.Background(), []string{"req"}, []string{"imp-1", "imp-2"}); len(errs) != 4 {
+		t.Errorf("The errors from the original Fetcher should be returned. Got %v", errs)
+	}
+
+	if count := metricsEngine.StoredDataFetchTimers[pbsmetrics.StoredDataFetcherPostgres].Count(); count != 1 {
+		t.Errorf("Expected the fetch time to be recorded once. Got %d", count)
+	}
+	if count := metricsEngine.StoredDataFetchTimers[pbsmetrics.StoredDataFetcherHTTP].Count(); count != 0 {
+		t.Errorf("Fetch times should only be recorded for the Fetcher's own backend. Got %d", count)
+	}
+	assertErrorCount(t, metricsEngine, pbsmetrics.StoredDataTypeRequest, pbsmetrics.StoredDataErrorMiss, 1)
+	assertErrorCount(t, metricsEngine, pbsmetrics.StoredDataTypeImp, pbsmetrics.StoredDataErrorMiss, 2)
+	assertErrorCount(t, metricsEngine, pbsmetrics.StoredDataTypeRequest, pbsmetrics.StoredDataErrorUnknown, 1)
+	assertErrorCount(t, metricsEngine, pbsmetrics.StoredDataTypeImp, pbsmetrics.StoredDataErrorUnknown, 0)
+}
+
+func assertErrorCount(t *testing.T, metricsEngine *pbsmetrics.Metrics, dataType pbsmetrics.StoredDataType, errType pbsmetrics.StoredDataError, expected int64) {
+	t.Helper()
+	if count := metricsEngine.StoredDataMetrics[dataType].ErrorMeters[pbsmetrics.StoredDataFetcherPostgres][errType].Count(); count != expected {
+		t.Errorf("Expected %d %s errors for stored %s data. Got %d", expected, errType, dataType, count)
+	}
+}
@@ -0,0 +1,49 @@
+package stored_requests
+
+import (
+	"strconv"
+	"strings"
+)
+
+// LatestVersion is the version which Stored Request IDs without an "@version" suffix refer to.
+const LatestVersion = "latest"
+
+// ParseVersionedID splits a Stored Request ID like "{id}@{version}" into its parts.
+//
+// Only a trailing "@latest" or "@{version}", where the version is a positive number, is treated as a version.
+// IDs ending in "@latest" refer to the current data, and return version 0. Any other ID (including ones like
+// "user@site") is returned unchanged, with version 0.
+func ParseVersionedID(versionedID string) (id string, version int) {
+	at := strings.LastIndex(versionedID, "@")
+	if at < 1 {
+		return versionedID, 0
+	}
+	id = versionedID[:at]
+	versionString := versionedID[at+1:]
+	if versionString == LatestVersion {
+		return id, 0
+	}
+	// Leading zeros and signs are rejected too, so that each version has a single ID.
+	version, err := strconv.Atoi(versionString)
+	if err != nil || version <= 0 || strconv.Itoa(version) != versionString {
+		return versionedID, 0
+	}
+	return id, version
+}
+
+// VersionedID builds the ID which Fetchers use for a specific version of some data.
+// Version 0 refers to the latest data, which is stored under the plain ID.
+//
+// Since the data for a specific version never changes, Caches can keep it for as long as they like.
+func VersionedID(id string, version int) string {
+	if version <= 0 {
+		return id
+	}
+	return id + "@" + strconv.Itoa(version)
+}
+
+// NormalizeID converts an ID from a request into the one which should be passed to the Fetcher.
+// For example, "{id}@latest" becomes "{id}", so that it shares a cache entry with "{id}".
+func NormalizeID(versionedID string) string {
+	return VersionedID(ParseVersionedID(versionedID))
+}
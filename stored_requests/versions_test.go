@@ -0,0 +1,42 @@
+package stored_requests
+
+import "testing"
+
+func TestParseVersionedID(t *testing.T) {
+	valid := map[string]struct {
+		id      string
+		version int
+	}{
+		"abc":          {"abc", 0},
+		"abc@latest":   {"abc", 0},
+		"abc@3":        {"abc", 3},
+		"user@site@12": {"user@site", 12},
+		"user@site":    {"user@site", 0},
+		"abc@":         {"abc@", 0},
+		"abc@0":        {"abc@0", 0},
+		"abc@-1":       {"abc@-1", 0},
+		"abc@03":       {"abc@03", 0},
+		"abc@first":    {"abc@first", 0},
+		"@3":           {"@3", 0},
+	}
+	for versionedID, expected := range valid {
+		id, version := ParseVersionedID(versionedID)
+		if id != expected.id || version != expected.version {
+			t.Errorf("Expected %s to parse as (%s, %d). Got (%s, %d)", versionedID, expected.id, expected.version, id, version)
+		}
+	}
+}
+
+func TestNormalizeID(t *testing.T) {
+	expected := map[string]string{
+		"abc":        "abc",
+		"abc@latest": "abc",
+		"abc@2":      "abc@2",
+		"pub@site":   "pub@site",
+	}
+	for versionedID, normalized := range expected {
+		if actual := NormalizeID(versionedID); actual != normalized {
+			t.Errorf("Expected %s to normalize to %s. Got %s", versionedID, normalized, actual)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prebid/prebid-server/config"
+)
+
+// Exporter sends finished spans somewhere they can be viewed.
+type Exporter interface {
+	// Export is called from the request goroutines when each sampled span ends, so it shouldn't block.
+	Export(span *SpanData)
+	// Close sends any spans which haven't been exported yet.
+	Close() error
+}
+
+// NewTracerFromConfig makes the Tracer described by the tracing config.
+// It returns nil if tracing is off.
+func NewTracerFromConfig(cfg *config.Tracing) *Tracer {
+	switch cfg.Exporter {
+	case "log":
+		return NewTracer(&logExporter{}, cfg.SampleRate, cfg.PropagateToBidders)
+	case "zipkin":
+		return NewTracer(NewZipkinExporter(&http.Client{Timeout: cfg.Zipkin.TimeoutDuration()}, &cfg.Zipkin), cfg.SampleRate, cfg.PropagateToBidders)
+	default:
+		return nil
+	}
+}
+
+// logExporter writes each span to the glog INFO log. It's meant for debugging, not production traffic.
+type logExporter struct{}
+
+func (e *logExporter) Export(span *SpanData) {
+	parentID := ""
+	if span.HasParent() {
+		parentID = span.ParentID.String()
+	}
+	glog.Infof("span name=%s trace=%s id=%s parent=%s duration=%v attributes=%v error=%q",
+		span.Name, span.Context.TraceID, span.Context.SpanID, parentID, span.End.Sub(span.Start), span.Attributes, span.Error)
+}
+
+func (e *logExporter) Close() error {
+	return nil
+}
+
+// NewZipkinExporter makes an Exporter which sends spans to a collector with Zipkin's v2 JSON API.
+// The OpenTelemetry Collector, Jaeger and Zipkin itself all accept this format.
+//
+// Spans are buffered in memory, and sent from a background goroutine whenever cfg.BatchSize of them are
+// waiting, or every cfg.FlushInterval. If the buffer is full, new spans are dropped.
+func NewZipkinExporter(client *http.Client, cfg *config.ZipkinTracing) *ZipkinExporter {
+	e := &ZipkinExporter{
+		client:        client,
+		endpoint:      cfg.Endpoint,
+		serviceName:   cfg.ServiceName,
+		spans:         make(chan *SpanData, cfg.BufferSize),
+		batchSize:     cfg.BatchSize,
+		flushInterval: cfg.FlushIntervalDuration(),
+		done:          make(chan struct{}),
+		stopped:       make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+type ZipkinExporter struct {
+	// This is first so that it's 64-bit aligned for the atomic operations.
+	dropped uint64
+
+	client        *http.Client
+	endpoint      string
+	serviceName   string
+	spans         chan *SpanData
+	batchSize     int
+	flushInterval time.Duration
+	done          chan struct{}
+	stopped       chan struct{}
+	closeOnce     sync.Once
+}
+
+func (e *ZipkinExporter) Export(span *SpanData) {
+	select {
+	case <-e.done:
+		atomic.AddUint64(&e.dropped, 1)
+		return
+	default:
+	}
+
+	select {
+	case e.spans <- span:
+	default:
+		atomic.AddUint64(&e.dropped, 1)
+	}
+}
+
+// Dropped returns the number of spans which were dropped because the buffer was full, or the exporter was closed.
+func (e *ZipkinExporter) Dropped() uint64 {
+	return atomic.LoadUint64(&e.dropped)
+}
+
+// Close sends the buffered spans to the collector. Spans exported afterwards will be dropped.
+func (e *ZipkinExporter) Close() error {
+	e.closeOnce.Do(func() {
+		close(e.done)
+		<-e.stopped
+	})
+	return nil
+}
+
+func (e *ZipkinExporter) run() {
+	ticker := time.NewTicker(e.flushInterval)
+	defer ticker.Stop()
+
+	var reportedDrops uint64
+	batch := make([]*SpanData, 0, e.batchSize)
+	for {
+		select {
+		case span := <-e.spans:
+			batch = append(batch, span)
+			if len(batch) >= e.batchSize {
+				batch = e.flush(batch)
+			}
+		case <-ticker.C:
+			batch = e.flush(batch)
+			if dropped := e.Dropped(); dropped > reportedDrops {
+				glog.Warningf("The tracing buffer was full. %d spans have been dropped.", dropped)
+				reportedDrops = dropped
+			}
+		case <-e.done:
+			e.drain(batch)
+			close(e.stopped)
+			return
+		}
+	}
+}
+
+// drain sends the batch, and all the spans left in the buffer.
+func (e *ZipkinExporter) drain(batch []*SpanData) {
+	for {
+		select {
+		case span := <-e.spans:
+			batch = append(batch, span)
+			if len(batch) >= e.batchSize {
+				batch = e.flush(batch)
+			}
+		default:
+			e.flush(batch)
+			return
+		}
+	}
+}
+
+// flush sends the batch to the collector, and returns an empty batch for the next spans.
+func (e *ZipkinExporter) flush(batch []*SpanData) []*SpanData {
+	if len(batch) == 0 {
+		return batch
+	}
+	if err := e.send(batch); err != nil {
+		glog.Warningf("Failed to send %d spans to %s: %v", len(batch), e.endpoint, err)
+	}
+	return make([]*SpanData, 0, e.batchSize)
+}
+
+func (e *ZipkinExporter) send(batch []*SpanData) error {
+	spans := make([]zipkinSpan, len(batch))
+	for i, span := range batch {
+		spans[i] = newZipkinSpan(span, e.serviceName)
+	}
+	body, err := json.Marshal(spans)
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("the collector responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// zipkinSpan is a span in Zipkin's v2 JSON format. See https://zipkin.io/zipkin-api/#/default/post_spans
+type zipkinSpan struct {
+	TraceID       string            `json:"traceId"`
+	ID            string            `json:"id"`
+	ParentID      string            `json:"parentId,omitempty"`
+	Name          string            `json:"name"`
+	Timestamp     int64             `json:"timestamp"`
+	Duration      int64             `json:"duration"`
+	LocalEndpoint zipkinEndpoint    `json:"localEndpoint"`
+	Tags          map[string]string `json:"tags,omitempty"`
+}
+
+type zipkinEndpoint struct {
+	ServiceName string `json:"serviceName"`
+}
+
+func newZipkinSpan(span *SpanData, serviceName string) zipkinSpan {
+	zs := zipkinSpan{
+		TraceID:       span.Context.TraceID.String(),
+		ID:            span.Context.SpanID.String(),
+		Name:          span.Name,
+		Timestamp:     span.Start.UnixNano() / int64(time.Microsecond),
+		Duration:      int64(span.End.Sub(span.Start) / time.Microsecond),
+		LocalEndpoint: zipkinEndpoint{ServiceName: serviceName},
+		Tags:          span.Attributes,
+	}
+	if span.HasParent() {
+		zs.ParentID = span.ParentID.String()
+	}
+	if span.Error != "" {
+		if zs.Tags == nil {
+			zs.Tags = make(map[string]string, 1)
+		}
+		// Zipkin marks any span with an "error" tag as failed.
+		zs.Tags["error"] = span.Error
+	}
+	return zs
+}
@@ -0,0 +1,96 @@
+package tracing
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prebid/prebid-server/config"
+)
+
+func TestZipkinExporter(t *testing.T) {
+	collector := &zipkinCollector{}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	exporter := NewZipkinExporter(server.Client(), &config.ZipkinTracing{
+		Endpoint:      server.URL,
+		ServiceName:   "pbs",
+		BufferSize:    10,
+		BatchSize:     2,
+		FlushInterval: 60000,
+		Timeout:       1000,
+	})
+	start := time.Unix(1500000000, 0)
+	for i := 0; i < 3; i++ {
+		exporter.Export(&SpanData{
+			Name:       "bidder.doRequest",
+			Context:    SpanContext{TraceID: TraceID{1}, SpanID: SpanID{byte(i + 2)}, Sampled: true},
+			ParentID:   SpanID{1},
+			Start:      start,
+			End:        start.Add(15 * time.Millisecond),
+			Attributes: map[string]string{"http.status_code": "204"},
+			Error:      "failed",
+		})
+	}
+	exporter.Close()
+	exporter.Export(&SpanData{Name: "too late"})
+
+	batches := collector.get()
+	if len(batches) != 2 || len(batches[0]) != 2 || len(batches[1]) != 1 {
+		t.Fatalf("The spans should be sent in batches of 2, with the rest sent on Close(). Got %v", batches)
+	}
+	span := batches[0][0]
+	if span.TraceID != "01000000000000000000000000000000" || span.ID != "0200000000000000" || span.ParentID != "0100000000000000" {
+		t.Errorf("Bad IDs: %s %s %s", span.TraceID, span.ID, span.ParentID)
+	}
+	if span.Timestamp != 1500000000000000 || span.Duration != 15000 {
+		t.Errorf("The timestamp and duration should be in microseconds. Got %d %d", span.Timestamp, span.Duration)
+	}
+	if span.LocalEndpoint.ServiceName != "pbs" {
+		t.Errorf("Bad service name: %s", span.LocalEndpoint.ServiceName)
+	}
+	if span.Tags["http.status_code"] != "204" || span.Tags["error"] != "failed" {
+		t.Errorf("Bad tags: %v", span.Tags)
+	}
+	if exporter.Dropped() != 1 {
+		t.Errorf("Spans exported after Close() should be dropped. Got %d", exporter.Dropped())
+	}
+}
+
+func TestNewTracerFromConfig(t *testing.T) {
+	if NewTracerFromConfig(&config.Tracing{Exporter: "none"}) != nil {
+		t.Error("Tracing should be off with the none exporter")
+	}
+	if tracer := NewTracerFromConfig(&config.Tracing{Exporter: "log", SampleRate: 0.5, PropagateToBidders: true}); tracer == nil || tracer.sampleRate != 0.5 || !tracer.propagateToBidders {
+		t.Errorf("Bad tracer for the log exporter: %v", tracer)
+	}
+}
+
+type zipkinCollector struct {
+	mutex   sync.Mutex
+	batches [][]zipkinSpan
+}
+
+func (c *zipkinCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	var batch []zipkinSpan
+	if err := json.Unmarshal(body, &batch); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.batches = append(c.batches, batch)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (c *zipkinCollector) get() [][]zipkinSpan {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.batches
+}
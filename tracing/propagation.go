@@ -0,0 +1,88 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// TraceParentHeader is the W3C Trace Context header which passes a SpanContext between services.
+// See https://www.w3.org/TR/trace-context/#traceparent-header
+const TraceParentHeader = "traceparent"
+
+const (
+	traceParentVersion = "00"
+	flagSampled        = "01"
+	flagNotSampled     = "00"
+)
+
+// FormatTraceParent returns the traceparent header value for sc.
+func FormatTraceParent(sc SpanContext) string {
+	flags := flagNotSampled
+	if sc.Sampled {
+		flags = flagSampled
+	}
+	return traceParentVersion + "-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// ParseTraceParent reads a traceparent header value. It returns false if the value isn't valid.
+func ParseTraceParent(value string) (sc SpanContext, ok bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	// Later versions may add more fields, but they have to start with these ones.
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == traceParentVersion && len(parts) != 4) {
+		return
+	}
+	if !decodeID(sc.TraceID[:], parts[1]) || !decodeID(sc.SpanID[:], parts[2]) {
+		return
+	}
+	if sc.TraceID == (TraceID{}) || sc.SpanID == (SpanID{}) {
+		return
+	}
+	flags := make([]byte, 1)
+	if !decodeID(flags, parts[3]) {
+		return
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, true
+}
+
+func decodeID(dst []byte, value string) bool {
+	if len(value) != hex.EncodedLen(len(dst)) || strings.ToLower(value) != value {
+		return false
+	}
+	_, err := hex.Decode(dst, []byte(value))
+	return err == nil
+}
+
+// Extract returns a context which continues the trace from the traceparent header of an incoming request, if it has one.
+func Extract(ctx context.Context, header http.Header) context.Context {
+	if getTracer() == nil {
+		return ctx
+	}
+	if parent, ok := ParseTraceParent(header.Get(TraceParentHeader)); ok {
+		return ContextWithRemoteParent(ctx, parent)
+	}
+	return ctx
+}
+
+// InjectBidderRequest adds a traceparent header for the span in ctx to a request which is going to a bidder.
+// It does nothing unless tracing.propagate_to_bidders is on.
+//
+// The headers are copied first, since the adapters may share them between requests.
+func InjectBidderRequest(ctx context.Context, req *http.Request) {
+	tracer := getTracer()
+	if tracer == nil || !tracer.propagateToBidders {
+		return
+	}
+	span := SpanFromContext(ctx)
+	if span == nil {
+		return
+	}
+	header := make(http.Header, len(req.Header)+1)
+	for key, values := range req.Header {
+		header[key] = values
+	}
+	header.Set(TraceParentHeader, FormatTraceParent(span.Context()))
+	req.Header = header
+}
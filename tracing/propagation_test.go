@@ -0,0 +1,101 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestTraceParentRoundTrip(t *testing.T) {
+	sc := SpanContext{
+		TraceID: TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+		SpanID:  SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+		Sampled: true,
+	}
+	value := FormatTraceParent(sc)
+	if value != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" {
+		t.Errorf("Bad traceparent: %s", value)
+	}
+	parsed, ok := ParseTraceParent(value)
+	if !ok || parsed != sc {
+		t.Errorf("The traceparent should parse back to the same SpanContext. Got %v", parsed)
+	}
+}
+
+func TestParseTraceParent(t *testing.T) {
+	testCases := []struct {
+		value   string
+		valid   bool
+		sampled bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, false},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-03-future", true, true},
+		{"", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false, false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false, false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-zz", false, false},
+	}
+	for _, test := range testCases {
+		sc, ok := ParseTraceParent(test.value)
+		if ok != test.valid {
+			t.Errorf("%q: expected valid=%t. Got %t", test.value, test.valid, ok)
+		}
+		if ok && sc.Sampled != test.sampled {
+			t.Errorf("%q: expected sampled=%t. Got %t", test.value, test.sampled, sc.Sampled)
+		}
+	}
+}
+
+func TestExtract(t *testing.T) {
+	SetTracer(NewTracer(&recordingExporter{}, 1, false))
+	defer SetTracer(nil)
+
+	header := http.Header{}
+	header.Set(TraceParentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	_, span := StartSpan(Extract(context.Background(), header), "auction")
+	if span.Context().TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("The span should continue the incoming trace. Got %s", span.Context().TraceID)
+	}
+	if span.parentID.String() != "00f067aa0ba902b7" {
+		t.Errorf("The span's parent should be the caller's span. Got %s", span.parentID)
+	}
+}
+
+func TestInjectBidderRequest(t *testing.T) {
+	SetTracer(NewTracer(&recordingExporter{}, 1, true))
+	defer SetTracer(nil)
+
+	ctx, span := StartSpan(context.Background(), "bidder")
+	shared := http.Header{"Content-Type": []string{"application/json"}}
+	req, _ := http.NewRequest("POST", "http://bidder.com/bid", nil)
+	req.Header = shared
+	InjectBidderRequest(ctx, req)
+
+	if req.Header.Get(TraceParentHeader) != FormatTraceParent(span.Context()) {
+		t.Errorf("Bad traceparent header: %s", req.Header.Get(TraceParentHeader))
+	}
+	if req.Header.Get("Content-Type") != "application/json" {
+		t.Error("The bidder's headers should be kept")
+	}
+	if shared.Get(TraceParentHeader) != "" {
+		t.Error("The bidder's headers shouldn't be modified, since they may be shared")
+	}
+}
+
+func TestInjectBidderRequestDisabled(t *testing.T) {
+	SetTracer(NewTracer(&recordingExporter{}, 1, false))
+	defer SetTracer(nil)
+
+	ctx, _ := StartSpan(context.Background(), "bidder")
+	req, _ := http.NewRequest("POST", "http://bidder.com/bid", nil)
+	InjectBidderRequest(ctx, req)
+
+	if req.Header.Get(TraceParentHeader) != "" {
+		t.Error("The traceparent header shouldn't be sent unless tracing.propagate_to_bidders is on")
+	}
+}
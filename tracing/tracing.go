@@ -0,0 +1,257 @@
+// Package tracing records spans for the work done on each auction, so that a slow auction can be traced
+// back to the stored request fetch, bidder call or cache call which caused it.
+//
+// The spans follow the OpenTelemetry model: every span belongs to a trace, and knows the span which
+// started it. Spans are carried from function to function in a context.Context.
+//
+// Tracing is a no-op until SetTracer() is called. Code which records spans doesn't need to check,
+// since StartSpan() returns a nil *Span when tracing is off, and all the *Span methods accept nil.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	mathrand "math/rand"
+	"sync"
+	"time"
+)
+
+// TraceID identifies all the spans which make up a trace.
+type TraceID [16]byte
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// SpanID identifies a single span within a trace.
+type SpanID [8]byte
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// SpanContext is the part of a span which gets passed on to its children, including those in other services.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	// Sampled is true if the spans in this trace are being recorded.
+	Sampled bool
+}
+
+// Span is one timed operation. Spans must be ended by calling End().
+type Span struct {
+	tracer   *Tracer
+	name     string
+	context  SpanContext
+	parentID SpanID
+	start    time.Time
+
+	mutex      sync.Mutex
+	end        time.Time
+	attributes map[string]string
+	err        error
+}
+
+// SetAttribute attaches some information to the span.
+func (s *Span) SetAttribute(key string, value string) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.attributes == nil {
+		s.attributes = make(map[string]string)
+	}
+	s.attributes[key] = value
+}
+
+// SetError marks the span as failed. Nil errors are ignored.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.err = err
+}
+
+// End finishes the span, and sends it to the exporter if it was sampled.
+// Calls after the first one are ignored.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	if !s.end.IsZero() {
+		s.mutex.Unlock()
+		return
+	}
+	s.end = time.Now()
+	s.mutex.Unlock()
+
+	if s.context.Sampled {
+		s.tracer.exporter.Export(s.data())
+	}
+}
+
+// Context returns the span's SpanContext.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.context
+}
+
+func (s *Span) data() *SpanData {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	data := &SpanData{
+		Name:       s.name,
+		Context:    s.context,
+		ParentID:   s.parentID,
+		Start:      s.start,
+		End:        s.end,
+		Attributes: make(map[string]string, len(s.attributes)),
+	}
+	for key, value := range s.attributes {
+		data.Attributes[key] = value
+	}
+	if s.err != nil {
+		data.Error = s.err.Error()
+	}
+	return data
+}
+
+// SpanData is a finished span, as it's given to the Exporter.
+type SpanData struct {
+	Name    string
+	Context SpanContext
+	// ParentID is all zeros if the span started its trace.
+	ParentID   SpanID
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]string
+	// Error is the message from SetError(), if it was called.
+	Error string
+}
+
+// HasParent returns true if the span was started by another one.
+func (d *SpanData) HasParent() bool {
+	return d.ParentID != SpanID{}
+}
+
+// Tracer starts spans and hands them to an Exporter once they end.
+type Tracer struct {
+	exporter           Exporter
+	sampleRate         float64
+	propagateToBidders bool
+}
+
+// NewTracer makes a Tracer which records sampleRate of the traces it starts.
+// If propagateToBidders is true, the requests sent to bidders will get a traceparent header. See InjectBidderRequest().
+func NewTracer(exporter Exporter, sampleRate float64, propagateToBidders bool) *Tracer {
+	return &Tracer{
+		exporter:           exporter,
+		sampleRate:         sampleRate,
+		propagateToBidders: propagateToBidders,
+	}
+}
+
+// Close sends any spans which the exporter is holding on to.
+func (t *Tracer) Close() error {
+	return t.exporter.Close()
+}
+
+func (t *Tracer) startSpan(ctx context.Context, name string) (context.Context, *Span) {
+	span := &Span{
+		tracer: t,
+		name:   name,
+		start:  time.Now(),
+	}
+	if parent, ok := parentContext(ctx); ok {
+		span.context.TraceID = parent.TraceID
+		span.context.Sampled = parent.Sampled
+		span.parentID = parent.SpanID
+	} else {
+		span.context.TraceID = newTraceID()
+		span.context.Sampled = t.sampleRate >= 1 || mathrand.Float64() < t.sampleRate
+	}
+	span.context.SpanID = newSpanID()
+	return context.WithValue(ctx, spanKey, span), span
+}
+
+var (
+	currentTracer *Tracer
+	tracerMutex   sync.RWMutex
+)
+
+// SetTracer sets the Tracer which StartSpan() uses. Passing nil turns tracing off.
+// This should be called at startup, before any requests are handled.
+func SetTracer(tracer *Tracer) {
+	tracerMutex.Lock()
+	defer tracerMutex.Unlock()
+	currentTracer = tracer
+}
+
+func getTracer() *Tracer {
+	tracerMutex.RLock()
+	defer tracerMutex.RUnlock()
+	return currentTracer
+}
+
+// StartSpan starts a span as a child of the one in ctx, or the remote parent from ContextWithRemoteParent().
+// If ctx has neither, the span starts a new trace.
+//
+// The returned context holds the new span, and should be passed on to the operation being timed.
+// If tracing is off, ctx is returned unchanged along with a nil span.
+func StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	tracer := getTracer()
+	if tracer == nil {
+		return ctx, nil
+	}
+	return tracer.startSpan(ctx, name)
+}
+
+// SpanFromContext returns the span in ctx, or nil if it doesn't have one.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey).(*Span)
+	return span
+}
+
+// ContextWithRemoteParent returns a context whose spans will continue the trace started by another service.
+func ContextWithRemoteParent(ctx context.Context, parent SpanContext) context.Context {
+	return context.WithValue(ctx, remoteParentKey, parent)
+}
+
+func parentContext(ctx context.Context) (SpanContext, bool) {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.context, true
+	}
+	parent, ok := ctx.Value(remoteParentKey).(SpanContext)
+	return parent, ok
+}
+
+type contextKey int
+
+const (
+	spanKey contextKey = iota
+	remoteParentKey
+)
+
+func newTraceID() (id TraceID) {
+	randomBytes(id[:])
+	return
+}
+
+func newSpanID() (id SpanID) {
+	randomBytes(id[:])
+	return
+}
+
+func randomBytes(b []byte) {
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand shouldn't fail, but IDs don't need to be secure. They just need to be unique.
+		mathrand.Read(b)
+	}
+}
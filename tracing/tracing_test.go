@@ -0,0 +1,118 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestNoTracer(t *testing.T) {
+	SetTracer(nil)
+	ctx := context.Background()
+	spanCtx, span := StartSpan(ctx, "test")
+	if span != nil {
+		t.Error("StartSpan should return a nil span when tracing is off")
+	}
+	if spanCtx != ctx {
+		t.Error("StartSpan should return the same context when tracing is off")
+	}
+	// None of these should panic.
+	span.SetAttribute("key", "value")
+	span.SetError(errors.New("failed"))
+	span.End()
+}
+
+func TestSpanHierarchy(t *testing.T) {
+	exporter := &recordingExporter{}
+	SetTracer(NewTracer(exporter, 1, false))
+	defer SetTracer(nil)
+
+	ctx, root := StartSpan(context.Background(), "root")
+	_, child := StartSpan(ctx, "child")
+	child.SetAttribute("bidder", "appnexus")
+	child.SetError(errors.New("timed out"))
+	child.End()
+	child.End()
+	root.End()
+
+	spans := exporter.spans
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans. Got %d", len(spans))
+	}
+	if spans[0].Name != "child" || spans[1].Name != "root" {
+		t.Errorf("Spans should be exported in the order they end. Got %s, %s", spans[0].Name, spans[1].Name)
+	}
+	if spans[1].HasParent() {
+		t.Error("The root span shouldn't have a parent")
+	}
+	if spans[0].ParentID != spans[1].Context.SpanID {
+		t.Error("The child span should have the root as its parent")
+	}
+	if spans[0].Context.TraceID != spans[1].Context.TraceID {
+		t.Error("The child span should be in the root's trace")
+	}
+	if spans[0].Attributes["bidder"] != "appnexus" {
+		t.Errorf("Bad bidder attribute: %s", spans[0].Attributes["bidder"])
+	}
+	if spans[0].Error != "timed out" {
+		t.Errorf("Bad error: %s", spans[0].Error)
+	}
+	if spans[0].End.Before(spans[0].Start) {
+		t.Error("The span should end after it starts")
+	}
+}
+
+func TestRemoteParent(t *testing.T) {
+	exporter := &recordingExporter{}
+	SetTracer(NewTracer(exporter, 0, false))
+	defer SetTracer(nil)
+
+	parent := SpanContext{
+		TraceID: TraceID{1, 2, 3},
+		SpanID:  SpanID{4, 5, 6},
+		Sampled: true,
+	}
+	_, span := StartSpan(ContextWithRemoteParent(context.Background(), parent), "remote child")
+	span.End()
+
+	if len(exporter.spans) != 1 {
+		t.Fatalf("The caller's sampling decision should override the sample rate. Got %d spans", len(exporter.spans))
+	}
+	if exporter.spans[0].Context.TraceID != parent.TraceID || exporter.spans[0].ParentID != parent.SpanID {
+		t.Error("The span should continue the remote trace")
+	}
+}
+
+func TestSampling(t *testing.T) {
+	exporter := &recordingExporter{}
+	SetTracer(NewTracer(exporter, 0, false))
+	defer SetTracer(nil)
+
+	ctx, root := StartSpan(context.Background(), "root")
+	_, child := StartSpan(ctx, "child")
+	child.End()
+	root.End()
+
+	if len(exporter.spans) != 0 {
+		t.Errorf("Unsampled spans shouldn't be exported. Got %d", len(exporter.spans))
+	}
+	if root.Context().Sampled || child.Context().Sampled {
+		t.Error("The spans shouldn't be sampled")
+	}
+}
+
+type recordingExporter struct {
+	mutex sync.Mutex
+	spans []*SpanData
+}
+
+func (e *recordingExporter) Export(span *SpanData) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.spans = append(e.spans, span)
+}
+
+func (e *recordingExporter) Close() error {
+	return nil
+}
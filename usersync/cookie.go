@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/golang/glog"
 	"github.com/prebid/prebid-server/config"
 	"github.com/prebid/prebid-server/openrtb_ext"
 )
@@ -33,6 +34,8 @@ type PBSCookie struct {
 	uids     map[string]uidWithExpiry
 	optOut   bool
 	birthday *time.Time
+	// codec is nil unless the host has configured keys for signed cookies.
+	codec *cookieCodec
 }
 
 // uidWithExpiry bundles the UID with an Expiration date.
@@ -45,22 +48,39 @@ type uidWithExpiry struct {
 }
 
 // ParsePBSCookieFromRequest parses the UserSyncMap from an HTTP Request.
+//
+// If the host has configured keys for signed cookies, the returned cookie will be written back in that format.
+// Use UIDStoreClient.ParsePBSCookieFromRequest to fall back to the syncs saved on the server.
 func ParsePBSCookieFromRequest(r *http.Request, cookie *config.HostCookie) *PBSCookie {
+	return parsePBSCookieFromRequest(r, cookie, nil)
+}
+
+func parsePBSCookieFromRequest(r *http.Request, cookie *config.HostCookie, uidStore *UIDStoreClient) *PBSCookie {
+	codec := newCookieCodec(&cookie.UIDCookie)
 	if cookie.OptOutCookie.Name != "" {
 		optOutCookie, err1 := r.Cookie(cookie.OptOutCookie.Name)
 		if err1 == nil && optOutCookie.Value == cookie.OptOutCookie.Value {
 			pc := NewPBSCookie()
 			pc.SetPreference(false)
+			pc.codec = codec
 			return pc
 		}
 	}
 	var parsed *PBSCookie
 	uidCookie, err2 := r.Cookie(UID_COOKIE_NAME)
 	if err2 == nil {
-		parsed = ParsePBSCookie(uidCookie)
+		parsed = parsePBSCookie(uidCookie, codec)
 	} else {
 		parsed = NewPBSCookie()
 	}
+	parsed.codec = codec
+	// Safari and other browsers may have dropped or truncated the uids cookie. If so, fall back to the server-side copy.
+	if parsed.AllowSyncs() && parsed.LiveSyncCount() == 0 {
+		if stored := uidStore.load(r, cookie); stored != nil {
+			stored.codec = codec
+			parsed = stored
+		}
+	}
 	// Fixes #582
 	if uid, _, _ := parsed.GetUID(cookie.Family); uid == "" && cookie.CookieName != "" {
 		if hostCookie, err := r.Cookie(cookie.CookieName); err == nil {
@@ -71,12 +91,31 @@ func ParsePBSCookieFromRequest(r *http.Request, cookie *config.HostCookie) *PBSC
 }
 
 // ParsePBSCookie parses the UserSync cookie from a raw HTTP cookie.
+//
+// This only understands the legacy format. Signed cookies can't be verified without the host's keys,
+// so they're treated as corrupted. Use ParsePBSCookieFromRequest to read them.
 func ParsePBSCookie(uidCookie *http.Cookie) *PBSCookie {
+	return parsePBSCookie(uidCookie, nil)
+}
+
+func parsePBSCookie(uidCookie *http.Cookie, codec *cookieCodec) *PBSCookie {
 	pc := NewPBSCookie()
 
-	j, err := base64.URLEncoding.DecodeString(uidCookie.Value)
+	var j []byte
+	var err error
+	if isSigned(uidCookie.Value) {
+		if codec == nil {
+			return pc
+		}
+		j, err = codec.decode(uidCookie.Value)
+	} else {
+		if !codec.acceptsLegacy() {
+			return pc
+		}
+		j, err = base64.URLEncoding.DecodeString(uidCookie.Value)
+	}
 	if err != nil {
-		// corrupted cookie; we should reset
+		// corrupted or forged cookie; we should reset
 		return pc
 	}
 	err = json.Unmarshal(j, pc)
@@ -112,11 +151,22 @@ func (cookie *PBSCookie) SetPreference(allow bool) {
 // Gets an HTTP cookie containing all the data from this UserSyncMap. This is a snapshot--not a live view.
 func (cookie *PBSCookie) ToHTTPCookie(ttl time.Duration) *http.Cookie {
 	j, _ := json.Marshal(cookie)
-	b64 := base64.URLEncoding.EncodeToString(j)
+
+	var value string
+	if cookie.codec.writesSigned() {
+		var err error
+		if value, err = cookie.codec.encode(j); err != nil {
+			// Falling back to the legacy format would undo the point of signing, so reset the cookie instead.
+			glog.Errorf("Failed to write signed uids cookie: %v", err)
+			value = ""
+		}
+	} else {
+		value = base64.URLEncoding.EncodeToString(j)
+	}
 
 	return &http.Cookie{
 		Name:    UID_COOKIE_NAME,
-		Value:   b64,
+		Value:   value,
 		Expires: time.Now().Add(ttl),
 	}
 }
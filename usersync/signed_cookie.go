@@ -0,0 +1,161 @@
+package usersync
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/prebid/prebid-server/config"
+)
+
+// Signed cookies have the format:
+//
+//   <version>.<key ID>.<payload>.<signature>
+//
+// The version is "s1" if the payload is the cookie's JSON, or "e1" if the payload is an AES-GCM nonce
+// followed by the encrypted JSON. The payload is base64url encoded without padding. The signature is the
+// base64url-encoded HMAC-SHA256 of everything which precedes it, so the version and key ID are covered too.
+//
+// Legacy cookies are padded base64url JSON. That alphabet doesn't include ".", so the two formats can't be confused.
+const (
+	signedCookieVersion    = "s1"
+	encryptedCookieVersion = "e1"
+)
+
+// These labels are used to derive independent signing and encryption keys from each config.CookieKey.Secret.
+const (
+	macKeyLabel = "prebid-server uids cookie signing key"
+	encKeyLabel = "prebid-server uids cookie encryption key"
+)
+
+var signedEncoding = base64.RawURLEncoding
+
+// cookieCodec reads and writes the signed cookie format, according to the host's config.
+//
+// Keys are derived lazily, since each request only needs the key it was written with and the key used to write it back.
+type cookieCodec struct {
+	cfg *config.UIDCookie
+}
+
+// newCookieCodec returns nil if the host hasn't configured any keys. In that case only legacy cookies can be used.
+func newCookieCodec(cfg *config.UIDCookie) *cookieCodec {
+	if cfg == nil || len(cfg.Keys) == 0 {
+		return nil
+	}
+	return &cookieCodec{cfg: cfg}
+}
+
+// isSigned returns true if the value looks like it's in the signed format, rather than the legacy one.
+func isSigned(value string) bool {
+	return strings.Contains(value, ".")
+}
+
+// acceptsLegacy is true if legacy cookies should be trusted.
+func (c *cookieCodec) acceptsLegacy() bool {
+	return c == nil || !c.cfg.RejectLegacy
+}
+
+// writesSigned is true if new cookies should be written in the signed format.
+func (c *cookieCodec) writesSigned() bool {
+	return c != nil && (c.cfg.Sign || c.cfg.Encrypt)
+}
+
+// encode signs (and maybe encrypts) the cookie JSON using the first configured key.
+func (c *cookieCodec) encode(data []byte) (string, error) {
+	key := c.cfg.Keys[0]
+	version := signedCookieVersion
+	if c.cfg.Encrypt {
+		aead, err := newAEAD(key.Secret)
+		if err != nil {
+			return "", err
+		}
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return "", fmt.Errorf("failed to generate a uids cookie nonce: %v", err)
+		}
+		data = aead.Seal(nonce, nonce, data, []byte(key.ID))
+		version = encryptedCookieVersion
+	}
+
+	signed := version + "." + key.ID + "." + signedEncoding.EncodeToString(data)
+	return signed + "." + signedEncoding.EncodeToString(sign(key.Secret, signed)), nil
+}
+
+// decode verifies (and maybe decrypts) a signed cookie, returning the cookie JSON.
+func (c *cookieCodec) decode(value string) ([]byte, error) {
+	parts := strings.Split(value, ".")
+	if len(parts) != 4 {
+		return nil, errors.New("signed uids cookie must have 4 parts")
+	}
+	version, keyID, payload, signature := parts[0], parts[1], parts[2], parts[3]
+	if version != signedCookieVersion && version != encryptedCookieVersion {
+		return nil, fmt.Errorf("unknown uids cookie version: %s", version)
+	}
+
+	key, ok := c.findKey(keyID)
+	if !ok {
+		return nil, fmt.Errorf("uids cookie was signed with an unknown key: %s", keyID)
+	}
+
+	gotMAC, err := signedEncoding.DecodeString(signature)
+	if err != nil {
+		return nil, fmt.Errorf("uids cookie signature is not base64url: %v", err)
+	}
+	if !hmac.Equal(gotMAC, sign(key.Secret, value[:len(value)-len(signature)-1])) {
+		return nil, errors.New("uids cookie signature is invalid")
+	}
+
+	data, err := signedEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, fmt.Errorf("uids cookie payload is not base64url: %v", err)
+	}
+	if version == signedCookieVersion {
+		return data, nil
+	}
+
+	aead, err := newAEAD(key.Secret)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("encrypted uids cookie is too short")
+	}
+	return aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(keyID))
+}
+
+func (c *cookieCodec) findKey(id string) (config.CookieKey, bool) {
+	for _, key := range c.cfg.Keys {
+		if key.ID == id {
+			return key, true
+		}
+	}
+	return config.CookieKey{}, false
+}
+
+func sign(secret string, data string) []byte {
+	mac := hmac.New(sha256.New, deriveKey(secret, macKeyLabel))
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func newAEAD(secret string) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(deriveKey(secret, encKeyLabel))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// deriveKey returns a 32 byte key for the given purpose. This lets hosts configure a single secret per key,
+// without reusing the same bytes for both HMAC and AES.
+func deriveKey(secret string, label string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(label))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,147 @@
+package usersync
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prebid/prebid-server/config"
+)
+
+const (
+	testSecret      = "0123456789abcdef0123456789abcdef"
+	otherTestSecret = "fedcba9876543210fedcba9876543210"
+)
+
+func TestSignedCookieReadWrite(t *testing.T) {
+	hostCookie := signingConfig(false, false, config.CookieKey{ID: "a", Secret: testSecret})
+	written := writeSigned(t, hostCookie)
+	if !strings.HasPrefix(written.Value, signedCookieVersion+".a.") {
+		t.Fatalf("Signed cookies should start with the version and key ID. Got %s", written.Value)
+	}
+	assertHasAdnxs(t, readSigned(written, hostCookie))
+}
+
+func TestEncryptedCookieReadWrite(t *testing.T) {
+	hostCookie := signingConfig(true, false, config.CookieKey{ID: "a", Secret: testSecret})
+	written := writeSigned(t, hostCookie)
+	if !strings.HasPrefix(written.Value, encryptedCookieVersion+".a.") {
+		t.Fatalf("Encrypted cookies should start with the version and key ID. Got %s", written.Value)
+	}
+	if strings.Contains(written.Value, base64.RawURLEncoding.EncodeToString([]byte("adnxs"))) {
+		t.Errorf("Encrypted cookies should not expose the bidder families.")
+	}
+	assertHasAdnxs(t, readSigned(written, hostCookie))
+}
+
+func TestKeyRotation(t *testing.T) {
+	oldConfig := signingConfig(false, false, config.CookieKey{ID: "old", Secret: testSecret})
+	written := writeSigned(t, oldConfig)
+
+	rotatedConfig := signingConfig(false, false, config.CookieKey{ID: "new", Secret: otherTestSecret}, config.CookieKey{ID: "old", Secret: testSecret})
+	parsed := readSigned(written, rotatedConfig)
+	assertHasAdnxs(t, parsed)
+
+	rewritten := parsed.ToHTTPCookie(time.Hour)
+	if !strings.HasPrefix(rewritten.Value, signedCookieVersion+".new.") {
+		t.Errorf("Cookies should be rewritten with the first key. Got %s", rewritten.Value)
+	}
+
+	droppedConfig := signingConfig(false, false, config.CookieKey{ID: "new", Secret: otherTestSecret})
+	ensureEmptyMap(t, readSigned(written, droppedConfig))
+}
+
+func TestForgedSignedCookie(t *testing.T) {
+	hostCookie := signingConfig(false, false, config.CookieKey{ID: "a", Secret: testSecret})
+	written := writeSigned(t, hostCookie)
+
+	forged := NewPBSCookie()
+	forged.TrySync("adnxs", "forged-id")
+	forgedJSON, _ := forged.MarshalJSON()
+	parts := strings.Split(written.Value, ".")
+	parts[2] = base64.RawURLEncoding.EncodeToString(forgedJSON)
+	written.Value = strings.Join(parts, ".")
+
+	ensureEmptyMap(t, readSigned(written, hostCookie))
+}
+
+func TestTamperedEncryptedCookie(t *testing.T) {
+	hostCookie := signingConfig(true, false, config.CookieKey{ID: "a", Secret: testSecret})
+	written := writeSigned(t, hostCookie)
+
+	wrongKey := signingConfig(true, false, config.CookieKey{ID: "a", Secret: otherTestSecret})
+	ensureEmptyMap(t, readSigned(written, wrongKey))
+}
+
+func TestLegacyCookieMigration(t *testing.T) {
+	legacy := NewPBSCookie()
+	legacy.TrySync("adnxs", "123")
+	legacyCookie := legacy.ToHTTPCookie(time.Hour)
+
+	migrating := signingConfig(false, false, config.CookieKey{ID: "a", Secret: testSecret})
+	parsed := readSigned(legacyCookie, migrating)
+	assertHasAdnxs(t, parsed)
+	if rewritten := parsed.ToHTTPCookie(time.Hour); !strings.HasPrefix(rewritten.Value, signedCookieVersion+".") {
+		t.Errorf("Legacy cookies should be rewritten in the signed format. Got %s", rewritten.Value)
+	}
+
+	migrated := signingConfig(false, true, config.CookieKey{ID: "a", Secret: testSecret})
+	ensureEmptyMap(t, readSigned(legacyCookie, migrated))
+}
+
+func TestSignedCookieWithoutKeys(t *testing.T) {
+	hostCookie := signingConfig(false, false, config.CookieKey{ID: "a", Secret: testSecret})
+	written := writeSigned(t, hostCookie)
+
+	ensureEmptyMap(t, ParsePBSCookie(written))
+	ensureEmptyMap(t, readSigned(written, &config.HostCookie{}))
+}
+
+func TestMalformedSignedCookies(t *testing.T) {
+	hostCookie := signingConfig(true, false, config.CookieKey{ID: "a", Secret: testSecret})
+	values := []string{
+		"a.b",
+		"s1.a.b.c.d",
+		"s2.a.e30.AAAA",
+		"s1.a.!!!.AAAA",
+		"e1.a.AAAA.!!!",
+	}
+	for _, value := range values {
+		ensureEmptyMap(t, readSigned(&http.Cookie{Name: UID_COOKIE_NAME, Value: value}, hostCookie))
+	}
+}
+
+func signingConfig(encrypt bool, rejectLegacy bool, keys ...config.CookieKey) *config.HostCookie {
+	return &config.HostCookie{
+		UIDCookie: config.UIDCookie{
+			Sign:         true,
+			Encrypt:      encrypt,
+			RejectLegacy: rejectLegacy,
+			Keys:         keys,
+		},
+	}
+}
+
+func writeSigned(t *testing.T, hostCookie *config.HostCookie) *http.Cookie {
+	t.Helper()
+	cookie := ParsePBSCookieFromRequest(&http.Request{Header: http.Header{}}, hostCookie)
+	if err := cookie.TrySync("adnxs", "123"); err != nil {
+		t.Fatalf("Failed to sync: %v", err)
+	}
+	return cookie.ToHTTPCookie(time.Hour)
+}
+
+func readSigned(cookie *http.Cookie, hostCookie *config.HostCookie) *PBSCookie {
+	request := &http.Request{Header: http.Header{}}
+	request.AddCookie(cookie)
+	return ParsePBSCookieFromRequest(request, hostCookie)
+}
+
+func assertHasAdnxs(t *testing.T, cookie *PBSCookie) {
+	t.Helper()
+	if uid, _, isLive := cookie.GetUID("adnxs"); uid != "123" || !isLive {
+		t.Errorf("Expected a live adnxs ID of 123. Got %s", uid)
+	}
+}
@@ -0,0 +1,114 @@
+package usersync
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prebid/prebid-server/config"
+)
+
+// UIDStore saves user syncs on the server, so that they survive if the browser truncates or drops the uids cookie.
+//
+// Data is keyed by the value of the host's own cookie (config.HostCookie.CookieName), which tends to outlive
+// third party cookies since it's set by the host's own domain. Implementations can be found in usersync/uidstores.
+type UIDStore interface {
+	// Load returns the data saved for the given host cookie ID.
+	// It should return nil data and a nil error if nothing was saved, or if the data has expired.
+	Load(ctx context.Context, id string) ([]byte, error)
+	// Save stores the data for the given host cookie ID. It may be discarded after the ttl has passed.
+	Save(ctx context.Context, id string, data []byte, ttl time.Duration) error
+}
+
+// UIDStoreClient saves and restores the user syncs kept in a UIDStore. Handlers which read or write the
+// uids cookie should be given one explicitly.
+//
+// A nil *UIDStoreClient does nothing, so it can be used when the host hasn't configured server-side UID storage.
+type UIDStoreClient struct {
+	store   UIDStore
+	timeout time.Duration
+}
+
+// NewUIDStoreClient makes a UIDStoreClient which aborts calls to the store after the timeout.
+// It returns nil if the store is nil.
+func NewUIDStoreClient(store UIDStore, timeout time.Duration) *UIDStoreClient {
+	if store == nil {
+		return nil
+	}
+	return &UIDStoreClient{
+		store:   store,
+		timeout: timeout,
+	}
+}
+
+// ParsePBSCookieFromRequest works like the package-level function. If the request has no usable uids cookie,
+// the syncs saved under its host cookie are used instead.
+func (c *UIDStoreClient) ParsePBSCookieFromRequest(r *http.Request, cfg *config.HostCookie) *PBSCookie {
+	return parsePBSCookieFromRequest(r, cfg, c)
+}
+
+// Save saves the cookie under the request's host cookie.
+// If the request doesn't have a host cookie then there's nothing to save it under, so this does nothing.
+func (c *UIDStoreClient) Save(r *http.Request, cfg *config.HostCookie, cookie *PBSCookie) {
+	id := hostCookieID(r, cfg)
+	if c == nil || id == "" {
+		return
+	}
+	data, err := json.Marshal(cookie)
+	if err != nil {
+		glog.Errorf("Failed to marshal user syncs for the UID store: %v", err)
+		return
+	}
+
+	ctx, cancel := c.context(r)
+	defer cancel()
+	if err := c.store.Save(ctx, id, data, cfg.TTLDuration()); err != nil {
+		glog.Errorf("Failed to save user syncs to the UID store: %v", err)
+	}
+}
+
+// load returns the syncs saved under the request's host cookie.
+// It returns nil if nothing usable was saved.
+func (c *UIDStoreClient) load(r *http.Request, cfg *config.HostCookie) *PBSCookie {
+	id := hostCookieID(r, cfg)
+	if c == nil || id == "" {
+		return nil
+	}
+
+	ctx, cancel := c.context(r)
+	defer cancel()
+	data, err := c.store.Load(ctx, id)
+	if err != nil {
+		glog.Errorf("Failed to load user syncs from the UID store: %v", err)
+		return nil
+	}
+	if data == nil {
+		return nil
+	}
+
+	stored := NewPBSCookie()
+	if err := json.Unmarshal(data, stored); err != nil {
+		glog.Errorf("The UID store returned corrupted user syncs: %v", err)
+		return nil
+	}
+	return stored
+}
+
+func (c *UIDStoreClient) context(r *http.Request) (context.Context, context.CancelFunc) {
+	if c.timeout > 0 {
+		return context.WithTimeout(r.Context(), c.timeout)
+	}
+	return context.WithCancel(r.Context())
+}
+
+func hostCookieID(r *http.Request, cfg *config.HostCookie) string {
+	if cfg.CookieName == "" {
+		return ""
+	}
+	if hostCookie, err := r.Cookie(cfg.CookieName); err == nil {
+		return hostCookie.Value
+	}
+	return ""
+}
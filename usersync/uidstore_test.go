@@ -0,0 +1,134 @@
+package usersync
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/prebid/prebid-server/config"
+)
+
+func TestUIDStoreRestoresMissingCookie(t *testing.T) {
+	store, client := newFakeUIDStore()
+	hostCookie := &config.HostCookie{CookieName: "host-id"}
+
+	saved := NewPBSCookie()
+	saved.TrySync("adnxs", "123")
+	client.Save(requestWithCookies(&http.Cookie{Name: "host-id", Value: "abc"}), hostCookie, saved)
+	if _, ok := store.data["abc"]; !ok {
+		t.Fatalf("The cookie should have been saved under the host cookie ID.")
+	}
+
+	parsed := client.ParsePBSCookieFromRequest(requestWithCookies(&http.Cookie{Name: "host-id", Value: "abc"}), hostCookie)
+	assertHasAdnxs(t, parsed)
+}
+
+func TestUIDStoreIgnoredWithLiveCookie(t *testing.T) {
+	store, client := newFakeUIDStore()
+	hostCookie := &config.HostCookie{CookieName: "host-id"}
+
+	saved := NewPBSCookie()
+	saved.TrySync("adnxs", "stored")
+	client.Save(requestWithCookies(&http.Cookie{Name: "host-id", Value: "abc"}), hostCookie, saved)
+
+	browser := NewPBSCookie()
+	browser.TrySync("adnxs", "123")
+	parsed := client.ParsePBSCookieFromRequest(requestWithCookies(&http.Cookie{Name: "host-id", Value: "abc"}, browser.ToHTTPCookie(time.Hour)), hostCookie)
+	assertHasAdnxs(t, parsed)
+	if store.loads != 0 {
+		t.Errorf("The UID store shouldn't be used if the uids cookie has live syncs. Got %d loads", store.loads)
+	}
+}
+
+func TestUIDStoreWithoutHostCookie(t *testing.T) {
+	store, client := newFakeUIDStore()
+	hostCookie := &config.HostCookie{CookieName: "host-id"}
+
+	saved := NewPBSCookie()
+	saved.TrySync("adnxs", "123")
+	client.Save(requestWithCookies(), hostCookie, saved)
+	if len(store.data) != 0 {
+		t.Errorf("Nothing should be saved if the request has no host cookie.")
+	}
+
+	parsed := client.ParsePBSCookieFromRequest(requestWithCookies(), hostCookie)
+	ensureEmptyMap(t, parsed)
+	if store.loads != 0 {
+		t.Errorf("The UID store shouldn't be used if the request has no host cookie. Got %d loads", store.loads)
+	}
+}
+
+func TestUIDStoreErrors(t *testing.T) {
+	store, client := newFakeUIDStore()
+	store.err = errors.New("store is down")
+	hostCookie := &config.HostCookie{CookieName: "host-id"}
+
+	parsed := client.ParsePBSCookieFromRequest(requestWithCookies(&http.Cookie{Name: "host-id", Value: "abc"}), hostCookie)
+	if parsed.HasLiveSync("adnxs") {
+		t.Errorf("No syncs should be restored if the UID store fails.")
+	}
+}
+
+func TestUIDStoreCorruptedData(t *testing.T) {
+	store, client := newFakeUIDStore()
+	store.data["abc"] = []byte("not json")
+	hostCookie := &config.HostCookie{CookieName: "host-id"}
+
+	parsed := client.ParsePBSCookieFromRequest(requestWithCookies(&http.Cookie{Name: "host-id", Value: "abc"}), hostCookie)
+	if parsed.HasLiveSync("adnxs") {
+		t.Errorf("No syncs should be restored if the UID store has corrupted data.")
+	}
+}
+
+func newFakeUIDStore() (*fakeUIDStore, *UIDStoreClient) {
+	store := &fakeUIDStore{data: make(map[string][]byte)}
+	return store, NewUIDStoreClient(store, time.Second)
+}
+
+func requestWithCookies(cookies ...*http.Cookie) *http.Request {
+	request := &http.Request{Header: http.Header{}}
+	for _, cookie := range cookies {
+		request.AddCookie(cookie)
+	}
+	return request
+}
+
+type fakeUIDStore struct {
+	data  map[string][]byte
+	err   error
+	loads int
+}
+
+func (s *fakeUIDStore) Load(ctx context.Context, id string) ([]byte, error) {
+	s.loads++
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.data[id], nil
+}
+
+func (s *fakeUIDStore) Save(ctx context.Context, id string, data []byte, ttl time.Duration) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.data[id] = data
+	return nil
+}
+
+func TestNilUIDStoreClient(t *testing.T) {
+	client := NewUIDStoreClient(nil, time.Second)
+	if client != nil {
+		t.Fatalf("There shouldn't be a client without a store.")
+	}
+	hostCookie := &config.HostCookie{CookieName: "host-id"}
+
+	saved := NewPBSCookie()
+	saved.TrySync("adnxs", "123")
+	client.Save(requestWithCookies(&http.Cookie{Name: "host-id", Value: "abc"}), hostCookie, saved)
+	parsed := client.ParsePBSCookieFromRequest(requestWithCookies(&http.Cookie{Name: "host-id", Value: "abc"}), hostCookie)
+	if parsed.HasLiveSync("adnxs") {
+		t.Errorf("No syncs should be restored without a UID store.")
+	}
+}
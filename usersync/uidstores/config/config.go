@@ -0,0 +1,50 @@
+package config
+
+import (
+	"database/sql"
+
+	"github.com/golang/glog"
+	"github.com/prebid/prebid-server/config"
+	"github.com/prebid/prebid-server/usersync"
+	"github.com/prebid/prebid-server/usersync/uidstores/memory"
+	"github.com/prebid/prebid-server/usersync/uidstores/postgres"
+)
+
+// NewUIDStore returns two things:
+//
+// 1. The UIDStore described by the config. This will be nil if uid_store.type is "none".
+// 2. A function which should be called on shutdown for graceful cleanups.
+//
+// If any errors occur, the program will exit with an error message.
+// It probably means you have a bad config or networking issue.
+func NewUIDStore(cfg *config.UIDStore) (store usersync.UIDStore, shutdown func()) {
+	switch cfg.Type {
+	case "memory":
+		glog.Infof("Saving user syncs in memory. Max size: %d bytes.", cfg.MemorySize)
+		return memory.NewStore(cfg.MemorySize), func() {}
+	case "postgres":
+		glog.Infof("Connecting to Postgres to save user syncs. DB=%s, host=%s, port=%d, user=%s, table=%s", cfg.Postgres.ConnectionInfo.Database, cfg.Postgres.ConnectionInfo.Host, cfg.Postgres.ConnectionInfo.Port, cfg.Postgres.ConnectionInfo.Username, cfg.Postgres.Table)
+		db := newPostgresDB(cfg.Postgres.ConnectionInfo)
+		return postgres.NewStore(db, cfg.Postgres.Table), func() {
+			if err := db.Close(); err != nil {
+				glog.Errorf("Error closing UID Store DB connection: %v", err)
+			}
+		}
+	default:
+		glog.Info("No UID Store configured. User syncs will only be saved in the uids cookie.")
+		return nil, func() {}
+	}
+}
+
+func newPostgresDB(cfg config.PostgresConnection) *sql.DB {
+	db, err := sql.Open("postgres", cfg.ConnString())
+	if err != nil {
+		glog.Fatalf("Failed to open postgres connection: %v", err)
+	}
+
+	if err := db.Ping(); err != nil {
+		glog.Fatalf("Failed to ping postgres: %v", err)
+	}
+
+	return db
+}
@@ -0,0 +1,35 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/coocood/freecache"
+	"github.com/prebid/prebid-server/usersync"
+)
+
+// NewStore returns a UIDStore which keeps syncs in memory. It evicts entries after their TTL,
+// or when more than sizeBytes are in use. The least recently used entries are evicted first.
+//
+// Syncs stored here are lost whenever PBS restarts, and aren't shared between PBS instances.
+func NewStore(sizeBytes int) usersync.UIDStore {
+	return &store{
+		cache: freecache.NewCache(sizeBytes),
+	}
+}
+
+type store struct {
+	cache *freecache.Cache
+}
+
+func (s *store) Load(ctx context.Context, id string) ([]byte, error) {
+	data, err := s.cache.Get([]byte(id))
+	if err == freecache.ErrNotFound {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (s *store) Save(ctx context.Context, id string, data []byte, ttl time.Duration) error {
+	return s.cache.Set([]byte(id), data, int(ttl/time.Second))
+}
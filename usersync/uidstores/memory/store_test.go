@@ -0,0 +1,42 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSaveThenLoad(t *testing.T) {
+	store := NewStore(512 * 1024)
+	if err := store.Save(context.Background(), "host-id", []byte(`{"optout":true}`), time.Hour); err != nil {
+		t.Fatalf("Unexpected error saving data: %v", err)
+	}
+	data, err := store.Load(context.Background(), "host-id")
+	if err != nil {
+		t.Fatalf("Unexpected error loading data: %v", err)
+	}
+	if string(data) != `{"optout":true}` {
+		t.Errorf("Bad data loaded. Expected %s, got %s", `{"optout":true}`, string(data))
+	}
+}
+
+func TestLoadMissing(t *testing.T) {
+	store := NewStore(512 * 1024)
+	data, err := store.Load(context.Background(), "unknown-id")
+	if err != nil {
+		t.Errorf("Missing IDs should not return errors. Got %v", err)
+	}
+	if data != nil {
+		t.Errorf("Missing IDs should return nil data. Got %s", string(data))
+	}
+}
+
+func TestOverwrite(t *testing.T) {
+	store := NewStore(512 * 1024)
+	store.Save(context.Background(), "host-id", []byte(`{"first":true}`), time.Hour)
+	store.Save(context.Background(), "host-id", []byte(`{"second":true}`), time.Hour)
+	data, _ := store.Load(context.Background(), "host-id")
+	if string(data) != `{"second":true}` {
+		t.Errorf("Later saves should overwrite earlier ones. Got %s", string(data))
+	}
+}
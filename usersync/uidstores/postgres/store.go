@@ -0,0 +1,46 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prebid/prebid-server/usersync"
+)
+
+// NewStore returns a UIDStore which saves syncs to the given Postgres table.
+// See config.UIDStorePostgres for the expected schema.
+//
+// The table name is inserted into the queries directly, so it must come from a trusted source.
+func NewStore(db *sql.DB, table string) usersync.UIDStore {
+	if db == nil {
+		glog.Fatalf("The Postgres UID Store requires a database connection. Please report this as a bug.")
+	}
+	return &store{
+		db:        db,
+		loadQuery: fmt.Sprintf("SELECT data FROM %s WHERE id = $1 AND expires > $2", table),
+		saveQuery: fmt.Sprintf("INSERT INTO %s (id, data, expires) VALUES ($1, $2, $3) ON CONFLICT (id) DO UPDATE SET data = EXCLUDED.data, expires = EXCLUDED.expires", table),
+	}
+}
+
+type store struct {
+	db        *sql.DB
+	loadQuery string
+	saveQuery string
+}
+
+func (s *store) Load(ctx context.Context, id string) ([]byte, error) {
+	var data []byte
+	err := s.db.QueryRowContext(ctx, s.loadQuery, id, time.Now()).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (s *store) Save(ctx context.Context, id string, data []byte, ttl time.Duration) error {
+	_, err := s.db.ExecContext(ctx, s.saveQuery, id, string(data), time.Now().Add(ttl))
+	return err
+}
@@ -0,0 +1,94 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestLoad(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Unexpected error stubbing DB: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT data FROM uids WHERE id = $1 AND expires > $2")).
+		WithArgs("host-id", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"data"}).AddRow(`{"optout":true}`))
+
+	data, err := NewStore(db, "uids").Load(context.Background(), "host-id")
+	assertExpectationsMet(t, mock)
+	if err != nil {
+		t.Errorf("Unexpected error loading data: %v", err)
+	}
+	if string(data) != `{"optout":true}` {
+		t.Errorf("Bad data loaded. Expected %s, got %s", `{"optout":true}`, string(data))
+	}
+}
+
+func TestLoadMissing(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Unexpected error stubbing DB: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT data FROM uids WHERE id = $1 AND expires > $2")).
+		WithArgs("host-id", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"data"}))
+
+	data, err := NewStore(db, "uids").Load(context.Background(), "host-id")
+	assertExpectationsMet(t, mock)
+	if err != nil {
+		t.Errorf("Missing IDs should not return errors. Got %v", err)
+	}
+	if data != nil {
+		t.Errorf("Missing IDs should return nil data. Got %s", string(data))
+	}
+}
+
+func TestLoadError(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Unexpected error stubbing DB: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT data FROM uids WHERE id = $1 AND expires > $2")).
+		WithArgs("host-id", sqlmock.AnyArg()).
+		WillReturnError(errors.New("connection refused"))
+
+	if _, err := NewStore(db, "uids").Load(context.Background(), "host-id"); err == nil {
+		t.Error("DB errors should be returned.")
+	}
+	assertExpectationsMet(t, mock)
+}
+
+func TestSave(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Unexpected error stubbing DB: %v", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO uids (id, data, expires) VALUES ($1, $2, $3) ON CONFLICT (id) DO UPDATE SET data = EXCLUDED.data, expires = EXCLUDED.expires")).
+		WithArgs("host-id", `{"optout":true}`, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := NewStore(db, "uids").Save(context.Background(), "host-id", []byte(`{"optout":true}`), time.Hour); err != nil {
+		t.Errorf("Unexpected error saving data: %v", err)
+	}
+	assertExpectationsMet(t, mock)
+}
+
+func assertExpectationsMet(t *testing.T, mock sqlmock.Sqlmock) {
+	t.Helper()
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Mock expectations not met: %v", err)
+	}
+}